@@ -2,19 +2,24 @@ package apiservice
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
 	"sync"
 	"time"
 
+	"github.com/wurt83ow/timetracker/internal/events"
+	"github.com/wurt83ow/timetracker/internal/jobs"
 	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
 	"github.com/wurt83ow/timetracker/internal/workerpool"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
 type External interface {
-	GetUserInfo(int, int) (models.ExtUserData, error)
+	GetUserInfo(context.Context, int, int) (models.ExtUserData, error)
 }
 
 type Log interface {
@@ -22,29 +27,88 @@ type Log interface {
 }
 
 type Storage interface {
-	GetNonUpdateUsers(context.Context) ([]models.ExtUserData, error)
-	UpdateUsersInfo(context.Context, []models.ExtUserData) error
+	ClaimNonUpdateUsers(ctx context.Context, limit int, lease time.Duration) ([]models.ExtUserData, error)
+	ClaimUser(ctx context.Context, userID int, lease time.Duration) (models.ExtUserData, error)
+	UpdateUsersInfo(context.Context, []models.ExtUserData) ([]int, error)
+	GetEnrichmentAttempt(context.Context, int) (models.EnrichmentAttempt, error)
+	SaveEnrichmentAttempts(context.Context, []models.EnrichmentAttempt) error
+}
+
+// Options configure the enrichment batches and how failed enrichments are retried
+// before they are dead-lettered
+type Options interface {
+	EnrichmentMaxAttempts() string
+	EnrichmentRetryDelay() string
+	EnrichmentRetryMaxDelay() string
+	EnrichmentBatchSize() string
+	EnrichmentLease() string
+}
+
+// TaskKind is the kind of the worker pool tasks that enrich users, e.g. to limit the
+// rate of requests to the external API system
+const TaskKind = "enrichment"
+
+const (
+	defaultMaxAttempts   = 5
+	defaultRetryDelay    = time.Minute
+	defaultRetryMaxDelay = time.Hour
+	defaultBatchSize     = 100
+	defaultLease         = 5 * time.Minute
+
+	// maxErrorLength bounds the error stored with an enrichment attempt
+	maxErrorLength = 500
+)
+
+// Bus triggers the enrichment of new passports and receives the enrichment results
+type Bus interface {
+	Publish(events.Event)
+	Subscribe(events.Handler, ...string) func()
 }
 
 type Pool interface {
-	// NewTask(f func(interface{}) error, data interface{}) *workerpool.Task
-	AddTask(task *workerpool.Task)
+	TrySubmit(task *workerpool.Task) error
+}
+
+// Jobs keeps the enrichments triggered by the events across restarts
+type Jobs interface {
+	Handle(kind string, handler jobs.Handler)
+	Enqueue(ctx context.Context, kind, key string, payload any, runAt time.Time) (int64, error)
+}
+
+// enrichmentJob is the payload of the enrichment jobs
+type enrichmentJob struct {
+	UserID int `json:"user_id"`
 }
 
 type ApiService struct {
 	ctx          context.Context
 	results      chan interface{}
 	wg           sync.WaitGroup
+	stopped      chan struct{}
 	cancelFunc   context.CancelFunc
 	external     External
 	pool         Pool
 	storage      Storage
+	bus          Bus
+	jobs         Jobs
+	unsubscribe  func()
 	log          Log
 	taskInterval int
+
+	maxAttempts   int
+	retryDelay    time.Duration
+	retryMaxDelay time.Duration
+	batchSize     int
+	lease         time.Duration
+	now           func() time.Time
+
+	// inflight holds the users being enriched by the instance until their lease expires
+	imx      sync.Mutex
+	inflight map[int]time.Time
 }
 
-func NewApiService(ctx context.Context, external External, pool Pool, storage Storage,
-	log Log, taskInterval func() string,
+func NewApiService(ctx context.Context, external External, pool Pool, storage Storage, bus Bus,
+	jobs Jobs, log Log, taskInterval func() string, option Options,
 ) *ApiService {
 	taskInt, err := strconv.Atoi(taskInterval())
 	if err != nil {
@@ -53,30 +117,127 @@ func NewApiService(ctx context.Context, external External, pool Pool, storage St
 		taskInt = 3000
 	}
 
+	maxAttempts, err := strconv.Atoi(option.EnrichmentMaxAttempts())
+	if err != nil || maxAttempts < 1 {
+		log.Info("invalid ENRICHMENT_MAX_ATTEMPTS, using the default: ", zap.Int("default", defaultMaxAttempts))
+		maxAttempts = defaultMaxAttempts
+	}
+
+	retryDelay, err := time.ParseDuration(option.EnrichmentRetryDelay())
+	if err != nil || retryDelay <= 0 {
+		log.Info("invalid ENRICHMENT_RETRY_DELAY, using the default: ", zap.Duration("default", defaultRetryDelay))
+		retryDelay = defaultRetryDelay
+	}
+
+	retryMaxDelay, err := time.ParseDuration(option.EnrichmentRetryMaxDelay())
+	if err != nil || retryMaxDelay < retryDelay {
+		log.Info("invalid ENRICHMENT_RETRY_MAX_DELAY, using the default: ", zap.Duration("default", defaultRetryMaxDelay))
+		retryMaxDelay = max(defaultRetryMaxDelay, retryDelay)
+	}
+
+	batchSize, err := strconv.Atoi(option.EnrichmentBatchSize())
+	if err != nil || batchSize < 1 {
+		log.Info("invalid ENRICHMENT_BATCH_SIZE, using the default: ", zap.Int("default", defaultBatchSize))
+		batchSize = defaultBatchSize
+	}
+
+	lease, err := time.ParseDuration(option.EnrichmentLease())
+	if err != nil || lease <= 0 {
+		log.Info("invalid ENRICHMENT_LEASE, using the default: ", zap.Duration("default", defaultLease))
+		lease = defaultLease
+	}
+
 	return &ApiService{
 		ctx:          ctx,
 		results:      make(chan interface{}),
 		wg:           sync.WaitGroup{},
+		stopped:      make(chan struct{}),
 		cancelFunc:   nil,
 		external:     external,
 		pool:         pool,
 		storage:      storage,
+		bus:          bus,
+		jobs:         jobs,
 		log:          log,
 		taskInterval: taskInt,
+
+		maxAttempts:   maxAttempts,
+		retryDelay:    retryDelay,
+		retryMaxDelay: retryMaxDelay,
+		batchSize:     batchSize,
+		lease:         lease,
+		now:           time.Now,
+		inflight:      make(map[int]time.Time),
 	}
 }
 
+// Start enriches the users as soon as they register or change their passport, and
+// sweeps the users whose data is due for a refresh
 func (a *ApiService) Start() {
-	a.ctx, a.cancelFunc = context.WithCancel(a.ctx)
+	var ctx context.Context
+	ctx, a.cancelFunc = context.WithCancel(a.ctx)
+	a.jobs.Handle(TaskKind, a.enrich)
+	a.unsubscribe = a.bus.Subscribe(a.enrichNow, events.UserRegistered, events.UserPassportChanged)
 	a.wg.Add(1)
-	go a.UpdateUsers(a.ctx)
+	go a.UpdateUsers(ctx)
 }
 
+// Stop stops claiming users and saves the collected results. The results of the
+// tasks still in the pool are saved as they come.
 func (a *ApiService) Stop() {
+	a.unsubscribe()
 	a.cancelFunc()
 	a.wg.Wait()
 }
 
+// enrichNow queues a job enriching the user of the event, unless one is queued already
+func (a *ApiService) enrichNow(event events.Event) {
+	key := fmt.Sprintf("%s:%d", TaskKind, event.ID)
+	_, err := a.jobs.Enqueue(a.ctx, TaskKind, key, enrichmentJob{UserID: event.ID}, time.Time{})
+	if err != nil && !errors.Is(err, storage.ErrConflict) {
+		a.log.Info("failed to queue user enrichment: ", zap.Error(err))
+	}
+}
+
+// enrich runs an enrichment job. The failures of the API system are recorded as
+// enrichment attempts and retried by the sweep, only the storage errors retry the job.
+func (a *ApiService) enrich(ctx context.Context, job models.Job) error {
+	var payload enrichmentJob
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return fmt.Errorf("invalid enrichment job payload: %w", err)
+	}
+
+	user, err := a.storage.ClaimUser(ctx, payload.UserID, a.lease)
+	if err != nil {
+		if errors.Is(err, storage.ErrConflict) || errors.Is(err, storage.ErrNotFound) {
+			// Another pass is enriching the user, or it is gone
+			return nil
+		}
+		return err
+	}
+
+	if !a.acquire(user.UserID) {
+		return nil
+	}
+
+	info, err := a.external.GetUserInfo(ctx, user.PassportSerie, user.PassportNumber)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			// The job is run again, and the sweep takes over once the lease expires
+			a.release(user.UserID)
+			return err
+		}
+		a.recordFailure(user.UserID, err)
+		return nil
+	}
+
+	info.UserID = user.UserID
+	a.log.Info("processed enrichment job: ", zap.Int("userID", user.UserID))
+	a.doWork([]models.ExtUserData{info})
+
+	return nil
+}
+
 func (a *ApiService) UpdateUsers(ctx context.Context) {
 	t := time.NewTicker(time.Duration(a.taskInterval) * time.Millisecond)
 
@@ -87,37 +248,58 @@ func (a *ApiService) UpdateUsers(ctx context.Context) {
 	dmx.RLock()
 	defer dmx.RUnlock()
 
+	defer a.wg.Done()
+	defer close(a.stopped)
+
 	for {
 		select {
 		case <-ctx.Done():
+			if len(result) != 0 {
+				a.doWork(result)
+			}
 			return
 		case job := <-a.results:
 			j, ok := job.(models.ExtUserData)
 			if ok {
 				result = append(result, j)
 			}
+
+			if len(result) >= a.batchSize {
+				a.doWork(result)
+				result = nil
+			}
 		case <-t.C:
+			// Flush first, so that the saved users are not claimed again
+			if len(result) != 0 {
+				a.doWork(result)
+				result = nil
+			}
 
-			users, err := a.storage.GetNonUpdateUsers(ctx)
+			limit := a.batchSize - a.inflightCount()
+			if limit <= 0 {
+				continue
+			}
 
+			users, err := a.storage.ClaimNonUpdateUsers(ctx, limit, a.lease)
 			if err != nil {
-
-				return
+				a.log.Info("failed to claim users for enrichment: ", zap.Error(err))
+				continue
 			}
 
 			a.CreateUsersTask(users)
-
-			if len(result) != 0 {
-				a.doWork(result)
-				result = nil
-			}
 		}
 	}
 }
 
-// AddResults adds result to pool.
+// AddResults passes the result to the batch update, or saves it right away once the service is stopped
 func (a *ApiService) AddResults(result interface{}) {
-	a.results <- result
+	select {
+	case a.results <- result:
+	case <-a.stopped:
+		if user, ok := result.(models.ExtUserData); ok {
+			a.doWork([]models.ExtUserData{user})
+		}
+	}
 }
 
 func (a *ApiService) GetResults() <-chan interface{} {
@@ -125,35 +307,178 @@ func (a *ApiService) GetResults() <-chan interface{} {
 	return a.results
 }
 
+// CreateUsersTask enqueues the enrichment of the users that are not in flight already
 func (a *ApiService) CreateUsersTask(users []models.ExtUserData) {
-	var task *workerpool.Task
-
 	for _, user := range users {
+		if !a.acquire(user.UserID) {
+			continue
+		}
 
-		task = workerpool.NewTask(func(data interface{}) error {
-
-			usr, ok := data.(models.ExtUserData)
-			if ok { // type assertion failed
-				usrinfo, err := a.external.GetUserInfo(usr.PassportSerie, usr.PassportNumber)
-
-				if err != nil {
-					return fmt.Errorf("failed to create order task: %w", err)
-				}
-				a.log.Info("processed task: ", zap.String("usefinfo", fmt.Sprintf("%d%d", usr.PassportSerie, usr.PassportNumber)))
-				a.AddResults(usrinfo)
+		task := workerpool.NewTask(TaskKind, func(ctx context.Context) (any, error) {
+			return a.external.GetUserInfo(ctx, user.PassportSerie, user.PassportNumber)
+		}).OnDone(func(result any, err error) {
+			if errors.Is(err, context.Canceled) {
+				// The pool is shut down: the lease expires and another pass enriches the user
+				a.release(user.UserID)
+				return
+			}
+			if err != nil {
+				a.recordFailure(user.UserID, err)
+				return
 			}
 
-			return nil
-		}, user)
-		a.pool.AddTask(task)
+			info := result.(models.ExtUserData)
+			info.UserID = user.UserID
+			a.log.Info("processed task: ", zap.Int("userID", user.UserID))
+			a.AddResults(info)
+		})
+
+		if err := a.pool.TrySubmit(task); err != nil {
+			a.log.Info("failed to enqueue user enrichment, leaving it to a later pass: ", zap.Error(err))
+			a.release(user.UserID)
+		}
 	}
 }
 
 func (a *ApiService) doWork(result []models.ExtUserData) {
+	defer func() {
+		for _, user := range result {
+			a.release(user.UserID)
+		}
+	}()
+
 	// perform a group update of the users table (field Surname, Name, Address)
-	err := a.storage.UpdateUsersInfo(a.ctx, result)
+	ids, err := a.storage.UpdateUsersInfo(a.ctx, result)
 	if err != nil {
 		a.log.Info("errors when updating order status: ", zap.Error(err))
+		return
+	}
+
+	// The storage skips users whose passport has changed or who were checked meanwhile
+	updated := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		updated[id] = true
+	}
+	enriched := make([]models.ExtUserData, 0, len(ids))
+	for _, user := range result {
+		if updated[user.UserID] {
+			enriched = append(enriched, user)
+		} else {
+			a.log.Info("user enrichment discarded by the storage: ", zap.Int("userID", user.UserID))
+		}
+	}
+	if len(enriched) == 0 {
+		return
+	}
+
+	now := a.now()
+	attempts := make([]models.EnrichmentAttempt, 0, len(enriched))
+	for _, user := range enriched {
+		attempts = append(attempts, models.EnrichmentAttempt{UserID: user.UserID, Status: models.EnrichmentSucceeded, UpdatedAt: now})
 	}
 
+	if err := a.storage.SaveEnrichmentAttempts(a.ctx, attempts); err != nil {
+		a.log.Info("failed to save enrichment attempts: ", zap.Error(err))
+	}
+
+	for _, user := range enriched {
+		// The listeners don't need the passport
+		user.PassportSerie, user.PassportNumber = 0, 0
+		a.bus.Publish(events.Event{Type: events.UserEnriched, ID: user.UserID, Time: now, Data: user})
+	}
+}
+
+// recordFailure counts a failed enrichment of the user and schedules its retry with
+// an exponential backoff, or moves it to the dead letters after the last attempt
+func (a *ApiService) recordFailure(userID int, cause error) {
+	defer a.release(userID)
+
+	previous, err := a.storage.GetEnrichmentAttempt(a.ctx, userID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		a.log.Info("failed to read enrichment attempt: ", zap.Error(err))
+		return
+	}
+
+	now := a.now()
+	attempt := models.EnrichmentAttempt{
+		UserID:    userID,
+		Status:    models.EnrichmentFailed,
+		Attempts:  previous.Attempts + 1,
+		LastError: truncateError(cause.Error()),
+		UpdatedAt: now,
+	}
+
+	if attempt.Attempts >= a.maxAttempts {
+		attempt.Status = models.EnrichmentDead
+		a.log.Info("user enrichment moved to dead letters: ", zap.Int("userID", userID), zap.Int("attempts", attempt.Attempts))
+	} else {
+		next := now.Add(a.retryBackoff(attempt.Attempts))
+		attempt.NextRetryAt = &next
+	}
+
+	if err := a.storage.SaveEnrichmentAttempts(a.ctx, []models.EnrichmentAttempt{attempt}); err != nil {
+		a.log.Info("failed to save enrichment attempt: ", zap.Error(err))
+	}
+
+	a.bus.Publish(events.Event{Type: events.UserEnrichmentFailed, ID: userID, Time: now, Data: attempt})
+}
+
+// acquire marks the user as in flight unless it already is. An entry whose lease
+// has expired is stale: the task was lost, and the user may be claimed again.
+func (a *ApiService) acquire(userID int) bool {
+	a.imx.Lock()
+	defer a.imx.Unlock()
+
+	now := a.now()
+	if until, ok := a.inflight[userID]; ok && until.After(now) {
+		return false
+	}
+
+	a.inflight[userID] = now.Add(a.lease)
+	return true
+}
+
+func (a *ApiService) release(userID int) {
+	a.imx.Lock()
+	defer a.imx.Unlock()
+
+	delete(a.inflight, userID)
+}
+
+// inflightCount drops the stale entries and returns the number of users in flight
+func (a *ApiService) inflightCount() int {
+	a.imx.Lock()
+	defer a.imx.Unlock()
+
+	now := a.now()
+	for id, until := range a.inflight {
+		if !until.After(now) {
+			delete(a.inflight, id)
+		}
+	}
+
+	return len(a.inflight)
+}
+
+// retryBackoff doubles the retry delay with every failed attempt, up to the maximum
+func (a *ApiService) retryBackoff(attempts int) time.Duration {
+	delay := a.retryDelay
+	for i := 1; i < attempts && delay < a.retryMaxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, a.retryMaxDelay)
+}
+
+func truncateError(s string) string {
+	if len(s) <= maxErrorLength {
+		return s
+	}
+
+	// Cut at a rune boundary
+	cut := maxErrorLength
+	for cut > 0 && s[cut]&0xC0 == 0x80 {
+		cut--
+	}
+	return s[:cut] + "..."
 }
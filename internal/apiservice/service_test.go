@@ -0,0 +1,257 @@
+package apiservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wurt83ow/timetracker/internal/events"
+	"github.com/wurt83ow/timetracker/internal/jobs"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"github.com/wurt83ow/timetracker/internal/workerpool"
+	"go.uber.org/zap"
+)
+
+type options struct{}
+
+func (options) EnrichmentMaxAttempts() string   { return "3" }
+func (options) EnrichmentRetryDelay() string    { return "1m" }
+func (options) EnrichmentRetryMaxDelay() string { return "3m" }
+func (options) EnrichmentBatchSize() string     { return "2" }
+func (options) EnrichmentLease() string         { return "5m" }
+
+type fakeStorage struct {
+	attempts map[int]models.EnrichmentAttempt
+	updated  []models.ExtUserData
+	leased   map[int]bool
+	// checked are the users checked within the update interval, which are not updated again
+	checked map[int]bool
+}
+
+func (s *fakeStorage) ClaimNonUpdateUsers(context.Context, int, time.Duration) ([]models.ExtUserData, error) {
+	return nil, nil
+}
+
+func (s *fakeStorage) ClaimUser(ctx context.Context, userID int, lease time.Duration) (models.ExtUserData, error) {
+	if s.leased[userID] {
+		return models.ExtUserData{}, storage.ErrConflict
+	}
+	s.leased[userID] = true
+	return models.ExtUserData{UserID: userID, PassportSerie: 1234, PassportNumber: 567890}, nil
+}
+
+func (s *fakeStorage) UpdateUsersInfo(ctx context.Context, users []models.ExtUserData) ([]int, error) {
+	var ids []int
+	for _, user := range users {
+		if s.checked[user.UserID] {
+			continue
+		}
+		s.updated = append(s.updated, user)
+		ids = append(ids, user.UserID)
+	}
+	return ids, nil
+}
+
+func (s *fakeStorage) GetEnrichmentAttempt(ctx context.Context, userID int) (models.EnrichmentAttempt, error) {
+	a, ok := s.attempts[userID]
+	if !ok {
+		return models.EnrichmentAttempt{}, storage.ErrNotFound
+	}
+	return a, nil
+}
+
+func (s *fakeStorage) SaveEnrichmentAttempts(ctx context.Context, attempts []models.EnrichmentAttempt) error {
+	for _, a := range attempts {
+		s.attempts[a.UserID] = a
+	}
+	return nil
+}
+
+func TestApiService_RecordsAttempts(t *testing.T) {
+	store := &fakeStorage{attempts: make(map[int]models.EnrichmentAttempt), leased: make(map[int]bool)}
+	a := NewApiService(context.Background(), nil, nil, store, &fakeBus{}, newFakeJobs(), zap.NewNop(), func() string { return "3000" }, options{})
+
+	now := time.Date(2024, 7, 1, 9, 0, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+
+	// Retries back off exponentially up to the maximum delay
+	for _, delay := range []time.Duration{time.Minute, 2 * time.Minute} {
+		a.recordFailure(7, errors.New("status code error: 502 Bad Gateway"))
+
+		attempt := store.attempts[7]
+		assert.Equal(t, models.EnrichmentFailed, attempt.Status)
+		require.NotNil(t, attempt.NextRetryAt)
+		assert.Equal(t, now.Add(delay), *attempt.NextRetryAt)
+	}
+	assert.Equal(t, 3*time.Minute, a.retryBackoff(5))
+
+	// The last attempt moves the user to the dead letters
+	a.recordFailure(7, errors.New("user data not found"))
+	attempt := store.attempts[7]
+	assert.Equal(t, models.EnrichmentDead, attempt.Status)
+	assert.Equal(t, 3, attempt.Attempts)
+	assert.Equal(t, "user data not found", attempt.LastError)
+	assert.Nil(t, attempt.NextRetryAt)
+
+	// A success resets the count
+	a.doWork([]models.ExtUserData{{UserID: 7, Surname: "Ivanov"}})
+	assert.Equal(t, models.EnrichmentAttempt{UserID: 7, Status: models.EnrichmentSucceeded, UpdatedAt: now}, store.attempts[7])
+	assert.Len(t, store.updated, 1)
+}
+
+func TestApiService_SkipsDiscardedResults(t *testing.T) {
+	store := &fakeStorage{attempts: make(map[int]models.EnrichmentAttempt), leased: make(map[int]bool), checked: map[int]bool{8: true}}
+	bus := &fakeBus{}
+	a := NewApiService(context.Background(), nil, nil, store, bus, newFakeJobs(), zap.NewNop(), func() string { return "3000" }, options{})
+
+	// The storage discards the result of a user checked meanwhile
+	a.doWork([]models.ExtUserData{{UserID: 7, Surname: "Ivanov"}, {UserID: 8, Surname: "Petrov"}})
+
+	assert.Equal(t, []models.ExtUserData{{UserID: 7, Surname: "Ivanov"}}, store.updated)
+	assert.Equal(t, models.EnrichmentSucceeded, store.attempts[7].Status)
+	assert.NotContains(t, store.attempts, 8)
+	require.Len(t, bus.published, 1)
+	assert.Equal(t, 7, bus.published[0].ID)
+	assert.Equal(t, 0, a.inflightCount())
+}
+
+type fakeBus struct {
+	published []events.Event
+}
+
+func (b *fakeBus) Publish(e events.Event) {
+	b.published = append(b.published, e)
+}
+
+func (b *fakeBus) Subscribe(events.Handler, ...string) func() {
+	return func() {}
+}
+
+type fakePool struct {
+	tasks []*workerpool.Task
+	err   error
+}
+
+func (p *fakePool) TrySubmit(task *workerpool.Task) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.tasks = append(p.tasks, task)
+	return nil
+}
+
+func TestApiService_DeduplicatesInFlightUsers(t *testing.T) {
+	store := &fakeStorage{attempts: make(map[int]models.EnrichmentAttempt), leased: make(map[int]bool)}
+	pool := &fakePool{}
+	a := NewApiService(context.Background(), nil, pool, store, &fakeBus{}, newFakeJobs(), zap.NewNop(), func() string { return "3000" }, options{})
+
+	now := time.Date(2024, 7, 1, 9, 0, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+
+	// A user still being enriched is not enqueued again
+	a.CreateUsersTask([]models.ExtUserData{{UserID: 1}, {UserID: 2}})
+	a.CreateUsersTask([]models.ExtUserData{{UserID: 1}, {UserID: 2}})
+	assert.Len(t, pool.tasks, 2)
+	assert.Equal(t, 2, a.inflightCount())
+
+	// Saving the result or the failure lets the user be claimed again
+	a.doWork([]models.ExtUserData{{UserID: 1}})
+	a.recordFailure(2, errors.New("status code error: 502 Bad Gateway"))
+	assert.Equal(t, 0, a.inflightCount())
+
+	a.CreateUsersTask([]models.ExtUserData{{UserID: 1}})
+	assert.Len(t, pool.tasks, 3)
+
+	// A user rejected by a full queue is left to a later pass
+	pool.err = workerpool.ErrQueueFull
+	a.CreateUsersTask([]models.ExtUserData{{UserID: 2}})
+	assert.Equal(t, 1, a.inflightCount())
+	pool.err = nil
+
+	// A lost task doesn't hold the user after the lease expires
+	now = now.Add(5 * time.Minute)
+	assert.Equal(t, 0, a.inflightCount())
+	a.CreateUsersTask([]models.ExtUserData{{UserID: 1}})
+	assert.Len(t, pool.tasks, 4)
+}
+
+type fakeJobs struct {
+	handlers map[string]jobs.Handler
+	queued   map[string]models.Job
+}
+
+func newFakeJobs() *fakeJobs {
+	return &fakeJobs{handlers: make(map[string]jobs.Handler), queued: make(map[string]models.Job)}
+}
+
+func (j *fakeJobs) Handle(kind string, handler jobs.Handler) {
+	j.handlers[kind] = handler
+}
+
+func (j *fakeJobs) Enqueue(ctx context.Context, kind, key string, payload any, runAt time.Time) (int64, error) {
+	if _, ok := j.queued[key]; ok {
+		return 0, storage.ErrConflict
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	j.queued[key] = models.Job{ID: int64(len(j.queued) + 1), Kind: kind, Key: key, Payload: raw}
+	return int64(len(j.queued)), nil
+}
+
+type fakeExternal struct {
+	err error
+}
+
+func (e *fakeExternal) GetUserInfo(context.Context, int, int) (models.ExtUserData, error) {
+	if e.err != nil {
+		return models.ExtUserData{}, e.err
+	}
+	return models.ExtUserData{Surname: "Ivanov"}, nil
+}
+
+func TestApiService_EnrichesOnEvents(t *testing.T) {
+	store := &fakeStorage{attempts: make(map[int]models.EnrichmentAttempt), leased: make(map[int]bool)}
+	external := &fakeExternal{}
+	bus := &fakeBus{}
+	queue := newFakeJobs()
+	a := NewApiService(context.Background(), external, &fakePool{}, store, bus, queue, zap.NewNop(), func() string { return "3000" }, options{})
+	a.Start()
+	defer a.Stop()
+
+	// A registered user gets a durable job, once
+	a.enrichNow(events.Event{Type: events.UserRegistered, ID: 3})
+	a.enrichNow(events.Event{Type: events.UserPassportChanged, ID: 3})
+	a.enrichNow(events.Event{Type: events.UserRegistered, ID: 4})
+	require.Len(t, queue.queued, 2)
+	job := queue.queued["enrichment:3"]
+	assert.JSONEq(t, `{"user_id":3}`, string(job.Payload))
+
+	// The job enriches the user and publishes the result without the passport
+	handler := queue.handlers[TaskKind]
+	require.NotNil(t, handler)
+	require.NoError(t, handler(context.Background(), job))
+	assert.True(t, store.leased[3])
+	assert.Equal(t, []models.ExtUserData{{UserID: 3, Surname: "Ivanov"}}, store.updated)
+
+	// A user leased by another pass is left to it
+	require.NoError(t, handler(context.Background(), job))
+	assert.Len(t, store.updated, 1)
+
+	// The failures of the API system are recorded, not retried by the job
+	external.err = errors.New("status code error: 502 Bad Gateway")
+	require.NoError(t, handler(context.Background(), queue.queued["enrichment:4"]))
+	assert.Equal(t, models.EnrichmentFailed, store.attempts[4].Status)
+
+	require.Len(t, bus.published, 2)
+	assert.Equal(t, events.UserEnriched, bus.published[0].Type)
+	assert.Equal(t, models.ExtUserData{UserID: 3, Surname: "Ivanov"}, bus.published[0].Data)
+	assert.Equal(t, events.UserEnrichmentFailed, bus.published[1].Type)
+	assert.Equal(t, 4, bus.published[1].ID)
+}
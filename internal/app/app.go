@@ -5,26 +5,44 @@ import (
 	"errors"
 	"log"
 	"net/http"
-	"os"
-	"os/signal"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi"
+	chimiddleware "github.com/go-chi/chi/middleware"
 	_ "github.com/wurt83ow/timetracker/docs" // connecting generated Swagger files
 	"github.com/wurt83ow/timetracker/internal/apiservice"
 	authz "github.com/wurt83ow/timetracker/internal/authorization"
 	"github.com/wurt83ow/timetracker/internal/bdkeeper"
 	"github.com/wurt83ow/timetracker/internal/config"
 	"github.com/wurt83ow/timetracker/internal/controllers"
+	"github.com/wurt83ow/timetracker/internal/encryption"
+	"github.com/wurt83ow/timetracker/internal/enrichment"
+	"github.com/wurt83ow/timetracker/internal/events"
+	"github.com/wurt83ow/timetracker/internal/httpclient"
+	"github.com/wurt83ow/timetracker/internal/jobs"
 	"github.com/wurt83ow/timetracker/internal/logger"
+	"github.com/wurt83ow/timetracker/internal/mailer"
+	"github.com/wurt83ow/timetracker/internal/memkeeper"
 	"github.com/wurt83ow/timetracker/internal/middleware"
+	"github.com/wurt83ow/timetracker/internal/oidc"
+	"github.com/wurt83ow/timetracker/internal/reports"
+	"github.com/wurt83ow/timetracker/internal/sqlitekeeper"
 	"github.com/wurt83ow/timetracker/internal/storage"
+	"github.com/wurt83ow/timetracker/internal/stream"
+	"github.com/wurt83ow/timetracker/internal/twofactor"
+	"github.com/wurt83ow/timetracker/internal/webhooks"
 	"github.com/wurt83ow/timetracker/internal/workerpool"
 )
 
 type Server struct {
-	srv *http.Server
-	ctx context.Context
+	srv        *http.Server
+	ctx        context.Context
+	pool       *workerpool.Pool
+	jobs       *jobs.Runner
+	apiService *apiservice.ApiService
+	webhooks   *webhooks.Service
 }
 
 // NewServer creates a new Server instance with the provided context
@@ -46,88 +64,203 @@ func (server *Server) Serve() {
 		log.Fatalln(err)
 	}
 
-	// initialize the keeper instance
-	keeper := initializeKeeper(option.DataBaseDSN, nLogger, option.UserUpdateInterval)
-	if keeper == nil {
-		nLogger.Debug("Failed to initialize keeper")
+	// create a new envelope for encrypting personal data at rest
+	envelope, err := encryption.NewEnvelope(option.EncryptionKey())
+	if err != nil {
+		log.Fatalln(err)
 	}
+
+	// initialize the keeper instance
+	keeper := initializeKeeper(option, nLogger, envelope)
 	defer keeper.Close()
 
 	// initialize the storage instance
-	memoryStorage := initializeStorage(server.ctx, keeper, nLogger)
-	if memoryStorage == nil {
-		nLogger.Debug("Failed to initialize storage")
-	}
+	memoryStorage := initializeStorage(server.ctx, keeper, option, nLogger)
+
+	// keep the storage coherent with other instances sharing the database
+	followChanges(server.ctx, memoryStorage, keeper, option, nLogger)
+
+	// create an event bus that triggers the enrichment of new passports and
+	// delivers its results
+	bus := events.NewBus(nLogger)
+	defer bus.Close()
 
 	// create a new workerpool for concurrency task processing
-	var allTask []*workerpool.Task
-	pool := initializeWorkerPool(allTask, option, nLogger)
+	pool := initializeWorkerPool(option, nLogger)
+	server.pool = pool
 
 	// create a new NewJWTAuthz for user authorization
 	authz := initializeAuthz(memoryStorage, option, nLogger)
 
+	// create a new cipher for encrypting secrets at rest
+	cipher, err := encryption.NewCipher(option.EncryptionKey())
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// create a new two-factor authentication service
+	twoFactor := initializeTwoFactor(memoryStorage, cipher, option, nLogger)
+
 	// create a new controller to process incoming requests
-	basecontr := initializeBaseController(server.ctx, memoryStorage, option.DefaultEndTime, nLogger, authz)
+	basecontr := initializeBaseController(server.ctx, memoryStorage, option.DefaultEndTime, nLogger, authz, twoFactor, bus, cipher)
 
 	// get a middleware for logging requests
 	reqLog := middleware.NewReqLog(nLogger)
 
-	// start the worker pool in the background
-	go pool.RunBackground()
+	// start the workers of the pool
+	pool.Start()
+
+	// create a runner of the background jobs kept in the database
+	runner := initializeJobRunner(memoryStorage, pool, option, nLogger)
+	server.jobs = runner
 
 	// create a new controller for creating outgoing requests
 	extcontr := initializeExtController(server.ctx, memoryStorage, option, nLogger)
 
-	apiService := initializeApiService(server.ctx, extcontr, pool, memoryStorage, nLogger, option)
+	apiService := initializeApiService(server.ctx, extcontr, pool, memoryStorage, bus, runner, nLogger, option)
 	apiService.Start()
+	server.apiService = apiService
+
+	// schedule the reports emailed to their subscribers
+	reportService := initializeReportService(memoryStorage, runner, option, nLogger)
+	reportService.Start(server.ctx)
+
+	// post the events to the webhooks subscribed to them
+	webhookService := initializeWebhookService(memoryStorage, runner, bus, cipher, option, nLogger)
+	webhookService.Start(server.ctx)
+	server.webhooks = webhookService
+
+	// number the timer and task events for the live streams of the clients
+	hub := stream.NewHub(bus, nLogger, option)
+	hub.Start()
+
+	// run the jobs once their handlers are registered
+	runner.Start(server.ctx)
 
 	// create router and mount routes
 	r := chi.NewRouter()
+	r.Use(chimiddleware.RequestID)
 	r.Use(reqLog.RequestLogger)
 	r.Mount("/", basecontr.Route())
+	r.Mount("/api/status", extcontr.Route())
+	r.Mount("/api/webhooks", controllers.NewWebhookController(webhookService, authz, nLogger).Route())
+	r.Mount("/api/events", controllers.NewStreamController(hub, authz, nLogger).Route())
+	r.Mount("/api/board", controllers.NewBoardController(server.ctx, memoryStorage, bus, authz, nLogger).Route())
+
+	// mount OpenID Connect login if an identity provider is configured
+	if option.OIDCIssuer() != "" {
+		oidccontr := initializeOIDCController(server.ctx, memoryStorage, authz, twoFactor, cipher, option, nLogger)
+		r.Mount("/api/auth/oidc", oidccontr.Route())
+		r.Mount("/api/me/identities", oidccontr.IdentitiesRoute())
+	}
 
 	// configure and start the server
 	server.srv = startServer(r, option.RunAddr())
 
-	// Create a channel to receive interrupt signals (e.g., CTRL+C)
-	stopChan := make(chan os.Signal, 1)
-	signal.Notify(stopChan, os.Interrupt)
-
-	// Block execution until a signal is received
-	<-stopChan
+	// end the event streams, which are never idle, when the server shuts down
+	server.srv.RegisterOnShutdown(hub.Stop)
 
+	// Block execution until the server is shut down
+	<-server.ctx.Done()
 }
 
-// initializeKeeper initializes a BDKeeper instance
-func initializeKeeper(dataBaseDSN func() string, logger *logger.Logger, userUpdateInterval func() string) *bdkeeper.BDKeeper {
-	if dataBaseDSN() == "" {
-		logger.Warn("DataBaseDSN is empty")
-		return nil
+// initializeKeeper selects the keeper by the DSN scheme: a SQLiteKeeper for sqlite://,
+// a BDKeeper for PostgreSQL, or a MemKeeper if no database is configured
+func initializeKeeper(option *config.Options, logger *logger.Logger, envelope *encryption.Envelope) storage.Keeper {
+	dsn := option.DataBaseDSN()
+
+	switch {
+	case dsn == "":
+		logger.Warn("DataBaseDSN is empty, keeping data in memory")
+		return memkeeper.NewMemKeeper(option.SnapshotFile, logger, option.UserUpdateInterval, envelope)
+
+	case strings.HasPrefix(dsn, sqlitekeeper.Scheme):
+		keeper := sqlitekeeper.NewSQLiteKeeper(option.DataBaseDSN, logger, option.UserUpdateInterval, envelope)
+		if keeper == nil {
+			log.Fatalln("failed to initialize sqlite keeper")
+		}
+		return keeper
+	}
+
+	keeper := bdkeeper.NewBDKeeper(option.DataBaseDSN, logger, option.UserUpdateInterval, envelope)
+	if keeper == nil {
+		log.Fatalln("failed to initialize database keeper")
 	}
 
-	return bdkeeper.NewBDKeeper(dataBaseDSN, logger, userUpdateInterval)
+	return keeper
 }
 
 // initializeStorage initializes a MemoryStorage instance
-func initializeStorage(ctx context.Context, keeper storage.Keeper, logger *logger.Logger) *storage.MemoryStorage {
-	if keeper == nil {
-		logger.Warn("Keeper is nil, cannot initialize storage")
-		return nil
+func initializeStorage(ctx context.Context, keeper storage.Keeper, option *config.Options, logger *logger.Logger) *storage.MemoryStorage {
+	return storage.NewMemoryStorage(ctx, keeper, logger, option)
+}
+
+// followChanges applies the changes of other instances in the background if CACHE_SYNC is set
+func followChanges(ctx context.Context, memoryStorage *storage.MemoryStorage, keeper storage.Keeper, option *config.Options, logger *logger.Logger) {
+	enabled, err := strconv.ParseBool(option.CacheSync())
+	if err != nil {
+		logger.Warn("invalid CACHE_SYNC, not following changes")
+		return
+	}
+	if !enabled {
+		return
+	}
+
+	feed, ok := keeper.(storage.ChangeFeed)
+	if !ok {
+		logger.Warn("the keeper does not publish changes, not following them")
+		return
 	}
 
-	return storage.NewMemoryStorage(ctx, keeper, logger)
+	go memoryStorage.Follow(ctx, feed)
 }
 
 // initializeBaseController initializes a BaseController instance
 func initializeBaseController(ctx context.Context, storage *storage.MemoryStorage, DefaultEndTime func() string,
-	logger *logger.Logger, authz *authz.JWTAuthz,
+	logger *logger.Logger, authz *authz.JWTAuthz, twoFactor *twofactor.Service, bus *events.Bus, cipher *encryption.Cipher,
 ) *controllers.BaseController {
-	return controllers.NewBaseController(ctx, storage, DefaultEndTime, logger, authz)
+	return controllers.NewBaseController(ctx, storage, DefaultEndTime, logger, authz, twoFactor, bus, cipher)
+}
+
+// initializeTwoFactor initializes a two-factor authentication Service instance
+func initializeTwoFactor(storage *storage.MemoryStorage, cipher *encryption.Cipher, option *config.Options, logger *logger.Logger) *twofactor.Service {
+	return twofactor.NewService(storage, cipher, option.TOTPIssuer, logger)
+}
+
+// initializeOIDCController initializes an OIDCController instance
+func initializeOIDCController(ctx context.Context, storage *storage.MemoryStorage, authz *authz.JWTAuthz,
+	twoFactor *twofactor.Service, cipher *encryption.Cipher, option *config.Options, logger *logger.Logger,
+) *controllers.OIDCController {
+	provider := oidc.NewClient(oidc.Config{
+		Issuer:       option.OIDCIssuer(),
+		ClientID:     option.OIDCClientID(),
+		ClientSecret: option.OIDCClientSecret(),
+		RedirectURL:  option.OIDCRedirectURL(),
+	}, logger)
+
+	return controllers.NewOIDCController(ctx, storage, provider, authz, twoFactor, cipher, option.DefaultEndTime, option.OIDCPassportClaim, logger)
+}
+
+// initializeWorkerPool initializes a worker pool with the provided options
+func initializeWorkerPool(option *config.Options, logger *logger.Logger) *workerpool.Pool {
+	return workerpool.NewPool(option, logger)
+}
+
+// initializeJobRunner initializes a job Runner executing the jobs on the worker pool
+func initializeJobRunner(storage *storage.MemoryStorage, pool *workerpool.Pool, option *config.Options, logger *logger.Logger) *jobs.Runner {
+	return jobs.NewRunner(storage, pool, logger, option)
 }
 
-// initializeWorkerPool initializes a worker pool with the provided tasks and options
-func initializeWorkerPool(allTask []*workerpool.Task, option *config.Options, logger *logger.Logger) *workerpool.Pool {
-	return workerpool.NewPool(allTask, option.Concurrency, logger, option.TaskExecutionInterval)
+// initializeReportService initializes a report Service sending the reports over SMTP
+func initializeReportService(storage *storage.MemoryStorage, runner *jobs.Runner, option *config.Options, logger *logger.Logger) *reports.Service {
+	return reports.NewService(storage, runner, mailer.NewClient(option, logger), logger)
+}
+
+// initializeWebhookService initializes a webhook Service delivering the events of the bus
+func initializeWebhookService(storage *storage.MemoryStorage, runner *jobs.Runner, bus *events.Bus,
+	cipher *encryption.Cipher, option *config.Options, logger *logger.Logger,
+) *webhooks.Service {
+	return webhooks.NewService(storage, runner, bus, cipher, logger, option)
 }
 
 // initializeAuthz initializes a JWTAuthz instance for user authorization
@@ -137,16 +270,49 @@ func initializeAuthz(storage *storage.MemoryStorage, option *config.Options, log
 
 // initializeExtController initializes an ExtController instance
 func initializeExtController(ctx context.Context, storage *storage.MemoryStorage, option *config.Options, logger *logger.Logger) *controllers.ExtController {
-	return controllers.NewExtController(ctx, storage, option.ApiSystemAddress, logger)
+	client := httpclient.NewClient(option, logger)
+	enricher := initializeEnrichment(client, option, logger)
+
+	return controllers.NewExtController(ctx, storage, enricher, client, logger)
+}
+
+// initializeEnrichment registers the enrichment providers and merges the configured ones
+func initializeEnrichment(client *httpclient.Client, option *config.Options, logger *logger.Logger) *enrichment.Merger {
+	registry := enrichment.NewRegistry()
+
+	fields, err := enrichment.ParseFields(option.EnrichmentHTTPFields())
+	if err != nil {
+		log.Fatalln(err)
+	}
+	registry.Register(enrichment.NewHTTPProvider(client, option.ApiSystemAddress, fields, logger))
+
+	if option.EnrichmentFile() != "" {
+		file, err := enrichment.NewFileProvider(option.EnrichmentFile, logger)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		registry.Register(file)
+	}
+
+	merger, err := registry.Build(option.EnrichmentProviders(), option.EnrichmentPrecedence())
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	return merger
 }
 
 // initializeApiService initializes an ApiService instance
-func initializeApiService(ctx context.Context, extcontr *controllers.ExtController, pool *workerpool.Pool, memoryStorage *storage.MemoryStorage, logger *logger.Logger, option *config.Options) *apiservice.ApiService {
-	apiService := apiservice.NewApiService(ctx, extcontr, pool, memoryStorage, logger, option.TaskExecutionInterval)
+func initializeApiService(ctx context.Context, extcontr *controllers.ExtController, pool *workerpool.Pool, memoryStorage *storage.MemoryStorage,
+	bus *events.Bus, runner *jobs.Runner, logger *logger.Logger, option *config.Options,
+) *apiservice.ApiService {
+	apiService := apiservice.NewApiService(ctx, extcontr, pool, memoryStorage, bus, runner, logger, option.TaskExecutionInterval, option)
 	return apiService
 }
 
-// startServer configures and starts an HTTP server with the provided router and address
+// startServer configures and starts an HTTP server with the provided router and address.
+// The streaming routes extend the write deadline with every write, so the WriteTimeout
+// bounds the writes of a stream rather than the whole stream.
 func startServer(router chi.Router, address string) *http.Server {
 	const (
 		oneMegabyte = 1 << 20
@@ -188,13 +354,31 @@ func (server *Server) Shutdown(timeout time.Duration) {
 	log.Println("attempting to stop the server")
 
 	if server.srv != nil {
-		if err := server.srv.Shutdown(ctxShutDown); err != nil {
-			if !errors.Is(err, http.ErrServerClosed) {
-				log.Printf("server Shutdown Failed: %s", err)
-				return
-			}
+		if err := server.srv.Shutdown(ctxShutDown); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			// The background work is stopped anyway
+			log.Printf("server Shutdown Failed: %s", err)
+		} else {
+			log.Println("server stopped")
+		}
+	}
+
+	// Stop claiming new work, then let the workers finish the queued tasks. The
+	// jobs cancelled by the pool stay in the database for the next start.
+	if server.webhooks != nil {
+		server.webhooks.Stop()
+	}
+	if server.jobs != nil {
+		server.jobs.Stop()
+	}
+	if server.apiService != nil {
+		server.apiService.Stop()
+	}
+	if server.pool != nil {
+		if err := server.pool.Shutdown(ctxShutDown); err != nil {
+			log.Printf("worker pool cancelled before draining: %s", err)
+		} else {
+			log.Println("worker pool drained")
 		}
-		log.Println("server stopped")
 	}
 
 	log.Println("server exited properly")
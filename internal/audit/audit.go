@@ -0,0 +1,101 @@
+// Package audit carries the request metadata of audit events and computes the
+// changes recorded for a mutation.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+	ActionErase  = "erase"
+	ActionStart  = "start"
+	ActionStop   = "stop"
+	ActionLink   = "link"
+	ActionResync = "resync"
+)
+
+// Entity types recorded in the audit log
+const (
+	EntityUser      = "user"
+	EntityTask      = "task"
+	EntityTimeEntry = "time_entry"
+	EntityTwoFactor = "two_factor"
+	EntityIdentity  = "identity"
+)
+
+// Redacted replaces the values of sensitive fields
+const Redacted = "[redacted]"
+
+// Metadata describes who performed a mutation. A zero ActorID is the system itself.
+type Metadata struct {
+	ActorID   int
+	RequestID string
+	IP        string
+}
+
+type metadataKey struct{}
+
+// WithMetadata returns a copy of ctx that carries the audit metadata
+func WithMetadata(ctx context.Context, m Metadata) context.Context {
+	return context.WithValue(ctx, metadataKey{}, m)
+}
+
+// FromContext returns the audit metadata of ctx, or the system actor if there is none
+func FromContext(ctx context.Context) Metadata {
+	m, _ := ctx.Value(metadataKey{}).(Metadata)
+	return m
+}
+
+// Diff returns the JSON of the fields that differ between two snapshots of an
+// entity. A nil snapshot stands for an entity that does not exist, so all fields
+// of the other one are included. Values of sensitive fields are redacted.
+func Diff(before, after map[string]interface{}, sensitive ...string) (json.RawMessage, json.RawMessage, error) {
+	changedBefore := make(map[string]interface{})
+	changedAfter := make(map[string]interface{})
+
+	for key, value := range before {
+		if after == nil || !reflect.DeepEqual(value, after[key]) {
+			changedBefore[key] = value
+		}
+	}
+	for key, value := range after {
+		if before == nil || !reflect.DeepEqual(value, before[key]) {
+			changedAfter[key] = value
+		}
+	}
+
+	for _, key := range sensitive {
+		if _, ok := changedBefore[key]; ok {
+			changedBefore[key] = Redacted
+		}
+		if _, ok := changedAfter[key]; ok {
+			changedAfter[key] = Redacted
+		}
+	}
+
+	beforeJSON, err := marshal(before, changedBefore)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	afterJSON, err := marshal(after, changedAfter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return beforeJSON, afterJSON, nil
+}
+
+func marshal(snapshot, changed map[string]interface{}) (json.RawMessage, error) {
+	if snapshot == nil {
+		return nil, nil
+	}
+
+	return json.Marshal(changed)
+}
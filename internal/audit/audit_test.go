@@ -0,0 +1,40 @@
+package audit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	before := map[string]interface{}{"surname": "Ivanov", "timezone": "UTC", "name": "Ivan"}
+	after := map[string]interface{}{"surname": "Petrov", "timezone": "Europe/Moscow", "name": "Ivan"}
+
+	b, a, err := Diff(before, after, "surname")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"surname":"[redacted]","timezone":"UTC"}`, string(b))
+	assert.JSONEq(t, `{"surname":"[redacted]","timezone":"Europe/Moscow"}`, string(a))
+}
+
+func TestDiff_CreateAndDelete(t *testing.T) {
+	snapshot := map[string]interface{}{"name": "Report"}
+
+	b, a, err := Diff(nil, snapshot)
+	require.NoError(t, err)
+	assert.Nil(t, b)
+	assert.JSONEq(t, `{"name":"Report"}`, string(a))
+
+	b, a, err = Diff(snapshot, nil)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name":"Report"}`, string(b))
+	assert.Nil(t, a)
+}
+
+func TestFromContext(t *testing.T) {
+	assert.Equal(t, Metadata{}, FromContext(context.Background()))
+
+	m := Metadata{ActorID: 7, RequestID: "req-1", IP: "10.0.0.1"}
+	assert.Equal(t, m, FromContext(WithMetadata(context.Background(), m)))
+}
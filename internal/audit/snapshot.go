@@ -0,0 +1,61 @@
+package audit
+
+import (
+	"encoding/hex"
+
+	"github.com/wurt83ow/timetracker/internal/models"
+)
+
+// SensitiveUserFields are recorded as changed, without their values
+var SensitiveUserFields = []string{"passportSerie", "passportNumber", "surname", "name", "patronymic", "address", "password_hash"}
+
+// UserSnapshot returns the audited fields of a user
+func UserSnapshot(user models.User) map[string]interface{} {
+	snapshot := map[string]interface{}{
+		"passportSerie":  user.PassportSerie,
+		"passportNumber": user.PassportNumber,
+		"surname":        user.Surname,
+		"name":           user.Name,
+		"patronymic":     user.Patronymic,
+		"address":        user.Address,
+		"timezone":       user.Timezone,
+		"password_hash":  hex.EncodeToString(user.Hash),
+	}
+	if !user.DefaultEndTime.IsZero() {
+		snapshot["default_end_time"] = user.DefaultEndTime.Format("15:04:05Z07:00")
+	}
+
+	return snapshot
+}
+
+// ErasedUserSnapshot returns the audited fields of a user after erasure
+func ErasedUserSnapshot(user models.User) map[string]interface{} {
+	return UserSnapshot(models.User{DefaultEndTime: user.DefaultEndTime, Timezone: user.Timezone})
+}
+
+// TaskSnapshot returns the audited fields of a task
+func TaskSnapshot(task models.Task) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        task.Name,
+		"description": task.Description,
+	}
+}
+
+// EnrichedUserSnapshot returns the fields changed by the external enrichment of a user.
+// The previous values are not read, so the fields are compared against an empty snapshot.
+// Empty fields are not enriched and not recorded.
+func EnrichedUserSnapshot(data models.ExtUserData) (map[string]interface{}, map[string]interface{}) {
+	after := make(map[string]interface{})
+	for name, value := range map[string]string{
+		"surname":    data.Surname,
+		"name":       data.Name,
+		"patronymic": data.Patronymic,
+		"address":    data.Address,
+	} {
+		if value != "" {
+			after[name] = ""
+		}
+	}
+
+	return map[string]interface{}{}, after
+}
@@ -1,32 +1,45 @@
 package authz
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
+	"crypto/subtle"
 	"errors"
 	"log"
 	"net/http"
 	"strconv"
-	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 	"github.com/wurt83ow/timetracker/internal/config"
 	"github.com/wurt83ow/timetracker/internal/models"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"golang.org/x/crypto/bcrypt"
 )
 
+// challengePurpose marks short-lived tokens that only allow completing a 2FA login
+const (
+	challengePurpose = "2fa"
+	challengeTTL     = 5 * time.Minute
+)
+
+// CustomClaims carries the user ID in the standard "sub" claim
 type CustomClaims struct {
-	PassportNumber string `json:"passport_number"`
+	Purpose string `json:"purpose,omitempty"`
 	jwt.StandardClaims
 }
 
+// principalKey is the context key of the authenticated principal
+type principalKey struct{}
+
 type Log interface {
 	Info(string, ...zapcore.Field)
 }
 
 type Storage interface {
-	GetUser(context.Context, int, int) (models.User, error)
+	GetUserByID(context.Context, int) (models.User, error)
 }
 
 type JWTAuthz struct {
@@ -60,53 +73,44 @@ func (j *JWTAuthz) JWTAuthzMiddleware(log Log) func(next http.Handler) http.Hand
 			// Grab jwt-token cookie
 			jwtCookie, err := r.Cookie("jwt-token")
 
-			var userID string
+			var userID int
 			if err == nil && jwtCookie.Value != "" {
 				userID, err = j.DecodeJWTToUser(jwtCookie.Value)
 
 				if err != nil {
-					userID = ""
+					userID = 0
 					log.Info("Error occurred decoding JWT from cookie", zap.Error(err))
 				}
 			} else {
 				log.Info("Error occurred reading JWT cookie", zap.Error(err))
 			}
 
-			if userID == "" {
+			if userID == 0 {
 				jwtHeader := r.Header.Get("Authorization")
 
 				if jwtHeader != "" {
 					userID, err = j.DecodeJWTToUser(jwtHeader)
 					if err != nil {
-						userID = ""
+						userID = 0
 						log.Info("Error occurred decoding token from header", zap.Error(err))
 					}
 				}
 			}
 
-			if userID == "" {
+			if userID == 0 {
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
 
 			// Check if user exists in the database
-			passportSerie, passportNumber, err := j.parsePassportData(userID)
-			if err != nil {
-				log.Info("Error occurred parsing passport data", zap.Error(err))
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-
-			_, err = j.storage.GetUser(r.Context(), passportSerie, passportNumber)
+			user, err := j.storage.GetUserByID(r.Context(), userID)
 			if err != nil {
 				log.Info("User not found in database", zap.Error(err))
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
 
-			var keyUserID models.Key = "userID"
-			ctx := r.Context()
-			ctx = context.WithValue(ctx, keyUserID, userID)
+			ctx := WithPrincipal(r.Context(), NewPrincipal(user))
 
 			next.ServeHTTP(w, r.WithContext(ctx))
 		}
@@ -115,10 +119,36 @@ func (j *JWTAuthz) JWTAuthzMiddleware(log Log) func(next http.Handler) http.Hand
 	}
 }
 
-func (j *JWTAuthz) CreateJWTTokenForUser(userid string) string {
+// NewPrincipal builds the principal of an authenticated user
+func NewPrincipal(user models.User) models.Principal {
+	return models.Principal{
+		UserID:         user.UUID,
+		Role:           user.Role,
+		Timezone:       user.Timezone,
+		DefaultEndTime: user.DefaultEndTime,
+	}
+}
+
+// WithPrincipal returns a copy of ctx that carries the principal
+func WithPrincipal(ctx context.Context, principal models.Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal stored by JWTAuthzMiddleware
+func PrincipalFromContext(ctx context.Context) (models.Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(models.Principal)
+	if !ok || principal.UserID == 0 {
+		return models.Principal{}, false
+	}
+
+	return principal, true
+}
+
+func (j *JWTAuthz) CreateJWTTokenForUser(userID int) string {
 	claims := CustomClaims{
-		PassportNumber: userid,
-		StandardClaims: jwt.StandardClaims{},
+		StandardClaims: jwt.StandardClaims{
+			Subject: strconv.Itoa(userID),
+		},
 	}
 
 	// Encode to token string
@@ -131,9 +161,58 @@ func (j *JWTAuthz) CreateJWTTokenForUser(userid string) string {
 	return tokenString
 }
 
-func (j *JWTAuthz) DecodeJWTToUser(token string) (string, error) {
+// CreateChallengeToken creates a short-lived token that can only be exchanged
+// for a regular JWT after a valid second factor is presented
+func (j *JWTAuthz) CreateChallengeToken(userID int) string {
+	claims := CustomClaims{
+		Purpose: challengePurpose,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   strconv.Itoa(userID),
+			ExpiresAt: time.Now().Add(challengeTTL).Unix(),
+		},
+	}
+
+	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(j.jwtSigningKey)
+	if err != nil {
+		log.Println("Error occurred generating challenge token", err)
+		return ""
+	}
+
+	return tokenString
+}
+
+// DecodeJWTToUser returns the ID of the user the token was issued to
+func (j *JWTAuthz) DecodeJWTToUser(token string) (int, error) {
+	claims, err := j.decodeClaims(token)
+	if err != nil {
+		return 0, err
+	}
+
+	// Challenge tokens must not grant access to the API
+	if claims.Purpose != "" {
+		return 0, errors.New("unexpected token purpose")
+	}
+
+	return subjectToUserID(claims.Subject)
+}
+
+// DecodeChallengeToken returns the user of a valid, unexpired challenge token
+func (j *JWTAuthz) DecodeChallengeToken(token string) (int, error) {
+	claims, err := j.decodeClaims(token)
+	if err != nil {
+		return 0, err
+	}
+
+	if claims.Purpose != challengePurpose {
+		return 0, errors.New("not a challenge token")
+	}
+
+	return subjectToUserID(claims.Subject)
+}
+
+func (j *JWTAuthz) decodeClaims(token string) (*CustomClaims, error) {
 	if token == "" {
-		return "", errors.New("empty token")
+		return nil, errors.New("empty token")
 	}
 
 	// Decode
@@ -145,26 +224,58 @@ func (j *JWTAuthz) DecodeJWTToUser(token string) (string, error) {
 
 		return j.jwtSigningKey, nil
 	})
+	if err != nil {
+		return nil, err
+	}
 
 	// There's two parts. We might decode it successfully but it might
 	// be the case we aren't Valid so you must check both
 	if decClaims, ok := decodedToken.Claims.(*CustomClaims); ok && decodedToken.Valid {
-		return decClaims.PassportNumber, nil
+		return decClaims, nil
 	}
 
-	return "", err
+	return nil, errors.New("invalid token")
 }
 
-func (j *JWTAuthz) GetHash(passportNumber string, password string) []byte {
-	src := []byte(passportNumber + password)
+// HashPassword returns a salted bcrypt hash of the password
+func (j *JWTAuthz) HashPassword(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
 
-	// create a new hash.Hash that calculates the SHA-256 checksum
-	h := sha256.New()
-	// transfer bytes for hashing
-	h.Write(src)
-	// calculate the hash
+// CheckPassword compares a password with a stored hash. Hashes created before
+// passwords were salted per user are derived from the passport login, or from the
+// login bound to them by BindLegacyHash.
+func (j *JWTAuthz) CheckPassword(hash []byte, login string, password string) bool {
+	if j.NeedsRehash(hash) {
+		if bound, ok := bytes.CutPrefix(hash, []byte(legacyBindingPrefix)); ok {
+			i := bytes.IndexByte(bound, '$')
+			if i < 0 {
+				return false
+			}
+			login, hash = string(bound[:i]), bound[i+1:]
+		}
 
-	return h.Sum(nil)
+		return subtle.ConstantTimeCompare(hash, legacyHash(login, password)) == 1
+	}
+
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
+
+// BindLegacyHash records the login a legacy hash was derived from, so the password
+// still matches after the passport used as login changes. The hash is rehashed on
+// the next login as any legacy one.
+func (j *JWTAuthz) BindLegacyHash(hash []byte, login string) []byte {
+	if len(hash) == 0 || !j.NeedsRehash(hash) || bytes.HasPrefix(hash, []byte(legacyBindingPrefix)) {
+		return hash
+	}
+
+	return append([]byte(legacyBindingPrefix+login+"$"), hash...)
+}
+
+// NeedsRehash reports whether the hash was created by the legacy scheme
+func (j *JWTAuthz) NeedsRehash(hash []byte) bool {
+	_, err := bcrypt.Cost(hash)
+	return err != nil
 }
 
 func (j *JWTAuthz) AuthCookie(name string, token string) *http.Cookie {
@@ -176,22 +287,27 @@ func (j *JWTAuthz) AuthCookie(name string, token string) *http.Cookie {
 	return &d
 }
 
-// parsePassportData parses the passport data from a string into series and number
-func (j *JWTAuthz) parsePassportData(passportNumber string) (int, int, error) {
-	parts := strings.Split(passportNumber, " ")
-	if len(parts) != 2 {
-		return 0, 0, errors.New("invalid passport number format")
-	}
+// legacyBindingPrefix starts a legacy hash bound to the login it was derived from
+const legacyBindingPrefix = "sha256-login$"
 
-	passportSerie, err := strconv.Atoi(parts[0])
-	if err != nil {
-		return 0, 0, errors.New("invalid passport series format")
-	}
+// legacyHash is the unsalted SHA-256 of login and password used by earlier versions
+func legacyHash(login string, password string) []byte {
+	src := []byte(login + password)
 
-	passportNumberInt, err := strconv.Atoi(parts[1])
-	if err != nil {
-		return 0, 0, errors.New("invalid passport number format")
+	// create a new hash.Hash that calculates the SHA-256 checksum
+	h := sha256.New()
+	// transfer bytes for hashing
+	h.Write(src)
+	// calculate the hash
+
+	return h.Sum(nil)
+}
+
+func subjectToUserID(subject string) (int, error) {
+	userID, err := strconv.Atoi(subject)
+	if err != nil || userID <= 0 {
+		return 0, errors.New("invalid token subject")
 	}
 
-	return passportSerie, passportNumberInt, nil
+	return userID, nil
 }
@@ -0,0 +1,99 @@
+package authz
+
+import (
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap/zapcore"
+)
+
+type nopLog struct{}
+
+func (nopLog) Info(string, ...zapcore.Field) {}
+
+type usersByID map[int]models.User
+
+func (u usersByID) GetUserByID(_ context.Context, id int) (models.User, error) {
+	user, ok := u[id]
+	if !ok {
+		return models.User{}, storage.ErrNotFound
+	}
+	return user, nil
+}
+
+func TestJWTAuthz_TokenSubjectIsUserID(t *testing.T) {
+	j := NewJWTAuthz(usersByID{}, "test_key", nopLog{})
+
+	userID, err := j.DecodeJWTToUser(j.CreateJWTTokenForUser(42))
+	require.NoError(t, err)
+	assert.Equal(t, 42, userID)
+
+	// A challenge token is not a session token and vice versa
+	_, err = j.DecodeJWTToUser(j.CreateChallengeToken(42))
+	assert.Error(t, err)
+
+	_, err = j.DecodeChallengeToken(j.CreateJWTTokenForUser(42))
+	assert.Error(t, err)
+}
+
+func TestJWTAuthz_MiddlewareSetsPrincipal(t *testing.T) {
+	users := usersByID{7: {UUID: 7, PassportSerie: 1234, PassportNumber: 567890, Timezone: "UTC"}}
+	j := NewJWTAuthz(users, "test_key", nopLog{})
+
+	var principal models.Principal
+	handler := j.JWTAuthzMiddleware(nopLog{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ = PrincipalFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", j.CreateJWTTokenForUser(7))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, models.Principal{UserID: 7, Timezone: "UTC"}, principal)
+
+	// The token stays valid after the passport changes
+	users[7] = models.User{UUID: 7, PassportSerie: 4321, PassportNumber: 98765, Timezone: "UTC"}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// Tokens of deleted users are rejected
+	delete(users, 7)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestJWTAuthz_CheckPassword(t *testing.T) {
+	j := NewJWTAuthz(usersByID{}, "test_key", nopLog{})
+
+	hash, err := j.HashPassword("password123")
+	require.NoError(t, err)
+	assert.False(t, j.NeedsRehash(hash))
+	assert.True(t, j.CheckPassword(hash, "1234 567890", "password123"))
+	assert.True(t, j.CheckPassword(hash, "4321 098765", "password123"))
+	assert.False(t, j.CheckPassword(hash, "1234 567890", "wrong"))
+
+	legacy := sha256.Sum256([]byte("1234 567890" + "password123"))
+	assert.True(t, j.NeedsRehash(legacy[:]))
+	assert.True(t, j.CheckPassword(legacy[:], "1234 567890", "password123"))
+	assert.False(t, j.CheckPassword(legacy[:], "1234 567890", "wrong"))
+
+	// A legacy hash bound to the login keeps matching after the passport changes
+	bound := j.BindLegacyHash(legacy[:], "1234 567890")
+	assert.True(t, j.NeedsRehash(bound))
+	assert.True(t, j.CheckPassword(bound, "4321 098765", "password123"))
+	assert.False(t, j.CheckPassword(bound, "4321 098765", "wrong"))
+	assert.Equal(t, bound, j.BindLegacyHash(bound, "4321 098765"), "a bound hash keeps the first login")
+	assert.Equal(t, hash, j.BindLegacyHash(hash, "1234 567890"), "salted hashes don't depend on the login")
+	assert.Empty(t, j.BindLegacyHash(nil, "1234 567890"))
+}
@@ -0,0 +1,159 @@
+package bdkeeper
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wurt83ow/timetracker/internal/audit"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"go.uber.org/zap"
+)
+
+// recordAudit appends an audit event in the transaction of the mutation it describes
+func (bd *BDKeeper) recordAudit(ctx context.Context, tx pgx.Tx, action, entityType string, entityID int,
+	before, after map[string]interface{}, sensitive ...string,
+) error {
+	beforeJSON, afterJSON, err := audit.Diff(before, after, sensitive...)
+	if err != nil {
+		return err
+	}
+
+	meta := audit.FromContext(ctx)
+
+	query := `
+        INSERT INTO audit_events (actor_id, action, entity_type, entity_id, before, after, request_id, ip)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+    `
+
+	_, err = tx.Exec(ctx, query,
+		nullInt(meta.ActorID),
+		action,
+		entityType,
+		entityID,
+		nullJSON(beforeJSON),
+		nullJSON(afterJSON),
+		nullString(meta.RequestID),
+		nullString(meta.IP),
+	)
+	if err != nil {
+		bd.log.Info("error saving audit event to database: ", zap.Error(err))
+		return err
+	}
+
+	// Every change of a user or a task is audited, so it is published here
+	if err := bd.publish(ctx, tx, entityType, entityID); err != nil {
+		bd.log.Info("error publishing change: ", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// GetAuditEvents returns audit events matching the filter, newest first
+func (bd *BDKeeper) GetAuditEvents(ctx context.Context, filter models.AuditFilter, limit int) ([]models.AuditEvent, error) {
+	query := `
+        SELECT id, COALESCE(actor_id, 0), action, entity_type, entity_id, before, after,
+            COALESCE(request_id, ''), COALESCE(ip, ''), created_at
+        FROM audit_events
+        WHERE TRUE`
+	var args []interface{}
+
+	where := func(condition string, value interface{}) {
+		args = append(args, value)
+		query += " AND " + condition + " $" + strconv.Itoa(len(args))
+	}
+
+	if filter.ActorID != nil {
+		where("actor_id =", *filter.ActorID)
+	}
+	if filter.Action != nil {
+		where("action =", *filter.Action)
+	}
+	if filter.EntityType != nil {
+		where("entity_type =", *filter.EntityType)
+	}
+	if filter.EntityID != nil {
+		where("entity_id =", *filter.EntityID)
+	}
+	if filter.From != nil {
+		where("created_at >=", *filter.From)
+	}
+	if filter.To != nil {
+		where("created_at <", *filter.To)
+	}
+	if filter.BeforeID > 0 {
+		where("id <", filter.BeforeID)
+	}
+
+	args = append(args, limit)
+	query += " ORDER BY id DESC LIMIT $" + strconv.Itoa(len(args))
+
+	rows, err := bd.pool.Query(ctx, query, args...)
+	if err != nil {
+		bd.log.Info("error retrieving audit events from database: ", zap.Error(err))
+		return nil, err
+	}
+
+	return scanAuditEvents(rows)
+}
+
+// exportAudit returns the audit events performed by or about a user
+func exportAudit(ctx context.Context, tx pgx.Tx, userID int) ([]models.AuditEvent, error) {
+	query := `
+        SELECT id, COALESCE(actor_id, 0), action, entity_type, entity_id, before, after,
+            COALESCE(request_id, ''), COALESCE(ip, ''), created_at
+        FROM audit_events
+        WHERE actor_id = $1 OR (entity_type = $2 AND entity_id = $1)
+        ORDER BY id
+    `
+
+	rows, err := tx.Query(ctx, query, userID, audit.EntityUser)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanAuditEvents(rows)
+}
+
+func scanAuditEvents(rows pgx.Rows) ([]models.AuditEvent, error) {
+	defer rows.Close()
+
+	events := make([]models.AuditEvent, 0)
+	for rows.Next() {
+		var e models.AuditEvent
+		var before, after []byte
+		err := rows.Scan(&e.ID, &e.ActorID, &e.Action, &e.EntityType, &e.EntityID, &before, &after,
+			&e.RequestID, &e.IP, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		e.Before = before
+		e.After = after
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+func nullInt(v int) *int {
+	if v == 0 {
+		return nil
+	}
+	return &v
+}
+
+func nullString(v string) *string {
+	if v == "" {
+		return nil
+	}
+	return &v
+}
+
+func nullJSON(v []byte) *string {
+	if v == nil {
+		return nil
+	}
+	s := string(v)
+	return &s
+}
@@ -3,20 +3,23 @@ package bdkeeper
 import (
 	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"reflect"
 	"sort"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file" // registers a migrate driver.
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jackc/pgx/v5/stdlib"
-	"github.com/lib/pq"
+	"github.com/wurt83ow/timetracker/internal/audit"
 	"github.com/wurt83ow/timetracker/internal/models"
 	"github.com/wurt83ow/timetracker/internal/storage"
 	"go.uber.org/zap"
@@ -31,9 +34,11 @@ type BDKeeper struct {
 	pool               *pgxpool.Pool
 	log                Log
 	userUpdateInterval func() string
+	cipher             FieldCipher
+	notify             atomic.Bool // publish changes, see Listen
 }
 
-func NewBDKeeper(dsn func() string, log Log, userUpdateInterval func() string) *BDKeeper {
+func NewBDKeeper(dsn func() string, log Log, userUpdateInterval func() string, cipher FieldCipher) *BDKeeper {
 	addr := dsn()
 	if addr == "" {
 		log.Info("database dsn is empty")
@@ -96,11 +101,41 @@ func NewBDKeeper(dsn func() string, log Log, userUpdateInterval func() string) *
 
 	log.Info("Connected!")
 
-	return &BDKeeper{
+	keeper := &BDKeeper{
 		pool:               pool,
 		log:                log,
 		userUpdateInterval: userUpdateInterval,
+		cipher:             cipher,
 	}
+
+	if err := keeper.encryptPlaintextUsers(context.Background()); err != nil {
+		log.Info("Error while encrypting personal data of existing users: ", zap.Error(err))
+		return nil
+	}
+
+	return keeper
+}
+
+// withTx runs fn in a transaction that is committed if fn succeeds
+func (bd *BDKeeper) withTx(ctx context.Context, fn func(pgx.Tx) error) (err error) {
+	tx, err := bd.pool.Begin(ctx)
+	if err != nil {
+		bd.log.Info("Error while beginning transaction: ", zap.Error(err))
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback(ctx)
+			panic(p)
+		} else if err != nil {
+			_ = tx.Rollback(ctx)
+		} else {
+			err = tx.Commit(ctx)
+		}
+	}()
+
+	return fn(tx)
 }
 
 func (kp *BDKeeper) Close() bool {
@@ -113,38 +148,53 @@ func (kp *BDKeeper) Close() bool {
 	return false
 }
 
-func (bd *BDKeeper) SaveUser(ctx context.Context, user models.User) (int, error) {
+func (bd *BDKeeper) SaveUser(ctx context.Context, user models.User) (userID int, err error) {
 
 	// Convert []byte to string
 	passwordHash := hex.EncodeToString(user.Hash)
 
+	sealed, err := bd.sealUser(user)
+	if err != nil {
+		return 0, err
+	}
+
 	query := `
         INSERT INTO Users (
-            passportSerie, passportNumber, surname, name, patronymic, address,
+            passport_bidx, passport_enc, surname_enc, name_enc, patronymic_enc, address_enc,
             default_end_time, timezone, password_hash
         ) VALUES (
             $1, $2, $3, $4, $5, $6, $7, $8, $9
         )
-        ON CONFLICT (passportSerie, passportNumber) DO NOTHING
+        ON CONFLICT (passport_bidx) DO NOTHING
         RETURNING id
     `
 
-	var userID int
-	err := bd.pool.QueryRow(
-		ctx,
-		query,
-		user.PassportSerie,
-		user.PassportNumber,
-		user.Surname,
-		user.Name,
-		user.Patronymic,
-		user.Address,
-		user.DefaultEndTime,
-		user.Timezone,
-		passwordHash,
-	).Scan(&userID)
+	err = bd.withTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(
+			ctx,
+			query,
+			bd.passportIndex(user.PassportSerie, user.PassportNumber),
+			sealed.passport,
+			sealed.surname,
+			sealed.name,
+			sealed.patronymic,
+			sealed.address,
+			user.DefaultEndTime,
+			user.Timezone,
+			passwordHash,
+		).Scan(&userID)
+		if err != nil {
+			return err
+		}
+
+		return bd.recordAudit(ctx, tx, audit.ActionCreate, audit.EntityUser, userID, nil, audit.UserSnapshot(user), audit.SensitiveUserFields...)
+	})
 
 	if err != nil {
+		// ON CONFLICT DO NOTHING returns no row for an existing passport
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, storage.ErrConflict
+		}
 		bd.log.Info("error saving user to database: ", zap.Error(err))
 		return 0, err
 	}
@@ -153,98 +203,105 @@ func (bd *BDKeeper) SaveUser(ctx context.Context, user models.User) (int, error)
 	return userID, nil
 }
 
+// GetUser retrieves a user by passport using the blind index
 func (bd *BDKeeper) GetUser(ctx context.Context, passportSerie, passportNumber int) (models.User, error) {
 	query := `
 		SELECT
 			id,
-			passportSerie,
-			passportNumber,
-			surname,
-			name,
-			patronymic,
-			address,
+			passport_enc,
+			surname_enc,
+			name_enc,
+			patronymic_enc,
+			address_enc,
 			default_end_time,
 			timezone,
 			password_hash,
-			last_checked_at
+			last_checked_at,
+			role
 		FROM Users
-		WHERE passportSerie = $1 AND passportNumber = $2
+		WHERE passport_bidx = $1
 	`
 
-	var user models.User
-	var defaultEndTime pq.NullTime
-	var lastCheckedAt pq.NullTime
-	var hashHex *string
-
-	err := bd.pool.QueryRow(ctx, query, passportSerie, passportNumber).Scan(
-		&user.UUID,
-		&user.PassportSerie,
-		&user.PassportNumber,
-		&user.Surname,
-		&user.Name,
-		&user.Patronymic,
-		&user.Address,
-		&defaultEndTime,
-		&user.Timezone,
-		&hashHex,
-		&lastCheckedAt,
-	)
-
+	user, err := bd.scanUser(bd.pool.QueryRow(ctx, query, bd.passportIndex(passportSerie, passportNumber)))
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			bd.log.Info("user not found: ", zap.Int("passportSerie", passportSerie), zap.Int("passportNumber", passportNumber))
+		if errors.Is(err, pgx.ErrNoRows) {
+			bd.log.Info("user not found by passport")
 			return models.User{}, nil // User not found
 		}
 		bd.log.Info("error retrieving user from database: ", zap.Error(err))
 		return models.User{}, err // An error occurred while executing the query
 	}
 
-	// Decoding the hash from hex string to bytes, if the value is not NULL
-	if hashHex != nil {
-		user.Hash, err = hex.DecodeString(*hashHex)
-		if err != nil {
-			return models.User{}, fmt.Errorf("failed to decode password hash: %w", err)
-		}
-	}
+	bd.log.Info("User found successfully: ", zap.Int("userID", user.UUID))
+	return user, nil
+}
 
-	// Setting the DefaultEndTime field only if the value from the database is not NULL
-	if defaultEndTime.Valid {
-		user.DefaultEndTime = defaultEndTime.Time
-	}
+// GetUserByID retrieves a user by the internal identifier
+func (bd *BDKeeper) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	query := `
+		SELECT
+			id,
+			passport_enc,
+			surname_enc,
+			name_enc,
+			patronymic_enc,
+			address_enc,
+			default_end_time,
+			timezone,
+			password_hash,
+			last_checked_at,
+			role
+		FROM Users
+		WHERE id = $1 AND erased_at IS NULL
+	`
 
-	// Setting the LastCheckedAt field only if the value from the database is not NULL
-	if lastCheckedAt.Valid {
-		user.LastCheckedAt = lastCheckedAt.Time
+	user, err := bd.scanUser(bd.pool.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.User{}, storage.ErrNotFound
+		}
+		bd.log.Info("error retrieving user by id from database: ", zap.Error(err))
+		return models.User{}, err
 	}
 
-	bd.log.Info("User found successfully: ", zap.Int("userID", user.UUID))
 	return user, nil
 }
 
-func (bd *BDKeeper) UpdateUsersInfo(ctx context.Context, users []models.ExtUserData) (err error) {
+func (bd *BDKeeper) UpdateUsersInfo(ctx context.Context, users []models.ExtUserData) (ids []int, err error) {
 	if len(users) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	// Prepare arrays for batch updating
-	passportSeries := make([]int, len(users))
-	passportNumbers := make([]int, len(users))
-	surnames := make([]string, len(users))
-	names := make([]string, len(users))
-	addresses := make([]string, len(users))
+	passportIndexes := make([]string, len(users))
+	surnames := make([]*string, len(users))
+	names := make([]*string, len(users))
+	patronymics := make([]*string, len(users))
+	addresses := make([]*string, len(users))
+	byIndex := make(map[string]models.ExtUserData, len(users))
 
 	for i, user := range users {
-		passportSeries[i] = user.PassportSerie
-		passportNumbers[i] = user.PassportNumber
-		surnames[i] = user.Surname
-		names[i] = user.Name
-		addresses[i] = user.Address
+		passportIndexes[i] = bd.passportIndex(user.PassportSerie, user.PassportNumber)
+		byIndex[passportIndexes[i]] = user
+
+		if surnames[i], err = bd.seal(user.Surname); err != nil {
+			return nil, err
+		}
+		if names[i], err = bd.seal(user.Name); err != nil {
+			return nil, err
+		}
+		if patronymics[i], err = bd.seal(user.Patronymic); err != nil {
+			return nil, err
+		}
+		if addresses[i], err = bd.seal(user.Address); err != nil {
+			return nil, err
+		}
 	}
 
 	// Read the interval from the environment variable
 	updateInterval, err := time.ParseDuration(bd.userUpdateInterval())
 	if err != nil {
-		return fmt.Errorf("failed to parse USER_UPDATE_INTERVAL: %w", err)
+		return nil, fmt.Errorf("failed to parse USER_UPDATE_INTERVAL: %w", err)
 	}
 
 	// Get the current time and calculate the threshold time
@@ -255,7 +312,7 @@ func (bd *BDKeeper) UpdateUsersInfo(ctx context.Context, users []models.ExtUserD
 	tx, err := bd.pool.Begin(ctx)
 	if err != nil {
 		bd.log.Info("Error while beginning transaction: ", zap.Error(err))
-		return err
+		return nil, err
 	}
 
 	defer func() {
@@ -280,103 +337,169 @@ func (bd *BDKeeper) UpdateUsersInfo(ctx context.Context, users []models.ExtUserD
 
 	query := `
         UPDATE Users SET
-            surname = updated.surname,
-            name = updated.name,
-            address = updated.address,
+            surname_enc = COALESCE(updated.surname, Users.surname_enc),
+            name_enc = COALESCE(updated.name, Users.name_enc),
+            patronymic_enc = COALESCE(updated.patronymic, Users.patronymic_enc),
+            address_enc = COALESCE(updated.address, Users.address_enc),
             last_checked_at = CURRENT_TIMESTAMP
         FROM (
             SELECT
-                unnest($1::int[]) AS passportSerie,
-                unnest($2::int[]) AS passportNumber,
-                unnest($3::text[]) AS surname,
-                unnest($4::text[]) AS name,
+                unnest($1::text[]) AS passport_bidx,
+                unnest($2::text[]) AS surname,
+                unnest($3::text[]) AS name,
+                unnest($4::text[]) AS patronymic,
                 unnest($5::text[]) AS address
         ) AS updated
-        WHERE Users.passportSerie = updated.passportSerie
-        AND Users.passportNumber = updated.passportNumber
+        WHERE Users.passport_bidx = updated.passport_bidx
         AND (Users.last_checked_at IS NULL OR Users.last_checked_at <= $6)
+        RETURNING Users.id, Users.passport_bidx
     `
 	// Execute the query using pgx
-	_, err = tx.Exec(
+	rows, err := tx.Query(
 		ctx,
 		query,
-		passportSeries,
-		passportNumbers,
+		passportIndexes,
 		surnames,
 		names,
+		patronymics,
 		addresses,
 		thresholdTime,
 	)
 	if err != nil {
 		bd.log.Info("Error during batch updating user data in the database: ", zap.Error(err))
-		return err
+		return nil, err
+	}
+
+	type updatedUser struct {
+		ID           int
+		PassportBidx string
+	}
+	updated, err := pgx.CollectRows(rows, pgx.RowToStructByPos[updatedUser])
+	if err != nil {
+		bd.log.Info("Error during batch updating user data in the database: ", zap.Error(err))
+		return nil, err
+	}
+
+	for _, u := range updated {
+		before, after := audit.EnrichedUserSnapshot(byIndex[u.PassportBidx])
+		err = bd.recordAudit(ctx, tx, audit.ActionUpdate, audit.EntityUser, u.ID, before, after, audit.SensitiveUserFields...)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, u.ID)
 	}
 
 	bd.log.Info("User data successfully updated")
-	return nil
+	return ids, nil
 }
 
 func (bd *BDKeeper) UpdateUser(ctx context.Context, user models.User) error {
+	current, err := bd.GetUserByID(ctx, user.UUID)
+	if err != nil {
+		return err
+	}
+
 	query := "UPDATE Users SET "
 	args := []interface{}{user.UUID}
 	argCounter := 2 // Start with 2 since the 1st argument is the UUID
 
-	if user.PassportSerie != 0 {
-		query += "passportSerie = $" + strconv.Itoa(argCounter) + ", "
-		args = append(args, user.PassportSerie)
-		argCounter++
-	}
-	if user.PassportNumber != 0 {
-		query += "passportNumber = $" + strconv.Itoa(argCounter) + ", "
-		args = append(args, user.PassportNumber)
-		argCounter++
-	}
-	if user.Surname != "" {
-		query += "surname = $" + strconv.Itoa(argCounter) + ", "
-		args = append(args, user.Surname)
-		argCounter++
-	}
-	if user.Name != "" {
-		query += "name = $" + strconv.Itoa(argCounter) + ", "
-		args = append(args, user.Name)
+	set := func(column string, value interface{}) {
+		query += column + " = $" + strconv.Itoa(argCounter) + ", "
+		args = append(args, value)
 		argCounter++
 	}
-	if user.Patronymic != "" {
-		query += "patronymic = $" + strconv.Itoa(argCounter) + ", "
-		args = append(args, user.Patronymic)
-		argCounter++
+
+	// The updated user as it will be stored, used for the audit log
+	updated := current
+
+	if user.PassportSerie != 0 || user.PassportNumber != 0 {
+		// The blind index covers the whole passport, so a partial change needs the stored one
+		if user.PassportSerie != 0 {
+			updated.PassportSerie = user.PassportSerie
+		}
+		if user.PassportNumber != 0 {
+			updated.PassportNumber = user.PassportNumber
+		}
+
+		passport, err := bd.seal(passportKey(updated.PassportSerie, updated.PassportNumber))
+		if err != nil {
+			return err
+		}
+		set("passport_bidx", bd.passportIndex(updated.PassportSerie, updated.PassportNumber))
+		set("passport_enc", passport)
+
+		// A new passport is enriched again at once, the last check was of the old one
+		if storage.PassportChanged(current, updated) && user.LastCheckedAt.IsZero() {
+			set("last_checked_at", nil)
+		}
 	}
-	if user.Address != "" {
-		query += "address = $" + strconv.Itoa(argCounter) + ", "
-		args = append(args, user.Address)
-		argCounter++
+
+	for _, field := range []struct {
+		column, value string
+		target        *string
+	}{
+		{"surname_enc", user.Surname, &updated.Surname},
+		{"name_enc", user.Name, &updated.Name},
+		{"patronymic_enc", user.Patronymic, &updated.Patronymic},
+		{"address_enc", user.Address, &updated.Address},
+	} {
+		if field.value == "" {
+			continue
+		}
+		sealed, err := bd.seal(field.value)
+		if err != nil {
+			return err
+		}
+		set(field.column, sealed)
+		*field.target = field.value
 	}
+
 	if !user.DefaultEndTime.IsZero() {
-		query += "default_end_time = $" + strconv.Itoa(argCounter) + ", "
-		args = append(args, user.DefaultEndTime)
-		argCounter++
+		set("default_end_time", user.DefaultEndTime)
+		updated.DefaultEndTime = user.DefaultEndTime
 	}
 	if user.Timezone != "" {
-		query += "timezone = $" + strconv.Itoa(argCounter) + ", "
-		args = append(args, user.Timezone)
-		argCounter++
+		set("timezone", user.Timezone)
+		updated.Timezone = user.Timezone
 	}
 	if len(user.Hash) > 0 {
-		query += "password_hash = $" + strconv.Itoa(argCounter) + ", "
-		args = append(args, user.Hash)
-		argCounter++
+		set("password_hash", hex.EncodeToString(user.Hash))
+		updated.Hash = user.Hash
 	}
 	if !user.LastCheckedAt.IsZero() {
-		query += "last_checked_at = $" + strconv.Itoa(argCounter) + ", "
-		args = append(args, user.LastCheckedAt)
+		set("last_checked_at", user.LastCheckedAt)
+	}
+
+	if len(args) == 1 {
+		// Nothing to update
+		return nil
 	}
 
 	// Remove the last comma and space
 	query = query[:len(query)-2]
 	query += " WHERE id = $1"
 
-	_, err := bd.pool.Exec(ctx, query, args...)
+	err = bd.withTx(ctx, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return storage.ErrNotFound
+		}
+
+		return bd.recordAudit(ctx, tx, audit.ActionUpdate, audit.EntityUser, user.UUID,
+			audit.UserSnapshot(current), audit.UserSnapshot(updated), audit.SensitiveUserFields...)
+	})
 	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation {
+			// Another user already has this passport
+			return storage.ErrConflict
+		}
+		if errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
 		bd.log.Info("Error updating user data in the database: ", zap.Error(err))
 		return err
 	}
@@ -390,18 +513,20 @@ func (kp *BDKeeper) LoadUsers(ctx context.Context) (storage.StorageUsers, error)
 	sql := `
     SELECT
         id,
-        passportSerie,
-        passportNumber,
-        surname,
-        name,
-        patronymic,
-        address,
+        passport_enc,
+        surname_enc,
+        name_enc,
+        patronymic_enc,
+        address_enc,
         default_end_time,
         timezone,    
         password_hash,
-        last_checked_at
+        last_checked_at,
+        role
     FROM
-        Users`
+        Users
+    WHERE
+        erased_at IS NULL`
 
 	rows, err := kp.pool.Query(ctx, sql)
 	if err != nil {
@@ -417,46 +542,11 @@ func (kp *BDKeeper) LoadUsers(ctx context.Context) (storage.StorageUsers, error)
 	data := make(storage.StorageUsers)
 
 	for rows.Next() {
-		var m models.User
-		var defaultEndTime pq.NullTime
-		var lastCheckedAt pq.NullTime
-		var hashHex *string
-
-		err := rows.Scan(
-			&m.UUID,
-			&m.PassportSerie,
-			&m.PassportNumber,
-			&m.Surname,
-			&m.Name,
-			&m.Patronymic,
-			&m.Address,
-			&defaultEndTime,
-			&m.Timezone,
-			&hashHex,
-			&lastCheckedAt,
-		)
+		m, err := kp.scanUser(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to load users: %w", err)
 		}
 
-		// Decoding a hash from a hex string into bytes if the value is not NULL
-		if hashHex != nil {
-			m.Hash, err = hex.DecodeString(*hashHex)
-			if err != nil {
-				return nil, fmt.Errorf("failed to decode password hash: %w", err)
-			}
-		}
-
-		// Set DefaultEndTime field only if the value from the database is not NULL
-		if defaultEndTime.Valid {
-			m.DefaultEndTime = defaultEndTime.Time
-		}
-
-		// Set LastCheckedAt field only if the value from the database is not NULL
-		if lastCheckedAt.Valid {
-			m.LastCheckedAt = lastCheckedAt.Time
-		}
-
 		data[m.UUID] = m
 	}
 
@@ -464,15 +554,32 @@ func (kp *BDKeeper) LoadUsers(ctx context.Context) (storage.StorageUsers, error)
 }
 
 func (kp *BDKeeper) DeleteUser(ctx context.Context, id int) error {
+	current, err := kp.GetUserByID(ctx, id)
+	if err != nil {
+		return err
+	}
 
 	query := `
         DELETE FROM Users
         WHERE id = $1
     `
 
-	_, err := kp.pool.Exec(ctx, query, id)
+	// Time entries, two-factor settings and identities are removed by ON DELETE CASCADE
+	err = kp.withTx(ctx, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, query, id)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return storage.ErrNotFound
+		}
+
+		return kp.recordAudit(ctx, tx, audit.ActionDelete, audit.EntityUser, id, audit.UserSnapshot(current), nil, audit.SensitiveUserFields...)
+	})
 	if err != nil {
-		kp.log.Info("error deleting user from database: ", zap.Error(err))
+		if !errors.Is(err, storage.ErrNotFound) {
+			kp.log.Info("error deleting user from database: ", zap.Error(err))
+		}
 		return err
 	}
 
@@ -480,7 +587,11 @@ func (kp *BDKeeper) DeleteUser(ctx context.Context, id int) error {
 	return nil
 }
 
-func (kp *BDKeeper) GetNonUpdateUsers(ctx context.Context) ([]models.ExtUserData, error) {
+// ClaimNonUpdateUsers leases up to limit users that haven't been updated within the
+// update interval. FOR UPDATE SKIP LOCKED keeps concurrent claims of several instances
+// apart, and the lease keeps the users from being claimed again until it expires or
+// an enrichment attempt is saved.
+func (kp *BDKeeper) ClaimNonUpdateUsers(ctx context.Context, limit int, lease time.Duration) ([]models.ExtUserData, error) {
 
 	// Read the interval from the environment variable
 	updateInterval, err := time.ParseDuration(kp.userUpdateInterval())
@@ -494,39 +605,40 @@ func (kp *BDKeeper) GetNonUpdateUsers(ctx context.Context) ([]models.ExtUserData
 
 	// Prepare the SQL query
 	sql := `
-    SELECT
-        passportSerie,
-        passportNumber,
-        surname,
-        name,
-        address
-    FROM
-        public.Users
-    WHERE
-        last_checked_at IS NULL OR last_checked_at <= $1
-    LIMIT 100`
-
-	rows, err := kp.pool.Query(ctx, sql, thresholdTime)
+    WITH claimed AS (
+        SELECT id
+        FROM public.Users
+        WHERE
+            passport_enc IS NOT NULL
+            AND (last_checked_at IS NULL OR last_checked_at <= $1)
+            AND (enrichment_leased_until IS NULL OR enrichment_leased_until <= $2)
+            AND ` + enrichableCondition + `
+        ORDER BY id
+        LIMIT $3
+        FOR UPDATE SKIP LOCKED
+    )
+    UPDATE public.Users SET enrichment_leased_until = $4
+    FROM claimed
+    WHERE Users.id = claimed.id
+    RETURNING
+        Users.id,
+        passport_enc,
+        surname_enc,
+        name_enc,
+        address_enc`
+
+	rows, err := kp.pool.Query(ctx, sql, thresholdTime, currentTime, limit, currentTime.Add(lease))
 	if err != nil {
-		return nil, fmt.Errorf("failed to get non-updated users: %w", err)
+		return nil, fmt.Errorf("failed to claim non-updated users: %w", err)
 	}
 	defer rows.Close()
 
 	users := make([]models.ExtUserData, 0)
 
 	for rows.Next() {
-		var user models.ExtUserData
-
-		err := rows.Scan(
-			&user.PassportSerie,
-			&user.PassportNumber,
-			&user.Surname,
-			&user.Name,
-			&user.Address,
-		)
+		user, err := kp.scanExtUserData(rows)
 		if err != nil {
-
-			return nil, fmt.Errorf("failed to scan user: %w", err)
+			return nil, err
 		}
 
 		users = append(users, user)
@@ -537,10 +649,13 @@ func (kp *BDKeeper) GetNonUpdateUsers(ctx context.Context) ([]models.ExtUserData
 		return nil, fmt.Errorf("failed to process rows: %w", err)
 	}
 
+	// RETURNING doesn't keep the order of the claim
+	sort.Slice(users, func(i, j int) bool { return users[i].UserID < users[j].UserID })
+
 	return users, nil
 }
 
-func (bd *BDKeeper) SaveTask(ctx context.Context, task models.Task) (int, error) {
+func (bd *BDKeeper) SaveTask(ctx context.Context, task models.Task) (taskID int, err error) {
 	query := `
         INSERT INTO tasks (
             name, description, created_at
@@ -549,14 +664,20 @@ func (bd *BDKeeper) SaveTask(ctx context.Context, task models.Task) (int, error)
         ) RETURNING id
     `
 
-	var taskID int
-	err := bd.pool.QueryRow(
-		ctx,
-		query,
-		task.Name,
-		task.Description,
-		task.CreatedAt,
-	).Scan(&taskID)
+	err = bd.withTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(
+			ctx,
+			query,
+			task.Name,
+			task.Description,
+			task.CreatedAt,
+		).Scan(&taskID)
+		if err != nil {
+			return err
+		}
+
+		return bd.recordAudit(ctx, tx, audit.ActionCreate, audit.EntityTask, taskID, nil, audit.TaskSnapshot(task))
+	})
 	if err != nil {
 		bd.log.Info("error saving task to database: ", zap.Error(err))
 		return 0, err
@@ -614,11 +735,24 @@ func (kp *BDKeeper) DeleteTask(ctx context.Context, id int) error {
 	query := `
         DELETE FROM tasks
         WHERE id = $1
+        RETURNING name, description
     `
 
-	_, err := kp.pool.Exec(ctx, query, id)
+	err := kp.withTx(ctx, func(tx pgx.Tx) error {
+		var task models.Task
+		if err := tx.QueryRow(ctx, query, id).Scan(&task.Name, &task.Description); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return storage.ErrNotFound
+			}
+			return err
+		}
+
+		return kp.recordAudit(ctx, tx, audit.ActionDelete, audit.EntityTask, id, audit.TaskSnapshot(task), nil)
+	})
 	if err != nil {
-		kp.log.Info("error deleting task from database: ", zap.Error(err))
+		if !errors.Is(err, storage.ErrNotFound) {
+			kp.log.Info("error deleting task from database: ", zap.Error(err))
+		}
 		return err
 	}
 
@@ -626,24 +760,60 @@ func (kp *BDKeeper) DeleteTask(ctx context.Context, id int) error {
 	return nil
 }
 
+// GetTaskByID retrieves a task by its identifier
+func (bd *BDKeeper) GetTaskByID(ctx context.Context, id int) (models.Task, error) {
+	query := `SELECT id, name, COALESCE(description, ''), created_at FROM tasks WHERE id = $1`
+
+	var t models.Task
+	var createdAt *time.Time
+	if err := bd.pool.QueryRow(ctx, query, id).Scan(&t.ID, &t.Name, &t.Description, &createdAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.Task{}, storage.ErrNotFound
+		}
+		return models.Task{}, fmt.Errorf("failed to get task: %w", err)
+	}
+	if createdAt != nil {
+		t.CreatedAt = *createdAt
+	}
+
+	return t, nil
+}
+
 // UpdateTask updates an existing task in the database
 func (bd *BDKeeper) UpdateTask(ctx context.Context, task models.Task) error {
 
+	current := `
+        SELECT name, description FROM tasks
+        WHERE id = $1
+        FOR UPDATE
+    `
+
 	query := `
         UPDATE Tasks SET
             name = $2,
             description = $3              
         WHERE id = $1
     `
-	_, err := bd.pool.Exec(
-		ctx,
-		query,
-		task.ID,
-		task.Name,
-		task.Description,
-	)
+
+	err := bd.withTx(ctx, func(tx pgx.Tx) error {
+		var before models.Task
+		if err := tx.QueryRow(ctx, current, task.ID).Scan(&before.Name, &before.Description); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return storage.ErrNotFound
+			}
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, query, task.ID, task.Name, task.Description); err != nil {
+			return err
+		}
+
+		return bd.recordAudit(ctx, tx, audit.ActionUpdate, audit.EntityTask, task.ID, audit.TaskSnapshot(before), audit.TaskSnapshot(task))
+	})
 	if err != nil {
-		bd.log.Info("Error updating task in the database: ", zap.Error(err))
+		if !errors.Is(err, storage.ErrNotFound) {
+			bd.log.Info("Error updating task in the database: ", zap.Error(err))
+		}
 		return err
 	}
 
@@ -652,7 +822,7 @@ func (bd *BDKeeper) UpdateTask(ctx context.Context, task models.Task) error {
 }
 
 // StartTaskTracking starts tracking time for a task
-func (bd *BDKeeper) StartTaskTracking(ctx context.Context, entry models.TimeEntry) error {
+func (bd *BDKeeper) StartTaskTracking(ctx context.Context, entry models.TimeEntry) (err error) {
 
 	// Convert time taking into account the user's time zone
 	location, err := time.LoadLocation(entry.UserTimezone)
@@ -712,19 +882,31 @@ func (bd *BDKeeper) StartTaskTracking(ctx context.Context, entry models.TimeEntr
 	insertQuery := `
         INSERT INTO user_tasks (user_id, task_id, event_date, start_time)
         VALUES ($1, $2, $3, $4)
+        RETURNING id
     `
-	_, err = tx.Exec(ctx, insertQuery, entry.UserID, entry.TaskID, entry.EventDate, startTime)
+	var entryID int
+	err = tx.QueryRow(ctx, insertQuery, entry.UserID, entry.TaskID, entry.EventDate, startTime).Scan(&entryID)
 	if err != nil {
 		errType := reflect.TypeOf(err)
 		bd.log.Info("error saving task tracking to database: ", zap.String("errorType", errType.String()), zap.Error(err))
 		return err
 	}
 
+	err = bd.recordAudit(ctx, tx, audit.ActionStart, audit.EntityTimeEntry, entryID, nil, map[string]interface{}{
+		"user_id":    entry.UserID,
+		"task_id":    entry.TaskID,
+		"event_date": entry.EventDate.Format("2006-01-02"),
+		"start_time": startTime.Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+
 	bd.log.Info("Task tracking started successfully for user: ", zap.Int("userID", entry.UserID), zap.Int("taskID", entry.TaskID))
 	return nil
 }
 
-func (bd *BDKeeper) StopTaskTracking(ctx context.Context, entry models.TimeEntry) error {
+func (bd *BDKeeper) StopTaskTracking(ctx context.Context, entry models.TimeEntry) (err error) {
 	// Convert time taking into account the user's time zone
 	location, err := time.LoadLocation(entry.UserTimezone)
 	if err != nil {
@@ -795,10 +977,47 @@ func (bd *BDKeeper) StopTaskTracking(ctx context.Context, entry models.TimeEntry
 		return err
 	}
 
+	err = bd.recordAudit(ctx, tx, audit.ActionStop, audit.EntityTimeEntry, id,
+		map[string]interface{}{"end_time": nil},
+		map[string]interface{}{"end_time": endTime.Format(time.RFC3339)})
+	if err != nil {
+		return err
+	}
+
 	bd.log.Info("Task tracking stopped successfully for user: ", zap.Int("userID", entry.UserID), zap.Int("taskID", entry.TaskID))
 	return nil
 }
 
+// GetRunningTimers lists the entries without an end time by user. The start time of
+// day is combined with the date of the entry.
+func (bd *BDKeeper) GetRunningTimers(ctx context.Context) ([]models.RunningTimer, error) {
+	query := `
+        SELECT user_id, task_id, event_date, event_date + start_time
+        FROM user_tasks
+        WHERE end_time IS NULL
+        ORDER BY user_id, id
+    `
+	rows, err := bd.pool.Query(ctx, query)
+	if err != nil {
+		bd.log.Info("error querying running timers: ", zap.Error(err))
+		return nil, err
+	}
+
+	timers, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.RunningTimer, error) {
+		var timer models.RunningTimer
+		var eventDate time.Time
+		err := row.Scan(&timer.UserID, &timer.TaskID, &eventDate, &timer.StartedAt)
+		timer.EventDate = eventDate.Format(time.DateOnly)
+		return timer, err
+	})
+	if err != nil {
+		bd.log.Info("error querying running timers: ", zap.Error(err))
+		return nil, err
+	}
+
+	return timers, nil
+}
+
 func (bd *BDKeeper) GetUserTaskSummary(ctx context.Context, userID int, startDate, endDate time.Time, userTimezone string, defaultEndTime time.Time) ([]models.TaskSummary, error) {
 	location, err := time.LoadLocation(userTimezone)
 	if err != nil {
@@ -0,0 +1,112 @@
+package bdkeeper
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wurt83ow/timetracker/internal/encryption"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"github.com/wurt83ow/timetracker/internal/storage/storagetest"
+	"go.uber.org/zap"
+)
+
+var _ storage.Keeper = (*BDKeeper)(nil)
+
+// testDSNEnv names the PostgreSQL database the conformance suite runs against.
+// All its data is deleted, so it must not be a database anyone uses.
+const testDSNEnv = "TEST_DATABASE_URI"
+
+func TestBDKeeper_Conformance(t *testing.T) {
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Skip(testDSNEnv + " is not set")
+	}
+
+	envelope, err := encryption.NewEnvelope("test_encryption_key")
+	require.NoError(t, err)
+
+	kp := NewBDKeeper(func() string { return dsn }, zap.NewNop(), func() string { return "5m" }, envelope)
+	require.NotNil(t, kp)
+	t.Cleanup(func() { kp.Close() })
+
+	storagetest.Run(t, func(t *testing.T) storage.Keeper {
+		// TRUNCATE does not fire the row trigger that keeps audit_events append-only
+		_, err := kp.pool.Exec(context.Background(), `
+			TRUNCATE Users, tasks, user_tasks, user_two_factor, user_recovery_codes, user_identities, audit_events, enrichment_attempts, jobs, report_subscriptions, webhooks, webhook_deliveries
+			RESTART IDENTITY CASCADE
+		`)
+		require.NoError(t, err)
+
+		return kp
+	})
+}
+
+func TestBDKeeper_CacheSync(t *testing.T) {
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Skip(testDSNEnv + " is not set")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	envelope, err := encryption.NewEnvelope("test_encryption_key")
+	require.NoError(t, err)
+
+	// Two instances with their own connection pools share the database
+	newKeeper := func() *BDKeeper {
+		kp := NewBDKeeper(func() string { return dsn }, zap.NewNop(), func() string { return "5m" }, envelope)
+		require.NotNil(t, kp)
+		t.Cleanup(func() { kp.Close() })
+
+		return kp
+	}
+	follow := func(kp *BDKeeper) *storage.MemoryStorage {
+		s := storage.NewMemoryStorage(ctx, kp, zap.NewNop(), syncOptions{})
+
+		listening := make(chan struct{})
+		go func() {
+			_ = kp.Listen(ctx, func() { close(listening) }, func(change storage.Change) { s.ApplyChange(ctx, change) })
+		}()
+		<-listening
+
+		return s
+	}
+
+	a, b := newKeeper(), newKeeper()
+	_, err = a.pool.Exec(ctx, `TRUNCATE Users, tasks, user_tasks, audit_events RESTART IDENTITY CASCADE`)
+	require.NoError(t, err)
+
+	storageA, storageB := follow(a), follow(b)
+
+	_, err = storageA.InsertTask(ctx, models.Task{Name: "Report"})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		tasks, _, err := storageB.GetTasks(ctx, models.TaskFilter{}, models.Pagination{Limit: 10})
+		return err == nil && len(tasks) == 1 && tasks[0].Name == "Report"
+	}, 5*time.Second, 50*time.Millisecond, "a task created on A is seen by B")
+
+	tasks, _, err := storageB.GetTasks(ctx, models.TaskFilter{}, models.Pagination{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, tasks, 1)
+	require.NoError(t, storageB.UpdateTask(ctx, models.Task{ID: tasks[0].ID, Name: "Review"}))
+	assert.Eventually(t, func() bool {
+		tasks, _, err := storageA.GetTasks(ctx, models.TaskFilter{Name: ptr("Review")}, models.Pagination{Limit: 10})
+		return err == nil && len(tasks) == 1
+	}, 5*time.Second, 50*time.Millisecond, "an update on B is seen by A")
+}
+
+type syncOptions struct{}
+
+func (syncOptions) StorageMode() string { return storage.ModeMirror }
+func (syncOptions) CacheSize() string   { return "" }
+func (syncOptions) CacheTTL() string    { return "" }
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,71 @@
+package bdkeeper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wurt83ow/timetracker/internal/audit"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap"
+)
+
+// changesChannel is the NOTIFY channel of the changes of users and tasks
+const changesChannel = "timetracker_changes"
+
+// Listen applies the changes of users and tasks made by all instances sharing the database
+// until ctx is done or the connection fails. Once a keeper listens, it also publishes its
+// own changes, so every instance has to listen for the caches to stay coherent.
+func (bd *BDKeeper) Listen(ctx context.Context, ready func(), apply func(storage.Change)) error {
+	bd.notify.Store(true)
+
+	pooled, err := bd.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to listen for changes: %w", err)
+	}
+
+	// The listening connection never goes back to the pool
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+changesChannel); err != nil {
+		return fmt.Errorf("failed to listen for changes: %w", err)
+	}
+
+	ready()
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to wait for changes: %w", err)
+		}
+
+		var change storage.Change
+		if err := json.Unmarshal([]byte(notification.Payload), &change); err != nil {
+			bd.log.Info("cannot decode change: ", zap.String("payload", notification.Payload), zap.Error(err))
+			continue
+		}
+
+		apply(change)
+	}
+}
+
+// publish notifies the listeners of a change of a user or a task. PostgreSQL delivers
+// the notification when tx commits, and drops it if tx rolls back.
+func (bd *BDKeeper) publish(ctx context.Context, tx pgx.Tx, entityType string, entityID int) error {
+	if !bd.notify.Load() || (entityType != audit.EntityUser && entityType != audit.EntityTask) {
+		return nil
+	}
+
+	payload, err := json.Marshal(storage.Change{Entity: entityType, ID: entityID})
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, "SELECT pg_notify($1, $2)", changesChannel, string(payload))
+	return err
+}
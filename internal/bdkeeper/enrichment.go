@@ -0,0 +1,235 @@
+package bdkeeper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wurt83ow/timetracker/internal/audit"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap"
+)
+
+// enrichmentColumns is the column list read by scanEnrichmentAttempt
+const enrichmentColumns = `user_id, status, attempts, COALESCE(last_error, ''), next_retry_at, updated_at`
+
+// enrichableCondition selects the users whose enrichment state lets them be enriched
+const enrichableCondition = `NOT EXISTS (
+            SELECT 1 FROM enrichment_attempts a
+            WHERE a.user_id = Users.id
+            AND (a.status = 'dead' OR (a.status = 'failed' AND a.next_retry_at > CURRENT_TIMESTAMP))
+        )`
+
+// GetEnrichmentAttempt retrieves the enrichment state of a user
+func (bd *BDKeeper) GetEnrichmentAttempt(ctx context.Context, userID int) (models.EnrichmentAttempt, error) {
+	query := `SELECT ` + enrichmentColumns + ` FROM enrichment_attempts WHERE user_id = $1`
+
+	a, err := scanEnrichmentAttempt(bd.pool.QueryRow(ctx, query, userID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.EnrichmentAttempt{}, storage.ErrNotFound
+		}
+		bd.log.Info("error retrieving enrichment attempt from database: ", zap.Error(err))
+		return models.EnrichmentAttempt{}, err
+	}
+
+	return a, nil
+}
+
+// SaveEnrichmentAttempts creates or replaces the enrichment states, skipping deleted users.
+// The attempt ends the enrichment lease of the user.
+func (bd *BDKeeper) SaveEnrichmentAttempts(ctx context.Context, attempts []models.EnrichmentAttempt) error {
+	if len(attempts) == 0 {
+		return nil
+	}
+
+	query := `
+        INSERT INTO enrichment_attempts (user_id, status, attempts, last_error, next_retry_at, updated_at)
+        SELECT $1, $2, $3, NULLIF($4, ''), $5, $6
+        WHERE EXISTS (SELECT 1 FROM Users WHERE id = $1)
+        ON CONFLICT (user_id) DO UPDATE SET
+            status = EXCLUDED.status,
+            attempts = EXCLUDED.attempts,
+            last_error = EXCLUDED.last_error,
+            next_retry_at = EXCLUDED.next_retry_at,
+            updated_at = EXCLUDED.updated_at
+    `
+
+	release := `UPDATE Users SET enrichment_leased_until = NULL WHERE id = $1`
+
+	// The statements of a batch run in one implicit transaction
+	batch := &pgx.Batch{}
+	for _, a := range attempts {
+		batch.Queue(query, a.UserID, a.Status, a.Attempts, a.LastError, a.NextRetryAt, a.UpdatedAt)
+		batch.Queue(release, a.UserID)
+	}
+
+	if err := bd.pool.SendBatch(ctx, batch).Close(); err != nil {
+		bd.log.Info("error saving enrichment attempts to database: ", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// GetEnrichmentAttempts lists the enrichment states with the statuses, or all of them,
+// most recently updated first
+func (bd *BDKeeper) GetEnrichmentAttempts(ctx context.Context, statuses []string, limit int) ([]models.EnrichmentAttempt, error) {
+	query := `
+        SELECT ` + enrichmentColumns + `
+        FROM enrichment_attempts
+        WHERE cardinality($1::text[]) = 0 OR status = ANY($1)
+        ORDER BY updated_at DESC, user_id
+        LIMIT $2
+    `
+
+	if statuses == nil {
+		statuses = []string{}
+	}
+
+	rows, err := bd.pool.Query(ctx, query, statuses, limit)
+	if err != nil {
+		bd.log.Info("error retrieving enrichment attempts from database: ", zap.Error(err))
+		return nil, err
+	}
+
+	attempts, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.EnrichmentAttempt, error) {
+		return scanEnrichmentAttempt(row)
+	})
+	if err != nil {
+		bd.log.Info("error retrieving enrichment attempts from database: ", zap.Error(err))
+		return nil, err
+	}
+
+	return attempts, nil
+}
+
+// CountEnrichmentAttempts counts the enrichment states by status
+func (bd *BDKeeper) CountEnrichmentAttempts(ctx context.Context) (map[string]int, error) {
+	rows, err := bd.pool.Query(ctx, `SELECT status, COUNT(*) FROM enrichment_attempts GROUP BY status`)
+	if err != nil {
+		bd.log.Info("error counting enrichment attempts in database: ", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+
+	return counts, rows.Err()
+}
+
+// ResyncUser forgets the enrichment state of a user, so that the next pass enriches it
+func (bd *BDKeeper) ResyncUser(ctx context.Context, userID int) error {
+	err := bd.withTx(ctx, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `UPDATE Users SET last_checked_at = NULL WHERE id = $1 AND erased_at IS NULL`, userID)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return storage.ErrNotFound
+		}
+
+		if _, err := tx.Exec(ctx, `DELETE FROM enrichment_attempts WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+
+		return bd.recordAudit(ctx, tx, audit.ActionResync, audit.EntityUser, userID, nil, nil)
+	})
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			bd.log.Info("error resyncing user in database: ", zap.Error(err))
+		}
+		return err
+	}
+
+	return nil
+}
+
+// ClaimUser leases a user for enrichment regardless of when it was checked, e.g.
+// right after it registered. A user leased already is a conflict.
+func (bd *BDKeeper) ClaimUser(ctx context.Context, userID int, lease time.Duration) (models.ExtUserData, error) {
+	now := time.Now().UTC()
+
+	var user models.ExtUserData
+	err := bd.withTx(ctx, func(tx pgx.Tx) error {
+		row := tx.QueryRow(ctx, `
+            UPDATE Users SET enrichment_leased_until = $3
+            WHERE id = $1 AND erased_at IS NULL AND passport_enc IS NOT NULL
+            AND (enrichment_leased_until IS NULL OR enrichment_leased_until <= $2)
+            RETURNING id, passport_enc, surname_enc, name_enc, address_enc
+        `, userID, now, now.Add(lease))
+
+		var err error
+		user, err = bd.scanExtUserData(row)
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return err
+		}
+
+		var exists bool
+		if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM Users WHERE id = $1 AND erased_at IS NULL)`, userID).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return storage.ErrConflict
+		}
+		return storage.ErrNotFound
+	})
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) && !errors.Is(err, storage.ErrConflict) {
+			bd.log.Info("error claiming user in database: ", zap.Error(err))
+		}
+		return models.ExtUserData{}, err
+	}
+
+	return user, nil
+}
+
+// scanExtUserData reads and decrypts the id, passport_enc, surname_enc, name_enc
+// and address_enc columns
+func (bd *BDKeeper) scanExtUserData(row pgx.Row) (models.ExtUserData, error) {
+	var passport, surname, name, address *string
+
+	var user models.ExtUserData
+	if err := row.Scan(&user.UserID, &passport, &surname, &name, &address); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return user, err
+		}
+		return user, fmt.Errorf("failed to scan user: %w", err)
+	}
+
+	plaintext, err := bd.open(passport)
+	if err != nil {
+		return user, err
+	}
+	if user.PassportSerie, user.PassportNumber, err = parsePassportKey(plaintext); err != nil {
+		return user, err
+	}
+	if user.Surname, err = bd.open(surname); err != nil {
+		return user, err
+	}
+	if user.Name, err = bd.open(name); err != nil {
+		return user, err
+	}
+	if user.Address, err = bd.open(address); err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
+
+func scanEnrichmentAttempt(row pgx.Row) (models.EnrichmentAttempt, error) {
+	var a models.EnrichmentAttempt
+	err := row.Scan(&a.UserID, &a.Status, &a.Attempts, &a.LastError, &a.NextRetryAt, &a.UpdatedAt)
+	return a, err
+}
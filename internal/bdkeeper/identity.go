@@ -0,0 +1,76 @@
+package bdkeeper
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/wurt83ow/timetracker/internal/audit"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap"
+)
+
+// pgUniqueViolation is the PostgreSQL error code for unique constraint violations
+const pgUniqueViolation = "23505"
+
+// GetUserByIdentity retrieves the user linked to an identity provider subject
+func (bd *BDKeeper) GetUserByIdentity(ctx context.Context, issuer, subject string) (models.User, error) {
+	query := `
+		SELECT
+			u.id,
+			u.passport_enc,
+			u.surname_enc,
+			u.name_enc,
+			u.patronymic_enc,
+			u.address_enc,
+			u.default_end_time,
+			u.timezone,
+			u.password_hash,
+			u.last_checked_at,
+			u.role
+		FROM Users u
+		JOIN user_identities i ON i.user_id = u.id
+		WHERE i.issuer = $1 AND i.subject = $2
+	`
+
+	user, err := bd.scanUser(bd.pool.QueryRow(ctx, query, issuer, subject))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.User{}, storage.ErrNotFound
+		}
+		bd.log.Info("error retrieving user by identity from database: ", zap.Error(err))
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+// LinkIdentity links an identity provider subject to a user
+func (bd *BDKeeper) LinkIdentity(ctx context.Context, identity models.UserIdentity) error {
+	query := `
+        INSERT INTO user_identities (user_id, issuer, subject, email)
+        VALUES ($1, $2, $3, $4)
+    `
+
+	err := bd.withTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, query, identity.UserID, identity.Issuer, identity.Subject, identity.Email); err != nil {
+			return err
+		}
+
+		return bd.recordAudit(ctx, tx, audit.ActionLink, audit.EntityIdentity, identity.UserID, nil,
+			map[string]interface{}{"issuer": identity.Issuer, "subject": identity.Subject, "email": identity.Email}, "email")
+	})
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation {
+			return storage.ErrConflict
+		}
+		bd.log.Info("error linking identity in database: ", zap.Error(err))
+		return err
+	}
+
+	bd.log.Info("Identity linked successfully: ", zap.Int("userID", identity.UserID), zap.String("issuer", identity.Issuer))
+	return nil
+}
@@ -0,0 +1,141 @@
+package bdkeeper
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap"
+)
+
+// jobColumns is the column list read by scanJob
+const jobColumns = `id, kind, COALESCE(job_key, ''), payload, status, attempts, run_at, locked_until, COALESCE(last_error, ''), created_at`
+
+// EnqueueJob adds a job to the queue, to run once RunAt has come. A job with the
+// Key of a job that is not done yet is a conflict.
+func (bd *BDKeeper) EnqueueJob(ctx context.Context, job models.Job) (int64, error) {
+	query := `
+        INSERT INTO jobs (kind, job_key, payload, run_at)
+        VALUES ($1, NULLIF($2, ''), $3, $4)
+        RETURNING id
+    `
+
+	runAt := job.RunAt
+	if runAt.IsZero() {
+		runAt = time.Now()
+	}
+
+	payload := []byte(job.Payload)
+	if len(payload) == 0 {
+		payload = []byte("{}")
+	}
+
+	var id int64
+	if err := bd.pool.QueryRow(ctx, query, job.Kind, job.Key, payload, runAt.UTC()).Scan(&id); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation {
+			return 0, storage.ErrConflict
+		}
+		bd.log.Info("error enqueuing job to database: ", zap.Error(err))
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// ClaimJobs locks up to limit jobs that are due, or whose lock has expired, for the
+// visibility timeout and counts the attempt. The rows locked by other instances are
+// skipped, so that every job is claimed by one of them.
+func (bd *BDKeeper) ClaimJobs(ctx context.Context, limit int, visibility time.Duration) ([]models.Job, error) {
+	query := `
+        WITH claimed AS (
+            SELECT id AS claimed_id FROM jobs
+            WHERE (status = 'queued' AND run_at <= $1)
+            OR (status = 'running' AND locked_until <= $1)
+            ORDER BY run_at, id
+            LIMIT $2
+            FOR UPDATE SKIP LOCKED
+        )
+        UPDATE jobs SET status = 'running', attempts = attempts + 1, locked_until = $3
+        FROM claimed
+        WHERE id = claimed_id
+        RETURNING ` + jobColumns
+
+	now := time.Now().UTC()
+	rows, err := bd.pool.Query(ctx, query, now, limit, now.Add(visibility))
+	if err != nil {
+		bd.log.Info("error claiming jobs in database: ", zap.Error(err))
+		return nil, err
+	}
+
+	jobs, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.Job, error) {
+		return scanJob(row)
+	})
+	if err != nil {
+		bd.log.Info("error claiming jobs in database: ", zap.Error(err))
+		return nil, err
+	}
+
+	// RETURNING doesn't keep the order of the subquery
+	sortJobs(jobs)
+
+	return jobs, nil
+}
+
+// CompleteJob removes a finished job from the queue
+func (bd *BDKeeper) CompleteJob(ctx context.Context, id int64) error {
+	return bd.execJob(ctx, "completing", `DELETE FROM jobs WHERE id = $1`, id)
+}
+
+// RetryJob puts a claimed job back in the queue to run at runAt, with the attempts it has made
+func (bd *BDKeeper) RetryJob(ctx context.Context, id int64, attempts int, runAt time.Time, lastError string) error {
+	query := `
+        UPDATE jobs SET status = 'queued', attempts = $2, run_at = $3, locked_until = NULL, last_error = NULLIF($4, '')
+        WHERE id = $1
+    `
+	return bd.execJob(ctx, "retrying", query, id, attempts, runAt.UTC(), lastError)
+}
+
+// BuryJob marks a job that ran out of attempts as dead, keeping it for inspection
+func (bd *BDKeeper) BuryJob(ctx context.Context, id int64, lastError string) error {
+	query := `UPDATE jobs SET status = 'dead', locked_until = NULL, last_error = NULLIF($2, '') WHERE id = $1`
+	return bd.execJob(ctx, "burying", query, id, lastError)
+}
+
+// execJob runs a statement changing the job with the id, a missing job is not found
+func (bd *BDKeeper) execJob(ctx context.Context, action, query string, id int64, args ...any) error {
+	tag, err := bd.pool.Exec(ctx, query, append([]any{id}, args...)...)
+	if err != nil {
+		bd.log.Info("error "+action+" job in database: ", zap.Error(err))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+func scanJob(row pgx.Row) (models.Job, error) {
+	var job models.Job
+	var payload []byte
+	err := row.Scan(&job.ID, &job.Kind, &job.Key, &payload, &job.Status, &job.Attempts,
+		&job.RunAt, &job.LockedUntil, &job.LastError, &job.CreatedAt)
+	job.Payload = payload
+	return job, err
+}
+
+// sortJobs orders the jobs as they were queued
+func sortJobs(jobs []models.Job) {
+	sort.Slice(jobs, func(i, j int) bool {
+		if !jobs[i].RunAt.Equal(jobs[j].RunAt) {
+			return jobs[i].RunAt.Before(jobs[j].RunAt)
+		}
+		return jobs[i].ID < jobs[j].ID
+	})
+}
@@ -0,0 +1,278 @@
+package bdkeeper
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+)
+
+// maxDecryptedUsers caps the users decrypted by a single list or search request. Surnames,
+// names, patronymics, addresses and single passport parts are encrypted and can only be
+// matched after every user is decrypted, which is a scan of the whole table.
+const maxDecryptedUsers = 10000
+
+// userSortColumns are the SQL expressions of the user sort fields that are stored in clear
+var userSortColumns = map[string]string{
+	"id":       "id",
+	"timezone": `COALESCE(timezone, '') COLLATE "C"`,
+}
+
+const selectUsers = `
+		SELECT
+			id,
+			passport_enc,
+			surname_enc,
+			name_enc,
+			patronymic_enc,
+			address_enc,
+			default_end_time,
+			timezone,
+			password_hash,
+			last_checked_at,
+			role
+		FROM Users`
+
+// GetUsers returns a page of users matching the filter and the number of all matching
+// users. Only the passport as a whole (by the blind index) and the timezone are filtered
+// in SQL. When the filter or the sort needs other fields, they are matched and sorted
+// after decryption, and more than maxDecryptedUsers candidates fail with storage.ErrTooManyUsers.
+func (bd *BDKeeper) GetUsers(ctx context.Context, filter models.Filter, pagination models.Pagination) ([]models.User, int, error) {
+	where := " WHERE erased_at IS NULL"
+	var args []interface{}
+
+	// The blind index covers the whole passport
+	passport := filter.PassportSerie != nil && filter.PassportNumber != nil
+	if passport {
+		args = append(args, bd.passportIndex(*filter.PassportSerie, *filter.PassportNumber))
+		where += " AND passport_bidx = $" + strconv.Itoa(len(args))
+	}
+	if filter.Timezone != nil {
+		args = append(args, *filter.Timezone)
+		where += " AND strpos(timezone, $" + strconv.Itoa(len(args)) + ") > 0"
+	}
+
+	if !passport && (filter.PassportSerie != nil || filter.PassportNumber != nil) ||
+		filter.Surname != nil || filter.Name != nil || filter.Patronymic != nil || filter.Address != nil ||
+		!sortsBy(userSortColumns, pagination.Sort) {
+		return bd.getDecryptedUsers(ctx, where, args, filter, pagination)
+	}
+
+	var total int
+	if err := bd.pool.QueryRow(ctx, "SELECT COUNT(*) FROM Users"+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count users: %w", err)
+	}
+
+	query := selectUsers + where
+	offset := max(pagination.Offset, 0)
+	if after := pagination.After; after != nil {
+		var condition string
+		condition, args = keyset(userSortColumns, pagination.Sort, after, args)
+		query += " AND " + condition
+		offset = 0
+	}
+
+	args = append(args, max(pagination.Limit, 0), offset)
+	query += orderBy(userSortColumns, pagination.Sort) +
+		" LIMIT $" + strconv.Itoa(len(args)-1) + " OFFSET $" + strconv.Itoa(len(args))
+
+	rows, err := bd.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get users: %w", err)
+	}
+	defer rows.Close()
+
+	users := make([]models.User, 0)
+	for rows.Next() {
+		user, err := bd.scanUser(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to get users: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to get users: %w", err)
+	}
+
+	return users, total, nil
+}
+
+// getDecryptedUsers decrypts the users selected by the SQL part of the filter,
+// then matches the rest of the filter and pages them in memory
+func (bd *BDKeeper) getDecryptedUsers(ctx context.Context, where string, args []interface{},
+	filter models.Filter, pagination models.Pagination,
+) ([]models.User, int, error) {
+	args = append(args, maxDecryptedUsers+1)
+	rows, err := bd.pool.Query(ctx, selectUsers+where+" ORDER BY id LIMIT $"+strconv.Itoa(len(args)), args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get users: %w", err)
+	}
+	defer rows.Close()
+
+	users := make([]models.User, 0)
+	scanned := 0
+	for rows.Next() {
+		if scanned++; scanned > maxDecryptedUsers {
+			return nil, 0, storage.ErrTooManyUsers
+		}
+
+		user, err := bd.scanUser(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to get users: %w", err)
+		}
+
+		if storage.MatchUser(user, filter) {
+			users = append(users, user)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to get users: %w", err)
+	}
+
+	users, total := storage.PageUsers(users, pagination)
+	return users, total, nil
+}
+
+// taskSortColumns are the SQL expressions of the task sort fields. Strings are compared
+// bytewise and missing values sort like zero values, as in the memory storage.
+var taskSortColumns = map[string]string{
+	"id":          "id",
+	"name":        `name COLLATE "C"`,
+	"description": `COALESCE(description, '') COLLATE "C"`,
+	"created_at":  "COALESCE(created_at, '0001-01-01 00:00:00+00')",
+}
+
+// GetTasks returns a page of tasks matching the filter, sorted as the pagination asks,
+// and the number of all matching tasks
+func (bd *BDKeeper) GetTasks(ctx context.Context, filter models.TaskFilter, pagination models.Pagination) ([]models.Task, int, error) {
+	where := " WHERE TRUE"
+	var args []interface{}
+
+	if filter.Name != nil {
+		args = append(args, *filter.Name)
+		where += " AND strpos(name, $" + strconv.Itoa(len(args)) + ") > 0"
+	}
+	if filter.Description != nil {
+		args = append(args, *filter.Description)
+		where += " AND strpos(COALESCE(description, ''), $" + strconv.Itoa(len(args)) + ") > 0"
+	}
+
+	var total int
+	if err := bd.pool.QueryRow(ctx, "SELECT COUNT(*) FROM tasks"+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count tasks: %w", err)
+	}
+
+	query := "SELECT id, name, COALESCE(description, ''), created_at FROM tasks" + where
+	offset := max(pagination.Offset, 0)
+	if after := pagination.After; after != nil {
+		cursor := *after
+		cursor.Values = make([]interface{}, len(after.Values))
+		for i, f := range pagination.Sort {
+			value, err := taskCursorValue(f.Field, after.Values[i])
+			if err != nil {
+				return nil, 0, fmt.Errorf("failed to get tasks: %w", err)
+			}
+			cursor.Values[i] = value
+		}
+
+		var condition string
+		condition, args = keyset(taskSortColumns, pagination.Sort, &cursor, args)
+		query += " AND " + condition
+		offset = 0
+	}
+
+	args = append(args, max(pagination.Limit, 0), offset)
+	query += orderBy(taskSortColumns, pagination.Sort) +
+		" LIMIT $" + strconv.Itoa(len(args)-1) + " OFFSET $" + strconv.Itoa(len(args))
+
+	rows, err := bd.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get tasks: %w", err)
+	}
+	defer rows.Close()
+
+	tasks := make([]models.Task, 0)
+	for rows.Next() {
+		var t models.Task
+		var createdAt *time.Time
+		if err := rows.Scan(&t.ID, &t.Name, &t.Description, &createdAt); err != nil {
+			return nil, 0, fmt.Errorf("failed to get tasks: %w", err)
+		}
+		if createdAt != nil {
+			t.CreatedAt = *createdAt
+		}
+		tasks = append(tasks, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to get tasks: %w", err)
+	}
+
+	return tasks, total, nil
+}
+
+// taskCursorValue converts a cursor value to the type of its column
+func taskCursorValue(field string, value interface{}) (interface{}, error) {
+	if field != "created_at" {
+		return value, nil
+	}
+
+	s, _ := value.(string)
+	return time.Parse(storage.SortTimeLayout, s)
+}
+
+// sortsBy reports whether every sort field has a SQL expression
+func sortsBy(columns map[string]string, sort []models.SortField) bool {
+	for _, f := range sort {
+		if _, ok := columns[f.Field]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// orderBy is the ORDER BY clause of the sort, ties are broken by id
+func orderBy(columns map[string]string, sort []models.SortField) string {
+	order := make([]string, 0, len(sort)+1)
+	for _, f := range sort {
+		order = append(order, columns[f.Field]+direction(f.Desc))
+	}
+	order = append(order, "id")
+
+	return " ORDER BY " + strings.Join(order, ", ")
+}
+
+// keyset is the condition that continues a list after the cursor, appended to the arguments:
+// (a > $1) OR (a = $1 AND b > $2) OR ... OR (a = $1 AND b = $2 AND id > $3)
+func keyset(columns map[string]string, sort []models.SortField, after *models.Cursor, args []interface{}) (string, []interface{}) {
+	var keys []string
+	equal := ""
+	for i, f := range sort {
+		args = append(args, after.Values[i])
+		placeholder := "$" + strconv.Itoa(len(args))
+
+		op := " > "
+		if f.Desc {
+			op = " < "
+		}
+		keys = append(keys, "("+equal+columns[f.Field]+op+placeholder+")")
+		equal += columns[f.Field] + " = " + placeholder + " AND "
+	}
+	args = append(args, after.ID)
+	keys = append(keys, "("+equal+"id > $"+strconv.Itoa(len(args))+")")
+
+	return "(" + strings.Join(keys, " OR ") + ")", args
+}
+
+func direction(desc bool) string {
+	if desc {
+		return " DESC"
+	}
+	return ""
+}
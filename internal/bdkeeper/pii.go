@@ -0,0 +1,269 @@
+package bdkeeper
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lib/pq"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"go.uber.org/zap"
+)
+
+// FieldCipher encrypts personal data stored in the Users table
+type FieldCipher interface {
+	Seal(string) (string, error)
+	Open(string) (string, error)
+	BlindIndex(string) string
+}
+
+// passportKey is the canonical form of a passport, as used for login
+func passportKey(passportSerie, passportNumber int) string {
+	return fmt.Sprintf("%d %d", passportSerie, passportNumber)
+}
+
+// parsePassportKey splits a value produced by passportKey
+func parsePassportKey(value string) (int, int, error) {
+	parts := strings.Fields(value)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid passport value")
+	}
+
+	passportSerie, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid passport serie: %w", err)
+	}
+
+	passportNumber, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid passport number: %w", err)
+	}
+
+	return passportSerie, passportNumber, nil
+}
+
+// passportIndex returns the blind index used to look up a user by passport
+func (bd *BDKeeper) passportIndex(passportSerie, passportNumber int) string {
+	return bd.cipher.BlindIndex(passportKey(passportSerie, passportNumber))
+}
+
+// seal encrypts a value, empty values are stored as NULL
+func (bd *BDKeeper) seal(value string) (*string, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	sealed, err := bd.cipher.Seal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt user data: %w", err)
+	}
+
+	return &sealed, nil
+}
+
+// open decrypts a value written by seal
+func (bd *BDKeeper) open(value *string) (string, error) {
+	if value == nil {
+		return "", nil
+	}
+
+	plaintext, err := bd.cipher.Open(*value)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt user data: %w", err)
+	}
+
+	return plaintext, nil
+}
+
+// sealedUser holds the encrypted personal data of a user
+type sealedUser struct {
+	passport, surname, name, patronymic, address *string
+}
+
+func (bd *BDKeeper) sealUser(user models.User) (sealedUser, error) {
+	var sealed sealedUser
+	var err error
+
+	if sealed.passport, err = bd.seal(passportKey(user.PassportSerie, user.PassportNumber)); err != nil {
+		return sealedUser{}, err
+	}
+	if sealed.surname, err = bd.seal(user.Surname); err != nil {
+		return sealedUser{}, err
+	}
+	if sealed.name, err = bd.seal(user.Name); err != nil {
+		return sealedUser{}, err
+	}
+	if sealed.patronymic, err = bd.seal(user.Patronymic); err != nil {
+		return sealedUser{}, err
+	}
+	if sealed.address, err = bd.seal(user.Address); err != nil {
+		return sealedUser{}, err
+	}
+
+	return sealed, nil
+}
+
+// scanUser reads a Users row selected with the standard encrypted column list
+func (bd *BDKeeper) scanUser(row pgx.Row) (models.User, error) {
+	var user models.User
+	var sealed sealedUser
+	var defaultEndTime pq.NullTime
+	var lastCheckedAt pq.NullTime
+	var hashHex *string
+
+	err := row.Scan(
+		&user.UUID,
+		&sealed.passport,
+		&sealed.surname,
+		&sealed.name,
+		&sealed.patronymic,
+		&sealed.address,
+		&defaultEndTime,
+		&user.Timezone,
+		&hashHex,
+		&lastCheckedAt,
+		&user.Role,
+	)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	passport, err := bd.open(sealed.passport)
+	if err != nil {
+		return models.User{}, err
+	}
+	if passport != "" {
+		if user.PassportSerie, user.PassportNumber, err = parsePassportKey(passport); err != nil {
+			return models.User{}, err
+		}
+	}
+	if user.Surname, err = bd.open(sealed.surname); err != nil {
+		return models.User{}, err
+	}
+	if user.Name, err = bd.open(sealed.name); err != nil {
+		return models.User{}, err
+	}
+	if user.Patronymic, err = bd.open(sealed.patronymic); err != nil {
+		return models.User{}, err
+	}
+	if user.Address, err = bd.open(sealed.address); err != nil {
+		return models.User{}, err
+	}
+
+	// Decoding the hash from hex string to bytes, if the value is not NULL
+	if hashHex != nil {
+		user.Hash, err = hex.DecodeString(*hashHex)
+		if err != nil {
+			return models.User{}, fmt.Errorf("failed to decode password hash: %w", err)
+		}
+	}
+
+	if defaultEndTime.Valid {
+		user.DefaultEndTime = defaultEndTime.Time
+	}
+
+	if lastCheckedAt.Valid {
+		user.LastCheckedAt = lastCheckedAt.Time
+	}
+
+	return user, nil
+}
+
+// encryptPlaintextUsers moves personal data written before encryption was
+// introduced into the encrypted columns and clears the plaintext copies
+func (bd *BDKeeper) encryptPlaintextUsers(ctx context.Context) (err error) {
+	query := `
+		SELECT id, passportSerie, passportNumber, surname, name, patronymic, address
+		FROM Users
+		WHERE passport_bidx IS NULL AND passportSerie IS NOT NULL
+		FOR UPDATE
+	`
+
+	tx, err := bd.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+			return
+		}
+		err = tx.Commit(ctx)
+	}()
+
+	rows, err := tx.Query(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	type plaintextUser struct {
+		id                                 int
+		passportSerie, passportNumber      int
+		surname, name, patronymic, address *string
+	}
+
+	var users []plaintextUser
+	for rows.Next() {
+		var u plaintextUser
+		if err = rows.Scan(&u.id, &u.passportSerie, &u.passportNumber, &u.surname, &u.name, &u.patronymic, &u.address); err != nil {
+			rows.Close()
+			return err
+		}
+		users = append(users, u)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	update := `
+		UPDATE Users SET
+			passport_bidx = $2,
+			passport_enc = $3,
+			surname_enc = $4,
+			name_enc = $5,
+			patronymic_enc = $6,
+			address_enc = $7,
+			passportSerie = NULL,
+			passportNumber = NULL,
+			surname = NULL,
+			name = NULL,
+			patronymic = NULL,
+			address = NULL
+		WHERE id = $1
+	`
+
+	for _, u := range users {
+		args := []interface{}{u.id, bd.passportIndex(u.passportSerie, u.passportNumber)}
+		for _, value := range []string{
+			passportKey(u.passportSerie, u.passportNumber),
+			deref(u.surname), deref(u.name), deref(u.patronymic), deref(u.address),
+		} {
+			sealed, sealErr := bd.seal(value)
+			if sealErr != nil {
+				return sealErr
+			}
+			args = append(args, sealed)
+		}
+
+		if _, err = tx.Exec(ctx, update, args...); err != nil {
+			return err
+		}
+	}
+
+	if len(users) > 0 {
+		bd.log.Info("Encrypted personal data of existing users", zap.Int("count", len(users)))
+	}
+
+	return nil
+}
+
+func deref(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
@@ -0,0 +1,219 @@
+package bdkeeper
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wurt83ow/timetracker/internal/audit"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap"
+)
+
+// ExportUser collects the personal data of a user from a single snapshot of the database
+func (bd *BDKeeper) ExportUser(ctx context.Context, userID int) (export models.UserExport, err error) {
+	tx, err := bd.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		bd.log.Info("Error while beginning transaction: ", zap.Error(err))
+		return models.UserExport{}, err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	profile := `
+		SELECT
+			id,
+			passport_enc,
+			surname_enc,
+			name_enc,
+			patronymic_enc,
+			address_enc,
+			default_end_time,
+			timezone,
+			password_hash,
+			last_checked_at,
+			role
+		FROM Users
+		WHERE id = $1 AND erased_at IS NULL
+	`
+
+	export.Profile, err = bd.scanUser(tx.QueryRow(ctx, profile, userID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.UserExport{}, storage.ErrNotFound
+		}
+		bd.log.Info("error retrieving user for export: ", zap.Error(err))
+		return models.UserExport{}, err
+	}
+
+	if export.TimeEntries, err = exportTimeEntries(ctx, tx, userID); err != nil {
+		bd.log.Info("error retrieving time entries for export: ", zap.Error(err))
+		return models.UserExport{}, err
+	}
+
+	if export.Tokens, err = exportTokens(ctx, tx, userID); err != nil {
+		bd.log.Info("error retrieving tokens for export: ", zap.Error(err))
+		return models.UserExport{}, err
+	}
+
+	if export.Audit, err = exportAudit(ctx, tx, userID); err != nil {
+		bd.log.Info("error retrieving audit events for export: ", zap.Error(err))
+		return models.UserExport{}, err
+	}
+
+	return export, nil
+}
+
+func exportTimeEntries(ctx context.Context, tx pgx.Tx, userID int) ([]models.TimeEntryRecord, error) {
+	query := `
+		SELECT
+			ut.id,
+			ut.task_id,
+			COALESCE(t.name, ''),
+			COALESCE(ut.event_date::text, ''),
+			COALESCE(ut.start_time::text, ''),
+			COALESCE(ut.end_time::text, '')
+		FROM user_tasks ut
+		LEFT JOIN tasks t ON t.id = ut.task_id
+		WHERE ut.user_id = $1
+		ORDER BY ut.event_date, ut.start_time, ut.id
+	`
+
+	rows, err := tx.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]models.TimeEntryRecord, 0)
+	for rows.Next() {
+		var e models.TimeEntryRecord
+		if err := rows.Scan(&e.ID, &e.TaskID, &e.TaskName, &e.EventDate, &e.StartTime, &e.EndTime); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+// exportTokens lists the credentials of a user. JWTs are stateless and not stored.
+func exportTokens(ctx context.Context, tx pgx.Tx, userID int) ([]models.TokenMetadata, error) {
+	query := `
+		SELECT 'totp', '', '', '', enabled, created_at, confirmed_at
+		FROM user_two_factor
+		WHERE user_id = $1
+		UNION ALL
+		SELECT 'recovery_code', '', '', '', used_at IS NULL, NULL, used_at
+		FROM user_recovery_codes
+		WHERE user_id = $1
+		UNION ALL
+		SELECT 'oidc', issuer, subject, COALESCE(email, ''), TRUE, created_at, NULL
+		FROM user_identities
+		WHERE user_id = $1
+	`
+
+	rows, err := tx.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]models.TokenMetadata, 0)
+	for rows.Next() {
+		var t models.TokenMetadata
+		var createdAt, usedAt *time.Time
+		if err := rows.Scan(&t.Type, &t.Issuer, &t.Subject, &t.Email, &t.Enabled, &createdAt, &usedAt); err != nil {
+			return nil, err
+		}
+		t.CreatedAt = createdAt
+		t.UsedAt = usedAt
+		tokens = append(tokens, t)
+	}
+
+	return tokens, rows.Err()
+}
+
+// EraseUser removes the personal data and credentials of a user. The user row
+// and the time entries stay, so that aggregated reports do not change.
+func (bd *BDKeeper) EraseUser(ctx context.Context, userID int) error {
+	// The current values record which fields the erasure changed, the lock keeps
+	// a concurrent update from changing them before the erasure
+	current := `
+		SELECT
+			id,
+			passport_enc,
+			surname_enc,
+			name_enc,
+			patronymic_enc,
+			address_enc,
+			default_end_time,
+			timezone,
+			password_hash,
+			last_checked_at,
+			role
+		FROM Users
+		WHERE id = $1 AND erased_at IS NULL
+		FOR UPDATE
+	`
+
+	anonymize := `
+		UPDATE Users SET
+			passport_bidx = NULL,
+			passport_enc = NULL,
+			surname_enc = NULL,
+			name_enc = NULL,
+			patronymic_enc = NULL,
+			address_enc = NULL,
+			passportSerie = NULL,
+			passportNumber = NULL,
+			surname = NULL,
+			name = NULL,
+			patronymic = NULL,
+			address = NULL,
+			password_hash = NULL,
+			erased_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`
+
+	err := bd.withTx(ctx, func(tx pgx.Tx) error {
+		before, err := bd.scanUser(tx.QueryRow(ctx, current, userID))
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return storage.ErrNotFound
+			}
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, anonymize, userID); err != nil {
+			return err
+		}
+
+		for _, query := range []string{
+			`DELETE FROM user_recovery_codes WHERE user_id = $1`,
+			`DELETE FROM user_two_factor WHERE user_id = $1`,
+			`DELETE FROM user_identities WHERE user_id = $1`,
+			`DELETE FROM enrichment_attempts WHERE user_id = $1`,
+		} {
+			if _, err := tx.Exec(ctx, query, userID); err != nil {
+				return err
+			}
+		}
+
+		return bd.recordAudit(ctx, tx, audit.ActionErase, audit.EntityUser, userID,
+			audit.UserSnapshot(before), audit.ErasedUserSnapshot(before),
+			audit.SensitiveUserFields...)
+	})
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			bd.log.Info("error erasing user in database: ", zap.Error(err))
+		}
+		return err
+	}
+
+	bd.log.Info("User erased successfully", zap.Int("id", userID))
+	return nil
+}
@@ -0,0 +1,103 @@
+package bdkeeper
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap"
+)
+
+// reportColumns is the column list read by scanReportSubscription
+const reportColumns = `id, user_id, schedule, report_type, recipients, format, timezone, last_sent_at, created_at`
+
+// SaveReportSubscription creates a report subscription and returns its id
+func (bd *BDKeeper) SaveReportSubscription(ctx context.Context, sub models.ReportSubscription) (int, error) {
+	query := `
+        INSERT INTO report_subscriptions (user_id, schedule, report_type, recipients, format, timezone)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id
+    `
+
+	var id int
+	err := bd.pool.QueryRow(ctx, query, sub.UserID, sub.Schedule, sub.ReportType, sub.Recipients, sub.Format, sub.Timezone).Scan(&id)
+	if err != nil {
+		bd.log.Info("error saving report subscription to database: ", zap.Error(err))
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// GetReportSubscription retrieves a report subscription by its id
+func (bd *BDKeeper) GetReportSubscription(ctx context.Context, id int) (models.ReportSubscription, error) {
+	query := `SELECT ` + reportColumns + ` FROM report_subscriptions WHERE id = $1`
+
+	sub, err := scanReportSubscription(bd.pool.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.ReportSubscription{}, storage.ErrNotFound
+		}
+		bd.log.Info("error retrieving report subscription from database: ", zap.Error(err))
+		return models.ReportSubscription{}, err
+	}
+
+	return sub, nil
+}
+
+// GetReportSubscriptions lists the report subscriptions in the order they were created
+func (bd *BDKeeper) GetReportSubscriptions(ctx context.Context) ([]models.ReportSubscription, error) {
+	rows, err := bd.pool.Query(ctx, `SELECT `+reportColumns+` FROM report_subscriptions ORDER BY id`)
+	if err != nil {
+		bd.log.Info("error retrieving report subscriptions from database: ", zap.Error(err))
+		return nil, err
+	}
+
+	subs, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.ReportSubscription, error) {
+		return scanReportSubscription(row)
+	})
+	if err != nil {
+		bd.log.Info("error retrieving report subscriptions from database: ", zap.Error(err))
+		return nil, err
+	}
+
+	return subs, nil
+}
+
+// DeleteReportSubscription deletes a report subscription, its queued reports are not sent
+func (bd *BDKeeper) DeleteReportSubscription(ctx context.Context, id int) error {
+	tag, err := bd.pool.Exec(ctx, `DELETE FROM report_subscriptions WHERE id = $1`, id)
+	if err != nil {
+		bd.log.Info("error deleting report subscription from database: ", zap.Error(err))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+// MarkReportSent records when the report of a subscription was last sent
+func (bd *BDKeeper) MarkReportSent(ctx context.Context, id int, sentAt time.Time) error {
+	tag, err := bd.pool.Exec(ctx, `UPDATE report_subscriptions SET last_sent_at = $2 WHERE id = $1`, id, sentAt.UTC())
+	if err != nil {
+		bd.log.Info("error marking report sent in database: ", zap.Error(err))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+func scanReportSubscription(row pgx.Row) (models.ReportSubscription, error) {
+	var sub models.ReportSubscription
+	err := row.Scan(&sub.ID, &sub.UserID, &sub.Schedule, &sub.ReportType, &sub.Recipients,
+		&sub.Format, &sub.Timezone, &sub.LastSentAt, &sub.CreatedAt)
+	return sub, err
+}
@@ -0,0 +1,77 @@
+package bdkeeper
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/search"
+	"go.uber.org/zap"
+)
+
+// Search returns the tasks and users best matching the query. Tasks are found
+// through the GIN index of their tsvector. Personal data is encrypted, so users
+// are scored after decryption, and only the first maxDecryptedUsers users by id
+// are searched.
+func (bd *BDKeeper) Search(ctx context.Context, query string, limit int) ([]models.SearchResult, error) {
+	terms := search.Terms(query)
+	results := make([]models.SearchResult, 0)
+	if len(terms) == 0 {
+		return results, nil
+	}
+
+	// The weights of ts_rank ({D, C, B, A}) follow search.WeightText and search.WeightName
+	rows, err := bd.pool.Query(ctx, `
+		SELECT id, name, COALESCE(description, '')
+		FROM tasks, to_tsquery('simple', $1) query
+		WHERE search @@ query
+		ORDER BY ts_rank('{0, 0, 0.4, 1.0}', search, query) DESC, id
+		LIMIT $2`, search.TSQuery(terms), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search tasks: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t models.Task
+		if err := rows.Scan(&t.ID, &t.Name, &t.Description); err != nil {
+			return nil, fmt.Errorf("failed to search tasks: %w", err)
+		}
+		if rank := search.Score(terms, search.TaskFields(t)...); rank > 0 {
+			results = append(results, search.TaskResult(t, rank))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to search tasks: %w", err)
+	}
+
+	users, err := bd.pool.Query(ctx, selectUsers+`
+		WHERE erased_at IS NULL
+		ORDER BY id
+		LIMIT $1`, maxDecryptedUsers+1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search users: %w", err)
+	}
+	defer users.Close()
+
+	scanned := 0
+	for users.Next() {
+		if scanned++; scanned > maxDecryptedUsers {
+			bd.log.Info("user search truncated", zap.Int("users", maxDecryptedUsers))
+			break
+		}
+
+		user, err := bd.scanUser(users)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search users: %w", err)
+		}
+		if rank := search.Score(terms, search.UserFields(user)...); rank > 0 {
+			results = append(results, search.UserResult(user, rank))
+		}
+	}
+	if err := users.Err(); err != nil {
+		return nil, fmt.Errorf("failed to search users: %w", err)
+	}
+
+	return search.Top(results, limit), nil
+}
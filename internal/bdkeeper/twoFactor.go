@@ -0,0 +1,270 @@
+package bdkeeper
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lib/pq"
+	"github.com/wurt83ow/timetracker/internal/audit"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap"
+)
+
+// GetTwoFactor retrieves the two-factor settings of a user
+func (bd *BDKeeper) GetTwoFactor(ctx context.Context, userID int) (models.TwoFactor, error) {
+	query := `
+        SELECT user_id, secret, enabled, last_used_step, created_at, confirmed_at, failed_attempts, locked_until
+        FROM user_two_factor
+        WHERE user_id = $1
+    `
+
+	var tf models.TwoFactor
+	var createdAt, confirmedAt, lockedUntil pq.NullTime
+
+	err := bd.pool.QueryRow(ctx, query, userID).Scan(
+		&tf.UserID,
+		&tf.Secret,
+		&tf.Enabled,
+		&tf.LastUsedStep,
+		&createdAt,
+		&confirmedAt,
+		&tf.FailedAttempts,
+		&lockedUntil,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.TwoFactor{}, storage.ErrNotFound
+		}
+		bd.log.Info("error retrieving two-factor settings from database: ", zap.Error(err))
+		return models.TwoFactor{}, err
+	}
+
+	if createdAt.Valid {
+		tf.CreatedAt = createdAt.Time
+	}
+	if confirmedAt.Valid {
+		tf.ConfirmedAt = confirmedAt.Time
+	}
+	if lockedUntil.Valid {
+		tf.LockedUntil = lockedUntil.Time
+	}
+
+	return tf, nil
+}
+
+// SaveTwoFactor creates or replaces the two-factor settings of a user
+func (bd *BDKeeper) SaveTwoFactor(ctx context.Context, tf models.TwoFactor) error {
+	current := `
+        SELECT enabled, secret FROM user_two_factor
+        WHERE user_id = $1
+        FOR UPDATE
+    `
+
+	query := `
+        INSERT INTO user_two_factor (user_id, secret, enabled, last_used_step, created_at, confirmed_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        ON CONFLICT (user_id) DO UPDATE SET
+            secret = EXCLUDED.secret,
+            enabled = EXCLUDED.enabled,
+            last_used_step = EXCLUDED.last_used_step,
+            created_at = EXCLUDED.created_at,
+            confirmed_at = EXCLUDED.confirmed_at
+    `
+
+	var confirmedAt pq.NullTime
+	if !tf.ConfirmedAt.IsZero() {
+		confirmedAt = pq.NullTime{Time: tf.ConfirmedAt, Valid: true}
+	}
+
+	after := map[string]interface{}{"enabled": tf.Enabled, "secret": tf.Secret}
+
+	err := bd.withTx(ctx, func(tx pgx.Tx) error {
+		var before map[string]interface{}
+		var enabled bool
+		var secret string
+		switch err := tx.QueryRow(ctx, current, tf.UserID).Scan(&enabled, &secret); {
+		case err == nil:
+			before = map[string]interface{}{"enabled": enabled, "secret": secret}
+		case !errors.Is(err, pgx.ErrNoRows):
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, query, tf.UserID, tf.Secret, tf.Enabled, tf.LastUsedStep, tf.CreatedAt, confirmedAt); err != nil {
+			return err
+		}
+
+		// Saving the last used step on every verification is not worth an audit event
+		if before != nil && enabled == tf.Enabled && secret == tf.Secret {
+			return nil
+		}
+
+		action := audit.ActionCreate
+		if before != nil {
+			action = audit.ActionUpdate
+		}
+		return bd.recordAudit(ctx, tx, action, audit.EntityTwoFactor, tf.UserID, before, after, "secret")
+	})
+	if err != nil {
+		bd.log.Info("error saving two-factor settings to database: ", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// UseTOTPStep records the time step of an accepted code and clears the failed
+// attempts. A step that is not after the last used one is rejected with ErrNotFound,
+// so a code can't be used twice even by concurrent requests.
+func (bd *BDKeeper) UseTOTPStep(ctx context.Context, userID int, step int64) error {
+	query := `
+        UPDATE user_two_factor
+        SET last_used_step = $1, failed_attempts = 0
+        WHERE user_id = $2 AND last_used_step < $1
+    `
+
+	tag, err := bd.pool.Exec(ctx, query, step, userID)
+	if err != nil {
+		bd.log.Info("error saving the used totp step: ", zap.Error(err))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+// FailTwoFactor counts a wrong code and locks the verification out until lockUntil
+// once there are limit wrong codes in a row. It returns the number of wrong codes.
+func (bd *BDKeeper) FailTwoFactor(ctx context.Context, userID int, limit int, lockUntil time.Time) (int, error) {
+	query := `
+        UPDATE user_two_factor
+        SET failed_attempts = failed_attempts + 1,
+            locked_until = CASE WHEN failed_attempts + 1 >= $2 THEN $3 ELSE locked_until END
+        WHERE user_id = $1
+        RETURNING failed_attempts
+    `
+
+	var failures int
+	err := bd.pool.QueryRow(ctx, query, userID, limit, lockUntil).Scan(&failures)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, storage.ErrNotFound
+		}
+		bd.log.Info("error counting a failed second factor: ", zap.Error(err))
+		return 0, err
+	}
+
+	return failures, nil
+}
+
+// DeleteTwoFactor removes the two-factor settings and recovery codes of a user
+func (bd *BDKeeper) DeleteTwoFactor(ctx context.Context, userID int) (err error) {
+	tx, err := bd.pool.Begin(ctx)
+	if err != nil {
+		bd.log.Info("Error while beginning transaction: ", zap.Error(err))
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+			bd.log.Info("Transaction rolled back: ", zap.Error(err))
+		} else {
+			err = tx.Commit(ctx)
+		}
+	}()
+
+	if _, err = tx.Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		bd.log.Info("error deleting recovery codes from database: ", zap.Error(err))
+		return err
+	}
+
+	tag, err := tx.Exec(ctx, `DELETE FROM user_two_factor WHERE user_id = $1`, userID)
+	if err != nil {
+		bd.log.Info("error deleting two-factor settings from database: ", zap.Error(err))
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		err = storage.ErrNotFound
+		return err
+	}
+
+	err = bd.recordAudit(ctx, tx, audit.ActionDelete, audit.EntityTwoFactor, userID, map[string]interface{}{"enabled": true}, nil)
+	return err
+}
+
+// SaveRecoveryCodes replaces the recovery code hashes of a user
+func (bd *BDKeeper) SaveRecoveryCodes(ctx context.Context, userID int, hashes []string) (err error) {
+	tx, err := bd.pool.Begin(ctx)
+	if err != nil {
+		bd.log.Info("Error while beginning transaction: ", zap.Error(err))
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+			bd.log.Info("Transaction rolled back: ", zap.Error(err))
+		} else {
+			err = tx.Commit(ctx)
+		}
+	}()
+
+	if _, err = tx.Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		bd.log.Info("error deleting recovery codes from database: ", zap.Error(err))
+		return err
+	}
+
+	query := `
+        INSERT INTO user_recovery_codes (user_id, code_hash)
+        SELECT $1, unnest($2::text[])
+    `
+	if _, err = tx.Exec(ctx, query, userID, hashes); err != nil {
+		bd.log.Info("error saving recovery codes to database: ", zap.Error(err))
+		return err
+	}
+
+	// Only the number of codes is recorded, never their hashes
+	err = bd.recordAudit(ctx, tx, audit.ActionUpdate, audit.EntityTwoFactor, userID,
+		map[string]interface{}{}, map[string]interface{}{"recovery_codes": len(hashes)})
+	return err
+}
+
+// UseRecoveryCode marks an unused recovery code as used and clears the failed attempts
+func (bd *BDKeeper) UseRecoveryCode(ctx context.Context, userID int, hash string) error {
+	query := `
+        UPDATE user_recovery_codes
+        SET used_at = CURRENT_TIMESTAMP
+        WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+    `
+
+	err := bd.withTx(ctx, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, query, userID, hash)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return storage.ErrNotFound
+		}
+
+		if _, err := tx.Exec(ctx, `UPDATE user_two_factor SET failed_attempts = 0 WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+
+		return bd.recordAudit(ctx, tx, audit.ActionUpdate, audit.EntityTwoFactor, userID,
+			map[string]interface{}{}, map[string]interface{}{"recovery_code_used": true})
+	})
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			bd.log.Info("error using recovery code: ", zap.Error(err))
+		}
+		return err
+	}
+
+	return nil
+}
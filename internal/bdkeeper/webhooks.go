@@ -0,0 +1,178 @@
+package bdkeeper
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	// webhookColumns is the column list read by scanWebhook
+	webhookColumns = `id, user_id, url, events, secret, created_at`
+	// deliveryColumns is the column list read by scanWebhookDelivery
+	deliveryColumns = `id, webhook_id, event_type, payload, status, attempts, COALESCE(response_status, 0), COALESCE(last_error, ''), created_at, delivered_at`
+)
+
+// SaveWebhook creates a webhook and returns its id
+func (bd *BDKeeper) SaveWebhook(ctx context.Context, hook models.Webhook) (int, error) {
+	query := `
+        INSERT INTO webhooks (user_id, url, events, secret)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id
+    `
+
+	var id int
+	if err := bd.pool.QueryRow(ctx, query, hook.UserID, hook.URL, hook.Events, hook.Secret).Scan(&id); err != nil {
+		bd.log.Info("error saving webhook to database: ", zap.Error(err))
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// GetWebhook retrieves a webhook by its id
+func (bd *BDKeeper) GetWebhook(ctx context.Context, id int) (models.Webhook, error) {
+	query := `SELECT ` + webhookColumns + ` FROM webhooks WHERE id = $1`
+
+	hook, err := scanWebhook(bd.pool.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.Webhook{}, storage.ErrNotFound
+		}
+		bd.log.Info("error retrieving webhook from database: ", zap.Error(err))
+		return models.Webhook{}, err
+	}
+
+	return hook, nil
+}
+
+// GetWebhooks lists the webhooks in the order they were created
+func (bd *BDKeeper) GetWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	rows, err := bd.pool.Query(ctx, `SELECT `+webhookColumns+` FROM webhooks ORDER BY id`)
+	if err != nil {
+		bd.log.Info("error retrieving webhooks from database: ", zap.Error(err))
+		return nil, err
+	}
+
+	hooks, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.Webhook, error) {
+		return scanWebhook(row)
+	})
+	if err != nil {
+		bd.log.Info("error retrieving webhooks from database: ", zap.Error(err))
+		return nil, err
+	}
+
+	return hooks, nil
+}
+
+// DeleteWebhook deletes a webhook, its deliveries are deleted by the cascade
+func (bd *BDKeeper) DeleteWebhook(ctx context.Context, id int) error {
+	tag, err := bd.pool.Exec(ctx, `DELETE FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		bd.log.Info("error deleting webhook from database: ", zap.Error(err))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+// SaveWebhookDelivery adds a pending delivery of a webhook and returns its id. A
+// missing webhook is not found.
+func (bd *BDKeeper) SaveWebhookDelivery(ctx context.Context, d models.WebhookDelivery) (int64, error) {
+	query := `
+        INSERT INTO webhook_deliveries (webhook_id, event_type, payload)
+        SELECT id, $2, $3 FROM webhooks WHERE id = $1
+        RETURNING id
+    `
+
+	var id int64
+	err := bd.pool.QueryRow(ctx, query, d.WebhookID, d.EventType, []byte(d.Payload)).Scan(&id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, storage.ErrNotFound
+		}
+		bd.log.Info("error saving webhook delivery to database: ", zap.Error(err))
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// GetWebhookDelivery retrieves a delivery by its id
+func (bd *BDKeeper) GetWebhookDelivery(ctx context.Context, id int64) (models.WebhookDelivery, error) {
+	query := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries WHERE id = $1`
+
+	d, err := scanWebhookDelivery(bd.pool.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.WebhookDelivery{}, storage.ErrNotFound
+		}
+		bd.log.Info("error retrieving webhook delivery from database: ", zap.Error(err))
+		return models.WebhookDelivery{}, err
+	}
+
+	return d, nil
+}
+
+// GetWebhookDeliveries lists up to limit deliveries of a webhook, the most recent first
+func (bd *BDKeeper) GetWebhookDeliveries(ctx context.Context, webhookID int, limit int) ([]models.WebhookDelivery, error) {
+	query := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY id DESC LIMIT $2`
+
+	rows, err := bd.pool.Query(ctx, query, webhookID, limit)
+	if err != nil {
+		bd.log.Info("error retrieving webhook deliveries from database: ", zap.Error(err))
+		return nil, err
+	}
+
+	deliveries, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.WebhookDelivery, error) {
+		return scanWebhookDelivery(row)
+	})
+	if err != nil {
+		bd.log.Info("error retrieving webhook deliveries from database: ", zap.Error(err))
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// UpdateWebhookDelivery records the outcome of an attempt of a delivery
+func (bd *BDKeeper) UpdateWebhookDelivery(ctx context.Context, d models.WebhookDelivery) error {
+	query := `
+        UPDATE webhook_deliveries
+        SET status = $2, attempts = $3, response_status = NULLIF($4, 0), last_error = NULLIF($5, ''), delivered_at = $6
+        WHERE id = $1
+    `
+
+	tag, err := bd.pool.Exec(ctx, query, d.ID, d.Status, d.Attempts, d.ResponseStatus, d.LastError, d.DeliveredAt)
+	if err != nil {
+		bd.log.Info("error updating webhook delivery in database: ", zap.Error(err))
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+func scanWebhook(row pgx.Row) (models.Webhook, error) {
+	var hook models.Webhook
+	err := row.Scan(&hook.ID, &hook.UserID, &hook.URL, &hook.Events, &hook.Secret, &hook.CreatedAt)
+	return hook, err
+}
+
+func scanWebhookDelivery(row pgx.Row) (models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	var payload []byte
+	err := row.Scan(&d.ID, &d.WebhookID, &d.EventType, &payload, &d.Status, &d.Attempts,
+		&d.ResponseStatus, &d.LastError, &d.CreatedAt, &d.DeliveredAt)
+	d.Payload = payload
+	return d, err
+}
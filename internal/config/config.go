@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -12,7 +13,26 @@ import (
 type Options struct {
 	flagRunAddr, flagLogLevel, flagDataBaseDSN,
 	flagJWTSigningKey, flagConcurrency, flagTaskExecutionInterval,
-	flagUserUpdateInterval, flagDefaultEndTime, flagApiSystemAddress string
+	flagUserUpdateInterval, flagDefaultEndTime, flagApiSystemAddress,
+	flagEncryptionKey, flagEncryptionKeyFile, flagTOTPIssuer,
+	flagOIDCIssuer, flagOIDCClientID, flagOIDCClientSecret,
+	flagOIDCRedirectURL, flagOIDCPassportClaim, flagSnapshotFile,
+	flagStorageMode, flagCacheSize, flagCacheTTL, flagCacheSync,
+	flagAPISystemTimeout, flagAPISystemRetries, flagAPISystemRetryDelay,
+	flagAPISystemRetryMaxDelay, flagAPISystemBreakerThreshold,
+	flagAPISystemBreakerCooldown, flagEnrichmentProviders,
+	flagEnrichmentPrecedence, flagEnrichmentHTTPFields,
+	flagEnrichmentFile, flagEnrichmentMaxAttempts,
+	flagEnrichmentRetryDelay, flagEnrichmentRetryMaxDelay,
+	flagEnrichmentBatchSize, flagEnrichmentLease,
+	flagWorkerQueueSize, flagWorkerPriorities,
+	flagWorkerKindConcurrency, flagWorkerRateLimits,
+	flagJobPollInterval, flagJobVisibilityTimeout,
+	flagJobMaxAttempts, flagJobRetryDelay, flagJobBatchSize,
+	flagSMTPAddress, flagSMTPUsername, flagSMTPPassword,
+	flagSMTPFrom, flagSMTPTLS, flagSMTPTimeout,
+	flagWebhookTimeout, flagStreamBufferSize,
+	flagStreamHeartbeatInterval string
 }
 
 func NewOptions() *Options {
@@ -28,6 +48,24 @@ func (o *Options) ParseFlags() {
 	// Override variable values with values from command line flags
 	regStringVar(&o.flagRunAddr, "a", getEnvOrDefault("RUN_ADDRESS", ":8080"), "address and port to run server")
 	regStringVar(&o.flagConcurrency, "c", getEnvOrDefault("CONCURRENCY", "5"), "Concurrency")
+	regStringVar(&o.flagWorkerQueueSize, "worker-queue-size", getEnvOrDefault("WORKER_QUEUE_SIZE", "1000"), "number of background tasks of a kind queued before new ones are rejected")
+	regStringVar(&o.flagWorkerPriorities, "worker-priorities", getEnvOrDefault("WORKER_PRIORITIES", ""), "priorities of the background task kinds, e.g. enrichment=1,export=-1")
+	regStringVar(&o.flagWorkerKindConcurrency, "worker-kind-concurrency", getEnvOrDefault("WORKER_KIND_CONCURRENCY", ""), "maximum running background tasks per kind, e.g. export=1")
+	regStringVar(&o.flagWorkerRateLimits, "worker-rate-limits", getEnvOrDefault("WORKER_RATE_LIMITS", ""), "maximum rate of background tasks per kind, e.g. enrichment=10/s")
+	regStringVar(&o.flagJobPollInterval, "job-poll-interval", getEnvOrDefault("JOB_POLL_INTERVAL", "1s"), "interval between polls of the background job queue")
+	regStringVar(&o.flagJobVisibilityTimeout, "job-visibility-timeout", getEnvOrDefault("JOB_VISIBILITY_TIMEOUT", "5m"), "time a claimed job is hidden from other instances, and the longest it may run")
+	regStringVar(&o.flagJobMaxAttempts, "job-max-attempts", getEnvOrDefault("JOB_MAX_ATTEMPTS", "5"), "failed runs of a background job before it is marked dead")
+	regStringVar(&o.flagJobRetryDelay, "job-retry-delay", getEnvOrDefault("JOB_RETRY_DELAY", "10s"), "delay before retrying a failed job, doubled with every failure")
+	regStringVar(&o.flagJobBatchSize, "job-batch-size", getEnvOrDefault("JOB_BATCH_SIZE", "10"), "maximum number of jobs run at once by the instance")
+	regStringVar(&o.flagSMTPAddress, "smtp-address", getEnvOrDefault("SMTP_ADDRESS", ""), "host:port of the SMTP server sending the reports, none if empty")
+	regStringVar(&o.flagSMTPUsername, "smtp-username", getEnvOrDefault("SMTP_USERNAME", ""), "SMTP username, no authentication if empty")
+	regStringVar(&o.flagSMTPPassword, "smtp-password", getEnvOrDefault("SMTP_PASSWORD", ""), "SMTP password")
+	regStringVar(&o.flagSMTPFrom, "smtp-from", getEnvOrDefault("SMTP_FROM", "TimeTracker <timetracker@localhost>"), "sender of the reports")
+	regStringVar(&o.flagSMTPTLS, "smtp-tls", getEnvOrDefault("SMTP_TLS", "starttls"), "TLS of the SMTP connection: starttls, tls or none")
+	regStringVar(&o.flagSMTPTimeout, "smtp-timeout", getEnvOrDefault("SMTP_TIMEOUT", "30s"), "timeout of sending an email")
+	regStringVar(&o.flagWebhookTimeout, "webhook-timeout", getEnvOrDefault("WEBHOOK_TIMEOUT", "10s"), "timeout of a webhook delivery request")
+	regStringVar(&o.flagStreamBufferSize, "stream-buffer-size", getEnvOrDefault("STREAM_BUFFER_SIZE", "1000"), "number of recent events kept for the event streams to resume from")
+	regStringVar(&o.flagStreamHeartbeatInterval, "stream-heartbeat-interval", getEnvOrDefault("STREAM_HEARTBEAT_INTERVAL", "15s"), "interval between the heartbeats of an idle event stream")
 	regStringVar(&o.flagDataBaseDSN, "d", getEnvOrDefault("DATABASE_URI", ""), "")
 	regStringVar(&o.flagTaskExecutionInterval, "i", getEnvOrDefault("TASK_EXECUTION_INTERVAL", "3000"), "Task execution interval in milliseconds")
 	regStringVar(&o.flagJWTSigningKey, "j", getEnvOrDefault("JWT_SIGNING_KEY", "test_key"), "jwt signing key")
@@ -35,9 +73,47 @@ func (o *Options) ParseFlags() {
 	regStringVar(&o.flagUserUpdateInterval, "u", getEnvOrDefault("USER_UPDATE_INTERVAL", "5m"), "user update interval")
 	regStringVar(&o.flagDefaultEndTime, "e", getEnvOrDefault("DEFAULT_END_TIME", "19:00"), "default end time")
 	regStringVar(&o.flagApiSystemAddress, "s", getEnvOrDefault("API_SYSTEM_ADDRESS", "localhost:8081"), "API system address")
+	regStringVar(&o.flagAPISystemTimeout, "api-timeout", getEnvOrDefault("API_SYSTEM_TIMEOUT", "5s"), "timeout of a single request to the API system")
+	regStringVar(&o.flagAPISystemRetries, "api-retries", getEnvOrDefault("API_SYSTEM_RETRIES", "3"), "retries of a failed request to the API system")
+	regStringVar(&o.flagAPISystemRetryDelay, "api-retry-delay", getEnvOrDefault("API_SYSTEM_RETRY_DELAY", "200ms"), "base delay of the exponential backoff between retries")
+	regStringVar(&o.flagAPISystemRetryMaxDelay, "api-retry-max-delay", getEnvOrDefault("API_SYSTEM_RETRY_MAX_DELAY", "5s"), "maximum delay between retries")
+	regStringVar(&o.flagAPISystemBreakerThreshold, "api-breaker-threshold", getEnvOrDefault("API_SYSTEM_BREAKER_THRESHOLD", "5"), "consecutive failures that open the circuit breaker")
+	regStringVar(&o.flagAPISystemBreakerCooldown, "api-breaker-cooldown", getEnvOrDefault("API_SYSTEM_BREAKER_COOLDOWN", "30s"), "time the open circuit breaker rejects requests before a probe")
+	regStringVar(&o.flagEnrichmentProviders, "enrichment-providers", getEnvOrDefault("ENRICHMENT_PROVIDERS", "http"), "enrichment providers merged in precedence order, a|b falls back from a to b")
+	regStringVar(&o.flagEnrichmentPrecedence, "enrichment-precedence", getEnvOrDefault("ENRICHMENT_PRECEDENCE", ""), "per-field provider precedence, e.g. address=file,http;patronymic=file")
+	regStringVar(&o.flagEnrichmentHTTPFields, "enrichment-http-fields", getEnvOrDefault("ENRICHMENT_HTTP_FIELDS", "surname,name,patronymic,address"), "fields owned by the API system provider")
+	regStringVar(&o.flagEnrichmentFile, "enrichment-file", getEnvOrDefault("ENRICHMENT_FILE", ""), "CSV or JSON directory file of the file enrichment provider")
+	regStringVar(&o.flagEnrichmentMaxAttempts, "enrichment-max-attempts", getEnvOrDefault("ENRICHMENT_MAX_ATTEMPTS", "5"), "failed enrichments of a user before it is moved to the dead letters")
+	regStringVar(&o.flagEnrichmentRetryDelay, "enrichment-retry-delay", getEnvOrDefault("ENRICHMENT_RETRY_DELAY", "1m"), "delay before retrying a failed enrichment, doubled with every failure")
+	regStringVar(&o.flagEnrichmentRetryMaxDelay, "enrichment-retry-max-delay", getEnvOrDefault("ENRICHMENT_RETRY_MAX_DELAY", "1h"), "maximum delay before retrying a failed enrichment")
+	regStringVar(&o.flagEnrichmentBatchSize, "enrichment-batch-size", getEnvOrDefault("ENRICHMENT_BATCH_SIZE", "100"), "maximum number of users being enriched at once by the instance")
+	regStringVar(&o.flagEnrichmentLease, "enrichment-lease", getEnvOrDefault("ENRICHMENT_LEASE", "5m"), "time a claimed user is kept from other enrichments")
+	regStringVar(&o.flagEncryptionKey, "k", getEnvOrDefault("ENCRYPTION_KEY", ""), "key for encrypting secrets at rest, required unless ENCRYPTION_KEY_FILE is set")
+	regStringVar(&o.flagEncryptionKeyFile, "key-file", getEnvOrDefault("ENCRYPTION_KEY_FILE", ""), "file with the key for encrypting data at rest, overrides ENCRYPTION_KEY")
+	regStringVar(&o.flagTOTPIssuer, "t", getEnvOrDefault("TOTP_ISSUER", "TimeTracker"), "issuer shown in authenticator apps")
+	regStringVar(&o.flagOIDCIssuer, "oidc-issuer", getEnvOrDefault("OIDC_ISSUER", ""), "OpenID Connect issuer URL, empty disables OIDC login")
+	regStringVar(&o.flagOIDCClientID, "oidc-client-id", getEnvOrDefault("OIDC_CLIENT_ID", ""), "OpenID Connect client ID")
+	regStringVar(&o.flagOIDCClientSecret, "oidc-client-secret", getEnvOrDefault("OIDC_CLIENT_SECRET", ""), "OpenID Connect client secret")
+	regStringVar(&o.flagOIDCRedirectURL, "oidc-redirect-url", getEnvOrDefault("OIDC_REDIRECT_URL", "http://localhost:8080/api/auth/oidc/callback"), "OpenID Connect redirect URL")
+	regStringVar(&o.flagSnapshotFile, "snapshot-file", getEnvOrDefault("SNAPSHOT_FILE", ""), "JSON file the in-memory keeper is saved to when DATABASE_URI is empty")
+	regStringVar(&o.flagStorageMode, "storage-mode", getEnvOrDefault("STORAGE_MODE", "mirror"), "mirror keeps all users and tasks in memory, cache keeps recently used ones")
+	regStringVar(&o.flagCacheSize, "cache-size", getEnvOrDefault("CACHE_SIZE", "1000"), "maximum number of cached users and of cached tasks in the cache mode")
+	regStringVar(&o.flagCacheTTL, "cache-ttl", getEnvOrDefault("CACHE_TTL", "5m"), "time to live of cached users and tasks in the cache mode")
+	regStringVar(&o.flagCacheSync, "cache-sync", getEnvOrDefault("CACHE_SYNC", "false"), "apply the changes of other instances sharing the PostgreSQL database, set on every instance")
+	regStringVar(&o.flagOIDCPassportClaim, "oidc-passport-claim", getEnvOrDefault("OIDC_PASSPORT_CLAIM", "passport"), "ID token claim with the passport series and number")
 
 	// parse the arguments passed to the server into registered variables
 	flag.Parse()
+
+	// A key file keeps the encryption key out of the environment
+	if o.flagEncryptionKeyFile != "" {
+		o.flagEncryptionKey = readKeyFile(o.flagEncryptionKeyFile)
+	}
+
+	// There is no default key: data encrypted with a well-known key is not protected
+	if o.flagEncryptionKey == "" {
+		log.Fatal("ENCRYPTION_KEY or ENCRYPTION_KEY_FILE must be set")
+	}
 }
 
 func (o *Options) RunAddr() string {
@@ -60,6 +136,78 @@ func (o *Options) Concurrency() string {
 	return o.flagConcurrency
 }
 
+func (o *Options) WorkerQueueSize() string {
+	return o.flagWorkerQueueSize
+}
+
+func (o *Options) WorkerPriorities() string {
+	return o.flagWorkerPriorities
+}
+
+func (o *Options) WorkerKindConcurrency() string {
+	return o.flagWorkerKindConcurrency
+}
+
+func (o *Options) WorkerRateLimits() string {
+	return o.flagWorkerRateLimits
+}
+
+func (o *Options) JobPollInterval() string {
+	return o.flagJobPollInterval
+}
+
+func (o *Options) JobVisibilityTimeout() string {
+	return o.flagJobVisibilityTimeout
+}
+
+func (o *Options) JobMaxAttempts() string {
+	return o.flagJobMaxAttempts
+}
+
+func (o *Options) JobRetryDelay() string {
+	return o.flagJobRetryDelay
+}
+
+func (o *Options) JobBatchSize() string {
+	return o.flagJobBatchSize
+}
+
+func (o *Options) SMTPAddress() string {
+	return o.flagSMTPAddress
+}
+
+func (o *Options) SMTPUsername() string {
+	return o.flagSMTPUsername
+}
+
+func (o *Options) SMTPPassword() string {
+	return o.flagSMTPPassword
+}
+
+func (o *Options) SMTPFrom() string {
+	return o.flagSMTPFrom
+}
+
+func (o *Options) SMTPTLS() string {
+	return o.flagSMTPTLS
+}
+
+func (o *Options) SMTPTimeout() string {
+	return o.flagSMTPTimeout
+}
+
+func (o *Options) WebhookTimeout() string {
+	return o.flagWebhookTimeout
+}
+
+func (o *Options) StreamBufferSize() string {
+	return o.flagStreamBufferSize
+}
+
+func (o *Options) StreamHeartbeatInterval() string {
+	return o.flagStreamHeartbeatInterval
+}
+
 func (o *Options) TaskExecutionInterval() string {
 	return o.flagTaskExecutionInterval
 }
@@ -76,6 +224,114 @@ func (o *Options) ApiSystemAddress() string {
 	return o.flagApiSystemAddress
 }
 
+func (o *Options) APISystemTimeout() string {
+	return o.flagAPISystemTimeout
+}
+
+func (o *Options) APISystemRetries() string {
+	return o.flagAPISystemRetries
+}
+
+func (o *Options) APISystemRetryDelay() string {
+	return o.flagAPISystemRetryDelay
+}
+
+func (o *Options) APISystemRetryMaxDelay() string {
+	return o.flagAPISystemRetryMaxDelay
+}
+
+func (o *Options) APISystemBreakerThreshold() string {
+	return o.flagAPISystemBreakerThreshold
+}
+
+func (o *Options) APISystemBreakerCooldown() string {
+	return o.flagAPISystemBreakerCooldown
+}
+
+func (o *Options) EnrichmentProviders() string {
+	return o.flagEnrichmentProviders
+}
+
+func (o *Options) EnrichmentPrecedence() string {
+	return o.flagEnrichmentPrecedence
+}
+
+func (o *Options) EnrichmentHTTPFields() string {
+	return o.flagEnrichmentHTTPFields
+}
+
+func (o *Options) EnrichmentFile() string {
+	return o.flagEnrichmentFile
+}
+
+func (o *Options) EnrichmentMaxAttempts() string {
+	return o.flagEnrichmentMaxAttempts
+}
+
+func (o *Options) EnrichmentRetryDelay() string {
+	return o.flagEnrichmentRetryDelay
+}
+
+func (o *Options) EnrichmentRetryMaxDelay() string {
+	return o.flagEnrichmentRetryMaxDelay
+}
+
+func (o *Options) EnrichmentBatchSize() string {
+	return o.flagEnrichmentBatchSize
+}
+
+func (o *Options) EnrichmentLease() string {
+	return o.flagEnrichmentLease
+}
+
+func (o *Options) EncryptionKey() string {
+	return o.flagEncryptionKey
+}
+
+func (o *Options) TOTPIssuer() string {
+	return o.flagTOTPIssuer
+}
+
+func (o *Options) OIDCIssuer() string {
+	return o.flagOIDCIssuer
+}
+
+func (o *Options) OIDCClientID() string {
+	return o.flagOIDCClientID
+}
+
+func (o *Options) OIDCClientSecret() string {
+	return o.flagOIDCClientSecret
+}
+
+func (o *Options) OIDCRedirectURL() string {
+	return o.flagOIDCRedirectURL
+}
+
+func (o *Options) OIDCPassportClaim() string {
+	return o.flagOIDCPassportClaim
+}
+
+func (o *Options) SnapshotFile() string {
+	return o.flagSnapshotFile
+}
+
+func (o *Options) StorageMode() string {
+	return o.flagStorageMode
+}
+
+func (o *Options) CacheSize() string {
+	return o.flagCacheSize
+}
+
+func (o *Options) CacheTTL() string {
+	return o.flagCacheTTL
+}
+
+func (o *Options) CacheSync() string {
+	return o.flagCacheSync
+}
+
 func regStringVar(p *string, name string, value string, usage string) {
 	if flag.Lookup(name) == nil {
 		flag.StringVar(p, name, value, usage)
@@ -108,6 +364,16 @@ func loadEnvFile() {
 	}
 }
 
+// readKeyFile reads a secret from a file, ignoring surrounding whitespace
+func readKeyFile(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("cannot read key file: %v", err)
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
 // GetAsString reads an environment variable or returns a default value.
 func GetAsString(key string, defaultValue string) string {
 	if value, exists := os.LookupEnv(key); exists {
@@ -0,0 +1,178 @@
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	chimiddleware "github.com/go-chi/chi/middleware"
+	"github.com/wurt83ow/timetracker/internal/audit"
+	authz "github.com/wurt83ow/timetracker/internal/authorization"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 200
+)
+
+// auditContext returns ctx with the audit metadata of a request made by the authenticated principal
+func auditContext(ctx context.Context, r *http.Request) context.Context {
+	principal, _ := authz.PrincipalFromContext(r.Context())
+	return actorContext(ctx, r, principal.UserID)
+}
+
+// actorContext returns ctx with the audit metadata of a request that authenticates
+// the actor itself, such as a login
+func actorContext(ctx context.Context, r *http.Request, actorID int) context.Context {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	return audit.WithMetadata(ctx, audit.Metadata{
+		ActorID:   actorID,
+		RequestID: chimiddleware.GetReqID(r.Context()),
+		IP:        ip,
+	})
+}
+
+// @Summary List audit events
+// @Description Get the audit log, newest events first. Only available to administrators.
+// @Tags Audit
+// @Produce json
+// @Param actor_id query int false "Actor user ID"
+// @Param action query string false "Action"
+// @Param entity_type query string false "Entity type"
+// @Param entity_id query int false "Entity ID"
+// @Param from query string false "Start of the time range (RFC3339)"
+// @Param to query string false "End of the time range (RFC3339)"
+// @Param limit query int false "Limit (default 50, max 200)"
+// @Param cursor query string false "Cursor from the previous page"
+// @Success 200 {object} models.ResponseAuditEvents "Audit events"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /api/audit [get]
+func (h *BaseController) GetAuditEvents(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authz.PrincipalFromContext(r.Context())
+	if !ok {
+		h.log.Info("principal not found in context")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if principal.Role != models.RoleAdmin {
+		h.log.Info("access to the audit log denied", zap.Int("userID", principal.UserID))
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	filter, limit, err := parseAuditQuery(r)
+	if err != nil {
+		h.log.Info("invalid audit query: ", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	events, err := h.storage.GetAuditEvents(h.ctx, filter, limit)
+	if err != nil {
+		h.log.Info("error getting audit events from storage: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response := models.ResponseAuditEvents{Events: events}
+	if len(events) == limit {
+		response.NextCursor = encodeAuditCursor(events[len(events)-1].ID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.log.Info("error encoding response: ", zap.Error(err))
+	}
+}
+
+func parseAuditQuery(r *http.Request) (models.AuditFilter, int, error) {
+	var filter models.AuditFilter
+	query := r.URL.Query()
+
+	if v := query.Get("actor_id"); v != "" {
+		val, err := strconv.Atoi(v)
+		if err != nil {
+			return filter, 0, err
+		}
+		filter.ActorID = &val
+	}
+	if v := query.Get("action"); v != "" {
+		filter.Action = &v
+	}
+	if v := query.Get("entity_type"); v != "" {
+		filter.EntityType = &v
+	}
+	if v := query.Get("entity_id"); v != "" {
+		val, err := strconv.Atoi(v)
+		if err != nil {
+			return filter, 0, err
+		}
+		filter.EntityID = &val
+	}
+	if v := query.Get("from"); v != "" {
+		val, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, 0, err
+		}
+		filter.From = &val
+	}
+	if v := query.Get("to"); v != "" {
+		val, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, 0, err
+		}
+		filter.To = &val
+	}
+	if v := query.Get("cursor"); v != "" {
+		id, err := decodeAuditCursor(v)
+		if err != nil {
+			return filter, 0, err
+		}
+		filter.BeforeID = id
+	}
+
+	limit := defaultAuditLimit
+	if v := query.Get("limit"); v != "" {
+		val, err := strconv.Atoi(v)
+		if err != nil {
+			return filter, 0, err
+		}
+		limit = val
+	}
+	if limit <= 0 {
+		limit = defaultAuditLimit
+	}
+	if limit > maxAuditLimit {
+		limit = maxAuditLimit
+	}
+
+	return filter, limit, nil
+}
+
+// encodeAuditCursor hides the event ID the next page starts after
+func encodeAuditCursor(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+func decodeAuditCursor(cursor string) (int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(string(raw), 10, 64)
+}
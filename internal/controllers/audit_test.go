@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/wurt83ow/timetracker/internal/audit"
+	authz "github.com/wurt83ow/timetracker/internal/authorization"
+	"github.com/wurt83ow/timetracker/internal/events"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+)
+
+func TestBaseController_GetAuditEvents(t *testing.T) {
+	storage := new(MockStorage)
+	log := new(MockLog)
+	ctx := context.Background()
+	controller := NewBaseController(ctx, storage, defaultEndTime, log, new(MockAuthz), new(MockTwoFactor), new(MockPublisher), cursorCipher)
+
+	action := audit.ActionDelete
+	events := []models.AuditEvent{{ID: 12, ActorID: 1, Action: action}, {ID: 11, ActorID: 1, Action: action}}
+	storage.On("GetAuditEvents", ctx, models.AuditFilter{Action: &action}, 2).Return(events, nil)
+	storage.On("GetAuditEvents", ctx, models.AuditFilter{Action: &action, BeforeID: 11}, 2).Return(events[:0], nil)
+	log.On("Info", mock.Anything, mock.Anything).Return()
+
+	router := controller.Route()
+
+	t.Run("Admin", func(t *testing.T) {
+		adminCtx := authz.WithPrincipal(ctx, models.Principal{UserID: 1, Role: models.RoleAdmin})
+
+		req := httptest.NewRequest(http.MethodGet, "/api/audit?action=delete&limit=2", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req.WithContext(adminCtx))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var page models.ResponseAuditEvents
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+		assert.Len(t, page.Events, 2)
+		require.NotEmpty(t, page.NextCursor)
+
+		req = httptest.NewRequest(http.MethodGet, "/api/audit?action=delete&limit=2&cursor="+page.NextCursor, nil)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req.WithContext(adminCtx))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		page = models.ResponseAuditEvents{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+		assert.Empty(t, page.Events)
+		assert.Empty(t, page.NextCursor)
+	})
+
+	t.Run("Invalid Cursor", func(t *testing.T) {
+		adminCtx := authz.WithPrincipal(ctx, models.Principal{UserID: 1, Role: models.RoleAdmin})
+
+		req := httptest.NewRequest(http.MethodGet, "/api/audit?cursor=not-a-cursor", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req.WithContext(adminCtx))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("Not Admin", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/audit", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req.WithContext(authz.WithPrincipal(ctx, models.Principal{UserID: 7})))
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+	})
+}
+
+func TestBaseController_DeleteTaskRecordsActor(t *testing.T) {
+	storage := new(MockStorage)
+	log := new(MockLog)
+	ctx := context.Background()
+	publisher := new(MockPublisher)
+	controller := NewBaseController(ctx, storage, defaultEndTime, log, new(MockAuthz), new(MockTwoFactor), publisher, cursorCipher)
+
+	var meta audit.Metadata
+	storage.On("DeleteTask", mock.MatchedBy(func(ctx context.Context) bool {
+		meta = audit.FromContext(ctx)
+		return true
+	}), 3).Return(nil)
+	publisher.On("Publish", events.Event{Type: events.TaskDeleted, ID: 3}).Return()
+	log.On("Info", mock.Anything, mock.Anything).Return()
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/task/3", nil)
+	req.RemoteAddr = "192.0.2.10:51234"
+	rr := httptest.NewRecorder()
+	controller.Route().ServeHTTP(rr, req.WithContext(authz.WithPrincipal(ctx, models.Principal{UserID: 7})))
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 7, meta.ActorID)
+	assert.Equal(t, "192.0.2.10", meta.IP)
+	publisher.AssertExpectations(t)
+}
+
+func TestBaseController_GetCacheStats(t *testing.T) {
+	mockStorage := new(MockStorage)
+	log := new(MockLog)
+	ctx := context.Background()
+	controller := NewBaseController(ctx, mockStorage, defaultEndTime, log, new(MockAuthz), new(MockTwoFactor), new(MockPublisher), cursorCipher)
+
+	stats := storage.CacheStats{Mode: storage.ModeCache, TTL: "5m0s", Users: storage.CacheCounters{Size: 1, Capacity: 10, Hits: 3, Misses: 1}}
+	mockStorage.On("CacheStats").Return(stats)
+	log.On("Info", mock.Anything, mock.Anything).Return()
+
+	router := controller.Route()
+
+	t.Run("Admin", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/cache/stats", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req.WithContext(authz.WithPrincipal(ctx, models.Principal{UserID: 1, Role: models.RoleAdmin})))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var got storage.CacheStats
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+		assert.Equal(t, stats, got)
+	})
+
+	t.Run("Not Admin", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/cache/stats", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req.WithContext(authz.WithPrincipal(ctx, models.Principal{UserID: 7})))
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+	})
+}
@@ -1,7 +1,6 @@
 package controllers
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -14,6 +13,7 @@ import (
 	"github.com/go-chi/chi"
 	httpSwagger "github.com/swaggo/http-swagger"
 	authz "github.com/wurt83ow/timetracker/internal/authorization"
+	"github.com/wurt83ow/timetracker/internal/events"
 	"github.com/wurt83ow/timetracker/internal/models"
 	"github.com/wurt83ow/timetracker/internal/storage"
 	"go.uber.org/zap"
@@ -26,21 +26,38 @@ type IExternalClient interface {
 
 type Storage interface {
 	GetBaseConnection(context.Context) bool
-	InsertUser(context.Context, models.User) error
+	InsertUser(context.Context, models.User) (int, error)
 	UpdateUser(context.Context, models.User) error
 	DeleteUser(context.Context, int) error
-	GetUsers(context.Context, models.Filter, models.Pagination) ([]models.User, error)
+	GetUsers(context.Context, models.Filter, models.Pagination) ([]models.User, int, error)
 
-	InsertTask(context.Context, models.Task) error
+	InsertTask(context.Context, models.Task) (int, error)
 	UpdateTask(context.Context, models.Task) error
 	DeleteTask(context.Context, int) error
-	GetTasks(context.Context, models.TaskFilter, models.Pagination) ([]models.Task, error)
+	GetTasks(context.Context, models.TaskFilter, models.Pagination) ([]models.Task, int, error)
+	Search(context.Context, string, int) ([]models.SearchResult, error)
 
 	StartTaskTracking(context.Context, models.TimeEntry) error
 	StopTaskTracking(context.Context, models.TimeEntry) error
 	GetUserTaskSummary(context.Context, int, time.Time, time.Time, string, time.Time) ([]models.TaskSummary, error)
 	GetUser(context.Context, int, int) (models.User, error)
 	GetUserByID(context.Context, int) (models.User, error)
+
+	ExportUser(context.Context, int) (models.UserExport, error)
+	EraseUser(context.Context, int) error
+
+	GetAuditEvents(context.Context, models.AuditFilter, int) ([]models.AuditEvent, error)
+	CacheStats() storage.CacheStats
+
+	GetEnrichmentAttempts(context.Context, []string, int) ([]models.EnrichmentAttempt, error)
+	CountEnrichmentAttempts(context.Context) (map[string]int, error)
+	ResyncUser(context.Context, int) error
+
+	SaveReportSubscription(context.Context, models.ReportSubscription) (int, error)
+	GetReportSubscription(context.Context, int) (models.ReportSubscription, error)
+	GetReportSubscriptions(context.Context) ([]models.ReportSubscription, error)
+	DeleteReportSubscription(context.Context, int) error
+	EnqueueJob(context.Context, models.Job) (int64, error)
 }
 
 type Options interface {
@@ -54,27 +71,59 @@ type Log interface {
 
 type Authz interface {
 	JWTAuthzMiddleware(authz.Log) func(http.Handler) http.Handler
-	GetHash(string, string) []byte
-	CreateJWTTokenForUser(string) string
+	HashPassword(string) ([]byte, error)
+	CheckPassword([]byte, string, string) bool
+	NeedsRehash([]byte) bool
+	BindLegacyHash([]byte, string) []byte
+	CreateJWTTokenForUser(int) string
+	CreateChallengeToken(int) string
+	DecodeChallengeToken(string) (int, error)
 	AuthCookie(string, string) *http.Cookie
 }
 
+type TwoFactor interface {
+	Enabled(context.Context, int) (bool, error)
+	Enroll(context.Context, int, string) (models.TwoFactorEnrollment, error)
+	Confirm(context.Context, int, string) ([]string, error)
+	Disable(context.Context, int, string) error
+	Verify(context.Context, int, string) error
+}
+
+// Publisher announces the changes other parts of the service react to, such as the enrichment
+type Publisher interface {
+	Publish(events.Event)
+}
+
+// CursorCipher seals the list cursors, so that the sort values in them cannot be read from URLs
+type CursorCipher interface {
+	Encrypt([]byte) (string, error)
+	Decrypt(string) ([]byte, error)
+}
+
 type BaseController struct {
 	ctx            context.Context
 	storage        Storage
 	defaultEndTime func() string
 	log            Log
 	authz          Authz
+	twoFactor      TwoFactor
+	bus            Publisher
+	cipher         CursorCipher
 }
 
 // NewBaseController creates a new BaseController instance
-func NewBaseController(ctx context.Context, storage Storage, defaultEndTime func() string, log Log, authz Authz) *BaseController {
+func NewBaseController(ctx context.Context, storage Storage, defaultEndTime func() string, log Log,
+	authz Authz, twoFactor TwoFactor, bus Publisher, cipher CursorCipher,
+) *BaseController {
 	instance := &BaseController{
 		ctx:            ctx,
 		storage:        storage,
 		defaultEndTime: defaultEndTime,
 		log:            log,
 		authz:          authz,
+		twoFactor:      twoFactor,
+		bus:            bus,
+		cipher:         cipher,
 	}
 
 	return instance
@@ -86,6 +135,7 @@ func (h *BaseController) Route() *chi.Mux {
 
 	r.Post("/api/user/register", h.Register)
 	r.Post("/api/user/login", h.Login)
+	r.Post("/api/user/login/2fa", h.LoginTwoFactor)
 	r.Get("/ping", h.GetPing)
 
 	// Add route for Swagger UI
@@ -100,6 +150,9 @@ func (h *BaseController) Route() *chi.Mux {
 		r.Patch("/api/user/{id}", h.UpdateUser)
 		r.Delete("/api/user/{id}", h.DeleteUser)
 		r.Get("/api/users", h.GetUsers)
+		r.Get("/api/users/{id}/export", h.ExportUser)
+		r.Post("/api/users/{id}/erase", h.EraseUser)
+		r.Post("/api/users/{id}/resync", h.ResyncUser)
 
 		// Operations with tasks
 		r.Post("/api/task", h.AddTask)
@@ -107,10 +160,33 @@ func (h *BaseController) Route() *chi.Mux {
 		r.Delete("/api/task/{id}", h.DeleteTask)
 		r.Get("/api/tasks", h.GetTasks)
 
+		// Full-text search
+		r.Get("/api/search", h.Search)
+
 		// Operations with tracker
 		r.Post("/api/task/start", h.StartTaskTracking)
 		r.Post("/api/task/stop", h.StopTaskTracking)
 		r.Post("/api/task/summary", h.GetUserTaskSummary)
+
+		// Operations with two-factor authentication
+		r.Post("/api/me/2fa/enroll", h.EnrollTwoFactor)
+		r.Post("/api/me/2fa/confirm", h.ConfirmTwoFactor)
+		r.Post("/api/me/2fa/disable", h.DisableTwoFactor)
+
+		// Operations with the audit log
+		r.Get("/api/audit", h.GetAuditEvents)
+
+		// Operations with the storage cache
+		r.Get("/api/cache/stats", h.GetCacheStats)
+
+		// Operations with the user enrichment
+		r.Get("/api/admin/enrichment", h.GetEnrichment)
+
+		// Operations with the scheduled reports
+		r.Post("/api/reports/subscriptions", h.CreateReportSubscription)
+		r.Get("/api/reports/subscriptions", h.GetReportSubscriptions)
+		r.Delete("/api/reports/subscriptions/{id}", h.DeleteReportSubscription)
+		r.Post("/api/reports/subscriptions/{id}/send", h.SendReport)
 	})
 
 	return r
@@ -143,7 +219,7 @@ func (h *BaseController) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	passportSerie, passportNumber, err := h.parsePassportData(regReq.PassportNumber)
+	passportSerie, passportNumber, err := parsePassportData(regReq.PassportNumber)
 	if err != nil {
 		h.log.Info(err.Error())
 		w.WriteHeader(http.StatusBadRequest)
@@ -168,7 +244,12 @@ func (h *BaseController) Register(w http.ResponseWriter, r *http.Request) {
 
 	Timezone := loc.String()
 
-	Hash := h.authz.GetHash(regReq.PassportNumber, regReq.Password)
+	Hash, err := h.authz.HashPassword(regReq.Password)
+	if err != nil {
+		h.log.Info("cannot hash password: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError) // code 500
+		return
+	}
 
 	// Convert default end time string to time.Time in the local timezone
 	defaultEndTime, err := h.parseDefaultEndTime(loc)
@@ -187,7 +268,7 @@ func (h *BaseController) Register(w http.ResponseWriter, r *http.Request) {
 		Timezone:       Timezone,
 	}
 
-	err = h.storage.InsertUser(h.ctx, userData)
+	userID, err := h.storage.InsertUser(auditContext(h.ctx, r), userData)
 	if err != nil {
 		if err == storage.ErrConflict {
 			h.log.Info("login is already taken: ", zap.Error(err))
@@ -199,7 +280,9 @@ func (h *BaseController) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	freshToken := h.authz.CreateJWTTokenForUser(regReq.PassportNumber)
+	h.bus.Publish(events.Event{Type: events.UserRegistered, ID: userID})
+
+	freshToken := h.authz.CreateJWTTokenForUser(userID)
 	http.SetCookie(w, h.authz.AuthCookie("jwt-token", freshToken))
 	http.SetCookie(w, h.authz.AuthCookie("Authorization", freshToken))
 
@@ -209,12 +292,14 @@ func (h *BaseController) Register(w http.ResponseWriter, r *http.Request) {
 }
 
 // @Summary Login user
-// @Description Login a user and return a JWT token
+// @Description Login a user and return a JWT token.
+// @Description If the user has two-factor authentication enabled, a challenge token is returned instead,
+// @Description which must be exchanged for a JWT at /api/user/login/2fa.
 // @Tags User
 // @Accept json
 // @Produce json
 // @Param user body models.RequestUser true "User Info"
-// @Success 200 {string} string "User logged in successfully"
+// @Success 200 {object} models.ResponseTwoFactorChallenge "User logged in successfully or second factor required"
 // @Failure 400 {string} string "Bad Request"
 // @Failure 401 {string} string "Unauthorized"
 // @Failure 500 {string} string "Internal Server Error"
@@ -231,7 +316,7 @@ func (h *BaseController) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	passportSerie, passportNumber, err := h.parsePassportData(rb.PassportNumber)
+	passportSerie, passportNumber, err := parsePassportData(rb.PassportNumber)
 	if err != nil {
 		h.log.Info(err.Error())
 		w.WriteHeader(http.StatusBadRequest)
@@ -248,14 +333,31 @@ func (h *BaseController) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !bytes.Equal(user.Hash, h.authz.GetHash(rb.PassportNumber, rb.Password)) {
+	if !h.authz.CheckPassword(user.Hash, rb.PassportNumber, rb.Password) {
 		// incorrect login/password pair
 		w.WriteHeader(http.StatusUnauthorized) //code 401
 		h.log.Info("incorrect login/password pair, request status 401: ", metod)
 		return
 	}
 
-	freshToken := h.authz.CreateJWTTokenForUser(rb.PassportNumber)
+	// Replace a legacy hash that depends on the passport with a salted one
+	if h.authz.NeedsRehash(user.Hash) {
+		h.rehashPassword(r, user.UUID, rb.Password)
+	}
+
+	enabled, err := h.twoFactor.Enabled(h.ctx, user.UUID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError) //code 500
+		h.log.Info("cannot check two-factor settings: ", zap.Error(err))
+		return
+	}
+
+	if enabled {
+		writeTwoFactorChallenge(w, h.log, h.authz.CreateChallengeToken(user.UUID))
+		return
+	}
+
+	freshToken := h.authz.CreateJWTTokenForUser(user.UUID)
 	http.SetCookie(w, h.authz.AuthCookie("jwt-token", freshToken))
 	http.SetCookie(w, h.authz.AuthCookie("Authorization", freshToken))
 
@@ -289,7 +391,7 @@ func (h *BaseController) AddUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	passportSerie, passportNumber, err := h.parsePassportData(reqData.PassportNumber)
+	passportSerie, passportNumber, err := parsePassportData(reqData.PassportNumber)
 	if err != nil {
 		h.log.Info(err.Error())
 		w.WriteHeader(http.StatusBadRequest)
@@ -330,12 +432,20 @@ func (h *BaseController) AddUser(w http.ResponseWriter, r *http.Request) {
 		Timezone:       loc.String(),
 	}
 
-	if err := h.storage.InsertUser(h.ctx, user); err != nil {
+	userID, err := h.storage.InsertUser(auditContext(h.ctx, r), user)
+	if err != nil {
+		if err == storage.ErrConflict {
+			h.log.Info("passport is already registered: ", zap.Error(err))
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
 		h.log.Info("error inserting user to storage: ", zap.Error(err))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	h.bus.Publish(events.Event{Type: events.UserRegistered, ID: userID})
+
 	w.WriteHeader(http.StatusOK)
 	if _, err := w.Write([]byte("User added successfully")); err != nil {
 		h.log.Info("error writing response: ", zap.Error(err))
@@ -352,16 +462,15 @@ func (h *BaseController) AddUser(w http.ResponseWriter, r *http.Request) {
 // @Param user body models.User true "User Info"
 // @Success 200 {string} string "User updated successfully"
 // @Failure 400 {string} string "Bad Request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
 // @Failure 404 {string} string "Not Found"
 // @Failure 500 {string} string "Internal Server Error"
 // @Router /api/user/{id} [patch]
 func (h *BaseController) UpdateUser(w http.ResponseWriter, r *http.Request) {
-	// Extracting the user ID from the URL
-	idStr := chi.URLParam(r, "id")
-	id, err := strconv.Atoi(idStr)
-	if err != nil {
-		h.log.Info("invalid user ID in URL")
-		w.WriteHeader(http.StatusBadRequest)
+	// Only the user or an admin may change the user
+	id, ok := h.dataSubject(w, r)
+	if !ok {
 		return
 	}
 
@@ -376,13 +485,39 @@ func (h *BaseController) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	// Assigning the extracted ID to the user struct
 	user.UUID = id
 
-	if err := h.storage.UpdateUser(h.ctx, user); err == storage.ErrNotFound {
+	// The enrichment is run again for a new passport
+	passportChanged := false
+	if user.PassportSerie != 0 || user.PassportNumber != 0 {
+		previous, err := h.storage.GetUserByID(h.ctx, id)
+		passportChanged = err == nil &&
+			(user.PassportSerie != 0 && user.PassportSerie != previous.PassportSerie ||
+				user.PassportNumber != 0 && user.PassportNumber != previous.PassportNumber)
+
+		// A legacy hash is derived from the passport login, it keeps the old one
+		if passportChanged && len(previous.Hash) > 0 && h.authz.NeedsRehash(previous.Hash) {
+			user.Hash = h.authz.BindLegacyHash(previous.Hash, fmt.Sprintf("%d %d", previous.PassportSerie, previous.PassportNumber))
+		}
+	}
+
+	err := h.storage.UpdateUser(auditContext(h.ctx, r), user)
+	if err == storage.ErrNotFound {
 		h.log.Info("user not found")
 		w.WriteHeader(http.StatusNotFound)
-		fmt.Println("11111111111111111111111111111111111111111111111111", err)
+		return
+	} else if err == storage.ErrConflict {
+		h.log.Info("passport is already registered")
+		w.WriteHeader(http.StatusConflict)
+		return
+	} else if err != nil {
+		h.log.Info("error updating user in storage: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	if passportChanged {
+		h.bus.Publish(events.Event{Type: events.UserPassportChanged, ID: id})
+	}
+
 	w.WriteHeader(http.StatusOK)
 	h.log.Info("User updated successfully")
 }
@@ -395,20 +530,19 @@ func (h *BaseController) UpdateUser(w http.ResponseWriter, r *http.Request) {
 // @Param id path int true "User ID"
 // @Success 200 {string} string "User deleted successfully"
 // @Failure 400 {string} string "Bad Request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
 // @Failure 404 {string} string "Not Found"
 // @Failure 500 {string} string "Internal Server Error"
 // @Router /api/user/{id} [delete]
 func (h *BaseController) DeleteUser(w http.ResponseWriter, r *http.Request) {
-	// Extracting the user ID from the URL
-	idStr := chi.URLParam(r, "id")
-	id, err := strconv.Atoi(idStr)
-	if err != nil {
-		h.log.Info("invalid user ID in URL")
-		w.WriteHeader(http.StatusBadRequest)
+	// Only the user or an admin may delete the user
+	id, ok := h.dataSubject(w, r)
+	if !ok {
 		return
 	}
 
-	err = h.storage.DeleteUser(h.ctx, id)
+	err := h.storage.DeleteUser(auditContext(h.ctx, r), id)
 	if err == storage.ErrNotFound {
 		h.log.Info("user not found")
 		w.WriteHeader(http.StatusNotFound)
@@ -424,7 +558,8 @@ func (h *BaseController) DeleteUser(w http.ResponseWriter, r *http.Request) {
 }
 
 // @Summary Get users
-// @Description Get users from the database
+// @Description Get users from the database. Admins get full users, other users get
+// @Description users without the passport and the address and cannot filter or sort by them.
 // @Tags User
 // @Accept json
 // @Produce json
@@ -435,15 +570,26 @@ func (h *BaseController) DeleteUser(w http.ResponseWriter, r *http.Request) {
 // @Param patronymic query string false "Patronymic"
 // @Param address query string false "Address"
 // @Param timezone query string false "Timezone"
-// @Param limit query int false "Limit"
+// @Param sort query string false "Sort fields, comma separated, '-' for descending (e.g. surname,-id)"
+// @Param limit query int false "Limit (default 20, max 100)"
 // @Param offset query int false "Offset"
-// @Success 200 {array} models.User "List of users"
+// @Param cursor query string false "Cursor from the previous page"
+// @Success 200 {object} models.ResponseUsers "Page of users"
 // @Failure 400 {string} string "Bad Request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
 // @Failure 500 {string} string "Internal Server Error"
 // @Router /api/users [get]
 func (h *BaseController) GetUsers(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authz.PrincipalFromContext(r.Context())
+	if !ok {
+		h.log.Info("principal not found in context")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	admin := principal.Role == models.RoleAdmin
+
 	var filter models.Filter
-	var pagination models.Pagination
 
 	if v := r.URL.Query().Get("passportSerie"); v != "" {
 		val, err := strconv.Atoi(v)
@@ -479,39 +625,83 @@ func (h *BaseController) GetUsers(w http.ResponseWriter, r *http.Request) {
 		filter.Timezone = &v
 	}
 
-	if v := r.URL.Query().Get("limit"); v != "" {
-		val, err := strconv.Atoi(v)
-		if err != nil {
-			h.log.Info("invalid limit format")
-			w.WriteHeader(http.StatusBadRequest)
-			return
+	pagination, err := h.parsePagination(r, storage.ParseUserSort, storage.ValidUserCursor)
+	if err != nil {
+		h.log.Info("invalid pagination: ", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Passports and addresses of other users are visible to admins only
+	if !admin && (filter.PassportSerie != nil || filter.PassportNumber != nil || filter.Address != nil || sortsByPrivateField(pagination.Sort)) {
+		h.log.Info("filter or sort by private user fields denied", zap.Int("id", principal.UserID))
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	// One user more than the page tells whether there is a next page
+	lookahead := pagination
+	lookahead.Limit++
+
+	users, total, err := h.storage.GetUsers(h.ctx, filter, lookahead)
+	if errors.Is(err, storage.ErrTooManyUsers) {
+		h.log.Info("too many users to filter by encrypted fields")
+		w.WriteHeader(http.StatusBadRequest)
+		if _, err := w.Write([]byte("Too many users, filter by the passport or the timezone")); err != nil {
+			h.log.Info("error writing response: ", zap.Error(err))
 		}
-		pagination.Limit = val
+		return
+	} else if err != nil {
+		h.log.Info("error getting users from storage: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
-	if v := r.URL.Query().Get("offset"); v != "" {
-		val, err := strconv.Atoi(v)
+
+	var nextCursor string
+	if len(users) > pagination.Limit {
+		users = users[:pagination.Limit]
+		nextCursor, err = h.encodeListCursor(storage.UserCursor(users[len(users)-1], pagination.Sort))
 		if err != nil {
-			h.log.Info("invalid offset format")
-			w.WriteHeader(http.StatusBadRequest)
+			h.log.Info("error encoding cursor: ", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		pagination.Offset = val
 	}
 
-	users, err := h.storage.GetUsers(h.ctx, filter, pagination)
-	if err != nil {
-		h.log.Info("error getting users from storage: ", zap.Error(err))
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	var response interface{} = models.ResponseUsers{Users: users, Total: total, NextCursor: nextCursor}
+	if !admin {
+		public := make([]models.PublicUser, 0, len(users))
+		for _, u := range users {
+			public = append(public, models.PublicUser{
+				UUID:       u.UUID,
+				Surname:    u.Surname,
+				Name:       u.Name,
+				Patronymic: u.Patronymic,
+				Timezone:   u.Timezone,
+			})
+		}
+		response = models.ResponsePublicUsers{Users: public, Total: total, NextCursor: nextCursor}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(users); err != nil {
+	if err := json.NewEncoder(w).Encode(response); err != nil {
 		h.log.Info("error encoding response: ", zap.Error(err))
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
 
+// sortsByPrivateField reports whether users are sorted by a field hidden from non-admins
+func sortsByPrivateField(fields []models.SortField) bool {
+	for _, f := range fields {
+		switch f.Field {
+		case "passportSerie", "passportNumber", "address":
+			return true
+		}
+	}
+
+	return false
+}
+
 // @Summary Add task
 // @Description Add a new task to the database
 // @Tags Tasks
@@ -542,12 +732,16 @@ func (h *BaseController) AddTask(w http.ResponseWriter, r *http.Request) {
 
 	task.CreatedAt = time.Now()
 
-	if err := h.storage.InsertTask(h.ctx, task); err != nil {
+	taskID, err := h.storage.InsertTask(auditContext(h.ctx, r), task)
+	if err != nil {
 		h.log.Info("error inserting task to storage: ", zap.Error(err))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	task.ID = taskID
+	h.bus.Publish(events.Event{Type: events.TaskCreated, ID: taskID, Data: task})
+
 	w.WriteHeader(http.StatusOK)
 	if _, err := w.Write([]byte("Task added successfully")); err != nil {
 		h.log.Info("error writing response: ", zap.Error(err))
@@ -588,7 +782,7 @@ func (h *BaseController) UpdateTask(w http.ResponseWriter, r *http.Request) {
 	// Assigning the extracted ID to the task struct
 	task.ID = id
 
-	if err := h.storage.UpdateTask(h.ctx, task); err == storage.ErrNotFound {
+	if err := h.storage.UpdateTask(auditContext(h.ctx, r), task); err == storage.ErrNotFound {
 		h.log.Info("task not found")
 		w.WriteHeader(http.StatusNotFound)
 		return
@@ -597,6 +791,7 @@ func (h *BaseController) UpdateTask(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	h.bus.Publish(events.Event{Type: events.TaskUpdated, ID: id, Data: task})
 
 	w.WriteHeader(http.StatusOK)
 	h.log.Info("Task updated successfully")
@@ -623,7 +818,7 @@ func (h *BaseController) DeleteTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.storage.DeleteTask(h.ctx, id)
+	err = h.storage.DeleteTask(auditContext(h.ctx, r), id)
 	if err == storage.ErrNotFound {
 		h.log.Info("task not found")
 		w.WriteHeader(http.StatusNotFound)
@@ -633,6 +828,7 @@ func (h *BaseController) DeleteTask(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	h.bus.Publish(events.Event{Type: events.TaskDeleted, ID: id})
 
 	w.WriteHeader(http.StatusOK)
 	h.log.Info("Task deleted successfully")
@@ -645,15 +841,16 @@ func (h *BaseController) DeleteTask(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param name query string false "Name"
 // @Param description query string false "Description"
-// @Param limit query int false "Limit"
+// @Param sort query string false "Sort fields, comma separated, '-' for descending (e.g. -created_at)"
+// @Param limit query int false "Limit (default 20, max 100)"
 // @Param offset query int false "Offset"
-// @Success 200 {array} models.Task "List of tasks"
+// @Param cursor query string false "Cursor from the previous page"
+// @Success 200 {object} models.ResponseTasks "Page of tasks"
 // @Failure 400 {string} string "Bad Request"
 // @Failure 500 {string} string "Internal Server Error"
 // @Router /api/tasks [get]
 func (h *BaseController) GetTasks(w http.ResponseWriter, r *http.Request) {
 	var filter models.TaskFilter
-	var pagination models.Pagination
 
 	if v := r.URL.Query().Get("name"); v != "" {
 		filter.Name = &v
@@ -661,34 +858,37 @@ func (h *BaseController) GetTasks(w http.ResponseWriter, r *http.Request) {
 	if v := r.URL.Query().Get("description"); v != "" {
 		filter.Description = &v
 	}
-	if v := r.URL.Query().Get("limit"); v != "" {
-		val, err := strconv.Atoi(v)
-		if err != nil {
-			h.log.Info("invalid limit format")
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		pagination.Limit = val
-	}
-	if v := r.URL.Query().Get("offset"); v != "" {
-		val, err := strconv.Atoi(v)
-		if err != nil {
-			h.log.Info("invalid offset format")
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		pagination.Offset = val
+	pagination, err := h.parsePagination(r, storage.ParseTaskSort, storage.ValidTaskCursor)
+	if err != nil {
+		h.log.Info("invalid pagination: ", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
 
-	tasks, err := h.storage.GetTasks(h.ctx, filter, pagination)
+	// One task more than the page tells whether there is a next page
+	lookahead := pagination
+	lookahead.Limit++
+
+	tasks, total, err := h.storage.GetTasks(h.ctx, filter, lookahead)
 	if err != nil {
 		h.log.Info("error getting tasks from storage: ", zap.Error(err))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	response := models.ResponseTasks{Tasks: tasks, Total: total}
+	if len(tasks) > pagination.Limit {
+		response.Tasks = tasks[:pagination.Limit]
+		response.NextCursor, err = h.encodeListCursor(storage.TaskCursor(tasks[pagination.Limit-1], pagination.Sort))
+		if err != nil {
+			h.log.Info("error encoding cursor: ", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(tasks); err != nil {
+	if err := json.NewEncoder(w).Encode(response); err != nil {
 		h.log.Info("error encoding response: ", zap.Error(err))
 		w.WriteHeader(http.StatusInternalServerError)
 	}
@@ -702,7 +902,7 @@ func (h *BaseController) GetTasks(w http.ResponseWriter, r *http.Request) {
 // @Param task body models.RequestData true "Task Info"
 // @Success 200 {string} string "Task tracking started successfully"
 // @Failure 400 {string} string "Bad Request"
-// @Failure 404 {string} string "User not found"
+// @Failure 401 {string} string "Unauthorized"
 // @Failure 500 {string} string "Internal Server Error"
 // @Router /api/task/start [post]
 func (h *BaseController) StartTaskTracking(w http.ResponseWriter, r *http.Request) {
@@ -713,59 +913,30 @@ func (h *BaseController) StartTaskTracking(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Retrieve userID from context
-	userID, ok := r.Context().Value(models.Key("userID")).(string)
-	if !ok || userID == "" {
-		h.log.Info("userID not found in context")
+	// Retrieve the authenticated user from context
+	principal, ok := authz.PrincipalFromContext(r.Context())
+	if !ok {
+		h.log.Info("principal not found in context")
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
 
-	// Find user by userID (passport series and number) in cache
-	passportSerie, passportNumber, err := h.parsePassportData(userID)
-	if err != nil {
-		h.log.Info("error parsing passport data", zap.Error(err))
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	filter := models.Filter{
-		PassportSerie:  &passportSerie,
-		PassportNumber: &passportNumber,
-	}
-	users, err := h.storage.GetUsers(h.ctx, filter, models.Pagination{Limit: 1})
-	if err != nil || len(users) == 0 {
-		h.log.Info("user not found", zap.Error(err))
-		w.WriteHeader(http.StatusNotFound)
-		return
-	}
-
-	user := users[0]
-
 	// Prepare TimeEntry
-	loc, err := time.LoadLocation(user.Timezone)
+	entry, err := trackingEntry(principal, reqData.TaskID)
 	if err != nil {
 		h.log.Info("invalid user timezone", zap.Error(err))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	startOfDay := time.Now().In(loc).Truncate(24 * time.Hour)
-
-	entry := models.TimeEntry{
-		EventDate:    startOfDay,
-		UserID:       user.UUID,
-		TaskID:       reqData.TaskID,
-		UserTimezone: user.Timezone,
-	}
-
 	// Start task tracking
-	if err := h.storage.StartTaskTracking(h.ctx, entry); err != nil {
+	if err := h.storage.StartTaskTracking(auditContext(h.ctx, r), entry); err != nil {
 		h.log.Info("error starting task tracking", zap.Error(err))
 		w.WriteHeader(http.StatusInternalServerError)
 
 		return
 	}
+	publishTimer(h.bus, events.TimerStarted, entry)
 
 	w.WriteHeader(http.StatusOK)
 	if _, err := w.Write([]byte("Task tracking started successfully")); err != nil {
@@ -782,7 +953,7 @@ func (h *BaseController) StartTaskTracking(w http.ResponseWriter, r *http.Reques
 // @Param task body models.RequestData true "Task Info"
 // @Success 200 {string} string "Task tracking stopped successfully"
 // @Failure 400 {string} string "Bad Request"
-// @Failure 404 {string} string "User not found"
+// @Failure 401 {string} string "Unauthorized"
 // @Failure 500 {string} string "Internal Server Error"
 // @Router /api/task/stop [post]
 func (h *BaseController) StopTaskTracking(w http.ResponseWriter, r *http.Request) {
@@ -793,58 +964,29 @@ func (h *BaseController) StopTaskTracking(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Retrieve userID from context
-	userID, ok := r.Context().Value(models.Key("userID")).(string)
-	if !ok || userID == "" {
-		h.log.Info("userID not found in context")
+	// Retrieve the authenticated user from context
+	principal, ok := authz.PrincipalFromContext(r.Context())
+	if !ok {
+		h.log.Info("principal not found in context")
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
 
-	// Find user by userID (passport series and number) in cache
-	passportSerie, passportNumber, err := h.parsePassportData(userID)
-	if err != nil {
-		h.log.Info("error parsing passport data", zap.Error(err))
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	filter := models.Filter{
-		PassportSerie:  &passportSerie,
-		PassportNumber: &passportNumber,
-	}
-	users, err := h.storage.GetUsers(h.ctx, filter, models.Pagination{Limit: 1})
-	if err != nil || len(users) == 0 {
-		h.log.Info("user not found", zap.Error(err))
-		w.WriteHeader(http.StatusNotFound)
-		return
-	}
-
-	user := users[0]
-
 	// Prepare TimeEntry
-	loc, err := time.LoadLocation(user.Timezone)
+	entry, err := trackingEntry(principal, reqData.TaskID)
 	if err != nil {
 		h.log.Info("invalid user timezone", zap.Error(err))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	startOfDay := time.Now().In(loc).Truncate(24 * time.Hour)
-
-	entry := models.TimeEntry{
-		EventDate:    startOfDay,
-		UserID:       user.UUID,
-		TaskID:       reqData.TaskID,
-		UserTimezone: user.Timezone,
-	}
-
 	// Stop task tracking
-	if err := h.storage.StopTaskTracking(h.ctx, entry); err != nil {
+	if err := h.storage.StopTaskTracking(auditContext(h.ctx, r), entry); err != nil {
 		h.log.Info("error stopping task tracking", zap.Error(err))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	publishTimer(h.bus, events.TimerStopped, entry)
 
 	w.WriteHeader(http.StatusOK)
 	if _, err := w.Write([]byte("Task tracking stopped successfully")); err != nil {
@@ -853,6 +995,33 @@ func (h *BaseController) StopTaskTracking(w http.ResponseWriter, r *http.Request
 	h.log.Info("Task tracking stopped successfully")
 }
 
+// trackingEntry prepares the time entry of the principal for the task on the current day
+func trackingEntry(principal models.Principal, taskID int) (models.TimeEntry, error) {
+	loc, err := time.LoadLocation(principal.Timezone)
+	if err != nil {
+		return models.TimeEntry{}, err
+	}
+
+	startOfDay := time.Now().In(loc).Truncate(24 * time.Hour)
+
+	return models.TimeEntry{
+		EventDate:    startOfDay,
+		UserID:       principal.UserID,
+		TaskID:       taskID,
+		UserTimezone: principal.Timezone,
+	}, nil
+}
+
+// publishTimer announces that the timer of the entry was started or stopped
+func publishTimer(bus Publisher, eventType string, entry models.TimeEntry) {
+	bus.Publish(events.Event{Type: eventType, ID: entry.UserID, Data: events.Timer{
+		UserID:    entry.UserID,
+		TaskID:    entry.TaskID,
+		EventDate: entry.EventDate.Format(time.DateOnly),
+		At:        time.Now().UTC(),
+	}})
+}
+
 // @Summary Get user task summary
 // @Description Get a summary of tasks for a user within a date range
 // @Tags Task
@@ -937,7 +1106,7 @@ func (h *BaseController) GetPing(w http.ResponseWriter, r *http.Request) {
 }
 
 // parsePassportData parses the passport data from a string into series and number
-func (h *BaseController) parsePassportData(passportNumber string) (int, int, error) {
+func parsePassportData(passportNumber string) (int, int, error) {
 	parts := strings.Split(passportNumber, " ")
 	if len(parts) != 2 {
 		return 0, 0, errors.New("invalid passport number format")
@@ -956,8 +1125,25 @@ func (h *BaseController) parsePassportData(passportNumber string) (int, int, err
 	return passportSerie, passportNumberInt, nil
 }
 
+// rehashPassword stores a salted hash for a user that logged in with a legacy one
+func (h *BaseController) rehashPassword(r *http.Request, userID int, password string) {
+	hash, err := h.authz.HashPassword(password)
+	if err != nil {
+		h.log.Info("cannot hash password: ", zap.Error(err))
+		return
+	}
+
+	if err := h.storage.UpdateUser(actorContext(h.ctx, r, userID), models.User{UUID: userID, Hash: hash}); err != nil {
+		h.log.Info("cannot update legacy password hash: ", zap.Error(err))
+	}
+}
+
 func (h *BaseController) parseDefaultEndTime(loc *time.Location) (time.Time, error) {
-	defaultEndTimeStr := h.defaultEndTime()
+	return parseDefaultEndTime(h.defaultEndTime(), loc)
+}
+
+// parseDefaultEndTime converts an "HH:MM" string to today's time in the given location
+func parseDefaultEndTime(defaultEndTimeStr string, loc *time.Location) (time.Time, error) {
 	defaultEndTime, err := time.ParseInLocation("15:04", defaultEndTimeStr, loc)
 	if err != nil {
 		return time.Time{}, err
@@ -13,10 +13,17 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	authz "github.com/wurt83ow/timetracker/internal/authorization"
+	"github.com/wurt83ow/timetracker/internal/encryption"
+	"github.com/wurt83ow/timetracker/internal/events"
 	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"github.com/wurt83ow/timetracker/internal/twofactor"
 	"go.uber.org/zap/zapcore"
 )
 
+// cursorCipher seals the list cursors of the controllers under test
+var cursorCipher, _ = encryption.NewCipher("test_encryption_key")
+
 // MockStorage is a mock implementation of the Storage interface
 type MockStorage struct {
 	mock.Mock
@@ -27,9 +34,9 @@ func (m *MockStorage) GetBaseConnection(ctx context.Context) bool {
 	return args.Bool(0)
 }
 
-func (m *MockStorage) InsertUser(ctx context.Context, user models.User) error {
+func (m *MockStorage) InsertUser(ctx context.Context, user models.User) (int, error) {
 	args := m.Called(ctx, user)
-	return args.Error(0)
+	return args.Int(0), args.Error(1)
 }
 
 func (m *MockStorage) UpdateUser(ctx context.Context, user models.User) error {
@@ -42,14 +49,14 @@ func (m *MockStorage) DeleteUser(ctx context.Context, id int) error {
 	return args.Error(0)
 }
 
-func (m *MockStorage) GetUsers(ctx context.Context, filter models.Filter, pagination models.Pagination) ([]models.User, error) {
+func (m *MockStorage) GetUsers(ctx context.Context, filter models.Filter, pagination models.Pagination) ([]models.User, int, error) {
 	args := m.Called(ctx, filter, pagination)
-	return args.Get(0).([]models.User), args.Error(1)
+	return args.Get(0).([]models.User), args.Int(1), args.Error(2)
 }
 
-func (m *MockStorage) InsertTask(ctx context.Context, task models.Task) error {
+func (m *MockStorage) InsertTask(ctx context.Context, task models.Task) (int, error) {
 	args := m.Called(ctx, task)
-	return args.Error(0)
+	return args.Int(0), args.Error(1)
 }
 
 func (m *MockStorage) UpdateTask(ctx context.Context, task models.Task) error {
@@ -62,9 +69,14 @@ func (m *MockStorage) DeleteTask(ctx context.Context, taskID int) error {
 	return args.Error(0)
 }
 
-func (m *MockStorage) GetTasks(ctx context.Context, filter models.TaskFilter, pagination models.Pagination) ([]models.Task, error) {
+func (m *MockStorage) GetTasks(ctx context.Context, filter models.TaskFilter, pagination models.Pagination) ([]models.Task, int, error) {
 	args := m.Called(ctx, filter, pagination)
-	return args.Get(0).([]models.Task), args.Error(1)
+	return args.Get(0).([]models.Task), args.Int(1), args.Error(2)
+}
+
+func (m *MockStorage) Search(ctx context.Context, query string, limit int) ([]models.SearchResult, error) {
+	args := m.Called(ctx, query, limit)
+	return args.Get(0).([]models.SearchResult), args.Error(1)
 }
 
 func (m *MockStorage) StartTaskTracking(ctx context.Context, entry models.TimeEntry) error {
@@ -93,6 +105,66 @@ func (m *MockStorage) GetUserByID(ctx context.Context, id int) (models.User, err
 	return args.Get(0).(models.User), args.Error(1)
 }
 
+func (m *MockStorage) ExportUser(ctx context.Context, id int) (models.UserExport, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(models.UserExport), args.Error(1)
+}
+
+func (m *MockStorage) EraseUser(ctx context.Context, id int) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetAuditEvents(ctx context.Context, filter models.AuditFilter, limit int) ([]models.AuditEvent, error) {
+	args := m.Called(ctx, filter, limit)
+	return args.Get(0).([]models.AuditEvent), args.Error(1)
+}
+
+func (m *MockStorage) CacheStats() storage.CacheStats {
+	args := m.Called()
+	return args.Get(0).(storage.CacheStats)
+}
+
+func (m *MockStorage) GetEnrichmentAttempts(ctx context.Context, statuses []string, limit int) ([]models.EnrichmentAttempt, error) {
+	args := m.Called(ctx, statuses, limit)
+	return args.Get(0).([]models.EnrichmentAttempt), args.Error(1)
+}
+
+func (m *MockStorage) CountEnrichmentAttempts(ctx context.Context) (map[string]int, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(map[string]int), args.Error(1)
+}
+
+func (m *MockStorage) ResyncUser(ctx context.Context, id int) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockStorage) SaveReportSubscription(ctx context.Context, sub models.ReportSubscription) (int, error) {
+	args := m.Called(ctx, sub)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockStorage) GetReportSubscription(ctx context.Context, id int) (models.ReportSubscription, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(models.ReportSubscription), args.Error(1)
+}
+
+func (m *MockStorage) GetReportSubscriptions(ctx context.Context) ([]models.ReportSubscription, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]models.ReportSubscription), args.Error(1)
+}
+
+func (m *MockStorage) DeleteReportSubscription(ctx context.Context, id int) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockStorage) EnqueueJob(ctx context.Context, job models.Job) (int64, error) {
+	args := m.Called(ctx, job)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 // MockAuthz is a mock implementation of the Authz interface
 type MockAuthz struct {
 	mock.Mock
@@ -106,20 +178,79 @@ func (m *MockAuthz) JWTAuthzMiddleware(log authz.Log) func(http.Handler) http.Ha
 	}
 }
 
-func (m *MockAuthz) GetHash(data string, salt string) []byte {
-	args := m.Called(data, salt)
+func (m *MockAuthz) HashPassword(password string) ([]byte, error) {
+	args := m.Called(password)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockAuthz) CheckPassword(hash []byte, login string, password string) bool {
+	args := m.Called(hash, login, password)
+	return args.Bool(0)
+}
+
+func (m *MockAuthz) NeedsRehash(hash []byte) bool {
+	args := m.Called(hash)
+	return args.Bool(0)
+}
+
+func (m *MockAuthz) BindLegacyHash(hash []byte, login string) []byte {
+	args := m.Called(hash, login)
 	return args.Get(0).([]byte)
 }
 
-func (m *MockAuthz) CreateJWTTokenForUser(data string) string {
-	args := m.Called(data)
+func (m *MockAuthz) CreateJWTTokenForUser(userID int) string {
+	args := m.Called(userID)
 	return args.String(0)
 }
 
+func (m *MockAuthz) CreateChallengeToken(userID int) string {
+	args := m.Called(userID)
+	return args.String(0)
+}
+
+func (m *MockAuthz) DecodeChallengeToken(token string) (int, error) {
+	args := m.Called(token)
+	return args.Int(0), args.Error(1)
+}
+
 func (m *MockAuthz) AuthCookie(name string, value string) *http.Cookie {
 	return &http.Cookie{Name: name, Value: value}
 }
 
+// MockTwoFactor is a mock implementation of the TwoFactor interface
+type MockTwoFactor struct {
+	mock.Mock
+}
+
+func (m *MockTwoFactor) Enabled(ctx context.Context, userID int) (bool, error) {
+	args := m.Called(ctx, userID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockTwoFactor) Enroll(ctx context.Context, userID int, account string) (models.TwoFactorEnrollment, error) {
+	args := m.Called(ctx, userID, account)
+	return args.Get(0).(models.TwoFactorEnrollment), args.Error(1)
+}
+
+func (m *MockTwoFactor) Confirm(ctx context.Context, userID int, code string) ([]string, error) {
+	args := m.Called(ctx, userID, code)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockTwoFactor) Disable(ctx context.Context, userID int, code string) error {
+	args := m.Called(ctx, userID, code)
+	return args.Error(0)
+}
+
+func (m *MockTwoFactor) Verify(ctx context.Context, userID int, code string) error {
+	args := m.Called(ctx, userID, code)
+	return args.Error(0)
+}
+
+func defaultEndTime() string {
+	return "19:00"
+}
+
 // MockLog is a mock implementation of the Log interface
 type MockLog struct {
 	mock.Mock
@@ -129,18 +260,30 @@ func (m *MockLog) Info(msg string, fields ...zapcore.Field) {
 	m.Called(msg, fields)
 }
 
+// MockPublisher is a mock implementation of the Publisher interface
+type MockPublisher struct {
+	mock.Mock
+}
+
+func (m *MockPublisher) Publish(event events.Event) {
+	m.Called(event)
+}
+
 func TestBaseController_Register(t *testing.T) {
 	storage := new(MockStorage)
 	authz := new(MockAuthz)
 	log := new(MockLog)
 	ctx := context.Background()
-	controller := NewBaseController(ctx, storage, nil, log, authz)
+	twoFactor := new(MockTwoFactor)
+	publisher := new(MockPublisher)
+	controller := NewBaseController(ctx, storage, defaultEndTime, log, authz, twoFactor, publisher, cursorCipher)
 
 	// Mock responses
 	storage.On("GetUser", ctx, mock.Anything, mock.Anything).Return(models.User{}, errors.New("not found"))
-	storage.On("InsertUser", ctx, mock.Anything).Return(nil)
-	authz.On("GetHash", mock.Anything, mock.Anything).Return([]byte("hashedPassword"))
-	authz.On("CreateJWTTokenForUser", mock.Anything).Return("jwtToken")
+	storage.On("InsertUser", mock.Anything, mock.Anything).Return(5, nil)
+	authz.On("HashPassword", "password123").Return([]byte("hashedPassword"), nil)
+	authz.On("CreateJWTTokenForUser", 5).Return("jwtToken")
+	publisher.On("Publish", events.Event{Type: events.UserRegistered, ID: 5}).Return()
 
 	// Mock log calls
 	log.On("Info", mock.Anything, mock.Anything).Return()
@@ -159,7 +302,9 @@ func TestBaseController_Register(t *testing.T) {
 		router.ServeHTTP(rr, req.WithContext(ctx))
 
 		assert.Equal(t, http.StatusOK, rr.Code)
-		storage.AssertCalled(t, "InsertUser", ctx, mock.Anything)
+		assert.Equal(t, "jwtToken", rr.Header().Get("Authorization"))
+		storage.AssertCalled(t, "InsertUser", mock.Anything, mock.Anything)
+		publisher.AssertCalled(t, "Publish", events.Event{Type: events.UserRegistered, ID: 5})
 	})
 
 	t.Run("Bad Request", func(t *testing.T) {
@@ -178,14 +323,18 @@ func TestBaseController_Login(t *testing.T) {
 	authz := new(MockAuthz)
 	log := new(MockLog)
 	ctx := context.Background()
-	controller := NewBaseController(ctx, storage, nil, log, authz)
+	twoFactor := new(MockTwoFactor)
+	controller := NewBaseController(ctx, storage, defaultEndTime, log, authz, twoFactor, new(MockPublisher), cursorCipher)
 
 	// Mock responses for successful login
 	storage.On("GetUser", ctx, 1234, 567890).Return(models.User{
+		UUID: 3,
 		Hash: []byte("hashedPassword"),
-	}, nil)
-	authz.On("GetHash", "1234 567890", "password123").Return([]byte("hashedPassword"))
-	authz.On("CreateJWTTokenForUser", "1234 567890").Return("jwtToken")
+	}, nil).Once()
+	authz.On("CheckPassword", []byte("hashedPassword"), "1234 567890", "password123").Return(true)
+	authz.On("NeedsRehash", []byte("hashedPassword")).Return(false)
+	authz.On("CreateJWTTokenForUser", 3).Return("jwtToken")
+	twoFactor.On("Enabled", ctx, 3).Return(false, nil)
 
 	// Mock log calls
 	log.On("Info", mock.Anything, mock.Anything).Return()
@@ -204,11 +353,36 @@ func TestBaseController_Login(t *testing.T) {
 		router.ServeHTTP(rr, req.WithContext(ctx))
 
 		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "jwtToken", rr.Header().Get("Authorization"))
+		storage.AssertNotCalled(t, "UpdateUser", mock.Anything, mock.Anything)
+	})
+
+	// Mock responses for a user with a legacy password hash
+	storage.On("GetUser", ctx, 1234, 567890).Return(models.User{
+		UUID: 3,
+		Hash: []byte("legacyHash"),
+	}, nil).Once()
+	authz.On("CheckPassword", []byte("legacyHash"), "1234 567890", "password123").Return(true)
+	authz.On("NeedsRehash", []byte("legacyHash")).Return(true)
+	authz.On("HashPassword", "password123").Return([]byte("saltedHash"), nil)
+	storage.On("UpdateUser", mock.Anything, models.User{UUID: 3, Hash: []byte("saltedHash")}).Return(nil)
+
+	t.Run("Legacy Hash Is Upgraded", func(t *testing.T) {
+		payload, _ := json.Marshal(models.RequestUser{
+			PassportNumber: "1234 567890",
+			Password:       "password123",
+		})
+
+		req, _ := http.NewRequest("POST", "/api/user/login", bytes.NewBuffer(payload))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req.WithContext(ctx))
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		storage.AssertCalled(t, "UpdateUser", mock.Anything, models.User{UUID: 3, Hash: []byte("saltedHash")})
 	})
 
 	// Mock responses for unauthorized login
 	storage.On("GetUser", ctx, 1234, 567890).Return(models.User{}, errors.New("not found"))
-	authz.On("GetHash", "1234 567890", "wrongpassword").Return([]byte("wronghashedPassword"))
 
 	t.Run("Unauthorized", func(t *testing.T) {
 		user := models.RequestUser{
@@ -224,3 +398,210 @@ func TestBaseController_Login(t *testing.T) {
 		assert.Equal(t, http.StatusUnauthorized, rr.Code)
 	})
 }
+
+func TestBaseController_LoginTwoFactor(t *testing.T) {
+	storage := new(MockStorage)
+	authz := new(MockAuthz)
+	log := new(MockLog)
+	twoFactor := new(MockTwoFactor)
+	ctx := context.Background()
+	controller := NewBaseController(ctx, storage, defaultEndTime, log, authz, twoFactor, new(MockPublisher), cursorCipher)
+
+	user := models.User{
+		UUID: 7,
+		Hash: []byte("hashedPassword"),
+	}
+	storage.On("GetUser", ctx, 1234, 567890).Return(user, nil)
+	storage.On("GetUserByID", ctx, 7).Return(user, nil)
+	authz.On("CheckPassword", []byte("hashedPassword"), "1234 567890", "password123").Return(true)
+	authz.On("NeedsRehash", []byte("hashedPassword")).Return(false)
+	authz.On("CreateChallengeToken", 7).Return("challengeToken")
+	authz.On("DecodeChallengeToken", "challengeToken").Return(7, nil)
+	authz.On("DecodeChallengeToken", "forged").Return(0, errors.New("invalid token"))
+	authz.On("CreateJWTTokenForUser", 7).Return("jwtToken")
+	twoFactor.On("Enabled", ctx, 7).Return(true, nil)
+	twoFactor.On("Verify", mock.Anything, 7, "123456").Return(nil)
+	twoFactor.On("Verify", mock.Anything, 7, "000000").Return(twofactor.ErrInvalidCode)
+	twoFactor.On("Verify", mock.Anything, 7, "111111").Return(twofactor.ErrLocked)
+
+	log.On("Info", mock.Anything, mock.Anything).Return()
+
+	router := controller.Route()
+
+	t.Run("Password Login Returns Challenge", func(t *testing.T) {
+		payload, _ := json.Marshal(models.RequestUser{
+			PassportNumber: "1234 567890",
+			Password:       "password123",
+		})
+
+		req, _ := http.NewRequest("POST", "/api/user/login", bytes.NewBuffer(payload))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req.WithContext(ctx))
+
+		var resp models.ResponseTwoFactorChallenge
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "challengeToken", resp.ChallengeToken)
+		assert.Empty(t, rr.Header().Get("Authorization"))
+		authz.AssertNotCalled(t, "CreateJWTTokenForUser", 7)
+	})
+
+	t.Run("Valid Code", func(t *testing.T) {
+		payload, _ := json.Marshal(models.RequestTwoFactorLogin{ChallengeToken: "challengeToken", Code: "123456"})
+
+		req, _ := http.NewRequest("POST", "/api/user/login/2fa", bytes.NewBuffer(payload))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req.WithContext(ctx))
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "jwtToken", rr.Header().Get("Authorization"))
+	})
+
+	t.Run("Invalid Code", func(t *testing.T) {
+		payload, _ := json.Marshal(models.RequestTwoFactorLogin{ChallengeToken: "challengeToken", Code: "000000"})
+
+		req, _ := http.NewRequest("POST", "/api/user/login/2fa", bytes.NewBuffer(payload))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req.WithContext(ctx))
+
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("Locked Out", func(t *testing.T) {
+		payload, _ := json.Marshal(models.RequestTwoFactorLogin{ChallengeToken: "challengeToken", Code: "111111"})
+
+		req, _ := http.NewRequest("POST", "/api/user/login/2fa", bytes.NewBuffer(payload))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req.WithContext(ctx))
+
+		assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+		assert.Empty(t, rr.Header().Get("Authorization"))
+	})
+
+	t.Run("Invalid Challenge", func(t *testing.T) {
+		payload, _ := json.Marshal(models.RequestTwoFactorLogin{ChallengeToken: "forged", Code: "123456"})
+
+		req, _ := http.NewRequest("POST", "/api/user/login/2fa", bytes.NewBuffer(payload))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req.WithContext(ctx))
+
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+}
+
+func TestBaseController_UpdateUserPublishesPassportChange(t *testing.T) {
+	store := new(MockStorage)
+	log := new(MockLog)
+	ctx := context.Background()
+	publisher := new(MockPublisher)
+	controller := NewBaseController(ctx, store, defaultEndTime, log, new(MockAuthz), new(MockTwoFactor), publisher, cursorCipher)
+
+	log.On("Info", mock.Anything, mock.Anything).Return()
+	store.On("GetUserByID", ctx, 3).Return(models.User{UUID: 3, PassportSerie: 1234, PassportNumber: 567890}, nil)
+	store.On("UpdateUser", mock.Anything, mock.Anything).Return(nil)
+	publisher.On("Publish", mock.Anything).Return()
+
+	principalCtx := authz.WithPrincipal(ctx, models.Principal{UserID: 3})
+	router := controller.Route()
+	update := func(body string) {
+		t.Helper()
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodPatch, "/api/user/3", bytes.NewBufferString(body)).WithContext(principalCtx))
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+
+	update(`{"timezone": "Europe/Moscow"}`)
+	update(`{"passportSerie": 1234, "passportNumber": 567890}`)
+	publisher.AssertNotCalled(t, "Publish", mock.Anything)
+
+	update(`{"passportNumber": 111111}`)
+	publisher.AssertCalled(t, "Publish", events.Event{Type: events.UserPassportChanged, ID: 3})
+}
+
+func TestBaseController_UpdateUserBindsLegacyHash(t *testing.T) {
+	store := new(MockStorage)
+	log := new(MockLog)
+	authorizer := new(MockAuthz)
+	ctx := context.Background()
+	publisher := new(MockPublisher)
+	controller := NewBaseController(ctx, store, defaultEndTime, log, authorizer, new(MockTwoFactor), publisher, cursorCipher)
+
+	log.On("Info", mock.Anything, mock.Anything).Return()
+	publisher.On("Publish", mock.Anything).Return()
+	store.On("GetUserByID", ctx, 3).Return(models.User{UUID: 3, PassportSerie: 1234, PassportNumber: 567890, Hash: []byte("legacyHash")}, nil)
+	authorizer.On("NeedsRehash", []byte("legacyHash")).Return(true)
+	authorizer.On("BindLegacyHash", []byte("legacyHash"), "1234 567890").Return([]byte("boundHash"))
+	store.On("UpdateUser", mock.Anything, models.User{UUID: 3, PassportSerie: 1234, PassportNumber: 111111, Hash: []byte("boundHash")}).Return(nil)
+
+	rr := httptest.NewRecorder()
+	controller.Route().ServeHTTP(rr, httptest.NewRequest(http.MethodPatch, "/api/user/3", bytes.NewBufferString(`{"passportSerie": 1234, "passportNumber": 111111}`)).WithContext(authz.WithPrincipal(ctx, models.Principal{UserID: 3})))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	store.AssertExpectations(t)
+}
+
+func TestBaseController_UserAccess(t *testing.T) {
+	store := new(MockStorage)
+	log := new(MockLog)
+	ctx := context.Background()
+	controller := NewBaseController(ctx, store, defaultEndTime, log, new(MockAuthz), new(MockTwoFactor), new(MockPublisher), cursorCipher)
+
+	log.On("Info", mock.Anything, mock.Anything).Return()
+	store.On("DeleteUser", mock.Anything, 8).Return(nil)
+	store.On("GetUsers", ctx, mock.Anything, mock.Anything).Return([]models.User{
+		{UUID: 8, PassportSerie: 1234, PassportNumber: 567890, Surname: "Ivanov", Name: "Ivan", Address: "Moscow", Timezone: "Europe/Moscow"},
+	}, 1, nil)
+
+	router := controller.Route()
+	serve := func(principal models.Principal, method, target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(method, target, bytes.NewBufferString(`{"timezone": "UTC"}`)).WithContext(authz.WithPrincipal(ctx, principal)))
+		return rr
+	}
+
+	t.Run("another user cannot change or delete the user", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, serve(models.Principal{UserID: 7}, http.MethodPatch, "/api/user/8").Code)
+		assert.Equal(t, http.StatusForbidden, serve(models.Principal{UserID: 7}, http.MethodDelete, "/api/user/8").Code)
+		store.AssertNotCalled(t, "UpdateUser", mock.Anything, mock.Anything)
+		store.AssertNotCalled(t, "DeleteUser", mock.Anything, mock.Anything)
+	})
+
+	t.Run("admin deletes another user", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve(models.Principal{UserID: 7, Role: models.RoleAdmin}, http.MethodDelete, "/api/user/8").Code)
+	})
+
+	t.Run("other users are listed without passport and address", func(t *testing.T) {
+		rr := serve(models.Principal{UserID: 7}, http.MethodGet, "/api/users")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NotContains(t, rr.Body.String(), "passport")
+		assert.NotContains(t, rr.Body.String(), "address")
+		assert.Contains(t, rr.Body.String(), `"surname":"Ivanov"`)
+	})
+
+	t.Run("admin lists full users", func(t *testing.T) {
+		rr := serve(models.Principal{UserID: 7, Role: models.RoleAdmin}, http.MethodGet, "/api/users")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), `"passportNumber":567890`)
+	})
+
+	t.Run("other users cannot filter or sort by private fields", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, serve(models.Principal{UserID: 7}, http.MethodGet, "/api/users?passportNumber=567890").Code)
+		assert.Equal(t, http.StatusForbidden, serve(models.Principal{UserID: 7}, http.MethodGet, "/api/users?sort=address").Code)
+	})
+}
+
+func TestBaseController_GetUsersTooMany(t *testing.T) {
+	store := new(MockStorage)
+	log := new(MockLog)
+	ctx := context.Background()
+	controller := NewBaseController(ctx, store, defaultEndTime, log, new(MockAuthz), new(MockTwoFactor), new(MockPublisher), cursorCipher)
+
+	log.On("Info", mock.Anything, mock.Anything).Return()
+	store.On("GetUsers", ctx, mock.Anything, mock.Anything).Return([]models.User(nil), 0, storage.ErrTooManyUsers)
+
+	rr := httptest.NewRecorder()
+	controller.Route().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/users?surname=Iv", nil).WithContext(authz.WithPrincipal(ctx, models.Principal{UserID: 7})))
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
@@ -0,0 +1,322 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/gorilla/websocket"
+	authz "github.com/wurt83ow/timetracker/internal/authorization"
+	"github.com/wurt83ow/timetracker/internal/events"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"go.uber.org/zap"
+)
+
+const (
+	// boardWriteWait bounds every write to a board socket
+	boardWriteWait = 10 * time.Second
+	// boardPongWait is the longest a client may stay silent, pings keep it talking
+	boardPongWait   = 60 * time.Second
+	boardPingPeriod = boardPongWait * 9 / 10
+	// boardMaxMessage bounds the size of a command
+	boardMaxMessage = 4096
+	// boardQueueSize is the number of acknowledgements waiting to be written
+	boardQueueSize = 16
+)
+
+// BoardStorage is the storage of the running timers
+type BoardStorage interface {
+	StartTaskTracking(context.Context, models.TimeEntry) error
+	StopTaskTracking(context.Context, models.TimeEntry) error
+	GetRunningTimers(context.Context) ([]models.RunningTimer, error)
+}
+
+// EventBus announces the timers started and stopped and follows them on the other sockets
+type EventBus interface {
+	Publisher
+	Subscribe(events.Handler, ...string) func()
+}
+
+type BoardController struct {
+	ctx      context.Context
+	storage  BoardStorage
+	bus      EventBus
+	authz    Authenticator
+	log      Log
+	upgrader websocket.Upgrader
+	now      func() time.Time
+}
+
+// NewBoardController creates a new BoardController instance
+func NewBoardController(ctx context.Context, storage BoardStorage, bus EventBus, authz Authenticator, log Log) *BoardController {
+	return &BoardController{
+		ctx:     ctx,
+		storage: storage,
+		bus:     bus,
+		authz:   authz,
+		log:     log,
+		now:     time.Now,
+	}
+}
+
+// Route sets up the routes for the BoardController, to be mounted at /api/board
+func (c *BoardController) Route() *chi.Mux {
+	r := chi.NewRouter()
+	r.Use(c.authz.JWTAuthzMiddleware(c.log))
+
+	r.Get("/ws", c.Board)
+
+	return r
+}
+
+// @Summary Team board of running timers
+// @Description WebSocket with the running timers of every user. The server sends a models.BoardSnapshot when the
+// @Description socket opens and whenever a timer is started or stopped. The client may send models.BoardCommand
+// @Description messages to start or stop a timer of its user, or to switch to another task, which stops the other
+// @Description running timers of the user first; every command is answered with a models.BoardAck with its id.
+// @Description The handshake is authenticated like the other requests, e.g. with the jwt-token cookie.
+// @Tags Task
+// @Success 101 {object} models.BoardSnapshot "Switching protocols"
+// @Failure 400 {string} string "Not a WebSocket handshake"
+// @Failure 401 {string} string "Unauthorized"
+// @Router /api/board/ws [get]
+func (c *BoardController) Board(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authz.PrincipalFromContext(r.Context())
+	if !ok {
+		c.log.Info("principal not found in context")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Upgrade writes the error response itself
+	conn, err := c.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		c.log.Info("error upgrading board connection: ", zap.Error(err))
+		return
+	}
+	defer conn.Close()
+
+	// The snapshots of a burst of timer events are coalesced
+	changed := make(chan struct{}, 1)
+	unsubscribe := c.bus.Subscribe(func(events.Event) {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}, events.TimerStarted, events.TimerStopped)
+	defer unsubscribe()
+
+	acks := make(chan models.BoardAck, boardQueueSize)
+	quit := make(chan struct{})
+	defer close(quit)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.readCommands(auditContext(c.ctx, r), conn, principal, acks, quit)
+	}()
+
+	ping := time.NewTicker(boardPingPeriod)
+	defer ping.Stop()
+
+	// Only this goroutine writes to the socket
+	if err := c.writeSnapshot(conn); err != nil {
+		c.log.Info("error writing board snapshot: ", zap.Error(err))
+		return
+	}
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-c.ctx.Done():
+			_ = conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "server is shutting down"), time.Now().Add(boardWriteWait))
+			return
+		case ack := <-acks:
+			if err := c.write(conn, ack); err != nil {
+				return
+			}
+		case <-changed:
+			if err := c.writeSnapshot(conn); err != nil {
+				c.log.Info("error writing board snapshot: ", zap.Error(err))
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(boardWriteWait)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readCommands runs the commands of the client until the socket fails or quit is closed
+func (c *BoardController) readCommands(ctx context.Context, conn *websocket.Conn, principal models.Principal,
+	acks chan<- models.BoardAck, quit <-chan struct{},
+) {
+	conn.SetReadLimit(boardMaxMessage)
+	_ = conn.SetReadDeadline(time.Now().Add(boardPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(boardPongWait))
+	})
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				c.log.Info("error reading board command: ", zap.Error(err))
+			}
+			return
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(boardPongWait))
+
+		ack := models.BoardAck{Type: models.BoardAckMessage, OK: true}
+
+		var cmd models.BoardCommand
+		if err := json.Unmarshal(message, &cmd); err != nil {
+			ack.OK, ack.Error = false, "invalid command"
+		} else {
+			ack.ID = cmd.ID
+			if err := c.execute(ctx, principal, cmd); err != nil {
+				ack.OK, ack.Error = false, err.Error()
+			}
+		}
+
+		select {
+		case acks <- ack:
+		case <-quit:
+			return
+		}
+	}
+}
+
+// execute runs a command of the principal through the same storage calls as the
+// tracking requests
+func (c *BoardController) execute(ctx context.Context, principal models.Principal, cmd models.BoardCommand) error {
+	if cmd.TaskID <= 0 {
+		return errors.New("task_id is required")
+	}
+
+	switch cmd.Action {
+	case models.BoardStart:
+		return c.start(ctx, principal, cmd.TaskID)
+
+	case models.BoardStop:
+		running, err := c.runningTimers(ctx, principal.UserID)
+		if err != nil {
+			return err
+		}
+		for _, timer := range running {
+			if timer.TaskID == cmd.TaskID {
+				return c.stop(ctx, principal, timer)
+			}
+		}
+		return fmt.Errorf("task %d is not being tracked", cmd.TaskID)
+
+	case models.BoardSwitch:
+		running, err := c.runningTimers(ctx, principal.UserID)
+		if err != nil {
+			return err
+		}
+		tracked := false
+		for _, timer := range running {
+			if timer.TaskID == cmd.TaskID {
+				tracked = true
+				continue
+			}
+			if err := c.stop(ctx, principal, timer); err != nil {
+				return err
+			}
+		}
+		if tracked {
+			return nil
+		}
+		return c.start(ctx, principal, cmd.TaskID)
+	}
+
+	return fmt.Errorf("unknown action %q", cmd.Action)
+}
+
+func (c *BoardController) start(ctx context.Context, principal models.Principal, taskID int) error {
+	entry, err := trackingEntry(principal, taskID)
+	if err != nil {
+		c.log.Info("invalid user timezone", zap.Error(err))
+		return errors.New("invalid user timezone")
+	}
+
+	if err := c.storage.StartTaskTracking(ctx, entry); err != nil {
+		c.log.Info("error starting task tracking", zap.Error(err))
+		return fmt.Errorf("cannot start tracking task %d", taskID)
+	}
+	publishTimer(c.bus, events.TimerStarted, entry)
+
+	return nil
+}
+
+// stop stops the running timer on the date it was started
+func (c *BoardController) stop(ctx context.Context, principal models.Principal, timer models.RunningTimer) error {
+	entry, err := trackingEntry(principal, timer.TaskID)
+	if err != nil {
+		c.log.Info("invalid user timezone", zap.Error(err))
+		return errors.New("invalid user timezone")
+	}
+	if entry.EventDate, err = time.Parse(time.DateOnly, timer.EventDate); err != nil {
+		return err
+	}
+
+	if err := c.storage.StopTaskTracking(ctx, entry); err != nil {
+		c.log.Info("error stopping task tracking", zap.Error(err))
+		return fmt.Errorf("cannot stop tracking task %d", timer.TaskID)
+	}
+	publishTimer(c.bus, events.TimerStopped, entry)
+
+	return nil
+}
+
+// runningTimers returns the running timers of the user
+func (c *BoardController) runningTimers(ctx context.Context, userID int) ([]models.RunningTimer, error) {
+	timers, err := c.storage.GetRunningTimers(ctx)
+	if err != nil {
+		c.log.Info("error getting running timers: ", zap.Error(err))
+		return nil, errors.New("cannot get the running timers")
+	}
+
+	running := make([]models.RunningTimer, 0, 1)
+	for _, timer := range timers {
+		if timer.UserID == userID {
+			running = append(running, timer)
+		}
+	}
+
+	return running, nil
+}
+
+// writeSnapshot sends the running timers of every user
+func (c *BoardController) writeSnapshot(conn *websocket.Conn) error {
+	timers, err := c.storage.GetRunningTimers(c.ctx)
+	if err != nil {
+		return err
+	}
+
+	now := c.now()
+	snapshot := models.BoardSnapshot{Type: models.BoardSnapshotMessage, At: now.UTC(), Timers: make([]models.BoardTimer, 0, len(timers))}
+	for _, timer := range timers {
+		elapsed := int64(now.Sub(timer.StartedAt) / time.Second)
+		if elapsed < 0 {
+			elapsed = 0
+		}
+		snapshot.Timers = append(snapshot.Timers, models.BoardTimer{RunningTimer: timer, ElapsedSeconds: elapsed})
+	}
+
+	return c.write(conn, snapshot)
+}
+
+func (c *BoardController) write(conn *websocket.Conn, message any) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(boardWriteWait)); err != nil {
+		return err
+	}
+	return conn.WriteJSON(message)
+}
@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	authz "github.com/wurt83ow/timetracker/internal/authorization"
+	"github.com/wurt83ow/timetracker/internal/encryption"
+	"github.com/wurt83ow/timetracker/internal/events"
+	"github.com/wurt83ow/timetracker/internal/memkeeper"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"go.uber.org/zap"
+)
+
+func TestBoardController_Board(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	envelope, err := encryption.NewEnvelope("test_encryption_key")
+	require.NoError(t, err)
+	kp := memkeeper.NewMemKeeper(func() string { return "" }, zap.NewNop(), func() string { return "5m" }, envelope)
+
+	var userIDs []int
+	for i := 1; i <= 2; i++ {
+		id, err := kp.SaveUser(ctx, models.User{PassportSerie: 1000 + i, PassportNumber: 100000 + i, Name: "User", Timezone: "UTC"})
+		require.NoError(t, err)
+		userIDs = append(userIDs, id)
+	}
+	var taskIDs []int
+	for _, name := range []string{"Design", "Review"} {
+		id, err := kp.SaveTask(ctx, models.Task{Name: name, CreatedAt: time.Now()})
+		require.NoError(t, err)
+		taskIDs = append(taskIDs, id)
+	}
+
+	bus := events.NewBus(zap.NewNop())
+	defer bus.Close()
+
+	log := new(MockLog)
+	log.On("Info", mock.Anything, mock.Anything).Return()
+	router := NewBoardController(ctx, kp, bus, new(MockAuthz), log).Route()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := strconv.Atoi(r.URL.Query().Get("user"))
+		principal := models.Principal{UserID: userID, Timezone: "UTC"}
+		router.ServeHTTP(w, r.WithContext(authz.WithPrincipal(r.Context(), principal)))
+	}))
+	defer server.Close()
+
+	dial := func(userID int) *websocket.Conn {
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?user=" + strconv.Itoa(userID)
+		conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	// read returns the next message of the type, skipping the others
+	read := func(conn *websocket.Conn, messageType string, v any) {
+		t.Helper()
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+		for {
+			_, message, err := conn.ReadMessage()
+			require.NoError(t, err)
+			if strings.Contains(string(message), `"type":"`+messageType+`"`) {
+				require.NoError(t, json.Unmarshal(message, v))
+				return
+			}
+		}
+	}
+
+	// readTimers returns the timers of the snapshots until one has n of them
+	readTimers := func(conn *websocket.Conn, n int) []models.BoardTimer {
+		t.Helper()
+		for {
+			var snapshot models.BoardSnapshot
+			read(conn, models.BoardSnapshotMessage, &snapshot)
+			if len(snapshot.Timers) == n {
+				return snapshot.Timers
+			}
+		}
+	}
+
+	send := func(conn *websocket.Conn, cmd models.BoardCommand) models.BoardAck {
+		t.Helper()
+		require.NoError(t, conn.WriteJSON(cmd))
+		var ack models.BoardAck
+		read(conn, models.BoardAckMessage, &ack)
+		return ack
+	}
+
+	first, second := dial(userIDs[0]), dial(userIDs[1])
+	assert.Empty(t, readTimers(first, 0))
+	assert.Empty(t, readTimers(second, 0))
+
+	ack := send(first, models.BoardCommand{ID: "1", Action: models.BoardStart, TaskID: taskIDs[0]})
+	assert.Equal(t, models.BoardAck{Type: models.BoardAckMessage, ID: "1", OK: true}, ack)
+
+	// Every socket sees the timers of every user
+	timers := readTimers(second, 1)
+	assert.Equal(t, userIDs[0], timers[0].UserID)
+	assert.Equal(t, taskIDs[0], timers[0].TaskID)
+	assert.GreaterOrEqual(t, timers[0].ElapsedSeconds, int64(0))
+
+	// A switch stops the running timer and starts the other task
+	ack = send(first, models.BoardCommand{ID: "2", Action: models.BoardSwitch, TaskID: taskIDs[1]})
+	require.True(t, ack.OK, ack.Error)
+	running, err := kp.GetRunningTimers(ctx)
+	require.NoError(t, err)
+	require.Len(t, running, 1)
+	assert.Equal(t, taskIDs[1], running[0].TaskID)
+
+	ack = send(second, models.BoardCommand{ID: "3", Action: models.BoardStart, TaskID: taskIDs[1]})
+	require.True(t, ack.OK, ack.Error)
+	assert.Len(t, readTimers(first, 2), 2)
+
+	// The commands act on the timers of the user only
+	ack = send(second, models.BoardCommand{ID: "4", Action: models.BoardStop, TaskID: taskIDs[0]})
+	assert.False(t, ack.OK)
+	assert.Equal(t, "4", ack.ID)
+
+	ack = send(second, models.BoardCommand{ID: "5", Action: models.BoardStop, TaskID: taskIDs[1]})
+	require.True(t, ack.OK, ack.Error)
+	assert.Len(t, readTimers(first, 1), 1)
+
+	// A rejected start and invalid commands are acknowledged with an error
+	assert.False(t, send(first, models.BoardCommand{ID: "6", Action: models.BoardStart, TaskID: taskIDs[1]}).OK)
+	assert.False(t, send(first, models.BoardCommand{ID: "7", Action: "pause", TaskID: taskIDs[1]}).OK)
+	assert.False(t, send(first, models.BoardCommand{ID: "8", Action: models.BoardStart}).OK)
+
+	require.NoError(t, first.WriteMessage(websocket.TextMessage, []byte(`{"id":`)))
+	var invalid models.BoardAck
+	read(first, models.BoardAckMessage, &invalid)
+	assert.False(t, invalid.OK)
+
+	// The sockets are closed when the server shuts down
+	cancel()
+	require.NoError(t, first.SetReadDeadline(time.Now().Add(2*time.Second)))
+	for {
+		if _, _, err := first.ReadMessage(); err != nil {
+			assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), err)
+			break
+		}
+	}
+}
+
+func TestBoardController_RejectsPlainRequests(t *testing.T) {
+	log := new(MockLog)
+	log.On("Info", mock.Anything, mock.Anything).Return()
+	bus := events.NewBus(zap.NewNop())
+	defer bus.Close()
+	router := NewBoardController(context.Background(), nil, bus, new(MockAuthz), log).Route()
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/ws", nil))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	rr = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	router.ServeHTTP(rr, req.WithContext(authz.WithPrincipal(req.Context(), models.Principal{UserID: 1})))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
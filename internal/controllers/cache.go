@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	authz "github.com/wurt83ow/timetracker/internal/authorization"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"go.uber.org/zap"
+)
+
+// @Summary Cache statistics
+// @Description Get the storage mode and the hit and miss statistics of the user and task caches. Only available to administrators.
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} storage.CacheStats "Cache statistics"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Router /api/cache/stats [get]
+func (h *BaseController) GetCacheStats(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authz.PrincipalFromContext(r.Context())
+	if !ok {
+		h.log.Info("principal not found in context")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if principal.Role != models.RoleAdmin {
+		h.log.Info("access to the cache statistics denied", zap.Int("userID", principal.UserID))
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.storage.CacheStats()); err != nil {
+		h.log.Info("error encoding response: ", zap.Error(err))
+	}
+}
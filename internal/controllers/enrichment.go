@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	authz "github.com/wurt83ow/timetracker/internal/authorization"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultEnrichmentLimit = 50
+	maxEnrichmentLimit     = 200
+)
+
+var errInvalidStatus = errors.New("invalid enrichment status")
+
+// @Summary Enrichment status
+// @Description Count the users by enrichment status and list the failing ones, most recently updated first.
+// @Description Dead users are not retried until a resync. Only available to administrators.
+// @Tags Admin
+// @Produce json
+// @Param status query string false "Comma-separated statuses: succeeded, failed, dead (default failed,dead)"
+// @Param limit query int false "Limit (default 50, max 200)"
+// @Success 200 {object} models.ResponseEnrichment "Enrichment status"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /api/admin/enrichment [get]
+func (h *BaseController) GetEnrichment(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authz.PrincipalFromContext(r.Context())
+	if !ok {
+		h.log.Info("principal not found in context")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if principal.Role != models.RoleAdmin {
+		h.log.Info("access to the enrichment status denied", zap.Int("userID", principal.UserID))
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	statuses, limit, err := parseEnrichmentQuery(r)
+	if err != nil {
+		h.log.Info("invalid enrichment query: ", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	counts, err := h.storage.CountEnrichmentAttempts(r.Context())
+	if err != nil {
+		h.log.Info("error counting enrichment attempts: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	attempts, err := h.storage.GetEnrichmentAttempts(r.Context(), statuses, limit)
+	if err != nil {
+		h.log.Info("error getting enrichment attempts: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(models.ResponseEnrichment{Counts: counts, Attempts: attempts}); err != nil {
+		h.log.Info("error encoding response: ", zap.Error(err))
+	}
+}
+
+// @Summary Resync user
+// @Description Refresh the external data of a user on the next enrichment pass, even if it was checked
+// @Description recently or moved to the dead letters. Available to the user and to administrators.
+// @Tags User
+// @Param id path int true "User ID"
+// @Success 202 {string} string "Resync scheduled"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not Found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /api/users/{id}/resync [post]
+func (h *BaseController) ResyncUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.dataSubject(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.storage.ResyncUser(auditContext(h.ctx, r), id)
+	if errors.Is(err, storage.ErrNotFound) {
+		h.log.Info("user not found")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.log.Info("error resyncing user: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	h.log.Info("User resync scheduled", zap.Int("id", id))
+}
+
+func parseEnrichmentQuery(r *http.Request) ([]string, int, error) {
+	statuses := []string{models.EnrichmentFailed, models.EnrichmentDead}
+	if v := r.URL.Query().Get("status"); v != "" {
+		statuses = nil
+		for _, status := range strings.Split(v, ",") {
+			switch status = strings.TrimSpace(status); status {
+			case models.EnrichmentSucceeded, models.EnrichmentFailed, models.EnrichmentDead:
+				statuses = append(statuses, status)
+			default:
+				return nil, 0, errInvalidStatus
+			}
+		}
+	}
+
+	limit := defaultEnrichmentLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		val, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, 0, err
+		}
+		limit = val
+	}
+	if limit <= 0 {
+		limit = defaultEnrichmentLimit
+	}
+	if limit > maxEnrichmentLimit {
+		limit = maxEnrichmentLimit
+	}
+
+	return statuses, limit, nil
+}
@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	authz "github.com/wurt83ow/timetracker/internal/authorization"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+)
+
+func TestBaseController_GetEnrichment(t *testing.T) {
+	store := new(MockStorage)
+	log := new(MockLog)
+	ctx := context.Background()
+	controller := NewBaseController(ctx, store, defaultEndTime, log, new(MockAuthz), new(MockTwoFactor), new(MockPublisher), cursorCipher)
+
+	next := time.Date(2024, 7, 1, 9, 0, 0, 0, time.UTC)
+	attempts := []models.EnrichmentAttempt{
+		{UserID: 3, Status: models.EnrichmentFailed, Attempts: 2, LastError: "status code error: 502", NextRetryAt: &next, UpdatedAt: next.Add(-time.Minute)},
+	}
+	counts := map[string]int{models.EnrichmentSucceeded: 10, models.EnrichmentFailed: 1}
+	store.On("CountEnrichmentAttempts", mock.Anything).Return(counts, nil)
+	store.On("GetEnrichmentAttempts", mock.Anything, []string{models.EnrichmentFailed, models.EnrichmentDead}, defaultEnrichmentLimit).Return(attempts, nil)
+	store.On("GetEnrichmentAttempts", mock.Anything, []string{models.EnrichmentDead}, maxEnrichmentLimit).Return([]models.EnrichmentAttempt{}, nil)
+	log.On("Info", mock.Anything, mock.Anything).Return()
+
+	router := controller.Route()
+	get := func(target, role string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		principal := models.Principal{UserID: 1, Role: role}
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil).WithContext(authz.WithPrincipal(ctx, principal)))
+		return rr
+	}
+
+	rr := get("/api/admin/enrichment", models.RoleAdmin)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var response models.ResponseEnrichment
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, counts, response.Counts)
+	require.Len(t, response.Attempts, 1)
+	assert.Equal(t, "status code error: 502", response.Attempts[0].LastError)
+
+	rr = get("/api/admin/enrichment?status=dead&limit=1000", models.RoleAdmin)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"attempts":[]`)
+
+	assert.Equal(t, http.StatusBadRequest, get("/api/admin/enrichment?status=pending", models.RoleAdmin).Code)
+	assert.Equal(t, http.StatusForbidden, get("/api/admin/enrichment", models.RoleUser).Code)
+}
+
+func TestBaseController_ResyncUser(t *testing.T) {
+	store := new(MockStorage)
+	log := new(MockLog)
+	ctx := context.Background()
+	controller := NewBaseController(ctx, store, defaultEndTime, log, new(MockAuthz), new(MockTwoFactor), new(MockPublisher), cursorCipher)
+
+	store.On("ResyncUser", mock.Anything, 7).Return(nil)
+	store.On("ResyncUser", mock.Anything, 8).Return(storage.ErrNotFound)
+	log.On("Info", mock.Anything, mock.Anything).Return()
+
+	router := controller.Route()
+	post := func(target string, principal models.Principal) int {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, target, nil).WithContext(authz.WithPrincipal(ctx, principal)))
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusAccepted, post("/api/users/7/resync", models.Principal{UserID: 7}))
+	assert.Equal(t, http.StatusForbidden, post("/api/users/8/resync", models.Principal{UserID: 7}))
+	assert.Equal(t, http.StatusNotFound, post("/api/users/8/resync", models.Principal{UserID: 1, Role: models.RoleAdmin}))
+	assert.Equal(t, http.StatusBadRequest, post("/api/users/abc/resync", models.Principal{UserID: 7}))
+}
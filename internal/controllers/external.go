@@ -3,19 +3,30 @@ package controllers
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
-	"strings"
 
+	"github.com/go-chi/chi"
+	"github.com/wurt83ow/timetracker/internal/httpclient"
 	"github.com/wurt83ow/timetracker/internal/models"
 	"go.uber.org/zap"
 )
 
 type ExtController struct {
-	ctx     context.Context
-	storage Storage
-	log     Log
-	extAddr func() string
+	ctx      context.Context
+	storage  Storage
+	enricher Enricher
+	breaker  Breaker
+	log      Log
+}
+
+// Enricher looks up the data of a user in the enrichment providers
+type Enricher interface {
+	Enrich(ctx context.Context, passportSerie, passportNumber int) (models.ExtUserData, error)
+}
+
+// Breaker reports the circuit breaker guarding the external API system
+type Breaker interface {
+	Status() httpclient.BreakerStatus
 }
 
 type Pool interface {
@@ -23,49 +34,43 @@ type Pool interface {
 	GetResults() <-chan interface{}
 }
 
-func NewExtController(ctx context.Context, storage Storage, extAddr func() string, log Log) *ExtController {
+func NewExtController(ctx context.Context, storage Storage, enricher Enricher, breaker Breaker, log Log) *ExtController {
 	return &ExtController{
-		ctx:     ctx,
-		storage: storage,
-		log:     log,
-		extAddr: extAddr,
+		ctx:      ctx,
+		storage:  storage,
+		enricher: enricher,
+		breaker:  breaker,
+		log:      log,
 	}
 }
 
-func (c *ExtController) GetUserInfo(passportSerie int, passportNumber int) (models.ExtUserData, error) {
-
-	addr := c.extAddr()
-
-	// Add http scheme if it is missing
-	if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
-		addr = "http://" + addr
-	}
-
-	if string(addr[len(addr)-1]) != "/" {
-		addr = addr + "/"
-	}
-
-	url := fmt.Sprintf("%sinfo?passportSerie=%d&passportNumber=%d", addr, passportSerie, passportNumber)
-
-	resp, err := http.Get(url)
+func (c *ExtController) GetUserInfo(ctx context.Context, passportSerie int, passportNumber int) (models.ExtUserData, error) {
+	userInfo, err := c.enricher.Enrich(ctx, passportSerie, passportNumber)
 	if err != nil {
-		c.log.Info("unable to access user info service, check that it is running: ", zap.Error(err))
+		c.log.Info("failed to enrich user data: ", zap.Error(err))
 		return models.ExtUserData{}, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		c.log.Info("status code error: ", zap.String("method", resp.Status))
-		return models.ExtUserData{}, fmt.Errorf("status code error: %s", resp.Status)
-	}
+	return userInfo, nil
+}
 
-	var userInfo models.ExtUserData
-	if err := json.NewDecoder(resp.Body).Decode(&userInfo); err != nil {
-		return models.ExtUserData{}, err
-	}
+// Route mounts the status of the external API system
+func (c *ExtController) Route() *chi.Mux {
+	r := chi.NewRouter()
+	r.Get("/enrichment", c.GetEnrichmentStatus)
 
-	userInfo.PassportSerie = passportSerie
-	userInfo.PassportNumber = passportNumber
+	return r
+}
 
-	return userInfo, nil
+// @Summary Enrichment status
+// @Description Get the state of the circuit breaker guarding the external API system that enriches users.
+// @Tags Status
+// @Produce json
+// @Success 200 {object} httpclient.BreakerStatus "Circuit breaker status"
+// @Router /api/status/enrichment [get]
+func (c *ExtController) GetEnrichmentStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(c.breaker.Status()); err != nil {
+		c.log.Info("error encoding response: ", zap.Error(err))
+	}
 }
@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/wurt83ow/timetracker/internal/httpclient"
+	"github.com/wurt83ow/timetracker/internal/models"
+)
+
+type fakeEnricher struct {
+	data models.ExtUserData
+	err  error
+}
+
+func (e *fakeEnricher) Enrich(ctx context.Context, passportSerie, passportNumber int) (models.ExtUserData, error) {
+	if e.err != nil {
+		return models.ExtUserData{}, e.err
+	}
+	data := e.data
+	data.PassportSerie, data.PassportNumber = passportSerie, passportNumber
+	return data, nil
+}
+
+type fakeBreaker httpclient.BreakerStatus
+
+func (b fakeBreaker) Status() httpclient.BreakerStatus {
+	return httpclient.BreakerStatus(b)
+}
+
+func TestExtController_GetUserInfo(t *testing.T) {
+	log := new(MockLog)
+	log.On("Info", mock.Anything, mock.Anything).Return()
+
+	enricher := &fakeEnricher{data: models.ExtUserData{Surname: "Ivanov", Patronymic: "Ivanovich"}}
+	controller := NewExtController(context.Background(), new(MockStorage), enricher, fakeBreaker{}, log)
+
+	info, err := controller.GetUserInfo(context.Background(), 1234, 567890)
+	require.NoError(t, err)
+	assert.Equal(t, models.ExtUserData{PassportSerie: 1234, PassportNumber: 567890, Surname: "Ivanov", Patronymic: "Ivanovich"}, info)
+
+	enricher.err = httpclient.ErrCircuitOpen
+	_, err = controller.GetUserInfo(context.Background(), 1234, 567890)
+	assert.ErrorIs(t, err, httpclient.ErrCircuitOpen)
+}
+
+func TestExtController_GetEnrichmentStatus(t *testing.T) {
+	breaker := fakeBreaker{State: httpclient.StateOpen, ConsecutiveFailures: 5, Threshold: 5}
+	controller := NewExtController(context.Background(), new(MockStorage), &fakeEnricher{}, breaker, new(MockLog))
+
+	rr := httptest.NewRecorder()
+	controller.Route().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/enrichment", nil))
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"state":"open"`)
+	assert.Contains(t, rr.Body.String(), `"consecutive_failures":5`)
+}
@@ -0,0 +1,402 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	authz "github.com/wurt83ow/timetracker/internal/authorization"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap"
+)
+
+// oidcFlowCookie holds the sealed flow of a login between its start and the callback
+const oidcFlowCookie = "oidc-flow"
+
+var (
+	errNoLinkedAccount = errors.New("identity is not linked to any user")
+	errInvalidFlow     = errors.New("missing or invalid oidc flow")
+)
+
+type OIDCProvider interface {
+	Begin(context.Context) (string, models.OIDCFlow, error)
+	Complete(context.Context, models.OIDCFlow, string, string) (models.ExternalIdentity, error)
+}
+
+type IdentityStorage interface {
+	GetUser(context.Context, int, int) (models.User, error)
+	GetUserByID(context.Context, int) (models.User, error)
+	InsertUser(context.Context, models.User) (int, error)
+	GetUserByIdentity(context.Context, string, string) (models.User, error)
+	LinkIdentity(context.Context, models.UserIdentity) error
+}
+
+type SessionAuthz interface {
+	JWTAuthzMiddleware(authz.Log) func(http.Handler) http.Handler
+	CreateJWTTokenForUser(int) string
+	CreateChallengeToken(int) string
+	AuthCookie(string, string) *http.Cookie
+}
+
+// SecondFactor tells whether a user logging in has to pass the second factor
+type SecondFactor interface {
+	Enabled(context.Context, int) (bool, error)
+}
+
+// FlowCipher seals the flow cookie, so it can be neither read nor forged
+type FlowCipher interface {
+	Encrypt([]byte) (string, error)
+	Decrypt(string) ([]byte, error)
+}
+
+type OIDCController struct {
+	ctx            context.Context
+	storage        IdentityStorage
+	provider       OIDCProvider
+	authz          SessionAuthz
+	twoFactor      SecondFactor
+	cipher         FlowCipher
+	defaultEndTime func() string
+	passportClaim  func() string
+	log            Log
+}
+
+// NewOIDCController creates a new OIDCController instance
+func NewOIDCController(ctx context.Context, storage IdentityStorage, provider OIDCProvider, authz SessionAuthz,
+	twoFactor SecondFactor, cipher FlowCipher, defaultEndTime func() string, passportClaim func() string, log Log,
+) *OIDCController {
+	return &OIDCController{
+		ctx:            ctx,
+		storage:        storage,
+		provider:       provider,
+		authz:          authz,
+		twoFactor:      twoFactor,
+		cipher:         cipher,
+		defaultEndTime: defaultEndTime,
+		passportClaim:  passportClaim,
+		log:            log,
+	}
+}
+
+// Route sets up the routes for the OIDCController, to be mounted at /api/auth/oidc
+func (c *OIDCController) Route() *chi.Mux {
+	r := chi.NewRouter()
+
+	r.Get("/login", c.Login)
+	r.Get("/callback", c.Callback)
+
+	return r
+}
+
+// IdentitiesRoute sets up the routes of the logged-in user's identities, to be mounted
+// at /api/me/identities
+func (c *OIDCController) IdentitiesRoute() *chi.Mux {
+	r := chi.NewRouter()
+	r.Use(c.authz.JWTAuthzMiddleware(c.log))
+
+	r.Post("/", c.LinkIdentity)
+
+	return r
+}
+
+// @Summary Login with OpenID Connect
+// @Description Redirect to the identity provider using the authorization code flow with PKCE.
+// @Description The login is bound to the browser with a short-lived cookie checked by the callback.
+// @Tags User
+// @Success 302 {string} string "Redirect to the identity provider"
+// @Failure 502 {string} string "Identity provider is unavailable"
+// @Router /api/auth/oidc/login [get]
+func (c *OIDCController) Login(w http.ResponseWriter, r *http.Request) {
+	authURL, ok := c.begin(w, r, 0)
+	if !ok {
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// @Summary Link an OpenID Connect identity
+// @Description Start linking an identity of the provider to the current user. The client opens the returned
+// @Description URL in the same browser, and the callback links the identity instead of logging in.
+// @Tags User
+// @Produce json
+// @Success 200 {object} models.ResponseOIDCLink "URL of the identity provider"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 502 {string} string "Identity provider is unavailable"
+// @Router /api/me/identities [post]
+func (c *OIDCController) LinkIdentity(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authz.PrincipalFromContext(r.Context())
+	if !ok {
+		c.log.Info("principal not found in context")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	authURL, ok := c.begin(w, r, principal.UserID)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(models.ResponseOIDCLink{AuthURL: authURL}); err != nil {
+		c.log.Info("error encoding response: ", zap.Error(err))
+	}
+}
+
+// @Summary OpenID Connect callback
+// @Description Complete the flow started in the same browser. A login returns a JWT token for the user
+// @Description the identity is linked to, or for a new user created from the passport claim on first login,
+// @Description or a challenge token if the user has two-factor authentication enabled. An identity is never
+// @Description linked to an existing user on login, only by a flow started at /api/me/identities.
+// @Tags User
+// @Produce json
+// @Param code query string true "Authorization code"
+// @Param state query string true "State"
+// @Success 200 {object} models.ResponseUser "User logged in or identity linked"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Identity is not linked to any user"
+// @Failure 409 {string} string "Identity is linked to another user"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /api/auth/oidc/callback [get]
+func (c *OIDCController) Callback(w http.ResponseWriter, r *http.Request) {
+	// The flow is good for a single callback, whatever its outcome
+	flow, flowErr := c.readFlow(r)
+	http.SetCookie(w, c.flowCookie(r, "", -1))
+
+	if errParam := r.URL.Query().Get("error"); errParam != "" {
+		c.log.Info("identity provider returned an error: ", zap.String("error", errParam))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	state := r.URL.Query().Get("state")
+	code := r.URL.Query().Get("code")
+	if state == "" || code == "" {
+		c.log.Info("state or code was not received")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if flowErr != nil {
+		c.log.Info("cannot complete oidc login: ", zap.Error(flowErr))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	identity, err := c.provider.Complete(r.Context(), flow, state, code)
+	if err != nil {
+		c.log.Info("cannot complete oidc login: ", zap.Error(err))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if flow.LinkUserID != 0 {
+		c.completeLink(w, r, flow.LinkUserID, identity)
+		return
+	}
+
+	user, err := c.resolveUser(r, identity)
+	if errors.Is(err, errNoLinkedAccount) {
+		c.log.Info("identity is not linked to any user", zap.String("subject", identity.Subject))
+		w.WriteHeader(http.StatusForbidden)
+		return
+	} else if err != nil {
+		c.log.Info("cannot resolve user for identity: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// The identity provider stands in for the password, not for the second factor
+	enabled, err := c.twoFactor.Enabled(c.ctx, user.UUID)
+	if err != nil {
+		c.log.Info("error checking two-factor authentication: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if enabled {
+		writeTwoFactorChallenge(w, c.log, c.authz.CreateChallengeToken(user.UUID))
+		return
+	}
+
+	freshToken := c.authz.CreateJWTTokenForUser(user.UUID)
+	http.SetCookie(w, c.authz.AuthCookie("jwt-token", freshToken))
+	http.SetCookie(w, c.authz.AuthCookie("Authorization", freshToken))
+
+	w.Header().Set("Authorization", freshToken)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(models.ResponseUser{Response: "success"}); err != nil {
+		c.log.Info("error encoding response: ", zap.Error(err))
+	}
+}
+
+// begin starts a flow, linking to the user unless userID is 0, and binds it to the
+// browser. It writes an error response if it fails.
+func (c *OIDCController) begin(w http.ResponseWriter, r *http.Request, userID int) (string, bool) {
+	authURL, flow, err := c.provider.Begin(r.Context())
+	if err != nil {
+		c.log.Info("cannot start oidc login: ", zap.Error(err))
+		w.WriteHeader(http.StatusBadGateway)
+		return "", false
+	}
+	flow.LinkUserID = userID
+
+	data, err := json.Marshal(flow)
+	if err != nil {
+		c.log.Info("cannot encode oidc flow: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return "", false
+	}
+
+	sealed, err := c.cipher.Encrypt(data)
+	if err != nil {
+		c.log.Info("cannot seal oidc flow: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return "", false
+	}
+
+	http.SetCookie(w, c.flowCookie(r, sealed, int(time.Until(flow.Expires).Seconds())))
+	return authURL, true
+}
+
+// readFlow opens the flow cookie of the browser
+func (c *OIDCController) readFlow(r *http.Request) (models.OIDCFlow, error) {
+	cookie, err := r.Cookie(oidcFlowCookie)
+	if err != nil || cookie.Value == "" {
+		return models.OIDCFlow{}, errInvalidFlow
+	}
+
+	data, err := c.cipher.Decrypt(cookie.Value)
+	if err != nil {
+		return models.OIDCFlow{}, fmt.Errorf("%w: %v", errInvalidFlow, err)
+	}
+
+	var flow models.OIDCFlow
+	if err := json.Unmarshal(data, &flow); err != nil {
+		return models.OIDCFlow{}, fmt.Errorf("%w: %v", errInvalidFlow, err)
+	}
+
+	return flow, nil
+}
+
+// flowCookie builds the flow cookie, a negative maxAge deletes it. It is sent back on
+// the top-level redirect from the identity provider, so it has to be SameSite=Lax.
+func (c *OIDCController) flowCookie(r *http.Request, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcFlowCookie,
+		Value:    value,
+		Path:     "/api/auth/oidc",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// completeLink links the identity to the user who started the flow
+func (c *OIDCController) completeLink(w http.ResponseWriter, r *http.Request, userID int, identity models.ExternalIdentity) {
+	user, err := c.storage.GetUserByID(c.ctx, userID)
+	if err != nil {
+		c.log.Info("user not found", zap.Error(err))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	err = c.link(r, user, identity)
+	if errors.Is(err, storage.ErrConflict) {
+		// Linking the same identity again is harmless
+		linked, lookupErr := c.storage.GetUserByIdentity(c.ctx, identity.Issuer, identity.Subject)
+		if lookupErr != nil || linked.UUID != user.UUID {
+			c.log.Info("identity is linked to another user", zap.String("subject", identity.Subject))
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+	} else if err != nil {
+		c.log.Info("cannot link identity: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(models.ResponseUser{Response: "identity linked"}); err != nil {
+		c.log.Info("error encoding response: ", zap.Error(err))
+	}
+}
+
+// resolveUser finds the user an identity belongs to, creating one on first login. An
+// identity is never linked to an existing user here: the passport claim is not proof
+// of owning the account.
+func (c *OIDCController) resolveUser(r *http.Request, identity models.ExternalIdentity) (models.User, error) {
+	user, err := c.storage.GetUserByIdentity(c.ctx, identity.Issuer, identity.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return models.User{}, err
+	}
+
+	passport, _ := identity.Claims[c.passportClaim()].(string)
+	if passport == "" {
+		return models.User{}, errNoLinkedAccount
+	}
+
+	passportSerie, passportNumber, err := parsePassportData(passport)
+	if err != nil {
+		return models.User{}, fmt.Errorf("invalid passport claim: %w", err)
+	}
+
+	// The owner of the account links the identity from the account
+	if _, err := c.storage.GetUser(c.ctx, passportSerie, passportNumber); err == nil {
+		return models.User{}, errNoLinkedAccount
+	}
+
+	// First login: create the user from the identity provider claims
+	user, err = c.createUser(r, passportSerie, passportNumber)
+	if errors.Is(err, storage.ErrConflict) {
+		return models.User{}, errNoLinkedAccount
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+
+	return user, c.link(r, user, identity)
+}
+
+func (c *OIDCController) createUser(r *http.Request, passportSerie, passportNumber int) (models.User, error) {
+	loc, err := time.LoadLocation("Local")
+	if err != nil {
+		return models.User{}, err
+	}
+
+	defaultEndTime, err := parseDefaultEndTime(c.defaultEndTime(), loc)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	id, err := c.storage.InsertUser(auditContext(c.ctx, r), models.User{
+		PassportSerie:  passportSerie,
+		PassportNumber: passportNumber,
+		DefaultEndTime: defaultEndTime,
+		Timezone:       loc.String(),
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+
+	c.log.Info("User created on first oidc login")
+	return c.storage.GetUserByID(c.ctx, id)
+}
+
+func (c *OIDCController) link(r *http.Request, user models.User, identity models.ExternalIdentity) error {
+	return c.storage.LinkIdentity(actorContext(c.ctx, r, user.UUID), models.UserIdentity{
+		UserID:  user.UUID,
+		Issuer:  identity.Issuer,
+		Subject: identity.Subject,
+		Email:   identity.Email,
+	})
+}
@@ -0,0 +1,318 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	authz "github.com/wurt83ow/timetracker/internal/authorization"
+	"github.com/wurt83ow/timetracker/internal/encryption"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/oidc"
+	"github.com/wurt83ow/timetracker/internal/oidc/oidctest"
+	"github.com/wurt83ow/timetracker/internal/storage"
+)
+
+// MockIdentityStorage is a mock implementation of the IdentityStorage interface
+type MockIdentityStorage struct {
+	mock.Mock
+}
+
+func (m *MockIdentityStorage) GetUser(ctx context.Context, passportSerie int, passportNumber int) (models.User, error) {
+	args := m.Called(ctx, passportSerie, passportNumber)
+	return args.Get(0).(models.User), args.Error(1)
+}
+
+func (m *MockIdentityStorage) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(models.User), args.Error(1)
+}
+
+func (m *MockIdentityStorage) InsertUser(ctx context.Context, user models.User) (int, error) {
+	args := m.Called(ctx, user)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockIdentityStorage) GetUserByIdentity(ctx context.Context, issuer, subject string) (models.User, error) {
+	args := m.Called(ctx, issuer, subject)
+	return args.Get(0).(models.User), args.Error(1)
+}
+
+func (m *MockIdentityStorage) LinkIdentity(ctx context.Context, identity models.UserIdentity) error {
+	args := m.Called(ctx, identity)
+	return args.Error(0)
+}
+
+// MockOIDCProvider is a mock implementation of the OIDCProvider interface
+type MockOIDCProvider struct {
+	mock.Mock
+}
+
+func (m *MockOIDCProvider) Begin(ctx context.Context) (string, models.OIDCFlow, error) {
+	args := m.Called(ctx)
+	return args.String(0), args.Get(1).(models.OIDCFlow), args.Error(2)
+}
+
+func (m *MockOIDCProvider) Complete(ctx context.Context, flow models.OIDCFlow, state, code string) (models.ExternalIdentity, error) {
+	args := m.Called(ctx, flow, state, code)
+	return args.Get(0).(models.ExternalIdentity), args.Error(1)
+}
+
+func newFlowCipher(t *testing.T) *encryption.Cipher {
+	cipher, err := encryption.NewCipher("test_encryption_key")
+	require.NoError(t, err)
+	return cipher
+}
+
+// flowCookie returns the flow cookie set by a response
+func flowCookie(t *testing.T, rr *httptest.ResponseRecorder) *http.Cookie {
+	t.Helper()
+
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == oidcFlowCookie && cookie.MaxAge > 0 {
+			assert.True(t, cookie.HttpOnly)
+			assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+			return cookie
+		}
+	}
+	t.Fatal("flow cookie not set")
+	return nil
+}
+
+// callback returns to the callback with the state and code, and the cookie if any
+func callback(router http.Handler, query string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/callback?"+query, nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestOIDCController_FirstLoginCreatesUser(t *testing.T) {
+	idp := oidctest.NewServer("timetracker", "secret")
+	defer idp.Close()
+	idp.SetClaims(map[string]interface{}{"sub": "alice", "passport": "1234 567890"})
+
+	identities := new(MockIdentityStorage)
+	authz := new(MockAuthz)
+	twoFactor := new(MockTwoFactor)
+	log := new(MockLog)
+	ctx := context.Background()
+
+	provider := oidc.NewClient(oidc.Config{
+		Issuer:       idp.Issuer(),
+		ClientID:     "timetracker",
+		ClientSecret: "secret",
+		RedirectURL:  "http://timetracker.test/api/auth/oidc/callback",
+	}, log)
+
+	controller := NewOIDCController(ctx, identities, provider, authz, twoFactor, newFlowCipher(t), defaultEndTime,
+		func() string { return "passport" }, log)
+
+	created := models.User{UUID: 42, PassportSerie: 1234, PassportNumber: 567890}
+	identities.On("GetUserByIdentity", ctx, idp.Issuer(), "alice").Return(models.User{}, storage.ErrNotFound)
+	identities.On("GetUser", ctx, 1234, 567890).Return(models.User{}, errors.New("user not found"))
+	identities.On("InsertUser", mock.Anything, mock.Anything).Return(42, nil)
+	identities.On("GetUserByID", ctx, 42).Return(created, nil)
+	identities.On("LinkIdentity", mock.Anything, models.UserIdentity{UserID: 42, Issuer: idp.Issuer(), Subject: "alice"}).Return(nil)
+	twoFactor.On("Enabled", ctx, 42).Return(false, nil)
+	authz.On("CreateJWTTokenForUser", 42).Return("jwtToken")
+	log.On("Info", mock.Anything, mock.Anything).Return()
+
+	router := controller.Route()
+
+	// Start the login and follow the redirect to the identity provider
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/login", nil))
+	require.Equal(t, http.StatusFound, rr.Code)
+	cookie := flowCookie(t, rr)
+
+	httpClient := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := httpClient.Get(rr.Header().Get("Location"))
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	redirect, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+
+	// Another browser can't complete the login
+	rr = callback(router, redirect.RawQuery)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	identities.AssertNotCalled(t, "InsertUser", mock.Anything, mock.Anything)
+
+	// Return to the callback with the code issued by the identity provider
+	rr = callback(router, redirect.RawQuery, cookie)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "jwtToken", rr.Header().Get("Authorization"))
+	identities.AssertCalled(t, "InsertUser", mock.Anything, mock.Anything)
+	identities.AssertCalled(t, "LinkIdentity", mock.Anything, mock.Anything)
+}
+
+func TestOIDCController_RejectsForgedFlow(t *testing.T) {
+	identities := new(MockIdentityStorage)
+	log := new(MockLog)
+	provider := new(MockOIDCProvider)
+	log.On("Info", mock.Anything, mock.Anything).Return()
+
+	controller := NewOIDCController(context.Background(), identities, provider, new(MockAuthz), new(MockTwoFactor),
+		newFlowCipher(t), defaultEndTime, func() string { return "passport" }, log)
+
+	// A flow sealed with another key, or not sealed at all, is not accepted
+	other, err := encryption.NewCipher("another_encryption_key")
+	require.NoError(t, err)
+	data, err := json.Marshal(models.OIDCFlow{State: "state", LinkUserID: 7, Expires: time.Now().Add(time.Minute)})
+	require.NoError(t, err)
+	sealed, err := other.Encrypt(data)
+	require.NoError(t, err)
+
+	for _, value := range []string{sealed, string(data)} {
+		rr := callback(controller.Route(), "state=state&code=code", &http.Cookie{Name: oidcFlowCookie, Value: value})
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	}
+	provider.AssertNotCalled(t, "Complete", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+// newMockedOIDC returns a controller whose login flows resolve to the identity
+func newMockedOIDC(t *testing.T, identity models.ExternalIdentity) (*OIDCController, *MockIdentityStorage, *MockAuthz, *MockTwoFactor) {
+	identities := new(MockIdentityStorage)
+	authz := new(MockAuthz)
+	twoFactor := new(MockTwoFactor)
+	log := new(MockLog)
+	provider := new(MockOIDCProvider)
+	log.On("Info", mock.Anything, mock.Anything).Return()
+
+	flow := models.OIDCFlow{State: "state", Nonce: "nonce", Verifier: "verifier", Expires: time.Now().Add(time.Minute)}
+	provider.On("Begin", mock.Anything).Return("https://idp.test/authorize", flow, nil)
+	provider.On("Complete", mock.Anything, mock.Anything, "state", "code").Return(identity, nil)
+
+	controller := NewOIDCController(context.Background(), identities, provider, authz, twoFactor, newFlowCipher(t),
+		defaultEndTime, func() string { return "passport" }, log)
+	return controller, identities, authz, twoFactor
+}
+
+// login starts a login and returns the flow cookie
+func login(t *testing.T, controller *OIDCController) *http.Cookie {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	controller.Route().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/login", nil))
+	require.Equal(t, http.StatusFound, rr.Code)
+	return flowCookie(t, rr)
+}
+
+func TestOIDCController_UnlinkedIdentityIsForbidden(t *testing.T) {
+	ctx := context.Background()
+	controller, identities, _, _ := newMockedOIDC(t, models.ExternalIdentity{
+		Issuer:  "https://idp.test",
+		Subject: "bob",
+		Claims:  map[string]interface{}{"sub": "bob"},
+	})
+	identities.On("GetUserByIdentity", ctx, "https://idp.test", "bob").Return(models.User{}, storage.ErrNotFound)
+
+	// The session of a logged-in user doesn't link the identity either
+	rr := callback(controller.Route(), "state=state&code=code", login(t, controller),
+		&http.Cookie{Name: "jwt-token", Value: "jwtToken"})
+
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	identities.AssertNotCalled(t, "LinkIdentity", mock.Anything, mock.Anything)
+}
+
+func TestOIDCController_PassportOfExistingUserIsNotLinked(t *testing.T) {
+	ctx := context.Background()
+	controller, identities, authz, _ := newMockedOIDC(t, models.ExternalIdentity{
+		Issuer:  "https://idp.test",
+		Subject: "mallory",
+		Claims:  map[string]interface{}{"sub": "mallory", "passport": "1234 567890"},
+	})
+	identities.On("GetUserByIdentity", ctx, "https://idp.test", "mallory").Return(models.User{}, storage.ErrNotFound)
+	identities.On("GetUser", ctx, 1234, 567890).Return(models.User{UUID: 7}, nil)
+
+	rr := callback(controller.Route(), "state=state&code=code", login(t, controller))
+
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Empty(t, rr.Header().Get("Authorization"))
+	identities.AssertNotCalled(t, "LinkIdentity", mock.Anything, mock.Anything)
+	identities.AssertNotCalled(t, "InsertUser", mock.Anything, mock.Anything)
+	authz.AssertNotCalled(t, "CreateJWTTokenForUser", mock.Anything)
+}
+
+func TestOIDCController_TwoFactorUserGetsChallenge(t *testing.T) {
+	ctx := context.Background()
+	controller, identities, authz, twoFactor := newMockedOIDC(t, models.ExternalIdentity{
+		Issuer:  "https://idp.test",
+		Subject: "alice",
+	})
+	identities.On("GetUserByIdentity", ctx, "https://idp.test", "alice").Return(models.User{UUID: 7}, nil)
+	twoFactor.On("Enabled", ctx, 7).Return(true, nil)
+	authz.On("CreateChallengeToken", 7).Return("challengeToken")
+
+	rr := callback(controller.Route(), "state=state&code=code", login(t, controller))
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	var resp models.ResponseTwoFactorChallenge
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, "challengeToken", resp.ChallengeToken)
+	assert.Empty(t, rr.Header().Get("Authorization"))
+	authz.AssertNotCalled(t, "CreateJWTTokenForUser", mock.Anything)
+}
+
+func TestOIDCController_LinkIdentity(t *testing.T) {
+	ctx := context.Background()
+	controller, identities, sessions, _ := newMockedOIDC(t, models.ExternalIdentity{
+		Issuer:  "https://idp.test",
+		Subject: "alice",
+		Email:   "alice@example.com",
+	})
+	identities.On("GetUserByID", ctx, 7).Return(models.User{UUID: 7}, nil)
+	identities.On("LinkIdentity", mock.Anything, models.UserIdentity{UserID: 7, Issuer: "https://idp.test", Subject: "alice", Email: "alice@example.com"}).Return(nil).Once()
+
+	// start links to the identity for the user
+	start := func(userID int) *http.Cookie {
+		t.Helper()
+
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		rr := httptest.NewRecorder()
+		controller.IdentitiesRoute().ServeHTTP(rr, req.WithContext(authz.WithPrincipal(req.Context(), models.Principal{UserID: userID})))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var resp models.ResponseOIDCLink
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, "https://idp.test/authorize", resp.AuthURL)
+		return flowCookie(t, rr)
+	}
+
+	rr := callback(controller.Route(), "state=state&code=code", start(7))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("Authorization"))
+	identities.AssertNumberOfCalls(t, "LinkIdentity", 1)
+
+	// Linking it again is harmless, linking the identity of another user is not
+	identities.On("LinkIdentity", mock.Anything, mock.Anything).Return(storage.ErrConflict)
+	identities.On("GetUserByIdentity", ctx, "https://idp.test", "alice").Return(models.User{UUID: 7}, nil)
+	rr = callback(controller.Route(), "state=state&code=code", start(7))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	identities.On("GetUserByID", ctx, 8).Return(models.User{UUID: 8}, nil)
+	rr = callback(controller.Route(), "state=state&code=code", start(8))
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	// Starting a link needs a logged-in user
+	rr = httptest.NewRecorder()
+	controller.IdentitiesRoute().ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	sessions.AssertNotCalled(t, "CreateJWTTokenForUser", mock.Anything)
+}
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/wurt83ow/timetracker/internal/models"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// parsePagination reads the limit, offset, sort and cursor parameters of a list endpoint.
+// A cursor must come from a page with the same sort and takes precedence over the offset.
+func (h *BaseController) parsePagination(r *http.Request, parseSort func(string) ([]models.SortField, error),
+	validCursor func(models.Cursor, []models.SortField) bool) (models.Pagination, error) {
+	var pagination models.Pagination
+	query := r.URL.Query()
+
+	sort, err := parseSort(query.Get("sort"))
+	if err != nil {
+		return pagination, err
+	}
+	pagination.Sort = sort
+
+	pagination.Limit = defaultPageLimit
+	if v := query.Get("limit"); v != "" {
+		val, err := strconv.Atoi(v)
+		if err != nil {
+			return pagination, err
+		}
+		pagination.Limit = val
+	}
+	if pagination.Limit <= 0 {
+		pagination.Limit = defaultPageLimit
+	}
+	if pagination.Limit > maxPageLimit {
+		pagination.Limit = maxPageLimit
+	}
+
+	if v := query.Get("offset"); v != "" {
+		val, err := strconv.Atoi(v)
+		if err != nil {
+			return pagination, err
+		}
+		pagination.Offset = val
+	}
+
+	if v := query.Get("cursor"); v != "" {
+		cursor, err := h.decodeListCursor(v)
+		if err != nil || !validCursor(cursor, sort) {
+			return pagination, errInvalidCursor
+		}
+		pagination.After = &cursor
+		pagination.Offset = 0
+	}
+
+	return pagination, nil
+}
+
+// encodeListCursor seals the sort key of the item the next page starts after.
+// The sort key holds personal data such as surnames, which must not end up in URLs and logs.
+func (h *BaseController) encodeListCursor(cursor models.Cursor) (string, error) {
+	raw, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := h.cipher.Encrypt(raw)
+	if err != nil {
+		return "", err
+	}
+
+	// The ciphertext is standard base64, the cursor goes into a query parameter
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func (h *BaseController) decodeListCursor(s string) (models.Cursor, error) {
+	var cursor models.Cursor
+
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, err
+	}
+
+	raw, err := h.cipher.Decrypt(base64.StdEncoding.EncodeToString(data))
+	if err != nil {
+		return cursor, err
+	}
+
+	// Numbers are the integer sort keys, they must not become floats
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&cursor); err != nil {
+		return cursor, err
+	}
+
+	for i, v := range cursor.Values {
+		if n, ok := v.(json.Number); ok {
+			val, err := strconv.Atoi(n.String())
+			if err != nil {
+				return cursor, err
+			}
+			cursor.Values[i] = val
+		}
+	}
+
+	return cursor, nil
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	authz "github.com/wurt83ow/timetracker/internal/authorization"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+)
+
+func TestBaseController_GetTasks(t *testing.T) {
+	mockStorage := new(MockStorage)
+	log := new(MockLog)
+	ctx := context.Background()
+	controller := NewBaseController(ctx, mockStorage, defaultEndTime, log, new(MockAuthz), new(MockTwoFactor), new(MockPublisher), cursorCipher)
+
+	sort := []models.SortField{{Field: "created_at", Desc: true}, {Field: "id"}}
+	created := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	tasks := []models.Task{{ID: 4, Name: "Deploy", CreatedAt: created}, {ID: 2, Name: "Review", CreatedAt: created}, {ID: 9, Name: "Release"}}
+	after := storage.TaskCursor(tasks[1], sort)
+
+	// The controller asks for one task more than the page to tell whether there is a next page
+	mockStorage.On("GetTasks", ctx, models.TaskFilter{}, models.Pagination{Limit: 3, Sort: sort}).Return(tasks, 3, nil)
+	mockStorage.On("GetTasks", ctx, models.TaskFilter{}, models.Pagination{Limit: 3, Sort: sort, After: &after}).Return(tasks[2:], 3, nil)
+	mockStorage.On("GetTasks", ctx, models.TaskFilter{}, models.Pagination{Limit: 4, Sort: sort}).Return(tasks, 3, nil)
+	mockStorage.On("GetTasks", ctx, models.TaskFilter{}, models.Pagination{Limit: defaultPageLimit + 1}).Return(tasks[:1], 1, nil)
+	log.On("Info", mock.Anything, mock.Anything).Return()
+
+	router := controller.Route()
+	principalCtx := authz.WithPrincipal(ctx, models.Principal{UserID: 7})
+
+	get := func(target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil).WithContext(principalCtx))
+		return rr
+	}
+
+	t.Run("Cursor", func(t *testing.T) {
+		rr := get("/api/tasks?sort=-created_at,id&limit=2")
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var page models.ResponseTasks
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+		assert.Len(t, page.Tasks, 2)
+		assert.Equal(t, 3, page.Total)
+		require.NotEmpty(t, page.NextCursor)
+
+		rr = get("/api/tasks?sort=-created_at,id&limit=2&cursor=" + page.NextCursor)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		page = models.ResponseTasks{}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+		assert.Len(t, page.Tasks, 1)
+		assert.Equal(t, 3, page.Total)
+		assert.Empty(t, page.NextCursor)
+	})
+
+	t.Run("Full Last Page", func(t *testing.T) {
+		rr := get("/api/tasks?sort=-created_at,id&limit=3")
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var page models.ResponseTasks
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+		assert.Len(t, page.Tasks, 3)
+		assert.Empty(t, page.NextCursor)
+	})
+
+	t.Run("Sealed Cursor", func(t *testing.T) {
+		cursor, err := controller.encodeListCursor(after)
+		require.NoError(t, err)
+
+		raw, err := base64.RawURLEncoding.DecodeString(cursor)
+		require.NoError(t, err)
+		assert.NotContains(t, string(raw), "created_at")
+
+		decoded, err := controller.decodeListCursor(cursor)
+		require.NoError(t, err)
+		assert.Equal(t, after, decoded)
+
+		plain, err := json.Marshal(after)
+		require.NoError(t, err)
+		_, err = controller.decodeListCursor(base64.RawURLEncoding.EncodeToString(plain))
+		assert.Error(t, err)
+	})
+
+	t.Run("Default Limit", func(t *testing.T) {
+		rr := get("/api/tasks?limit=0")
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var page models.ResponseTasks
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+		assert.Len(t, page.Tasks, 1)
+		assert.Empty(t, page.NextCursor)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		cursor, err := controller.encodeListCursor(after)
+		require.NoError(t, err)
+		for _, target := range []string{
+			"/api/tasks?sort=owner",
+			"/api/tasks?sort=name,-name",
+			"/api/tasks?cursor=not-a-cursor",
+			"/api/tasks?sort=name&cursor=" + cursor,
+		} {
+			assert.Equal(t, http.StatusBadRequest, get(target).Code, target)
+		}
+	})
+}
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	authz "github.com/wurt83ow/timetracker/internal/authorization"
+	"github.com/wurt83ow/timetracker/internal/export"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap"
+)
+
+// @Summary Export user data
+// @Description Download a ZIP archive with the profile, time entries and credentials metadata of a user as JSON and CSV
+// @Tags User
+// @Produce application/zip
+// @Param id path int true "User ID"
+// @Success 200 {file} file "ZIP archive"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not Found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /api/users/{id}/export [get]
+func (h *BaseController) ExportUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.dataSubject(w, r)
+	if !ok {
+		return
+	}
+
+	data, err := h.storage.ExportUser(h.ctx, id)
+	if err == storage.ErrNotFound {
+		h.log.Info("user not found")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.log.Info("error exporting user data: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%d-export.zip"`, id))
+
+	// The archive is streamed, so an error can only be logged at this point
+	if err := export.WriteZip(w, data); err != nil {
+		h.log.Info("error writing export archive: ", zap.Error(err))
+		return
+	}
+
+	h.log.Info("User data exported", zap.Int("id", id))
+}
+
+// @Summary Erase user data
+// @Description Anonymize the personal data of a user and remove the credentials.
+// @Description Time entries are kept for reporting.
+// @Tags User
+// @Param id path int true "User ID"
+// @Success 200 {string} string "User erased successfully"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not Found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /api/users/{id}/erase [post]
+func (h *BaseController) EraseUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.dataSubject(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.storage.EraseUser(auditContext(h.ctx, r), id)
+	if err == storage.ErrNotFound {
+		h.log.Info("user not found")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.log.Info("error erasing user: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	h.log.Info("User erased successfully", zap.Int("id", id))
+}
+
+// dataSubject returns the user ID from the URL if the principal may access that user's data.
+// Users may access their own data and administrators the data of anyone.
+func (h *BaseController) dataSubject(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		h.log.Info("invalid user ID in URL")
+		w.WriteHeader(http.StatusBadRequest)
+		return 0, false
+	}
+
+	principal, ok := authz.PrincipalFromContext(r.Context())
+	if !ok {
+		h.log.Info("principal not found in context")
+		w.WriteHeader(http.StatusUnauthorized)
+		return 0, false
+	}
+
+	if principal.UserID != id && principal.Role != models.RoleAdmin {
+		h.log.Info("access to the data of another user denied", zap.Int("id", id))
+		w.WriteHeader(http.StatusForbidden)
+		return 0, false
+	}
+
+	return id, true
+}
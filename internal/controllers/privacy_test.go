@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	authz "github.com/wurt83ow/timetracker/internal/authorization"
+	"github.com/wurt83ow/timetracker/internal/models"
+)
+
+func TestBaseController_ExportUser(t *testing.T) {
+	storage := new(MockStorage)
+	log := new(MockLog)
+	ctx := context.Background()
+	controller := NewBaseController(ctx, storage, defaultEndTime, log, new(MockAuthz), new(MockTwoFactor), new(MockPublisher), cursorCipher)
+
+	storage.On("ExportUser", ctx, 7).Return(models.UserExport{
+		Profile: models.User{UUID: 7, PassportSerie: 1234, PassportNumber: 567890, Surname: "Ivanov", Hash: []byte("secret")},
+		TimeEntries: []models.TimeEntryRecord{
+			{ID: 1, TaskID: 2, TaskName: "Report", EventDate: "2024-07-01", StartTime: "09:00:00+00", EndTime: "10:30:00+00"},
+		},
+		Tokens: []models.TokenMetadata{{Type: "totp", Enabled: true}},
+	}, nil)
+	log.On("Info", mock.Anything, mock.Anything).Return()
+
+	router := controller.Route()
+	principalCtx := authz.WithPrincipal(ctx, models.Principal{UserID: 7})
+
+	t.Run("Own Data", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/users/7/export", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req.WithContext(principalCtx))
+
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "application/zip", rr.Header().Get("Content-Type"))
+
+		archive, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+		require.NoError(t, err)
+
+		files := make(map[string]*zip.File)
+		var names []string
+		for _, f := range archive.File {
+			files[f.Name] = f
+			names = append(names, f.Name)
+		}
+		sort.Strings(names)
+		assert.Equal(t, []string{"audit.csv", "audit.json", "profile.csv", "profile.json", "time_entries.csv", "time_entries.json", "tokens.csv", "tokens.json"}, names)
+
+		profile := readZipFile(t, files["profile.json"])
+		assert.Contains(t, profile, `"surname": "Ivanov"`)
+		assert.NotContains(t, profile, "secret")
+
+		records, err := csv.NewReader(bytes.NewBufferString(readZipFile(t, files["time_entries.csv"]))).ReadAll()
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{
+			{"id", "task_id", "task_name", "event_date", "start_time", "end_time"},
+			{"1", "2", "Report", "2024-07-01", "09:00:00+00", "10:30:00+00"},
+		}, records)
+	})
+
+	t.Run("Data Of Another User", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/users/8/export", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req.WithContext(principalCtx))
+
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		storage.AssertNotCalled(t, "ExportUser", ctx, 8)
+	})
+}
+
+func TestBaseController_EraseUser(t *testing.T) {
+	storage := new(MockStorage)
+	log := new(MockLog)
+	ctx := context.Background()
+	controller := NewBaseController(ctx, storage, defaultEndTime, log, new(MockAuthz), new(MockTwoFactor), new(MockPublisher), cursorCipher)
+
+	storage.On("EraseUser", mock.Anything, 7).Return(nil)
+	log.On("Info", mock.Anything, mock.Anything).Return()
+
+	router := controller.Route()
+	principalCtx := authz.WithPrincipal(ctx, models.Principal{UserID: 7})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/users/8/erase", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req.WithContext(principalCtx))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/api/users/7/erase", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req.WithContext(principalCtx))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	storage.AssertCalled(t, "EraseUser", mock.Anything, 7)
+}
+
+func readZipFile(t *testing.T, f *zip.File) string {
+	t.Helper()
+	require.NotNil(t, f)
+
+	rc, err := f.Open()
+	require.NoError(t, err)
+	defer rc.Close()
+
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(rc)
+	require.NoError(t, err)
+
+	return buf.String()
+}
@@ -0,0 +1,275 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	authz "github.com/wurt83ow/timetracker/internal/authorization"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/reports"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap"
+)
+
+// maxReportRecipients bounds the recipients of a report subscription
+const maxReportRecipients = 20
+
+// @Summary Create report subscription
+// @Description Email a report of the hours tracked per user and task on a cron schedule (e.g. "0 8 * * MON"
+// @Description or @daily), evaluated in the timezone of the subscription. A daily, weekly or monthly report
+// @Description covers the previous calendar day, week or month. Only available to administrators.
+// @Tags Reports
+// @Accept json
+// @Produce json
+// @Param subscription body models.RequestReportSubscription true "Subscription"
+// @Success 201 {object} models.ReportSubscription "Created subscription"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /api/reports/subscriptions [post]
+func (h *BaseController) CreateReportSubscription(w http.ResponseWriter, r *http.Request) {
+	principal, ok := h.reportAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.RequestReportSubscription
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.log.Info("cannot decode request JSON body: ", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	sub, err := newReportSubscription(req, principal.UserID)
+	if err != nil {
+		h.log.Info("invalid report subscription: ", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		if _, err := w.Write([]byte(err.Error())); err != nil {
+			h.log.Info("error writing response: ", zap.Error(err))
+		}
+		return
+	}
+
+	sub.ID, err = h.storage.SaveReportSubscription(r.Context(), sub)
+	if err != nil {
+		h.log.Info("error saving report subscription: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	job, err := reports.NextJob(sub, time.Now())
+	if err == nil {
+		sub.NextRunAt = &job.RunAt
+		_, err = h.storage.EnqueueJob(r.Context(), job)
+	}
+	if err != nil && !errors.Is(err, storage.ErrConflict) {
+		// The subscription is scheduled when the service starts again
+		h.log.Info("error scheduling report: ", zap.Int("subscription", sub.ID), zap.Error(err))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(sub); err != nil {
+		h.log.Info("error encoding response: ", zap.Error(err))
+	}
+	h.log.Info("Report subscription created", zap.Int("id", sub.ID))
+}
+
+// @Summary Get report subscriptions
+// @Description List the report subscriptions with their next run. Only available to administrators.
+// @Tags Reports
+// @Produce json
+// @Success 200 {array} models.ReportSubscription "Subscriptions"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /api/reports/subscriptions [get]
+func (h *BaseController) GetReportSubscriptions(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.reportAdmin(w, r); !ok {
+		return
+	}
+
+	subs, err := h.storage.GetReportSubscriptions(r.Context())
+	if err != nil {
+		h.log.Info("error getting report subscriptions: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	for i := range subs {
+		if next, err := reports.NextRun(subs[i], now); err == nil {
+			subs[i].NextRunAt = &next
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(subs); err != nil {
+		h.log.Info("error encoding response: ", zap.Error(err))
+	}
+}
+
+// @Summary Delete report subscription
+// @Description Delete a report subscription, which ends its schedule. Only available to administrators.
+// @Tags Reports
+// @Param id path int true "Subscription ID"
+// @Success 204 {string} string "Subscription deleted"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not Found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /api/reports/subscriptions/{id} [delete]
+func (h *BaseController) DeleteReportSubscription(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.reportAdmin(w, r); !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		h.log.Info("invalid report subscription ID format")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.storage.DeleteReportSubscription(r.Context(), id)
+	if errors.Is(err, storage.ErrNotFound) {
+		h.log.Info("report subscription not found")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.log.Info("error deleting report subscription: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	h.log.Info("Report subscription deleted", zap.Int("id", id))
+}
+
+// @Summary Send report now
+// @Description Queue the report of a subscription for the period before now, outside of its schedule.
+// @Description Only available to administrators.
+// @Tags Reports
+// @Param id path int true "Subscription ID"
+// @Success 202 {string} string "Report queued"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not Found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /api/reports/subscriptions/{id}/send [post]
+func (h *BaseController) SendReport(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.reportAdmin(w, r); !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		h.log.Info("invalid report subscription ID format")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	sub, err := h.storage.GetReportSubscription(r.Context(), id)
+	if errors.Is(err, storage.ErrNotFound) {
+		h.log.Info("report subscription not found")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.log.Info("error getting report subscription: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	job, err := reports.NowJob(sub, time.Now())
+	if err == nil {
+		_, err = h.storage.EnqueueJob(r.Context(), job)
+	}
+	if err != nil {
+		h.log.Info("error queueing report: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	h.log.Info("Report queued", zap.Int("subscription", id))
+}
+
+// reportAdmin returns the principal of the request if it is an administrator, and
+// writes the error status otherwise
+func (h *BaseController) reportAdmin(w http.ResponseWriter, r *http.Request) (models.Principal, bool) {
+	principal, ok := authz.PrincipalFromContext(r.Context())
+	if !ok {
+		h.log.Info("principal not found in context")
+		w.WriteHeader(http.StatusUnauthorized)
+		return models.Principal{}, false
+	}
+
+	if principal.Role != models.RoleAdmin {
+		h.log.Info("access to the report subscriptions denied", zap.Int("userID", principal.UserID))
+		w.WriteHeader(http.StatusForbidden)
+		return models.Principal{}, false
+	}
+
+	return principal, true
+}
+
+// newReportSubscription validates the request and fills in the defaults
+func newReportSubscription(req models.RequestReportSubscription, userID int) (models.ReportSubscription, error) {
+	sub := models.ReportSubscription{
+		UserID:     userID,
+		Schedule:   strings.TrimSpace(req.Schedule),
+		ReportType: req.ReportType,
+		Format:     req.Format,
+		Timezone:   req.Timezone,
+		CreatedAt:  time.Now(),
+	}
+
+	switch sub.ReportType {
+	case models.ReportDailyHours, models.ReportWeeklyHours, models.ReportMonthlyHours:
+	default:
+		return sub, fmt.Errorf("unknown report type %q", sub.ReportType)
+	}
+
+	switch sub.Format {
+	case "":
+		sub.Format = models.ReportFormatHTMLCSV
+	case models.ReportFormatHTML, models.ReportFormatCSV, models.ReportFormatHTMLCSV:
+	default:
+		return sub, fmt.Errorf("unknown report format %q", sub.Format)
+	}
+
+	if sub.Timezone == "" {
+		sub.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(sub.Timezone); err != nil {
+		return sub, fmt.Errorf("unknown timezone %q", sub.Timezone)
+	}
+
+	if len(req.Recipients) == 0 || len(req.Recipients) > maxReportRecipients {
+		return sub, fmt.Errorf("a report needs 1 to %d recipients", maxReportRecipients)
+	}
+	for _, rcpt := range req.Recipients {
+		rcpt = strings.TrimSpace(rcpt)
+		if _, err := mail.ParseAddress(rcpt); err != nil {
+			return sub, fmt.Errorf("invalid recipient %q", rcpt)
+		}
+		sub.Recipients = append(sub.Recipients, rcpt)
+	}
+
+	// The schedule must have a next run
+	if _, err := reports.NextRun(sub, time.Now()); err != nil {
+		return sub, err
+	}
+
+	return sub, nil
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	authz "github.com/wurt83ow/timetracker/internal/authorization"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/reports"
+	"github.com/wurt83ow/timetracker/internal/storage"
+)
+
+func TestBaseController_CreateReportSubscription(t *testing.T) {
+	store := new(MockStorage)
+	log := new(MockLog)
+	ctx := context.Background()
+	controller := NewBaseController(ctx, store, defaultEndTime, log, new(MockAuthz), new(MockTwoFactor), new(MockPublisher), cursorCipher)
+
+	store.On("SaveReportSubscription", mock.Anything, mock.MatchedBy(func(sub models.ReportSubscription) bool {
+		return sub.UserID == 1 && sub.Format == models.ReportFormatHTMLCSV && sub.Timezone == "UTC"
+	})).Return(4, nil)
+	store.On("EnqueueJob", mock.Anything, mock.MatchedBy(func(job models.Job) bool {
+		return job.Kind == reports.JobKind && strings.HasPrefix(job.Key, "report:4:")
+	})).Return(int64(1), nil)
+	log.On("Info", mock.Anything, mock.Anything).Return()
+
+	router := controller.Route()
+	post := func(body, role string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		principal := models.Principal{UserID: 1, Role: role}
+		req := httptest.NewRequest(http.MethodPost, "/api/reports/subscriptions", strings.NewReader(body))
+		router.ServeHTTP(rr, req.WithContext(authz.WithPrincipal(ctx, principal)))
+		return rr
+	}
+
+	rr := post(`{"schedule":"0 8 * * MON","report_type":"weekly_hours","recipients":["manager@example.com"]}`, models.RoleAdmin)
+	require.Equal(t, http.StatusCreated, rr.Code)
+
+	var sub models.ReportSubscription
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &sub))
+	assert.Equal(t, 4, sub.ID)
+	require.NotNil(t, sub.NextRunAt)
+	assert.Equal(t, 8, sub.NextRunAt.Hour())
+
+	invalid := []string{
+		`{"schedule":"every monday","report_type":"weekly_hours","recipients":["manager@example.com"]}`,
+		`{"schedule":"@weekly","report_type":"yearly_hours","recipients":["manager@example.com"]}`,
+		`{"schedule":"@weekly","report_type":"weekly_hours","recipients":[]}`,
+		`{"schedule":"@weekly","report_type":"weekly_hours","recipients":["not an address"]}`,
+		`{"schedule":"@weekly","report_type":"weekly_hours","recipients":["manager@example.com"],"format":"pdf"}`,
+		`{"schedule":"@weekly","report_type":"weekly_hours","recipients":["manager@example.com"],"timezone":"Mars/Olympus"}`,
+		`{"schedule":`,
+	}
+	for _, body := range invalid {
+		assert.Equal(t, http.StatusBadRequest, post(body, models.RoleAdmin).Code, body)
+	}
+
+	assert.Equal(t, http.StatusForbidden, post(`{}`, models.RoleUser).Code)
+	store.AssertNumberOfCalls(t, "SaveReportSubscription", 1)
+}
+
+func TestBaseController_ReportSubscriptions(t *testing.T) {
+	store := new(MockStorage)
+	log := new(MockLog)
+	ctx := context.Background()
+	controller := NewBaseController(ctx, store, defaultEndTime, log, new(MockAuthz), new(MockTwoFactor), new(MockPublisher), cursorCipher)
+
+	sub := models.ReportSubscription{
+		ID:         3,
+		Schedule:   "@daily",
+		ReportType: models.ReportDailyHours,
+		Recipients: []string{"manager@example.com"},
+		Format:     models.ReportFormatHTML,
+		Timezone:   "Europe/Moscow",
+	}
+	store.On("GetReportSubscriptions", mock.Anything).Return([]models.ReportSubscription{sub}, nil)
+	store.On("GetReportSubscription", mock.Anything, 3).Return(sub, nil)
+	store.On("GetReportSubscription", mock.Anything, 9).Return(models.ReportSubscription{}, storage.ErrNotFound)
+	store.On("EnqueueJob", mock.Anything, mock.MatchedBy(func(job models.Job) bool {
+		return job.Kind == reports.JobKind && job.Key == ""
+	})).Return(int64(1), nil)
+	store.On("DeleteReportSubscription", mock.Anything, 3).Return(nil)
+	store.On("DeleteReportSubscription", mock.Anything, 9).Return(storage.ErrNotFound)
+	log.On("Info", mock.Anything, mock.Anything).Return()
+
+	router := controller.Route()
+	serve := func(method, target, role string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		principal := models.Principal{UserID: 1, Role: role}
+		router.ServeHTTP(rr, httptest.NewRequest(method, target, nil).WithContext(authz.WithPrincipal(ctx, principal)))
+		return rr
+	}
+
+	rr := serve(http.MethodGet, "/api/reports/subscriptions", models.RoleAdmin)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var subs []models.ReportSubscription
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &subs))
+	require.Len(t, subs, 1)
+	assert.NotNil(t, subs[0].NextRunAt)
+
+	assert.Equal(t, http.StatusAccepted, serve(http.MethodPost, "/api/reports/subscriptions/3/send", models.RoleAdmin).Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodPost, "/api/reports/subscriptions/9/send", models.RoleAdmin).Code)
+	assert.Equal(t, http.StatusNoContent, serve(http.MethodDelete, "/api/reports/subscriptions/3", models.RoleAdmin).Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodDelete, "/api/reports/subscriptions/9", models.RoleAdmin).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodDelete, "/api/reports/subscriptions/abc", models.RoleAdmin).Code)
+
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodGet, "/api/reports/subscriptions", models.RoleUser).Code)
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodPost, "/api/reports/subscriptions/3/send", models.RoleUser).Code)
+}
@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/wurt83ow/timetracker/internal/models"
+	"go.uber.org/zap"
+)
+
+// @Summary Search
+// @Description Search task names and descriptions and user names. Every word of the query has to start a word of the result, results are ranked by how well they match.
+// @Tags Search
+// @Produce json
+// @Param q query string true "Query"
+// @Param limit query int false "Limit (default 20, max 100)"
+// @Success 200 {object} models.ResponseSearch "Search results, best first"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /api/search [get]
+func (h *BaseController) Search(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		h.log.Info("search query is empty")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultPageLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		val, err := strconv.Atoi(v)
+		if err != nil {
+			h.log.Info("invalid limit format")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		limit = val
+	}
+	if limit <= 0 {
+		limit = defaultPageLimit
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+
+	results, err := h.storage.Search(h.ctx, query, limit)
+	if err != nil {
+		h.log.Info("error searching storage: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(models.ResponseSearch{Results: results}); err != nil {
+		h.log.Info("error encoding response: ", zap.Error(err))
+	}
+}
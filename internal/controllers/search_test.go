@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	authz "github.com/wurt83ow/timetracker/internal/authorization"
+	"github.com/wurt83ow/timetracker/internal/models"
+)
+
+func TestBaseController_Search(t *testing.T) {
+	storage := new(MockStorage)
+	log := new(MockLog)
+	ctx := context.Background()
+	controller := NewBaseController(ctx, storage, defaultEndTime, log, new(MockAuthz), new(MockTwoFactor), new(MockPublisher), cursorCipher)
+
+	results := []models.SearchResult{{Type: "task", ID: 3, Title: "Monthly report", Rank: 1}}
+	storage.On("Search", ctx, "monthly report", defaultPageLimit).Return(results, nil)
+	storage.On("Search", ctx, "report", maxPageLimit).Return(results[:0], nil)
+	log.On("Info", mock.Anything, mock.Anything).Return()
+
+	router := controller.Route()
+	get := func(target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil).WithContext(authz.WithPrincipal(ctx, models.Principal{UserID: 7})))
+		return rr
+	}
+
+	rr := get("/api/search?q=monthly+report")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var response models.ResponseSearch
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, results, response.Results)
+
+	rr = get("/api/search?q=report&limit=1000")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"results": []}`, rr.Body.String(), "the limit is capped")
+
+	assert.Equal(t, http.StatusBadRequest, get("/api/search?q=+").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/api/search?q=report&limit=ten").Code)
+}
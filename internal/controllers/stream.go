@@ -0,0 +1,164 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	authz "github.com/wurt83ow/timetracker/internal/authorization"
+	"github.com/wurt83ow/timetracker/internal/events"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/stream"
+	"go.uber.org/zap"
+)
+
+const (
+	// streamWriteTimeout bounds every write of a stream in place of the WriteTimeout
+	// of the server, which would end the stream
+	streamWriteTimeout = 10 * time.Second
+
+	// streamRetry is the reconnection delay suggested to the clients, in milliseconds
+	streamRetry = 3000
+)
+
+type EventStream interface {
+	Subscribe(lastID int64) *stream.Subscription
+	Heartbeat() time.Duration
+}
+
+type StreamController struct {
+	stream EventStream
+	authz  Authenticator
+	log    Log
+}
+
+// NewStreamController creates a new StreamController instance
+func NewStreamController(stream EventStream, authz Authenticator, log Log) *StreamController {
+	return &StreamController{
+		stream: stream,
+		authz:  authz,
+		log:    log,
+	}
+}
+
+// Route sets up the routes for the StreamController, to be mounted at /api/events
+func (c *StreamController) Route() *chi.Mux {
+	r := chi.NewRouter()
+	r.Use(c.authz.JWTAuthzMiddleware(c.log))
+
+	r.Get("/stream", c.Stream)
+
+	return r
+}
+
+// @Summary Stream timer and task events
+// @Description Server-Sent Events with the timers started and stopped and the tasks created, updated and deleted.
+// @Description Administrators get the timers of all users, other users their own. Every event has an id; a client
+// @Description reconnecting with the Last-Event-ID header gets the events it missed while they are buffered, and a
+// @Description "reset" event if they are not, after which it should reload its state. Idle streams get a comment
+// @Description as a heartbeat.
+// @Tags Events
+// @Produce text/event-stream
+// @Param Last-Event-ID header int false "ID of the last event received"
+// @Success 200 {string} string "Stream of events"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 500 {string} string "Streaming unsupported"
+// @Router /api/events/stream [get]
+func (c *StreamController) Stream(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authz.PrincipalFromContext(r.Context())
+	if !ok {
+		c.log.Info("principal not found in context")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout)); err != nil {
+		c.log.Info("streaming is not supported: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// An invalid ID starts a new stream
+	lastID, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+	sub := c.stream.Subscribe(lastID)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(format string, args ...any) bool {
+		if err := rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout)); err != nil {
+			return false
+		}
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	if !send("retry: %d\n\n", streamRetry) {
+		return
+	}
+	if sub.Missed && !send("event: reset\ndata: {}\n\n") {
+		return
+	}
+	for _, entry := range sub.Backlog {
+		if !c.sendEntry(principal, entry, send) {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(c.stream.Heartbeat())
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if !send(": heartbeat\n\n") {
+				return
+			}
+		case entry, ok := <-sub.Events:
+			if !ok {
+				// Dropped for lagging behind, the client resumes from the buffer
+				return
+			}
+			if !c.sendEntry(principal, entry, send) {
+				return
+			}
+		}
+	}
+}
+
+// sendEntry writes the event if the principal may see it
+func (c *StreamController) sendEntry(principal models.Principal, entry stream.Entry, send func(string, ...any) bool) bool {
+	if !canSee(principal, entry.Event) {
+		return true
+	}
+
+	data, err := json.Marshal(entry.Event)
+	if err != nil {
+		c.log.Info("error encoding event: ", zap.Error(err))
+		return true
+	}
+
+	return send("id: %d\nevent: %s\ndata: %s\n\n", entry.ID, entry.Event.Type, data)
+}
+
+// canSee tells whether the event is visible to the principal: the tasks are
+// shared, the timers are seen by their user and by the administrators
+func canSee(principal models.Principal, event events.Event) bool {
+	switch event.Type {
+	case events.TimerStarted, events.TimerStopped:
+		return principal.Role == models.RoleAdmin || event.ID == principal.UserID
+	}
+
+	return true
+}
@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	authz "github.com/wurt83ow/timetracker/internal/authorization"
+	"github.com/wurt83ow/timetracker/internal/events"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/stream"
+	"go.uber.org/zap"
+)
+
+type streamOptions struct{}
+
+func (streamOptions) StreamBufferSize() string        { return "10" }
+func (streamOptions) StreamHeartbeatInterval() string { return "50ms" }
+
+// readEvents reads the stream until n events and returns their event lines
+func readEvents(t *testing.T, lines *bufio.Scanner, n int) []string {
+	t.Helper()
+
+	var got []string
+	for len(got) < n && lines.Scan() {
+		if line := lines.Text(); strings.HasPrefix(line, "event: ") {
+			got = append(got, line)
+		}
+	}
+	return got
+}
+
+// readHeartbeat reads the stream until a heartbeat and returns the events before it
+func readHeartbeat(t *testing.T, lines *bufio.Scanner) []string {
+	t.Helper()
+
+	var got []string
+	for lines.Scan() {
+		line := lines.Text()
+		if line == ": heartbeat" {
+			break
+		}
+		if strings.HasPrefix(line, "event: ") {
+			got = append(got, line)
+		}
+	}
+	return got
+}
+
+func TestStreamController_Stream(t *testing.T) {
+	bus := events.NewBus(zap.NewNop())
+	defer bus.Close()
+	hub := stream.NewHub(bus, zap.NewNop(), streamOptions{})
+	hub.Start()
+	defer hub.Stop()
+
+	log := new(MockLog)
+	log.On("Info", mock.Anything, mock.Anything).Return()
+	router := NewStreamController(hub, new(MockAuthz), log).Route()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal := models.Principal{UserID: 1, Role: r.URL.Query().Get("role")}
+		router.ServeHTTP(w, r.WithContext(authz.WithPrincipal(r.Context(), principal)))
+	}))
+	defer server.Close()
+
+	// The streams end with the requests, before the server is closed
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	open := func(role, lastID string) *bufio.Scanner {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/stream?role="+role, nil)
+		require.NoError(t, err)
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+
+		resp, err := server.Client().Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		return bufio.NewScanner(resp.Body)
+	}
+
+	user := open(models.RoleUser, "")
+	admin := open(models.RoleAdmin, "")
+
+	// An idle stream gets heartbeats
+	assert.Empty(t, readHeartbeat(t, user))
+
+	bus.Publish(events.Event{Type: events.TimerStarted, ID: 2, Data: events.Timer{UserID: 2, TaskID: 5}})
+	bus.Publish(events.Event{Type: events.TimerStarted, ID: 1, Data: events.Timer{UserID: 1, TaskID: 5}})
+	bus.Publish(events.Event{Type: events.TaskUpdated, ID: 5})
+
+	// A user gets its own timers only
+	assert.Equal(t, []string{"event: timer.started", "event: task.updated"}, readEvents(t, user, 2))
+	assert.Equal(t, []string{"event: timer.started", "event: timer.started", "event: task.updated"}, readEvents(t, admin, 3))
+
+	// A client resumes after the last event it got
+	resumed := open(models.RoleAdmin, "1")
+	assert.Equal(t, []string{"event: timer.started", "event: task.updated"}, readEvents(t, resumed, 2))
+
+	reset := open(models.RoleAdmin, "42")
+	assert.Equal(t, []string{"event: reset"}, readEvents(t, reset, 1))
+}
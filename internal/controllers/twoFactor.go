@@ -0,0 +1,244 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	authz "github.com/wurt83ow/timetracker/internal/authorization"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/twofactor"
+	"go.uber.org/zap"
+)
+
+// @Summary Complete two-factor login
+// @Description Exchange a login challenge token and a TOTP or recovery code for a JWT token
+// @Tags User
+// @Accept json
+// @Produce json
+// @Param login body models.RequestTwoFactorLogin true "Challenge and code"
+// @Success 200 {object} models.ResponseUser "User logged in successfully"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 429 {string} string "Too many invalid codes"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /api/user/login/2fa [post]
+func (h *BaseController) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var reqData models.RequestTwoFactorLogin
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		h.log.Info("cannot decode request JSON body: ", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	userID, err := h.authz.DecodeChallengeToken(reqData.ChallengeToken)
+	if err != nil {
+		h.log.Info("invalid challenge token: ", zap.Error(err))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.storage.GetUserByID(h.ctx, userID)
+	if err != nil {
+		h.log.Info("user not found: ", zap.Error(err))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.twoFactor.Verify(actorContext(h.ctx, r, user.UUID), user.UUID, reqData.Code); err != nil {
+		if errors.Is(err, twofactor.ErrInvalidCode) || errors.Is(err, twofactor.ErrNotEnrolled) {
+			h.log.Info("invalid second factor: ", zap.Error(err))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if errors.Is(err, twofactor.ErrLocked) {
+			h.log.Info("second factor locked out: ", zap.Int("userID", user.UUID))
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		h.log.Info("error verifying second factor: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	freshToken := h.authz.CreateJWTTokenForUser(user.UUID)
+	http.SetCookie(w, h.authz.AuthCookie("jwt-token", freshToken))
+	http.SetCookie(w, h.authz.AuthCookie("Authorization", freshToken))
+
+	w.Header().Set("Authorization", freshToken)
+	if err := json.NewEncoder(w).Encode(models.ResponseUser{Response: "success"}); err != nil {
+		h.log.Info("error encoding response: ", zap.Error(err))
+	}
+}
+
+// @Summary Start two-factor enrollment
+// @Description Generate a new TOTP secret for the current user. It becomes active after confirmation.
+// @Tags User
+// @Produce json
+// @Success 200 {object} models.TwoFactorEnrollment "Secret and otpauth URI"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 409 {string} string "Two-factor authentication is already enabled"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /api/me/2fa/enroll [post]
+func (h *BaseController) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	enrollment, err := h.twoFactor.Enroll(auditContext(h.ctx, r), user.UUID, fmt.Sprintf("user-%d", user.UUID))
+	if errors.Is(err, twofactor.ErrAlreadyEnabled) {
+		h.log.Info("two-factor authentication is already enabled")
+		w.WriteHeader(http.StatusConflict)
+		return
+	} else if err != nil {
+		h.log.Info("error enrolling two-factor authentication: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(enrollment); err != nil {
+		h.log.Info("error encoding response: ", zap.Error(err))
+	}
+}
+
+// @Summary Confirm two-factor enrollment
+// @Description Activate two-factor authentication with a code from the authenticator app.
+// @Description The returned recovery codes are shown only once.
+// @Tags User
+// @Accept json
+// @Produce json
+// @Param code body models.RequestTwoFactorCode true "TOTP code"
+// @Success 200 {object} models.ResponseRecoveryCodes "Recovery codes"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "Enrollment not found"
+// @Failure 409 {string} string "Two-factor authentication is already enabled"
+// @Failure 429 {string} string "Too many invalid codes"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /api/me/2fa/confirm [post]
+func (h *BaseController) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var reqData models.RequestTwoFactorCode
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		h.log.Info("cannot decode request JSON body: ", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := h.twoFactor.Confirm(auditContext(h.ctx, r), user.UUID, reqData.Code)
+	switch {
+	case errors.Is(err, twofactor.ErrInvalidCode):
+		h.log.Info("invalid two-factor code")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	case errors.Is(err, twofactor.ErrNotEnrolled):
+		h.log.Info("two-factor enrollment not found")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case errors.Is(err, twofactor.ErrLocked):
+		h.log.Info("two-factor confirmation locked out")
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	case errors.Is(err, twofactor.ErrAlreadyEnabled):
+		h.log.Info("two-factor authentication is already enabled")
+		w.WriteHeader(http.StatusConflict)
+		return
+	case err != nil:
+		h.log.Info("error confirming two-factor authentication: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(models.ResponseRecoveryCodes{RecoveryCodes: codes}); err != nil {
+		h.log.Info("error encoding response: ", zap.Error(err))
+	}
+}
+
+// @Summary Disable two-factor authentication
+// @Description Disable two-factor authentication with a TOTP or recovery code
+// @Tags User
+// @Accept json
+// @Produce json
+// @Param code body models.RequestTwoFactorCode true "TOTP or recovery code"
+// @Success 200 {string} string "Two-factor authentication disabled"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "Two-factor authentication is not enabled"
+// @Failure 429 {string} string "Too many invalid codes"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /api/me/2fa/disable [post]
+func (h *BaseController) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var reqData models.RequestTwoFactorCode
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		h.log.Info("cannot decode request JSON body: ", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.twoFactor.Disable(auditContext(h.ctx, r), user.UUID, reqData.Code)
+	switch {
+	case errors.Is(err, twofactor.ErrInvalidCode):
+		h.log.Info("invalid two-factor code")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	case errors.Is(err, twofactor.ErrNotEnrolled):
+		h.log.Info("two-factor authentication is not enabled")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case errors.Is(err, twofactor.ErrLocked):
+		h.log.Info("two-factor verification locked out")
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	case err != nil:
+		h.log.Info("error disabling two-factor authentication: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	h.log.Info("Two-factor authentication disabled")
+}
+
+// writeTwoFactorChallenge answers a successful first factor of a user with enabled 2FA
+func writeTwoFactorChallenge(w http.ResponseWriter, log Log, challengeToken string) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(models.ResponseTwoFactorChallenge{
+		Response:       "second factor required",
+		ChallengeToken: challengeToken,
+	})
+	if err != nil {
+		log.Info("error encoding response: ", zap.Error(err))
+	}
+}
+
+// currentUser resolves the authenticated user, writing an error response if it fails
+func (h *BaseController) currentUser(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	principal, ok := authz.PrincipalFromContext(r.Context())
+	if !ok {
+		h.log.Info("principal not found in context")
+		w.WriteHeader(http.StatusUnauthorized)
+		return models.User{}, false
+	}
+
+	user, err := h.storage.GetUserByID(h.ctx, principal.UserID)
+	if err != nil {
+		h.log.Info("user not found", zap.Error(err))
+		w.WriteHeader(http.StatusUnauthorized)
+		return models.User{}, false
+	}
+
+	return user, true
+}
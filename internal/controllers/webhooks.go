@@ -0,0 +1,303 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	authz "github.com/wurt83ow/timetracker/internal/authorization"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"github.com/wurt83ow/timetracker/internal/webhooks"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultDeliveriesLimit = 50
+	maxDeliveriesLimit     = 500
+)
+
+type Webhooks interface {
+	Create(context.Context, int, models.RequestWebhook) (models.Webhook, error)
+	List(context.Context) ([]models.Webhook, error)
+	Delete(context.Context, int) error
+	Deliveries(context.Context, int, int) ([]models.WebhookDelivery, error)
+	Redeliver(context.Context, int, int64) error
+}
+
+// Authenticator guards the routes of a controller with the JWT of the user
+type Authenticator interface {
+	JWTAuthzMiddleware(authz.Log) func(http.Handler) http.Handler
+}
+
+type WebhookController struct {
+	webhooks Webhooks
+	authz    Authenticator
+	log      Log
+}
+
+// NewWebhookController creates a new WebhookController instance
+func NewWebhookController(webhooks Webhooks, authz Authenticator, log Log) *WebhookController {
+	return &WebhookController{
+		webhooks: webhooks,
+		authz:    authz,
+		log:      log,
+	}
+}
+
+// Route sets up the routes for the WebhookController, to be mounted at /api/webhooks
+func (c *WebhookController) Route() *chi.Mux {
+	r := chi.NewRouter()
+	r.Use(c.authz.JWTAuthzMiddleware(c.log))
+
+	r.Post("/", c.CreateWebhook)
+	r.Get("/", c.GetWebhooks)
+	r.Delete("/{id}", c.DeleteWebhook)
+	r.Get("/{id}/deliveries", c.GetWebhookDeliveries)
+	r.Post("/{id}/deliveries/{deliveryID}/redeliver", c.Redeliver)
+
+	return r
+}
+
+// @Summary Create webhook
+// @Description Post the events of the types to the URL. Every request carries the headers X-Webhook-Event,
+// @Description X-Webhook-Delivery, X-Webhook-Timestamp and X-Webhook-Signature, which is "sha256=" and the hex
+// @Description HMAC-SHA256 of the timestamp, a dot and the body, keyed with the secret. A secret is generated
+// @Description unless one is given, and is returned only in this response. Only available to administrators.
+// @Tags Webhooks
+// @Accept json
+// @Produce json
+// @Param webhook body models.RequestWebhook true "Webhook"
+// @Success 201 {object} models.Webhook "Created webhook with its secret"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /api/webhooks [post]
+func (c *WebhookController) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	principal, ok := c.admin(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.RequestWebhook
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		c.log.Info("cannot decode request JSON body: ", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	hook, err := c.webhooks.Create(r.Context(), principal.UserID, req)
+	if errors.Is(err, webhooks.ErrInvalidWebhook) {
+		c.log.Info("invalid webhook: ", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		if _, err := w.Write([]byte(err.Error())); err != nil {
+			c.log.Info("error writing response: ", zap.Error(err))
+		}
+		return
+	} else if err != nil {
+		c.log.Info("error saving webhook: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(hook); err != nil {
+		c.log.Info("error encoding response: ", zap.Error(err))
+	}
+	c.log.Info("Webhook created", zap.Int("id", hook.ID))
+}
+
+// @Summary Get webhooks
+// @Description List the webhooks without their secrets. Only available to administrators.
+// @Tags Webhooks
+// @Produce json
+// @Success 200 {array} models.Webhook "Webhooks"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /api/webhooks [get]
+func (c *WebhookController) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	if _, ok := c.admin(w, r); !ok {
+		return
+	}
+
+	hooks, err := c.webhooks.List(r.Context())
+	if err != nil {
+		c.log.Info("error getting webhooks: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(hooks); err != nil {
+		c.log.Info("error encoding response: ", zap.Error(err))
+	}
+}
+
+// @Summary Delete webhook
+// @Description Delete a webhook with its delivery log. Only available to administrators.
+// @Tags Webhooks
+// @Param id path int true "Webhook ID"
+// @Success 204 {string} string "Webhook deleted"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not Found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /api/webhooks/{id} [delete]
+func (c *WebhookController) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if _, ok := c.admin(w, r); !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		c.log.Info("invalid webhook ID format")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = c.webhooks.Delete(r.Context(), id)
+	if errors.Is(err, storage.ErrNotFound) {
+		c.log.Info("webhook not found")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		c.log.Info("error deleting webhook: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	c.log.Info("Webhook deleted", zap.Int("id", id))
+}
+
+// @Summary Get webhook deliveries
+// @Description List the most recent deliveries of a webhook with the outcome of their last attempt.
+// @Description Only available to administrators.
+// @Tags Webhooks
+// @Produce json
+// @Param id path int true "Webhook ID"
+// @Param limit query int false "Number of deliveries, 50 by default and at most 500"
+// @Success 200 {array} models.WebhookDelivery "Deliveries, the most recent first"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not Found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /api/webhooks/{id}/deliveries [get]
+func (c *WebhookController) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	if _, ok := c.admin(w, r); !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		c.log.Info("invalid webhook ID format")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultDeliveriesLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxDeliveriesLimit {
+			c.log.Info("invalid deliveries limit")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	deliveries, err := c.webhooks.Deliveries(r.Context(), id, limit)
+	if errors.Is(err, storage.ErrNotFound) {
+		c.log.Info("webhook not found")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		c.log.Info("error getting webhook deliveries: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(deliveries); err != nil {
+		c.log.Info("error encoding response: ", zap.Error(err))
+	}
+}
+
+// @Summary Redeliver webhook delivery
+// @Description Queue a delivery of a webhook again, e.g. after the endpoint has recovered from a failure.
+// @Description Only available to administrators.
+// @Tags Webhooks
+// @Param id path int true "Webhook ID"
+// @Param deliveryID path int true "Delivery ID"
+// @Success 202 {string} string "Delivery queued"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Not Found"
+// @Failure 409 {string} string "Delivery is queued already"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /api/webhooks/{id}/deliveries/{deliveryID}/redeliver [post]
+func (c *WebhookController) Redeliver(w http.ResponseWriter, r *http.Request) {
+	if _, ok := c.admin(w, r); !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		c.log.Info("invalid webhook ID format")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	deliveryID, err := strconv.ParseInt(chi.URLParam(r, "deliveryID"), 10, 64)
+	if err != nil {
+		c.log.Info("invalid delivery ID format")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = c.webhooks.Redeliver(r.Context(), id, deliveryID)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		c.log.Info("webhook delivery not found")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case errors.Is(err, storage.ErrConflict):
+		c.log.Info("webhook delivery is queued already")
+		w.WriteHeader(http.StatusConflict)
+		return
+	case err != nil:
+		c.log.Info("error queueing webhook delivery: ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	c.log.Info("Webhook delivery queued", zap.Int("webhook", id), zap.Int64("delivery", deliveryID))
+}
+
+// admin returns the principal of the request if it is an administrator, and writes
+// the error status otherwise
+func (c *WebhookController) admin(w http.ResponseWriter, r *http.Request) (models.Principal, bool) {
+	principal, ok := authz.PrincipalFromContext(r.Context())
+	if !ok {
+		c.log.Info("principal not found in context")
+		w.WriteHeader(http.StatusUnauthorized)
+		return models.Principal{}, false
+	}
+
+	if principal.Role != models.RoleAdmin {
+		c.log.Info("access to the webhooks denied", zap.Int("userID", principal.UserID))
+		w.WriteHeader(http.StatusForbidden)
+		return models.Principal{}, false
+	}
+
+	return principal, true
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	authz "github.com/wurt83ow/timetracker/internal/authorization"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"github.com/wurt83ow/timetracker/internal/webhooks"
+)
+
+type MockWebhooks struct {
+	mock.Mock
+}
+
+func (m *MockWebhooks) Create(ctx context.Context, userID int, req models.RequestWebhook) (models.Webhook, error) {
+	args := m.Called(ctx, userID, req)
+	return args.Get(0).(models.Webhook), args.Error(1)
+}
+
+func (m *MockWebhooks) List(ctx context.Context) ([]models.Webhook, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]models.Webhook), args.Error(1)
+}
+
+func (m *MockWebhooks) Delete(ctx context.Context, id int) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockWebhooks) Deliveries(ctx context.Context, webhookID int, limit int) ([]models.WebhookDelivery, error) {
+	args := m.Called(ctx, webhookID, limit)
+	return args.Get(0).([]models.WebhookDelivery), args.Error(1)
+}
+
+func (m *MockWebhooks) Redeliver(ctx context.Context, webhookID int, deliveryID int64) error {
+	args := m.Called(ctx, webhookID, deliveryID)
+	return args.Error(0)
+}
+
+func TestWebhookController(t *testing.T) {
+	hooks := new(MockWebhooks)
+	log := new(MockLog)
+	ctx := context.Background()
+	controller := NewWebhookController(hooks, new(MockAuthz), log)
+
+	created := models.Webhook{ID: 2, UserID: 1, URL: "https://example.com/hooks", Events: []string{"task.created"}, Secret: "s3cret"}
+	hooks.On("Create", mock.Anything, 1, mock.MatchedBy(func(req models.RequestWebhook) bool {
+		return req.URL == created.URL
+	})).Return(created, nil)
+	hooks.On("Create", mock.Anything, 1, mock.Anything).
+		Return(models.Webhook{}, fmt.Errorf("%w: no event types", webhooks.ErrInvalidWebhook))
+	hooks.On("List", mock.Anything).Return([]models.Webhook{{ID: 2, URL: created.URL}}, nil)
+	hooks.On("Delete", mock.Anything, 2).Return(nil)
+	hooks.On("Delete", mock.Anything, 9).Return(storage.ErrNotFound)
+	hooks.On("Deliveries", mock.Anything, 2, 50).Return([]models.WebhookDelivery{{ID: 7, WebhookID: 2}}, nil)
+	hooks.On("Deliveries", mock.Anything, 2, 5).Return([]models.WebhookDelivery{}, nil)
+	hooks.On("Deliveries", mock.Anything, 9, 50).Return([]models.WebhookDelivery(nil), storage.ErrNotFound)
+	hooks.On("Redeliver", mock.Anything, 2, int64(7)).Return(nil).Once()
+	hooks.On("Redeliver", mock.Anything, 2, int64(7)).Return(storage.ErrConflict)
+	hooks.On("Redeliver", mock.Anything, 2, int64(8)).Return(storage.ErrNotFound)
+	log.On("Info", mock.Anything, mock.Anything).Return()
+
+	router := controller.Route()
+	serve := func(method, target, body, role string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		principal := models.Principal{UserID: 1, Role: role}
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		router.ServeHTTP(rr, req.WithContext(authz.WithPrincipal(ctx, principal)))
+		return rr
+	}
+
+	rr := serve(http.MethodPost, "/", `{"url":"https://example.com/hooks","events":["task.created"]}`, models.RoleAdmin)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	var hook models.Webhook
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &hook))
+	assert.Equal(t, "s3cret", hook.Secret)
+
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/", `{"url":"https://example.com/other"}`, models.RoleAdmin).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/", `{"url":`, models.RoleAdmin).Code)
+
+	rr = serve(http.MethodGet, "/", "", models.RoleAdmin)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "secret")
+
+	rr = serve(http.MethodGet, "/2/deliveries", "", models.RoleAdmin)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var deliveries []models.WebhookDelivery
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &deliveries))
+	require.Len(t, deliveries, 1)
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/2/deliveries?limit=5", "", models.RoleAdmin).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodGet, "/2/deliveries?limit=0", "", models.RoleAdmin).Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/9/deliveries", "", models.RoleAdmin).Code)
+
+	assert.Equal(t, http.StatusAccepted, serve(http.MethodPost, "/2/deliveries/7/redeliver", "", models.RoleAdmin).Code)
+	assert.Equal(t, http.StatusConflict, serve(http.MethodPost, "/2/deliveries/7/redeliver", "", models.RoleAdmin).Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodPost, "/2/deliveries/8/redeliver", "", models.RoleAdmin).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/2/deliveries/x/redeliver", "", models.RoleAdmin).Code)
+
+	assert.Equal(t, http.StatusNoContent, serve(http.MethodDelete, "/2", "", models.RoleAdmin).Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodDelete, "/9", "", models.RoleAdmin).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodDelete, "/abc", "", models.RoleAdmin).Code)
+
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodGet, "/", "", models.RoleUser).Code)
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodPost, "/", `{}`, models.RoleUser).Code)
+	hooks.AssertNumberOfCalls(t, "Create", 2)
+}
@@ -0,0 +1,147 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+)
+
+// envelopeVersion prefixes sealed values so the format can be changed later
+const envelopeVersion = "v1"
+
+const dataKeySize = 32
+
+// Envelope encrypts each value with a fresh data key and stores the data key
+// encrypted with the master key next to the value. It also computes blind
+// indexes, keyed hashes that allow equality lookups of encrypted values.
+type Envelope struct {
+	master   cipher.AEAD
+	indexKey []byte
+}
+
+// NewEnvelope creates a new Envelope, deriving the master and blind index keys from the given secret
+func NewEnvelope(secret string) (*Envelope, error) {
+	if secret == "" {
+		return nil, errors.New("encryption key is empty")
+	}
+
+	master, err := newAEAD(deriveKey(secret, "envelope master key"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{
+		master:   master,
+		indexKey: deriveKey(secret, "blind index key"),
+	}, nil
+}
+
+// Seal encrypts the plaintext under a new data key
+func (e *Envelope) Seal(plaintext string) (string, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	wrappedKey, err := seal(e.master, dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := seal(data, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+
+	return envelopeVersion + "." + wrappedKey + "." + sealed, nil
+}
+
+// Open decrypts a value produced by Seal
+func (e *Envelope) Open(value string) (string, error) {
+	parts := strings.Split(value, ".")
+	if len(parts) != 3 || parts[0] != envelopeVersion {
+		return "", ErrInvalidCiphertext
+	}
+
+	dataKey, err := open(e.master, parts[1])
+	if err != nil {
+		return "", err
+	}
+
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return "", ErrInvalidCiphertext
+	}
+
+	plaintext, err := open(data, parts[2])
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+// BlindIndex returns the hex encoded HMAC-SHA256 of the value
+func (e *Envelope) BlindIndex(value string) string {
+	mac := hmac.New(sha256.New, e.indexKey)
+	mac.Write([]byte(value))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func seal(aead cipher.AEAD, plaintext []byte) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	return base64.RawStdEncoding.EncodeToString(aead.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+func open(aead cipher.AEAD, value string) ([]byte, error) {
+	data, err := base64.RawStdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+
+	nonceSize := aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, ErrInvalidCiphertext
+	}
+
+	plaintext, err := aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+
+	return plaintext, nil
+}
+
+// deriveKey derives a separate 256-bit key for each purpose from the secret
+func deriveKey(secret string, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose))
+
+	return mac.Sum(nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
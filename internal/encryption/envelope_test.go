@@ -0,0 +1,59 @@
+package encryption
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvelope_SealOpen(t *testing.T) {
+	e, err := NewEnvelope("secret")
+	require.NoError(t, err)
+
+	first, err := e.Seal("1234 567890")
+	require.NoError(t, err)
+	second, err := e.Seal("1234 567890")
+	require.NoError(t, err)
+
+	// Every value gets its own data key and nonce
+	assert.NotEqual(t, first, second)
+	assert.NotContains(t, first, "567890")
+
+	plaintext, err := e.Open(first)
+	require.NoError(t, err)
+	assert.Equal(t, "1234 567890", plaintext)
+}
+
+func TestEnvelope_OpenRejectsTamperedValues(t *testing.T) {
+	e, err := NewEnvelope("secret")
+	require.NoError(t, err)
+
+	sealed, err := e.Seal("Ivanov")
+	require.NoError(t, err)
+
+	other, err := NewEnvelope("other secret")
+	require.NoError(t, err)
+	_, err = other.Open(sealed)
+	assert.ErrorIs(t, err, ErrInvalidCiphertext)
+
+	parts := strings.Split(sealed, ".")
+	parts[2] = parts[1]
+	_, err = e.Open(strings.Join(parts, "."))
+	assert.ErrorIs(t, err, ErrInvalidCiphertext)
+
+	_, err = e.Open("Ivanov")
+	assert.ErrorIs(t, err, ErrInvalidCiphertext)
+}
+
+func TestEnvelope_BlindIndex(t *testing.T) {
+	e, err := NewEnvelope("secret")
+	require.NoError(t, err)
+	other, err := NewEnvelope("other secret")
+	require.NoError(t, err)
+
+	assert.Equal(t, e.BlindIndex("1234 567890"), e.BlindIndex("1234 567890"))
+	assert.NotEqual(t, e.BlindIndex("1234 567890"), e.BlindIndex("1234 567891"))
+	assert.NotEqual(t, e.BlindIndex("1234 567890"), other.BlindIndex("1234 567890"))
+}
@@ -0,0 +1,72 @@
+package enrichment
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/wurt83ow/timetracker/internal/models"
+)
+
+// Chain is a fallback provider: it returns the result of the first provider
+// that has data for the passport, trying the next one on errors and misses
+type Chain struct {
+	providers []Provider
+}
+
+func NewChain(providers ...Provider) *Chain {
+	return &Chain{providers: providers}
+}
+
+// Name joins the names of the providers with "|"
+func (c *Chain) Name() string {
+	names := make([]string, len(c.providers))
+	for i, p := range c.providers {
+		names[i] = p.Name()
+	}
+	return strings.Join(names, "|")
+}
+
+// Fields returns the fields owned by any of the providers
+func (c *Chain) Fields() []Field {
+	var fields []Field
+	for _, field := range Fields {
+		for _, p := range c.providers {
+			if owns(p, field) {
+				fields = append(fields, field)
+				break
+			}
+		}
+	}
+	return fields
+}
+
+// Lookup returns the fields owned by the provider that answered. If all the
+// providers fail, the last error is returned, or ErrNotFound if none has the user.
+func (c *Chain) Lookup(ctx context.Context, passportSerie, passportNumber int) (models.ExtUserData, error) {
+	err := ErrNotFound
+
+	for _, p := range c.providers {
+		data, lookupErr := p.Lookup(ctx, passportSerie, passportNumber)
+		if lookupErr == nil {
+			return owned(p, data), nil
+		}
+		if !errors.Is(lookupErr, ErrNotFound) {
+			err = lookupErr
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return models.ExtUserData{}, err
+}
+
+func (c *Chain) contains(name string) bool {
+	for _, p := range c.providers {
+		if p.Name() == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+// Package enrichment looks up the personal data of users in external sources.
+// Every Provider owns a set of fields, and a Merger combines the results of
+// several providers field by field, in the order of precedence of each field.
+package enrichment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/wurt83ow/timetracker/internal/models"
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	// ErrNotFound means that a provider has no data for the passport
+	ErrNotFound = errors.New("user data not found")
+
+	ErrUnknownProvider = errors.New("unknown enrichment provider")
+	ErrInvalidField    = errors.New("invalid enrichment field")
+)
+
+// Field is an enriched field of ExtUserData
+type Field string
+
+const (
+	FieldSurname    Field = "surname"
+	FieldName       Field = "name"
+	FieldPatronymic Field = "patronymic"
+	FieldAddress    Field = "address"
+)
+
+// Fields are all the enriched fields in their canonical order
+var Fields = []Field{FieldSurname, FieldName, FieldPatronymic, FieldAddress}
+
+type Log interface {
+	Info(string, ...zapcore.Field)
+}
+
+// Provider looks up the data of a user by passport
+type Provider interface {
+	Name() string
+	// Fields returns the fields the provider owns, other fields of its results are ignored
+	Fields() []Field
+	Lookup(ctx context.Context, passportSerie, passportNumber int) (models.ExtUserData, error)
+}
+
+func (f Field) get(data models.ExtUserData) string {
+	switch f {
+	case FieldSurname:
+		return data.Surname
+	case FieldName:
+		return data.Name
+	case FieldPatronymic:
+		return data.Patronymic
+	case FieldAddress:
+		return data.Address
+	}
+	return ""
+}
+
+func (f Field) set(data *models.ExtUserData, value string) {
+	switch f {
+	case FieldSurname:
+		data.Surname = value
+	case FieldName:
+		data.Name = value
+	case FieldPatronymic:
+		data.Patronymic = value
+	case FieldAddress:
+		data.Address = value
+	}
+}
+
+// ParseFields parses a comma-separated list of fields
+func ParseFields(s string) ([]Field, error) {
+	var fields []Field
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		field, err := parseField(name)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+func parseField(name string) (Field, error) {
+	for _, field := range Fields {
+		if string(field) == name {
+			return field, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q", ErrInvalidField, name)
+}
+
+func owns(p Provider, field Field) bool {
+	for _, f := range p.Fields() {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// owned keeps the fields of data owned by the provider
+func owned(p Provider, data models.ExtUserData) models.ExtUserData {
+	result := models.ExtUserData{PassportSerie: data.PassportSerie, PassportNumber: data.PassportNumber}
+	for _, field := range p.Fields() {
+		field.set(&result, field.get(data))
+	}
+	return result
+}
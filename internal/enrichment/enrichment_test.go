@@ -0,0 +1,122 @@
+package enrichment
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wurt83ow/timetracker/internal/models"
+)
+
+type fakeProvider struct {
+	name   string
+	fields []Field
+	data   models.ExtUserData
+	err    error
+	calls  int
+}
+
+func (p *fakeProvider) Name() string    { return p.name }
+func (p *fakeProvider) Fields() []Field { return p.fields }
+
+func (p *fakeProvider) Lookup(ctx context.Context, passportSerie, passportNumber int) (models.ExtUserData, error) {
+	p.calls++
+	if p.err != nil {
+		return models.ExtUserData{}, p.err
+	}
+	data := p.data
+	data.PassportSerie, data.PassportNumber = passportSerie, passportNumber
+	return data, nil
+}
+
+func TestMerger_MergesOwnedFields(t *testing.T) {
+	api := &fakeProvider{name: "http", fields: []Field{FieldSurname, FieldName, FieldAddress},
+		data: models.ExtUserData{Surname: "Ivanov", Name: "Ivan", Patronymic: "ignored", Address: "Moscow"}}
+	file := &fakeProvider{name: "file", fields: []Field{FieldName, FieldPatronymic, FieldAddress},
+		data: models.ExtUserData{Name: "Vanya", Patronymic: "Ivanovich"}}
+
+	m, err := NewMerger([]Provider{api, file}, map[Field][]string{FieldAddress: {"file"}})
+	require.NoError(t, err)
+
+	data, err := m.Enrich(context.Background(), 1234, 567890)
+	require.NoError(t, err)
+	assert.Equal(t, models.ExtUserData{
+		PassportSerie:  1234,
+		PassportNumber: 567890,
+		Surname:        "Ivanov",
+		Name:           "Ivan",      // http comes first by default
+		Patronymic:     "Ivanovich", // only the file owns the patronymic
+		Address:        "Moscow",    // the file comes first for the address, but has no value
+	}, data)
+}
+
+func TestMerger_Errors(t *testing.T) {
+	failing := &fakeProvider{name: "http", fields: Fields, err: errors.New("unavailable")}
+	missing := &fakeProvider{name: "file", fields: Fields, err: ErrNotFound}
+
+	m, err := NewMerger([]Provider{missing}, nil)
+	require.NoError(t, err)
+	_, err = m.Enrich(context.Background(), 1, 2)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	m, err = NewMerger([]Provider{missing, failing}, nil)
+	require.NoError(t, err)
+	_, err = m.Enrich(context.Background(), 1, 2)
+	assert.EqualError(t, err, "http: unavailable", "a partial result is not returned")
+
+	_, err = NewMerger([]Provider{missing}, map[Field][]string{FieldName: {"ldap"}})
+	assert.ErrorIs(t, err, ErrUnknownProvider)
+}
+
+func TestChain_FallsBack(t *testing.T) {
+	api := &fakeProvider{name: "http", fields: []Field{FieldSurname}, err: errors.New("unavailable")}
+	file := &fakeProvider{name: "file", fields: []Field{FieldSurname, FieldPatronymic},
+		data: models.ExtUserData{Surname: "Ivanov", Patronymic: "Ivanovich", Address: "not owned"}}
+
+	chain := NewChain(api, file)
+	assert.Equal(t, "http|file", chain.Name())
+	assert.Equal(t, []Field{FieldSurname, FieldPatronymic}, chain.Fields())
+
+	data, err := chain.Lookup(context.Background(), 1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, models.ExtUserData{PassportSerie: 1, PassportNumber: 2, Surname: "Ivanov", Patronymic: "Ivanovich"}, data)
+
+	api.err = nil
+	api.data = models.ExtUserData{Surname: "Petrov"}
+	data, err = chain.Lookup(context.Background(), 1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, "Petrov", data.Surname)
+	assert.Empty(t, data.Patronymic, "the chain stops at the first answer")
+	assert.Equal(t, 1, file.calls)
+
+	file.err = ErrNotFound
+	api.err = ErrNotFound
+	_, err = chain.Lookup(context.Background(), 1, 2)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestRegistry_Build(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register(&fakeProvider{name: "http", fields: []Field{FieldSurname}, err: errors.New("unavailable")})
+	registry.Register(&fakeProvider{name: "file", fields: []Field{FieldSurname, FieldPatronymic},
+		data: models.ExtUserData{Surname: "Ivanov", Patronymic: "Ivanovich"}})
+	registry.Register(&fakeProvider{name: "ldap", fields: []Field{FieldPatronymic},
+		data: models.ExtUserData{Patronymic: "Petrovich"}})
+
+	m, err := registry.Build("http|file, ldap", "patronymic=ldap")
+	require.NoError(t, err)
+
+	data, err := m.Enrich(context.Background(), 1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, "Ivanov", data.Surname, "the file answers for the failed API")
+	assert.Equal(t, "Petrovich", data.Patronymic)
+
+	_, err = registry.Build("http,smtp", "")
+	assert.ErrorIs(t, err, ErrUnknownProvider)
+	_, err = registry.Build("http", "middle=http")
+	assert.ErrorIs(t, err, ErrInvalidField)
+	_, err = registry.Build(" ", "")
+	assert.ErrorIs(t, err, ErrUnknownProvider)
+}
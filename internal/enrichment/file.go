@@ -0,0 +1,192 @@
+package enrichment
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/models"
+	"go.uber.org/zap"
+)
+
+// ProviderFile is the name of the local directory file provider
+const ProviderFile = "file"
+
+var errInvalidFile = errors.New("invalid enrichment file")
+
+type passport struct {
+	serie, number int
+}
+
+// FileProvider looks users up in a local CSV or JSON directory file. The file
+// is read again when it changes. A CSV file has a header with passportSerie,
+// passportNumber and the owned fields; a JSON file is an array of ExtUserData.
+// The provider owns the fields present in the file.
+type FileProvider struct {
+	path func() string
+	log  Log
+
+	mx      sync.Mutex
+	modTime time.Time
+	fields  []Field
+	users   map[passport]models.ExtUserData
+}
+
+// NewFileProvider creates a FileProvider and reads the file
+func NewFileProvider(path func() string, log Log) (*FileProvider, error) {
+	p := &FileProvider{path: path, log: log}
+
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	if err := p.reload(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *FileProvider) Name() string {
+	return ProviderFile
+}
+
+func (p *FileProvider) Fields() []Field {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	return p.fields
+}
+
+func (p *FileProvider) Lookup(ctx context.Context, passportSerie, passportNumber int) (models.ExtUserData, error) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	// A broken edit keeps the previous contents
+	if err := p.reload(); err != nil {
+		p.log.Info("failed to read the enrichment file: ", zap.Error(err))
+	}
+
+	data, ok := p.users[passport{passportSerie, passportNumber}]
+	if !ok {
+		return models.ExtUserData{}, ErrNotFound
+	}
+
+	return data, nil
+}
+
+// reload reads the file if it has changed since the last read
+func (p *FileProvider) reload() error {
+	path := p.path()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if p.users != nil && info.ModTime().Equal(p.modTime) {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var users []models.ExtUserData
+	var fields []Field
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		users, fields, err = readJSON(f)
+	} else {
+		users, fields, err = readCSV(f)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	p.users = make(map[passport]models.ExtUserData, len(users))
+	for _, user := range users {
+		p.users[passport{user.PassportSerie, user.PassportNumber}] = user
+	}
+	p.fields = fields
+	p.modTime = info.ModTime()
+
+	return nil
+}
+
+func readCSV(r io.Reader) ([]models.ExtUserData, []Field, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil, fmt.Errorf("%w: no header", errInvalidFile)
+	}
+
+	serie, number := -1, -1
+	columns := make(map[int]Field)
+	var fields []Field
+
+	for i, name := range records[0] {
+		switch name = strings.TrimSpace(name); name {
+		case "passportSerie":
+			serie = i
+		case "passportNumber":
+			number = i
+		default:
+			field, err := parseField(name)
+			if err != nil {
+				return nil, nil, err
+			}
+			columns[i] = field
+			fields = append(fields, field)
+		}
+	}
+	if serie < 0 || number < 0 {
+		return nil, nil, fmt.Errorf("%w: passportSerie and passportNumber columns are required", errInvalidFile)
+	}
+
+	users := make([]models.ExtUserData, 0, len(records)-1)
+	for line, record := range records[1:] {
+		var user models.ExtUserData
+		if user.PassportSerie, err = strconv.Atoi(strings.TrimSpace(record[serie])); err != nil {
+			return nil, nil, fmt.Errorf("%w: line %d: %v", errInvalidFile, line+2, err)
+		}
+		if user.PassportNumber, err = strconv.Atoi(strings.TrimSpace(record[number])); err != nil {
+			return nil, nil, fmt.Errorf("%w: line %d: %v", errInvalidFile, line+2, err)
+		}
+		for i, field := range columns {
+			field.set(&user, strings.TrimSpace(record[i]))
+		}
+		users = append(users, user)
+	}
+
+	return users, fields, nil
+}
+
+func readJSON(r io.Reader) ([]models.ExtUserData, []Field, error) {
+	var users []models.ExtUserData
+	if err := json.NewDecoder(r).Decode(&users); err != nil {
+		return nil, nil, err
+	}
+
+	var fields []Field
+	for _, field := range Fields {
+		for _, user := range users {
+			if field.get(user) != "" {
+				fields = append(fields, field)
+				break
+			}
+		}
+	}
+
+	return users, fields, nil
+}
@@ -0,0 +1,68 @@
+package enrichment
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"go.uber.org/zap"
+)
+
+func TestFileProvider_CSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.csv")
+	require.NoError(t, os.WriteFile(path, []byte("passportSerie,passportNumber,patronymic\n1234,567890,Ivanovich\n"), 0o600))
+
+	p, err := NewFileProvider(func() string { return path }, zap.NewNop())
+	require.NoError(t, err)
+	assert.Equal(t, []Field{FieldPatronymic}, p.Fields(), "the provider owns the columns of the file")
+
+	data, err := p.Lookup(context.Background(), 1234, 567890)
+	require.NoError(t, err)
+	assert.Equal(t, models.ExtUserData{PassportSerie: 1234, PassportNumber: 567890, Patronymic: "Ivanovich"}, data)
+
+	_, err = p.Lookup(context.Background(), 1234, 1)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// The file is read again when it changes
+	require.NoError(t, os.WriteFile(path, []byte("passportSerie,passportNumber,surname,patronymic\n1234,1,Petrov,Petrovich\n"), 0o600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+
+	data, err = p.Lookup(context.Background(), 1234, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "Petrov", data.Surname)
+	assert.Equal(t, []Field{FieldSurname, FieldPatronymic}, p.Fields())
+}
+
+func TestFileProvider_JSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[{"passportSerie": 1234, "passportNumber": 567890, "name": "Ivan", "address": "Moscow"}]`), 0o600))
+
+	p, err := NewFileProvider(func() string { return path }, zap.NewNop())
+	require.NoError(t, err)
+	assert.Equal(t, []Field{FieldName, FieldAddress}, p.Fields())
+
+	data, err := p.Lookup(context.Background(), 1234, 567890)
+	require.NoError(t, err)
+	assert.Equal(t, "Moscow", data.Address)
+}
+
+func TestFileProvider_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	for name, contents := range map[string]string{
+		"unknown.csv":  "passportSerie,passportNumber,middle\n",
+		"passport.csv": "surname\nIvanov\n",
+		"number.csv":   "passportSerie,passportNumber\n12a4,567890\n",
+		"broken.json":  `{"passportSerie":`,
+	} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(contents), 0o600))
+
+		_, err := NewFileProvider(func() string { return path }, zap.NewNop())
+		assert.Error(t, err, name)
+	}
+}
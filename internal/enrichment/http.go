@@ -0,0 +1,88 @@
+package enrichment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/wurt83ow/timetracker/internal/models"
+	"go.uber.org/zap"
+)
+
+// ProviderHTTP is the name of the external API system provider
+const ProviderHTTP = "http"
+
+// Client requests the external API system
+type Client interface {
+	Get(ctx context.Context, url string) (*http.Response, error)
+}
+
+// HTTPProvider asks the external API system at /info?passportSerie=&passportNumber=
+type HTTPProvider struct {
+	client Client
+	addr   func() string
+	fields []Field
+	log    Log
+}
+
+func NewHTTPProvider(client Client, addr func() string, fields []Field, log Log) *HTTPProvider {
+	return &HTTPProvider{client: client, addr: addr, fields: fields, log: log}
+}
+
+func (p *HTTPProvider) Name() string {
+	return ProviderHTTP
+}
+
+func (p *HTTPProvider) Fields() []Field {
+	return p.fields
+}
+
+func (p *HTTPProvider) Lookup(ctx context.Context, passportSerie, passportNumber int) (models.ExtUserData, error) {
+	addr := p.addr()
+
+	// Add http scheme if it is missing
+	if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+		addr = "http://" + addr
+	}
+
+	if !strings.HasSuffix(addr, "/") {
+		addr = addr + "/"
+	}
+
+	target := fmt.Sprintf("%sinfo?passportSerie=%d&passportNumber=%d", addr, passportSerie, passportNumber)
+
+	resp, err := p.client.Get(ctx, target)
+	if err != nil {
+		// The URL holds the passport, which must not end up in logs and stored errors
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = fmt.Errorf("%s user info: %w", urlErr.Op, urlErr.Err)
+		}
+		p.log.Info("unable to access user info service, check that it is running: ", zap.Error(err))
+		return models.ExtUserData{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return models.ExtUserData{}, ErrNotFound
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		p.log.Info("status code error: ", zap.String("method", resp.Status))
+		return models.ExtUserData{}, fmt.Errorf("status code error: %s", resp.Status)
+	}
+
+	var userInfo models.ExtUserData
+	if err := json.NewDecoder(resp.Body).Decode(&userInfo); err != nil {
+		return models.ExtUserData{}, err
+	}
+
+	userInfo.PassportSerie = passportSerie
+	userInfo.PassportNumber = passportNumber
+
+	return userInfo, nil
+}
@@ -0,0 +1,47 @@
+package enrichment
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"go.uber.org/zap"
+)
+
+type plainClient struct{}
+
+func (plainClient) Get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
+}
+
+func TestHTTPProvider_Lookup(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/info" || r.URL.Query().Get("passportSerie") != "1234" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"surname": "Ivanov", "name": "Ivan", "patronymic": "Ivanovich", "address": "Moscow"}`))
+	}))
+	defer srv.Close()
+
+	// The address is configured without a scheme
+	addr := srv.Listener.Addr().String()
+	p := NewHTTPProvider(plainClient{}, func() string { return addr }, Fields, zap.NewNop())
+
+	data, err := p.Lookup(context.Background(), 1234, 567890)
+	require.NoError(t, err)
+	assert.Equal(t, models.ExtUserData{
+		PassportSerie: 1234, PassportNumber: 567890, Surname: "Ivanov", Name: "Ivan", Patronymic: "Ivanovich", Address: "Moscow",
+	}, data)
+
+	_, err = p.Lookup(context.Background(), 4321, 567890)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
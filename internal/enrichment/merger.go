@@ -0,0 +1,98 @@
+package enrichment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/wurt83ow/timetracker/internal/models"
+)
+
+// Merger asks every provider and takes each field from the first provider,
+// in the order of precedence of the field, that owns it and has a value
+type Merger struct {
+	providers []Provider
+	order     map[Field][]Provider
+}
+
+// NewMerger creates a Merger. Providers take precedence in the given order,
+// unless precedence lists the names of the providers that come first for a field.
+func NewMerger(providers []Provider, precedence map[Field][]string) (*Merger, error) {
+	m := &Merger{providers: providers, order: make(map[Field][]Provider, len(Fields))}
+
+	for _, field := range Fields {
+		var order []Provider
+		taken := make(map[Provider]bool)
+
+		for _, name := range precedence[field] {
+			p := find(providers, name)
+			if p == nil {
+				return nil, fmt.Errorf("%w: %q in the precedence of %s", ErrUnknownProvider, name, field)
+			}
+			if !taken[p] {
+				order = append(order, p)
+				taken[p] = true
+			}
+		}
+		for _, p := range providers {
+			if !taken[p] {
+				order = append(order, p)
+			}
+		}
+
+		m.order[field] = order
+	}
+
+	return m, nil
+}
+
+// Enrich looks up the user in all providers and merges their results. A provider
+// error fails the enrichment, so that the user is not marked as checked with only
+// a part of the data; fallbacks are configured with a Chain.
+func (m *Merger) Enrich(ctx context.Context, passportSerie, passportNumber int) (models.ExtUserData, error) {
+	results := make(map[Provider]models.ExtUserData, len(m.providers))
+
+	for _, p := range m.providers {
+		data, err := p.Lookup(ctx, passportSerie, passportNumber)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return models.ExtUserData{}, fmt.Errorf("%s: %w", p.Name(), err)
+		}
+		results[p] = data
+	}
+
+	if len(results) == 0 {
+		return models.ExtUserData{}, ErrNotFound
+	}
+
+	merged := models.ExtUserData{PassportSerie: passportSerie, PassportNumber: passportNumber}
+	for _, field := range Fields {
+		for _, p := range m.order[field] {
+			data, ok := results[p]
+			if !ok || !owns(p, field) {
+				continue
+			}
+			if value := field.get(data); value != "" {
+				field.set(&merged, value)
+				break
+			}
+		}
+	}
+
+	return merged, nil
+}
+
+// find returns the provider with the name, or the chain that contains it
+func find(providers []Provider, name string) Provider {
+	for _, p := range providers {
+		if p.Name() == name {
+			return p
+		}
+		if chain, ok := p.(*Chain); ok && chain.contains(name) {
+			return p
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package enrichment
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Registry holds the available providers by name
+type Registry struct {
+	providers map[string]Provider
+}
+
+func NewRegistry() *Registry {
+	return &Registry{providers: make(map[string]Provider)}
+}
+
+// Register adds a provider, replacing a provider with the same name
+func (r *Registry) Register(p Provider) {
+	r.providers[p.Name()] = p
+}
+
+// Build creates a Merger from a spec like "http|file,other": the comma-separated
+// entries are merged in precedence order, and an entry of names joined with "|"
+// is a Chain that falls back to the next provider. The precedence overrides the
+// order for some fields, e.g. "address=file,http;patronymic=file".
+func (r *Registry) Build(spec, precedence string) (*Merger, error) {
+	var providers []Provider
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		var chain []Provider
+		for _, name := range strings.Split(entry, "|") {
+			name = strings.TrimSpace(name)
+			p, ok := r.providers[name]
+			if !ok {
+				return nil, fmt.Errorf("%w: %q", ErrUnknownProvider, name)
+			}
+			chain = append(chain, p)
+		}
+
+		if len(chain) == 1 {
+			providers = append(providers, chain[0])
+		} else {
+			providers = append(providers, NewChain(chain...))
+		}
+	}
+
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("%w: no providers in %q", ErrUnknownProvider, spec)
+	}
+
+	order, err := parsePrecedence(precedence)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewMerger(providers, order)
+}
+
+func parsePrecedence(s string) (map[Field][]string, error) {
+	order := make(map[Field][]string)
+
+	for _, rule := range strings.Split(s, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		name, list, ok := strings.Cut(rule, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: precedence rule %q is not field=providers", ErrInvalidField, rule)
+		}
+
+		field, err := parseField(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, provider := range strings.Split(list, ",") {
+			if provider = strings.TrimSpace(provider); provider != "" {
+				order[field] = append(order[field], provider)
+			}
+		}
+	}
+
+	return order, nil
+}
@@ -0,0 +1,177 @@
+// Package events is an in-process event bus. Publishers don't wait for the
+// subscribers, except for blocking ones: every subscriber gets the events in order
+// on a goroutine of its own.
+package events
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Event types
+const (
+	// UserRegistered is published when a user is created by registration or by an admin
+	UserRegistered = "user.registered"
+	// UserPassportChanged is published when the passport of a user is updated
+	UserPassportChanged = "user.passport_changed"
+	// UserEnriched is published with the data the enrichment found for a user
+	UserEnriched = "user.enriched"
+	// UserEnrichmentFailed is published with the state of a failed enrichment
+	UserEnrichmentFailed = "user.enrichment_failed"
+	// TimerStarted is published with the Timer a user started
+	TimerStarted = "timer.started"
+	// TimerStopped is published with the Timer a user stopped
+	TimerStopped = "timer.stopped"
+	// TaskCreated is published with the task created
+	TaskCreated = "task.created"
+	// TaskUpdated is published with the changed fields of a task
+	TaskUpdated = "task.updated"
+	// TaskDeleted is published when a task is deleted
+	TaskDeleted = "task.deleted"
+)
+
+// queueSize is the number of events a subscriber may lag behind before it misses events
+const queueSize = 256
+
+type Log interface {
+	Info(string, ...zapcore.Field)
+}
+
+// Event tells that something happened to the entity with the ID, e.g. the user of a
+// user.* event. Data depends on the type.
+type Event struct {
+	Type string    `json:"type"`
+	ID   int       `json:"id"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data,omitempty"`
+}
+
+// Timer is the data of the timer.* events, the ID of which is the user
+type Timer struct {
+	UserID    int       `json:"user_id"`
+	TaskID    int       `json:"task_id"`
+	EventDate string    `json:"event_date"`
+	At        time.Time `json:"at"`
+}
+
+type Handler func(Event)
+
+type subscription struct {
+	types   map[string]bool
+	queue   chan Event
+	handler Handler
+	// block makes the publishers wait for room in the queue instead of dropping the event
+	block bool
+}
+
+// Bus delivers the published events to the subscribers
+type Bus struct {
+	mx     sync.RWMutex
+	subs   map[*subscription]struct{}
+	closed bool
+	wg     sync.WaitGroup
+	log    Log
+	now    func() time.Time
+}
+
+func NewBus(log Log) *Bus {
+	return &Bus{subs: make(map[*subscription]struct{}), log: log, now: time.Now}
+}
+
+// Subscribe calls the handler with the events of the types, or with all the events
+// if no type is given. The returned function stops the delivery.
+func (b *Bus) Subscribe(handler Handler, types ...string) (unsubscribe func()) {
+	return b.subscribe(&subscription{queue: make(chan Event, queueSize), handler: handler}, types)
+}
+
+// SubscribeBlocking is Subscribe for a handler that must not miss events: Publish waits
+// while the queue of the handler is full. The handler must be quick, and it must neither
+// publish nor unsubscribe, or the publishers wait forever.
+func (b *Bus) SubscribeBlocking(handler Handler, types ...string) (unsubscribe func()) {
+	return b.subscribe(&subscription{queue: make(chan Event, queueSize), handler: handler, block: true}, types)
+}
+
+func (b *Bus) subscribe(sub *subscription, types []string) func() {
+	if len(types) != 0 {
+		sub.types = make(map[string]bool, len(types))
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if b.closed {
+		return func() {}
+	}
+
+	b.subs[sub] = struct{}{}
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		for event := range sub.queue {
+			sub.handler(event)
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { b.remove(sub) })
+	}
+}
+
+// Publish queues the event for the subscribers. A subscriber whose queue is full
+// misses the event, so that a slow subscriber doesn't hold up the publisher. Only a
+// blocking subscriber holds up the publisher until there is room in its queue.
+func (b *Bus) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = b.now().UTC()
+	}
+
+	b.mx.RLock()
+	defer b.mx.RUnlock()
+
+	for sub := range b.subs {
+		if sub.types != nil && !sub.types[event.Type] {
+			continue
+		}
+
+		if sub.block {
+			sub.queue <- event
+			continue
+		}
+
+		select {
+		case sub.queue <- event:
+		default:
+			b.log.Info("event subscriber is behind, dropping event: ", zap.String("type", event.Type), zap.Int("id", event.ID))
+		}
+	}
+}
+
+// Close stops the delivery and waits for the handlers to finish the queued events
+func (b *Bus) Close() {
+	b.mx.Lock()
+	for sub := range b.subs {
+		delete(b.subs, sub)
+		close(sub.queue)
+	}
+	b.closed = true
+	b.mx.Unlock()
+
+	b.wg.Wait()
+}
+
+func (b *Bus) remove(sub *subscription) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.queue)
+	}
+}
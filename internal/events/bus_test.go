@@ -0,0 +1,109 @@
+package events
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type recorder struct {
+	mx     sync.Mutex
+	events []Event
+}
+
+func (r *recorder) handle(e Event) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.events = append(r.events, e)
+}
+
+func (r *recorder) types() []string {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	types := make([]string, len(r.events))
+	for i, e := range r.events {
+		types[i] = e.Type
+	}
+	return types
+}
+
+func TestBus_DeliversByType(t *testing.T) {
+	bus := NewBus(zap.NewNop())
+
+	var all, enriched recorder
+	bus.Subscribe(all.handle)
+	unsubscribe := bus.Subscribe(enriched.handle, UserEnriched)
+
+	bus.Publish(Event{Type: UserRegistered, ID: 1})
+	bus.Publish(Event{Type: UserEnriched, ID: 1})
+	assert.Eventually(t, func() bool { return len(enriched.types()) == 1 }, time.Second, 10*time.Millisecond)
+
+	unsubscribe()
+	unsubscribe()
+	bus.Publish(Event{Type: UserEnriched, ID: 2})
+	bus.Close()
+
+	assert.Equal(t, []string{UserRegistered, UserEnriched, UserEnriched}, all.types(), "events are delivered in order")
+	assert.Equal(t, []string{UserEnriched}, enriched.types(), "no events after unsubscribing")
+	assert.False(t, all.events[0].Time.IsZero())
+
+	// Publishing after Close is a no-op
+	bus.Publish(Event{Type: UserRegistered, ID: 3})
+}
+
+func TestBus_SlowSubscriberMissesEvents(t *testing.T) {
+	bus := NewBus(zap.NewNop())
+
+	release := make(chan struct{})
+	var got recorder
+	bus.Subscribe(func(e Event) {
+		<-release
+		got.handle(e)
+	})
+
+	// The publisher isn't held up by a full queue
+	for i := 0; i < queueSize+10; i++ {
+		bus.Publish(Event{Type: UserRegistered, ID: i})
+	}
+	close(release)
+	bus.Close()
+
+	assert.Less(t, len(got.types()), queueSize+10)
+	assert.GreaterOrEqual(t, len(got.types()), queueSize)
+}
+
+func TestBus_BlockingSubscriberGetsAllEvents(t *testing.T) {
+	bus := NewBus(zap.NewNop())
+
+	release := make(chan struct{})
+	var got recorder
+	bus.SubscribeBlocking(func(e Event) {
+		<-release
+		got.handle(e)
+	})
+
+	// The publisher waits for the full queue instead of dropping events
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for i := 0; i < queueSize+10; i++ {
+			bus.Publish(Event{Type: UserRegistered, ID: i})
+		}
+	}()
+
+	select {
+	case <-published:
+		t.Fatal("the publisher is not held up by a full blocking queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	<-published
+	bus.Close()
+
+	assert.Len(t, got.types(), queueSize+10)
+}
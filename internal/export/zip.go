@@ -0,0 +1,164 @@
+// Package export writes the personal data of a user as a ZIP archive.
+package export
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/models"
+)
+
+// WriteZip streams the export as JSON and CSV files into a ZIP archive
+func WriteZip(w io.Writer, data models.UserExport) error {
+	zw := zip.NewWriter(w)
+
+	profile := data.Profile
+	files := []struct {
+		name    string
+		json    interface{}
+		header  []string
+		records [][]string
+	}{
+		{
+			name: "profile",
+			json: profile,
+			header: []string{"id", "passportSerie", "passportNumber", "surname", "name", "patronymic",
+				"address", "default_end_time", "timezone", "last_checked_at"},
+			records: [][]string{{
+				strconv.Itoa(profile.UUID),
+				strconv.Itoa(profile.PassportSerie),
+				strconv.Itoa(profile.PassportNumber),
+				profile.Surname,
+				profile.Name,
+				profile.Patronymic,
+				profile.Address,
+				formatTime(&profile.DefaultEndTime),
+				profile.Timezone,
+				formatTime(&profile.LastCheckedAt),
+			}},
+		},
+		{
+			name:    "time_entries",
+			json:    data.TimeEntries,
+			header:  []string{"id", "task_id", "task_name", "event_date", "start_time", "end_time"},
+			records: timeEntryRecords(data.TimeEntries),
+		},
+		{
+			name:    "tokens",
+			json:    data.Tokens,
+			header:  []string{"type", "issuer", "subject", "email", "enabled", "created_at", "used_at"},
+			records: tokenRecords(data.Tokens),
+		},
+		{
+			name:    "audit",
+			json:    data.Audit,
+			header:  []string{"id", "actor_id", "action", "entity_type", "entity_id", "before", "after", "request_id", "ip", "created_at"},
+			records: auditRecords(data.Audit),
+		},
+	}
+
+	for _, f := range files {
+		if err := writeJSON(zw, f.name+".json", f.json); err != nil {
+			return err
+		}
+		if err := writeCSV(zw, f.name+".csv", f.header, f.records); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func writeJSON(zw *zip.Writer, name string, v interface{}) error {
+	fw, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(fw)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(v)
+}
+
+func writeCSV(zw *zip.Writer, name string, header []string, records [][]string) error {
+	fw, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(fw)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	if err := cw.WriteAll(records); err != nil {
+		return err
+	}
+
+	return cw.Error()
+}
+
+func timeEntryRecords(entries []models.TimeEntryRecord) [][]string {
+	records := make([][]string, 0, len(entries))
+	for _, e := range entries {
+		records = append(records, []string{
+			strconv.Itoa(e.ID),
+			strconv.Itoa(e.TaskID),
+			e.TaskName,
+			e.EventDate,
+			e.StartTime,
+			e.EndTime,
+		})
+	}
+
+	return records
+}
+
+func tokenRecords(tokens []models.TokenMetadata) [][]string {
+	records := make([][]string, 0, len(tokens))
+	for _, t := range tokens {
+		records = append(records, []string{
+			t.Type,
+			t.Issuer,
+			t.Subject,
+			t.Email,
+			strconv.FormatBool(t.Enabled),
+			formatTime(t.CreatedAt),
+			formatTime(t.UsedAt),
+		})
+	}
+
+	return records
+}
+
+func auditRecords(events []models.AuditEvent) [][]string {
+	records := make([][]string, 0, len(events))
+	for _, e := range events {
+		records = append(records, []string{
+			strconv.FormatInt(e.ID, 10),
+			strconv.Itoa(e.ActorID),
+			e.Action,
+			e.EntityType,
+			strconv.Itoa(e.EntityID),
+			string(e.Before),
+			string(e.After),
+			e.RequestID,
+			e.IP,
+			formatTime(&e.CreatedAt),
+		})
+	}
+
+	return records
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+
+	return t.Format(time.RFC3339)
+}
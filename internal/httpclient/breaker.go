@@ -0,0 +1,137 @@
+package httpclient
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without a request while the service is considered down
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// Breaker states
+const (
+	// StateClosed lets all requests through
+	StateClosed = "closed"
+	// StateOpen rejects all requests until the cooldown has passed
+	StateOpen = "open"
+	// StateHalfOpen lets a single probe through, its outcome closes or opens the breaker again
+	StateHalfOpen = "half-open"
+)
+
+// BreakerStatus is the state of a circuit breaker
+type BreakerStatus struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	Threshold           int        `json:"threshold"`
+	Cooldown            string     `json:"cooldown"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	Failures            uint64     `json:"failures"`
+	Rejected            uint64     `json:"rejected"`
+}
+
+// breaker opens after threshold consecutive failures. Once the cooldown has passed
+// it is half-open and lets one probe through.
+type breaker struct {
+	mx        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	state    string
+	failures int // consecutive
+	openedAt time.Time
+	probing  bool
+	total    uint64
+	rejected uint64
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+		state:     StateClosed,
+	}
+}
+
+// Allow reports whether a request may be sent. A true result has to be followed by Success,
+// Failure or Abort.
+func (b *breaker) Allow() bool {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if b.state == StateOpen && b.now().Sub(b.openedAt) >= b.cooldown {
+		b.state = StateHalfOpen
+	}
+
+	switch {
+	case b.state == StateClosed:
+		return true
+	case b.state == StateHalfOpen && !b.probing:
+		b.probing = true
+		return true
+	}
+
+	b.rejected++
+	return false
+}
+
+// Success closes the breaker
+func (b *breaker) Success() {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	b.state = StateClosed
+	b.failures = 0
+	b.probing = false
+}
+
+// Failure opens the breaker when a probe fails or the failures reach the threshold
+func (b *breaker) Failure() {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	b.total++
+	b.failures++
+
+	if b.state == StateHalfOpen || b.failures >= b.threshold {
+		b.state = StateOpen
+		b.openedAt = b.now()
+	}
+	b.probing = false
+}
+
+// Abort ends a request that was allowed without an outcome
+func (b *breaker) Abort() {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	b.probing = false
+}
+
+// Status returns the state of the breaker
+func (b *breaker) Status() BreakerStatus {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	state := b.state
+	if state == StateOpen && b.now().Sub(b.openedAt) >= b.cooldown {
+		state = StateHalfOpen
+	}
+
+	status := BreakerStatus{
+		State:               state,
+		ConsecutiveFailures: b.failures,
+		Threshold:           b.threshold,
+		Cooldown:            b.cooldown.String(),
+		Failures:            b.total,
+		Rejected:            b.rejected,
+	}
+	if state != StateClosed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+
+	return status
+}
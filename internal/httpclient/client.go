@@ -0,0 +1,178 @@
+// Package httpclient is an HTTP client for unreliable services. Every attempt has
+// a timeout, failed attempts are retried with jittered exponential backoff, and a
+// circuit breaker stops calling a service that keeps failing.
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	defaultTimeout          = 5 * time.Second
+	defaultRetries          = 3
+	defaultRetryDelay       = 200 * time.Millisecond
+	defaultRetryMaxDelay    = 5 * time.Second
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+	maxResponseSize         = 1 << 20
+)
+
+type Log interface {
+	Info(string, ...zapcore.Field)
+}
+
+// Options configure the timeouts, retries and the circuit breaker
+type Options interface {
+	APISystemTimeout() string
+	APISystemRetries() string
+	APISystemRetryDelay() string
+	APISystemRetryMaxDelay() string
+	APISystemBreakerThreshold() string
+	APISystemBreakerCooldown() string
+}
+
+type Client struct {
+	http          *http.Client
+	log           Log
+	timeout       time.Duration
+	retries       int
+	retryDelay    time.Duration
+	retryMaxDelay time.Duration
+	breaker       *breaker
+	jitter        func(time.Duration) time.Duration
+}
+
+// NewClient creates a Client, invalid options are replaced by their defaults
+func NewClient(options Options, log Log) *Client {
+	threshold := parseInt(log, "API_SYSTEM_BREAKER_THRESHOLD", options.APISystemBreakerThreshold(), defaultBreakerThreshold, 1)
+
+	return &Client{
+		http:          &http.Client{},
+		log:           log,
+		timeout:       parseDuration(log, "API_SYSTEM_TIMEOUT", options.APISystemTimeout(), defaultTimeout),
+		retries:       parseInt(log, "API_SYSTEM_RETRIES", options.APISystemRetries(), defaultRetries, 0),
+		retryDelay:    parseDuration(log, "API_SYSTEM_RETRY_DELAY", options.APISystemRetryDelay(), defaultRetryDelay),
+		retryMaxDelay: parseDuration(log, "API_SYSTEM_RETRY_MAX_DELAY", options.APISystemRetryMaxDelay(), defaultRetryMaxDelay),
+		breaker:       newBreaker(threshold, parseDuration(log, "API_SYSTEM_BREAKER_COOLDOWN", options.APISystemBreakerCooldown(), defaultBreakerCooldown)),
+		jitter: func(d time.Duration) time.Duration {
+			return time.Duration(rand.Int63n(int64(d) + 1))
+		},
+	}
+}
+
+// Get requests the URL. Network errors and 5xx responses are retried, other responses are
+// returned with the body already read, so that the attempt timeout does not cut it off.
+func (c *Client) Get(ctx context.Context, url string) (*http.Response, error) {
+	var err error
+
+	for attempt := 0; ; attempt++ {
+		if !c.breaker.Allow() {
+			if err != nil {
+				// The breaker opened while retrying
+				return nil, err
+			}
+			return nil, ErrCircuitOpen
+		}
+
+		var resp *http.Response
+		resp, err = c.attempt(ctx, url)
+		if err == nil {
+			c.breaker.Success()
+			return resp, nil
+		}
+
+		// A canceled caller says nothing about the service
+		if ctx.Err() != nil {
+			c.breaker.Abort()
+			return nil, err
+		}
+		c.breaker.Failure()
+
+		if attempt == c.retries {
+			return nil, err
+		}
+
+		delay := c.backoff(attempt)
+		c.log.Info("retrying request: ", zap.Int("attempt", attempt+1), zap.Duration("delay", delay), zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// attempt sends one request within the timeout, a 5xx response is an error
+func (c *Client) attempt(ctx context.Context, url string) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("status code error: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	return resp, nil
+}
+
+// backoff is a random delay up to the exponentially growing limit of the attempt
+func (c *Client) backoff(attempt int) time.Duration {
+	limit := c.retryDelay << attempt
+	if limit > c.retryMaxDelay || limit <= 0 {
+		limit = c.retryMaxDelay
+	}
+
+	return c.jitter(limit)
+}
+
+// Status returns the state of the circuit breaker
+func (c *Client) Status() BreakerStatus {
+	return c.breaker.Status()
+}
+
+func parseDuration(log Log, name, value string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Info("invalid "+name+", using default", zap.String("value", value))
+		return fallback
+	}
+
+	return d
+}
+
+func parseInt(log Log, name, value string, fallback, min int) int {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min {
+		log.Info("invalid "+name+", using default", zap.String("value", value))
+		return fallback
+	}
+
+	return n
+}
@@ -0,0 +1,122 @@
+package httpclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type options struct {
+	timeout, retries, threshold, cooldown string
+}
+
+func (o options) APISystemTimeout() string          { return o.timeout }
+func (o options) APISystemRetries() string          { return o.retries }
+func (o options) APISystemRetryDelay() string       { return "1ms" }
+func (o options) APISystemRetryMaxDelay() string    { return "4ms" }
+func (o options) APISystemBreakerThreshold() string { return o.threshold }
+func (o options) APISystemBreakerCooldown() string  { return o.cooldown }
+
+// serve answers with the statuses in turn, repeating the last one
+func serve(t *testing.T, statuses ...int) (*httptest.Server, *int32) {
+	t.Helper()
+
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&calls, 1))
+		w.WriteHeader(statuses[min(n, len(statuses))-1])
+		_, _ = w.Write([]byte("body"))
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &calls
+}
+
+func TestClient_RetriesServerErrors(t *testing.T) {
+	srv, calls := serve(t, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK)
+	c := NewClient(options{timeout: "1s", retries: "3", threshold: "10", cooldown: "1m"}, zap.NewNop())
+
+	resp, err := c.Get(context.Background(), srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "body", string(body))
+	assert.Equal(t, int32(3), atomic.LoadInt32(calls))
+}
+
+func TestClient_DoesNotRetryClientErrors(t *testing.T) {
+	srv, calls := serve(t, http.StatusNotFound)
+	c := NewClient(options{timeout: "1s", retries: "3", threshold: "10", cooldown: "1m"}, zap.NewNop())
+
+	resp, err := c.Get(context.Background(), srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(calls))
+	assert.Equal(t, StateClosed, c.Status().State, "a client error does not count against the service")
+}
+
+func TestClient_TimesOutAttempts(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	c := NewClient(options{timeout: "20ms", retries: "1", threshold: "10", cooldown: "1m"}, zap.NewNop())
+
+	start := time.Now()
+	_, err := c.Get(context.Background(), srv.URL)
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second, "a hung service does not block the caller")
+	assert.Equal(t, 2, c.Status().ConsecutiveFailures)
+}
+
+func TestClient_CircuitBreaker(t *testing.T) {
+	srv, calls := serve(t, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusOK)
+	c := NewClient(options{timeout: "1s", retries: "0", threshold: "2", cooldown: "1m"}, zap.NewNop())
+
+	now := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	c.breaker.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		_, err := c.Get(context.Background(), srv.URL)
+		require.Error(t, err)
+	}
+	assert.Equal(t, StateOpen, c.Status().State)
+
+	_, err := c.Get(context.Background(), srv.URL)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, int32(2), atomic.LoadInt32(calls), "an open breaker sends no requests")
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, StateHalfOpen, c.Status().State)
+
+	require.True(t, c.breaker.Allow(), "one probe is let through")
+	assert.False(t, c.breaker.Allow(), "while the probe runs, other requests are rejected")
+	c.breaker.Failure()
+	assert.Equal(t, StateOpen, c.Status().State, "a failed probe opens the breaker again")
+
+	now = now.Add(time.Minute)
+	resp, err := c.Get(context.Background(), srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	status := c.Status()
+	assert.Equal(t, StateClosed, status.State, "a successful probe closes the breaker")
+	assert.Zero(t, status.ConsecutiveFailures)
+	assert.Equal(t, uint64(3), status.Failures)
+	assert.Equal(t, uint64(2), status.Rejected)
+}
@@ -0,0 +1,326 @@
+// Package jobs runs the background work kept in the database. The jobs outlive a
+// restart of the instance, and several instances sharing the database take turns
+// on them: a claimed job is locked for the visibility timeout, and is claimed again
+// once the lock expires without the job being finished.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/workerpool"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	defaultPollInterval = time.Second
+	defaultVisibility   = 5 * time.Minute
+	defaultMaxAttempts  = 5
+	defaultRetryDelay   = 10 * time.Second
+	defaultBatchSize    = 10
+
+	// maxRetryDelay bounds the backoff between the attempts of a job
+	maxRetryDelay = time.Hour
+
+	// maxErrorLength bounds the error stored with a job
+	maxErrorLength = 500
+)
+
+// Handler does the work of a job. A job is run at least once: it may run again
+// if the instance stops before the job is finished, so the work must be idempotent.
+type Handler func(ctx context.Context, job models.Job) error
+
+type Log interface {
+	Info(string, ...zapcore.Field)
+}
+
+// Store keeps the queue of jobs
+type Store interface {
+	EnqueueJob(context.Context, models.Job) (int64, error)
+	ClaimJobs(ctx context.Context, limit int, visibility time.Duration) ([]models.Job, error)
+	CompleteJob(context.Context, int64) error
+	RetryJob(ctx context.Context, id int64, attempts int, runAt time.Time, lastError string) error
+	BuryJob(context.Context, int64, string) error
+}
+
+type Pool interface {
+	Submit(ctx context.Context, task *workerpool.Task) error
+}
+
+// Options configure how often the queue is polled and how failed jobs are retried
+type Options interface {
+	JobPollInterval() string
+	JobVisibilityTimeout() string
+	JobMaxAttempts() string
+	JobRetryDelay() string
+	JobBatchSize() string
+}
+
+// Runner claims the due jobs and runs them on the worker pool as tasks of the kind
+// of the job, so that the limits of the pool apply to them
+type Runner struct {
+	store Store
+	pool  Pool
+	log   Log
+
+	pollInterval time.Duration
+	visibility   time.Duration
+	maxAttempts  int
+	retryDelay   time.Duration
+	batchSize    int
+	now          func() time.Time
+
+	hmx      sync.RWMutex
+	handlers map[string]Handler
+
+	// running counts the claimed jobs that are not finished yet
+	rmx     sync.Mutex
+	running int
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewRunner creates a runner from the options. Invalid options are logged and replaced with the defaults.
+func NewRunner(store Store, pool Pool, log Log, option Options) *Runner {
+	pollInterval, err := time.ParseDuration(option.JobPollInterval())
+	if err != nil || pollInterval <= 0 {
+		log.Info("invalid JOB_POLL_INTERVAL, using the default: ", zap.Duration("default", defaultPollInterval))
+		pollInterval = defaultPollInterval
+	}
+
+	visibility, err := time.ParseDuration(option.JobVisibilityTimeout())
+	if err != nil || visibility <= 0 {
+		log.Info("invalid JOB_VISIBILITY_TIMEOUT, using the default: ", zap.Duration("default", defaultVisibility))
+		visibility = defaultVisibility
+	}
+
+	maxAttempts, err := strconv.Atoi(option.JobMaxAttempts())
+	if err != nil || maxAttempts < 1 {
+		log.Info("invalid JOB_MAX_ATTEMPTS, using the default: ", zap.Int("default", defaultMaxAttempts))
+		maxAttempts = defaultMaxAttempts
+	}
+
+	retryDelay, err := time.ParseDuration(option.JobRetryDelay())
+	if err != nil || retryDelay <= 0 {
+		log.Info("invalid JOB_RETRY_DELAY, using the default: ", zap.Duration("default", defaultRetryDelay))
+		retryDelay = defaultRetryDelay
+	}
+
+	batchSize, err := strconv.Atoi(option.JobBatchSize())
+	if err != nil || batchSize < 1 {
+		log.Info("invalid JOB_BATCH_SIZE, using the default: ", zap.Int("default", defaultBatchSize))
+		batchSize = defaultBatchSize
+	}
+
+	return &Runner{
+		store:        store,
+		pool:         pool,
+		log:          log,
+		pollInterval: pollInterval,
+		visibility:   visibility,
+		maxAttempts:  maxAttempts,
+		retryDelay:   retryDelay,
+		batchSize:    batchSize,
+		now:          time.Now,
+		handlers:     make(map[string]Handler),
+	}
+}
+
+// Handle registers the handler of the jobs of the kind
+func (r *Runner) Handle(kind string, handler Handler) {
+	r.hmx.Lock()
+	defer r.hmx.Unlock()
+
+	r.handlers[kind] = handler
+}
+
+// Enqueue adds a job of the kind with the payload encoded as JSON, to run at runAt
+// or right away if it is zero. A non-empty key is queued once until the job is
+// done, a duplicate returns storage.ErrConflict.
+func (r *Runner) Enqueue(ctx context.Context, kind, key string, payload any, runAt time.Time) (int64, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode job payload: %w", err)
+	}
+
+	return r.store.EnqueueJob(ctx, models.Job{Kind: kind, Key: key, Payload: raw, RunAt: runAt})
+}
+
+// Start polls the queue until Stop is called
+func (r *Runner) Start(ctx context.Context) {
+	ctx, r.cancel = context.WithCancel(ctx)
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		t := time.NewTicker(r.pollInterval)
+		defer t.Stop()
+
+		for {
+			r.poll(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+// Stop stops claiming jobs. The jobs submitted to the pool finish with it, the
+// ones it cancels are put back in the queue.
+func (r *Runner) Stop() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+// poll claims the due jobs the runner has room for and submits them to the pool,
+// waiting for room in its queue
+func (r *Runner) poll(ctx context.Context) {
+	limit := r.batchSize - r.runningCount()
+	if limit <= 0 {
+		return
+	}
+
+	jobs, err := r.store.ClaimJobs(ctx, limit, r.visibility)
+	if err != nil {
+		if ctx.Err() == nil {
+			r.log.Info("failed to claim jobs: ", zap.Error(err))
+		}
+		return
+	}
+
+	for i, job := range jobs {
+		if err := r.submit(ctx, job); err != nil {
+			// Neither this job nor the rest of the batch ran, so the claim doesn't count
+			r.log.Info("failed to submit jobs, putting them back: ", zap.Error(err))
+			for _, job := range jobs[i:] {
+				r.release(job)
+			}
+			return
+		}
+	}
+}
+
+func (r *Runner) submit(ctx context.Context, job models.Job) error {
+	r.hmx.RLock()
+	handler, ok := r.handlers[job.Kind]
+	r.hmx.RUnlock()
+
+	switch {
+	case !ok:
+		r.bury(job, "no handler for the job kind")
+		return nil
+	case job.Attempts > r.maxAttempts:
+		// The job was claimed again after its lock expired more times than it may fail
+		r.bury(job, "the job was abandoned too many times")
+		return nil
+	}
+
+	task := workerpool.NewTask(job.Kind, func(ctx context.Context) (any, error) {
+		// The job is not run past its lock, when another instance may claim it
+		ctx, cancel := context.WithTimeout(ctx, r.visibility)
+		defer cancel()
+
+		return nil, handler(ctx, job)
+	}).OnDone(func(_ any, err error) {
+		defer r.done()
+		r.finish(job, err)
+	})
+
+	r.rmx.Lock()
+	r.running++
+	r.rmx.Unlock()
+
+	if err := r.pool.Submit(ctx, task); err != nil {
+		r.done()
+		return err
+	}
+
+	return nil
+}
+
+// finish completes the job, or retries it with an exponential backoff until it
+// runs out of attempts
+func (r *Runner) finish(job models.Job, err error) {
+	// The bookkeeping outlives the shutdown of the pool
+	ctx := context.Background()
+
+	switch {
+	case err == nil:
+		if err := r.store.CompleteJob(ctx, job.ID); err != nil {
+			r.log.Info("failed to complete job: ", zap.Int64("id", job.ID), zap.Error(err))
+		}
+	case errors.Is(err, context.Canceled):
+		// The pool was shut down before the job could finish
+		r.release(job)
+	case job.Attempts >= r.maxAttempts:
+		r.log.Info("job moved to dead letters: ", zap.Int64("id", job.ID), zap.String("kind", job.Kind), zap.Error(err))
+		r.bury(job, err.Error())
+	default:
+		runAt := r.now().Add(r.retryBackoff(job.Attempts))
+		if err := r.store.RetryJob(ctx, job.ID, job.Attempts, runAt, truncateError(err.Error())); err != nil {
+			r.log.Info("failed to retry job: ", zap.Int64("id", job.ID), zap.Error(err))
+		}
+	}
+}
+
+// release puts back a job that didn't run, without counting the attempt
+func (r *Runner) release(job models.Job) {
+	if err := r.store.RetryJob(context.Background(), job.ID, job.Attempts-1, r.now(), job.LastError); err != nil {
+		r.log.Info("failed to release job: ", zap.Int64("id", job.ID), zap.Error(err))
+	}
+}
+
+func (r *Runner) bury(job models.Job, cause string) {
+	if err := r.store.BuryJob(context.Background(), job.ID, truncateError(cause)); err != nil {
+		r.log.Info("failed to bury job: ", zap.Int64("id", job.ID), zap.Error(err))
+	}
+}
+
+func (r *Runner) done() {
+	r.rmx.Lock()
+	defer r.rmx.Unlock()
+
+	r.running--
+}
+
+func (r *Runner) runningCount() int {
+	r.rmx.Lock()
+	defer r.rmx.Unlock()
+
+	return r.running
+}
+
+// retryBackoff doubles the retry delay with every failed attempt, up to an hour
+func (r *Runner) retryBackoff(attempts int) time.Duration {
+	delay := r.retryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, maxRetryDelay)
+}
+
+func truncateError(s string) string {
+	if len(s) <= maxErrorLength {
+		return s
+	}
+
+	// Cut at a rune boundary
+	cut := maxErrorLength
+	for cut > 0 && s[cut]&0xC0 == 0x80 {
+		cut--
+	}
+	return s[:cut] + "..."
+}
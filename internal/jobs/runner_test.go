@@ -0,0 +1,156 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wurt83ow/timetracker/internal/encryption"
+	"github.com/wurt83ow/timetracker/internal/memkeeper"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"github.com/wurt83ow/timetracker/internal/workerpool"
+	"go.uber.org/zap"
+)
+
+type options struct{}
+
+func (options) JobPollInterval() string      { return "5ms" }
+func (options) JobVisibilityTimeout() string { return "1m" }
+func (options) JobMaxAttempts() string       { return "3" }
+func (options) JobRetryDelay() string        { return "1ms" }
+func (options) JobBatchSize() string         { return "10" }
+
+func (options) Concurrency() string           { return "2" }
+func (options) WorkerQueueSize() string       { return "10" }
+func (options) WorkerPriorities() string      { return "" }
+func (options) WorkerKindConcurrency() string { return "" }
+func (options) WorkerRateLimits() string      { return "" }
+
+// store records the finished jobs of a MemKeeper
+type store struct {
+	*memkeeper.MemKeeper
+
+	mx        sync.Mutex
+	completed []int64
+	buried    map[int64]string
+}
+
+func (s *store) CompleteJob(ctx context.Context, id int64) error {
+	s.mx.Lock()
+	s.completed = append(s.completed, id)
+	s.mx.Unlock()
+	return s.MemKeeper.CompleteJob(ctx, id)
+}
+
+func (s *store) BuryJob(ctx context.Context, id int64, lastError string) error {
+	s.mx.Lock()
+	s.buried[id] = lastError
+	s.mx.Unlock()
+	return s.MemKeeper.BuryJob(ctx, id, lastError)
+}
+
+func (s *store) finished() int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return len(s.completed) + len(s.buried)
+}
+
+func newRunner(t *testing.T) (*Runner, *store, *workerpool.Pool) {
+	envelope, err := encryption.NewEnvelope("test_encryption_key")
+	require.NoError(t, err)
+
+	s := &store{
+		MemKeeper: memkeeper.NewMemKeeper(func() string { return "" }, zap.NewNop(), func() string { return "5m" }, envelope),
+		buried:    make(map[int64]string),
+	}
+	pool := workerpool.NewPool(options{}, zap.NewNop())
+
+	return NewRunner(s, pool, zap.NewNop(), options{}), s, pool
+}
+
+func TestRunner_RunsRetriesAndBuries(t *testing.T) {
+	r, s, pool := newRunner(t)
+	ctx := context.Background()
+
+	var mx sync.Mutex
+	payloads := make([]string, 0)
+	runs := 0
+	r.Handle("report", func(_ context.Context, job models.Job) error {
+		mx.Lock()
+		defer mx.Unlock()
+		payloads = append(payloads, string(job.Payload))
+		return nil
+	})
+	r.Handle("flaky", func(context.Context, models.Job) error {
+		mx.Lock()
+		defer mx.Unlock()
+		runs++
+		return errors.New("unavailable")
+	})
+
+	reportID, err := r.Enqueue(ctx, "report", "report:1", map[string]int{"user_id": 1}, time.Time{})
+	require.NoError(t, err)
+	_, err = r.Enqueue(ctx, "report", "report:1", map[string]int{"user_id": 1}, time.Time{})
+	assert.ErrorIs(t, err, storage.ErrConflict, "the pending job is queued once")
+
+	flakyID, err := r.Enqueue(ctx, "flaky", "", nil, time.Time{})
+	require.NoError(t, err)
+	orphanID, err := r.Enqueue(ctx, "unknown", "", nil, time.Time{})
+	require.NoError(t, err)
+
+	pool.Start()
+	r.Start(ctx)
+	require.Eventually(t, func() bool { return s.finished() == 3 }, time.Second, 5*time.Millisecond)
+	r.Stop()
+	require.NoError(t, pool.Shutdown(ctx))
+
+	assert.Equal(t, []int64{reportID}, s.completed)
+	assert.Equal(t, []string{`{"user_id":1}`}, payloads)
+	assert.Equal(t, 3, runs, "the failed job runs until it is out of attempts")
+	assert.Equal(t, map[int64]string{flakyID: "unavailable", orphanID: "no handler for the job kind"}, s.buried)
+}
+
+func TestRunner_ReleasesCancelledJobs(t *testing.T) {
+	r, s, pool := newRunner(t)
+	ctx := context.Background()
+
+	started := make(chan struct{})
+	r.Handle("report", func(ctx context.Context, _ models.Job) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	id, err := r.Enqueue(ctx, "report", "", nil, time.Time{})
+	require.NoError(t, err)
+
+	pool.Start()
+	r.Start(ctx)
+	<-started
+	r.Stop()
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, pool.Shutdown(shutdownCtx), context.DeadlineExceeded)
+
+	// The job is back in the queue, and the cancelled run didn't count
+	jobs, err := s.ClaimJobs(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, id, jobs[0].ID)
+	assert.Equal(t, 1, jobs[0].Attempts)
+	assert.Zero(t, s.finished())
+}
+
+func TestRunner_RetryBackoff(t *testing.T) {
+	r := &Runner{retryDelay: 10 * time.Minute}
+
+	assert.Equal(t, 10*time.Minute, r.retryBackoff(1))
+	assert.Equal(t, 40*time.Minute, r.retryBackoff(3))
+	assert.Equal(t, time.Hour, r.retryBackoff(10))
+}
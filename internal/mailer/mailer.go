@@ -0,0 +1,217 @@
+// Package mailer sends email over SMTP.
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// TLS modes of the connection to the SMTP server
+const (
+	// TLSStartTLS upgrades the connection with STARTTLS and fails if the server doesn't offer it
+	TLSStartTLS = "starttls"
+	// TLSImplicit connects over TLS, usually to port 465
+	TLSImplicit = "tls"
+	// TLSNone sends the email in plain text, e.g. to a relay on localhost
+	TLSNone = "none"
+)
+
+const defaultTimeout = 30 * time.Second
+
+// ErrNotConfigured is returned by Send when no SMTP server is configured
+var ErrNotConfigured = errors.New("SMTP server is not configured")
+
+type Log interface {
+	Info(string, ...zapcore.Field)
+}
+
+// Options configure the SMTP server and the sender of the email
+type Options interface {
+	SMTPAddress() string
+	SMTPUsername() string
+	SMTPPassword() string
+	SMTPFrom() string
+	SMTPTLS() string
+	SMTPTimeout() string
+}
+
+// Attachment is a file attached to a message
+type Attachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// Message is an HTML email
+type Message struct {
+	To          []string
+	Subject     string
+	HTML        string
+	Attachments []Attachment
+}
+
+// Client sends each message over a connection of its own
+type Client struct {
+	address  string
+	username string
+	password string
+	from     string
+	tls      string
+	timeout  time.Duration
+}
+
+// NewClient creates a client from the options. Invalid options are logged and replaced with the defaults.
+func NewClient(option Options, log Log) *Client {
+	mode := option.SMTPTLS()
+	switch mode {
+	case TLSStartTLS, TLSImplicit, TLSNone:
+	default:
+		log.Info("invalid SMTP_TLS, using the default: ", zap.String("default", TLSStartTLS))
+		mode = TLSStartTLS
+	}
+
+	timeout, err := time.ParseDuration(option.SMTPTimeout())
+	if err != nil || timeout <= 0 {
+		log.Info("invalid SMTP_TIMEOUT, using the default: ", zap.Duration("default", defaultTimeout))
+		timeout = defaultTimeout
+	}
+
+	return &Client{
+		address:  option.SMTPAddress(),
+		username: option.SMTPUsername(),
+		password: option.SMTPPassword(),
+		from:     option.SMTPFrom(),
+		tls:      mode,
+		timeout:  timeout,
+	}
+}
+
+// Send delivers the message to the SMTP server. ctx bounds the whole session
+// together with the timeout of the client.
+func (c *Client) Send(ctx context.Context, msg Message) error {
+	if c.address == "" {
+		return ErrNotConfigured
+	}
+	if len(msg.To) == 0 {
+		return errors.New("the message has no recipients")
+	}
+
+	from, err := mail.ParseAddress(c.from)
+	if err != nil {
+		return fmt.Errorf("invalid sender address: %w", err)
+	}
+
+	data, err := build(from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+
+	// The envelope takes the bare addresses
+	rcpts := make([]string, 0, len(msg.To))
+	for _, to := range msg.To {
+		addr, err := mail.ParseAddress(to)
+		if err != nil {
+			return fmt.Errorf("invalid recipient address %q: %w", to, err)
+		}
+		rcpts = append(rcpts, addr.Address)
+	}
+
+	host, _, err := net.SplitHostPort(c.address)
+	if err != nil {
+		return fmt.Errorf("invalid SMTP address: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	conn, err := c.dial(ctx, host)
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+
+	// The SMTP client has no context, so the connection is closed when ctx is done
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if err := c.session(conn, host, from.Address, rcpts, data); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("SMTP session interrupted: %w", ctx.Err())
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (c *Client) dial(ctx context.Context, host string) (net.Conn, error) {
+	if c.tls == TLSImplicit {
+		dialer := &tls.Dialer{Config: c.tlsConfig(host)}
+		return dialer.DialContext(ctx, "tcp", c.address)
+	}
+
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", c.address)
+}
+
+// session runs the SMTP dialog of a single message
+func (c *Client) session(conn net.Conn, host, from string, to []string, data []byte) error {
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to greet SMTP server: %w", err)
+	}
+	defer client.Close()
+
+	if c.tls == TLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("SMTP server doesn't support STARTTLS")
+		}
+		if err := client.StartTLS(c.tlsConfig(host)); err != nil {
+			return fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+
+	if c.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", c.username, c.password, host)); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return fmt.Errorf("SMTP server rejected the sender: %w", err)
+	}
+	for _, rcpt := range to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("SMTP server rejected a recipient: %w", err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP server rejected the message: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("failed to send the message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("SMTP server rejected the message: %w", err)
+	}
+
+	return client.Quit()
+}
+
+func (c *Client) tlsConfig(host string) *tls.Config {
+	return &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+}
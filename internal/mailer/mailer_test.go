@@ -0,0 +1,97 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wurt83ow/timetracker/internal/mailer/smtptest"
+	"go.uber.org/zap"
+)
+
+type options struct {
+	address, username, password string
+}
+
+func (o options) SMTPAddress() string  { return o.address }
+func (o options) SMTPUsername() string { return o.username }
+func (o options) SMTPPassword() string { return o.password }
+func (o options) SMTPFrom() string     { return "TimeTracker <reports@example.com>" }
+func (o options) SMTPTLS() string      { return TLSNone }
+func (o options) SMTPTimeout() string  { return "5s" }
+
+func TestClient_SendsHTMLWithAttachments(t *testing.T) {
+	server := smtptest.NewServer()
+	defer server.Close()
+	server.RequireAuth("reports", "secret")
+
+	client := NewClient(options{address: server.Addr(), username: "reports", password: "secret"}, zap.NewNop())
+
+	err := client.Send(context.Background(), Message{
+		To:      []string{"Manager <manager@example.com>", "lead@example.com"},
+		Subject: "Отчёт за неделю",
+		HTML:    "<p>Часы за неделю</p>",
+		Attachments: []Attachment{
+			{Name: "hours.csv", ContentType: "text/csv", Data: []byte("user,hours\nIvanov,40\n")},
+		},
+	})
+	require.NoError(t, err)
+
+	messages := server.Messages()
+	require.Len(t, messages, 1)
+	assert.Equal(t, "reports@example.com", messages[0].From)
+	assert.Equal(t, []string{"manager@example.com", "lead@example.com"}, messages[0].To)
+
+	msg, err := mail.ReadMessage(bytes.NewReader(messages[0].Data))
+	require.NoError(t, err)
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	require.NoError(t, err)
+	assert.Equal(t, "Отчёт за неделю", subject)
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/mixed", mediaType)
+
+	// The multipart reader decodes quoted-printable, base64 is left to the reader
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	html, err := reader.NextPart()
+	require.NoError(t, err)
+	body, err := io.ReadAll(html)
+	require.NoError(t, err)
+	assert.Equal(t, "<p>Часы за неделю</p>", string(body))
+
+	attachment, err := reader.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "hours.csv", attachment.FileName())
+	assert.Equal(t, "base64", attachment.Header.Get("Content-Transfer-Encoding"))
+}
+
+func TestClient_Failures(t *testing.T) {
+	server := smtptest.NewServer()
+	defer server.Close()
+	server.RequireAuth("reports", "secret")
+
+	msg := Message{To: []string{"manager@example.com"}, Subject: "Report", HTML: "<p>Report</p>"}
+
+	wrongPassword := NewClient(options{address: server.Addr(), username: "reports", password: "wrong"}, zap.NewNop())
+	assert.ErrorContains(t, wrongPassword.Send(context.Background(), msg), "authentication failed")
+
+	client := NewClient(options{address: server.Addr(), username: "reports", password: "secret"}, zap.NewNop())
+	server.Reject("554 5.7.1 rejected")
+	assert.ErrorContains(t, client.Send(context.Background(), msg), "rejected the message")
+
+	invalid := Message{To: []string{"not an address"}, Subject: "Report"}
+	assert.ErrorContains(t, client.Send(context.Background(), invalid), "invalid recipient")
+
+	unconfigured := NewClient(options{}, zap.NewNop())
+	assert.ErrorIs(t, unconfigured.Send(context.Background(), msg), ErrNotConfigured)
+
+	assert.Empty(t, server.Messages())
+}
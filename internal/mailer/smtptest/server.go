@@ -0,0 +1,223 @@
+// Package smtptest provides a minimal in-process SMTP server for tests.
+package smtptest
+
+import (
+	"encoding/base64"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+// Message is an email received by the server
+type Message struct {
+	From string
+	To   []string
+	Data []byte
+}
+
+// Server accepts plain text SMTP sessions on localhost and keeps the received messages
+type Server struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mx       sync.Mutex
+	username string
+	password string
+	messages []Message
+	reject   string
+}
+
+// NewServer starts a server on a random port of localhost
+func NewServer() *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+
+	s := &Server{listener: listener}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.serve(conn)
+			}()
+		}
+	}()
+
+	return s
+}
+
+// Addr returns the host:port the server listens on
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops the server and waits for the open sessions to end
+func (s *Server) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+// Messages returns the messages received so far
+func (s *Server) Messages() []Message {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return append([]Message(nil), s.messages...)
+}
+
+// RequireAuth makes the clients authenticate with AUTH PLAIN before sending mail
+func (s *Server) RequireAuth(username, password string) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.username, s.password = username, password
+}
+
+// Reject makes the server answer the end of the message data with the reply, e.g.
+// "554 5.7.1 rejected"; an empty reply accepts the messages again
+func (s *Server) Reject(reply string) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.reject = reply
+}
+
+// serve runs the SMTP dialog of a connection
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	reply := func(line string) bool {
+		return tp.PrintfLine("%s", line) == nil
+	}
+
+	if !reply("220 smtptest ESMTP ready") {
+		return
+	}
+
+	s.mx.Lock()
+	username, password := s.username, s.password
+	s.mx.Unlock()
+
+	var msg Message
+	authenticated := username == ""
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			if !reply("250-smtptest") || !reply("250-8BITMIME") || !reply("250 AUTH PLAIN") {
+				return
+			}
+		case "HELO", "NOOP":
+			reply("250 OK")
+		case "RSET":
+			msg = Message{}
+			reply("250 OK")
+		case "AUTH":
+			authenticated = auth(tp, arg, username, password)
+			if authenticated {
+				reply("235 2.7.0 Authentication successful")
+			} else {
+				reply("535 5.7.8 Authentication credentials invalid")
+			}
+		case "MAIL":
+			if !authenticated {
+				reply("530 5.7.0 Authentication required")
+				continue
+			}
+			msg = Message{From: address(arg)}
+			reply("250 OK")
+		case "RCPT":
+			msg.To = append(msg.To, address(arg))
+			reply("250 OK")
+		case "DATA":
+			if msg.From == "" || len(msg.To) == 0 {
+				reply("503 5.5.1 Bad sequence of commands")
+				continue
+			}
+			if !reply("354 End data with <CR><LF>.<CR><LF>") {
+				return
+			}
+
+			data, err := io.ReadAll(tp.DotReader())
+			if err != nil {
+				return
+			}
+			msg.Data = data
+
+			reply(s.receive(msg))
+			msg = Message{}
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 5.5.2 Command not implemented")
+		}
+	}
+}
+
+// auth checks the AUTH PLAIN credentials, sent with the command or after a challenge
+func auth(tp *textproto.Conn, arg, username, password string) bool {
+	mechanism, initial, _ := strings.Cut(arg, " ")
+	if !strings.EqualFold(mechanism, "PLAIN") {
+		return false
+	}
+
+	if initial == "" {
+		if tp.PrintfLine("334 ") != nil {
+			return false
+		}
+		line, err := tp.ReadLine()
+		if err != nil {
+			return false
+		}
+		initial = line
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(initial)
+	if err != nil {
+		return false
+	}
+
+	// authzid NUL authcid NUL passwd
+	parts := strings.Split(string(decoded), "\x00")
+	return len(parts) == 3 && parts[1] == username && parts[2] == password
+}
+
+// receive keeps the message unless the server rejects the messages
+func (s *Server) receive(msg Message) string {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.reject != "" {
+		return s.reject
+	}
+
+	s.messages = append(s.messages, msg)
+	return "250 OK: queued"
+}
+
+// address extracts the address of a "FROM:<a@b>" or "TO:<a@b>" argument
+func address(arg string) string {
+	_, value, _ := strings.Cut(arg, ":")
+	value, _, _ = strings.Cut(strings.TrimSpace(value), " ")
+	return strings.Trim(value, "<>")
+}
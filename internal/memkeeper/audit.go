@@ -0,0 +1,83 @@
+package memkeeper
+
+import (
+	"context"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/audit"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"go.uber.org/zap"
+)
+
+// recordAudit appends an audit event. It is called with the write lock held,
+// so the event is recorded together with the mutation it describes.
+func (kp *MemKeeper) recordAudit(ctx context.Context, action, entityType string, entityID int,
+	before, after map[string]interface{}, sensitive ...string,
+) {
+	kp.publish(entityType, entityID)
+
+	beforeJSON, afterJSON, err := audit.Diff(before, after, sensitive...)
+	if err != nil {
+		// Snapshots only hold plain values, so this cannot happen
+		kp.log.Info("error encoding audit event: ", zap.Error(err))
+		return
+	}
+
+	meta := audit.FromContext(ctx)
+
+	kp.data.LastAuditID++
+	kp.data.Audit = append(kp.data.Audit, models.AuditEvent{
+		ID:         kp.data.LastAuditID,
+		ActorID:    meta.ActorID,
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Before:     beforeJSON,
+		After:      afterJSON,
+		RequestID:  meta.RequestID,
+		IP:         meta.IP,
+		CreatedAt:  time.Now(),
+	})
+}
+
+// GetAuditEvents returns audit events matching the filter, newest first
+func (kp *MemKeeper) GetAuditEvents(ctx context.Context, filter models.AuditFilter, limit int) ([]models.AuditEvent, error) {
+	kp.mx.RLock()
+	defer kp.mx.RUnlock()
+
+	events := make([]models.AuditEvent, 0)
+	for i := len(kp.data.Audit) - 1; i >= 0 && len(events) < limit; i-- {
+		if e := kp.data.Audit[i]; matchAudit(e, filter) {
+			events = append(events, e)
+		}
+	}
+
+	return events, nil
+}
+
+// exportAudit returns the audit events performed by or about a user
+func (kp *MemKeeper) exportAudit(userID int) []models.AuditEvent {
+	events := make([]models.AuditEvent, 0)
+	for _, e := range kp.data.Audit {
+		if e.ActorID == userID || (e.EntityType == audit.EntityUser && e.EntityID == userID) {
+			events = append(events, e)
+		}
+	}
+
+	return events
+}
+
+func matchAudit(e models.AuditEvent, filter models.AuditFilter) bool {
+	switch {
+	case filter.ActorID != nil && e.ActorID != *filter.ActorID,
+		filter.Action != nil && e.Action != *filter.Action,
+		filter.EntityType != nil && e.EntityType != *filter.EntityType,
+		filter.EntityID != nil && e.EntityID != *filter.EntityID,
+		filter.From != nil && e.CreatedAt.Before(*filter.From),
+		filter.To != nil && !e.CreatedAt.Before(*filter.To),
+		filter.BeforeID > 0 && e.ID >= filter.BeforeID:
+		return false
+	}
+
+	return true
+}
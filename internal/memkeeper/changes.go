@@ -0,0 +1,64 @@
+package memkeeper
+
+import (
+	"context"
+	"errors"
+
+	"github.com/wurt83ow/timetracker/internal/audit"
+	"github.com/wurt83ow/timetracker/internal/storage"
+)
+
+// listenerBuffer is the number of changes a listener may fall behind by
+const listenerBuffer = 1024
+
+// errListenerBehind is returned by Listen when changes had to be dropped
+var errListenerBehind = errors.New("change listener fell behind")
+
+// Listen applies the changes of users and tasks made through this keeper until ctx is done.
+// It lets several storages share one MemKeeper as instances share a database.
+func (kp *MemKeeper) Listen(ctx context.Context, ready func(), apply func(storage.Change)) error {
+	changes := make(chan storage.Change, listenerBuffer)
+
+	kp.mx.Lock()
+	kp.listeners[changes] = struct{}{}
+	kp.mx.Unlock()
+
+	defer func() {
+		kp.mx.Lock()
+		delete(kp.listeners, changes)
+		kp.mx.Unlock()
+	}()
+
+	ready()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case change, ok := <-changes:
+			if !ok {
+				return errListenerBehind
+			}
+			apply(change)
+		}
+	}
+}
+
+// publish sends a change to the listeners. It is called with the write lock held, so the
+// changes arrive in the order they were made. A listener that is too far behind is dropped
+// rather than blocking the mutation, it resyncs when it listens again.
+func (kp *MemKeeper) publish(entityType string, entityID int) {
+	if entityType != audit.EntityUser && entityType != audit.EntityTask {
+		return
+	}
+
+	change := storage.Change{Entity: entityType, ID: entityID}
+	for listener := range kp.listeners {
+		select {
+		case listener <- change:
+		default:
+			close(listener)
+			delete(kp.listeners, listener)
+		}
+	}
+}
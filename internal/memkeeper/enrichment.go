@@ -0,0 +1,151 @@
+package memkeeper
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/audit"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+)
+
+// GetEnrichmentAttempt retrieves the enrichment state of a user
+func (kp *MemKeeper) GetEnrichmentAttempt(ctx context.Context, userID int) (models.EnrichmentAttempt, error) {
+	kp.mx.RLock()
+	defer kp.mx.RUnlock()
+
+	a, ok := kp.data.Enrichment[userID]
+	if !ok {
+		return models.EnrichmentAttempt{}, storage.ErrNotFound
+	}
+
+	return a, nil
+}
+
+// SaveEnrichmentAttempts creates or replaces the enrichment states, skipping deleted users.
+// The attempt ends the enrichment lease of the user.
+func (kp *MemKeeper) SaveEnrichmentAttempts(ctx context.Context, attempts []models.EnrichmentAttempt) error {
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	for _, a := range attempts {
+		u, ok := kp.data.Users[a.UserID]
+		if !ok {
+			continue
+		}
+		kp.data.Enrichment[a.UserID] = a
+
+		u.LeasedUntil = time.Time{}
+		kp.data.Users[a.UserID] = u
+	}
+
+	return nil
+}
+
+// GetEnrichmentAttempts lists the enrichment states with the statuses, or all of them,
+// most recently updated first
+func (kp *MemKeeper) GetEnrichmentAttempts(ctx context.Context, statuses []string, limit int) ([]models.EnrichmentAttempt, error) {
+	kp.mx.RLock()
+	defer kp.mx.RUnlock()
+
+	attempts := make([]models.EnrichmentAttempt, 0)
+	for _, a := range kp.data.Enrichment {
+		if len(statuses) == 0 || contains(statuses, a.Status) {
+			attempts = append(attempts, a)
+		}
+	}
+
+	sort.Slice(attempts, func(i, j int) bool {
+		if !attempts[i].UpdatedAt.Equal(attempts[j].UpdatedAt) {
+			return attempts[i].UpdatedAt.After(attempts[j].UpdatedAt)
+		}
+		return attempts[i].UserID < attempts[j].UserID
+	})
+
+	if len(attempts) > limit {
+		attempts = attempts[:limit]
+	}
+
+	return attempts, nil
+}
+
+// CountEnrichmentAttempts counts the enrichment states by status
+func (kp *MemKeeper) CountEnrichmentAttempts(ctx context.Context) (map[string]int, error) {
+	kp.mx.RLock()
+	defer kp.mx.RUnlock()
+
+	counts := make(map[string]int)
+	for _, a := range kp.data.Enrichment {
+		counts[a.Status]++
+	}
+
+	return counts, nil
+}
+
+// ResyncUser forgets the enrichment state of a user, so that the next pass enriches it
+func (kp *MemKeeper) ResyncUser(ctx context.Context, userID int) error {
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	u, ok := kp.data.Users[userID]
+	if !ok || u.ErasedAt != nil {
+		return storage.ErrNotFound
+	}
+
+	u.LastCheckedAt = time.Time{}
+	kp.data.Users[userID] = u
+	delete(kp.data.Enrichment, userID)
+
+	kp.recordAudit(ctx, audit.ActionResync, audit.EntityUser, userID, nil, nil)
+	return nil
+}
+
+// ClaimUser leases a user for enrichment regardless of when it was checked, e.g.
+// right after it registered. A user leased already is a conflict.
+func (kp *MemKeeper) ClaimUser(ctx context.Context, userID int, lease time.Duration) (models.ExtUserData, error) {
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	u, ok := kp.data.Users[userID]
+	if !ok || u.ErasedAt != nil {
+		return models.ExtUserData{}, storage.ErrNotFound
+	}
+
+	now := time.Now()
+	if u.LeasedUntil.After(now) {
+		return models.ExtUserData{}, storage.ErrConflict
+	}
+
+	u.LeasedUntil = now.Add(lease)
+	kp.data.Users[userID] = u
+
+	return models.ExtUserData{
+		UserID:         userID,
+		PassportSerie:  u.PassportSerie,
+		PassportNumber: u.PassportNumber,
+		Surname:        u.Surname,
+		Name:           u.Name,
+		Address:        u.Address,
+	}, nil
+}
+
+// isEnrichable reports whether the enrichment state lets a user be enriched at the time
+func isEnrichable(a models.EnrichmentAttempt, ok bool, now time.Time) bool {
+	switch {
+	case !ok || a.Status == models.EnrichmentSucceeded:
+		return true
+	case a.Status == models.EnrichmentFailed:
+		return a.NextRetryAt == nil || !a.NextRetryAt.After(now)
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
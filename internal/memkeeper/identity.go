@@ -0,0 +1,52 @@
+package memkeeper
+
+import (
+	"context"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/audit"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap"
+)
+
+// GetUserByIdentity retrieves the user linked to an identity provider subject
+func (kp *MemKeeper) GetUserByIdentity(ctx context.Context, issuer, subject string) (models.User, error) {
+	kp.mx.RLock()
+	defer kp.mx.RUnlock()
+
+	for _, identity := range kp.data.Identities {
+		if identity.Issuer == issuer && identity.Subject == subject {
+			if u, ok := kp.data.Users[identity.UserID]; ok {
+				return u.model(), nil
+			}
+		}
+	}
+
+	return models.User{}, storage.ErrNotFound
+}
+
+// LinkIdentity links an identity provider subject to a user
+func (kp *MemKeeper) LinkIdentity(ctx context.Context, identity models.UserIdentity) error {
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	identity.ID = 1
+	for _, linked := range kp.data.Identities {
+		if linked.Issuer == identity.Issuer && linked.Subject == identity.Subject {
+			return storage.ErrConflict
+		}
+		if linked.ID >= identity.ID {
+			identity.ID = linked.ID + 1
+		}
+	}
+
+	identity.CreatedAt = time.Now()
+	kp.data.Identities = append(kp.data.Identities, identity)
+
+	kp.recordAudit(ctx, audit.ActionLink, audit.EntityIdentity, identity.UserID, nil,
+		map[string]interface{}{"issuer": identity.Issuer, "subject": identity.Subject, "email": identity.Email}, "email")
+
+	kp.log.Info("Identity linked successfully: ", zap.Int("userID", identity.UserID), zap.String("issuer", identity.Issuer))
+	return nil
+}
@@ -0,0 +1,132 @@
+package memkeeper
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+)
+
+// EnqueueJob adds a job to the queue, to run once RunAt has come. A job with the
+// Key of a job that is not done yet is a conflict.
+func (kp *MemKeeper) EnqueueJob(ctx context.Context, job models.Job) (int64, error) {
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	if job.Key != "" {
+		for _, j := range kp.data.Jobs {
+			if j.Key == job.Key && j.Status != models.JobDead {
+				return 0, storage.ErrConflict
+			}
+		}
+	}
+
+	now := time.Now()
+	if job.RunAt.IsZero() {
+		job.RunAt = now
+	}
+	if len(job.Payload) == 0 {
+		job.Payload = []byte("{}")
+	}
+
+	kp.data.LastJobID++
+	job.ID = kp.data.LastJobID
+	job.Status = models.JobQueued
+	job.Attempts = 0
+	job.LockedUntil = nil
+	job.LastError = ""
+	job.CreatedAt = now
+	kp.data.Jobs[job.ID] = job
+
+	return job.ID, nil
+}
+
+// ClaimJobs locks up to limit jobs that are due, or whose lock has expired, for the
+// visibility timeout and counts the attempt
+func (kp *MemKeeper) ClaimJobs(ctx context.Context, limit int, visibility time.Duration) ([]models.Job, error) {
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	now := time.Now()
+	jobs := make([]models.Job, 0)
+	for _, j := range kp.data.Jobs {
+		due := j.Status == models.JobQueued && !j.RunAt.After(now) ||
+			j.Status == models.JobRunning && j.LockedUntil != nil && !j.LockedUntil.After(now)
+		if due {
+			jobs = append(jobs, j)
+		}
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		if !jobs[i].RunAt.Equal(jobs[j].RunAt) {
+			return jobs[i].RunAt.Before(jobs[j].RunAt)
+		}
+		return jobs[i].ID < jobs[j].ID
+	})
+	if len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+
+	lockedUntil := now.Add(visibility)
+	for i := range jobs {
+		jobs[i].Status = models.JobRunning
+		jobs[i].Attempts++
+		jobs[i].LockedUntil = &lockedUntil
+		kp.data.Jobs[jobs[i].ID] = jobs[i]
+	}
+
+	return jobs, nil
+}
+
+// CompleteJob removes a finished job from the queue
+func (kp *MemKeeper) CompleteJob(ctx context.Context, id int64) error {
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	if _, ok := kp.data.Jobs[id]; !ok {
+		return storage.ErrNotFound
+	}
+	delete(kp.data.Jobs, id)
+
+	return nil
+}
+
+// RetryJob puts a claimed job back in the queue to run at runAt, with the attempts it has made
+func (kp *MemKeeper) RetryJob(ctx context.Context, id int64, attempts int, runAt time.Time, lastError string) error {
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	j, ok := kp.data.Jobs[id]
+	if !ok {
+		return storage.ErrNotFound
+	}
+
+	j.Status = models.JobQueued
+	j.Attempts = attempts
+	j.RunAt = runAt
+	j.LockedUntil = nil
+	j.LastError = lastError
+	kp.data.Jobs[id] = j
+
+	return nil
+}
+
+// BuryJob marks a job that ran out of attempts as dead, keeping it for inspection
+func (kp *MemKeeper) BuryJob(ctx context.Context, id int64, lastError string) error {
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	j, ok := kp.data.Jobs[id]
+	if !ok {
+		return storage.ErrNotFound
+	}
+
+	j.Status = models.JobDead
+	j.LockedUntil = nil
+	j.LastError = lastError
+	kp.data.Jobs[id] = j
+
+	return nil
+}
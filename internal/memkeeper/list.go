@@ -0,0 +1,40 @@
+package memkeeper
+
+import (
+	"context"
+
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+)
+
+// GetUsers returns a page of users matching the filter, sorted as the pagination asks,
+// and the number of all matching users
+func (kp *MemKeeper) GetUsers(ctx context.Context, filter models.Filter, pagination models.Pagination) ([]models.User, int, error) {
+	kp.mx.RLock()
+	defer kp.mx.RUnlock()
+
+	users := make([]models.User, 0)
+	for _, u := range kp.data.Users {
+		if user := u.model(); u.ErasedAt == nil && storage.MatchUser(user, filter) {
+			users = append(users, user)
+		}
+	}
+	users, total := storage.PageUsers(users, pagination)
+	return users, total, nil
+}
+
+// GetTasks returns a page of tasks matching the filter, sorted as the pagination asks,
+// and the number of all matching tasks
+func (kp *MemKeeper) GetTasks(ctx context.Context, filter models.TaskFilter, pagination models.Pagination) ([]models.Task, int, error) {
+	kp.mx.RLock()
+	defer kp.mx.RUnlock()
+
+	tasks := make([]models.Task, 0)
+	for _, task := range kp.data.Tasks {
+		if storage.MatchTask(task, filter) {
+			tasks = append(tasks, task)
+		}
+	}
+	tasks, total := storage.PageTasks(tasks, pagination)
+	return tasks, total, nil
+}
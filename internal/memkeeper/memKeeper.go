@@ -0,0 +1,627 @@
+// Package memkeeper implements storage.Keeper in memory, so that timetracker
+// can run without PostgreSQL. The data can be kept between runs in a JSON snapshot.
+package memkeeper
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/audit"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/search"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type Log interface {
+	Info(string, ...zapcore.Field)
+}
+
+type MemKeeper struct {
+	mx                 sync.RWMutex
+	data               snapshot
+	index              *search.Index
+	listeners          map[chan storage.Change]struct{}
+	path               func() string
+	cipher             SnapshotCipher
+	log                Log
+	userUpdateInterval func() string
+}
+
+// NewMemKeeper creates a MemKeeper. If path is not empty, the data is loaded
+// from the snapshot file there and written back to it on Close.
+func NewMemKeeper(path func() string, log Log, userUpdateInterval func() string, cipher SnapshotCipher) *MemKeeper {
+	kp := &MemKeeper{
+		data:               newSnapshot(),
+		index:              search.NewIndex(),
+		listeners:          make(map[chan storage.Change]struct{}),
+		path:               path,
+		cipher:             cipher,
+		log:                log,
+		userUpdateInterval: userUpdateInterval,
+	}
+
+	if err := kp.load(); err != nil {
+		log.Info("cannot load snapshot: ", zap.Error(err))
+	}
+
+	return kp
+}
+
+func (kp *MemKeeper) Close() bool {
+	if err := kp.save(); err != nil {
+		kp.log.Info("cannot save snapshot: ", zap.Error(err))
+		return false
+	}
+
+	return true
+}
+
+func (kp *MemKeeper) Ping(ctx context.Context) bool {
+	return true
+}
+
+// userByPassport returns the ID of the not erased user with the passport, or 0
+func (kp *MemKeeper) userByPassport(passportSerie, passportNumber int) int {
+	for id, u := range kp.data.Users {
+		if u.ErasedAt == nil && u.PassportSerie == passportSerie && u.PassportNumber == passportNumber {
+			return id
+		}
+	}
+
+	return 0
+}
+
+func (kp *MemKeeper) SaveUser(ctx context.Context, user models.User) (int, error) {
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	if kp.userByPassport(user.PassportSerie, user.PassportNumber) != 0 {
+		return 0, storage.ErrConflict
+	}
+
+	kp.data.LastUserID++
+	user.UUID = kp.data.LastUserID
+	user.LastCheckedAt = time.Time{}
+	user.Role = models.RoleUser
+	kp.data.Users[user.UUID] = newUserRecord(user)
+	kp.indexUser(user.UUID)
+
+	kp.recordAudit(ctx, audit.ActionCreate, audit.EntityUser, user.UUID, nil, audit.UserSnapshot(user), audit.SensitiveUserFields...)
+
+	kp.log.Info("User saved successfully: ", zap.Int("userID", user.UUID))
+	return user.UUID, nil
+}
+
+// GetUser retrieves a user by passport. A missing user is returned as a zero value, as BDKeeper does.
+func (kp *MemKeeper) GetUser(ctx context.Context, passportSerie, passportNumber int) (models.User, error) {
+	kp.mx.RLock()
+	defer kp.mx.RUnlock()
+
+	id := kp.userByPassport(passportSerie, passportNumber)
+	if id == 0 {
+		kp.log.Info("user not found by passport")
+		return models.User{}, nil
+	}
+
+	return kp.data.Users[id].model(), nil
+}
+
+// GetUserByID retrieves a user by the internal identifier
+func (kp *MemKeeper) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	kp.mx.RLock()
+	defer kp.mx.RUnlock()
+
+	u, ok := kp.data.Users[id]
+	if !ok || u.ErasedAt != nil {
+		return models.User{}, storage.ErrNotFound
+	}
+
+	return u.model(), nil
+}
+
+func (kp *MemKeeper) UpdateUsersInfo(ctx context.Context, users []models.ExtUserData) ([]int, error) {
+	if len(users) == 0 {
+		return nil, nil
+	}
+
+	thresholdTime, err := kp.updateThreshold()
+	if err != nil {
+		return nil, err
+	}
+
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	now := time.Now().UTC()
+
+	var ids []int
+	for _, data := range users {
+		id := kp.userByPassport(data.PassportSerie, data.PassportNumber)
+		if id == 0 {
+			continue
+		}
+
+		u := kp.data.Users[id]
+		if !u.LastCheckedAt.IsZero() && u.LastCheckedAt.After(thresholdTime) {
+			continue
+		}
+
+		// Fields no provider has a value for keep their current value
+		if data.Surname != "" {
+			u.Surname = data.Surname
+		}
+		if data.Name != "" {
+			u.Name = data.Name
+		}
+		if data.Patronymic != "" {
+			u.Patronymic = data.Patronymic
+		}
+		if data.Address != "" {
+			u.Address = data.Address
+		}
+		u.LastCheckedAt = now
+		kp.data.Users[id] = u
+		kp.indexUser(id)
+
+		before, after := audit.EnrichedUserSnapshot(data)
+		kp.recordAudit(ctx, audit.ActionUpdate, audit.EntityUser, id, before, after, audit.SensitiveUserFields...)
+		ids = append(ids, id)
+	}
+
+	kp.log.Info("User data successfully updated")
+	return ids, nil
+}
+
+func (kp *MemKeeper) UpdateUser(ctx context.Context, user models.User) error {
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	u, ok := kp.data.Users[user.UUID]
+	if !ok || u.ErasedAt != nil {
+		return storage.ErrNotFound
+	}
+
+	current := u.model()
+	updated := current
+
+	if user.PassportSerie != 0 {
+		updated.PassportSerie = user.PassportSerie
+	}
+	if user.PassportNumber != 0 {
+		updated.PassportNumber = user.PassportNumber
+	}
+	if id := kp.userByPassport(updated.PassportSerie, updated.PassportNumber); id != 0 && id != user.UUID {
+		// Another user already has this passport
+		return storage.ErrConflict
+	}
+
+	if user.Surname != "" {
+		updated.Surname = user.Surname
+	}
+	if user.Name != "" {
+		updated.Name = user.Name
+	}
+	if user.Patronymic != "" {
+		updated.Patronymic = user.Patronymic
+	}
+	if user.Address != "" {
+		updated.Address = user.Address
+	}
+	if !user.DefaultEndTime.IsZero() {
+		updated.DefaultEndTime = user.DefaultEndTime
+	}
+	if user.Timezone != "" {
+		updated.Timezone = user.Timezone
+	}
+	if len(user.Hash) > 0 {
+		updated.Hash = user.Hash
+	}
+	if !user.LastCheckedAt.IsZero() {
+		updated.LastCheckedAt = user.LastCheckedAt
+	} else if storage.PassportChanged(current, updated) {
+		// A new passport is enriched again at once, the last check was of the old one
+		updated.LastCheckedAt = time.Time{}
+	}
+
+	record := newUserRecord(updated)
+	record.LeasedUntil = u.LeasedUntil
+	kp.data.Users[user.UUID] = record
+	kp.indexUser(user.UUID)
+
+	kp.recordAudit(ctx, audit.ActionUpdate, audit.EntityUser, user.UUID,
+		audit.UserSnapshot(current), audit.UserSnapshot(updated), audit.SensitiveUserFields...)
+
+	kp.log.Info("User data successfully updated")
+	return nil
+}
+
+func (kp *MemKeeper) LoadUsers(ctx context.Context) (storage.StorageUsers, error) {
+	kp.mx.RLock()
+	defer kp.mx.RUnlock()
+
+	data := make(storage.StorageUsers)
+	for id, u := range kp.data.Users {
+		if u.ErasedAt == nil {
+			data[id] = u.model()
+		}
+	}
+
+	return data, nil
+}
+
+func (kp *MemKeeper) DeleteUser(ctx context.Context, id int) error {
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	u, ok := kp.data.Users[id]
+	if !ok {
+		return storage.ErrNotFound
+	}
+
+	// Mirrors ON DELETE CASCADE of the time entries, two-factor settings, identities,
+	// report subscriptions and webhooks
+	delete(kp.data.Users, id)
+	kp.indexUser(id)
+	kp.deleteEntries(func(e entryRecord) bool { return e.UserID == id })
+	kp.deleteCredentials(id)
+	delete(kp.data.Enrichment, id)
+	for reportID, sub := range kp.data.Reports {
+		if sub.UserID == id {
+			delete(kp.data.Reports, reportID)
+		}
+	}
+	for hookID, hook := range kp.data.Webhooks {
+		if hook.UserID == id {
+			kp.deleteWebhook(hookID)
+		}
+	}
+
+	kp.recordAudit(ctx, audit.ActionDelete, audit.EntityUser, id, audit.UserSnapshot(u.model()), nil, audit.SensitiveUserFields...)
+
+	kp.log.Info("User deleted successfully", zap.Int("id", id))
+	return nil
+}
+
+// ClaimNonUpdateUsers leases up to limit users that haven't been updated within the
+// update interval, so that they are not claimed again until the lease expires or an
+// enrichment attempt is saved
+func (kp *MemKeeper) ClaimNonUpdateUsers(ctx context.Context, limit int, lease time.Duration) ([]models.ExtUserData, error) {
+	thresholdTime, err := kp.updateThreshold()
+	if err != nil {
+		return nil, err
+	}
+
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	now := time.Now()
+	users := make([]models.ExtUserData, 0)
+	for _, id := range kp.userIDs() {
+		if len(users) == limit {
+			break
+		}
+
+		u := kp.data.Users[id]
+		if u.ErasedAt != nil || (!u.LastCheckedAt.IsZero() && u.LastCheckedAt.After(thresholdTime)) {
+			continue
+		}
+		if u.LeasedUntil.After(now) {
+			continue
+		}
+		if a, ok := kp.data.Enrichment[id]; !isEnrichable(a, ok, now) {
+			continue
+		}
+
+		u.LeasedUntil = now.Add(lease)
+		kp.data.Users[id] = u
+
+		users = append(users, models.ExtUserData{
+			UserID:         id,
+			PassportSerie:  u.PassportSerie,
+			PassportNumber: u.PassportNumber,
+			Surname:        u.Surname,
+			Name:           u.Name,
+			Address:        u.Address,
+		})
+	}
+
+	return users, nil
+}
+
+func (kp *MemKeeper) updateThreshold() (time.Time, error) {
+	updateInterval, err := time.ParseDuration(kp.userUpdateInterval())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse USER_UPDATE_INTERVAL: %w", err)
+	}
+
+	return time.Now().UTC().Add(-updateInterval), nil
+}
+
+// userIDs returns the user IDs in ascending order
+func (kp *MemKeeper) userIDs() []int {
+	ids := make([]int, 0, len(kp.data.Users))
+	for id := range kp.data.Users {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	return ids
+}
+
+func (kp *MemKeeper) SaveTask(ctx context.Context, task models.Task) (int, error) {
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	kp.data.LastTaskID++
+	task.ID = kp.data.LastTaskID
+	kp.data.Tasks[task.ID] = task
+	kp.indexTask(task.ID)
+
+	kp.recordAudit(ctx, audit.ActionCreate, audit.EntityTask, task.ID, nil, audit.TaskSnapshot(task))
+
+	kp.log.Info("Task saved successfully: ", zap.String("name", task.Name), zap.Int("id", task.ID))
+	return task.ID, nil
+}
+
+func (kp *MemKeeper) LoadTasks(ctx context.Context) (storage.StorageTasks, error) {
+	kp.mx.RLock()
+	defer kp.mx.RUnlock()
+
+	data := make(storage.StorageTasks, len(kp.data.Tasks))
+	for id, t := range kp.data.Tasks {
+		data[id] = t
+	}
+
+	return data, nil
+}
+
+// GetTaskByID retrieves a task by its identifier
+func (kp *MemKeeper) GetTaskByID(ctx context.Context, id int) (models.Task, error) {
+	kp.mx.RLock()
+	defer kp.mx.RUnlock()
+
+	task, ok := kp.data.Tasks[id]
+	if !ok {
+		return models.Task{}, storage.ErrNotFound
+	}
+
+	return task, nil
+}
+
+func (kp *MemKeeper) DeleteTask(ctx context.Context, id int) error {
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	task, ok := kp.data.Tasks[id]
+	if !ok {
+		return storage.ErrNotFound
+	}
+
+	// Time entries reference tasks without ON DELETE CASCADE
+	for _, e := range kp.data.Entries {
+		if e.TaskID == id {
+			return fmt.Errorf("task %d has time entries", id)
+		}
+	}
+
+	delete(kp.data.Tasks, id)
+	kp.indexTask(id)
+
+	kp.recordAudit(ctx, audit.ActionDelete, audit.EntityTask, id, audit.TaskSnapshot(task), nil)
+
+	kp.log.Info("Task deleted successfully", zap.Int("id", id))
+	return nil
+}
+
+// UpdateTask updates an existing task
+func (kp *MemKeeper) UpdateTask(ctx context.Context, task models.Task) error {
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	before, ok := kp.data.Tasks[task.ID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+
+	updated := before
+	updated.Name = task.Name
+	updated.Description = task.Description
+	kp.data.Tasks[task.ID] = updated
+	kp.indexTask(task.ID)
+
+	kp.recordAudit(ctx, audit.ActionUpdate, audit.EntityTask, task.ID, audit.TaskSnapshot(before), audit.TaskSnapshot(updated))
+
+	kp.log.Info("Task successfully updated: ", zap.Int("id", task.ID))
+	return nil
+}
+
+// StartTaskTracking starts tracking time for a task
+func (kp *MemKeeper) StartTaskTracking(ctx context.Context, entry models.TimeEntry) error {
+	// Convert time taking into account the user's time zone
+	location, err := time.LoadLocation(entry.UserTimezone)
+	if err != nil {
+		kp.log.Info("error loading user timezone: ", zap.Error(err))
+		return err
+	}
+	startTime := time.Now().In(location)
+	eventDate := formatDate(entry.EventDate)
+
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	if _, ok := kp.data.Users[entry.UserID]; !ok {
+		return fmt.Errorf("user %d does not exist", entry.UserID)
+	}
+	if _, ok := kp.data.Tasks[entry.TaskID]; !ok {
+		return fmt.Errorf("task %d does not exist", entry.TaskID)
+	}
+
+	if kp.activeEntry(entry.UserID, entry.TaskID, eventDate) != 0 {
+		return fmt.Errorf("task tracking is already in progress for user %d on task %d for date %s", entry.UserID, entry.TaskID, entry.EventDate)
+	}
+
+	kp.data.LastEntryID++
+	id := kp.data.LastEntryID
+	kp.data.Entries[id] = entryRecord{
+		ID:        id,
+		UserID:    entry.UserID,
+		TaskID:    entry.TaskID,
+		EventDate: eventDate,
+		StartTime: startTime,
+	}
+
+	kp.recordAudit(ctx, audit.ActionStart, audit.EntityTimeEntry, id, nil, map[string]interface{}{
+		"user_id":    entry.UserID,
+		"task_id":    entry.TaskID,
+		"event_date": eventDate,
+		"start_time": startTime.Format(time.RFC3339),
+	})
+
+	kp.log.Info("Task tracking started successfully for user: ", zap.Int("userID", entry.UserID), zap.Int("taskID", entry.TaskID))
+	return nil
+}
+
+func (kp *MemKeeper) StopTaskTracking(ctx context.Context, entry models.TimeEntry) error {
+	// Convert time taking into account the user's time zone
+	location, err := time.LoadLocation(entry.UserTimezone)
+	if err != nil {
+		kp.log.Info("error loading user timezone: ", zap.Error(err))
+		return err
+	}
+	endTime := time.Now().In(location)
+
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	id := kp.activeEntry(entry.UserID, entry.TaskID, formatDate(entry.EventDate))
+	if id == 0 {
+		return fmt.Errorf("no active task tracking found for user %d on task %d for date %s", entry.UserID, entry.TaskID, entry.EventDate)
+	}
+
+	e := kp.data.Entries[id]
+	e.EndTime = &endTime
+	kp.data.Entries[id] = e
+
+	kp.recordAudit(ctx, audit.ActionStop, audit.EntityTimeEntry, id,
+		map[string]interface{}{"end_time": nil},
+		map[string]interface{}{"end_time": endTime.Format(time.RFC3339)})
+
+	kp.log.Info("Task tracking stopped successfully for user: ", zap.Int("userID", entry.UserID), zap.Int("taskID", entry.TaskID))
+	return nil
+}
+
+// GetRunningTimers lists the entries without an end time by user
+func (kp *MemKeeper) GetRunningTimers(ctx context.Context) ([]models.RunningTimer, error) {
+	kp.mx.RLock()
+	defer kp.mx.RUnlock()
+
+	running := make([]entryRecord, 0)
+	for _, e := range kp.data.Entries {
+		if e.EndTime == nil {
+			running = append(running, e)
+		}
+	}
+
+	sort.Slice(running, func(i, j int) bool {
+		if running[i].UserID != running[j].UserID {
+			return running[i].UserID < running[j].UserID
+		}
+		return running[i].ID < running[j].ID
+	})
+
+	timers := make([]models.RunningTimer, 0, len(running))
+	for _, e := range running {
+		timers = append(timers, models.RunningTimer{
+			UserID:    e.UserID,
+			TaskID:    e.TaskID,
+			EventDate: e.EventDate,
+			StartedAt: e.StartTime,
+		})
+	}
+
+	return timers, nil
+}
+
+// activeEntry returns the ID of the entry without an end time, or 0
+func (kp *MemKeeper) activeEntry(userID, taskID int, eventDate string) int {
+	for id, e := range kp.data.Entries {
+		if e.UserID == userID && e.TaskID == taskID && e.EventDate == eventDate && e.EndTime == nil {
+			return id
+		}
+	}
+
+	return 0
+}
+
+func (kp *MemKeeper) deleteEntries(match func(entryRecord) bool) {
+	for id, e := range kp.data.Entries {
+		if match(e) {
+			delete(kp.data.Entries, id)
+		}
+	}
+}
+
+// GetUserTaskSummary sums the tracked time per task. Like the TIME columns of
+// BDKeeper, only the time of day of the start, end and default end time is used.
+func (kp *MemKeeper) GetUserTaskSummary(ctx context.Context, userID int, startDate, endDate time.Time, userTimezone string, defaultEndTime time.Time) ([]models.TaskSummary, error) {
+	location, err := time.LoadLocation(userTimezone)
+	if err != nil {
+		kp.log.Info("error loading user timezone: ", zap.Error(err))
+		return nil, err
+	}
+
+	from, to := formatDate(startDate), formatDate(endDate)
+
+	kp.mx.RLock()
+	defer kp.mx.RUnlock()
+
+	taskTimeMap := make(map[int]time.Duration)
+	for _, e := range kp.data.Entries {
+		if e.UserID != userID || e.EventDate < from || e.EventDate > to {
+			continue
+		}
+
+		startTime := timeOfDay(e.StartTime)
+
+		var endTime time.Time
+		if e.EndTime != nil {
+			endTime = timeOfDay(*e.EndTime)
+		} else if !defaultEndTime.IsZero() {
+			endTime = timeOfDay(defaultEndTime)
+		} else {
+			endTime = time.Date(startTime.Year(), startTime.Month(), startTime.Day(), 23, 59, 59, 0, location)
+		}
+
+		taskTimeMap[e.TaskID] += endTime.Sub(startTime)
+	}
+
+	var taskSummaries []models.TaskSummary
+	for taskID, totalTime := range taskTimeMap {
+		taskSummaries = append(taskSummaries, models.TaskSummary{
+			TaskID:    taskID,
+			TotalTime: totalTime.String(),
+		})
+	}
+
+	// Sort by descending time
+	sort.Slice(taskSummaries, func(i, j int) bool {
+		return taskSummaries[i].TotalTime > taskSummaries[j].TotalTime
+	})
+
+	return taskSummaries, nil
+}
+
+// formatDate returns the date part of a time, as stored in a DATE column
+func formatDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// timeOfDay drops the date of a time, as stored in a TIME WITH TIME ZONE column
+func timeOfDay(t time.Time) time.Time {
+	return time.Date(0, 1, 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+}
@@ -0,0 +1,65 @@
+package memkeeper
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wurt83ow/timetracker/internal/encryption"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"github.com/wurt83ow/timetracker/internal/storage/storagetest"
+	"go.uber.org/zap"
+)
+
+var _ storage.Keeper = (*MemKeeper)(nil)
+
+func newTestKeeper(t *testing.T, path string) *MemKeeper {
+	t.Helper()
+
+	envelope, err := encryption.NewEnvelope("test_encryption_key")
+	require.NoError(t, err)
+
+	return NewMemKeeper(func() string { return path }, zap.NewNop(), func() string { return "5m" }, envelope)
+}
+
+func TestMemKeeper_Snapshot(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "timetracker.json")
+
+	kp := newTestKeeper(t, path)
+	userID, err := kp.SaveUser(ctx, models.User{PassportSerie: 1234, PassportNumber: 567890, Surname: "Ivanov", Hash: []byte("hash")})
+	require.NoError(t, err)
+	taskID, err := kp.SaveTask(ctx, models.Task{Name: "Report"})
+	require.NoError(t, err)
+	require.True(t, kp.Close())
+
+	// Personal data does not reach the disk in plaintext
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), "Ivanov")
+
+	kp = newTestKeeper(t, path)
+	user, err := kp.GetUserByID(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, "Ivanov", user.Surname)
+	assert.Equal(t, []byte("hash"), user.Hash)
+
+	tasks, err := kp.LoadTasks(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "Report", tasks[taskID].Name)
+
+	// Sequences continue after a reload
+	nextID, err := kp.SaveUser(ctx, models.User{PassportSerie: 1234, PassportNumber: 111111})
+	require.NoError(t, err)
+	assert.Equal(t, userID+1, nextID)
+}
+
+func TestMemKeeper_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Keeper {
+		return newTestKeeper(t, "")
+	})
+}
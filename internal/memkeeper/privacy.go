@@ -0,0 +1,134 @@
+package memkeeper
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/audit"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap"
+)
+
+// ExportUser collects the personal data of a user
+func (kp *MemKeeper) ExportUser(ctx context.Context, userID int) (models.UserExport, error) {
+	kp.mx.RLock()
+	defer kp.mx.RUnlock()
+
+	u, ok := kp.data.Users[userID]
+	if !ok || u.ErasedAt != nil {
+		return models.UserExport{}, storage.ErrNotFound
+	}
+
+	return models.UserExport{
+		Profile:     u.model(),
+		TimeEntries: kp.exportTimeEntries(userID),
+		Tokens:      kp.exportTokens(userID),
+		Audit:       kp.exportAudit(userID),
+	}, nil
+}
+
+func (kp *MemKeeper) exportTimeEntries(userID int) []models.TimeEntryRecord {
+	entries := make([]entryRecord, 0)
+	for _, e := range kp.data.Entries {
+		if e.UserID == userID {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].EventDate != entries[j].EventDate {
+			return entries[i].EventDate < entries[j].EventDate
+		}
+		return entries[i].ID < entries[j].ID
+	})
+
+	records := make([]models.TimeEntryRecord, 0, len(entries))
+	for _, e := range entries {
+		record := models.TimeEntryRecord{
+			ID:        e.ID,
+			TaskID:    e.TaskID,
+			TaskName:  kp.data.Tasks[e.TaskID].Name,
+			EventDate: e.EventDate,
+			StartTime: formatTimeOfDay(e.StartTime),
+		}
+		if e.EndTime != nil {
+			record.EndTime = formatTimeOfDay(*e.EndTime)
+		}
+		records = append(records, record)
+	}
+
+	return records
+}
+
+// exportTokens lists the credentials of a user. JWTs are stateless and not stored.
+func (kp *MemKeeper) exportTokens(userID int) []models.TokenMetadata {
+	tokens := make([]models.TokenMetadata, 0)
+
+	if tf, ok := kp.data.TwoFactor[userID]; ok {
+		createdAt, confirmedAt := tf.CreatedAt, tf.ConfirmedAt
+		token := models.TokenMetadata{Type: "totp", Enabled: tf.Enabled, CreatedAt: &createdAt}
+		if !confirmedAt.IsZero() {
+			token.UsedAt = &confirmedAt
+		}
+		tokens = append(tokens, token)
+	}
+
+	for _, code := range kp.data.RecoveryCodes[userID] {
+		tokens = append(tokens, models.TokenMetadata{Type: "recovery_code", Enabled: code.UsedAt == nil, UsedAt: code.UsedAt})
+	}
+
+	for _, identity := range kp.data.Identities {
+		if identity.UserID != userID {
+			continue
+		}
+		createdAt := identity.CreatedAt
+		tokens = append(tokens, models.TokenMetadata{
+			Type:      "oidc",
+			Issuer:    identity.Issuer,
+			Subject:   identity.Subject,
+			Email:     identity.Email,
+			Enabled:   true,
+			CreatedAt: &createdAt,
+		})
+	}
+
+	return tokens
+}
+
+// EraseUser removes the personal data and credentials of a user. The user
+// and the time entries stay, so that aggregated reports do not change.
+func (kp *MemKeeper) EraseUser(ctx context.Context, userID int) error {
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	u, ok := kp.data.Users[userID]
+	if !ok || u.ErasedAt != nil {
+		return storage.ErrNotFound
+	}
+	current := u.model()
+
+	now := time.Now()
+	kp.data.Users[userID] = userRecord{
+		ID:             userID,
+		DefaultEndTime: u.DefaultEndTime,
+		Timezone:       u.Timezone,
+		LastCheckedAt:  u.LastCheckedAt,
+		Role:           u.Role,
+		ErasedAt:       &now,
+	}
+	kp.indexUser(userID)
+	kp.deleteCredentials(userID)
+	delete(kp.data.Enrichment, userID)
+
+	kp.recordAudit(ctx, audit.ActionErase, audit.EntityUser, userID,
+		audit.UserSnapshot(current), audit.ErasedUserSnapshot(current), audit.SensitiveUserFields...)
+
+	kp.log.Info("User erased successfully", zap.Int("id", userID))
+	return nil
+}
+
+// formatTimeOfDay formats a time like the text of a TIME WITH TIME ZONE column
+func formatTimeOfDay(t time.Time) string {
+	return t.Format("15:04:05.999999-07")
+}
@@ -0,0 +1,83 @@
+package memkeeper
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+)
+
+// SaveReportSubscription creates a report subscription and returns its id
+func (kp *MemKeeper) SaveReportSubscription(ctx context.Context, sub models.ReportSubscription) (int, error) {
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	kp.data.LastReportID++
+	sub.ID = kp.data.LastReportID
+	sub.Recipients = append([]string(nil), sub.Recipients...)
+	sub.LastSentAt = nil
+	sub.NextRunAt = nil
+	sub.CreatedAt = time.Now()
+	kp.data.Reports[sub.ID] = sub
+
+	return sub.ID, nil
+}
+
+// GetReportSubscription retrieves a report subscription by its id
+func (kp *MemKeeper) GetReportSubscription(ctx context.Context, id int) (models.ReportSubscription, error) {
+	kp.mx.RLock()
+	defer kp.mx.RUnlock()
+
+	sub, ok := kp.data.Reports[id]
+	if !ok {
+		return models.ReportSubscription{}, storage.ErrNotFound
+	}
+
+	return sub, nil
+}
+
+// GetReportSubscriptions lists the report subscriptions in the order they were created
+func (kp *MemKeeper) GetReportSubscriptions(ctx context.Context) ([]models.ReportSubscription, error) {
+	kp.mx.RLock()
+	defer kp.mx.RUnlock()
+
+	subs := make([]models.ReportSubscription, 0, len(kp.data.Reports))
+	for _, sub := range kp.data.Reports {
+		subs = append(subs, sub)
+	}
+
+	sort.Slice(subs, func(i, j int) bool { return subs[i].ID < subs[j].ID })
+
+	return subs, nil
+}
+
+// DeleteReportSubscription deletes a report subscription, its queued reports are not sent
+func (kp *MemKeeper) DeleteReportSubscription(ctx context.Context, id int) error {
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	if _, ok := kp.data.Reports[id]; !ok {
+		return storage.ErrNotFound
+	}
+	delete(kp.data.Reports, id)
+
+	return nil
+}
+
+// MarkReportSent records when the report of a subscription was last sent
+func (kp *MemKeeper) MarkReportSent(ctx context.Context, id int, sentAt time.Time) error {
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	sub, ok := kp.data.Reports[id]
+	if !ok {
+		return storage.ErrNotFound
+	}
+
+	sub.LastSentAt = &sentAt
+	kp.data.Reports[id] = sub
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package memkeeper
+
+import (
+	"context"
+
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/search"
+)
+
+// Search returns the tasks and users best matching the query. They are looked
+// up in a token index that follows every change of the data.
+func (kp *MemKeeper) Search(ctx context.Context, query string, limit int) ([]models.SearchResult, error) {
+	kp.mx.RLock()
+	defer kp.mx.RUnlock()
+
+	results := make([]models.SearchResult, 0)
+	for _, hit := range kp.index.Search(search.Terms(query)) {
+		switch hit.Type {
+		case search.TypeTask:
+			results = append(results, search.TaskResult(kp.data.Tasks[hit.ID], hit.Rank))
+		case search.TypeUser:
+			results = append(results, search.UserResult(kp.data.Users[hit.ID].model(), hit.Rank))
+		}
+	}
+
+	return search.Top(results, limit), nil
+}
+
+// indexUser brings the index entry of a user up to date, erased and deleted users are not found
+func (kp *MemKeeper) indexUser(id int) {
+	key := search.Key{Type: search.TypeUser, ID: id}
+
+	u, ok := kp.data.Users[id]
+	if !ok || u.ErasedAt != nil {
+		kp.index.Delete(key)
+		return
+	}
+
+	kp.index.Set(key, search.UserFields(u.model())...)
+}
+
+// indexTask brings the index entry of a task up to date
+func (kp *MemKeeper) indexTask(id int) {
+	key := search.Key{Type: search.TypeTask, ID: id}
+
+	task, ok := kp.data.Tasks[id]
+	if !ok {
+		kp.index.Delete(key)
+		return
+	}
+
+	kp.index.Set(key, search.TaskFields(task)...)
+}
+
+// reindex rebuilds the index from the data
+func (kp *MemKeeper) reindex() {
+	kp.index = search.NewIndex()
+
+	for id := range kp.data.Users {
+		kp.indexUser(id)
+	}
+	for id := range kp.data.Tasks {
+		kp.indexTask(id)
+	}
+}
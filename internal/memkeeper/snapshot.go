@@ -0,0 +1,206 @@
+package memkeeper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/models"
+)
+
+// SnapshotCipher encrypts the snapshot file, which contains personal data
+type SnapshotCipher interface {
+	Seal(string) (string, error)
+	Open(string) (string, error)
+}
+
+// snapshot is all data of a MemKeeper. The Last*ID fields play the role of sequences.
+type snapshot struct {
+	LastUserID     int   `json:"last_user_id"`
+	LastTaskID     int   `json:"last_task_id"`
+	LastEntryID    int   `json:"last_entry_id"`
+	LastAuditID    int64 `json:"last_audit_id"`
+	LastJobID      int64 `json:"last_job_id"`
+	LastReportID   int   `json:"last_report_id"`
+	LastWebhookID  int   `json:"last_webhook_id"`
+	LastDeliveryID int64 `json:"last_delivery_id"`
+
+	Users         map[int]userRecord                `json:"users"`
+	Tasks         map[int]models.Task               `json:"tasks"`
+	Entries       map[int]entryRecord               `json:"entries"`
+	TwoFactor     map[int]twoFactorRecord           `json:"two_factor"`
+	RecoveryCodes map[int][]recoveryCodeRecord      `json:"recovery_codes"`
+	Identities    []models.UserIdentity             `json:"identities"`
+	Audit         []models.AuditEvent               `json:"audit"`
+	Enrichment    map[int]models.EnrichmentAttempt  `json:"enrichment"`
+	Jobs          map[int64]models.Job              `json:"jobs"`
+	Reports       map[int]models.ReportSubscription `json:"reports"`
+	Webhooks      map[int]models.Webhook            `json:"webhooks"`
+	Deliveries    map[int64]models.WebhookDelivery  `json:"webhook_deliveries"`
+}
+
+func newSnapshot() snapshot {
+	return snapshot{
+		Users:         make(map[int]userRecord),
+		Tasks:         make(map[int]models.Task),
+		Entries:       make(map[int]entryRecord),
+		TwoFactor:     make(map[int]twoFactorRecord),
+		RecoveryCodes: make(map[int][]recoveryCodeRecord),
+		Enrichment:    make(map[int]models.EnrichmentAttempt),
+		Jobs:          make(map[int64]models.Job),
+		Reports:       make(map[int]models.ReportSubscription),
+		Webhooks:      make(map[int]models.Webhook),
+		Deliveries:    make(map[int64]models.WebhookDelivery),
+	}
+}
+
+// userRecord is a user with the fields models.User leaves out of JSON
+type userRecord struct {
+	ID             int        `json:"id"`
+	PassportSerie  int        `json:"passport_serie"`
+	PassportNumber int        `json:"passport_number"`
+	Surname        string     `json:"surname"`
+	Name           string     `json:"name"`
+	Patronymic     string     `json:"patronymic"`
+	Address        string     `json:"address"`
+	DefaultEndTime time.Time  `json:"default_end_time"`
+	Timezone       string     `json:"timezone"`
+	PasswordHash   []byte     `json:"password_hash"`
+	LastCheckedAt  time.Time  `json:"last_checked_at"`
+	LeasedUntil    time.Time  `json:"leased_until"`
+	Role           string     `json:"role"`
+	ErasedAt       *time.Time `json:"erased_at,omitempty"`
+}
+
+func newUserRecord(user models.User) userRecord {
+	return userRecord{
+		ID:             user.UUID,
+		PassportSerie:  user.PassportSerie,
+		PassportNumber: user.PassportNumber,
+		Surname:        user.Surname,
+		Name:           user.Name,
+		Patronymic:     user.Patronymic,
+		Address:        user.Address,
+		DefaultEndTime: user.DefaultEndTime,
+		Timezone:       user.Timezone,
+		PasswordHash:   user.Hash,
+		LastCheckedAt:  user.LastCheckedAt,
+		Role:           user.Role,
+	}
+}
+
+func (u userRecord) model() models.User {
+	return models.User{
+		UUID:           u.ID,
+		PassportSerie:  u.PassportSerie,
+		PassportNumber: u.PassportNumber,
+		Surname:        u.Surname,
+		Name:           u.Name,
+		Patronymic:     u.Patronymic,
+		Address:        u.Address,
+		DefaultEndTime: u.DefaultEndTime,
+		Timezone:       u.Timezone,
+		Hash:           u.PasswordHash,
+		LastCheckedAt:  u.LastCheckedAt,
+		Role:           u.Role,
+	}
+}
+
+// entryRecord is a row of user_tasks
+type entryRecord struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	TaskID    int        `json:"task_id"`
+	EventDate string     `json:"event_date"`
+	StartTime time.Time  `json:"start_time"`
+	EndTime   *time.Time `json:"end_time,omitempty"`
+}
+
+// twoFactorRecord is models.TwoFactor with the fields it leaves out of JSON
+type twoFactorRecord struct {
+	Secret       string    `json:"secret"`
+	Enabled      bool      `json:"enabled"`
+	LastUsedStep int64     `json:"last_used_step"`
+	CreatedAt    time.Time `json:"created_at"`
+	ConfirmedAt  time.Time `json:"confirmed_at"`
+
+	FailedAttempts int       `json:"failed_attempts"`
+	LockedUntil    time.Time `json:"locked_until"`
+}
+
+type recoveryCodeRecord struct {
+	Hash   string     `json:"hash"`
+	UsedAt *time.Time `json:"used_at,omitempty"`
+}
+
+// load reads the snapshot file, a missing file leaves the keeper empty
+func (kp *MemKeeper) load() error {
+	path := kp.path()
+	if path == "" {
+		return nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := kp.cipher.Open(string(raw))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt snapshot: %w", err)
+	}
+
+	data := newSnapshot()
+	if err := json.Unmarshal([]byte(plaintext), &data); err != nil {
+		return fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+
+	kp.mx.Lock()
+	kp.data = data
+	kp.reindex()
+	kp.mx.Unlock()
+
+	return nil
+}
+
+// save writes the snapshot file through a temporary file, so a crash never leaves half of it
+func (kp *MemKeeper) save() error {
+	path := kp.path()
+	if path == "" {
+		return nil
+	}
+
+	kp.mx.RLock()
+	plaintext, err := json.Marshal(kp.data)
+	kp.mx.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	sealed, err := kp.cipher.Seal(string(plaintext))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt snapshot: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(sealed); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,176 @@
+package memkeeper
+
+import (
+	"context"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/audit"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+)
+
+// GetTwoFactor retrieves the two-factor settings of a user
+func (kp *MemKeeper) GetTwoFactor(ctx context.Context, userID int) (models.TwoFactor, error) {
+	kp.mx.RLock()
+	defer kp.mx.RUnlock()
+
+	tf, ok := kp.data.TwoFactor[userID]
+	if !ok {
+		return models.TwoFactor{}, storage.ErrNotFound
+	}
+
+	return models.TwoFactor{
+		UserID:       userID,
+		Secret:       tf.Secret,
+		Enabled:      tf.Enabled,
+		LastUsedStep: tf.LastUsedStep,
+		CreatedAt:    tf.CreatedAt,
+		ConfirmedAt:  tf.ConfirmedAt,
+
+		FailedAttempts: tf.FailedAttempts,
+		LockedUntil:    tf.LockedUntil,
+	}, nil
+}
+
+// SaveTwoFactor creates or replaces the two-factor settings of a user
+func (kp *MemKeeper) SaveTwoFactor(ctx context.Context, tf models.TwoFactor) error {
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	// The failed attempts are kept, they are only changed by the verifications
+	current, exists := kp.data.TwoFactor[tf.UserID]
+	kp.data.TwoFactor[tf.UserID] = twoFactorRecord{
+		Secret:       tf.Secret,
+		Enabled:      tf.Enabled,
+		LastUsedStep: tf.LastUsedStep,
+		CreatedAt:    tf.CreatedAt,
+		ConfirmedAt:  tf.ConfirmedAt,
+
+		FailedAttempts: current.FailedAttempts,
+		LockedUntil:    current.LockedUntil,
+	}
+
+	// Saving the last used step on every verification is not worth an audit event
+	if exists && current.Enabled == tf.Enabled && current.Secret == tf.Secret {
+		return nil
+	}
+
+	after := map[string]interface{}{"enabled": tf.Enabled, "secret": tf.Secret}
+	if exists {
+		before := map[string]interface{}{"enabled": current.Enabled, "secret": current.Secret}
+		kp.recordAudit(ctx, audit.ActionUpdate, audit.EntityTwoFactor, tf.UserID, before, after, "secret")
+	} else {
+		kp.recordAudit(ctx, audit.ActionCreate, audit.EntityTwoFactor, tf.UserID, nil, after, "secret")
+	}
+
+	return nil
+}
+
+// UseTOTPStep records the time step of an accepted code and clears the failed
+// attempts. A step that is not after the last used one is rejected with ErrNotFound,
+// so a code can't be used twice even by concurrent requests.
+func (kp *MemKeeper) UseTOTPStep(ctx context.Context, userID int, step int64) error {
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	tf, ok := kp.data.TwoFactor[userID]
+	if !ok || tf.LastUsedStep >= step {
+		return storage.ErrNotFound
+	}
+
+	tf.LastUsedStep = step
+	tf.FailedAttempts = 0
+	kp.data.TwoFactor[userID] = tf
+	return nil
+}
+
+// FailTwoFactor counts a wrong code and locks the verification out until lockUntil
+// once there are limit wrong codes in a row. It returns the number of wrong codes.
+func (kp *MemKeeper) FailTwoFactor(ctx context.Context, userID int, limit int, lockUntil time.Time) (int, error) {
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	tf, ok := kp.data.TwoFactor[userID]
+	if !ok {
+		return 0, storage.ErrNotFound
+	}
+
+	tf.FailedAttempts++
+	if tf.FailedAttempts >= limit {
+		tf.LockedUntil = lockUntil
+	}
+	kp.data.TwoFactor[userID] = tf
+	return tf.FailedAttempts, nil
+}
+
+// DeleteTwoFactor removes the two-factor settings and recovery codes of a user
+func (kp *MemKeeper) DeleteTwoFactor(ctx context.Context, userID int) error {
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	if _, ok := kp.data.TwoFactor[userID]; !ok {
+		return storage.ErrNotFound
+	}
+
+	delete(kp.data.TwoFactor, userID)
+	delete(kp.data.RecoveryCodes, userID)
+
+	kp.recordAudit(ctx, audit.ActionDelete, audit.EntityTwoFactor, userID, map[string]interface{}{"enabled": true}, nil)
+	return nil
+}
+
+// SaveRecoveryCodes replaces the recovery code hashes of a user
+func (kp *MemKeeper) SaveRecoveryCodes(ctx context.Context, userID int, hashes []string) error {
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	codes := make([]recoveryCodeRecord, 0, len(hashes))
+	for _, hash := range hashes {
+		codes = append(codes, recoveryCodeRecord{Hash: hash})
+	}
+	kp.data.RecoveryCodes[userID] = codes
+
+	// Only the number of codes is recorded, never their hashes
+	kp.recordAudit(ctx, audit.ActionUpdate, audit.EntityTwoFactor, userID,
+		map[string]interface{}{}, map[string]interface{}{"recovery_codes": len(hashes)})
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used and clears the failed attempts
+func (kp *MemKeeper) UseRecoveryCode(ctx context.Context, userID int, hash string) error {
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	codes := kp.data.RecoveryCodes[userID]
+	for i := range codes {
+		if codes[i].Hash == hash && codes[i].UsedAt == nil {
+			now := time.Now()
+			codes[i].UsedAt = &now
+
+			if tf, ok := kp.data.TwoFactor[userID]; ok {
+				tf.FailedAttempts = 0
+				kp.data.TwoFactor[userID] = tf
+			}
+
+			kp.recordAudit(ctx, audit.ActionUpdate, audit.EntityTwoFactor, userID,
+				map[string]interface{}{}, map[string]interface{}{"recovery_code_used": true})
+			return nil
+		}
+	}
+
+	return storage.ErrNotFound
+}
+
+// deleteCredentials removes the two-factor settings, recovery codes and identities of a user
+func (kp *MemKeeper) deleteCredentials(userID int) {
+	delete(kp.data.TwoFactor, userID)
+	delete(kp.data.RecoveryCodes, userID)
+
+	identities := kp.data.Identities[:0]
+	for _, identity := range kp.data.Identities {
+		if identity.UserID != userID {
+			identities = append(identities, identity)
+		}
+	}
+	kp.data.Identities = identities
+}
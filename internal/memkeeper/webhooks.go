@@ -0,0 +1,151 @@
+package memkeeper
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+)
+
+// SaveWebhook creates a webhook and returns its id
+func (kp *MemKeeper) SaveWebhook(ctx context.Context, hook models.Webhook) (int, error) {
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	kp.data.LastWebhookID++
+	hook.ID = kp.data.LastWebhookID
+	hook.Events = append([]string(nil), hook.Events...)
+	hook.CreatedAt = time.Now()
+	kp.data.Webhooks[hook.ID] = hook
+
+	return hook.ID, nil
+}
+
+// GetWebhook retrieves a webhook by its id
+func (kp *MemKeeper) GetWebhook(ctx context.Context, id int) (models.Webhook, error) {
+	kp.mx.RLock()
+	defer kp.mx.RUnlock()
+
+	hook, ok := kp.data.Webhooks[id]
+	if !ok {
+		return models.Webhook{}, storage.ErrNotFound
+	}
+
+	return hook, nil
+}
+
+// GetWebhooks lists the webhooks in the order they were created
+func (kp *MemKeeper) GetWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	kp.mx.RLock()
+	defer kp.mx.RUnlock()
+
+	hooks := make([]models.Webhook, 0, len(kp.data.Webhooks))
+	for _, hook := range kp.data.Webhooks {
+		hooks = append(hooks, hook)
+	}
+
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].ID < hooks[j].ID })
+
+	return hooks, nil
+}
+
+// DeleteWebhook deletes a webhook with its deliveries
+func (kp *MemKeeper) DeleteWebhook(ctx context.Context, id int) error {
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	if _, ok := kp.data.Webhooks[id]; !ok {
+		return storage.ErrNotFound
+	}
+	kp.deleteWebhook(id)
+
+	return nil
+}
+
+// deleteWebhook mirrors ON DELETE CASCADE of the deliveries
+func (kp *MemKeeper) deleteWebhook(id int) {
+	delete(kp.data.Webhooks, id)
+	for deliveryID, d := range kp.data.Deliveries {
+		if d.WebhookID == id {
+			delete(kp.data.Deliveries, deliveryID)
+		}
+	}
+}
+
+// SaveWebhookDelivery adds a pending delivery of a webhook and returns its id
+func (kp *MemKeeper) SaveWebhookDelivery(ctx context.Context, d models.WebhookDelivery) (int64, error) {
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	if _, ok := kp.data.Webhooks[d.WebhookID]; !ok {
+		return 0, storage.ErrNotFound
+	}
+
+	kp.data.LastDeliveryID++
+	d.ID = kp.data.LastDeliveryID
+	d.Payload = append([]byte(nil), d.Payload...)
+	d.Status = models.WebhookPending
+	d.Attempts = 0
+	d.ResponseStatus = 0
+	d.LastError = ""
+	d.CreatedAt = time.Now()
+	d.DeliveredAt = nil
+	kp.data.Deliveries[d.ID] = d
+
+	return d.ID, nil
+}
+
+// GetWebhookDelivery retrieves a delivery by its id
+func (kp *MemKeeper) GetWebhookDelivery(ctx context.Context, id int64) (models.WebhookDelivery, error) {
+	kp.mx.RLock()
+	defer kp.mx.RUnlock()
+
+	d, ok := kp.data.Deliveries[id]
+	if !ok {
+		return models.WebhookDelivery{}, storage.ErrNotFound
+	}
+
+	return d, nil
+}
+
+// GetWebhookDeliveries lists up to limit deliveries of a webhook, the most recent first
+func (kp *MemKeeper) GetWebhookDeliveries(ctx context.Context, webhookID int, limit int) ([]models.WebhookDelivery, error) {
+	kp.mx.RLock()
+	defer kp.mx.RUnlock()
+
+	deliveries := make([]models.WebhookDelivery, 0)
+	for _, d := range kp.data.Deliveries {
+		if d.WebhookID == webhookID {
+			deliveries = append(deliveries, d)
+		}
+	}
+
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID > deliveries[j].ID })
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+
+	return deliveries, nil
+}
+
+// UpdateWebhookDelivery records the outcome of an attempt of a delivery
+func (kp *MemKeeper) UpdateWebhookDelivery(ctx context.Context, d models.WebhookDelivery) error {
+	kp.mx.Lock()
+	defer kp.mx.Unlock()
+
+	stored, ok := kp.data.Deliveries[d.ID]
+	if !ok {
+		return storage.ErrNotFound
+	}
+
+	stored.Status = d.Status
+	stored.Attempts = d.Attempts
+	stored.ResponseStatus = d.ResponseStatus
+	stored.LastError = d.LastError
+	stored.DeliveredAt = d.DeliveredAt
+	kp.data.Deliveries[d.ID] = stored
+
+	return nil
+}
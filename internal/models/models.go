@@ -1,10 +1,17 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
-type Key string
+// Principal is the authenticated user of a request
+type Principal struct {
+	UserID         int
+	Role           string
+	Timezone       string
+	DefaultEndTime time.Time
+}
 
 // User represents the user data structure
 type User struct {
@@ -17,8 +24,9 @@ type User struct {
 	Address        string    `db:"address" json:"address"`
 	DefaultEndTime time.Time `db:"default_end_time" json:"default_end_time"`
 	Timezone       string    `db:"timezone" json:"timezone"`
-	Hash           []byte    `db:"password_hash" json:"password_hash"`
+	Hash           []byte    `db:"password_hash" json:"-"`
 	LastCheckedAt  time.Time `db:"last_checked_at" json:"last_checked_at"`
+	Role           string    `db:"role" json:"role,omitempty"`
 }
 
 type RequestUser struct {
@@ -41,10 +49,12 @@ type TimeEntry struct {
 
 // ExtUserData represents the user parameters structure
 type ExtUserData struct {
+	UserID         int    `json:"-"`
 	PassportSerie  int    `json:"passportSerie,omitempty"`
 	PassportNumber int    `json:"passportNumber,omitempty"`
 	Surname        string `json:"surname,omitempty"`
 	Name           string `json:"name,omitempty"`
+	Patronymic     string `json:"patronymic,omitempty"`
 	Address        string `json:"address,omitempty"`
 }
 
@@ -58,9 +68,70 @@ type Filter struct {
 	Timezone       *string
 }
 
+// Pagination selects a page of a list. A cursor continues the list after the
+// last item of the previous page and takes precedence over the offset.
 type Pagination struct {
-	Offset int `json:"offset"`
-	Limit  int `json:"limit"`
+	Offset int         `json:"offset"`
+	Limit  int         `json:"limit"`
+	Sort   []SortField `json:"-"`
+	After  *Cursor     `json:"-"`
+}
+
+// SortField is a field a list is ordered by. Ties are broken by ascending id.
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// Cursor holds the sort key of the last item of a page. Values has one entry per
+// sort field, Sort is the sort the cursor was issued for.
+type Cursor struct {
+	Sort   string        `json:"s"`
+	Values []interface{} `json:"v"`
+	ID     int           `json:"id"`
+}
+
+// ResponseUsers is a page of users
+type ResponseUsers struct {
+	Users      []User `json:"users"`
+	Total      int    `json:"total"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// PublicUser is a user as other users see it, without the passport and the address
+type PublicUser struct {
+	UUID       int    `json:"id"`
+	Surname    string `json:"surname"`
+	Name       string `json:"name"`
+	Patronymic string `json:"patronymic,omitempty"`
+	Timezone   string `json:"timezone"`
+}
+
+// ResponsePublicUsers is a page of users as other users see them
+type ResponsePublicUsers struct {
+	Users      []PublicUser `json:"users"`
+	Total      int          `json:"total"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+// ResponseTasks is a page of tasks
+type ResponseTasks struct {
+	Tasks      []Task `json:"tasks"`
+	Total      int    `json:"total"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// SearchResult is a task or a user found by a search
+type SearchResult struct {
+	Type  string  `json:"type"`
+	ID    int     `json:"id"`
+	Title string  `json:"title"`
+	Rank  float64 `json:"rank"`
+}
+
+// ResponseSearch lists the search results, best first
+type ResponseSearch struct {
+	Results []SearchResult `json:"results"`
 }
 
 type Task struct {
@@ -81,6 +152,14 @@ type TaskSummary struct {
 	TotalTime string `json:"total_time"`
 }
 
+// RunningTimer is a time entry that is being tracked
+type RunningTimer struct {
+	UserID    int       `json:"user_id"`
+	TaskID    int       `json:"task_id"`
+	EventDate string    `json:"event_date"`
+	StartedAt time.Time `json:"started_at"`
+}
+
 // RequestData defines the structure for the start and stop task tracking requests
 type RequestData struct {
 	PassportNumber string `json:"passportNumber"`
@@ -96,3 +175,315 @@ type RequestDataTask struct {
 type RequestTask struct {
 	ID string `json:"id"`
 }
+
+// TwoFactor represents the TOTP settings of a user
+type TwoFactor struct {
+	UserID       int       `db:"user_id" json:"user_id"`
+	Secret       string    `db:"secret" json:"-"`
+	Enabled      bool      `db:"enabled" json:"enabled"`
+	LastUsedStep int64     `db:"last_used_step" json:"-"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+	ConfirmedAt  time.Time `db:"confirmed_at" json:"confirmed_at,omitempty"`
+	// FailedAttempts counts the wrong codes since the last accepted one
+	FailedAttempts int `db:"failed_attempts" json:"-"`
+	// LockedUntil rejects every code until then, it is set once there are too many wrong ones
+	LockedUntil time.Time `db:"locked_until" json:"-"`
+}
+
+// TwoFactorEnrollment is returned to the user when TOTP enrollment starts
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// RequestTwoFactorCode defines the structure for the confirm and disable requests
+type RequestTwoFactorCode struct {
+	Code string `json:"code"`
+}
+
+// RequestTwoFactorLogin defines the structure for exchanging a login challenge for a JWT
+type RequestTwoFactorLogin struct {
+	ChallengeToken string `json:"challengeToken"`
+	Code           string `json:"code"`
+}
+
+// ResponseTwoFactorChallenge is returned by login when a second factor is required
+type ResponseTwoFactorChallenge struct {
+	Response       string `json:"response"`
+	ChallengeToken string `json:"challengeToken"`
+}
+
+// ResponseRecoveryCodes is returned once, when TOTP enrollment is confirmed
+type ResponseRecoveryCodes struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// ExternalIdentity is the verified identity returned by an OpenID Connect provider
+type ExternalIdentity struct {
+	Issuer  string                 `json:"issuer"`
+	Subject string                 `json:"subject"`
+	Email   string                 `json:"email,omitempty"`
+	Name    string                 `json:"name,omitempty"`
+	Claims  map[string]interface{} `json:"-"`
+}
+
+// OIDCFlow is an OpenID Connect login in progress. It is kept sealed in a cookie of
+// the browser that started it, so the callback is only accepted from that browser.
+type OIDCFlow struct {
+	State    string    `json:"state"`
+	Nonce    string    `json:"nonce"`
+	Verifier string    `json:"verifier"`
+	Expires  time.Time `json:"expires"`
+	// LinkUserID is the logged-in user the identity is linked to, 0 for a login
+	LinkUserID int `json:"link_user_id,omitempty"`
+}
+
+// ResponseOIDCLink is returned when linking an identity starts, the client opens the URL
+type ResponseOIDCLink struct {
+	AuthURL string `json:"auth_url"`
+}
+
+// UserIdentity links an identity provider subject to a user
+type UserIdentity struct {
+	ID        int       `db:"id" json:"id"`
+	UserID    int       `db:"user_id" json:"user_id"`
+	Issuer    string    `db:"issuer" json:"issuer"`
+	Subject   string    `db:"subject" json:"subject"`
+	Email     string    `db:"email" json:"email,omitempty"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// UserExport is the personal data of a user returned by a data subject access request
+type UserExport struct {
+	Profile     User              `json:"profile"`
+	TimeEntries []TimeEntryRecord `json:"time_entries"`
+	Tokens      []TokenMetadata   `json:"tokens"`
+	Audit       []AuditEvent      `json:"audit"`
+}
+
+// TimeEntryRecord is a stored time tracking interval
+type TimeEntryRecord struct {
+	ID        int    `json:"id"`
+	TaskID    int    `json:"task_id"`
+	TaskName  string `json:"task_name"`
+	EventDate string `json:"event_date"`
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time,omitempty"`
+}
+
+// TokenMetadata describes a credential of a user without its secret value
+type TokenMetadata struct {
+	Type      string     `json:"type"`
+	Issuer    string     `json:"issuer,omitempty"`
+	Subject   string     `json:"subject,omitempty"`
+	Email     string     `json:"email,omitempty"`
+	Enabled   bool       `json:"enabled"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+}
+
+// Roles of users
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// AuditEvent is a recorded mutation
+type AuditEvent struct {
+	ID         int64           `json:"id"`
+	ActorID    int             `json:"actor_id"`
+	Action     string          `json:"action"`
+	EntityType string          `json:"entity_type"`
+	EntityID   int             `json:"entity_id"`
+	Before     json.RawMessage `json:"before,omitempty" swaggertype:"object"`
+	After      json.RawMessage `json:"after,omitempty" swaggertype:"object"`
+	RequestID  string          `json:"request_id,omitempty"`
+	IP         string          `json:"ip,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// AuditFilter selects audit events. BeforeID continues a listing after its last event.
+type AuditFilter struct {
+	ActorID    *int
+	Action     *string
+	EntityType *string
+	EntityID   *int
+	From       *time.Time
+	To         *time.Time
+	BeforeID   int64
+}
+
+// ResponseAuditEvents is a page of audit events
+type ResponseAuditEvents struct {
+	Events     []AuditEvent `json:"events"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+// Statuses of the enrichment of a user
+const (
+	EnrichmentSucceeded = "succeeded"
+	EnrichmentFailed    = "failed"
+	EnrichmentDead      = "dead"
+)
+
+// EnrichmentAttempt is the state of the external enrichment of a user. Attempts counts
+// the failures since the last success; a dead user is not retried until a resync.
+type EnrichmentAttempt struct {
+	UserID      int        `json:"user_id"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	LastError   string     `json:"last_error,omitempty"`
+	NextRetryAt *time.Time `json:"next_retry_at,omitempty"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// ResponseEnrichment counts the users by enrichment status and lists the failing ones
+type ResponseEnrichment struct {
+	Counts   map[string]int      `json:"counts"`
+	Attempts []EnrichmentAttempt `json:"attempts"`
+}
+
+// Statuses of a background job
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDead    = "dead"
+)
+
+// Job is a unit of background work kept in the database until it is done. A job
+// with a Key is queued once until it is done. A running job whose LockedUntil has
+// passed is considered lost and is claimed again.
+type Job struct {
+	ID          int64           `json:"id"`
+	Kind        string          `json:"kind"`
+	Key         string          `json:"key,omitempty"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LockedUntil *time.Time      `json:"locked_until,omitempty"`
+	LastError   string          `json:"last_error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// Types of the scheduled reports, the hours tracked per user and task over the
+// previous calendar day, week (Monday to Sunday) or month
+const (
+	ReportDailyHours   = "daily_hours"
+	ReportWeeklyHours  = "weekly_hours"
+	ReportMonthlyHours = "monthly_hours"
+)
+
+// Formats of the scheduled reports: an HTML table in the email, a CSV attachment, or both
+const (
+	ReportFormatHTML    = "html"
+	ReportFormatCSV     = "csv"
+	ReportFormatHTMLCSV = "html+csv"
+)
+
+// ReportSubscription is a report emailed to the recipients on a cron schedule,
+// evaluated in the timezone of the subscription
+type ReportSubscription struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Schedule   string     `json:"schedule"`
+	ReportType string     `json:"report_type"`
+	Recipients []string   `json:"recipients"`
+	Format     string     `json:"format"`
+	Timezone   string     `json:"timezone"`
+	LastSentAt *time.Time `json:"last_sent_at,omitempty"`
+	NextRunAt  *time.Time `json:"next_run_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// RequestReportSubscription defines the structure for the report subscription requests
+type RequestReportSubscription struct {
+	Schedule   string   `json:"schedule"`
+	ReportType string   `json:"report_type"`
+	Recipients []string `json:"recipients"`
+	Format     string   `json:"format,omitempty"`
+	Timezone   string   `json:"timezone,omitempty"`
+}
+
+// Statuses of a webhook delivery. A failed delivery is retried by the job queue
+// until it runs out of attempts, and may be redelivered on request.
+const (
+	WebhookPending   = "pending"
+	WebhookSucceeded = "succeeded"
+	WebhookFailed    = "failed"
+)
+
+// Webhook is a URL the events of the types are posted to, signed with the secret.
+// The secret is stored encrypted and returned only when the webhook is created.
+type Webhook struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RequestWebhook defines the structure for the webhook requests. A secret is
+// generated if none is given.
+type RequestWebhook struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret,omitempty"`
+}
+
+// WebhookDelivery is an event posted to a webhook, with the outcome of the last attempt
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	WebhookID      int             `json:"webhook_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload" swaggertype:"object"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus int             `json:"response_status,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
+
+// Actions of the commands sent over the team board socket
+const (
+	BoardStart  = "start"
+	BoardStop   = "stop"
+	BoardSwitch = "switch"
+)
+
+// Types of the messages sent to the clients of the team board socket
+const (
+	BoardSnapshotMessage = "snapshot"
+	BoardAckMessage      = "ack"
+)
+
+// BoardTimer is a running timer on the team board with the time elapsed since its start
+type BoardTimer struct {
+	RunningTimer
+	ElapsedSeconds int64 `json:"elapsed_seconds"`
+}
+
+// BoardSnapshot lists the running timers of every user at the moment
+type BoardSnapshot struct {
+	Type   string       `json:"type"`
+	At     time.Time    `json:"at"`
+	Timers []BoardTimer `json:"timers"`
+}
+
+// BoardCommand starts or stops a timer of the user, or switches the user to another
+// task. The ID is chosen by the client and returned in the acknowledgement.
+type BoardCommand struct {
+	ID     string `json:"id"`
+	Action string `json:"action"`
+	TaskID int    `json:"task_id"`
+}
+
+// BoardAck acknowledges the BoardCommand with the ID
+type BoardAck struct {
+	Type  string `json:"type"`
+	ID    string `json:"id"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
@@ -0,0 +1,322 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	pendingTTL     = 10 * time.Minute
+	requestTimeout = 10 * time.Second
+)
+
+var (
+	ErrUnknownState = errors.New("unknown or expired login state")
+	ErrInvalidToken = errors.New("invalid id token")
+)
+
+type Log interface {
+	Info(string, ...zapcore.Field)
+}
+
+// Config holds the relying party settings registered at the identity provider
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// discovery is the subset of the provider metadata the client uses
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Client implements the authorization code flow with PKCE. It keeps no logins in
+// progress: Begin returns the flow, and the caller hands it back to Complete, so the
+// callback may reach any instance.
+type Client struct {
+	config     Config
+	httpClient *http.Client
+	log        Log
+	now        func() time.Time
+
+	mx   sync.Mutex
+	meta *discovery
+	keys *keySet
+}
+
+// NewClient creates a new Client instance. Provider metadata is discovered lazily.
+func NewClient(config Config, log Log) *Client {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "profile", "email"}
+	}
+
+	return &Client{
+		config:     config,
+		httpClient: &http.Client{Timeout: requestTimeout},
+		log:        log,
+		now:        time.Now,
+	}
+}
+
+// Begin starts a login and returns the URL of the provider's authorization endpoint
+// and the flow to complete it with. The flow holds the PKCE verifier, so it must be
+// kept from anyone but the browser that started the login.
+func (c *Client) Begin(ctx context.Context) (string, models.OIDCFlow, error) {
+	meta, err := c.discover(ctx)
+	if err != nil {
+		return "", models.OIDCFlow{}, err
+	}
+
+	state, err := randomString(24)
+	if err != nil {
+		return "", models.OIDCFlow{}, err
+	}
+	nonce, err := randomString(24)
+	if err != nil {
+		return "", models.OIDCFlow{}, err
+	}
+	verifier, err := randomString(48)
+	if err != nil {
+		return "", models.OIDCFlow{}, err
+	}
+
+	flow := models.OIDCFlow{
+		State:    state,
+		Nonce:    nonce,
+		Verifier: verifier,
+		Expires:  c.now().Add(pendingTTL),
+	}
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", c.config.ClientID)
+	v.Set("redirect_uri", c.config.RedirectURL)
+	v.Set("scope", strings.Join(c.config.Scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", CodeChallenge(verifier))
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return meta.AuthorizationEndpoint + sep + v.Encode(), flow, nil
+}
+
+// Complete exchanges the authorization code returned to the callback with the state
+// of the flow started by Begin, and verifies the ID token
+func (c *Client) Complete(ctx context.Context, flow models.OIDCFlow, state, code string) (models.ExternalIdentity, error) {
+	if flow.State == "" || subtle.ConstantTimeCompare([]byte(flow.State), []byte(state)) != 1 || c.now().After(flow.Expires) {
+		return models.ExternalIdentity{}, ErrUnknownState
+	}
+
+	meta, err := c.discover(ctx)
+	if err != nil {
+		return models.ExternalIdentity{}, err
+	}
+
+	rawIDToken, err := c.exchange(ctx, meta, code, flow.Verifier)
+	if err != nil {
+		return models.ExternalIdentity{}, err
+	}
+
+	claims, err := c.verify(ctx, meta, rawIDToken)
+	if err != nil {
+		return models.ExternalIdentity{}, err
+	}
+
+	if nonce, _ := claims["nonce"].(string); nonce != flow.Nonce {
+		return models.ExternalIdentity{}, fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	}
+
+	identity := models.ExternalIdentity{
+		Issuer: meta.Issuer,
+		Claims: claims,
+	}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+
+	if identity.Subject == "" {
+		return models.ExternalIdentity{}, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	}
+
+	return identity, nil
+}
+
+// exchange redeems the authorization code at the token endpoint and returns the raw ID token
+func (c *Client) exchange(ctx context.Context, meta *discovery, code, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", c.config.RedirectURL)
+	form.Set("client_id", c.config.ClientID)
+	form.Set("code_verifier", verifier)
+	if c.config.ClientSecret != "" {
+		form.Set("client_secret", c.config.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		c.log.Info("unable to access token endpoint: ", zap.Error(err))
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.IDToken == "" {
+		return "", fmt.Errorf("%w: token response has no id_token", ErrInvalidToken)
+	}
+
+	return token.IDToken, nil
+}
+
+// verify checks the signature, issuer, audience and lifetime of an ID token
+func (c *Client) verify(ctx context.Context, meta *discovery, rawIDToken string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+
+	token, err := jwt.ParseWithClaims(rawIDToken, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+
+		kid, _ := t.Header["kid"].(string)
+		return c.key(ctx, meta, kid)
+	})
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	if iss, _ := claims["iss"].(string); iss != meta.Issuer {
+		return nil, fmt.Errorf("%w: issuer mismatch", ErrInvalidToken)
+	}
+
+	if !hasAudience(claims["aud"], c.config.ClientID) {
+		return nil, fmt.Errorf("%w: audience mismatch", ErrInvalidToken)
+	}
+
+	if _, ok := claims["exp"]; !ok {
+		return nil, fmt.Errorf("%w: missing expiry", ErrInvalidToken)
+	}
+
+	return claims, nil
+}
+
+// discover fetches and caches the provider metadata
+func (c *Client) discover(ctx context.Context) (*discovery, error) {
+	c.mx.Lock()
+	meta := c.meta
+	c.mx.Unlock()
+
+	if meta != nil {
+		return meta, nil
+	}
+
+	wellKnown := strings.TrimSuffix(c.config.Issuer, "/") + "/.well-known/openid-configuration"
+
+	meta = new(discovery)
+	if err := c.getJSON(ctx, wellKnown, meta); err != nil {
+		c.log.Info("unable to discover identity provider: ", zap.Error(err))
+		return nil, err
+	}
+
+	if strings.TrimSuffix(meta.Issuer, "/") != strings.TrimSuffix(c.config.Issuer, "/") {
+		return nil, fmt.Errorf("issuer mismatch in discovery document: %s", meta.Issuer)
+	}
+
+	c.mx.Lock()
+	c.meta = meta
+	c.mx.Unlock()
+
+	return meta, nil
+}
+
+func (c *Client) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status code error: %s", resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// CodeChallenge derives the S256 PKCE challenge for a verifier
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func hasAudience(aud interface{}, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func randomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,123 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/oidc/oidctest"
+	"go.uber.org/zap/zapcore"
+)
+
+const redirectURL = "http://timetracker.test/api/auth/oidc/callback"
+
+type nopLog struct{}
+
+func (nopLog) Info(string, ...zapcore.Field) {}
+
+// authorize follows the authorization URL and returns the state and code sent to the callback
+func authorize(t *testing.T, authURL string) (string, string) {
+	t.Helper()
+
+	httpClient := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	resp, err := httpClient.Get(authURL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+
+	return callback.Query().Get("state"), callback.Query().Get("code")
+}
+
+func TestClient_AuthorizationCodeFlow(t *testing.T) {
+	idp := oidctest.NewServer("timetracker", "secret")
+	defer idp.Close()
+	idp.SetClaims(map[string]interface{}{"sub": "alice", "email": "alice@example.com", "passport": "1234 567890"})
+
+	client := NewClient(Config{
+		Issuer:       idp.Issuer(),
+		ClientID:     "timetracker",
+		ClientSecret: "secret",
+		RedirectURL:  redirectURL,
+	}, nopLog{})
+
+	ctx := context.Background()
+
+	authURL, flow, err := client.Begin(ctx)
+	require.NoError(t, err)
+
+	parsed, err := url.Parse(authURL)
+	require.NoError(t, err)
+	assert.Equal(t, "S256", parsed.Query().Get("code_challenge_method"))
+	assert.NotEmpty(t, parsed.Query().Get("nonce"))
+
+	assert.Equal(t, flow.State, parsed.Query().Get("state"))
+	assert.Equal(t, CodeChallenge(flow.Verifier), parsed.Query().Get("code_challenge"))
+
+	state, code := authorize(t, authURL)
+
+	identity, err := client.Complete(ctx, flow, state, code)
+	require.NoError(t, err)
+	assert.Equal(t, idp.Issuer(), identity.Issuer)
+	assert.Equal(t, "alice", identity.Subject)
+	assert.Equal(t, "alice@example.com", identity.Email)
+	assert.Equal(t, "1234 567890", identity.Claims["passport"])
+}
+
+func TestClient_RejectsUnknownState(t *testing.T) {
+	idp := oidctest.NewServer("timetracker", "secret")
+	defer idp.Close()
+
+	client := NewClient(Config{
+		Issuer:       idp.Issuer(),
+		ClientID:     "timetracker",
+		ClientSecret: "secret",
+		RedirectURL:  redirectURL,
+	}, nopLog{})
+
+	authURL, flow, err := client.Begin(context.Background())
+	require.NoError(t, err)
+
+	state, code := authorize(t, authURL)
+
+	_, err = client.Complete(context.Background(), flow, "forged-state", code)
+	assert.ErrorIs(t, err, ErrUnknownState)
+
+	// The state of another browser's flow, or no flow at all, is rejected
+	_, err = client.Complete(context.Background(), models.OIDCFlow{}, state, code)
+	assert.ErrorIs(t, err, ErrUnknownState)
+
+	client.now = func() time.Time { return flow.Expires.Add(time.Second) }
+	_, err = client.Complete(context.Background(), flow, state, code)
+	assert.ErrorIs(t, err, ErrUnknownState)
+}
+
+func TestClient_RejectsWrongClientSecret(t *testing.T) {
+	idp := oidctest.NewServer("timetracker", "secret")
+	defer idp.Close()
+
+	client := NewClient(Config{
+		Issuer:       idp.Issuer(),
+		ClientID:     "timetracker",
+		ClientSecret: "wrong",
+		RedirectURL:  redirectURL,
+	}, nopLog{})
+
+	authURL, flow, err := client.Begin(context.Background())
+	require.NoError(t, err)
+
+	state, code := authorize(t, authURL)
+
+	_, err = client.Complete(context.Background(), flow, state, code)
+	assert.Error(t, err)
+}
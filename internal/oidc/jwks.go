@@ -0,0 +1,108 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// jsonWebKey is a single RSA key of a JWK set
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type keySet struct {
+	keys map[string]*rsa.PublicKey
+}
+
+// key returns the signing key with the given id, refreshing the key set once
+// if the id is unknown, since providers rotate keys
+func (c *Client) key(ctx context.Context, meta *discovery, kid string) (*rsa.PublicKey, error) {
+	c.mx.Lock()
+	keys := c.keys
+	c.mx.Unlock()
+
+	if keys != nil {
+		if k, ok := keys.lookup(kid); ok {
+			return k, nil
+		}
+	}
+
+	keys, err := c.fetchKeys(ctx, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mx.Lock()
+	c.keys = keys
+	c.mx.Unlock()
+
+	if k, ok := keys.lookup(kid); ok {
+		return k, nil
+	}
+
+	return nil, fmt.Errorf("signing key %q not found", kid)
+}
+
+func (c *Client) fetchKeys(ctx context.Context, meta *discovery) (*keySet, error) {
+	var doc struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := c.getJSON(ctx, meta.JWKSURI, &doc); err != nil {
+		return nil, err
+	}
+
+	set := &keySet{keys: make(map[string]*rsa.PublicKey)}
+	for _, k := range doc.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		pub, err := k.publicKey()
+		if err != nil {
+			return nil, err
+		}
+		set.keys[k.Kid] = pub
+	}
+
+	if len(set.keys) == 0 {
+		return nil, errors.New("no usable signing keys in jwks")
+	}
+
+	return set, nil
+}
+
+// lookup finds a key by id. A token without kid is accepted only when the set has a single key.
+func (s *keySet) lookup(kid string) (*rsa.PublicKey, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, k := range s.keys {
+			return k, true
+		}
+	}
+
+	k, ok := s.keys[kid]
+	return k, ok
+}
+
+func (k jsonWebKey) publicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid jwk modulus: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid jwk exponent: %w", err)
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
@@ -0,0 +1,264 @@
+package reports
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/mailer"
+	"github.com/wurt83ow/timetracker/internal/models"
+)
+
+// pageSize is the number of users and tasks read from the storage at a time
+const pageSize = 100
+
+// Row is the time a user tracked on a task over the period of a report
+type Row struct {
+	UserID  int
+	Surname string
+	Name    string
+	TaskID  int
+	Task    string
+	Hours   float64
+}
+
+// Report is the hours tracked per user and task from Start to End, both inclusive
+type Report struct {
+	Type  string
+	Start time.Time
+	End   time.Time
+	Rows  []Row
+}
+
+// Period returns the first and the last day of the calendar day, week (Monday to
+// Sunday) or month before the moment, in the location of the moment
+func Period(reportType string, at time.Time) (time.Time, time.Time, error) {
+	today := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, at.Location())
+
+	switch reportType {
+	case models.ReportDailyHours:
+		start := today.AddDate(0, 0, -1)
+		return start, start, nil
+	case models.ReportWeeklyHours:
+		// Days since Monday, Sunday being the last day of the week
+		sinceMonday := (int(today.Weekday()) + 6) % 7
+		start := today.AddDate(0, 0, -sinceMonday-7)
+		return start, start.AddDate(0, 0, 6), nil
+	case models.ReportMonthlyHours:
+		start := time.Date(at.Year(), at.Month()-1, 1, 0, 0, 0, 0, at.Location())
+		return start, start.AddDate(0, 1, -1), nil
+	}
+
+	return time.Time{}, time.Time{}, fmt.Errorf("unknown report type %q", reportType)
+}
+
+// Build collects the report of the subscription for the period before the moment
+func Build(ctx context.Context, store Storage, sub models.ReportSubscription, at time.Time) (Report, error) {
+	loc, err := time.LoadLocation(sub.Timezone)
+	if err != nil {
+		return Report{}, fmt.Errorf("invalid report timezone: %w", err)
+	}
+
+	start, end, err := Period(sub.ReportType, at.In(loc))
+	if err != nil {
+		return Report{}, err
+	}
+
+	tasks, err := taskNames(ctx, store)
+	if err != nil {
+		return Report{}, err
+	}
+
+	report := Report{Type: sub.ReportType, Start: start, End: end}
+	for offset := 0; ; offset += pageSize {
+		users, total, err := store.GetUsers(ctx, models.Filter{}, models.Pagination{Offset: offset, Limit: pageSize})
+		if err != nil {
+			return Report{}, err
+		}
+
+		for _, user := range users {
+			summary, err := store.GetUserTaskSummary(ctx, user.UUID, start, end, userTimezone(user), user.DefaultEndTime)
+			if err != nil {
+				return Report{}, err
+			}
+
+			for _, s := range summary {
+				total, err := time.ParseDuration(s.TotalTime)
+				if err != nil {
+					return Report{}, fmt.Errorf("invalid total time of task %d: %w", s.TaskID, err)
+				}
+
+				report.Rows = append(report.Rows, Row{
+					UserID:  user.UUID,
+					Surname: user.Surname,
+					Name:    user.Name,
+					TaskID:  s.TaskID,
+					Task:    tasks[s.TaskID],
+					Hours:   total.Hours(),
+				})
+			}
+		}
+
+		if len(users) < pageSize || offset+pageSize >= total {
+			break
+		}
+	}
+
+	sort.SliceStable(report.Rows, func(i, j int) bool {
+		a, b := report.Rows[i], report.Rows[j]
+		if a.UserID != b.UserID {
+			return a.UserID < b.UserID
+		}
+		return a.TaskID < b.TaskID
+	})
+
+	return report, nil
+}
+
+// taskNames maps the IDs of all tasks to their names
+func taskNames(ctx context.Context, store Storage) (map[int]string, error) {
+	names := make(map[int]string)
+	for offset := 0; ; offset += pageSize {
+		tasks, total, err := store.GetTasks(ctx, models.TaskFilter{}, models.Pagination{Offset: offset, Limit: pageSize})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, task := range tasks {
+			names[task.ID] = task.Name
+		}
+
+		if len(tasks) < pageSize || offset+pageSize >= total {
+			return names, nil
+		}
+	}
+}
+
+// userTimezone returns the timezone of the user, UTC if it isn't set
+func userTimezone(user models.User) string {
+	if user.Timezone == "" {
+		return "UTC"
+	}
+	return user.Timezone
+}
+
+// Subject returns the subject of the email with the report, e.g.
+// "Weekly hours report 2024-07-01 – 2024-07-07"
+func (r Report) Subject() string {
+	var title string
+	switch r.Type {
+	case models.ReportDailyHours:
+		return "Daily hours report " + r.Start.Format(time.DateOnly)
+	case models.ReportWeeklyHours:
+		title = "Weekly hours report"
+	default:
+		title = "Monthly hours report"
+	}
+
+	return fmt.Sprintf("%s %s – %s", title, r.Start.Format(time.DateOnly), r.End.Format(time.DateOnly))
+}
+
+// TotalHours returns the hours tracked by all users
+func (r Report) TotalHours() float64 {
+	var total float64
+	for _, row := range r.Rows {
+		total += row.Hours
+	}
+	return total
+}
+
+var htmlReport = template.Must(template.New("report").Funcs(template.FuncMap{
+	"hours": formatHours,
+}).Parse(`<!DOCTYPE html>
+<html>
+<body>
+<h2>{{.Subject}}</h2>
+{{- if .Rows}}
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>User</th><th>Task</th><th>Hours</th></tr>
+{{- range .Rows}}
+<tr><td>{{.Surname}} {{.Name}}</td><td>{{.Task}}</td><td align="right">{{hours .Hours}}</td></tr>
+{{- end}}
+<tr><th colspan="2" align="left">Total</th><th align="right">{{hours .TotalHours}}</th></tr>
+</table>
+{{- else}}
+<p>No time was tracked over the period.</p>
+{{- end}}
+</body>
+</html>
+`))
+
+// HTML renders the report as an HTML table
+func (r Report) HTML() (string, error) {
+	var buf bytes.Buffer
+	if err := htmlReport.Execute(&buf, r); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// CSV renders the report with a row per user and task
+func (r Report) CSV() ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	if err := w.Write([]string{"user_id", "surname", "name", "task_id", "task", "hours"}); err != nil {
+		return nil, err
+	}
+	for _, row := range r.Rows {
+		record := []string{
+			strconv.Itoa(row.UserID),
+			row.Surname,
+			row.Name,
+			strconv.Itoa(row.TaskID),
+			row.Task,
+			formatHours(row.Hours),
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// Message renders the report as an email in the format of the subscription: an HTML
+// table, a CSV attachment with a short summary in the body, or both
+func (r Report) Message(sub models.ReportSubscription) (mailer.Message, error) {
+	msg := mailer.Message{To: sub.Recipients, Subject: r.Subject()}
+
+	if sub.Format == models.ReportFormatCSV {
+		msg.HTML = fmt.Sprintf("<p>%s: %s hours in total, see the attachment.</p>",
+			template.HTMLEscapeString(r.Subject()), formatHours(r.TotalHours()))
+	} else {
+		html, err := r.HTML()
+		if err != nil {
+			return mailer.Message{}, err
+		}
+		msg.HTML = html
+	}
+
+	if sub.Format == models.ReportFormatCSV || sub.Format == models.ReportFormatHTMLCSV {
+		data, err := r.CSV()
+		if err != nil {
+			return mailer.Message{}, err
+		}
+		msg.Attachments = []mailer.Attachment{{
+			Name:        fmt.Sprintf("%s_%s.csv", r.Type, r.Start.Format(time.DateOnly)),
+			ContentType: "text/csv",
+			Data:        data,
+		}}
+	}
+
+	return msg, nil
+}
+
+func formatHours(hours float64) string {
+	return strconv.FormatFloat(hours, 'f', 2, 64)
+}
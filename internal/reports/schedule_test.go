@@ -0,0 +1,60 @@
+package reports
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchedule_Next(t *testing.T) {
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	require.NoError(t, err)
+
+	// A Wednesday
+	from := time.Date(2024, time.July, 3, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"*/15 * * * *", from, time.Date(2024, time.July, 3, 10, 45, 0, 0, time.UTC)},
+		{"@hourly", from, time.Date(2024, time.July, 3, 11, 0, 0, 0, time.UTC)},
+		{"0 8 * * *", from, time.Date(2024, time.July, 4, 8, 0, 0, 0, time.UTC)},
+		{"30 10 * * *", from, time.Date(2024, time.July, 4, 10, 30, 0, 0, time.UTC)},
+		{"@weekly", from, time.Date(2024, time.July, 8, 0, 0, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2024, time.July, 5, 9, 0, 0, 0, time.UTC), time.Date(2024, time.July, 8, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", from, time.Date(2024, time.July, 7, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", from, time.Date(2024, time.August, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", from, time.Date(2024, time.July, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 FEB *", from, time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", from, time.Date(2024, time.July, 15, 0, 0, 0, 0, time.UTC)},
+		// Either day matches when both are restricted
+		{"0 0 1 * FRI", from, time.Date(2024, time.July, 5, 0, 0, 0, 0, time.UTC)},
+		// The schedule is evaluated in the location of the moment
+		{"0 8 * * *", from.In(moscow), time.Date(2024, time.July, 4, 8, 0, 0, 0, moscow)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			schedule, err := ParseSchedule(tt.spec)
+			require.NoError(t, err)
+			assert.True(t, tt.want.Equal(schedule.Next(tt.from)), "got %s", schedule.Next(tt.from))
+		})
+	}
+}
+
+func TestSchedule_NeverMatches(t *testing.T) {
+	schedule, err := ParseSchedule("0 0 30 2 *")
+	require.NoError(t, err)
+	assert.True(t, schedule.Next(time.Now()).IsZero())
+}
+
+func TestParseSchedule_Invalid(t *testing.T) {
+	for _, spec := range []string{"", "@yearly", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *"} {
+		_, err := ParseSchedule(spec)
+		assert.ErrorIs(t, err, ErrInvalidSchedule, spec)
+	}
+}
@@ -0,0 +1,190 @@
+// Package reports emails the hours tracked by the users on the schedules of the
+// report subscriptions. Every run of a subscription is a job of the database queue,
+// and each run queues the next one, so the schedules survive restarts and several
+// instances deliver a report once.
+package reports
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/jobs"
+	"github.com/wurt83ow/timetracker/internal/mailer"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// JobKind is the kind of the jobs and worker pool tasks that send the reports
+const JobKind = "report"
+
+type Log interface {
+	Info(string, ...zapcore.Field)
+}
+
+type Storage interface {
+	GetUsers(context.Context, models.Filter, models.Pagination) ([]models.User, int, error)
+	GetTasks(context.Context, models.TaskFilter, models.Pagination) ([]models.Task, int, error)
+	GetUserTaskSummary(ctx context.Context, userID int, startDate, endDate time.Time, userTimezone string, defaultEndTime time.Time) ([]models.TaskSummary, error)
+	GetReportSubscription(context.Context, int) (models.ReportSubscription, error)
+	GetReportSubscriptions(context.Context) ([]models.ReportSubscription, error)
+	MarkReportSent(ctx context.Context, id int, sentAt time.Time) error
+	EnqueueJob(context.Context, models.Job) (int64, error)
+}
+
+type Jobs interface {
+	Handle(kind string, handler jobs.Handler)
+}
+
+type Mailer interface {
+	Send(context.Context, mailer.Message) error
+}
+
+// reportJob is the payload of the report jobs. RunAt is the moment the report is
+// made for, and Manual marks a report sent on demand, which doesn't queue the next run.
+type reportJob struct {
+	SubscriptionID int       `json:"subscription_id"`
+	RunAt          time.Time `json:"run_at"`
+	Manual         bool      `json:"manual,omitempty"`
+}
+
+// NextRun returns the first run of the subscription after the moment
+func NextRun(sub models.ReportSubscription, after time.Time) (time.Time, error) {
+	schedule, err := ParseSchedule(sub.Schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	loc, err := time.LoadLocation(sub.Timezone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid report timezone: %w", err)
+	}
+
+	next := schedule.Next(after.In(loc))
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("%w: %q never runs", ErrInvalidSchedule, sub.Schedule)
+	}
+
+	return next, nil
+}
+
+// NextJob returns the job of the first run of the subscription after the moment. The
+// key of the job is the run, so each run is queued once.
+func NextJob(sub models.ReportSubscription, after time.Time) (models.Job, error) {
+	runAt, err := NextRun(sub, after)
+	if err != nil {
+		return models.Job{}, err
+	}
+
+	return newJob(sub, runAt, false, fmt.Sprintf("%s:%d:%d", JobKind, sub.ID, runAt.Unix()))
+}
+
+// NowJob returns the job sending the report of the subscription right away
+func NowJob(sub models.ReportSubscription, now time.Time) (models.Job, error) {
+	return newJob(sub, now, true, "")
+}
+
+func newJob(sub models.ReportSubscription, runAt time.Time, manual bool, key string) (models.Job, error) {
+	payload, err := json.Marshal(reportJob{SubscriptionID: sub.ID, RunAt: runAt.UTC(), Manual: manual})
+	if err != nil {
+		return models.Job{}, err
+	}
+
+	return models.Job{Kind: JobKind, Key: key, Payload: payload, RunAt: runAt}, nil
+}
+
+// Service builds the reports of the subscriptions and emails them
+type Service struct {
+	storage Storage
+	jobs    Jobs
+	mailer  Mailer
+	log     Log
+	now     func() time.Time
+}
+
+// NewService creates a report service
+func NewService(storage Storage, jobs Jobs, mailer Mailer, log Log) *Service {
+	return &Service{
+		storage: storage,
+		jobs:    jobs,
+		mailer:  mailer,
+		log:     log,
+		now:     time.Now,
+	}
+}
+
+// Start registers the report jobs and queues the next run of every subscription.
+// The runs queued already are kept, so the schedules lost by a failure are resumed.
+func (s *Service) Start(ctx context.Context) {
+	s.jobs.Handle(JobKind, s.send)
+
+	subs, err := s.storage.GetReportSubscriptions(ctx)
+	if err != nil {
+		s.log.Info("failed to load report subscriptions: ", zap.Error(err))
+		return
+	}
+
+	for _, sub := range subs {
+		s.schedule(ctx, sub)
+	}
+}
+
+// schedule queues the next run of the subscription unless it is queued already
+func (s *Service) schedule(ctx context.Context, sub models.ReportSubscription) {
+	job, err := NextJob(sub, s.now())
+	if err != nil {
+		s.log.Info("failed to schedule report: ", zap.Int("subscription", sub.ID), zap.Error(err))
+		return
+	}
+
+	if _, err := s.storage.EnqueueJob(ctx, job); err != nil && !errors.Is(err, storage.ErrConflict) {
+		s.log.Info("failed to schedule report: ", zap.Int("subscription", sub.ID), zap.Error(err))
+	}
+}
+
+// send runs a report job. The next run is queued before the report is sent, so a
+// failing report doesn't stop the schedule; the report itself is retried by the queue.
+func (s *Service) send(ctx context.Context, job models.Job) error {
+	var payload reportJob
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return fmt.Errorf("invalid report job payload: %w", err)
+	}
+
+	sub, err := s.storage.GetReportSubscription(ctx, payload.SubscriptionID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			// The subscription is deleted, which ends its schedule
+			return nil
+		}
+		return err
+	}
+
+	if !payload.Manual {
+		s.schedule(ctx, sub)
+	}
+
+	report, err := Build(ctx, s.storage, sub, payload.RunAt)
+	if err != nil {
+		return err
+	}
+
+	msg, err := report.Message(sub)
+	if err != nil {
+		return err
+	}
+
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		return err
+	}
+
+	if err := s.storage.MarkReportSent(ctx, sub.ID, s.now()); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		s.log.Info("failed to mark report as sent: ", zap.Int("subscription", sub.ID), zap.Error(err))
+	}
+
+	s.log.Info("report sent", zap.Int("subscription", sub.ID), zap.String("subject", msg.Subject))
+	return nil
+}
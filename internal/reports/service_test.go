@@ -0,0 +1,213 @@
+package reports
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wurt83ow/timetracker/internal/encryption"
+	"github.com/wurt83ow/timetracker/internal/jobs"
+	"github.com/wurt83ow/timetracker/internal/mailer"
+	"github.com/wurt83ow/timetracker/internal/mailer/smtptest"
+	"github.com/wurt83ow/timetracker/internal/memkeeper"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap"
+)
+
+type smtpOptions struct{ address string }
+
+func (o smtpOptions) SMTPAddress() string { return o.address }
+func (smtpOptions) SMTPUsername() string  { return "" }
+func (smtpOptions) SMTPPassword() string  { return "" }
+func (smtpOptions) SMTPFrom() string      { return "TimeTracker <reports@example.com>" }
+func (smtpOptions) SMTPTLS() string       { return mailer.TLSNone }
+func (smtpOptions) SMTPTimeout() string   { return "5s" }
+
+// fakeJobs keeps the registered handlers
+type fakeJobs map[string]jobs.Handler
+
+func (f fakeJobs) Handle(kind string, handler jobs.Handler) {
+	f[kind] = handler
+}
+
+func newService(t *testing.T) (*Service, *memkeeper.MemKeeper, fakeJobs, *smtptest.Server) {
+	envelope, err := encryption.NewEnvelope("test_encryption_key")
+	require.NoError(t, err)
+
+	kp := memkeeper.NewMemKeeper(func() string { return "" }, zap.NewNop(), func() string { return "5m" }, envelope)
+	server := smtptest.NewServer()
+	t.Cleanup(server.Close)
+
+	registered := fakeJobs{}
+	client := mailer.NewClient(smtpOptions{address: server.Addr()}, zap.NewNop())
+
+	return NewService(kp, registered, client, zap.NewNop()), kp, registered, server
+}
+
+func TestService_SchedulesAndSendsReports(t *testing.T) {
+	s, kp, registered, server := newService(t)
+	ctx := context.Background()
+
+	userID, err := kp.SaveUser(ctx, models.User{PassportSerie: 1234, PassportNumber: 567890, Surname: "Ivanov", Name: "Ivan", Timezone: "UTC"})
+	require.NoError(t, err)
+	taskID, err := kp.SaveTask(ctx, models.Task{Name: "Quarterly report", CreatedAt: time.Now()})
+	require.NoError(t, err)
+
+	// Tracked yesterday, the period of a daily report sent today
+	now := time.Now().UTC()
+	entry := models.TimeEntry{EventDate: now.AddDate(0, 0, -1), UserID: userID, TaskID: taskID, UserTimezone: "UTC"}
+	require.NoError(t, kp.StartTaskTracking(ctx, entry))
+
+	sub := models.ReportSubscription{
+		UserID:     userID,
+		Schedule:   "0 8 * * *",
+		ReportType: models.ReportDailyHours,
+		Recipients: []string{"manager@example.com"},
+		Format:     models.ReportFormatHTMLCSV,
+		Timezone:   "UTC",
+	}
+	sub.ID, err = kp.SaveReportSubscription(ctx, sub)
+	require.NoError(t, err)
+
+	s.now = func() time.Time { return now }
+	s.Start(ctx)
+	require.Contains(t, registered, JobKind)
+
+	// The next run is queued once
+	next, err := NextJob(sub, now)
+	require.NoError(t, err)
+	_, err = kp.EnqueueJob(ctx, next)
+	assert.ErrorIs(t, err, storage.ErrConflict)
+
+	job, err := NowJob(sub, now)
+	require.NoError(t, err)
+	require.NoError(t, registered[JobKind](ctx, job))
+
+	messages := server.Messages()
+	require.Len(t, messages, 1)
+	assert.Equal(t, []string{"manager@example.com"}, messages[0].To)
+
+	msg, err := mail.ReadMessage(bytes.NewReader(messages[0].Data))
+	require.NoError(t, err)
+	assert.Equal(t, "Daily hours report "+now.AddDate(0, 0, -1).Format(time.DateOnly), msg.Header.Get("Subject"))
+
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+
+	html, err := reader.NextPart()
+	require.NoError(t, err)
+	body, err := io.ReadAll(html)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "Quarterly report")
+	assert.Contains(t, string(body), "Ivanov Ivan")
+
+	attachment, err := reader.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "daily_hours_"+now.AddDate(0, 0, -1).Format(time.DateOnly)+".csv", attachment.FileName())
+
+	saved, err := kp.GetReportSubscription(ctx, sub.ID)
+	require.NoError(t, err)
+	require.NotNil(t, saved.LastSentAt)
+}
+
+func TestService_DeletedSubscriptionEndsSchedule(t *testing.T) {
+	s, kp, registered, server := newService(t)
+	ctx := context.Background()
+
+	userID, err := kp.SaveUser(ctx, models.User{PassportSerie: 1234, PassportNumber: 567890, Timezone: "UTC"})
+	require.NoError(t, err)
+
+	sub := models.ReportSubscription{
+		UserID:     userID,
+		Schedule:   "@daily",
+		ReportType: models.ReportWeeklyHours,
+		Recipients: []string{"manager@example.com"},
+		Format:     models.ReportFormatCSV,
+		Timezone:   "UTC",
+	}
+	sub.ID, err = kp.SaveReportSubscription(ctx, sub)
+	require.NoError(t, err)
+
+	s.Start(ctx)
+	job, err := NextJob(sub, time.Now())
+	require.NoError(t, err)
+
+	require.NoError(t, kp.DeleteReportSubscription(ctx, sub.ID))
+	assert.NoError(t, registered[JobKind](ctx, job))
+	assert.Empty(t, server.Messages())
+}
+
+func TestService_SendFailureRetriesJob(t *testing.T) {
+	s, kp, registered, server := newService(t)
+	ctx := context.Background()
+	server.Reject("554 5.7.1 rejected")
+
+	userID, err := kp.SaveUser(ctx, models.User{PassportSerie: 1234, PassportNumber: 567890, Timezone: "UTC"})
+	require.NoError(t, err)
+
+	sub := models.ReportSubscription{
+		UserID:     userID,
+		Schedule:   "0 0 1 * *",
+		ReportType: models.ReportMonthlyHours,
+		Recipients: []string{"manager@example.com"},
+		Format:     models.ReportFormatHTML,
+		Timezone:   "Europe/Moscow",
+	}
+	sub.ID, err = kp.SaveReportSubscription(ctx, sub)
+	require.NoError(t, err)
+
+	s.Start(ctx)
+	job, err := NextJob(sub, time.Now())
+	require.NoError(t, err)
+
+	assert.Error(t, registered[JobKind](ctx, job))
+
+	saved, err := kp.GetReportSubscription(ctx, sub.ID)
+	require.NoError(t, err)
+	assert.Nil(t, saved.LastSentAt)
+}
+
+func TestPeriod(t *testing.T) {
+	// A Wednesday
+	at := time.Date(2024, time.March, 6, 8, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		reportType string
+		start, end time.Time
+	}{
+		{models.ReportDailyHours, time.Date(2024, time.March, 5, 0, 0, 0, 0, time.UTC), time.Date(2024, time.March, 5, 0, 0, 0, 0, time.UTC)},
+		{models.ReportWeeklyHours, time.Date(2024, time.February, 26, 0, 0, 0, 0, time.UTC), time.Date(2024, time.March, 3, 0, 0, 0, 0, time.UTC)},
+		{models.ReportMonthlyHours, time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		start, end, err := Period(tt.reportType, at)
+		require.NoError(t, err)
+		assert.Equal(t, tt.start, start, tt.reportType)
+		assert.Equal(t, tt.end, end, tt.reportType)
+	}
+
+	_, _, err := Period("yearly", at)
+	assert.Error(t, err)
+}
+
+func TestNowJob_IsManual(t *testing.T) {
+	job, err := NowJob(models.ReportSubscription{ID: 7}, time.Now())
+	require.NoError(t, err)
+
+	var payload reportJob
+	require.NoError(t, json.Unmarshal(job.Payload, &payload))
+	assert.Equal(t, 7, payload.SubscriptionID)
+	assert.True(t, payload.Manual)
+	assert.Empty(t, job.Key)
+}
@@ -0,0 +1,124 @@
+package search
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Key identifies an indexed item
+type Key struct {
+	Type string
+	ID   int
+}
+
+// Index is an in-memory inverted index from words to items. Searches may run
+// concurrently, while its owner serializes them with Set and Delete.
+type Index struct {
+	items map[Key][]Field
+	words map[string]map[Key]struct{}
+
+	mx     sync.Mutex
+	sorted []string // the words in order for prefix lookups, nil when stale
+}
+
+// NewIndex creates an empty index
+func NewIndex() *Index {
+	return &Index{
+		items: make(map[Key][]Field),
+		words: make(map[string]map[Key]struct{}),
+	}
+}
+
+// Set indexes an item, replacing its previous fields
+func (ix *Index) Set(key Key, fields ...Field) {
+	ix.Delete(key)
+
+	ix.items[key] = fields
+	for _, f := range fields {
+		for _, word := range tokens(f.Text) {
+			keys, ok := ix.words[word]
+			if !ok {
+				keys = make(map[Key]struct{})
+				ix.words[word] = keys
+				ix.sorted = nil
+			}
+			keys[key] = struct{}{}
+		}
+	}
+}
+
+// Delete removes an item from the index
+func (ix *Index) Delete(key Key) {
+	fields, ok := ix.items[key]
+	if !ok {
+		return
+	}
+
+	delete(ix.items, key)
+	for _, f := range fields {
+		for _, word := range tokens(f.Text) {
+			delete(ix.words[word], key)
+			if len(ix.words[word]) == 0 {
+				delete(ix.words, word)
+				ix.sorted = nil
+			}
+		}
+	}
+}
+
+// Hit is an item found in the index
+type Hit struct {
+	Key
+	Rank float64
+}
+
+// Search returns the items matching all terms, ranked by Score and in no particular order
+func (ix *Index) Search(terms []string) []Hit {
+	if len(terms) == 0 {
+		return nil
+	}
+
+	// Only the items with a word starting with every term are scored
+	candidates := ix.prefixed(terms[0])
+	for _, term := range terms[1:] {
+		matches := ix.prefixed(term)
+		for key := range candidates {
+			if _, ok := matches[key]; !ok {
+				delete(candidates, key)
+			}
+		}
+	}
+
+	hits := make([]Hit, 0, len(candidates))
+	for key := range candidates {
+		if rank := Score(terms, ix.items[key]...); rank > 0 {
+			hits = append(hits, Hit{Key: key, Rank: rank})
+		}
+	}
+
+	return hits
+}
+
+// prefixed returns the items with a word starting with the prefix
+func (ix *Index) prefixed(prefix string) map[Key]struct{} {
+	ix.mx.Lock()
+	defer ix.mx.Unlock()
+
+	if ix.sorted == nil {
+		ix.sorted = make([]string, 0, len(ix.words))
+		for word := range ix.words {
+			ix.sorted = append(ix.sorted, word)
+		}
+		sort.Strings(ix.sorted)
+	}
+
+	keys := make(map[Key]struct{})
+	for i := sort.SearchStrings(ix.sorted, prefix); i < len(ix.sorted) && strings.HasPrefix(ix.sorted[i], prefix); i++ {
+		for key := range ix.words[ix.sorted[i]] {
+			keys[key] = struct{}{}
+		}
+	}
+
+	return keys
+}
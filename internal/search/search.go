@@ -0,0 +1,140 @@
+// Package search tokenizes and ranks free text queries over tasks and users.
+// Keepers use it to score the candidates they find, so that every keeper ranks
+// results the same way.
+package search
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/wurt83ow/timetracker/internal/models"
+)
+
+// Types of the found items
+const (
+	TypeTask = "task"
+	TypeUser = "user"
+)
+
+// Field weights. Names matter more than descriptions, like the weights A and B of a tsvector.
+const (
+	WeightName = 1.0
+	WeightText = 0.4
+)
+
+// Field is a text of an item together with its weight
+type Field struct {
+	Text   string
+	Weight float64
+}
+
+// Terms splits a query into distinct lower case words of letters and digits
+func Terms(query string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+
+	for _, term := range tokens(query) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+
+	return terms
+}
+
+func tokens(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// TSQuery is the PostgreSQL to_tsquery form of the terms, every term matching as a prefix
+func TSQuery(terms []string) string {
+	parts := make([]string, 0, len(terms))
+	for _, term := range terms {
+		parts = append(parts, term+":*")
+	}
+
+	return strings.Join(parts, " & ")
+}
+
+// Score ranks the fields of an item against the terms. Every term has to start
+// a word of a field, a whole word counts twice as much as a prefix. The rank is
+// between 0 (no match) and 1.
+func Score(terms []string, fields ...Field) float64 {
+	if len(terms) == 0 {
+		return 0
+	}
+
+	var total float64
+	for _, term := range terms {
+		best := 0.0
+		for _, f := range fields {
+			for _, word := range tokens(f.Text) {
+				switch {
+				case word == term:
+					best = max(best, f.Weight)
+				case strings.HasPrefix(word, term):
+					best = max(best, f.Weight/2)
+				}
+			}
+		}
+
+		if best == 0 {
+			return 0
+		}
+		total += best
+	}
+
+	return total / float64(len(terms))
+}
+
+// TaskFields are the searchable fields of a task
+func TaskFields(task models.Task) []Field {
+	return []Field{{Text: task.Name, Weight: WeightName}, {Text: task.Description, Weight: WeightText}}
+}
+
+// UserFields are the searchable fields of a user
+func UserFields(user models.User) []Field {
+	return []Field{
+		{Text: user.Surname, Weight: WeightName},
+		{Text: user.Name, Weight: WeightName},
+		{Text: user.Patronymic, Weight: WeightName},
+	}
+}
+
+// TaskResult returns a found task
+func TaskResult(task models.Task, rank float64) models.SearchResult {
+	return models.SearchResult{Type: TypeTask, ID: task.ID, Title: task.Name, Rank: rank}
+}
+
+// UserResult returns a found user, titled by the full name
+func UserResult(user models.User, rank float64) models.SearchResult {
+	title := strings.Join(strings.Fields(user.Surname+" "+user.Name+" "+user.Patronymic), " ")
+	return models.SearchResult{Type: TypeUser, ID: user.UUID, Title: title, Rank: rank}
+}
+
+// Top orders the results by rank, then by type and id, and keeps the first limit of them
+func Top(results []models.SearchResult, limit int) []models.SearchResult {
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Rank != b.Rank {
+			return a.Rank > b.Rank
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.ID < b.ID
+	})
+
+	if limit < 0 {
+		limit = 0
+	}
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results
+}
@@ -0,0 +1,57 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wurt83ow/timetracker/internal/models"
+)
+
+func TestTerms(t *testing.T) {
+	assert.Equal(t, []string{"отчёт", "q3", "review"}, Terms("Отчёт Q3, review & отчёт!"))
+	assert.Empty(t, Terms(" -*- "))
+	assert.Equal(t, "report:* & q3:*", TSQuery([]string{"report", "q3"}))
+}
+
+func TestScore(t *testing.T) {
+	task := TaskFields(models.Task{Name: "Monthly report", Description: "Finance review"})
+
+	assert.Equal(t, WeightName, Score([]string{"report"}, task...))
+	assert.Equal(t, WeightName/2, Score([]string{"rep"}, task...), "a prefix counts half")
+	assert.Equal(t, WeightText, Score([]string{"review"}, task...))
+	assert.Equal(t, (WeightName+WeightText)/2, Score([]string{"monthly", "finance"}, task...))
+	assert.Zero(t, Score([]string{"report", "deploy"}, task...), "every term has to match")
+	assert.Zero(t, Score([]string{"port"}, task...), "terms match the start of a word")
+}
+
+func TestIndex(t *testing.T) {
+	ix := NewIndex()
+	report := Key{Type: TypeTask, ID: 1}
+	review := Key{Type: TypeTask, ID: 2}
+	ivanov := Key{Type: TypeUser, ID: 1}
+
+	ix.Set(report, TaskFields(models.Task{Name: "Report", Description: "Monthly"})...)
+	ix.Set(review, TaskFields(models.Task{Name: "Review", Description: "Monthly report"})...)
+	ix.Set(ivanov, UserFields(models.User{Surname: "Ivanov", Name: "Ivan"})...)
+
+	assert.ElementsMatch(t, []Hit{{Key: report, Rank: WeightName}, {Key: review, Rank: WeightText}}, ix.Search([]string{"report"}))
+	assert.ElementsMatch(t, []Hit{{Key: ivanov, Rank: WeightName}}, ix.Search([]string{"ivan"}))
+	assert.Empty(t, ix.Search([]string{"report", "ivan"}))
+
+	ix.Set(review, TaskFields(models.Task{Name: "Review"})...)
+	assert.ElementsMatch(t, []Hit{{Key: report, Rank: WeightName}}, ix.Search([]string{"report"}), "a changed item is indexed again")
+
+	ix.Delete(report)
+	assert.Empty(t, ix.Search([]string{"report"}))
+	assert.ElementsMatch(t, []Hit{{Key: review, Rank: WeightName / 2}}, ix.Search([]string{"re"}))
+}
+
+func TestTop(t *testing.T) {
+	results := Top([]models.SearchResult{
+		{Type: TypeUser, ID: 1, Rank: 0.5},
+		{Type: TypeTask, ID: 2, Rank: 0.5},
+		{Type: TypeTask, ID: 1, Rank: 1},
+	}, 2)
+
+	assert.Equal(t, []models.SearchResult{{Type: TypeTask, ID: 1, Rank: 1}, {Type: TypeTask, ID: 2, Rank: 0.5}}, results)
+}
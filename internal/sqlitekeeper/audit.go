@@ -0,0 +1,160 @@
+package sqlitekeeper
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/audit"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"go.uber.org/zap"
+)
+
+// auditColumns is the column list read by scanAuditEvents
+const auditColumns = `id, COALESCE(actor_id, 0), action, entity_type, entity_id, before, after,
+	COALESCE(request_id, ''), COALESCE(ip, ''), created_at`
+
+// recordAudit appends an audit event in the transaction of the mutation it describes
+func (kp *SQLiteKeeper) recordAudit(ctx context.Context, tx *sql.Tx, action, entityType string, entityID int,
+	before, after map[string]interface{}, sensitive ...string,
+) error {
+	beforeJSON, afterJSON, err := audit.Diff(before, after, sensitive...)
+	if err != nil {
+		return err
+	}
+
+	meta := audit.FromContext(ctx)
+
+	query := `
+        INSERT INTO audit_events (actor_id, action, entity_type, entity_id, before, after, request_id, ip, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+    `
+
+	_, err = tx.ExecContext(ctx, query,
+		nullInt(meta.ActorID),
+		action,
+		entityType,
+		entityID,
+		nullJSON(beforeJSON),
+		nullJSON(afterJSON),
+		nullString(meta.RequestID),
+		nullString(meta.IP),
+		formatTimestamp(time.Now()),
+	)
+	if err != nil {
+		kp.log.Info("error saving audit event to database: ", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// GetAuditEvents returns audit events matching the filter, newest first
+func (kp *SQLiteKeeper) GetAuditEvents(ctx context.Context, filter models.AuditFilter, limit int) ([]models.AuditEvent, error) {
+	query := `SELECT ` + auditColumns + ` FROM audit_events WHERE 1 = 1`
+	var args []interface{}
+
+	where := func(condition string, value interface{}) {
+		args = append(args, value)
+		query += " AND " + condition + " ?"
+	}
+
+	if filter.ActorID != nil {
+		where("actor_id =", *filter.ActorID)
+	}
+	if filter.Action != nil {
+		where("action =", *filter.Action)
+	}
+	if filter.EntityType != nil {
+		where("entity_type =", *filter.EntityType)
+	}
+	if filter.EntityID != nil {
+		where("entity_id =", *filter.EntityID)
+	}
+	if filter.From != nil {
+		where("created_at >=", formatTimestamp(*filter.From))
+	}
+	if filter.To != nil {
+		where("created_at <", formatTimestamp(*filter.To))
+	}
+	if filter.BeforeID > 0 {
+		where("id <", filter.BeforeID)
+	}
+
+	args = append(args, limit)
+	query += " ORDER BY id DESC LIMIT ?"
+
+	rows, err := kp.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		kp.log.Info("error retrieving audit events from database: ", zap.Error(err))
+		return nil, err
+	}
+
+	return scanAuditEvents(rows)
+}
+
+// exportAudit returns the audit events performed by or about a user
+func exportAudit(ctx context.Context, tx *sql.Tx, userID int) ([]models.AuditEvent, error) {
+	query := `SELECT ` + auditColumns + `
+        FROM audit_events
+        WHERE actor_id = ?1 OR (entity_type = ?2 AND entity_id = ?1)
+        ORDER BY id
+    `
+
+	rows, err := tx.QueryContext(ctx, query, userID, audit.EntityUser)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanAuditEvents(rows)
+}
+
+func scanAuditEvents(rows *sql.Rows) ([]models.AuditEvent, error) {
+	defer rows.Close()
+
+	events := make([]models.AuditEvent, 0)
+	for rows.Next() {
+		var e models.AuditEvent
+		var before, after sql.NullString
+		var createdAt sql.NullString
+		err := rows.Scan(&e.ID, &e.ActorID, &e.Action, &e.EntityType, &e.EntityID, &before, &after,
+			&e.RequestID, &e.IP, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		if before.Valid {
+			e.Before = []byte(before.String)
+		}
+		if after.Valid {
+			e.After = []byte(after.String)
+		}
+		if e.CreatedAt, err = parseTime(createdAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+func nullInt(v int) *int {
+	if v == 0 {
+		return nil
+	}
+	return &v
+}
+
+func nullString(v string) *string {
+	if v == "" {
+		return nil
+	}
+	return &v
+}
+
+func nullJSON(v []byte) *string {
+	if v == nil {
+		return nil
+	}
+	s := string(v)
+	return &s
+}
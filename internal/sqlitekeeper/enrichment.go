@@ -0,0 +1,268 @@
+package sqlitekeeper
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/audit"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap"
+)
+
+// enrichmentColumns is the column list read by scanEnrichmentAttempt
+const enrichmentColumns = `user_id, status, attempts, last_error, next_retry_at, updated_at`
+
+// GetEnrichmentAttempt retrieves the enrichment state of a user
+func (kp *SQLiteKeeper) GetEnrichmentAttempt(ctx context.Context, userID int) (models.EnrichmentAttempt, error) {
+	query := `SELECT ` + enrichmentColumns + ` FROM enrichment_attempts WHERE user_id = ?`
+
+	a, err := scanEnrichmentAttempt(kp.db.QueryRowContext(ctx, query, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.EnrichmentAttempt{}, storage.ErrNotFound
+		}
+		kp.log.Info("error retrieving enrichment attempt from database: ", zap.Error(err))
+		return models.EnrichmentAttempt{}, err
+	}
+
+	return a, nil
+}
+
+// SaveEnrichmentAttempts creates or replaces the enrichment states, skipping deleted users.
+// The attempt ends the enrichment lease of the user.
+func (kp *SQLiteKeeper) SaveEnrichmentAttempts(ctx context.Context, attempts []models.EnrichmentAttempt) error {
+	if len(attempts) == 0 {
+		return nil
+	}
+
+	query := `
+        INSERT INTO enrichment_attempts (user_id, status, attempts, last_error, next_retry_at, updated_at)
+        SELECT ?, ?, ?, ?, ?, ?
+        WHERE EXISTS (SELECT 1 FROM users WHERE id = ?)
+        ON CONFLICT (user_id) DO UPDATE SET
+            status = excluded.status,
+            attempts = excluded.attempts,
+            last_error = excluded.last_error,
+            next_retry_at = excluded.next_retry_at,
+            updated_at = excluded.updated_at
+    `
+
+	err := kp.withTx(ctx, func(tx *sql.Tx) error {
+		for _, a := range attempts {
+			var nextRetryAt *string
+			if a.NextRetryAt != nil {
+				nextRetryAt = formatTimestamp(*a.NextRetryAt)
+			}
+
+			_, err := tx.ExecContext(ctx, query, a.UserID, a.Status, a.Attempts, nullString(a.LastError),
+				nextRetryAt, formatTimestamp(a.UpdatedAt), a.UserID)
+			if err != nil {
+				return err
+			}
+
+			if _, err := tx.ExecContext(ctx, `UPDATE users SET enrichment_leased_until = NULL WHERE id = ?`, a.UserID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		kp.log.Info("error saving enrichment attempts to database: ", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// GetEnrichmentAttempts lists the enrichment states with the statuses, or all of them,
+// most recently updated first
+func (kp *SQLiteKeeper) GetEnrichmentAttempts(ctx context.Context, statuses []string, limit int) ([]models.EnrichmentAttempt, error) {
+	query := `SELECT ` + enrichmentColumns + ` FROM enrichment_attempts`
+	args := make([]any, 0, len(statuses)+1)
+
+	if len(statuses) > 0 {
+		query += ` WHERE status IN (?` + strings.Repeat(`, ?`, len(statuses)-1) + `)`
+		for _, status := range statuses {
+			args = append(args, status)
+		}
+	}
+	query += ` ORDER BY updated_at DESC, user_id LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := kp.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		kp.log.Info("error retrieving enrichment attempts from database: ", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := make([]models.EnrichmentAttempt, 0)
+	for rows.Next() {
+		a, err := scanEnrichmentAttempt(rows)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+
+	return attempts, rows.Err()
+}
+
+// CountEnrichmentAttempts counts the enrichment states by status
+func (kp *SQLiteKeeper) CountEnrichmentAttempts(ctx context.Context) (map[string]int, error) {
+	rows, err := kp.db.QueryContext(ctx, `SELECT status, COUNT(*) FROM enrichment_attempts GROUP BY status`)
+	if err != nil {
+		kp.log.Info("error counting enrichment attempts in database: ", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+
+	return counts, rows.Err()
+}
+
+// ResyncUser forgets the enrichment state of a user, so that the next pass enriches it
+func (kp *SQLiteKeeper) ResyncUser(ctx context.Context, userID int) error {
+	err := kp.withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `UPDATE users SET last_checked_at = NULL WHERE id = ? AND erased_at IS NULL`, userID)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return storage.ErrNotFound
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM enrichment_attempts WHERE user_id = ?`, userID); err != nil {
+			return err
+		}
+
+		return kp.recordAudit(ctx, tx, audit.ActionResync, audit.EntityUser, userID, nil, nil)
+	})
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			kp.log.Info("error resyncing user in database: ", zap.Error(err))
+		}
+		return err
+	}
+
+	return nil
+}
+
+func scanEnrichmentAttempt(row interface{ Scan(...any) error }) (models.EnrichmentAttempt, error) {
+	var a models.EnrichmentAttempt
+	var lastError, nextRetryAt, updatedAt sql.NullString
+
+	if err := row.Scan(&a.UserID, &a.Status, &a.Attempts, &lastError, &nextRetryAt, &updatedAt); err != nil {
+		return models.EnrichmentAttempt{}, err
+	}
+
+	a.LastError = lastError.String
+
+	next, err := parseTime(nextRetryAt)
+	if err != nil {
+		return models.EnrichmentAttempt{}, err
+	}
+	if !next.IsZero() {
+		a.NextRetryAt = &next
+	}
+	if a.UpdatedAt, err = parseTime(updatedAt); err != nil {
+		return models.EnrichmentAttempt{}, err
+	}
+
+	return a, nil
+}
+
+// ClaimUser leases a user for enrichment regardless of when it was checked, e.g.
+// right after it registered. A user leased already is a conflict.
+func (kp *SQLiteKeeper) ClaimUser(ctx context.Context, userID int, lease time.Duration) (models.ExtUserData, error) {
+	now := time.Now()
+
+	var user models.ExtUserData
+	err := kp.withTx(ctx, func(tx *sql.Tx) error {
+		row := tx.QueryRowContext(ctx, `
+            UPDATE users SET enrichment_leased_until = ?
+            WHERE id = ? AND erased_at IS NULL AND passport_enc IS NOT NULL
+            AND (enrichment_leased_until IS NULL OR enrichment_leased_until <= ?)
+            RETURNING id, passport_enc, surname_enc, name_enc, address_enc
+        `, formatTimestamp(now.Add(lease)), userID, formatTimestamp(now))
+
+		var err error
+		user, err = kp.scanExtUserData(row)
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		var exists bool
+		if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = ? AND erased_at IS NULL)`, userID).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return storage.ErrConflict
+		}
+		return storage.ErrNotFound
+	})
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) && !errors.Is(err, storage.ErrConflict) {
+			kp.log.Info("error claiming user in database: ", zap.Error(err))
+		}
+		return models.ExtUserData{}, err
+	}
+
+	return user, nil
+}
+
+// scanExtUserData reads and decrypts the id, passport_enc, surname_enc, name_enc
+// and address_enc columns
+func (kp *SQLiteKeeper) scanExtUserData(row interface{ Scan(...any) error }) (models.ExtUserData, error) {
+	var user models.ExtUserData
+	var passport, surname, name, address sql.NullString
+	if err := row.Scan(&user.UserID, &passport, &surname, &name, &address); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return user, err
+		}
+		return user, fmt.Errorf("failed to scan user: %w", err)
+	}
+
+	plaintext, err := kp.open(passport)
+	if err != nil {
+		return user, err
+	}
+	if user.PassportSerie, user.PassportNumber, err = parsePassportKey(plaintext); err != nil {
+		return user, err
+	}
+	if user.Surname, err = kp.open(surname); err != nil {
+		return user, err
+	}
+	if user.Name, err = kp.open(name); err != nil {
+		return user, err
+	}
+	if user.Address, err = kp.open(address); err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
+
+// enrichableCondition selects the users whose enrichment state lets them be enriched,
+// the parameter is the current time
+const enrichableCondition = `NOT EXISTS (
+            SELECT 1 FROM enrichment_attempts a
+            WHERE a.user_id = users.id
+            AND (a.status = 'dead' OR (a.status = 'failed' AND a.next_retry_at > ?))
+        )`
@@ -0,0 +1,62 @@
+package sqlitekeeper
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/audit"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap"
+)
+
+// GetUserByIdentity retrieves the user linked to an identity provider subject
+func (kp *SQLiteKeeper) GetUserByIdentity(ctx context.Context, issuer, subject string) (models.User, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE id = (SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?)
+	`
+
+	user, err := kp.scanUser(kp.db.QueryRowContext(ctx, query, issuer, subject))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, storage.ErrNotFound
+		}
+		kp.log.Info("error retrieving user by identity from database: ", zap.Error(err))
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+// LinkIdentity links an identity provider subject to a user
+func (kp *SQLiteKeeper) LinkIdentity(ctx context.Context, identity models.UserIdentity) error {
+	query := `
+        INSERT INTO user_identities (user_id, issuer, subject, email, created_at)
+        VALUES (?, ?, ?, ?, ?)
+    `
+
+	err := kp.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query, identity.UserID, identity.Issuer, identity.Subject,
+			nullString(identity.Email), formatTimestamp(time.Now()))
+		if err != nil {
+			return err
+		}
+
+		return kp.recordAudit(ctx, tx, audit.ActionLink, audit.EntityIdentity, identity.UserID, nil,
+			map[string]interface{}{"issuer": identity.Issuer, "subject": identity.Subject, "email": identity.Email}, "email")
+	})
+	if err != nil {
+		if isUniqueViolation(err) {
+			return storage.ErrConflict
+		}
+		kp.log.Info("error linking identity in database: ", zap.Error(err))
+		return err
+	}
+
+	kp.log.Info("Identity linked successfully: ", zap.Int("userID", identity.UserID), zap.String("issuer", identity.Issuer))
+	return nil
+}
@@ -0,0 +1,163 @@
+package sqlitekeeper
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap"
+)
+
+// jobColumns is the column list read by scanJob
+const jobColumns = `id, kind, job_key, payload, status, attempts, run_at, locked_until, last_error, created_at`
+
+// EnqueueJob adds a job to the queue, to run once RunAt has come. A job with the
+// Key of a job that is not done yet is a conflict.
+func (kp *SQLiteKeeper) EnqueueJob(ctx context.Context, job models.Job) (int64, error) {
+	query := `
+        INSERT INTO jobs (kind, job_key, payload, run_at, created_at)
+        VALUES (?, ?, ?, ?, ?)
+    `
+
+	now := time.Now()
+	runAt := job.RunAt
+	if runAt.IsZero() {
+		runAt = now
+	}
+
+	payload := string(job.Payload)
+	if payload == "" {
+		payload = "{}"
+	}
+
+	result, err := kp.db.ExecContext(ctx, query, job.Kind, nullString(job.Key), payload, formatTimestamp(runAt), formatTimestamp(now))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, storage.ErrConflict
+		}
+		kp.log.Info("error enqueuing job to database: ", zap.Error(err))
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// ClaimJobs locks up to limit jobs that are due, or whose lock has expired, for the
+// visibility timeout and counts the attempt. SQLite serializes the writers, so the
+// update claims every job for one instance.
+func (kp *SQLiteKeeper) ClaimJobs(ctx context.Context, limit int, visibility time.Duration) ([]models.Job, error) {
+	query := `
+        UPDATE jobs SET status = 'running', attempts = attempts + 1, locked_until = ?
+        WHERE id IN (
+            SELECT id FROM jobs
+            WHERE (status = 'queued' AND run_at <= ?)
+            OR (status = 'running' AND locked_until <= ?)
+            ORDER BY run_at, id
+            LIMIT ?
+        )
+        RETURNING ` + jobColumns
+
+	now := time.Now()
+	rows, err := kp.db.QueryContext(ctx, query, formatTimestamp(now.Add(visibility)), formatTimestamp(now), formatTimestamp(now), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim jobs: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := make([]models.Job, 0)
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to process rows: %w", err)
+	}
+
+	// RETURNING doesn't keep the order of the subquery
+	sort.Slice(jobs, func(i, j int) bool {
+		if !jobs[i].RunAt.Equal(jobs[j].RunAt) {
+			return jobs[i].RunAt.Before(jobs[j].RunAt)
+		}
+		return jobs[i].ID < jobs[j].ID
+	})
+
+	return jobs, nil
+}
+
+// CompleteJob removes a finished job from the queue
+func (kp *SQLiteKeeper) CompleteJob(ctx context.Context, id int64) error {
+	return kp.execJob(ctx, "completing", `DELETE FROM jobs WHERE id = ?`, id)
+}
+
+// RetryJob puts a claimed job back in the queue to run at runAt, with the attempts it has made
+func (kp *SQLiteKeeper) RetryJob(ctx context.Context, id int64, attempts int, runAt time.Time, lastError string) error {
+	query := `
+        UPDATE jobs SET status = 'queued', attempts = ?, run_at = ?, locked_until = NULL, last_error = ?
+        WHERE id = ?
+    `
+	return kp.execJob(ctx, "retrying", query, attempts, formatTimestamp(runAt), nullString(lastError), id)
+}
+
+// BuryJob marks a job that ran out of attempts as dead, keeping it for inspection
+func (kp *SQLiteKeeper) BuryJob(ctx context.Context, id int64, lastError string) error {
+	query := `UPDATE jobs SET status = 'dead', locked_until = NULL, last_error = ? WHERE id = ?`
+	return kp.execJob(ctx, "burying", query, nullString(lastError), id)
+}
+
+// execJob runs a statement changing a single job, a missing job is not found
+func (kp *SQLiteKeeper) execJob(ctx context.Context, action, query string, args ...any) error {
+	result, err := kp.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		kp.log.Info("error "+action+" job in database: ", zap.Error(err))
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+func scanJob(row interface{ Scan(...any) error }) (models.Job, error) {
+	var job models.Job
+	var key, lastError, runAt, lockedUntil, createdAt sql.NullString
+	var payload string
+
+	err := row.Scan(&job.ID, &job.Kind, &key, &payload, &job.Status, &job.Attempts,
+		&runAt, &lockedUntil, &lastError, &createdAt)
+	if err != nil {
+		return job, fmt.Errorf("failed to scan job: %w", err)
+	}
+
+	job.Key, job.LastError, job.Payload = key.String, lastError.String, []byte(payload)
+
+	if job.RunAt, err = parseTime(runAt); err != nil {
+		return job, err
+	}
+	if job.CreatedAt, err = parseTime(createdAt); err != nil {
+		return job, err
+	}
+	if lockedUntil.Valid {
+		t, err := parseTime(lockedUntil)
+		if err != nil {
+			return job, err
+		}
+		job.LockedUntil = &t
+	}
+
+	return job, nil
+}
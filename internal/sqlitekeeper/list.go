@@ -0,0 +1,150 @@
+package sqlitekeeper
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+)
+
+// GetUsers returns a page of users matching the filter and the number of all matching
+// users. Personal data is encrypted, so only the passport and the timezone are filtered
+// in SQL, while the remaining fields are filtered and sorted after decryption.
+func (kp *SQLiteKeeper) GetUsers(ctx context.Context, filter models.Filter, pagination models.Pagination) ([]models.User, int, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE erased_at IS NULL`
+	var args []interface{}
+
+	// The blind index covers the whole passport
+	if filter.PassportSerie != nil && filter.PassportNumber != nil {
+		query += " AND passport_bidx = ?"
+		args = append(args, kp.passportIndex(*filter.PassportSerie, *filter.PassportNumber))
+	}
+	// instr is case-sensitive like strings.Contains, unlike LIKE
+	if filter.Timezone != nil {
+		query += " AND instr(timezone, ?) > 0"
+		args = append(args, *filter.Timezone)
+	}
+
+	rows, err := kp.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get users: %w", err)
+	}
+	defer rows.Close()
+
+	users := make([]models.User, 0)
+	for rows.Next() {
+		user, err := kp.scanUser(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to get users: %w", err)
+		}
+
+		if storage.MatchUser(user, filter) {
+			users = append(users, user)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to get users: %w", err)
+	}
+
+	users, total := storage.PageUsers(users, pagination)
+	return users, total, nil
+}
+
+// taskSortColumns are the SQL expressions of the task sort fields. They sort missing
+// values like the memory storage sorts zero values.
+var taskSortColumns = map[string]string{
+	"id":          "id",
+	"name":        "name",
+	"description": "COALESCE(description, '')",
+	"created_at":  "COALESCE(created_at, '0001-01-01T00:00:00.000000Z')",
+}
+
+// GetTasks returns a page of tasks matching the filter, sorted as the pagination asks,
+// and the number of all matching tasks
+func (kp *SQLiteKeeper) GetTasks(ctx context.Context, filter models.TaskFilter, pagination models.Pagination) ([]models.Task, int, error) {
+	where := " WHERE 1 = 1"
+	var args []interface{}
+
+	if filter.Name != nil {
+		where += " AND instr(name, ?) > 0"
+		args = append(args, *filter.Name)
+	}
+	if filter.Description != nil {
+		where += " AND instr(COALESCE(description, ''), ?) > 0"
+		args = append(args, *filter.Description)
+	}
+
+	var total int
+	if err := kp.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM tasks"+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count tasks: %w", err)
+	}
+
+	query := "SELECT id, name, COALESCE(description, ''), created_at FROM tasks" + where
+	orderBy := make([]string, 0, len(pagination.Sort)+1)
+	for _, f := range pagination.Sort {
+		orderBy = append(orderBy, taskSortColumns[f.Field]+direction(f.Desc))
+	}
+	orderBy = append(orderBy, "id")
+	offset := max(pagination.Offset, 0)
+
+	// A cursor continues after the last task of the previous page:
+	// (a > ?) OR (a = ? AND b > ?) OR ... OR (a = ? AND b = ? AND id > ?)
+	if after := pagination.After; after != nil {
+		var keyset []string
+		equal := ""
+		for i, f := range pagination.Sort {
+			op := " > ?"
+			if f.Desc {
+				op = " < ?"
+			}
+			keyset = append(keyset, "("+equal+taskSortColumns[f.Field]+op+")")
+			args = append(args, after.Values[:i+1]...)
+			equal += taskSortColumns[f.Field] + " = ? AND "
+		}
+		keyset = append(keyset, "("+equal+"id > ?)")
+		args = append(args, after.Values...)
+		args = append(args, after.ID)
+
+		query += " AND (" + strings.Join(keyset, " OR ") + ")"
+		offset = 0
+	}
+
+	query += " ORDER BY " + strings.Join(orderBy, ", ") + " LIMIT ? OFFSET ?"
+	args = append(args, max(pagination.Limit, 0), offset)
+
+	rows, err := kp.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get tasks: %w", err)
+	}
+	defer rows.Close()
+
+	tasks := make([]models.Task, 0)
+	for rows.Next() {
+		var t models.Task
+		var createdAt sql.NullString
+		if err := rows.Scan(&t.ID, &t.Name, &t.Description, &createdAt); err != nil {
+			return nil, 0, fmt.Errorf("failed to get tasks: %w", err)
+		}
+		if t.CreatedAt, err = parseTime(createdAt); err != nil {
+			return nil, 0, fmt.Errorf("failed to get tasks: %w", err)
+		}
+		tasks = append(tasks, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to get tasks: %w", err)
+	}
+
+	return tasks, total, nil
+}
+
+func direction(desc bool) string {
+	if desc {
+		return " DESC"
+	}
+	return ""
+}
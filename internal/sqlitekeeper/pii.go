@@ -0,0 +1,209 @@
+package sqlitekeeper
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/models"
+)
+
+// FieldCipher encrypts personal data stored in the users table
+type FieldCipher interface {
+	Seal(string) (string, error)
+	Open(string) (string, error)
+	BlindIndex(string) string
+}
+
+// timestampLayout has a fixed width, so that UTC timestamps compare as strings
+const timestampLayout = "2006-01-02T15:04:05.000000Z"
+
+// userColumns is the column list read by scanUser
+const userColumns = `id, passport_enc, surname_enc, name_enc, patronymic_enc, address_enc,
+	default_end_time, timezone, password_hash, last_checked_at, role`
+
+// passportKey is the canonical form of a passport, as used for login
+func passportKey(passportSerie, passportNumber int) string {
+	return fmt.Sprintf("%d %d", passportSerie, passportNumber)
+}
+
+// parsePassportKey splits a value produced by passportKey
+func parsePassportKey(value string) (int, int, error) {
+	parts := strings.Fields(value)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid passport value")
+	}
+
+	passportSerie, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid passport serie: %w", err)
+	}
+
+	passportNumber, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid passport number: %w", err)
+	}
+
+	return passportSerie, passportNumber, nil
+}
+
+// passportIndex returns the blind index used to look up a user by passport
+func (kp *SQLiteKeeper) passportIndex(passportSerie, passportNumber int) string {
+	return kp.cipher.BlindIndex(passportKey(passportSerie, passportNumber))
+}
+
+// seal encrypts a value, empty values are stored as NULL
+func (kp *SQLiteKeeper) seal(value string) (*string, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	sealed, err := kp.cipher.Seal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt user data: %w", err)
+	}
+
+	return &sealed, nil
+}
+
+// open decrypts a value written by seal
+func (kp *SQLiteKeeper) open(value sql.NullString) (string, error) {
+	if !value.Valid {
+		return "", nil
+	}
+
+	plaintext, err := kp.cipher.Open(value.String)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt user data: %w", err)
+	}
+
+	return plaintext, nil
+}
+
+// sealedUser holds the encrypted personal data of a user
+type sealedUser struct {
+	passport, surname, name, patronymic, address *string
+}
+
+func (kp *SQLiteKeeper) sealUser(user models.User) (sealedUser, error) {
+	var sealed sealedUser
+	var err error
+
+	if sealed.passport, err = kp.seal(passportKey(user.PassportSerie, user.PassportNumber)); err != nil {
+		return sealedUser{}, err
+	}
+	if sealed.surname, err = kp.seal(user.Surname); err != nil {
+		return sealedUser{}, err
+	}
+	if sealed.name, err = kp.seal(user.Name); err != nil {
+		return sealedUser{}, err
+	}
+	if sealed.patronymic, err = kp.seal(user.Patronymic); err != nil {
+		return sealedUser{}, err
+	}
+	if sealed.address, err = kp.seal(user.Address); err != nil {
+		return sealedUser{}, err
+	}
+
+	return sealed, nil
+}
+
+// scanUser reads a users row selected with userColumns
+func (kp *SQLiteKeeper) scanUser(row interface{ Scan(...any) error }) (models.User, error) {
+	var user models.User
+	var passport, surname, name, patronymic, address sql.NullString
+	var defaultEndTime, timezone, hashHex, lastCheckedAt sql.NullString
+
+	err := row.Scan(
+		&user.UUID,
+		&passport,
+		&surname,
+		&name,
+		&patronymic,
+		&address,
+		&defaultEndTime,
+		&timezone,
+		&hashHex,
+		&lastCheckedAt,
+		&user.Role,
+	)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	plaintext, err := kp.open(passport)
+	if err != nil {
+		return models.User{}, err
+	}
+	if plaintext != "" {
+		if user.PassportSerie, user.PassportNumber, err = parsePassportKey(plaintext); err != nil {
+			return models.User{}, err
+		}
+	}
+	if user.Surname, err = kp.open(surname); err != nil {
+		return models.User{}, err
+	}
+	if user.Name, err = kp.open(name); err != nil {
+		return models.User{}, err
+	}
+	if user.Patronymic, err = kp.open(patronymic); err != nil {
+		return models.User{}, err
+	}
+	if user.Address, err = kp.open(address); err != nil {
+		return models.User{}, err
+	}
+
+	// Decoding the hash from hex string to bytes, if the value is not NULL
+	if hashHex.Valid {
+		if user.Hash, err = hex.DecodeString(hashHex.String); err != nil {
+			return models.User{}, fmt.Errorf("failed to decode password hash: %w", err)
+		}
+	}
+
+	if user.DefaultEndTime, err = parseTime(defaultEndTime); err != nil {
+		return models.User{}, err
+	}
+	if user.LastCheckedAt, err = parseTime(lastCheckedAt); err != nil {
+		return models.User{}, err
+	}
+	user.Timezone = timezone.String
+
+	return user, nil
+}
+
+// formatTimestamp formats a point in time for a timestamp column, zero times are stored as NULL
+func formatTimestamp(t time.Time) *string {
+	if t.IsZero() {
+		return nil
+	}
+
+	s := t.UTC().Format(timestampLayout)
+	return &s
+}
+
+// formatTime formats a time keeping its offset, as a TIME WITH TIME ZONE column would
+func formatTime(t time.Time) *string {
+	if t.IsZero() {
+		return nil
+	}
+
+	s := t.Format(time.RFC3339Nano)
+	return &s
+}
+
+// parseTime reads a value written by formatTimestamp or formatTime
+func parseTime(value sql.NullString) (time.Time, error) {
+	if !value.Valid || value.String == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, value.String)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse time: %w", err)
+	}
+
+	return t, nil
+}
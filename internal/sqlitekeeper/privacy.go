@@ -0,0 +1,194 @@
+package sqlitekeeper
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/audit"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap"
+)
+
+// ExportUser collects the personal data of a user from a single snapshot of the database
+func (kp *SQLiteKeeper) ExportUser(ctx context.Context, userID int) (export models.UserExport, err error) {
+	tx, err := kp.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		kp.log.Info("Error while beginning transaction: ", zap.Error(err))
+		return models.UserExport{}, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	export.Profile, err = kp.getUserByID(ctx, tx, userID)
+	if err != nil {
+		return models.UserExport{}, err
+	}
+
+	if export.TimeEntries, err = exportTimeEntries(ctx, tx, userID); err != nil {
+		kp.log.Info("error retrieving time entries for export: ", zap.Error(err))
+		return models.UserExport{}, err
+	}
+
+	if export.Tokens, err = exportTokens(ctx, tx, userID); err != nil {
+		kp.log.Info("error retrieving tokens for export: ", zap.Error(err))
+		return models.UserExport{}, err
+	}
+
+	if export.Audit, err = exportAudit(ctx, tx, userID); err != nil {
+		kp.log.Info("error retrieving audit events for export: ", zap.Error(err))
+		return models.UserExport{}, err
+	}
+
+	return export, nil
+}
+
+func exportTimeEntries(ctx context.Context, tx *sql.Tx, userID int) ([]models.TimeEntryRecord, error) {
+	query := `
+		SELECT ut.id, ut.task_id, COALESCE(t.name, ''), COALESCE(ut.event_date, ''), ut.start_time, ut.end_time
+		FROM user_tasks ut
+		LEFT JOIN tasks t ON t.id = ut.task_id
+		WHERE ut.user_id = ?
+		ORDER BY ut.event_date, ut.id
+	`
+
+	rows, err := tx.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]models.TimeEntryRecord, 0)
+	for rows.Next() {
+		var e models.TimeEntryRecord
+		var start, end sql.NullString
+		if err := rows.Scan(&e.ID, &e.TaskID, &e.TaskName, &e.EventDate, &start, &end); err != nil {
+			return nil, err
+		}
+
+		// Times are exported like the TIME WITH TIME ZONE columns of PostgreSQL
+		for _, field := range []struct {
+			value  sql.NullString
+			target *string
+		}{{start, &e.StartTime}, {end, &e.EndTime}} {
+			t, err := parseTime(field.value)
+			if err != nil {
+				return nil, err
+			}
+			if !t.IsZero() {
+				*field.target = formatTimeOfDay(t)
+			}
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+// exportTokens lists the credentials of a user. JWTs are stateless and not stored.
+func exportTokens(ctx context.Context, tx *sql.Tx, userID int) ([]models.TokenMetadata, error) {
+	query := `
+		SELECT 'totp', '', '', '', enabled, created_at, confirmed_at
+		FROM user_two_factor
+		WHERE user_id = ?1
+		UNION ALL
+		SELECT 'recovery_code', '', '', '', used_at IS NULL, NULL, used_at
+		FROM user_recovery_codes
+		WHERE user_id = ?1
+		UNION ALL
+		SELECT 'oidc', issuer, subject, COALESCE(email, ''), 1, created_at, NULL
+		FROM user_identities
+		WHERE user_id = ?1
+	`
+
+	rows, err := tx.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]models.TokenMetadata, 0)
+	for rows.Next() {
+		var t models.TokenMetadata
+		var createdAt, usedAt sql.NullString
+		if err := rows.Scan(&t.Type, &t.Issuer, &t.Subject, &t.Email, &t.Enabled, &createdAt, &usedAt); err != nil {
+			return nil, err
+		}
+		if t.CreatedAt, err = parseOptionalTime(createdAt); err != nil {
+			return nil, err
+		}
+		if t.UsedAt, err = parseOptionalTime(usedAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+
+	return tokens, rows.Err()
+}
+
+// parseOptionalTime is parseTime for nullable fields of the export
+func parseOptionalTime(value sql.NullString) (*time.Time, error) {
+	t, err := parseTime(value)
+	if err != nil || t.IsZero() {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// EraseUser removes the personal data and credentials of a user. The user row
+// and the time entries stay, so that aggregated reports do not change.
+func (kp *SQLiteKeeper) EraseUser(ctx context.Context, userID int) error {
+	anonymize := `
+		UPDATE users SET
+			passport_bidx = NULL,
+			passport_enc = NULL,
+			surname_enc = NULL,
+			name_enc = NULL,
+			patronymic_enc = NULL,
+			address_enc = NULL,
+			password_hash = NULL,
+			erased_at = ?
+		WHERE id = ?
+	`
+
+	err := kp.withTx(ctx, func(tx *sql.Tx) error {
+		// The current values are only needed to record which fields the erasure changed
+		current, err := kp.getUserByID(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, anonymize, formatTimestamp(time.Now()), userID); err != nil {
+			return err
+		}
+
+		for _, query := range []string{
+			`DELETE FROM user_recovery_codes WHERE user_id = ?`,
+			`DELETE FROM user_two_factor WHERE user_id = ?`,
+			`DELETE FROM user_identities WHERE user_id = ?`,
+			`DELETE FROM enrichment_attempts WHERE user_id = ?`,
+		} {
+			if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+				return err
+			}
+		}
+
+		return kp.recordAudit(ctx, tx, audit.ActionErase, audit.EntityUser, userID,
+			audit.UserSnapshot(current), audit.ErasedUserSnapshot(current),
+			audit.SensitiveUserFields...)
+	})
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			kp.log.Info("error erasing user in database: ", zap.Error(err))
+		}
+		return err
+	}
+
+	kp.log.Info("User erased successfully", zap.Int("id", userID))
+	return nil
+}
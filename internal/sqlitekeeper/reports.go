@@ -0,0 +1,148 @@
+package sqlitekeeper
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap"
+)
+
+// reportColumns is the column list read by scanReportSubscription
+const reportColumns = `id, user_id, schedule, report_type, recipients, format, timezone, last_sent_at, created_at`
+
+// SaveReportSubscription creates a report subscription and returns its id. The
+// recipients are stored as a JSON array.
+func (kp *SQLiteKeeper) SaveReportSubscription(ctx context.Context, sub models.ReportSubscription) (int, error) {
+	query := `
+        INSERT INTO report_subscriptions (user_id, schedule, report_type, recipients, format, timezone, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+    `
+
+	recipients, err := json.Marshal(sub.Recipients)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode recipients: %w", err)
+	}
+
+	result, err := kp.db.ExecContext(ctx, query, sub.UserID, sub.Schedule, sub.ReportType, string(recipients),
+		sub.Format, sub.Timezone, formatTimestamp(time.Now()))
+	if err != nil {
+		kp.log.Info("error saving report subscription to database: ", zap.Error(err))
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// GetReportSubscription retrieves a report subscription by its id
+func (kp *SQLiteKeeper) GetReportSubscription(ctx context.Context, id int) (models.ReportSubscription, error) {
+	query := `SELECT ` + reportColumns + ` FROM report_subscriptions WHERE id = ?`
+
+	sub, err := scanReportSubscription(kp.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.ReportSubscription{}, storage.ErrNotFound
+		}
+		kp.log.Info("error retrieving report subscription from database: ", zap.Error(err))
+		return models.ReportSubscription{}, err
+	}
+
+	return sub, nil
+}
+
+// GetReportSubscriptions lists the report subscriptions in the order they were created
+func (kp *SQLiteKeeper) GetReportSubscriptions(ctx context.Context) ([]models.ReportSubscription, error) {
+	rows, err := kp.db.QueryContext(ctx, `SELECT `+reportColumns+` FROM report_subscriptions ORDER BY id`)
+	if err != nil {
+		kp.log.Info("error retrieving report subscriptions from database: ", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := make([]models.ReportSubscription, 0)
+	for rows.Next() {
+		sub, err := scanReportSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		subs = append(subs, sub)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to process rows: %w", err)
+	}
+
+	return subs, nil
+}
+
+// DeleteReportSubscription deletes a report subscription, its queued reports are not sent
+func (kp *SQLiteKeeper) DeleteReportSubscription(ctx context.Context, id int) error {
+	return kp.execReportSubscription(ctx, "deleting", `DELETE FROM report_subscriptions WHERE id = ?`, id)
+}
+
+// MarkReportSent records when the report of a subscription was last sent
+func (kp *SQLiteKeeper) MarkReportSent(ctx context.Context, id int, sentAt time.Time) error {
+	query := `UPDATE report_subscriptions SET last_sent_at = ? WHERE id = ?`
+	return kp.execReportSubscription(ctx, "marking sent", query, formatTimestamp(sentAt), id)
+}
+
+// execReportSubscription runs a statement changing a single subscription, a missing one is not found
+func (kp *SQLiteKeeper) execReportSubscription(ctx context.Context, action, query string, args ...any) error {
+	result, err := kp.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		kp.log.Info("error "+action+" report subscription in database: ", zap.Error(err))
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+func scanReportSubscription(row interface{ Scan(...any) error }) (models.ReportSubscription, error) {
+	var sub models.ReportSubscription
+	var recipients string
+	var lastSentAt, createdAt sql.NullString
+
+	err := row.Scan(&sub.ID, &sub.UserID, &sub.Schedule, &sub.ReportType, &recipients,
+		&sub.Format, &sub.Timezone, &lastSentAt, &createdAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return sub, err
+		}
+		return sub, fmt.Errorf("failed to scan report subscription: %w", err)
+	}
+
+	if err := json.Unmarshal([]byte(recipients), &sub.Recipients); err != nil {
+		return sub, fmt.Errorf("failed to decode recipients: %w", err)
+	}
+	if sub.CreatedAt, err = parseTime(createdAt); err != nil {
+		return sub, err
+	}
+	if lastSentAt.Valid {
+		t, err := parseTime(lastSentAt)
+		if err != nil {
+			return sub, err
+		}
+		sub.LastSentAt = &t
+	}
+
+	return sub, nil
+}
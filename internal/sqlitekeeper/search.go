@@ -0,0 +1,59 @@
+package sqlitekeeper
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/search"
+)
+
+// Search returns the tasks and users best matching the query. SQLite keeps small
+// databases, so all tasks and users are scored, users after decryption.
+func (kp *SQLiteKeeper) Search(ctx context.Context, query string, limit int) ([]models.SearchResult, error) {
+	terms := search.Terms(query)
+	results := make([]models.SearchResult, 0)
+	if len(terms) == 0 {
+		return results, nil
+	}
+
+	rows, err := kp.db.QueryContext(ctx, `SELECT id, name, COALESCE(description, '') FROM tasks`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search tasks: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t models.Task
+		if err := rows.Scan(&t.ID, &t.Name, &t.Description); err != nil {
+			return nil, fmt.Errorf("failed to search tasks: %w", err)
+		}
+		if rank := search.Score(terms, search.TaskFields(t)...); rank > 0 {
+			results = append(results, search.TaskResult(t, rank))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to search tasks: %w", err)
+	}
+
+	users, err := kp.db.QueryContext(ctx, `SELECT `+userColumns+` FROM users WHERE erased_at IS NULL`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search users: %w", err)
+	}
+	defer users.Close()
+
+	for users.Next() {
+		user, err := kp.scanUser(users)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search users: %w", err)
+		}
+		if rank := search.Score(terms, search.UserFields(user)...); rank > 0 {
+			results = append(results, search.UserResult(user, rank))
+		}
+	}
+	if err := users.Err(); err != nil {
+		return nil, fmt.Errorf("failed to search users: %w", err)
+	}
+
+	return search.Top(results, limit), nil
+}
@@ -0,0 +1,863 @@
+// Package sqlitekeeper implements storage.Keeper on an embedded SQLite database,
+// for installations that do not run PostgreSQL. The driver is pure Go, no cgo is needed.
+package sqlitekeeper
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	migratesqlite "github.com/golang-migrate/migrate/v4/database/sqlite"
+	_ "github.com/golang-migrate/migrate/v4/source/file" // registers a migrate driver.
+	"github.com/wurt83ow/timetracker/internal/audit"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Scheme is the DSN prefix that selects the SQLite keeper
+const Scheme = "sqlite://"
+
+type Log interface {
+	Info(string, ...zapcore.Field)
+}
+
+type SQLiteKeeper struct {
+	db                 *sql.DB
+	log                Log
+	userUpdateInterval func() string
+	cipher             FieldCipher
+}
+
+// NewSQLiteKeeper opens the database file of a sqlite:// DSN and migrates it
+func NewSQLiteKeeper(dsn func() string, log Log, userUpdateInterval func() string, cipher FieldCipher) *SQLiteKeeper {
+	path := strings.TrimPrefix(dsn(), Scheme)
+	if path == "" {
+		log.Info("sqlite database path is empty")
+		return nil
+	}
+
+	// Foreign keys are off in SQLite unless enabled for every connection
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		log.Info("Unable to open sqlite database: ", zap.Error(err))
+		return nil
+	}
+
+	// SQLite has a single writer, one connection avoids SQLITE_BUSY between transactions
+	db.SetMaxOpenConns(1)
+
+	driver, err := migratesqlite.WithInstance(db, &migratesqlite.Config{})
+	if err != nil {
+		log.Info("Error getting driver: ", zap.Error(err))
+		return nil
+	}
+
+	dir, err := migrationsDir()
+	if err != nil {
+		log.Info("Error finding migrations: ", zap.Error(err))
+		return nil
+	}
+
+	m, err := migrate.NewWithDatabaseInstance("file://"+dir, "sqlite", driver)
+	if err != nil {
+		log.Info("Error creating migration instance: ", zap.Error(err))
+		return nil
+	}
+
+	err = m.Up()
+	if err != nil && err != migrate.ErrNoChange {
+		log.Info("Error while performing migration: ", zap.Error(err))
+		return nil
+	}
+
+	log.Info("Connected to sqlite!")
+
+	return &SQLiteKeeper{
+		db:                 db,
+		log:                log,
+		userUpdateInterval: userUpdateInterval,
+		cipher:             cipher,
+	}
+}
+
+// migrationsDir finds migrations/sqlite in the working directory or one of its parents,
+// so that the keeper also starts from cmd/timetracker and from package tests
+func migrationsDir() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		path := filepath.Join(dir, "migrations", "sqlite")
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("migrations/sqlite directory not found")
+		}
+		dir = parent
+	}
+}
+
+// withTx runs fn in a transaction that is committed if fn succeeds
+func (kp *SQLiteKeeper) withTx(ctx context.Context, fn func(*sql.Tx) error) (err error) {
+	tx, err := kp.db.BeginTx(ctx, nil)
+	if err != nil {
+		kp.log.Info("Error while beginning transaction: ", zap.Error(err))
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			_ = tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	return fn(tx)
+}
+
+// isUniqueViolation reports whether err is a UNIQUE constraint failure
+func isUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+func (kp *SQLiteKeeper) Close() bool {
+	if err := kp.db.Close(); err != nil {
+		kp.log.Info("error closing sqlite database: ", zap.Error(err))
+		return false
+	}
+
+	return true
+}
+
+func (kp *SQLiteKeeper) Ping(ctx context.Context) bool {
+	return kp.db.PingContext(ctx) == nil
+}
+
+func (kp *SQLiteKeeper) SaveUser(ctx context.Context, user models.User) (userID int, err error) {
+	sealed, err := kp.sealUser(user)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `
+        INSERT INTO users (
+            passport_bidx, passport_enc, surname_enc, name_enc, patronymic_enc, address_enc,
+            default_end_time, timezone, password_hash
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+        RETURNING id
+    `
+
+	err = kp.withTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(
+			ctx,
+			query,
+			kp.passportIndex(user.PassportSerie, user.PassportNumber),
+			sealed.passport,
+			sealed.surname,
+			sealed.name,
+			sealed.patronymic,
+			sealed.address,
+			formatTime(user.DefaultEndTime),
+			user.Timezone,
+			hex.EncodeToString(user.Hash),
+		).Scan(&userID)
+		if err != nil {
+			return err
+		}
+
+		return kp.recordAudit(ctx, tx, audit.ActionCreate, audit.EntityUser, userID, nil, audit.UserSnapshot(user), audit.SensitiveUserFields...)
+	})
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, storage.ErrConflict
+		}
+		kp.log.Info("error saving user to database: ", zap.Error(err))
+		return 0, err
+	}
+
+	kp.log.Info("User saved successfully: ", zap.Int("userID", userID))
+	return userID, nil
+}
+
+// GetUser retrieves a user by passport using the blind index. A missing user
+// is returned as a zero value, as BDKeeper does.
+func (kp *SQLiteKeeper) GetUser(ctx context.Context, passportSerie, passportNumber int) (models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE passport_bidx = ?`
+
+	user, err := kp.scanUser(kp.db.QueryRowContext(ctx, query, kp.passportIndex(passportSerie, passportNumber)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			kp.log.Info("user not found by passport")
+			return models.User{}, nil
+		}
+		kp.log.Info("error retrieving user from database: ", zap.Error(err))
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+// GetUserByID retrieves a user by the internal identifier
+func (kp *SQLiteKeeper) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	return kp.getUserByID(ctx, kp.db, id)
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	QueryRowContext(context.Context, string, ...any) *sql.Row
+}
+
+func (kp *SQLiteKeeper) getUserByID(ctx context.Context, q querier, id int) (models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = ? AND erased_at IS NULL`
+
+	user, err := kp.scanUser(q.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, storage.ErrNotFound
+		}
+		kp.log.Info("error retrieving user by id from database: ", zap.Error(err))
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+func (kp *SQLiteKeeper) UpdateUsersInfo(ctx context.Context, users []models.ExtUserData) ([]int, error) {
+	if len(users) == 0 {
+		return nil, nil
+	}
+
+	thresholdTime, err := kp.updateThreshold()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+        UPDATE users SET
+            surname_enc = COALESCE(?, surname_enc),
+            name_enc = COALESCE(?, name_enc),
+            patronymic_enc = COALESCE(?, patronymic_enc),
+            address_enc = COALESCE(?, address_enc),
+            last_checked_at = ?
+        WHERE passport_bidx = ?
+        AND (last_checked_at IS NULL OR last_checked_at <= ?)
+        RETURNING id
+    `
+
+	now := formatTimestamp(time.Now())
+	threshold := formatTimestamp(thresholdTime)
+
+	var ids []int
+	err = kp.withTx(ctx, func(tx *sql.Tx) error {
+		ids = ids[:0]
+		for _, user := range users {
+			surname, err := kp.seal(user.Surname)
+			if err != nil {
+				return err
+			}
+			name, err := kp.seal(user.Name)
+			if err != nil {
+				return err
+			}
+			patronymic, err := kp.seal(user.Patronymic)
+			if err != nil {
+				return err
+			}
+			address, err := kp.seal(user.Address)
+			if err != nil {
+				return err
+			}
+
+			var id int
+			err = tx.QueryRowContext(ctx, query, surname, name, patronymic, address, now,
+				kp.passportIndex(user.PassportSerie, user.PassportNumber), threshold).Scan(&id)
+			if errors.Is(err, sql.ErrNoRows) {
+				// Unknown or recently checked user
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			before, after := audit.EnrichedUserSnapshot(user)
+			if err := kp.recordAudit(ctx, tx, audit.ActionUpdate, audit.EntityUser, id, before, after, audit.SensitiveUserFields...); err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+
+		return nil
+	})
+	if err != nil {
+		kp.log.Info("Error during batch updating user data in the database: ", zap.Error(err))
+		return nil, err
+	}
+
+	kp.log.Info("User data successfully updated")
+	return ids, nil
+}
+
+func (kp *SQLiteKeeper) UpdateUser(ctx context.Context, user models.User) error {
+	var set []string
+	var args []interface{}
+
+	add := func(column string, value interface{}) {
+		set = append(set, column+" = ?")
+		args = append(args, value)
+	}
+
+	err := kp.withTx(ctx, func(tx *sql.Tx) error {
+		current, err := kp.getUserByID(ctx, tx, user.UUID)
+		if err != nil {
+			return err
+		}
+
+		// The updated user as it will be stored, used for the audit log
+		updated := current
+
+		if user.PassportSerie != 0 || user.PassportNumber != 0 {
+			// The blind index covers the whole passport, so a partial change needs the stored one
+			if user.PassportSerie != 0 {
+				updated.PassportSerie = user.PassportSerie
+			}
+			if user.PassportNumber != 0 {
+				updated.PassportNumber = user.PassportNumber
+			}
+
+			passport, err := kp.seal(passportKey(updated.PassportSerie, updated.PassportNumber))
+			if err != nil {
+				return err
+			}
+			add("passport_bidx", kp.passportIndex(updated.PassportSerie, updated.PassportNumber))
+			add("passport_enc", passport)
+
+			// A new passport is enriched again at once, the last check was of the old one
+			if storage.PassportChanged(current, updated) && user.LastCheckedAt.IsZero() {
+				add("last_checked_at", nil)
+			}
+		}
+
+		for _, field := range []struct {
+			column, value string
+			target        *string
+		}{
+			{"surname_enc", user.Surname, &updated.Surname},
+			{"name_enc", user.Name, &updated.Name},
+			{"patronymic_enc", user.Patronymic, &updated.Patronymic},
+			{"address_enc", user.Address, &updated.Address},
+		} {
+			if field.value == "" {
+				continue
+			}
+			sealed, err := kp.seal(field.value)
+			if err != nil {
+				return err
+			}
+			add(field.column, sealed)
+			*field.target = field.value
+		}
+
+		if !user.DefaultEndTime.IsZero() {
+			add("default_end_time", formatTime(user.DefaultEndTime))
+			updated.DefaultEndTime = user.DefaultEndTime
+		}
+		if user.Timezone != "" {
+			add("timezone", user.Timezone)
+			updated.Timezone = user.Timezone
+		}
+		if len(user.Hash) > 0 {
+			add("password_hash", hex.EncodeToString(user.Hash))
+			updated.Hash = user.Hash
+		}
+		if !user.LastCheckedAt.IsZero() {
+			add("last_checked_at", formatTimestamp(user.LastCheckedAt))
+		}
+
+		if len(set) == 0 {
+			// Nothing to update
+			return nil
+		}
+
+		query := "UPDATE users SET " + strings.Join(set, ", ") + " WHERE id = ?"
+		if _, err := tx.ExecContext(ctx, query, append(args, user.UUID)...); err != nil {
+			return err
+		}
+
+		return kp.recordAudit(ctx, tx, audit.ActionUpdate, audit.EntityUser, user.UUID,
+			audit.UserSnapshot(current), audit.UserSnapshot(updated), audit.SensitiveUserFields...)
+	})
+	if err != nil {
+		if isUniqueViolation(err) {
+			// Another user already has this passport
+			return storage.ErrConflict
+		}
+		if errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+		kp.log.Info("Error updating user data in the database: ", zap.Error(err))
+		return err
+	}
+
+	kp.log.Info("User data successfully updated")
+	return nil
+}
+
+func (kp *SQLiteKeeper) LoadUsers(ctx context.Context) (storage.StorageUsers, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE erased_at IS NULL`
+
+	rows, err := kp.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load users: %w", err)
+	}
+	defer rows.Close()
+
+	data := make(storage.StorageUsers)
+	for rows.Next() {
+		user, err := kp.scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load users: %w", err)
+		}
+		data[user.UUID] = user
+	}
+
+	return data, rows.Err()
+}
+
+func (kp *SQLiteKeeper) DeleteUser(ctx context.Context, id int) error {
+	// Time entries, two-factor settings and identities are removed by ON DELETE CASCADE
+	err := kp.withTx(ctx, func(tx *sql.Tx) error {
+		current, err := kp.getUserByID(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, id); err != nil {
+			return err
+		}
+
+		return kp.recordAudit(ctx, tx, audit.ActionDelete, audit.EntityUser, id, audit.UserSnapshot(current), nil, audit.SensitiveUserFields...)
+	})
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			kp.log.Info("error deleting user from database: ", zap.Error(err))
+		}
+		return err
+	}
+
+	kp.log.Info("User deleted successfully", zap.Int("id", id))
+	return nil
+}
+
+// ClaimNonUpdateUsers leases up to limit users that haven't been updated within the
+// update interval, so that they are not claimed again until the lease expires or an
+// enrichment attempt is saved. SQLite serializes writers, so the claim is atomic.
+func (kp *SQLiteKeeper) ClaimNonUpdateUsers(ctx context.Context, limit int, lease time.Duration) ([]models.ExtUserData, error) {
+	thresholdTime, err := kp.updateThreshold()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+        UPDATE users SET enrichment_leased_until = ?
+        WHERE id IN (
+            SELECT id FROM users
+            WHERE passport_enc IS NOT NULL
+            AND (last_checked_at IS NULL OR last_checked_at <= ?)
+            AND (enrichment_leased_until IS NULL OR enrichment_leased_until <= ?)
+            AND ` + enrichableCondition + `
+            ORDER BY id
+            LIMIT ?
+        )
+        RETURNING id, passport_enc, surname_enc, name_enc, address_enc
+    `
+
+	now := time.Now()
+	rows, err := kp.db.QueryContext(ctx, query,
+		formatTimestamp(now.Add(lease)), formatTimestamp(thresholdTime), formatTimestamp(now), formatTimestamp(now), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim non-updated users: %w", err)
+	}
+	defer rows.Close()
+
+	users := make([]models.ExtUserData, 0)
+	for rows.Next() {
+		user, err := kp.scanExtUserData(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to process rows: %w", err)
+	}
+
+	// RETURNING doesn't keep the order of the subquery
+	sort.Slice(users, func(i, j int) bool { return users[i].UserID < users[j].UserID })
+
+	return users, nil
+}
+
+// updateThreshold returns the time before which users are enriched again
+func (kp *SQLiteKeeper) updateThreshold() (time.Time, error) {
+	updateInterval, err := time.ParseDuration(kp.userUpdateInterval())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse USER_UPDATE_INTERVAL: %w", err)
+	}
+
+	return time.Now().UTC().Add(-updateInterval), nil
+}
+
+func (kp *SQLiteKeeper) SaveTask(ctx context.Context, task models.Task) (taskID int, err error) {
+	query := `INSERT INTO tasks (name, description, created_at) VALUES (?, ?, ?) RETURNING id`
+
+	err = kp.withTx(ctx, func(tx *sql.Tx) error {
+		if err := tx.QueryRowContext(ctx, query, task.Name, task.Description, formatTimestamp(task.CreatedAt)).Scan(&taskID); err != nil {
+			return err
+		}
+
+		return kp.recordAudit(ctx, tx, audit.ActionCreate, audit.EntityTask, taskID, nil, audit.TaskSnapshot(task))
+	})
+	if err != nil {
+		kp.log.Info("error saving task to database: ", zap.Error(err))
+		return 0, err
+	}
+
+	kp.log.Info("Task saved successfully: ", zap.String("name", task.Name), zap.Int("id", taskID))
+	return taskID, nil
+}
+
+func (kp *SQLiteKeeper) LoadTasks(ctx context.Context) (storage.StorageTasks, error) {
+	rows, err := kp.db.QueryContext(ctx, `SELECT id, name, COALESCE(description, ''), created_at FROM tasks`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tasks: %w", err)
+	}
+	defer rows.Close()
+
+	data := make(storage.StorageTasks)
+	for rows.Next() {
+		var t models.Task
+		var createdAt sql.NullString
+		if err := rows.Scan(&t.ID, &t.Name, &t.Description, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to load tasks: %w", err)
+		}
+		if t.CreatedAt, err = parseTime(createdAt); err != nil {
+			return nil, fmt.Errorf("failed to load tasks: %w", err)
+		}
+
+		data[t.ID] = t
+	}
+
+	return data, rows.Err()
+}
+
+// GetTaskByID retrieves a task by its identifier
+func (kp *SQLiteKeeper) GetTaskByID(ctx context.Context, id int) (models.Task, error) {
+	query := `SELECT id, name, COALESCE(description, ''), created_at FROM tasks WHERE id = ?`
+
+	var t models.Task
+	var createdAt sql.NullString
+	if err := kp.db.QueryRowContext(ctx, query, id).Scan(&t.ID, &t.Name, &t.Description, &createdAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Task{}, storage.ErrNotFound
+		}
+		return models.Task{}, fmt.Errorf("failed to get task: %w", err)
+	}
+
+	var err error
+	if t.CreatedAt, err = parseTime(createdAt); err != nil {
+		return models.Task{}, fmt.Errorf("failed to get task: %w", err)
+	}
+
+	return t, nil
+}
+
+func (kp *SQLiteKeeper) DeleteTask(ctx context.Context, id int) error {
+	query := `DELETE FROM tasks WHERE id = ? RETURNING name, COALESCE(description, '')`
+
+	err := kp.withTx(ctx, func(tx *sql.Tx) error {
+		var task models.Task
+		if err := tx.QueryRowContext(ctx, query, id).Scan(&task.Name, &task.Description); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return storage.ErrNotFound
+			}
+			return err
+		}
+
+		return kp.recordAudit(ctx, tx, audit.ActionDelete, audit.EntityTask, id, audit.TaskSnapshot(task), nil)
+	})
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			kp.log.Info("error deleting task from database: ", zap.Error(err))
+		}
+		return err
+	}
+
+	kp.log.Info("Task deleted successfully", zap.Int("id", id))
+	return nil
+}
+
+// UpdateTask updates an existing task in the database
+func (kp *SQLiteKeeper) UpdateTask(ctx context.Context, task models.Task) error {
+	err := kp.withTx(ctx, func(tx *sql.Tx) error {
+		var before models.Task
+		err := tx.QueryRowContext(ctx, `SELECT name, COALESCE(description, '') FROM tasks WHERE id = ?`, task.ID).
+			Scan(&before.Name, &before.Description)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return storage.ErrNotFound
+			}
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `UPDATE tasks SET name = ?, description = ? WHERE id = ?`, task.Name, task.Description, task.ID); err != nil {
+			return err
+		}
+
+		return kp.recordAudit(ctx, tx, audit.ActionUpdate, audit.EntityTask, task.ID, audit.TaskSnapshot(before), audit.TaskSnapshot(task))
+	})
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			kp.log.Info("Error updating task in the database: ", zap.Error(err))
+		}
+		return err
+	}
+
+	kp.log.Info("Task successfully updated: ", zap.Int("id", task.ID))
+	return nil
+}
+
+// StartTaskTracking starts tracking time for a task
+func (kp *SQLiteKeeper) StartTaskTracking(ctx context.Context, entry models.TimeEntry) error {
+	// Convert time taking into account the user's time zone
+	location, err := time.LoadLocation(entry.UserTimezone)
+	if err != nil {
+		kp.log.Info("error loading user timezone: ", zap.Error(err))
+		return err
+	}
+	startTime := time.Now().In(location)
+	eventDate := formatDate(entry.EventDate)
+
+	err = kp.withTx(ctx, func(tx *sql.Tx) error {
+		// Check for an active entry for the user and task on the specified date
+		var existingID int
+		err := tx.QueryRowContext(ctx, `
+            SELECT id FROM user_tasks
+            WHERE user_id = ? AND task_id = ? AND event_date = ? AND end_time IS NULL
+        `, entry.UserID, entry.TaskID, eventDate).Scan(&existingID)
+		if err == nil {
+			return fmt.Errorf("task tracking is already in progress for user %d on task %d for date %s", entry.UserID, entry.TaskID, entry.EventDate)
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		var entryID int
+		err = tx.QueryRowContext(ctx, `
+            INSERT INTO user_tasks (user_id, task_id, event_date, start_time)
+            VALUES (?, ?, ?, ?)
+            RETURNING id
+        `, entry.UserID, entry.TaskID, eventDate, formatTime(startTime)).Scan(&entryID)
+		if err != nil {
+			return err
+		}
+
+		return kp.recordAudit(ctx, tx, audit.ActionStart, audit.EntityTimeEntry, entryID, nil, map[string]interface{}{
+			"user_id":    entry.UserID,
+			"task_id":    entry.TaskID,
+			"event_date": eventDate,
+			"start_time": startTime.Format(time.RFC3339),
+		})
+	})
+	if err != nil {
+		kp.log.Info("error saving task tracking to database: ", zap.Error(err))
+		return err
+	}
+
+	kp.log.Info("Task tracking started successfully for user: ", zap.Int("userID", entry.UserID), zap.Int("taskID", entry.TaskID))
+	return nil
+}
+
+func (kp *SQLiteKeeper) StopTaskTracking(ctx context.Context, entry models.TimeEntry) error {
+	// Convert time taking into account the user's time zone
+	location, err := time.LoadLocation(entry.UserTimezone)
+	if err != nil {
+		kp.log.Info("error loading user timezone: ", zap.Error(err))
+		return err
+	}
+	endTime := time.Now().In(location)
+
+	err = kp.withTx(ctx, func(tx *sql.Tx) error {
+		var id int
+		err := tx.QueryRowContext(ctx, `
+            SELECT id FROM user_tasks
+            WHERE user_id = ? AND task_id = ? AND event_date = ? AND end_time IS NULL
+            ORDER BY id DESC
+        `, entry.UserID, entry.TaskID, formatDate(entry.EventDate)).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("no active task tracking found for user %d on task %d for date %s", entry.UserID, entry.TaskID, entry.EventDate)
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `UPDATE user_tasks SET end_time = ? WHERE id = ?`, formatTime(endTime), id); err != nil {
+			return err
+		}
+
+		return kp.recordAudit(ctx, tx, audit.ActionStop, audit.EntityTimeEntry, id,
+			map[string]interface{}{"end_time": nil},
+			map[string]interface{}{"end_time": endTime.Format(time.RFC3339)})
+	})
+	if err != nil {
+		kp.log.Info("error updating task tracking in database: ", zap.Error(err))
+		return err
+	}
+
+	kp.log.Info("Task tracking stopped successfully for user: ", zap.Int("userID", entry.UserID), zap.Int("taskID", entry.TaskID))
+	return nil
+}
+
+// GetRunningTimers lists the entries without an end time by user
+func (kp *SQLiteKeeper) GetRunningTimers(ctx context.Context) ([]models.RunningTimer, error) {
+	query := `
+        SELECT user_id, task_id, event_date, start_time
+        FROM user_tasks
+        WHERE end_time IS NULL
+        ORDER BY user_id, id
+    `
+	rows, err := kp.db.QueryContext(ctx, query)
+	if err != nil {
+		kp.log.Info("error querying running timers: ", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	timers := make([]models.RunningTimer, 0)
+	for rows.Next() {
+		var timer models.RunningTimer
+		var startTime sql.NullString
+		if err := rows.Scan(&timer.UserID, &timer.TaskID, &timer.EventDate, &startTime); err != nil {
+			return nil, fmt.Errorf("failed to scan running timer: %w", err)
+		}
+
+		if timer.StartedAt, err = parseTime(startTime); err != nil {
+			return nil, err
+		}
+		timers = append(timers, timer)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to process rows: %w", err)
+	}
+
+	return timers, nil
+}
+
+// GetUserTaskSummary sums the tracked time per task. Like the TIME columns of
+// BDKeeper, only the time of day of the start, end and default end time is used.
+func (kp *SQLiteKeeper) GetUserTaskSummary(ctx context.Context, userID int, startDate, endDate time.Time, userTimezone string, defaultEndTime time.Time) ([]models.TaskSummary, error) {
+	location, err := time.LoadLocation(userTimezone)
+	if err != nil {
+		kp.log.Info("error loading user timezone: ", zap.Error(err))
+		return nil, err
+	}
+
+	query := `
+        SELECT task_id, start_time, end_time
+        FROM user_tasks
+        WHERE user_id = ? AND event_date BETWEEN ? AND ?
+    `
+	rows, err := kp.db.QueryContext(ctx, query, userID, formatDate(startDate), formatDate(endDate))
+	if err != nil {
+		kp.log.Info("error querying task summary: ", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	taskTimeMap := make(map[int]time.Duration)
+	for rows.Next() {
+		var taskID int
+		var start, end sql.NullString
+		if err := rows.Scan(&taskID, &start, &end); err != nil {
+			kp.log.Info("error scanning task summary: ", zap.Error(err))
+			return nil, err
+		}
+
+		startTime, err := parseTime(start)
+		if err != nil {
+			return nil, err
+		}
+		startTime = timeOfDay(startTime)
+
+		var endTime time.Time
+		if end.Valid {
+			if endTime, err = parseTime(end); err != nil {
+				return nil, err
+			}
+			endTime = timeOfDay(endTime)
+		} else if !defaultEndTime.IsZero() {
+			endTime = timeOfDay(defaultEndTime)
+		} else {
+			endTime = time.Date(startTime.Year(), startTime.Month(), startTime.Day(), 23, 59, 59, 0, location)
+		}
+
+		taskTimeMap[taskID] += endTime.Sub(startTime)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var taskSummaries []models.TaskSummary
+	for taskID, totalTime := range taskTimeMap {
+		taskSummaries = append(taskSummaries, models.TaskSummary{
+			TaskID:    taskID,
+			TotalTime: totalTime.String(),
+		})
+	}
+
+	// Sort by descending time
+	sort.Slice(taskSummaries, func(i, j int) bool {
+		return taskSummaries[i].TotalTime > taskSummaries[j].TotalTime
+	})
+
+	return taskSummaries, nil
+}
+
+// formatDate returns the date part of a time, as stored in a DATE column
+func formatDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// timeOfDay drops the date of a time, as stored in a TIME WITH TIME ZONE column
+func timeOfDay(t time.Time) time.Time {
+	return time.Date(0, 1, 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+}
+
+// formatTimeOfDay formats a time like the text of a TIME WITH TIME ZONE column
+func formatTimeOfDay(t time.Time) string {
+	return t.Format("15:04:05.999999-07")
+}
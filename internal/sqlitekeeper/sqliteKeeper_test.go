@@ -0,0 +1,52 @@
+package sqlitekeeper
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wurt83ow/timetracker/internal/encryption"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"github.com/wurt83ow/timetracker/internal/storage/storagetest"
+	"go.uber.org/zap"
+)
+
+var _ storage.Keeper = (*SQLiteKeeper)(nil)
+
+func newTestKeeper(t *testing.T, path string) *SQLiteKeeper {
+	t.Helper()
+
+	envelope, err := encryption.NewEnvelope("test_encryption_key")
+	require.NoError(t, err)
+
+	kp := NewSQLiteKeeper(func() string { return Scheme + path }, zap.NewNop(), func() string { return "5m" }, envelope)
+	require.NotNil(t, kp)
+	t.Cleanup(func() { kp.Close() })
+
+	return kp
+}
+
+func TestSQLiteKeeper_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Keeper {
+		return newTestKeeper(t, filepath.Join(t.TempDir(), "timetracker.db"))
+	})
+}
+
+func TestSQLiteKeeper_Reopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "timetracker.db")
+
+	kp := newTestKeeper(t, path)
+	userID, err := kp.SaveUser(ctx, models.User{PassportSerie: 1234, PassportNumber: 567890, Surname: "Ivanov"})
+	require.NoError(t, err)
+	require.True(t, kp.Close())
+
+	// Migrations are not applied twice
+	kp = newTestKeeper(t, path)
+	user, err := kp.GetUserByID(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, "Ivanov", user.Surname)
+}
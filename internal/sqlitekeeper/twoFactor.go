@@ -0,0 +1,240 @@
+package sqlitekeeper
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/audit"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap"
+)
+
+// GetTwoFactor retrieves the two-factor settings of a user
+func (kp *SQLiteKeeper) GetTwoFactor(ctx context.Context, userID int) (models.TwoFactor, error) {
+	query := `
+        SELECT user_id, secret, enabled, last_used_step, created_at, confirmed_at, failed_attempts, locked_until
+        FROM user_two_factor
+        WHERE user_id = ?
+    `
+
+	var tf models.TwoFactor
+	var createdAt, confirmedAt, lockedUntil sql.NullString
+
+	err := kp.db.QueryRowContext(ctx, query, userID).Scan(
+		&tf.UserID,
+		&tf.Secret,
+		&tf.Enabled,
+		&tf.LastUsedStep,
+		&createdAt,
+		&confirmedAt,
+		&tf.FailedAttempts,
+		&lockedUntil,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.TwoFactor{}, storage.ErrNotFound
+		}
+		kp.log.Info("error retrieving two-factor settings from database: ", zap.Error(err))
+		return models.TwoFactor{}, err
+	}
+
+	if tf.CreatedAt, err = parseTime(createdAt); err != nil {
+		return models.TwoFactor{}, err
+	}
+	if tf.ConfirmedAt, err = parseTime(confirmedAt); err != nil {
+		return models.TwoFactor{}, err
+	}
+	if tf.LockedUntil, err = parseTime(lockedUntil); err != nil {
+		return models.TwoFactor{}, err
+	}
+
+	return tf, nil
+}
+
+// SaveTwoFactor creates or replaces the two-factor settings of a user
+func (kp *SQLiteKeeper) SaveTwoFactor(ctx context.Context, tf models.TwoFactor) error {
+	query := `
+        INSERT INTO user_two_factor (user_id, secret, enabled, last_used_step, created_at, confirmed_at)
+        VALUES (?, ?, ?, ?, ?, ?)
+        ON CONFLICT (user_id) DO UPDATE SET
+            secret = excluded.secret,
+            enabled = excluded.enabled,
+            last_used_step = excluded.last_used_step,
+            created_at = excluded.created_at,
+            confirmed_at = excluded.confirmed_at
+    `
+
+	after := map[string]interface{}{"enabled": tf.Enabled, "secret": tf.Secret}
+
+	err := kp.withTx(ctx, func(tx *sql.Tx) error {
+		var before map[string]interface{}
+		var enabled bool
+		var secret string
+		switch err := tx.QueryRowContext(ctx, `SELECT enabled, secret FROM user_two_factor WHERE user_id = ?`, tf.UserID).Scan(&enabled, &secret); {
+		case err == nil:
+			before = map[string]interface{}{"enabled": enabled, "secret": secret}
+		case !errors.Is(err, sql.ErrNoRows):
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx, query, tf.UserID, tf.Secret, tf.Enabled, tf.LastUsedStep,
+			formatTimestamp(tf.CreatedAt), formatTimestamp(tf.ConfirmedAt))
+		if err != nil {
+			return err
+		}
+
+		// Saving the last used step on every verification is not worth an audit event
+		if before != nil && enabled == tf.Enabled && secret == tf.Secret {
+			return nil
+		}
+
+		action := audit.ActionCreate
+		if before != nil {
+			action = audit.ActionUpdate
+		}
+		return kp.recordAudit(ctx, tx, action, audit.EntityTwoFactor, tf.UserID, before, after, "secret")
+	})
+	if err != nil {
+		kp.log.Info("error saving two-factor settings to database: ", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// UseTOTPStep records the time step of an accepted code and clears the failed
+// attempts. A step that is not after the last used one is rejected with ErrNotFound,
+// so a code can't be used twice even by concurrent requests.
+func (kp *SQLiteKeeper) UseTOTPStep(ctx context.Context, userID int, step int64) error {
+	query := `
+        UPDATE user_two_factor
+        SET last_used_step = ?, failed_attempts = 0
+        WHERE user_id = ? AND last_used_step < ?
+    `
+
+	result, err := kp.db.ExecContext(ctx, query, step, userID, step)
+	if err != nil {
+		kp.log.Info("error saving the used totp step: ", zap.Error(err))
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+// FailTwoFactor counts a wrong code and locks the verification out until lockUntil
+// once there are limit wrong codes in a row. It returns the number of wrong codes.
+func (kp *SQLiteKeeper) FailTwoFactor(ctx context.Context, userID int, limit int, lockUntil time.Time) (int, error) {
+	query := `
+        UPDATE user_two_factor
+        SET failed_attempts = failed_attempts + 1,
+            locked_until = CASE WHEN failed_attempts + 1 >= ? THEN ? ELSE locked_until END
+        WHERE user_id = ?
+        RETURNING failed_attempts
+    `
+
+	var failures int
+	err := kp.db.QueryRowContext(ctx, query, limit, formatTimestamp(lockUntil), userID).Scan(&failures)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, storage.ErrNotFound
+		}
+		kp.log.Info("error counting a failed second factor: ", zap.Error(err))
+		return 0, err
+	}
+
+	return failures, nil
+}
+
+// DeleteTwoFactor removes the two-factor settings and recovery codes of a user
+func (kp *SQLiteKeeper) DeleteTwoFactor(ctx context.Context, userID int) error {
+	err := kp.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id = ?`, userID); err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, `DELETE FROM user_two_factor WHERE user_id = ?`, userID)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return storage.ErrNotFound
+		}
+
+		return kp.recordAudit(ctx, tx, audit.ActionDelete, audit.EntityTwoFactor, userID, map[string]interface{}{"enabled": true}, nil)
+	})
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		kp.log.Info("error deleting two-factor settings from database: ", zap.Error(err))
+	}
+
+	return err
+}
+
+// SaveRecoveryCodes replaces the recovery code hashes of a user
+func (kp *SQLiteKeeper) SaveRecoveryCodes(ctx context.Context, userID int, hashes []string) error {
+	err := kp.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id = ?`, userID); err != nil {
+			return err
+		}
+
+		for _, hash := range hashes {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO user_recovery_codes (user_id, code_hash) VALUES (?, ?)`, userID, hash); err != nil {
+				return err
+			}
+		}
+
+		// Only the number of codes is recorded, never their hashes
+		return kp.recordAudit(ctx, tx, audit.ActionUpdate, audit.EntityTwoFactor, userID,
+			map[string]interface{}{}, map[string]interface{}{"recovery_codes": len(hashes)})
+	})
+	if err != nil {
+		kp.log.Info("error saving recovery codes to database: ", zap.Error(err))
+	}
+
+	return err
+}
+
+// UseRecoveryCode marks an unused recovery code as used and clears the failed attempts
+func (kp *SQLiteKeeper) UseRecoveryCode(ctx context.Context, userID int, hash string) error {
+	query := `
+        UPDATE user_recovery_codes
+        SET used_at = ?
+        WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+    `
+
+	err := kp.withTx(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, query, formatTimestamp(time.Now()), userID, hash)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return storage.ErrNotFound
+		}
+
+		if _, err := tx.ExecContext(ctx, `UPDATE user_two_factor SET failed_attempts = 0 WHERE user_id = ?`, userID); err != nil {
+			return err
+		}
+
+		return kp.recordAudit(ctx, tx, audit.ActionUpdate, audit.EntityTwoFactor, userID,
+			map[string]interface{}{}, map[string]interface{}{"recovery_code_used": true})
+	})
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			kp.log.Info("error using recovery code: ", zap.Error(err))
+		}
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,250 @@
+package sqlitekeeper
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	// webhookColumns is the column list read by scanWebhook
+	webhookColumns = `id, user_id, url, events, secret, created_at`
+	// deliveryColumns is the column list read by scanWebhookDelivery
+	deliveryColumns = `id, webhook_id, event_type, payload, status, attempts, response_status, last_error, created_at, delivered_at`
+)
+
+// SaveWebhook creates a webhook and returns its id. The event types are stored as
+// a JSON array.
+func (kp *SQLiteKeeper) SaveWebhook(ctx context.Context, hook models.Webhook) (int, error) {
+	query := `INSERT INTO webhooks (user_id, url, events, secret, created_at) VALUES (?, ?, ?, ?, ?)`
+
+	events, err := json.Marshal(hook.Events)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode webhook events: %w", err)
+	}
+
+	result, err := kp.db.ExecContext(ctx, query, hook.UserID, hook.URL, string(events), hook.Secret, formatTimestamp(time.Now()))
+	if err != nil {
+		kp.log.Info("error saving webhook to database: ", zap.Error(err))
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// GetWebhook retrieves a webhook by its id
+func (kp *SQLiteKeeper) GetWebhook(ctx context.Context, id int) (models.Webhook, error) {
+	query := `SELECT ` + webhookColumns + ` FROM webhooks WHERE id = ?`
+
+	hook, err := scanWebhook(kp.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Webhook{}, storage.ErrNotFound
+		}
+		kp.log.Info("error retrieving webhook from database: ", zap.Error(err))
+		return models.Webhook{}, err
+	}
+
+	return hook, nil
+}
+
+// GetWebhooks lists the webhooks in the order they were created
+func (kp *SQLiteKeeper) GetWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	rows, err := kp.db.QueryContext(ctx, `SELECT `+webhookColumns+` FROM webhooks ORDER BY id`)
+	if err != nil {
+		kp.log.Info("error retrieving webhooks from database: ", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := make([]models.Webhook, 0)
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		hooks = append(hooks, hook)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to process rows: %w", err)
+	}
+
+	return hooks, nil
+}
+
+// DeleteWebhook deletes a webhook, its deliveries are deleted by the cascade
+func (kp *SQLiteKeeper) DeleteWebhook(ctx context.Context, id int) error {
+	return kp.execWebhook(ctx, "deleting webhook", `DELETE FROM webhooks WHERE id = ?`, id)
+}
+
+// SaveWebhookDelivery adds a pending delivery of a webhook and returns its id. A
+// missing webhook is not found.
+func (kp *SQLiteKeeper) SaveWebhookDelivery(ctx context.Context, d models.WebhookDelivery) (int64, error) {
+	query := `
+        INSERT INTO webhook_deliveries (webhook_id, event_type, payload, created_at)
+        SELECT id, ?, ?, ? FROM webhooks WHERE id = ?
+    `
+
+	result, err := kp.db.ExecContext(ctx, query, d.EventType, string(d.Payload), formatTimestamp(time.Now()), d.WebhookID)
+	if err != nil {
+		kp.log.Info("error saving webhook delivery to database: ", zap.Error(err))
+		return 0, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if affected == 0 {
+		return 0, storage.ErrNotFound
+	}
+
+	return result.LastInsertId()
+}
+
+// GetWebhookDelivery retrieves a delivery by its id
+func (kp *SQLiteKeeper) GetWebhookDelivery(ctx context.Context, id int64) (models.WebhookDelivery, error) {
+	query := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries WHERE id = ?`
+
+	d, err := scanWebhookDelivery(kp.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.WebhookDelivery{}, storage.ErrNotFound
+		}
+		kp.log.Info("error retrieving webhook delivery from database: ", zap.Error(err))
+		return models.WebhookDelivery{}, err
+	}
+
+	return d, nil
+}
+
+// GetWebhookDeliveries lists up to limit deliveries of a webhook, the most recent first
+func (kp *SQLiteKeeper) GetWebhookDeliveries(ctx context.Context, webhookID int, limit int) ([]models.WebhookDelivery, error) {
+	query := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC LIMIT ?`
+
+	rows, err := kp.db.QueryContext(ctx, query, webhookID, limit)
+	if err != nil {
+		kp.log.Info("error retrieving webhook deliveries from database: ", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]models.WebhookDelivery, 0)
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to process rows: %w", err)
+	}
+
+	return deliveries, nil
+}
+
+// UpdateWebhookDelivery records the outcome of an attempt of a delivery
+func (kp *SQLiteKeeper) UpdateWebhookDelivery(ctx context.Context, d models.WebhookDelivery) error {
+	query := `
+        UPDATE webhook_deliveries
+        SET status = ?, attempts = ?, response_status = NULLIF(?, 0), last_error = ?, delivered_at = ?
+        WHERE id = ?
+    `
+
+	var deliveredAt *string
+	if d.DeliveredAt != nil {
+		deliveredAt = formatTimestamp(*d.DeliveredAt)
+	}
+
+	return kp.execWebhook(ctx, "updating webhook delivery", query,
+		d.Status, d.Attempts, d.ResponseStatus, nullString(d.LastError), deliveredAt, d.ID)
+}
+
+// execWebhook runs a statement changing a single row, a missing one is not found
+func (kp *SQLiteKeeper) execWebhook(ctx context.Context, action, query string, args ...any) error {
+	result, err := kp.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		kp.log.Info("error "+action+" in database: ", zap.Error(err))
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+func scanWebhook(row interface{ Scan(...any) error }) (models.Webhook, error) {
+	var hook models.Webhook
+	var events string
+	var createdAt sql.NullString
+
+	err := row.Scan(&hook.ID, &hook.UserID, &hook.URL, &events, &hook.Secret, &createdAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return hook, err
+		}
+		return hook, fmt.Errorf("failed to scan webhook: %w", err)
+	}
+
+	if err := json.Unmarshal([]byte(events), &hook.Events); err != nil {
+		return hook, fmt.Errorf("failed to decode webhook events: %w", err)
+	}
+	hook.CreatedAt, err = parseTime(createdAt)
+
+	return hook, err
+}
+
+func scanWebhookDelivery(row interface{ Scan(...any) error }) (models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	var payload string
+	var responseStatus sql.NullInt64
+	var lastError, createdAt, deliveredAt sql.NullString
+
+	err := row.Scan(&d.ID, &d.WebhookID, &d.EventType, &payload, &d.Status, &d.Attempts,
+		&responseStatus, &lastError, &createdAt, &deliveredAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return d, err
+		}
+		return d, fmt.Errorf("failed to scan webhook delivery: %w", err)
+	}
+
+	d.Payload = json.RawMessage(payload)
+	d.ResponseStatus = int(responseStatus.Int64)
+	d.LastError = lastError.String
+	if d.CreatedAt, err = parseTime(createdAt); err != nil {
+		return d, err
+	}
+	if deliveredAt.Valid {
+		t, err := parseTime(deliveredAt)
+		if err != nil {
+			return d, err
+		}
+		d.DeliveredAt = &t
+	}
+
+	return d, nil
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// CacheCounters are the statistics of one cache
+type CacheCounters struct {
+	Size        int    `json:"size"`
+	Capacity    int    `json:"capacity"`
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Evictions   uint64 `json:"evictions"`
+	Expirations uint64 `json:"expirations"`
+}
+
+// CacheStats are the statistics of the user and task caches of a MemoryStorage
+type CacheStats struct {
+	Mode  string        `json:"mode"`
+	TTL   string        `json:"ttl"`
+	Users CacheCounters `json:"users"`
+	Tasks CacheCounters `json:"tasks"`
+}
+
+// lruCache is a map with least recently used eviction and a time to live.
+// A zero capacity or TTL disables the respective limit.
+type lruCache[K comparable, V any] struct {
+	mx       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	now      func() time.Time
+	order    *list.List // front is the most recently used
+	items    map[K]*list.Element
+	counters CacheCounters
+}
+
+type cacheEntry[K comparable, V any] struct {
+	key       K
+	value     V
+	expiresAt time.Time
+}
+
+func newLRUCache[K comparable, V any](capacity int, ttl time.Duration) *lruCache[K, V] {
+	return &lruCache[K, V]{
+		capacity: capacity,
+		ttl:      ttl,
+		now:      time.Now,
+		order:    list.New(),
+		items:    make(map[K]*list.Element),
+	}
+}
+
+// Get returns a live value and marks it as recently used
+func (c *lruCache[K, V]) Get(key K) (V, bool) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	el, ok := c.items[key]
+	if ok && c.expired(el) {
+		c.remove(el)
+		c.counters.Expirations++
+		ok = false
+	}
+	if !ok {
+		c.counters.Misses++
+		var zero V
+		return zero, false
+	}
+
+	c.counters.Hits++
+	c.order.MoveToFront(el)
+	return el.Value.(*cacheEntry[K, V]).value, true
+}
+
+// Set stores a value, evicting the least recently used one when the cache is full
+func (c *lruCache[K, V]) Set(key K, value V) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	var expiresAt time.Time
+	if c.ttl > 0 {
+		expiresAt = c.now().Add(c.ttl)
+	}
+
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*cacheEntry[K, V])
+		entry.value = value
+		entry.expiresAt = expiresAt
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&cacheEntry[K, V]{key: key, value: value, expiresAt: expiresAt})
+
+	if c.capacity > 0 && c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+		c.counters.Evictions++
+	}
+}
+
+// Replace drops all values and stores the given ones instead
+func (c *lruCache[K, V]) Replace(values map[K]V) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.order.Init()
+	c.items = make(map[K]*list.Element, len(values))
+
+	var expiresAt time.Time
+	if c.ttl > 0 {
+		expiresAt = c.now().Add(c.ttl)
+	}
+	for key, value := range values {
+		if c.capacity > 0 && c.order.Len() == c.capacity {
+			break
+		}
+		c.items[key] = c.order.PushFront(&cacheEntry[K, V]{key: key, value: value, expiresAt: expiresAt})
+	}
+}
+
+// Update changes a cached value in place, without counting a hit or refreshing its TTL
+func (c *lruCache[K, V]) Update(key K, fn func(V) V) bool {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	el, ok := c.items[key]
+	if !ok || c.expired(el) {
+		return false
+	}
+
+	entry := el.Value.(*cacheEntry[K, V])
+	entry.value = fn(entry.value)
+	return true
+}
+
+// Delete removes a value
+func (c *lruCache[K, V]) Delete(key K) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+}
+
+// Values returns the live values, most recently used first
+func (c *lruCache[K, V]) Values() []V {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	values := make([]V, 0, c.order.Len())
+	for el := c.order.Front(); el != nil; {
+		next := el.Next()
+		if c.expired(el) {
+			c.remove(el)
+			c.counters.Expirations++
+		} else {
+			values = append(values, el.Value.(*cacheEntry[K, V]).value)
+		}
+		el = next
+	}
+
+	return values
+}
+
+// Stats returns the counters of the cache
+func (c *lruCache[K, V]) Stats() CacheCounters {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	counters := c.counters
+	counters.Size = c.order.Len()
+	counters.Capacity = c.capacity
+	return counters
+}
+
+func (c *lruCache[K, V]) expired(el *list.Element) bool {
+	expiresAt := el.Value.(*cacheEntry[K, V]).expiresAt
+	return !expiresAt.IsZero() && !c.now().Before(expiresAt)
+}
+
+func (c *lruCache[K, V]) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.items, el.Value.(*cacheEntry[K, V]).key)
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRUCache_Eviction(t *testing.T) {
+	c := newLRUCache[int, string](2, 0)
+
+	c.Set(1, "a")
+	c.Set(2, "b")
+	_, _ = c.Get(1) // 2 becomes the least recently used
+	c.Set(3, "c")
+
+	_, ok := c.Get(2)
+	assert.False(t, ok, "the least recently used value is evicted")
+	v, ok := c.Get(1)
+	assert.True(t, ok)
+	assert.Equal(t, "a", v)
+
+	stats := c.Stats()
+	assert.Equal(t, CacheCounters{Size: 2, Capacity: 2, Hits: 2, Misses: 1, Evictions: 1}, stats)
+}
+
+func TestLRUCache_TTL(t *testing.T) {
+	now := time.Now()
+	c := newLRUCache[int, string](0, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.Set(1, "a")
+	assert.True(t, c.Update(1, func(string) string { return "b" }))
+
+	now = now.Add(time.Minute)
+	_, ok := c.Get(1)
+	assert.False(t, ok, "values expire after the TTL")
+	assert.False(t, c.Update(1, func(string) string { return "c" }), "expired values are not updated")
+
+	c.Set(2, "b")
+	now = now.Add(2 * time.Minute)
+	assert.Empty(t, c.Values())
+
+	stats := c.Stats()
+	assert.Equal(t, uint64(2), stats.Expirations)
+	assert.Equal(t, 0, stats.Size)
+}
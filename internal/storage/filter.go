@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"strings"
+
+	"github.com/wurt83ow/timetracker/internal/models"
+)
+
+// MatchUser reports whether a user passes the filter. Text fields match by substring.
+func MatchUser(user models.User, filter models.Filter) bool {
+	switch {
+	case filter.PassportSerie != nil && *filter.PassportSerie != user.PassportSerie,
+		filter.PassportNumber != nil && *filter.PassportNumber != user.PassportNumber,
+		filter.Surname != nil && !strings.Contains(user.Surname, *filter.Surname),
+		filter.Name != nil && !strings.Contains(user.Name, *filter.Name),
+		filter.Patronymic != nil && !strings.Contains(user.Patronymic, *filter.Patronymic),
+		filter.Address != nil && !strings.Contains(user.Address, *filter.Address),
+		filter.Timezone != nil && !strings.Contains(user.Timezone, *filter.Timezone):
+		return false
+	}
+
+	return true
+}
+
+// MatchTask reports whether a task passes the filter. Text fields match by substring.
+func MatchTask(task models.Task, filter models.TaskFilter) bool {
+	switch {
+	case filter.Name != nil && !strings.Contains(task.Name, *filter.Name),
+		filter.Description != nil && !strings.Contains(task.Description, *filter.Description):
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/models"
+)
+
+// ErrInvalidSort indicates an unknown or repeated sort field
+var ErrInvalidSort = errors.New("invalid sort")
+
+// SortTimeLayout formats times in sort keys and cursors. It has a fixed width,
+// so that UTC times compare as strings.
+const SortTimeLayout = "2006-01-02T15:04:05.000000Z"
+
+// userSortKeys are the sort fields of users, named like the JSON fields
+var userSortKeys = map[string]func(models.User) interface{}{
+	"id":             func(u models.User) interface{} { return u.UUID },
+	"passportSerie":  func(u models.User) interface{} { return u.PassportSerie },
+	"passportNumber": func(u models.User) interface{} { return u.PassportNumber },
+	"surname":        func(u models.User) interface{} { return u.Surname },
+	"name":           func(u models.User) interface{} { return u.Name },
+	"patronymic":     func(u models.User) interface{} { return u.Patronymic },
+	"address":        func(u models.User) interface{} { return u.Address },
+	"timezone":       func(u models.User) interface{} { return u.Timezone },
+}
+
+// taskSortKeys are the sort fields of tasks, named like the JSON fields
+var taskSortKeys = map[string]func(models.Task) interface{}{
+	"id":          func(t models.Task) interface{} { return t.ID },
+	"name":        func(t models.Task) interface{} { return t.Name },
+	"description": func(t models.Task) interface{} { return t.Description },
+	"created_at":  func(t models.Task) interface{} { return FormatSortTime(t.CreatedAt) },
+}
+
+// FormatSortTime formats a time as it is compared in sort keys
+func FormatSortTime(t time.Time) string {
+	return t.UTC().Format(SortTimeLayout)
+}
+
+// ParseUserSort parses a sort parameter of users such as "surname,-id"
+func ParseUserSort(raw string) ([]models.SortField, error) {
+	return parseSort(raw, userSortKeys)
+}
+
+// ParseTaskSort parses a sort parameter of tasks such as "-created_at"
+func ParseTaskSort(raw string) ([]models.SortField, error) {
+	return parseSort(raw, taskSortKeys)
+}
+
+func parseSort[T any](raw string, keys map[string]func(T) interface{}) ([]models.SortField, error) {
+	var fields []models.SortField
+	seen := make(map[string]bool)
+
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		field := models.SortField{Field: strings.TrimPrefix(part, "-"), Desc: strings.HasPrefix(part, "-")}
+		if _, ok := keys[field.Field]; !ok || seen[field.Field] {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidSort, part)
+		}
+		seen[field.Field] = true
+
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+// SortString is the canonical form of a sort, the inverse of ParseUserSort and ParseTaskSort
+func SortString(fields []models.SortField) string {
+	parts := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if f.Desc {
+			parts = append(parts, "-"+f.Field)
+		} else {
+			parts = append(parts, f.Field)
+		}
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// UserCursor returns the cursor that continues a list of users after the user
+func UserCursor(user models.User, fields []models.SortField) models.Cursor {
+	return cursor(user, user.UUID, userSortKeys, fields)
+}
+
+// TaskCursor returns the cursor that continues a list of tasks after the task
+func TaskCursor(task models.Task, fields []models.SortField) models.Cursor {
+	return cursor(task, task.ID, taskSortKeys, fields)
+}
+
+func cursor[T any](item T, id int, keys map[string]func(T) interface{}, fields []models.SortField) models.Cursor {
+	c := models.Cursor{Sort: SortString(fields), ID: id, Values: make([]interface{}, 0, len(fields))}
+	for _, f := range fields {
+		c.Values = append(c.Values, keys[f.Field](item))
+	}
+
+	return c
+}
+
+// PageUsers sorts the users and returns the page selected by the pagination
+// together with the number of users before paging
+func PageUsers(users []models.User, pagination models.Pagination) ([]models.User, int) {
+	return page(users, func(u models.User) int { return u.UUID }, userSortKeys, pagination)
+}
+
+// PageTasks sorts the tasks and returns the page selected by the pagination
+// together with the number of tasks before paging
+func PageTasks(tasks []models.Task, pagination models.Pagination) ([]models.Task, int) {
+	return page(tasks, func(t models.Task) int { return t.ID }, taskSortKeys, pagination)
+}
+
+func page[T any](items []T, id func(T) int, keys map[string]func(T) interface{}, pagination models.Pagination) ([]T, int) {
+	total := len(items)
+
+	// compare orders an item against a sort key, ties are broken by id
+	compare := func(item T, values []interface{}, itemID int) int {
+		for i, f := range pagination.Sort {
+			c := compareValues(keys[f.Field](item), values[i])
+			if f.Desc {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+
+		switch {
+		case id(item) < itemID:
+			return -1
+		case id(item) > itemID:
+			return 1
+		}
+		return 0
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		other := cursor(items[j], id(items[j]), keys, pagination.Sort)
+		return compare(items[i], other.Values, other.ID) < 0
+	})
+
+	start := pagination.Offset
+	if after := pagination.After; after != nil {
+		start = sort.Search(len(items), func(i int) bool {
+			return compare(items[i], after.Values, after.ID) > 0
+		})
+	}
+	end := start + pagination.Limit
+
+	if start < 0 || start >= len(items) || end <= start {
+		return []T{}, total
+	}
+
+	if end > len(items) {
+		end = len(items)
+	}
+
+	return items[start:end], total
+}
+
+// compareValues compares two sort key values of the same field
+func compareValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case int:
+		b, _ := b.(int)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+	case string:
+		b, _ := b.(string)
+		return strings.Compare(a, b)
+	}
+
+	return 0
+}
+
+// ValidUserCursor reports whether the cursor continues a list of users sorted by the fields
+func ValidUserCursor(c models.Cursor, fields []models.SortField) bool {
+	return validCursor(c, userSortKeys, fields)
+}
+
+// ValidTaskCursor reports whether the cursor continues a list of tasks sorted by the fields
+func ValidTaskCursor(c models.Cursor, fields []models.SortField) bool {
+	return validCursor(c, taskSortKeys, fields)
+}
+
+func validCursor[T any](c models.Cursor, keys map[string]func(T) interface{}, fields []models.SortField) bool {
+	if c.Sort != SortString(fields) || len(c.Values) != len(fields) {
+		return false
+	}
+
+	var zero T
+	for i, f := range fields {
+		var ok bool
+		switch keys[f.Field](zero).(type) {
+		case int:
+			_, ok = c.Values[i].(int)
+		case string:
+			_, ok = c.Values[i].(string)
+		}
+		if !ok {
+			return false
+		}
+
+		if f.Field == "created_at" {
+			if _, err := time.Parse(SortTimeLayout, c.Values[i].(string)); err != nil {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"strings"
+	"strconv"
 	"sync"
 	"time"
 
@@ -18,8 +18,14 @@ var (
 	ErrConflict     = errors.New("data conflict")
 	ErrInsufficient = errors.New("insufficient funds")
 	ErrNotFound     = errors.New("user not found")
+	ErrTooManyUsers = errors.New("too many users to filter by encrypted fields")
 )
 
+// PassportChanged reports whether an update gives the user another passport
+func PassportChanged(current, updated models.User) bool {
+	return current.PassportSerie != updated.PassportSerie || current.PassportNumber != updated.PassportNumber
+}
+
 type (
 	StorageUsers = map[int]models.User
 	StorageTasks = map[int]models.Task
@@ -29,12 +35,34 @@ type Log interface {
 	Info(string, ...zapcore.Field)
 }
 
+// Storage modes of a MemoryStorage
+const (
+	// ModeMirror keeps all users and tasks in memory, loaded at startup
+	ModeMirror = "mirror"
+	// ModeCache keeps recently used users and tasks, lists are read from the keeper
+	ModeCache = "cache"
+)
+
+const (
+	defaultCacheSize = 1000
+	defaultCacheTTL  = 5 * time.Minute
+)
+
+// CacheOptions configure the storage mode and the cache limits
+type CacheOptions interface {
+	StorageMode() string
+	CacheSize() string
+	CacheTTL() string
+}
+
 type MemoryStorage struct {
 	ctx    context.Context
-	omx    sync.RWMutex
-	umx    sync.RWMutex
-	users  StorageUsers
-	tasks  StorageTasks
+	omx    sync.Mutex
+	umx    sync.Mutex
+	mode   string
+	ttl    time.Duration
+	users  *lruCache[int, models.User]
+	tasks  *lruCache[int, models.Task]
 	keeper Keeper
 	log    Log
 }
@@ -43,9 +71,12 @@ type Keeper interface {
 	LoadUsers(context.Context) (StorageUsers, error)
 	SaveUser(context.Context, models.User) (int, error)
 	UpdateUser(context.Context, models.User) error
-	UpdateUsersInfo(context.Context, []models.ExtUserData) error
+	UpdateUsersInfo(context.Context, []models.ExtUserData) ([]int, error)
 	DeleteUser(context.Context, int) error
-	GetNonUpdateUsers(context.Context) ([]models.ExtUserData, error)
+	ClaimNonUpdateUsers(ctx context.Context, limit int, lease time.Duration) ([]models.ExtUserData, error)
+	ClaimUser(ctx context.Context, userID int, lease time.Duration) (models.ExtUserData, error)
+	ExportUser(context.Context, int) (models.UserExport, error)
+	EraseUser(context.Context, int) error
 
 	LoadTasks(context.Context) (StorageTasks, error)
 	SaveTask(context.Context, models.Task) (int, error)
@@ -53,81 +84,183 @@ type Keeper interface {
 	DeleteTask(context.Context, int) error
 	StartTaskTracking(context.Context, models.TimeEntry) error
 	StopTaskTracking(context.Context, models.TimeEntry) error
+	GetRunningTimers(context.Context) ([]models.RunningTimer, error)
 	GetUserTaskSummary(context.Context, int, time.Time, time.Time, string, time.Time) ([]models.TaskSummary, error)
 	GetUser(context.Context, int, int) (models.User, error)
+	GetUserByID(context.Context, int) (models.User, error)
+	GetTaskByID(context.Context, int) (models.Task, error)
+	GetUsers(context.Context, models.Filter, models.Pagination) ([]models.User, int, error)
+	GetTasks(context.Context, models.TaskFilter, models.Pagination) ([]models.Task, int, error)
+	Search(context.Context, string, int) ([]models.SearchResult, error)
+
+	GetTwoFactor(context.Context, int) (models.TwoFactor, error)
+	SaveTwoFactor(context.Context, models.TwoFactor) error
+	DeleteTwoFactor(context.Context, int) error
+	SaveRecoveryCodes(context.Context, int, []string) error
+	UseRecoveryCode(context.Context, int, string) error
+	UseTOTPStep(context.Context, int, int64) error
+	FailTwoFactor(context.Context, int, int, time.Time) (int, error)
+
+	GetUserByIdentity(context.Context, string, string) (models.User, error)
+	LinkIdentity(context.Context, models.UserIdentity) error
+
+	GetAuditEvents(context.Context, models.AuditFilter, int) ([]models.AuditEvent, error)
+
+	GetEnrichmentAttempt(context.Context, int) (models.EnrichmentAttempt, error)
+	SaveEnrichmentAttempts(context.Context, []models.EnrichmentAttempt) error
+	GetEnrichmentAttempts(context.Context, []string, int) ([]models.EnrichmentAttempt, error)
+	CountEnrichmentAttempts(context.Context) (map[string]int, error)
+	ResyncUser(context.Context, int) error
+
+	EnqueueJob(context.Context, models.Job) (int64, error)
+	ClaimJobs(ctx context.Context, limit int, visibility time.Duration) ([]models.Job, error)
+	CompleteJob(context.Context, int64) error
+	RetryJob(ctx context.Context, id int64, attempts int, runAt time.Time, lastError string) error
+	BuryJob(context.Context, int64, string) error
+
+	SaveReportSubscription(context.Context, models.ReportSubscription) (int, error)
+	GetReportSubscription(context.Context, int) (models.ReportSubscription, error)
+	GetReportSubscriptions(context.Context) ([]models.ReportSubscription, error)
+	DeleteReportSubscription(context.Context, int) error
+	MarkReportSent(context.Context, int, time.Time) error
+	SaveWebhook(context.Context, models.Webhook) (int, error)
+	GetWebhook(context.Context, int) (models.Webhook, error)
+	GetWebhooks(context.Context) ([]models.Webhook, error)
+	DeleteWebhook(context.Context, int) error
+	SaveWebhookDelivery(context.Context, models.WebhookDelivery) (int64, error)
+	GetWebhookDelivery(context.Context, int64) (models.WebhookDelivery, error)
+	GetWebhookDeliveries(ctx context.Context, webhookID int, limit int) ([]models.WebhookDelivery, error)
+	UpdateWebhookDelivery(context.Context, models.WebhookDelivery) error
 
 	Ping(context.Context) bool
 	Close() bool
 }
 
-// NewMemoryStorage creates a new MemoryStorage instance
-func NewMemoryStorage(ctx context.Context, keeper Keeper, log Log) *MemoryStorage {
-	users := make(StorageUsers)
-	tasks := make(StorageTasks)
-
-	if keeper != nil {
-		var err error
+// NewMemoryStorage creates a new MemoryStorage instance. In the mirror mode all users
+// and tasks are loaded from the keeper, in the cache mode they are read through on demand.
+func NewMemoryStorage(ctx context.Context, keeper Keeper, log Log, options CacheOptions) *MemoryStorage {
+	s := &MemoryStorage{
+		ctx:    ctx,
+		mode:   options.StorageMode(),
+		keeper: keeper,
+		log:    log,
+	}
 
-		// Load users
-		users, err = keeper.LoadUsers(ctx)
-		if err != nil {
-			log.Info("cannot load user data: ", zap.Error(err))
+	if s.mode != ModeCache {
+		if s.mode != ModeMirror {
+			log.Info("unknown storage mode, using mirror", zap.String("mode", s.mode))
 		}
+		s.mode = ModeMirror
 
-		// Load tasks
-		tasks, err = keeper.LoadTasks(ctx)
-		if err != nil {
-			log.Info("cannot load task data: ", zap.Error(err))
-		}
+		// The mirror is never evicted, so that lists can be served from memory
+		s.users = newLRUCache[int, models.User](0, 0)
+		s.tasks = newLRUCache[int, models.Task](0, 0)
+		s.load(ctx)
+
+		return s
 	}
 
-	return &MemoryStorage{
-		ctx:    ctx,
-		users:  users,
-		tasks:  tasks,
-		keeper: keeper,
-		log:    log,
+	size, err := strconv.Atoi(options.CacheSize())
+	if err != nil || size <= 0 {
+		log.Info("invalid CACHE_SIZE, using default", zap.String("value", options.CacheSize()))
+		size = defaultCacheSize
 	}
+
+	s.ttl, err = time.ParseDuration(options.CacheTTL())
+	if err != nil || s.ttl < 0 {
+		log.Info("invalid CACHE_TTL, using default", zap.String("value", options.CacheTTL()))
+		s.ttl = defaultCacheTTL
+	}
+
+	s.users = newLRUCache[int, models.User](size, s.ttl)
+	s.tasks = newLRUCache[int, models.Task](size, s.ttl)
+
+	return s
 }
 
-// UpdateUsersInfo updates user information in the storage
-func (s *MemoryStorage) UpdateUsersInfo(ctx context.Context, result []models.ExtUserData) error {
-	err := s.keeper.UpdateUsersInfo(ctx, result)
+// load fills the mirror with all users and tasks of the keeper. Data that cannot be
+// loaded is kept as it is.
+func (s *MemoryStorage) load(ctx context.Context) {
+	users, err := s.keeper.LoadUsers(ctx)
 	if err != nil {
-		return err
+		s.log.Info("cannot load user data: ", zap.Error(err))
+	} else {
+		s.users.Replace(users)
+	}
+
+	tasks, err := s.keeper.LoadTasks(ctx)
+	if err != nil {
+		s.log.Info("cannot load task data: ", zap.Error(err))
+	} else {
+		s.tasks.Replace(tasks)
+	}
+}
+
+// mirror reports whether all users and tasks are in memory
+func (s *MemoryStorage) mirror() bool {
+	return s.mode == ModeMirror
+}
+
+// CacheStats returns the mode and the hit and miss statistics of the caches
+func (s *MemoryStorage) CacheStats() CacheStats {
+	stats := CacheStats{
+		Mode:  s.mode,
+		Users: s.users.Stats(),
+		Tasks: s.tasks.Stats(),
+	}
+	if s.ttl > 0 {
+		stats.TTL = s.ttl.String()
+	}
+
+	return stats
+}
+
+// UpdateUsersInfo updates user information in the storage and returns the IDs of the updated
+// users. The keeper skips unknown users and users checked within the update interval.
+func (s *MemoryStorage) UpdateUsersInfo(ctx context.Context, result []models.ExtUserData) ([]int, error) {
+	ids, err := s.keeper.UpdateUsersInfo(ctx, result)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		updated[id] = true
 	}
 
 	s.umx.Lock()
 	defer s.umx.Unlock()
 
 	for _, v := range result {
-		var id int
-		var exists bool
-
 		// Attempt to find the user in the in-memory storage
-		for _, user := range s.users {
-			if user.PassportSerie == v.PassportSerie && user.PassportNumber == v.PassportNumber {
-				id = user.UUID
-				exists = true
-				break
-			}
+		user, exists := s.findUser(v.PassportSerie, v.PassportNumber)
+		if !exists || !updated[user.UUID] {
+			continue
 		}
 
-		if exists {
-			// Extract the user, modify necessary fields, and put back into the map
-			user := s.users[id]
-			user.Surname = v.Surname
-			user.Name = v.Name
-			user.Address = v.Address
-			s.users[id] = user
-		}
+		// Modify the enriched fields of the cached user
+		s.users.Update(user.UUID, func(user models.User) models.User {
+			if v.Surname != "" {
+				user.Surname = v.Surname
+			}
+			if v.Name != "" {
+				user.Name = v.Name
+			}
+			if v.Patronymic != "" {
+				user.Patronymic = v.Patronymic
+			}
+			if v.Address != "" {
+				user.Address = v.Address
+			}
+			return user
+		})
 	}
 
-	return nil
+	return ids, nil
 }
 
-// InsertUser inserts a new user into the storage
-func (s *MemoryStorage) InsertUser(ctx context.Context, user models.User) error {
+// InsertUser inserts a new user into the storage and returns its ID
+func (s *MemoryStorage) InsertUser(ctx context.Context, user models.User) (int, error) {
 
 	s.umx.Lock()
 	defer s.umx.Unlock()
@@ -135,13 +268,14 @@ func (s *MemoryStorage) InsertUser(ctx context.Context, user models.User) error
 	// Save the user to the keeper
 	id, err := s.keeper.SaveUser(ctx, user)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	// Also save to the in-memory map
-	s.users[id] = user
+	// Also save to the in-memory storage with the generated ID
+	user.UUID = id
+	s.users.Set(id, user)
 
-	return nil
+	return id, nil
 }
 
 // UpdateUser updates an existing user in the storage
@@ -152,8 +286,9 @@ func (s *MemoryStorage) UpdateUser(ctx context.Context, user models.User) error
 	defer s.umx.Unlock()
 
 	// Check if the user with such a key exists in the storage
-	if _, exists := s.users[user.UUID]; !exists {
-		return ErrNotFound
+	current, err := s.GetUserByID(ctx, user.UUID)
+	if err != nil {
+		return err
 	}
 
 	// Attempt to update the user through the keeper
@@ -161,82 +296,212 @@ func (s *MemoryStorage) UpdateUser(ctx context.Context, user models.User) error
 		return err
 	}
 
-	// Update the user in memory
-	s.users[user.UUID] = user
+	// Update the user in memory, the keeper only changes the fields that are set
+	s.users.Set(user.UUID, mergeUser(current, user))
 
 	return nil
 }
 
-// GetUsers retrieves users from the storage based on the provided filter and pagination
-func (s *MemoryStorage) GetUsers(ctx context.Context, filter models.Filter, pagination models.Pagination) ([]models.User, error) {
-	var result []models.User
-	s.umx.RLock()
-	defer s.umx.RUnlock()
+// GetUsers retrieves a sorted page of users matching the filter and the number of all matching users.
+// Only the mirror holds all users, in the cache mode the keeper filters them.
+func (s *MemoryStorage) GetUsers(ctx context.Context, filter models.Filter, pagination models.Pagination) ([]models.User, int, error) {
+	if !s.mirror() {
+		return s.keeper.GetUsers(ctx, filter, pagination)
+	}
 
-	for _, user := range s.users {
-		if filter.PassportSerie != nil && *filter.PassportSerie != user.PassportSerie {
-			continue
-		}
-		if filter.PassportNumber != nil && *filter.PassportNumber != user.PassportNumber {
-			continue
-		}
-		if filter.Surname != nil && !strings.Contains(user.Surname, *filter.Surname) {
-			continue
+	result := make([]models.User, 0)
+	for _, user := range s.users.Values() {
+		if MatchUser(user, filter) {
+			result = append(result, user)
 		}
-		if filter.Name != nil && !strings.Contains(user.Name, *filter.Name) {
-			continue
-		}
-		if filter.Patronymic != nil && !strings.Contains(user.Patronymic, *filter.Patronymic) {
-			continue
-		}
-		if filter.Address != nil && !strings.Contains(user.Address, *filter.Address) {
-			continue
-		}
-		if filter.Timezone != nil && !strings.Contains(user.Timezone, *filter.Timezone) {
-			continue
-		}
-
-		result = append(result, user)
 	}
 
-	start := pagination.Offset
-	end := start + pagination.Limit
+	users, total := PageUsers(result, pagination)
+	return users, total, nil
+}
 
-	if start >= len(result) {
-		return []models.User{}, nil
+// DeleteUser deletes a user from the storage
+func (s *MemoryStorage) DeleteUser(ctx context.Context, id int) error {
+
+	s.umx.Lock()
+	defer s.umx.Unlock()
+
+	if _, err := s.GetUserByID(ctx, id); err != nil {
+		return err
 	}
 
-	if end > len(result) {
-		end = len(result)
+	// Delete the user from the keeper
+	if err := s.keeper.DeleteUser(ctx, id); err != nil {
+		return err
 	}
 
-	return result[start:end], nil
+	// Also delete from the in-memory storage
+	s.users.Delete(id)
+
+	return nil
 }
 
-// DeleteUser deletes a user from the storage
-func (s *MemoryStorage) DeleteUser(ctx context.Context, id int) error {
+// ExportUser collects the personal data of a user
+func (s *MemoryStorage) ExportUser(ctx context.Context, id int) (models.UserExport, error) {
+	return s.keeper.ExportUser(ctx, id)
+}
+
+// Search returns the tasks and users best matching the query. The keeper searches
+// in both modes, as the cache holds only part of the data.
+func (s *MemoryStorage) Search(ctx context.Context, query string, limit int) ([]models.SearchResult, error) {
+	return s.keeper.Search(ctx, query, limit)
+}
+
+// GetAuditEvents returns audit events matching the filter, newest first
+func (s *MemoryStorage) GetAuditEvents(ctx context.Context, filter models.AuditFilter, limit int) ([]models.AuditEvent, error) {
+	return s.keeper.GetAuditEvents(ctx, filter, limit)
+}
+
+// GetEnrichmentAttempt returns the enrichment state of a user
+func (s *MemoryStorage) GetEnrichmentAttempt(ctx context.Context, userID int) (models.EnrichmentAttempt, error) {
+	return s.keeper.GetEnrichmentAttempt(ctx, userID)
+}
+
+// SaveEnrichmentAttempts creates or replaces the enrichment states of users
+func (s *MemoryStorage) SaveEnrichmentAttempts(ctx context.Context, attempts []models.EnrichmentAttempt) error {
+	return s.keeper.SaveEnrichmentAttempts(ctx, attempts)
+}
+
+// GetEnrichmentAttempts lists the enrichment states with the statuses, most recently updated first
+func (s *MemoryStorage) GetEnrichmentAttempts(ctx context.Context, statuses []string, limit int) ([]models.EnrichmentAttempt, error) {
+	return s.keeper.GetEnrichmentAttempts(ctx, statuses, limit)
+}
+
+// CountEnrichmentAttempts counts the enrichment states by status
+func (s *MemoryStorage) CountEnrichmentAttempts(ctx context.Context) (map[string]int, error) {
+	return s.keeper.CountEnrichmentAttempts(ctx)
+}
+
+// EnqueueJob adds a job to the durable queue
+func (s *MemoryStorage) EnqueueJob(ctx context.Context, job models.Job) (int64, error) {
+	return s.keeper.EnqueueJob(ctx, job)
+}
+
+// ClaimJobs locks up to limit due jobs for the visibility timeout
+func (s *MemoryStorage) ClaimJobs(ctx context.Context, limit int, visibility time.Duration) ([]models.Job, error) {
+	return s.keeper.ClaimJobs(ctx, limit, visibility)
+}
+
+// CompleteJob removes a finished job from the queue
+func (s *MemoryStorage) CompleteJob(ctx context.Context, id int64) error {
+	return s.keeper.CompleteJob(ctx, id)
+}
+
+// RetryJob puts a claimed job back in the queue to run at runAt
+func (s *MemoryStorage) RetryJob(ctx context.Context, id int64, attempts int, runAt time.Time, lastError string) error {
+	return s.keeper.RetryJob(ctx, id, attempts, runAt, lastError)
+}
+
+// BuryJob marks a job that ran out of attempts as dead
+func (s *MemoryStorage) BuryJob(ctx context.Context, id int64, lastError string) error {
+	return s.keeper.BuryJob(ctx, id, lastError)
+}
 
+// SaveReportSubscription creates a report subscription and returns its id
+func (s *MemoryStorage) SaveReportSubscription(ctx context.Context, sub models.ReportSubscription) (int, error) {
+	return s.keeper.SaveReportSubscription(ctx, sub)
+}
+
+// GetReportSubscription retrieves a report subscription by its id
+func (s *MemoryStorage) GetReportSubscription(ctx context.Context, id int) (models.ReportSubscription, error) {
+	return s.keeper.GetReportSubscription(ctx, id)
+}
+
+// GetReportSubscriptions lists the report subscriptions in the order they were created
+func (s *MemoryStorage) GetReportSubscriptions(ctx context.Context) ([]models.ReportSubscription, error) {
+	return s.keeper.GetReportSubscriptions(ctx)
+}
+
+// DeleteReportSubscription deletes a report subscription
+func (s *MemoryStorage) DeleteReportSubscription(ctx context.Context, id int) error {
+	return s.keeper.DeleteReportSubscription(ctx, id)
+}
+
+// MarkReportSent records when the report of a subscription was last sent
+func (s *MemoryStorage) MarkReportSent(ctx context.Context, id int, sentAt time.Time) error {
+	return s.keeper.MarkReportSent(ctx, id, sentAt)
+}
+
+// SaveWebhook creates a webhook and returns its id
+func (s *MemoryStorage) SaveWebhook(ctx context.Context, hook models.Webhook) (int, error) {
+	return s.keeper.SaveWebhook(ctx, hook)
+}
+
+// GetWebhook retrieves a webhook by its id
+func (s *MemoryStorage) GetWebhook(ctx context.Context, id int) (models.Webhook, error) {
+	return s.keeper.GetWebhook(ctx, id)
+}
+
+// GetWebhooks lists the webhooks in the order they were created
+func (s *MemoryStorage) GetWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	return s.keeper.GetWebhooks(ctx)
+}
+
+// DeleteWebhook deletes a webhook with its deliveries
+func (s *MemoryStorage) DeleteWebhook(ctx context.Context, id int) error {
+	return s.keeper.DeleteWebhook(ctx, id)
+}
+
+// SaveWebhookDelivery adds a pending delivery of a webhook and returns its id
+func (s *MemoryStorage) SaveWebhookDelivery(ctx context.Context, d models.WebhookDelivery) (int64, error) {
+	return s.keeper.SaveWebhookDelivery(ctx, d)
+}
+
+// GetWebhookDelivery retrieves a delivery by its id
+func (s *MemoryStorage) GetWebhookDelivery(ctx context.Context, id int64) (models.WebhookDelivery, error) {
+	return s.keeper.GetWebhookDelivery(ctx, id)
+}
+
+// GetWebhookDeliveries lists up to limit deliveries of a webhook, the most recent first
+func (s *MemoryStorage) GetWebhookDeliveries(ctx context.Context, webhookID int, limit int) ([]models.WebhookDelivery, error) {
+	return s.keeper.GetWebhookDeliveries(ctx, webhookID, limit)
+}
+
+// UpdateWebhookDelivery records the outcome of an attempt of a delivery
+func (s *MemoryStorage) UpdateWebhookDelivery(ctx context.Context, d models.WebhookDelivery) error {
+	return s.keeper.UpdateWebhookDelivery(ctx, d)
+}
+
+// ResyncUser makes the next enrichment pass refresh a user, even a dead-lettered one
+func (s *MemoryStorage) ResyncUser(ctx context.Context, id int) error {
 	s.umx.Lock()
 	defer s.umx.Unlock()
 
-	if _, exists := s.users[id]; !exists {
-		return ErrNotFound
+	if err := s.keeper.ResyncUser(ctx, id); err != nil {
+		return err
 	}
 
-	// Delete the user from the keeper
-	if err := s.keeper.DeleteUser(ctx, id); err != nil {
+	s.users.Update(id, func(user models.User) models.User {
+		user.LastCheckedAt = time.Time{}
+		return user
+	})
+
+	return nil
+}
+
+// EraseUser anonymizes a user, keeping the time entries
+func (s *MemoryStorage) EraseUser(ctx context.Context, id int) error {
+	s.umx.Lock()
+	defer s.umx.Unlock()
+
+	if err := s.keeper.EraseUser(ctx, id); err != nil {
 		return err
 	}
 
-	// Also delete from the in-memory map
-	delete(s.users, id)
+	// Erased users can no longer log in or be listed
+	s.users.Delete(id)
 
 	return nil
 }
 
-// GetNonUpdateUsers retrieves users who haven't been updated within a specified interval
-func (s *MemoryStorage) GetNonUpdateUsers(ctx context.Context) ([]models.ExtUserData, error) {
-	users, err := s.keeper.GetNonUpdateUsers(ctx)
+// ClaimNonUpdateUsers leases up to limit users who haven't been updated within a specified interval
+func (s *MemoryStorage) ClaimNonUpdateUsers(ctx context.Context, limit int, lease time.Duration) ([]models.ExtUserData, error) {
+	users, err := s.keeper.ClaimNonUpdateUsers(ctx, limit, lease)
 	if err != nil {
 		return nil, err
 	}
@@ -244,28 +509,35 @@ func (s *MemoryStorage) GetNonUpdateUsers(ctx context.Context) ([]models.ExtUser
 	return users, nil
 }
 
-// InsertTask inserts a new task into the storage
-func (s *MemoryStorage) InsertTask(ctx context.Context, task models.Task) error {
+// ClaimUser leases a user for enrichment regardless of when it was checked
+func (s *MemoryStorage) ClaimUser(ctx context.Context, userID int, lease time.Duration) (models.ExtUserData, error) {
+	return s.keeper.ClaimUser(ctx, userID, lease)
+}
+
+// InsertTask inserts a new task into the storage and returns its ID
+func (s *MemoryStorage) InsertTask(ctx context.Context, task models.Task) (int, error) {
 	s.omx.Lock()
 	defer s.omx.Unlock()
 
-	if _, exists := s.tasks[task.ID]; exists {
-		return ErrConflict
+	if s.mirror() {
+		if _, exists := s.tasks.Get(task.ID); exists {
+			return 0, ErrConflict
+		}
 	}
 
 	// Save the task to the keeper and get the generated ID
 	taskID, err := s.keeper.SaveTask(ctx, task)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	// Update the task ID with the generated ID
 	task.ID = taskID
 
-	// Save to the in-memory map with the new ID
-	s.tasks[task.ID] = task
+	// Save to the in-memory storage with the new ID
+	s.tasks.Set(task.ID, task)
 
-	return nil
+	return task.ID, nil
 }
 
 // UpdateTask updates an existing task in the storage
@@ -278,47 +550,37 @@ func (s *MemoryStorage) UpdateTask(ctx context.Context, task models.Task) error
 	s.omx.Lock()
 	defer s.omx.Unlock()
 
-	if o, exists := s.tasks[task.ID]; exists {
+	updated := s.tasks.Update(task.ID, func(o models.Task) models.Task {
 		o.Name = task.Name
 		o.Description = task.Description
 		o.CreatedAt = task.CreatedAt
-		s.tasks[task.ID] = o
-	} else {
+		return o
+	})
+
+	// The keeper has found the task, so only the mirror can miss it
+	if !updated && s.mirror() {
 		return ErrNotFound
 	}
 
 	return nil
 }
 
-// GetTasks retrieves tasks from the storage based on the provided filter and pagination
-func (s *MemoryStorage) GetTasks(ctx context.Context, filter models.TaskFilter, pagination models.Pagination) ([]models.Task, error) {
-	var result []models.Task
-	s.omx.RLock()
-	defer s.omx.RUnlock()
-
-	for _, task := range s.tasks {
-		if filter.Name != nil && !strings.Contains(task.Name, *filter.Name) {
-			continue
-		}
-		if filter.Description != nil && !strings.Contains(task.Description, *filter.Description) {
-			continue
-		}
-
-		result = append(result, task)
-	}
-
-	start := pagination.Offset
-	end := start + pagination.Limit
-
-	if start >= len(result) {
-		return []models.Task{}, nil
+// GetTasks retrieves a sorted page of tasks matching the filter and the number of all matching tasks.
+// Only the mirror holds all tasks, in the cache mode the keeper filters them.
+func (s *MemoryStorage) GetTasks(ctx context.Context, filter models.TaskFilter, pagination models.Pagination) ([]models.Task, int, error) {
+	if !s.mirror() {
+		return s.keeper.GetTasks(ctx, filter, pagination)
 	}
 
-	if end > len(result) {
-		end = len(result)
+	result := make([]models.Task, 0)
+	for _, task := range s.tasks.Values() {
+		if MatchTask(task, filter) {
+			result = append(result, task)
+		}
 	}
 
-	return result[start:end], nil
+	tasks, total := PageTasks(result, pagination)
+	return tasks, total, nil
 }
 
 // DeleteTask deletes a task from the storage
@@ -326,8 +588,11 @@ func (s *MemoryStorage) DeleteTask(ctx context.Context, id int) error {
 	s.omx.Lock()
 	defer s.omx.Unlock()
 
-	if _, exists := s.tasks[id]; !exists {
-		return ErrNotFound
+	// Only the mirror knows every task, the keeper reports missing ones in the cache mode
+	if s.mirror() {
+		if _, exists := s.tasks.Get(id); !exists {
+			return ErrNotFound
+		}
 	}
 
 	// Delete the task from the keeper
@@ -335,8 +600,8 @@ func (s *MemoryStorage) DeleteTask(ctx context.Context, id int) error {
 		return err
 	}
 
-	// Also delete from the in-memory map
-	delete(s.tasks, id)
+	// Also delete from the in-memory storage
+	s.tasks.Delete(id)
 
 	return nil
 }
@@ -361,6 +626,11 @@ func (s *MemoryStorage) StopTaskTracking(ctx context.Context, entry models.TimeE
 	return nil
 }
 
+// GetRunningTimers lists the time entries being tracked by user
+func (s *MemoryStorage) GetRunningTimers(ctx context.Context) ([]models.RunningTimer, error) {
+	return s.keeper.GetRunningTimers(ctx)
+}
+
 // GetUserTaskSummary retrieves a summary of tasks for a user within a specified date range
 func (s *MemoryStorage) GetUserTaskSummary(ctx context.Context, userID int, startDate, endDate time.Time, userTimezone string, defaultEndTime time.Time) ([]models.TaskSummary, error) {
 	summary, err := s.keeper.GetUserTaskSummary(ctx, userID, startDate, endDate, userTimezone, defaultEndTime)
@@ -373,15 +643,9 @@ func (s *MemoryStorage) GetUserTaskSummary(ctx context.Context, userID int, star
 
 // GetUser retrieves a user from the storage by passport series and number
 func (s *MemoryStorage) GetUser(ctx context.Context, passportSerie, passportNumber int) (models.User, error) {
-	s.umx.RLock()
-	defer s.umx.RUnlock()
-
 	// Attempt to find the user in the in-memory storage
-	for _, user := range s.users {
-		if user.PassportSerie == passportSerie && user.PassportNumber == passportNumber {
-			fmt.Println("User found in memory storage")
-			return user, nil
-		}
+	if user, exists := s.findUser(passportSerie, passportNumber); exists {
+		return user, nil
 	}
 
 	// If the user is not found in the in-memory storage, search in the database
@@ -392,26 +656,90 @@ func (s *MemoryStorage) GetUser(ctx context.Context, passportSerie, passportNumb
 
 	// Check for an empty user by checking the UUID field
 	if user.UUID == 0 {
-		fmt.Println("User not found in database")
 		return models.User{}, fmt.Errorf("user not found")
 	}
 
-	s.users[user.UUID] = user
-	fmt.Println("User found in database and added to memory storage:", user)
+	s.users.Set(user.UUID, user)
 	return user, nil
 }
 
-// GetUser retrieves a user from the storage by passport series and number
+// GetUserByID retrieves a user from the storage by the internal identifier,
+// reading it through the cache
 func (s *MemoryStorage) GetUserByID(ctx context.Context, id int) (models.User, error) {
-	s.umx.RLock()
-	defer s.umx.RUnlock()
+	if user, exists := s.users.Get(id); exists {
+		return user, nil
+	}
+
+	user, err := s.keeper.GetUserByID(ctx, id)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	s.users.Set(user.UUID, user)
+	return user, nil
+}
+
+// findUser looks a user up by passport among the users in memory
+func (s *MemoryStorage) findUser(passportSerie, passportNumber int) (models.User, bool) {
+	for _, user := range s.users.Values() {
+		if user.PassportSerie == passportSerie && user.PassportNumber == passportNumber {
+			return user, true
+		}
+	}
+
+	return models.User{}, false
+}
+
+// GetTwoFactor retrieves the two-factor settings of a user
+func (s *MemoryStorage) GetTwoFactor(ctx context.Context, userID int) (models.TwoFactor, error) {
+	return s.keeper.GetTwoFactor(ctx, userID)
+}
+
+// SaveTwoFactor creates or replaces the two-factor settings of a user
+func (s *MemoryStorage) SaveTwoFactor(ctx context.Context, tf models.TwoFactor) error {
+	return s.keeper.SaveTwoFactor(ctx, tf)
+}
+
+// DeleteTwoFactor removes the two-factor settings and recovery codes of a user
+func (s *MemoryStorage) DeleteTwoFactor(ctx context.Context, userID int) error {
+	return s.keeper.DeleteTwoFactor(ctx, userID)
+}
 
-	v, exists := s.users[id]
-	if !exists {
-		return models.User{}, errors.New("value with such key doesn't exist")
+// SaveRecoveryCodes replaces the recovery code hashes of a user
+func (s *MemoryStorage) SaveRecoveryCodes(ctx context.Context, userID int, hashes []string) error {
+	return s.keeper.SaveRecoveryCodes(ctx, userID, hashes)
+}
+
+// UseRecoveryCode marks an unused recovery code as used and clears the failed attempts
+func (s *MemoryStorage) UseRecoveryCode(ctx context.Context, userID int, hash string) error {
+	return s.keeper.UseRecoveryCode(ctx, userID, hash)
+}
+
+// UseTOTPStep records the time step of an accepted code unless it was already used
+func (s *MemoryStorage) UseTOTPStep(ctx context.Context, userID int, step int64) error {
+	return s.keeper.UseTOTPStep(ctx, userID, step)
+}
+
+// FailTwoFactor counts a wrong code and locks the verification out after limit of them
+func (s *MemoryStorage) FailTwoFactor(ctx context.Context, userID int, limit int, lockUntil time.Time) (int, error) {
+	return s.keeper.FailTwoFactor(ctx, userID, limit, lockUntil)
+}
+
+// GetUserByIdentity retrieves the user linked to an identity provider subject
+func (s *MemoryStorage) GetUserByIdentity(ctx context.Context, issuer, subject string) (models.User, error) {
+	user, err := s.keeper.GetUserByIdentity(ctx, issuer, subject)
+	if err != nil {
+		return models.User{}, err
 	}
 
-	return v, nil
+	s.users.Set(user.UUID, user)
+
+	return user, nil
+}
+
+// LinkIdentity links an identity provider subject to a user
+func (s *MemoryStorage) LinkIdentity(ctx context.Context, identity models.UserIdentity) error {
+	return s.keeper.LinkIdentity(ctx, identity)
 }
 
 // GetBaseConnection checks the base connection to the database
@@ -422,3 +750,43 @@ func (s *MemoryStorage) GetBaseConnection(ctx context.Context) bool {
 
 	return s.keeper.Ping(ctx)
 }
+
+// mergeUser applies the non-zero fields of a partial update to a user
+func mergeUser(user models.User, update models.User) models.User {
+	current := user
+	if update.PassportSerie != 0 {
+		user.PassportSerie = update.PassportSerie
+	}
+	if update.PassportNumber != 0 {
+		user.PassportNumber = update.PassportNumber
+	}
+	if update.Surname != "" {
+		user.Surname = update.Surname
+	}
+	if update.Name != "" {
+		user.Name = update.Name
+	}
+	if update.Patronymic != "" {
+		user.Patronymic = update.Patronymic
+	}
+	if update.Address != "" {
+		user.Address = update.Address
+	}
+	if !update.DefaultEndTime.IsZero() {
+		user.DefaultEndTime = update.DefaultEndTime
+	}
+	if update.Timezone != "" {
+		user.Timezone = update.Timezone
+	}
+	if len(update.Hash) > 0 {
+		user.Hash = update.Hash
+	}
+	if !update.LastCheckedAt.IsZero() {
+		user.LastCheckedAt = update.LastCheckedAt
+	} else if PassportChanged(current, user) {
+		// The keepers forget the last check of the old passport
+		user.LastCheckedAt = time.Time{}
+	}
+
+	return user
+}
@@ -0,0 +1,114 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wurt83ow/timetracker/internal/encryption"
+	"github.com/wurt83ow/timetracker/internal/memkeeper"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap"
+)
+
+type cacheOptions struct {
+	mode, size, ttl string
+}
+
+func (o cacheOptions) StorageMode() string { return o.mode }
+func (o cacheOptions) CacheSize() string   { return o.size }
+func (o cacheOptions) CacheTTL() string    { return o.ttl }
+
+func newTestStorage(t *testing.T, options cacheOptions) (*storage.MemoryStorage, storage.Keeper) {
+	t.Helper()
+
+	envelope, err := encryption.NewEnvelope("test_encryption_key")
+	require.NoError(t, err)
+
+	keeper := memkeeper.NewMemKeeper(func() string { return "" }, zap.NewNop(), func() string { return "5m" }, envelope)
+	return storage.NewMemoryStorage(context.Background(), keeper, zap.NewNop(), options), keeper
+}
+
+func TestMemoryStorage_CacheMode(t *testing.T) {
+	ctx := context.Background()
+	s, keeper := newTestStorage(t, cacheOptions{mode: storage.ModeCache, size: "2", ttl: "1m"})
+
+	var ids []int
+	for i := 0; i < 3; i++ {
+		id, err := s.InsertUser(ctx, models.User{PassportSerie: 1000 + i, PassportNumber: 100000, Surname: "Ivanov"})
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+
+	stats := s.CacheStats()
+	assert.Equal(t, storage.ModeCache, stats.Mode)
+	assert.Equal(t, "1m0s", stats.TTL)
+	assert.Equal(t, 2, stats.Users.Size, "the cache is bounded")
+	assert.Equal(t, uint64(1), stats.Users.Evictions)
+
+	// The evicted user is read through from the keeper
+	user, err := s.GetUserByID(ctx, ids[0])
+	require.NoError(t, err)
+	assert.Equal(t, "Ivanov", user.Surname)
+	_, err = s.GetUserByID(ctx, ids[0])
+	require.NoError(t, err)
+
+	stats = s.CacheStats()
+	assert.Equal(t, uint64(1), stats.Users.Misses)
+	assert.Equal(t, uint64(1), stats.Users.Hits)
+
+	// Lists come from the keeper, including users that are not cached
+	users, _, err := s.GetUsers(ctx, models.Filter{Surname: ptr("Ivanov")}, models.Pagination{Limit: 10})
+	require.NoError(t, err)
+	assert.Len(t, users, 3)
+
+	// Writes go through to the keeper
+	require.NoError(t, s.UpdateUser(ctx, models.User{UUID: ids[1], Address: "Moscow"}))
+	stored, err := keeper.GetUserByID(ctx, ids[1])
+	require.NoError(t, err)
+	assert.Equal(t, "Moscow", stored.Address)
+
+	assert.ErrorIs(t, s.DeleteTask(ctx, 1000), storage.ErrNotFound, "the keeper reports missing tasks")
+}
+
+func TestMemoryStorage_MirrorMode(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStorage(t, cacheOptions{mode: storage.ModeMirror})
+
+	for _, name := range []string{"Report", "Review"} {
+		_, err := s.InsertTask(ctx, models.Task{Name: name})
+		require.NoError(t, err)
+	}
+
+	tasks, _, err := s.GetTasks(ctx, models.TaskFilter{Name: ptr("Rev")}, models.Pagination{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, tasks, 1)
+	assert.Equal(t, "Review", tasks[0].Name)
+
+	// The mirror sorts and continues cursors like the keepers
+	sort, err := storage.ParseTaskSort("-name")
+	require.NoError(t, err)
+	tasks, total, err := s.GetTasks(ctx, models.TaskFilter{}, models.Pagination{Limit: 1, Sort: sort})
+	require.NoError(t, err)
+	assert.Equal(t, 2, total)
+	require.Len(t, tasks, 1)
+	assert.Equal(t, "Review", tasks[0].Name)
+
+	after := storage.TaskCursor(tasks[0], sort)
+	tasks, _, err = s.GetTasks(ctx, models.TaskFilter{}, models.Pagination{Limit: 1, Sort: sort, After: &after})
+	require.NoError(t, err)
+	require.Len(t, tasks, 1)
+	assert.Equal(t, "Report", tasks[0].Name)
+
+	stats := s.CacheStats()
+	assert.Equal(t, storage.ModeMirror, stats.Mode)
+	assert.Empty(t, stats.TTL)
+	assert.Equal(t, 2, stats.Tasks.Size)
+	assert.Zero(t, stats.Tasks.Capacity, "the mirror is not bounded")
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,1284 @@
+// Package storagetest is a conformance suite for storage.Keeper implementations.
+// Every keeper must pass it, so that the handlers behave the same on any backend.
+package storagetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wurt83ow/timetracker/internal/audit"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/search"
+	"github.com/wurt83ow/timetracker/internal/storage"
+)
+
+// NewKeeper returns an empty keeper for a single behavior
+type NewKeeper func(t *testing.T) storage.Keeper
+
+// Behavior is a semantic the handlers rely on
+type Behavior struct {
+	Name string
+	Test func(t *testing.T, kp storage.Keeper)
+}
+
+// Run checks every behavior against a fresh keeper returned by newKeeper
+func Run(t *testing.T, newKeeper NewKeeper) {
+	for _, b := range Behaviors {
+		t.Run(b.Name, func(t *testing.T) {
+			b.Test(t, newKeeper(t))
+		})
+	}
+}
+
+// Behaviors is the table every keeper is run through
+var Behaviors = []Behavior{
+	// Users
+	{"SaveUser/DuplicatePassportConflicts", saveUserDuplicatePassport},
+	{"GetUser/FindsByPassport", getUserByPassport},
+	{"GetUser/UnknownPassportIsZero", getUserUnknownPassport},
+	{"GetUserByID/MissingIsNotFound", getUserByIDMissing},
+	{"UpdateUser/KeepsEmptyFields", updateUserKeepsEmptyFields},
+	{"UpdateUser/MissingIsNotFound", updateUserMissing},
+	{"UpdateUser/TakenPassportConflicts", updateUserTakenPassport},
+	{"DeleteUser/MissingIsNotFound", deleteUserMissing},
+	{"LoadUsers/SkipsDeleted", loadUsersSkipsDeleted},
+	{"UpdateUsersInfo/WaitsForInterval", updateUsersInfoWaitsForInterval},
+	{"UpdateUsersInfo/KeepsMissingFields", updateUsersInfoKeepsMissingFields},
+	{"UpdateUsersInfo/AfterPassportChange", updateUsersInfoAfterPassportChange},
+	{"GetUsers/FiltersAndPages", getUsersFiltersAndPages},
+	{"GetUsers/SkipsErased", getUsersSkipsErased},
+	{"GetUsers/SortsAndContinuesCursor", getUsersSortsAndContinuesCursor},
+
+	// Enrichment
+	{"EnrichmentAttempts/SavesAndLists", enrichmentAttemptsSaveAndList},
+	{"ClaimNonUpdateUsers/SkipsBackoffAndDeadLetters", nonUpdateUsersSkipsFailing},
+	{"ClaimNonUpdateUsers/LeasesUsers", claimNonUpdateUsersLeases},
+	{"ClaimUser/IgnoresLastCheck", claimUserIgnoresLastCheck},
+	{"ResyncUser/RevivesDeadLetter", resyncUserRevivesDeadLetter},
+
+	// Tasks
+	{"GetTaskByID/ReturnsTask", getTaskByID},
+	{"UpdateTask/MissingIsNotFound", updateTaskMissing},
+	{"DeleteTask/MissingIsNotFound", deleteTaskMissing},
+	{"DeleteTask/RejectsTrackedTask", deleteTaskRejectsTracked},
+	{"LoadTasks/ReturnsUpdates", loadTasksReturnsUpdates},
+	{"GetTasks/FiltersAndPages", getTasksFiltersAndPages},
+	{"GetTasks/SortsAndContinuesCursor", getTasksSortsAndContinuesCursor},
+
+	// Search
+	{"Search/RanksTasksAndUsers", searchRanksTasksAndUsers},
+	{"Search/FollowsChanges", searchFollowsChanges},
+
+	// Time tracking
+	{"StartTaskTracking/RejectsOverlappingStart", startRejectsOverlap},
+	{"StopTaskTracking/RequiresActiveEntry", stopRequiresActiveEntry},
+	{"StartTaskTracking/RestartsAfterStop", startAfterStop},
+	{"GetRunningTimers/ListsActiveEntries", runningTimersListsActiveEntries},
+	{"GetUserTaskSummary/CountsRunningEntry", summaryCountsRunningEntry},
+	{"GetUserTaskSummary/EmptyPeriod", summaryEmptyPeriod},
+
+	// Two-factor authentication
+	{"GetTwoFactor/MissingIsNotFound", getTwoFactorMissing},
+	{"SaveTwoFactor/Replaces", saveTwoFactorReplaces},
+	{"DeleteTwoFactor/MissingIsNotFound", deleteTwoFactorMissing},
+	{"UseRecoveryCode/SingleUse", useRecoveryCodeSingleUse},
+	{"UseTOTPStep/RejectsUsedSteps", useTOTPStepRejectsUsedSteps},
+	{"FailTwoFactor/LocksAfterLimit", failTwoFactorLocksAfterLimit},
+	{"DeleteTwoFactor/DeletesRecoveryCodes", deleteTwoFactorDeletesCodes},
+
+	// Identities
+	{"GetUserByIdentity/MissingIsNotFound", getUserByIdentityMissing},
+	{"LinkIdentity/DuplicateConflicts", linkIdentityDuplicate},
+
+	// Privacy
+	{"ExportUser/CollectsData", exportUserCollectsData},
+	{"ExportUser/MissingIsNotFound", exportUserMissing},
+	{"EraseUser/KeepsTimeEntries", eraseUserKeepsTimeEntries},
+	{"EraseUser/TwiceIsNotFound", eraseUserTwice},
+
+	// Audit
+	{"GetAuditEvents/NewestFirst", auditNewestFirst},
+	{"GetAuditEvents/RecordsMetadata", auditRecordsMetadata},
+	{"GetAuditEvents/BeforeIDPages", auditBeforeIDPages},
+	{"GetAuditEvents/RedactsSensitiveFields", auditRedactsSensitiveFields},
+
+	// Jobs
+	{"EnqueueJob/PendingKeyConflicts", enqueueJobPendingKeyConflicts},
+	{"ClaimJobs/SchedulesAndLocks", claimJobsSchedulesAndLocks},
+	{"ClaimJobs/ReclaimsExpiredLock", claimJobsReclaimsExpiredLock},
+	{"RetryJob/RequeuesAndBuries", retryJobRequeuesAndBuries},
+
+	// Reports
+	{"ReportSubscriptions/SavesListsAndDeletes", reportSubscriptionsSaveListDelete},
+	{"MarkReportSent/MissingIsNotFound", markReportSentMissing},
+
+	// Webhooks
+	{"Webhooks/SavesListsAndDeletes", webhooksSaveListDelete},
+	{"WebhookDeliveries/RecordsAttempts", webhookDeliveriesRecordAttempts},
+}
+
+const (
+	passportSerie  = 1234
+	passportNumber = 567890
+)
+
+// saveUser stores the user every behavior starts with
+func saveUser(t *testing.T, kp storage.Keeper) int {
+	t.Helper()
+
+	userID, err := kp.SaveUser(context.Background(), models.User{
+		PassportSerie:  passportSerie,
+		PassportNumber: passportNumber,
+		Surname:        "Ivanov",
+		Name:           "Ivan",
+		Timezone:       "UTC",
+		Hash:           []byte("hash"),
+	})
+	require.NoError(t, err)
+
+	return userID
+}
+
+func saveTask(t *testing.T, kp storage.Keeper) int {
+	t.Helper()
+
+	taskID, err := kp.SaveTask(context.Background(), models.Task{Name: "Report", Description: "Monthly", CreatedAt: time.Now()})
+	require.NoError(t, err)
+
+	return taskID
+}
+
+func timeEntry(userID, taskID int) models.TimeEntry {
+	return models.TimeEntry{EventDate: time.Now().UTC(), UserID: userID, TaskID: taskID, UserTimezone: "UTC"}
+}
+
+func saveUserDuplicatePassport(t *testing.T, kp storage.Keeper) {
+	saveUser(t, kp)
+
+	_, err := kp.SaveUser(context.Background(), models.User{PassportSerie: passportSerie, PassportNumber: passportNumber})
+	assert.ErrorIs(t, err, storage.ErrConflict)
+}
+
+func getUserByPassport(t *testing.T, kp storage.Keeper) {
+	userID := saveUser(t, kp)
+
+	user, err := kp.GetUser(context.Background(), passportSerie, passportNumber)
+	require.NoError(t, err)
+	assert.Equal(t, userID, user.UUID)
+	assert.Equal(t, "Ivanov", user.Surname)
+	assert.Equal(t, []byte("hash"), user.Hash)
+}
+
+func getUserUnknownPassport(t *testing.T, kp storage.Keeper) {
+	user, err := kp.GetUser(context.Background(), 1, 1)
+	require.NoError(t, err, "login treats a zero user as wrong credentials")
+	assert.Zero(t, user.UUID)
+}
+
+func getUserByIDMissing(t *testing.T, kp storage.Keeper) {
+	_, err := kp.GetUserByID(context.Background(), 1000)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func updateUserKeepsEmptyFields(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	userID := saveUser(t, kp)
+
+	require.NoError(t, kp.UpdateUser(ctx, models.User{UUID: userID, Address: "Moscow", PassportNumber: 111111}))
+
+	user, err := kp.GetUserByID(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, "Moscow", user.Address)
+	assert.Equal(t, "Ivanov", user.Surname)
+	assert.Equal(t, passportSerie, user.PassportSerie, "a partial passport change keeps the serie")
+	assert.Equal(t, 111111, user.PassportNumber)
+
+	found, err := kp.GetUser(ctx, passportSerie, 111111)
+	require.NoError(t, err)
+	assert.Equal(t, userID, found.UUID, "the user is found by the new passport")
+}
+
+func updateUserMissing(t *testing.T, kp storage.Keeper) {
+	err := kp.UpdateUser(context.Background(), models.User{UUID: 1000, Address: "Moscow"})
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func updateUserTakenPassport(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	saveUser(t, kp)
+
+	otherID, err := kp.SaveUser(ctx, models.User{PassportSerie: 4321, PassportNumber: 98765})
+	require.NoError(t, err)
+
+	err = kp.UpdateUser(ctx, models.User{UUID: otherID, PassportSerie: passportSerie, PassportNumber: passportNumber})
+	assert.ErrorIs(t, err, storage.ErrConflict)
+}
+
+func deleteUserMissing(t *testing.T, kp storage.Keeper) {
+	assert.ErrorIs(t, kp.DeleteUser(context.Background(), 1000), storage.ErrNotFound)
+}
+
+func loadUsersSkipsDeleted(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	userID := saveUser(t, kp)
+
+	otherID, err := kp.SaveUser(ctx, models.User{PassportSerie: 4321, PassportNumber: 98765})
+	require.NoError(t, err)
+	require.NoError(t, kp.DeleteUser(ctx, otherID))
+
+	users, err := kp.LoadUsers(ctx)
+	require.NoError(t, err)
+	assert.Len(t, users, 1)
+	assert.Contains(t, users, userID)
+}
+
+func updateUsersInfoWaitsForInterval(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	_, err := kp.SaveUser(ctx, models.User{PassportSerie: passportSerie, PassportNumber: passportNumber})
+	require.NoError(t, err)
+
+	users, err := kp.ClaimNonUpdateUsers(ctx, 100, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+
+	users[0].Surname = "Ivanov"
+	ids, err := kp.UpdateUsersInfo(ctx, users)
+	require.NoError(t, err)
+	assert.Equal(t, []int{users[0].UserID}, ids)
+
+	user, err := kp.GetUser(ctx, passportSerie, passportNumber)
+	require.NoError(t, err)
+	assert.Equal(t, "Ivanov", user.Surname)
+	assert.False(t, user.LastCheckedAt.IsZero())
+
+	users[0].Surname = "Petrov"
+	ids, err = kp.UpdateUsersInfo(ctx, users)
+	require.NoError(t, err)
+	assert.Empty(t, ids, "a recently checked user is not updated")
+
+	user, err = kp.GetUser(ctx, passportSerie, passportNumber)
+	require.NoError(t, err)
+	assert.Equal(t, "Ivanov", user.Surname)
+
+	users, err = kp.ClaimNonUpdateUsers(ctx, 100, time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, users, "enriched users wait for the update interval")
+}
+
+func updateUsersInfoAfterPassportChange(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	userID := saveUser(t, kp)
+
+	ids, err := kp.UpdateUsersInfo(ctx, []models.ExtUserData{{PassportSerie: passportSerie, PassportNumber: passportNumber, Surname: "Ivanov"}})
+	require.NoError(t, err)
+	require.Equal(t, []int{userID}, ids)
+
+	require.NoError(t, kp.UpdateUser(ctx, models.User{UUID: userID, PassportNumber: passportNumber + 1}))
+
+	user, err := kp.GetUserByID(ctx, userID)
+	require.NoError(t, err)
+	assert.True(t, user.LastCheckedAt.IsZero(), "the last check was of the old passport")
+
+	ids, err = kp.UpdateUsersInfo(ctx, []models.ExtUserData{{UserID: userID, PassportSerie: passportSerie, PassportNumber: passportNumber + 1, Surname: "Petrov"}})
+	require.NoError(t, err)
+	assert.Equal(t, []int{userID}, ids, "the new passport is enriched although the user was checked recently")
+
+	user, err = kp.GetUserByID(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, "Petrov", user.Surname)
+}
+
+func updateUsersInfoKeepsMissingFields(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	_, err := kp.SaveUser(ctx, models.User{PassportSerie: passportSerie, PassportNumber: passportNumber, Surname: "Ivanov", Address: "Moscow"})
+	require.NoError(t, err)
+
+	_, err = kp.UpdateUsersInfo(ctx, []models.ExtUserData{{
+		PassportSerie: passportSerie, PassportNumber: passportNumber, Name: "Ivan", Patronymic: "Ivanovich",
+	}})
+	require.NoError(t, err)
+
+	user, err := kp.GetUser(ctx, passportSerie, passportNumber)
+	require.NoError(t, err)
+	assert.Equal(t, "Ivanov", user.Surname, "fields without a value are not cleared")
+	assert.Equal(t, "Ivan", user.Name)
+	assert.Equal(t, "Ivanovich", user.Patronymic)
+	assert.Equal(t, "Moscow", user.Address)
+}
+
+func enrichmentAttemptsSaveAndList(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	userID := saveUser(t, kp)
+	otherID, err := kp.SaveUser(ctx, models.User{PassportSerie: 4321, PassportNumber: 98765})
+	require.NoError(t, err)
+
+	_, err = kp.GetEnrichmentAttempt(ctx, userID)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	next := now.Add(time.Minute)
+	require.NoError(t, kp.SaveEnrichmentAttempts(ctx, []models.EnrichmentAttempt{
+		{UserID: userID, Status: models.EnrichmentFailed, Attempts: 1, LastError: "unavailable", NextRetryAt: &next, UpdatedAt: now},
+		{UserID: otherID, Status: models.EnrichmentSucceeded, UpdatedAt: now.Add(-time.Minute)},
+		{UserID: otherID + 100, Status: models.EnrichmentFailed, UpdatedAt: now}, // deleted users are skipped
+	}))
+
+	a, err := kp.GetEnrichmentAttempt(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, models.EnrichmentFailed, a.Status)
+	assert.Equal(t, 1, a.Attempts)
+	assert.Equal(t, "unavailable", a.LastError)
+	require.NotNil(t, a.NextRetryAt)
+	assert.True(t, next.Equal(*a.NextRetryAt))
+	assert.True(t, now.Equal(a.UpdatedAt))
+
+	// Saving again replaces the state
+	require.NoError(t, kp.SaveEnrichmentAttempts(ctx, []models.EnrichmentAttempt{
+		{UserID: userID, Status: models.EnrichmentDead, Attempts: 2, LastError: "not found", UpdatedAt: now},
+	}))
+
+	attempts, err := kp.GetEnrichmentAttempts(ctx, []string{models.EnrichmentFailed, models.EnrichmentDead}, 10)
+	require.NoError(t, err)
+	require.Len(t, attempts, 1)
+	assert.Equal(t, userID, attempts[0].UserID)
+	assert.Equal(t, models.EnrichmentDead, attempts[0].Status)
+	assert.Nil(t, attempts[0].NextRetryAt)
+
+	attempts, err = kp.GetEnrichmentAttempts(ctx, nil, 10)
+	require.NoError(t, err)
+	require.Len(t, attempts, 2)
+	assert.Equal(t, []int{userID, otherID}, []int{attempts[0].UserID, attempts[1].UserID}, "most recently updated first")
+
+	counts, err := kp.CountEnrichmentAttempts(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{models.EnrichmentDead: 1, models.EnrichmentSucceeded: 1}, counts)
+}
+
+func nonUpdateUsersSkipsFailing(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	userID := saveUser(t, kp)
+
+	users, err := kp.ClaimNonUpdateUsers(ctx, 100, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, userID, users[0].UserID)
+
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, kp.SaveEnrichmentAttempts(ctx, []models.EnrichmentAttempt{
+		{UserID: userID, Status: models.EnrichmentFailed, Attempts: 1, NextRetryAt: &later, UpdatedAt: time.Now()},
+	}))
+	users, err = kp.ClaimNonUpdateUsers(ctx, 100, time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, users, "a failed user waits for its next retry")
+
+	earlier := time.Now().Add(-time.Second)
+	require.NoError(t, kp.SaveEnrichmentAttempts(ctx, []models.EnrichmentAttempt{
+		{UserID: userID, Status: models.EnrichmentFailed, Attempts: 1, NextRetryAt: &earlier, UpdatedAt: time.Now()},
+	}))
+	users, err = kp.ClaimNonUpdateUsers(ctx, 100, time.Minute)
+	require.NoError(t, err)
+	assert.Len(t, users, 1)
+
+	require.NoError(t, kp.SaveEnrichmentAttempts(ctx, []models.EnrichmentAttempt{
+		{UserID: userID, Status: models.EnrichmentDead, Attempts: 5, UpdatedAt: time.Now()},
+	}))
+	users, err = kp.ClaimNonUpdateUsers(ctx, 100, time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, users, "a dead-lettered user is not retried")
+}
+
+func claimNonUpdateUsersLeases(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	userID := saveUser(t, kp)
+	otherID, err := kp.SaveUser(ctx, models.User{PassportSerie: 4321, PassportNumber: 98765})
+	require.NoError(t, err)
+
+	users, err := kp.ClaimNonUpdateUsers(ctx, 1, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, users, 1, "the claim is bounded by the limit")
+	assert.Equal(t, userID, users[0].UserID)
+	assert.Equal(t, passportSerie, users[0].PassportSerie)
+
+	users, err = kp.ClaimNonUpdateUsers(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, users, 1, "leased users are not claimed again")
+	assert.Equal(t, otherID, users[0].UserID)
+
+	users, err = kp.ClaimNonUpdateUsers(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, users)
+
+	// Saving an attempt releases the lease
+	require.NoError(t, kp.SaveEnrichmentAttempts(ctx, []models.EnrichmentAttempt{
+		{UserID: userID, Status: models.EnrichmentFailed, Attempts: 1, UpdatedAt: time.Now()},
+	}))
+	users, err = kp.ClaimNonUpdateUsers(ctx, 10, -time.Second)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, userID, users[0].UserID)
+
+	// An expired lease doesn't hold the user
+	users, err = kp.ClaimNonUpdateUsers(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, userID, users[0].UserID)
+}
+
+func claimUserIgnoresLastCheck(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	userID := saveUser(t, kp)
+
+	_, err := kp.UpdateUsersInfo(ctx, []models.ExtUserData{{PassportSerie: passportSerie, PassportNumber: passportNumber, Surname: "Ivanov"}})
+	require.NoError(t, err)
+
+	user, err := kp.ClaimUser(ctx, userID, time.Minute)
+	require.NoError(t, err, "a recently checked user can be claimed")
+	assert.Equal(t, userID, user.UserID)
+	assert.Equal(t, passportNumber, user.PassportNumber)
+	assert.Equal(t, "Ivanov", user.Surname)
+
+	_, err = kp.ClaimUser(ctx, userID, time.Minute)
+	assert.ErrorIs(t, err, storage.ErrConflict, "a leased user is not claimed again")
+
+	_, err = kp.ClaimUser(ctx, userID+100, time.Minute)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func resyncUserRevivesDeadLetter(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	userID := saveUser(t, kp)
+
+	users, err := kp.ClaimNonUpdateUsers(ctx, 100, time.Minute)
+	require.NoError(t, err)
+	_, err = kp.UpdateUsersInfo(ctx, users)
+	require.NoError(t, err)
+	require.NoError(t, kp.SaveEnrichmentAttempts(ctx, []models.EnrichmentAttempt{
+		{UserID: userID, Status: models.EnrichmentDead, Attempts: 5, UpdatedAt: time.Now()},
+	}))
+
+	require.NoError(t, kp.ResyncUser(ctx, userID))
+
+	users, err = kp.ClaimNonUpdateUsers(ctx, 100, time.Minute)
+	require.NoError(t, err)
+	assert.Len(t, users, 1, "a resynced user is enriched on the next pass, even if it was checked recently")
+
+	_, err = kp.GetEnrichmentAttempt(ctx, userID)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	assert.ErrorIs(t, kp.ResyncUser(ctx, userID+100), storage.ErrNotFound)
+}
+
+func getUsersFiltersAndPages(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	var ids []int
+	for i, surname := range []string{"Ivanov", "Petrov", "Ivanova", "Sidorov"} {
+		id, err := kp.SaveUser(ctx, models.User{PassportSerie: 1000 + i, PassportNumber: 100000, Surname: surname, Timezone: "Europe/Moscow"})
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+
+	page := models.Pagination{Limit: 10}
+
+	users, total, err := kp.GetUsers(ctx, models.Filter{Surname: ptr("Ivanov")}, page)
+	require.NoError(t, err)
+	require.Len(t, users, 2, "text fields match by substring")
+	assert.Equal(t, 2, total)
+	assert.Equal(t, ids[0], users[0].UUID, "users are ordered by id")
+	assert.Equal(t, ids[2], users[1].UUID)
+	assert.Equal(t, "Ivanova", users[1].Surname)
+
+	users, _, err = kp.GetUsers(ctx, models.Filter{Surname: ptr("ivanov")}, page)
+	require.NoError(t, err)
+	assert.Empty(t, users, "text filters are case-sensitive")
+
+	users, _, err = kp.GetUsers(ctx, models.Filter{PassportSerie: ptr(1001), PassportNumber: ptr(100000)}, page)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, ids[1], users[0].UUID)
+
+	users, total, err = kp.GetUsers(ctx, models.Filter{PassportNumber: ptr(100000), Timezone: ptr("Moscow")}, models.Pagination{Offset: 1, Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+	assert.Equal(t, 4, total, "the total counts all matching users")
+	assert.Equal(t, ids[1], users[0].UUID)
+	assert.Equal(t, ids[2], users[1].UUID)
+
+	users, _, err = kp.GetUsers(ctx, models.Filter{}, models.Pagination{Offset: 10, Limit: 2})
+	require.NoError(t, err)
+	assert.Empty(t, users)
+}
+
+func getUsersSkipsErased(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	userID := saveUser(t, kp)
+	require.NoError(t, kp.EraseUser(ctx, userID))
+
+	users, _, err := kp.GetUsers(ctx, models.Filter{}, models.Pagination{Limit: 10})
+	require.NoError(t, err)
+	assert.Empty(t, users)
+}
+
+func getUsersSortsAndContinuesCursor(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	var ids []int
+	for i, surname := range []string{"Petrov", "Ivanov", "Petrov", "Sidorov"} {
+		id, err := kp.SaveUser(ctx, models.User{PassportSerie: 1000 + i, PassportNumber: 100000, Surname: surname})
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+
+	sort, err := storage.ParseUserSort("-surname")
+	require.NoError(t, err)
+
+	users, total, err := kp.GetUsers(ctx, models.Filter{}, models.Pagination{Limit: 2, Sort: sort})
+	require.NoError(t, err)
+	assert.Equal(t, 4, total)
+	require.Len(t, users, 2)
+	assert.Equal(t, ids[3], users[0].UUID)
+	assert.Equal(t, ids[0], users[1].UUID, "ties are ordered by id")
+
+	after := storage.UserCursor(users[1], sort)
+	users, total, err = kp.GetUsers(ctx, models.Filter{}, models.Pagination{Limit: 2, Sort: sort, After: &after})
+	require.NoError(t, err)
+	assert.Equal(t, 4, total, "the total ignores the cursor")
+	require.Len(t, users, 2)
+	assert.Equal(t, ids[2], users[0].UUID)
+	assert.Equal(t, ids[1], users[1].UUID)
+
+	after = storage.UserCursor(users[1], sort)
+	users, _, err = kp.GetUsers(ctx, models.Filter{}, models.Pagination{Limit: 2, Sort: sort, After: &after})
+	require.NoError(t, err)
+	assert.Empty(t, users)
+}
+
+func getTasksFiltersAndPages(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	var ids []int
+	for _, name := range []string{"Report", "Review", "Monthly report", "Deploy"} {
+		id, err := kp.SaveTask(ctx, models.Task{Name: name, Description: "Team " + name})
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+
+	tasks, _, err := kp.GetTasks(ctx, models.TaskFilter{Name: ptr("Re")}, models.Pagination{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, tasks, 2, "the name filter is case-sensitive")
+	assert.Equal(t, ids[0], tasks[0].ID, "tasks are ordered by id")
+	assert.Equal(t, ids[1], tasks[1].ID)
+
+	tasks, total, err := kp.GetTasks(ctx, models.TaskFilter{Description: ptr("Team")}, models.Pagination{Offset: 1, Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, tasks, 2)
+	assert.Equal(t, 4, total, "the total counts all matching tasks")
+	assert.Equal(t, ids[1], tasks[0].ID)
+	assert.Equal(t, "Team Review", tasks[0].Description)
+	assert.Equal(t, ids[2], tasks[1].ID)
+
+	tasks, _, err = kp.GetTasks(ctx, models.TaskFilter{}, models.Pagination{Limit: 0})
+	require.NoError(t, err)
+	assert.Empty(t, tasks, "a zero limit returns no tasks, as the mirror does")
+}
+
+func getTasksSortsAndContinuesCursor(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	created := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	var ids []int
+	for _, task := range []models.Task{
+		{Name: "Review", CreatedAt: created},
+		{Name: "Deploy", CreatedAt: created.Add(time.Hour)},
+		{Name: "Report", CreatedAt: created},
+		{Name: "Backlog"},
+	} {
+		id, err := kp.SaveTask(ctx, task)
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+
+	sort, err := storage.ParseTaskSort("-created_at,name")
+	require.NoError(t, err)
+
+	var got []int
+	var after *models.Cursor
+	for page := 0; page < 3; page++ {
+		tasks, total, err := kp.GetTasks(ctx, models.TaskFilter{}, models.Pagination{Limit: 3, Sort: sort, After: after})
+		require.NoError(t, err)
+		assert.Equal(t, 4, total)
+		if len(tasks) == 0 {
+			break
+		}
+
+		for _, task := range tasks {
+			got = append(got, task.ID)
+		}
+		cursor := storage.TaskCursor(tasks[len(tasks)-1], sort)
+		after = &cursor
+	}
+	assert.Equal(t, []int{ids[1], ids[2], ids[0], ids[3]}, got, "tasks without a creation time come last in descending order")
+
+	sort, err = storage.ParseTaskSort("-name")
+	require.NoError(t, err)
+
+	tasks, _, err := kp.GetTasks(ctx, models.TaskFilter{}, models.Pagination{Offset: 1, Limit: 2, Sort: sort})
+	require.NoError(t, err)
+	require.Len(t, tasks, 2)
+	assert.Equal(t, ids[2], tasks[0].ID)
+	assert.Equal(t, ids[1], tasks[1].ID)
+}
+
+func searchRanksTasksAndUsers(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	reportID, err := kp.SaveTask(ctx, models.Task{Name: "Monthly report", Description: "Finance"})
+	require.NoError(t, err)
+	reviewID, err := kp.SaveTask(ctx, models.Task{Name: "Review", Description: "Check the monthly reporting"})
+	require.NoError(t, err)
+	_, err = kp.SaveTask(ctx, models.Task{Name: "Deploy"})
+	require.NoError(t, err)
+	userID, err := kp.SaveUser(ctx, models.User{PassportSerie: passportSerie, PassportNumber: passportNumber, Surname: "Reporter", Name: "Ivan"})
+	require.NoError(t, err)
+
+	results, err := kp.Search(ctx, "Report", 10)
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.Equal(t, models.SearchResult{Type: search.TypeTask, ID: reportID, Title: "Monthly report", Rank: search.WeightName}, results[0], "a whole word of a name ranks first")
+	assert.Equal(t, models.SearchResult{Type: search.TypeUser, ID: userID, Title: "Reporter Ivan", Rank: search.WeightName / 2}, results[1])
+	assert.Equal(t, reviewID, results[2].ID, "a description ranks below a name")
+
+	results, err = kp.Search(ctx, "monthly fin", 10)
+	require.NoError(t, err)
+	require.Len(t, results, 1, "every word has to match")
+	assert.Equal(t, reportID, results[0].ID)
+
+	results, err = kp.Search(ctx, "report", 1)
+	require.NoError(t, err)
+	assert.Len(t, results, 1)
+
+	results, err = kp.Search(ctx, "?!", 10)
+	require.NoError(t, err)
+	assert.Empty(t, results)
+}
+
+func searchFollowsChanges(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	taskID := saveTask(t, kp)
+	userID := saveUser(t, kp)
+
+	require.NoError(t, kp.UpdateTask(ctx, models.Task{ID: taskID, Name: "Deploy"}))
+	require.NoError(t, kp.EraseUser(ctx, userID))
+
+	results, err := kp.Search(ctx, "report", 10)
+	require.NoError(t, err)
+	assert.Empty(t, results, "the old task name is not found")
+
+	results, err = kp.Search(ctx, "ivanov", 10)
+	require.NoError(t, err)
+	assert.Empty(t, results, "erased users are not found")
+
+	results, err = kp.Search(ctx, "deploy", 10)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, taskID, results[0].ID)
+}
+
+func getTaskByID(t *testing.T, kp storage.Keeper) {
+	taskID := saveTask(t, kp)
+
+	task, err := kp.GetTaskByID(context.Background(), taskID)
+	require.NoError(t, err)
+	assert.Equal(t, taskID, task.ID)
+	assert.Equal(t, "Report", task.Name)
+	assert.Equal(t, "Monthly", task.Description)
+	assert.False(t, task.CreatedAt.IsZero())
+
+	_, err = kp.GetTaskByID(context.Background(), taskID+1)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func updateTaskMissing(t *testing.T, kp storage.Keeper) {
+	err := kp.UpdateTask(context.Background(), models.Task{ID: 1000, Name: "Review"})
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func deleteTaskMissing(t *testing.T, kp storage.Keeper) {
+	assert.ErrorIs(t, kp.DeleteTask(context.Background(), 1000), storage.ErrNotFound)
+}
+
+func deleteTaskRejectsTracked(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	userID, taskID := saveUser(t, kp), saveTask(t, kp)
+	require.NoError(t, kp.StartTaskTracking(ctx, timeEntry(userID, taskID)))
+
+	assert.Error(t, kp.DeleteTask(ctx, taskID))
+
+	tasks, err := kp.LoadTasks(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, tasks, taskID)
+}
+
+func loadTasksReturnsUpdates(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	taskID := saveTask(t, kp)
+
+	require.NoError(t, kp.UpdateTask(ctx, models.Task{ID: taskID, Name: "Review"}))
+
+	tasks, err := kp.LoadTasks(ctx)
+	require.NoError(t, err)
+	require.Contains(t, tasks, taskID)
+	assert.Equal(t, "Review", tasks[taskID].Name)
+}
+
+func startRejectsOverlap(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	entry := timeEntry(saveUser(t, kp), saveTask(t, kp))
+
+	require.NoError(t, kp.StartTaskTracking(ctx, entry))
+	assert.Error(t, kp.StartTaskTracking(ctx, entry))
+}
+
+func stopRequiresActiveEntry(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	entry := timeEntry(saveUser(t, kp), saveTask(t, kp))
+
+	assert.Error(t, kp.StopTaskTracking(ctx, entry))
+	require.NoError(t, kp.StartTaskTracking(ctx, entry))
+	require.NoError(t, kp.StopTaskTracking(ctx, entry))
+	assert.Error(t, kp.StopTaskTracking(ctx, entry), "an entry is stopped once")
+}
+
+func startAfterStop(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	entry := timeEntry(saveUser(t, kp), saveTask(t, kp))
+
+	require.NoError(t, kp.StartTaskTracking(ctx, entry))
+	require.NoError(t, kp.StopTaskTracking(ctx, entry))
+	assert.NoError(t, kp.StartTaskTracking(ctx, entry))
+}
+
+func runningTimersListsActiveEntries(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	userID, taskID := saveUser(t, kp), saveTask(t, kp)
+
+	timers, err := kp.GetRunningTimers(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, timers)
+
+	stopped, err := kp.SaveTask(ctx, models.Task{Name: "Review", CreatedAt: time.Now()})
+	require.NoError(t, err)
+	require.NoError(t, kp.StartTaskTracking(ctx, timeEntry(userID, stopped)))
+	require.NoError(t, kp.StopTaskTracking(ctx, timeEntry(userID, stopped)))
+	require.NoError(t, kp.StartTaskTracking(ctx, timeEntry(userID, taskID)))
+
+	timers, err = kp.GetRunningTimers(ctx)
+	require.NoError(t, err)
+	require.Len(t, timers, 1)
+	assert.Equal(t, userID, timers[0].UserID)
+	assert.Equal(t, taskID, timers[0].TaskID)
+	assert.Equal(t, time.Now().UTC().Format(time.DateOnly), timers[0].EventDate)
+	assert.WithinDuration(t, time.Now(), timers[0].StartedAt, time.Minute)
+}
+
+func summaryCountsRunningEntry(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	userID, taskID := saveUser(t, kp), saveTask(t, kp)
+	require.NoError(t, kp.StartTaskTracking(ctx, timeEntry(userID, taskID)))
+
+	today := time.Now().UTC()
+	summary, err := kp.GetUserTaskSummary(ctx, userID, today, today, "UTC", time.Time{})
+	require.NoError(t, err)
+	require.Len(t, summary, 1)
+	assert.Equal(t, taskID, summary[0].TaskID)
+}
+
+func summaryEmptyPeriod(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	userID, taskID := saveUser(t, kp), saveTask(t, kp)
+	require.NoError(t, kp.StartTaskTracking(ctx, timeEntry(userID, taskID)))
+
+	today := time.Now().UTC()
+	summary, err := kp.GetUserTaskSummary(ctx, userID, today.AddDate(0, 0, -2), today.AddDate(0, 0, -1), "UTC", time.Time{})
+	require.NoError(t, err)
+	assert.Empty(t, summary)
+}
+
+func getTwoFactorMissing(t *testing.T, kp storage.Keeper) {
+	_, err := kp.GetTwoFactor(context.Background(), saveUser(t, kp))
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func saveTwoFactorReplaces(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	userID := saveUser(t, kp)
+
+	require.NoError(t, kp.SaveTwoFactor(ctx, models.TwoFactor{UserID: userID, Secret: "sealed", CreatedAt: time.Now()}))
+	require.NoError(t, kp.SaveTwoFactor(ctx, models.TwoFactor{UserID: userID, Secret: "sealed", Enabled: true, LastUsedStep: 42, CreatedAt: time.Now(), ConfirmedAt: time.Now()}))
+
+	tf, err := kp.GetTwoFactor(ctx, userID)
+	require.NoError(t, err)
+	assert.True(t, tf.Enabled)
+	assert.Equal(t, int64(42), tf.LastUsedStep)
+	assert.False(t, tf.ConfirmedAt.IsZero())
+}
+
+func deleteTwoFactorMissing(t *testing.T, kp storage.Keeper) {
+	assert.ErrorIs(t, kp.DeleteTwoFactor(context.Background(), saveUser(t, kp)), storage.ErrNotFound)
+}
+
+func useRecoveryCodeSingleUse(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	userID := saveUser(t, kp)
+
+	require.NoError(t, kp.SaveRecoveryCodes(ctx, userID, []string{"a", "b"}))
+	require.NoError(t, kp.UseRecoveryCode(ctx, userID, "a"))
+	assert.ErrorIs(t, kp.UseRecoveryCode(ctx, userID, "a"), storage.ErrNotFound)
+	assert.ErrorIs(t, kp.UseRecoveryCode(ctx, userID, "c"), storage.ErrNotFound)
+}
+
+func useTOTPStepRejectsUsedSteps(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	userID := saveUser(t, kp)
+
+	assert.ErrorIs(t, kp.UseTOTPStep(ctx, userID, 1), storage.ErrNotFound)
+
+	require.NoError(t, kp.SaveTwoFactor(ctx, models.TwoFactor{UserID: userID, Secret: "sealed", Enabled: true, LastUsedStep: 10, CreatedAt: time.Now()}))
+	assert.ErrorIs(t, kp.UseTOTPStep(ctx, userID, 10), storage.ErrNotFound)
+	require.NoError(t, kp.UseTOTPStep(ctx, userID, 11))
+	assert.ErrorIs(t, kp.UseTOTPStep(ctx, userID, 11), storage.ErrNotFound)
+
+	tf, err := kp.GetTwoFactor(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), tf.LastUsedStep)
+}
+
+func failTwoFactorLocksAfterLimit(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	userID := saveUser(t, kp)
+	lockUntil := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	_, err := kp.FailTwoFactor(ctx, userID, 2, lockUntil)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	require.NoError(t, kp.SaveTwoFactor(ctx, models.TwoFactor{UserID: userID, Secret: "sealed", Enabled: true, CreatedAt: time.Now()}))
+
+	failures, err := kp.FailTwoFactor(ctx, userID, 2, lockUntil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, failures)
+	tf, err := kp.GetTwoFactor(ctx, userID)
+	require.NoError(t, err)
+	assert.True(t, tf.LockedUntil.IsZero())
+
+	failures, err = kp.FailTwoFactor(ctx, userID, 2, lockUntil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, failures)
+	tf, err = kp.GetTwoFactor(ctx, userID)
+	require.NoError(t, err)
+	assert.True(t, lockUntil.Equal(tf.LockedUntil), tf.LockedUntil)
+
+	// Saving the settings keeps the failures, an accepted code clears them
+	require.NoError(t, kp.SaveTwoFactor(ctx, tf))
+	tf, err = kp.GetTwoFactor(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, tf.FailedAttempts)
+
+	require.NoError(t, kp.UseTOTPStep(ctx, userID, 1))
+	tf, err = kp.GetTwoFactor(ctx, userID)
+	require.NoError(t, err)
+	assert.Zero(t, tf.FailedAttempts)
+
+	_, err = kp.FailTwoFactor(ctx, userID, 2, lockUntil)
+	require.NoError(t, err)
+	require.NoError(t, kp.SaveRecoveryCodes(ctx, userID, []string{"a"}))
+	require.NoError(t, kp.UseRecoveryCode(ctx, userID, "a"))
+	tf, err = kp.GetTwoFactor(ctx, userID)
+	require.NoError(t, err)
+	assert.Zero(t, tf.FailedAttempts)
+}
+
+func deleteTwoFactorDeletesCodes(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	userID := saveUser(t, kp)
+
+	require.NoError(t, kp.SaveTwoFactor(ctx, models.TwoFactor{UserID: userID, Secret: "sealed", Enabled: true, CreatedAt: time.Now()}))
+	require.NoError(t, kp.SaveRecoveryCodes(ctx, userID, []string{"a"}))
+	require.NoError(t, kp.DeleteTwoFactor(ctx, userID))
+
+	_, err := kp.GetTwoFactor(ctx, userID)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	assert.ErrorIs(t, kp.UseRecoveryCode(ctx, userID, "a"), storage.ErrNotFound)
+}
+
+func getUserByIdentityMissing(t *testing.T, kp storage.Keeper) {
+	_, err := kp.GetUserByIdentity(context.Background(), "https://issuer", "subject")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func linkIdentityDuplicate(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	userID := saveUser(t, kp)
+
+	identity := models.UserIdentity{UserID: userID, Issuer: "https://issuer", Subject: "subject", Email: "user@example.com"}
+	require.NoError(t, kp.LinkIdentity(ctx, identity))
+	assert.ErrorIs(t, kp.LinkIdentity(ctx, identity), storage.ErrConflict)
+
+	user, err := kp.GetUserByIdentity(ctx, "https://issuer", "subject")
+	require.NoError(t, err)
+	assert.Equal(t, userID, user.UUID)
+}
+
+func exportUserCollectsData(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	userID, taskID := saveUser(t, kp), saveTask(t, kp)
+	require.NoError(t, kp.StartTaskTracking(ctx, timeEntry(userID, taskID)))
+	require.NoError(t, kp.LinkIdentity(ctx, models.UserIdentity{UserID: userID, Issuer: "https://issuer", Subject: "subject"}))
+
+	export, err := kp.ExportUser(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, "Ivanov", export.Profile.Surname)
+	require.Len(t, export.TimeEntries, 1)
+	assert.Equal(t, "Report", export.TimeEntries[0].TaskName)
+	assert.NotEmpty(t, export.TimeEntries[0].StartTime)
+	assert.Empty(t, export.TimeEntries[0].EndTime)
+	require.Len(t, export.Tokens, 1)
+	assert.Equal(t, "oidc", export.Tokens[0].Type)
+	assert.NotEmpty(t, export.Audit)
+}
+
+func exportUserMissing(t *testing.T, kp storage.Keeper) {
+	_, err := kp.ExportUser(context.Background(), 1000)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func eraseUserKeepsTimeEntries(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	userID, taskID := saveUser(t, kp), saveTask(t, kp)
+	require.NoError(t, kp.StartTaskTracking(ctx, timeEntry(userID, taskID)))
+	require.NoError(t, kp.LinkIdentity(ctx, models.UserIdentity{UserID: userID, Issuer: "https://issuer", Subject: "subject"}))
+
+	require.NoError(t, kp.EraseUser(ctx, userID))
+
+	_, err := kp.GetUserByID(ctx, userID)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	user, err := kp.GetUser(ctx, passportSerie, passportNumber)
+	require.NoError(t, err)
+	assert.Zero(t, user.UUID, "the passport of an erased user is free")
+
+	_, err = kp.GetUserByIdentity(ctx, "https://issuer", "subject")
+	assert.ErrorIs(t, err, storage.ErrNotFound, "identities are removed")
+
+	today := time.Now().UTC()
+	summary, err := kp.GetUserTaskSummary(ctx, userID, today, today, "UTC", time.Time{})
+	require.NoError(t, err)
+	assert.Len(t, summary, 1, "reports do not change")
+}
+
+func eraseUserTwice(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	userID := saveUser(t, kp)
+
+	require.NoError(t, kp.EraseUser(ctx, userID))
+	assert.ErrorIs(t, kp.EraseUser(ctx, userID), storage.ErrNotFound)
+}
+
+func auditNewestFirst(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	userID := saveUser(t, kp)
+	require.NoError(t, kp.UpdateUser(ctx, models.User{UUID: userID, Address: "Moscow"}))
+
+	events, err := kp.GetAuditEvents(ctx, models.AuditFilter{EntityType: ptr(audit.EntityUser), EntityID: &userID}, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, audit.ActionUpdate, events[0].Action)
+	assert.Equal(t, audit.ActionCreate, events[1].Action)
+	assert.Greater(t, events[0].ID, events[1].ID)
+}
+
+func auditRecordsMetadata(t *testing.T, kp storage.Keeper) {
+	ctx := audit.WithMetadata(context.Background(), audit.Metadata{ActorID: 7, RequestID: "request", IP: "10.0.0.1"})
+	taskID, err := kp.SaveTask(ctx, models.Task{Name: "Report"})
+	require.NoError(t, err)
+
+	events, err := kp.GetAuditEvents(ctx, models.AuditFilter{ActorID: ptr(7)}, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, audit.EntityTask, events[0].EntityType)
+	assert.Equal(t, taskID, events[0].EntityID)
+	assert.Equal(t, "request", events[0].RequestID)
+	assert.Equal(t, "10.0.0.1", events[0].IP)
+	assert.False(t, events[0].CreatedAt.IsZero())
+}
+
+func auditBeforeIDPages(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		saveTask(t, kp)
+	}
+
+	filter := models.AuditFilter{EntityType: ptr(audit.EntityTask)}
+	first, err := kp.GetAuditEvents(ctx, filter, 2)
+	require.NoError(t, err)
+	require.Len(t, first, 2)
+
+	filter.BeforeID = first[1].ID
+	rest, err := kp.GetAuditEvents(ctx, filter, 2)
+	require.NoError(t, err)
+	require.Len(t, rest, 1)
+	assert.Less(t, rest[0].ID, first[1].ID)
+}
+
+func auditRedactsSensitiveFields(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	userID := saveUser(t, kp)
+
+	events, err := kp.GetAuditEvents(ctx, models.AuditFilter{EntityType: ptr(audit.EntityUser), EntityID: &userID}, 1)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.NotContains(t, string(events[0].After), "Ivanov")
+	assert.Contains(t, string(events[0].After), audit.Redacted)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func enqueueJobPendingKeyConflicts(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+
+	id, err := kp.EnqueueJob(ctx, models.Job{Kind: "enrichment", Key: "enrichment:1"})
+	require.NoError(t, err)
+
+	_, err = kp.EnqueueJob(ctx, models.Job{Kind: "enrichment", Key: "enrichment:1"})
+	assert.ErrorIs(t, err, storage.ErrConflict, "a pending job is queued once")
+
+	_, err = kp.EnqueueJob(ctx, models.Job{Kind: "enrichment"})
+	assert.NoError(t, err, "jobs without a key never conflict")
+
+	jobs, err := kp.ClaimJobs(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+	_, err = kp.EnqueueJob(ctx, models.Job{Kind: "enrichment", Key: "enrichment:1"})
+	assert.ErrorIs(t, err, storage.ErrConflict, "a running job is pending too")
+
+	require.NoError(t, kp.BuryJob(ctx, id, "failed"))
+	_, err = kp.EnqueueJob(ctx, models.Job{Kind: "enrichment", Key: "enrichment:1"})
+	assert.NoError(t, err, "a dead job frees its key")
+}
+
+func claimJobsSchedulesAndLocks(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+
+	first, err := kp.EnqueueJob(ctx, models.Job{Kind: "report", Payload: []byte(`{"user_id":1}`)})
+	require.NoError(t, err)
+	second, err := kp.EnqueueJob(ctx, models.Job{Kind: "report"})
+	require.NoError(t, err)
+	_, err = kp.EnqueueJob(ctx, models.Job{Kind: "report", RunAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+
+	jobs, err := kp.ClaimJobs(ctx, 1, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1, "the claim is bounded by the limit")
+	assert.Equal(t, first, jobs[0].ID, "the oldest job goes first")
+	assert.Equal(t, "report", jobs[0].Kind)
+	assert.Equal(t, models.JobRunning, jobs[0].Status)
+	assert.Equal(t, 1, jobs[0].Attempts)
+	assert.JSONEq(t, `{"user_id":1}`, string(jobs[0].Payload))
+	require.NotNil(t, jobs[0].LockedUntil)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), *jobs[0].LockedUntil, 5*time.Second)
+
+	jobs, err = kp.ClaimJobs(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1, "locked and scheduled jobs are not claimed")
+	assert.Equal(t, second, jobs[0].ID)
+	assert.JSONEq(t, `{}`, string(jobs[0].Payload))
+
+	require.NoError(t, kp.CompleteJob(ctx, first))
+	assert.ErrorIs(t, kp.CompleteJob(ctx, first), storage.ErrNotFound)
+}
+
+func claimJobsReclaimsExpiredLock(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+
+	id, err := kp.EnqueueJob(ctx, models.Job{Kind: "report"})
+	require.NoError(t, err)
+
+	jobs, err := kp.ClaimJobs(ctx, 10, -time.Second)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+
+	// The worker holding the job is gone once its lock expires
+	jobs, err = kp.ClaimJobs(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, id, jobs[0].ID)
+	assert.Equal(t, 2, jobs[0].Attempts)
+}
+
+func retryJobRequeuesAndBuries(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+
+	id, err := kp.EnqueueJob(ctx, models.Job{Kind: "report"})
+	require.NoError(t, err)
+	_, err = kp.ClaimJobs(ctx, 10, time.Minute)
+	require.NoError(t, err)
+
+	require.NoError(t, kp.RetryJob(ctx, id, 1, time.Now().Add(time.Hour), "unavailable"))
+	jobs, err := kp.ClaimJobs(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, jobs, "the retry waits for its time")
+
+	require.NoError(t, kp.RetryJob(ctx, id, 1, time.Now().Add(-time.Second), "unavailable"))
+	jobs, err = kp.ClaimJobs(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, 2, jobs[0].Attempts)
+	assert.Equal(t, "unavailable", jobs[0].LastError)
+
+	require.NoError(t, kp.BuryJob(ctx, id, "still unavailable"))
+	jobs, err = kp.ClaimJobs(ctx, 10, -time.Second)
+	require.NoError(t, err)
+	assert.Empty(t, jobs, "dead jobs are not claimed")
+
+	assert.ErrorIs(t, kp.RetryJob(ctx, id+100, 1, time.Now(), ""), storage.ErrNotFound)
+	assert.ErrorIs(t, kp.BuryJob(ctx, id+100, ""), storage.ErrNotFound)
+}
+
+func reportSubscriptionsSaveListDelete(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	userID := saveUser(t, kp)
+
+	sub := models.ReportSubscription{
+		UserID:     userID,
+		Schedule:   "0 8 * * MON",
+		ReportType: models.ReportWeeklyHours,
+		Recipients: []string{"manager@example.com", "lead@example.com"},
+		Format:     models.ReportFormatHTMLCSV,
+		Timezone:   "Europe/Moscow",
+	}
+	id, err := kp.SaveReportSubscription(ctx, sub)
+	require.NoError(t, err)
+	otherID, err := kp.SaveReportSubscription(ctx, models.ReportSubscription{
+		UserID: userID, Schedule: "@daily", ReportType: models.ReportDailyHours,
+		Recipients: []string{"lead@example.com"}, Format: models.ReportFormatCSV, Timezone: "UTC",
+	})
+	require.NoError(t, err)
+
+	saved, err := kp.GetReportSubscription(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, sub.Schedule, saved.Schedule)
+	assert.Equal(t, sub.Recipients, saved.Recipients)
+	assert.Equal(t, sub.Timezone, saved.Timezone)
+	assert.Nil(t, saved.LastSentAt)
+	assert.WithinDuration(t, time.Now(), saved.CreatedAt, time.Minute)
+
+	sentAt := time.Date(2024, 7, 1, 8, 0, 0, 0, time.UTC)
+	require.NoError(t, kp.MarkReportSent(ctx, id, sentAt))
+
+	subs, err := kp.GetReportSubscriptions(ctx)
+	require.NoError(t, err)
+	require.Len(t, subs, 2)
+	assert.Equal(t, []int{id, otherID}, []int{subs[0].ID, subs[1].ID})
+	require.NotNil(t, subs[0].LastSentAt)
+	assert.True(t, sentAt.Equal(*subs[0].LastSentAt))
+
+	require.NoError(t, kp.DeleteReportSubscription(ctx, id))
+	assert.ErrorIs(t, kp.DeleteReportSubscription(ctx, id), storage.ErrNotFound)
+	_, err = kp.GetReportSubscription(ctx, id)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func markReportSentMissing(t *testing.T, kp storage.Keeper) {
+	assert.ErrorIs(t, kp.MarkReportSent(context.Background(), 100, time.Now()), storage.ErrNotFound)
+}
+
+func webhooksSaveListDelete(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+	userID := saveUser(t, kp)
+
+	hook := models.Webhook{
+		UserID: userID,
+		URL:    "https://example.com/hooks/timetracker",
+		Events: []string{"timer.started", "timer.stopped"},
+		Secret: "sealed secret",
+	}
+	id, err := kp.SaveWebhook(ctx, hook)
+	require.NoError(t, err)
+	otherID, err := kp.SaveWebhook(ctx, models.Webhook{UserID: userID, URL: "https://example.com/tasks", Events: []string{"task.created"}, Secret: "other"})
+	require.NoError(t, err)
+
+	saved, err := kp.GetWebhook(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, hook.URL, saved.URL)
+	assert.Equal(t, hook.Events, saved.Events)
+	assert.Equal(t, hook.Secret, saved.Secret)
+	assert.WithinDuration(t, time.Now(), saved.CreatedAt, time.Minute)
+
+	deliveryID, err := kp.SaveWebhookDelivery(ctx, models.WebhookDelivery{WebhookID: id, EventType: "timer.started", Payload: []byte(`{"type":"timer.started"}`)})
+	require.NoError(t, err)
+
+	hooks, err := kp.GetWebhooks(ctx)
+	require.NoError(t, err)
+	require.Len(t, hooks, 2)
+	assert.Equal(t, []int{id, otherID}, []int{hooks[0].ID, hooks[1].ID})
+
+	require.NoError(t, kp.DeleteWebhook(ctx, id))
+	assert.ErrorIs(t, kp.DeleteWebhook(ctx, id), storage.ErrNotFound)
+	_, err = kp.GetWebhook(ctx, id)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	// The deliveries go with the webhook
+	_, err = kp.GetWebhookDelivery(ctx, deliveryID)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func webhookDeliveriesRecordAttempts(t *testing.T, kp storage.Keeper) {
+	ctx := context.Background()
+
+	hookID, err := kp.SaveWebhook(ctx, models.Webhook{UserID: saveUser(t, kp), URL: "https://example.com/hooks", Events: []string{"task.created"}, Secret: "secret"})
+	require.NoError(t, err)
+
+	_, err = kp.SaveWebhookDelivery(ctx, models.WebhookDelivery{WebhookID: hookID + 100, EventType: "task.created", Payload: []byte(`{}`)})
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	firstID, err := kp.SaveWebhookDelivery(ctx, models.WebhookDelivery{WebhookID: hookID, EventType: "task.created", Payload: []byte(`{"id":1}`)})
+	require.NoError(t, err)
+	secondID, err := kp.SaveWebhookDelivery(ctx, models.WebhookDelivery{WebhookID: hookID, EventType: "task.created", Payload: []byte(`{"id":2}`)})
+	require.NoError(t, err)
+
+	pending, err := kp.GetWebhookDelivery(ctx, firstID)
+	require.NoError(t, err)
+	assert.Equal(t, models.WebhookPending, pending.Status)
+	assert.JSONEq(t, `{"id":1}`, string(pending.Payload))
+	assert.Zero(t, pending.Attempts)
+	assert.Nil(t, pending.DeliveredAt)
+
+	failed := pending
+	failed.Status, failed.Attempts, failed.ResponseStatus, failed.LastError = models.WebhookFailed, 1, 502, "unexpected status 502"
+	require.NoError(t, kp.UpdateWebhookDelivery(ctx, failed))
+
+	deliveredAt := time.Date(2024, 7, 1, 8, 0, 0, 0, time.UTC)
+	succeeded := failed
+	succeeded.Status, succeeded.Attempts, succeeded.ResponseStatus, succeeded.LastError = models.WebhookSucceeded, 2, 204, ""
+	succeeded.DeliveredAt = &deliveredAt
+	require.NoError(t, kp.UpdateWebhookDelivery(ctx, succeeded))
+
+	saved, err := kp.GetWebhookDelivery(ctx, firstID)
+	require.NoError(t, err)
+	assert.Equal(t, models.WebhookSucceeded, saved.Status)
+	assert.Equal(t, 2, saved.Attempts)
+	assert.Equal(t, 204, saved.ResponseStatus)
+	assert.Empty(t, saved.LastError)
+	require.NotNil(t, saved.DeliveredAt)
+	assert.True(t, deliveredAt.Equal(*saved.DeliveredAt))
+
+	deliveries, err := kp.GetWebhookDeliveries(ctx, hookID, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	assert.Equal(t, []int64{secondID, firstID}, []int64{deliveries[0].ID, deliveries[1].ID})
+
+	deliveries, err = kp.GetWebhookDeliveries(ctx, hookID, 1)
+	require.NoError(t, err)
+	assert.Len(t, deliveries, 1)
+
+	assert.ErrorIs(t, kp.UpdateWebhookDelivery(ctx, models.WebhookDelivery{ID: secondID + 100}), storage.ErrNotFound)
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/audit"
+	"go.uber.org/zap"
+)
+
+// followRetryInterval is the pause before listening again after the feed failed
+const followRetryInterval = 5 * time.Second
+
+// Change tells that a user or a task was created, changed or deleted, possibly by another
+// instance sharing the data. Entity is audit.EntityUser or audit.EntityTask.
+type Change struct {
+	Entity string `json:"entity"`
+	ID     int    `json:"id"`
+}
+
+// ChangeFeed delivers the changes made by all instances sharing the data of a keeper
+type ChangeFeed interface {
+	// Listen applies changes until ctx is done or the feed fails. It calls ready
+	// once no change can be missed, so that the state read after it stays coherent.
+	Listen(ctx context.Context, ready func(), apply func(Change)) error
+}
+
+// Follow keeps the storage coherent with the other instances until ctx is done.
+// Every time the feed starts listening, the storage is synced with the keeper,
+// as changes may have been missed while it was not.
+func (s *MemoryStorage) Follow(ctx context.Context, feed ChangeFeed) {
+	for {
+		err := feed.Listen(ctx, func() { s.resync(ctx) }, func(change Change) { s.ApplyChange(ctx, change) })
+		if ctx.Err() != nil {
+			return
+		}
+		s.log.Info("change feed interrupted: ", zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(followRetryInterval):
+		}
+	}
+}
+
+// ApplyChange brings a user or a task up to date. The mirror reads it again from the keeper,
+// the cache drops it to read it through on demand.
+func (s *MemoryStorage) ApplyChange(ctx context.Context, change Change) {
+	switch change.Entity {
+	case audit.EntityUser:
+		s.umx.Lock()
+		defer s.umx.Unlock()
+
+		if !s.mirror() {
+			s.users.Delete(change.ID)
+			return
+		}
+
+		user, err := s.keeper.GetUserByID(ctx, change.ID)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			s.users.Delete(change.ID)
+		case err != nil:
+			s.log.Info("cannot apply user change: ", zap.Int("id", change.ID), zap.Error(err))
+		default:
+			s.users.Set(change.ID, user)
+		}
+
+	case audit.EntityTask:
+		s.omx.Lock()
+		defer s.omx.Unlock()
+
+		if !s.mirror() {
+			s.tasks.Delete(change.ID)
+			return
+		}
+
+		task, err := s.keeper.GetTaskByID(ctx, change.ID)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			s.tasks.Delete(change.ID)
+		case err != nil:
+			s.log.Info("cannot apply task change: ", zap.Int("id", change.ID), zap.Error(err))
+		default:
+			s.tasks.Set(change.ID, task)
+		}
+	}
+}
+
+// resync reloads the mirror or empties the cache
+func (s *MemoryStorage) resync(ctx context.Context) {
+	s.umx.Lock()
+	defer s.umx.Unlock()
+	s.omx.Lock()
+	defer s.omx.Unlock()
+
+	if s.mirror() {
+		s.load(ctx)
+		return
+	}
+
+	s.users.Replace(nil)
+	s.tasks.Replace(nil)
+}
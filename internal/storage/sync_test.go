@@ -0,0 +1,107 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wurt83ow/timetracker/internal/encryption"
+	"github.com/wurt83ow/timetracker/internal/memkeeper"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap"
+)
+
+// readyFeed tells when the storage following it listens
+type readyFeed struct {
+	storage.ChangeFeed
+	listening chan struct{}
+}
+
+func (f readyFeed) Listen(ctx context.Context, ready func(), apply func(storage.Change)) error {
+	return f.ChangeFeed.Listen(ctx, func() {
+		ready()
+		close(f.listening)
+	}, apply)
+}
+
+// following starts an instance that follows the changes of the keeper and waits until it listens
+func following(t *testing.T, ctx context.Context, keeper storage.Keeper, feed storage.ChangeFeed, options cacheOptions) *storage.MemoryStorage {
+	t.Helper()
+
+	s := storage.NewMemoryStorage(ctx, keeper, zap.NewNop(), options)
+
+	listening := make(chan struct{})
+	go s.Follow(ctx, readyFeed{ChangeFeed: feed, listening: listening})
+	<-listening
+
+	return s
+}
+
+func TestMemoryStorage_FollowsOtherInstances(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	envelope, err := encryption.NewEnvelope("test_encryption_key")
+	require.NoError(t, err)
+	keeper := memkeeper.NewMemKeeper(func() string { return "" }, zap.NewNop(), func() string { return "5m" }, envelope)
+
+	a := following(t, ctx, keeper, keeper, cacheOptions{mode: storage.ModeMirror})
+	b := following(t, ctx, keeper, keeper, cacheOptions{mode: storage.ModeMirror})
+
+	_, err = a.InsertTask(ctx, models.Task{Name: "Report"})
+	require.NoError(t, err)
+	userID, err := a.InsertUser(ctx, models.User{PassportSerie: 1234, PassportNumber: 567890, Surname: "Ivanov"})
+	require.NoError(t, err)
+
+	taskNames := func(s *storage.MemoryStorage) []string {
+		tasks, _, err := s.GetTasks(ctx, models.TaskFilter{}, models.Pagination{Limit: 10})
+		require.NoError(t, err)
+
+		names := make([]string, 0, len(tasks))
+		for _, task := range tasks {
+			names = append(names, task.Name)
+		}
+		return names
+	}
+	assert.Eventually(t, func() bool {
+		_, err := b.GetUserByID(ctx, userID)
+		return err == nil && len(taskNames(b)) == 1
+	}, time.Second, 10*time.Millisecond, "a task and a user created on A are seen by B")
+
+	require.NoError(t, b.UpdateUser(ctx, models.User{UUID: userID, Surname: "Petrov"}))
+	assert.Eventually(t, func() bool {
+		users, _, err := a.GetUsers(ctx, models.Filter{Surname: ptr("Petrov")}, models.Pagination{Limit: 10})
+		return err == nil && len(users) == 1
+	}, time.Second, 10*time.Millisecond, "an update on B is seen by A")
+
+	tasks, _, err := a.GetTasks(ctx, models.TaskFilter{}, models.Pagination{Limit: 10})
+	require.NoError(t, err)
+	require.NoError(t, b.DeleteTask(ctx, tasks[0].ID))
+	assert.Eventually(t, func() bool { return len(taskNames(a)) == 0 }, time.Second, 10*time.Millisecond, "a deletion on B is seen by A")
+}
+
+func TestMemoryStorage_FollowDropsCachedEntries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	envelope, err := encryption.NewEnvelope("test_encryption_key")
+	require.NoError(t, err)
+	keeper := memkeeper.NewMemKeeper(func() string { return "" }, zap.NewNop(), func() string { return "5m" }, envelope)
+
+	a := following(t, ctx, keeper, keeper, cacheOptions{mode: storage.ModeMirror})
+	b := following(t, ctx, keeper, keeper, cacheOptions{mode: storage.ModeCache, size: "10", ttl: "1h"})
+
+	userID, err := a.InsertUser(ctx, models.User{PassportSerie: 1234, PassportNumber: 567890, Surname: "Ivanov"})
+	require.NoError(t, err)
+	_, err = b.GetUserByID(ctx, userID)
+	require.NoError(t, err)
+
+	require.NoError(t, a.UpdateUser(ctx, models.User{UUID: userID, Surname: "Petrov"}))
+	assert.Eventually(t, func() bool {
+		user, err := b.GetUserByID(ctx, userID)
+		return err == nil && user.Surname == "Petrov"
+	}, time.Second, 10*time.Millisecond, "the cache reads the changed user through again")
+}
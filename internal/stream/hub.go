@@ -0,0 +1,216 @@
+// Package stream numbers the timer and task events of the bus for the live
+// streams of the clients. The recent events are kept in a ring buffer, so a client
+// that reconnects resumes after the last event it got. The buffer is in memory:
+// each instance numbers its own events, and they are lost on restart.
+package stream
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/events"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	defaultBufferSize = 1000
+	defaultHeartbeat  = 15 * time.Second
+
+	// subscriberQueueSize is the number of events a subscriber may lag behind
+	// before it is dropped and has to resume from the buffer
+	subscriberQueueSize = 64
+)
+
+// EventTypes are the types of the events streamed to the clients. There is no
+// paused event: the tracker has no pause, a break is a stop and a new start.
+var EventTypes = []string{
+	events.TimerStarted,
+	events.TimerStopped,
+	events.TaskCreated,
+	events.TaskUpdated,
+	events.TaskDeleted,
+}
+
+type Log interface {
+	Info(string, ...zapcore.Field)
+}
+
+type Bus interface {
+	Subscribe(events.Handler, ...string) func()
+}
+
+// Options configure the buffer of the recent events and the heartbeat of the streams
+type Options interface {
+	StreamBufferSize() string
+	StreamHeartbeatInterval() string
+}
+
+// Entry is an event numbered by the hub. The IDs grow by one with every event.
+type Entry struct {
+	ID    int64
+	Event events.Event
+}
+
+// Subscription gets the events published after it was made. Events is closed when
+// the subscriber lags too far behind or the hub is stopped.
+type Subscription struct {
+	// Backlog holds the buffered events after the ID the subscription resumes from
+	Backlog []Entry
+	// Missed tells that events after that ID are no longer buffered, so the client
+	// has to reload its state
+	Missed bool
+	Events <-chan Entry
+
+	hub   *Hub
+	queue chan Entry
+}
+
+// Close stops the delivery of the events
+func (s *Subscription) Close() {
+	s.hub.remove(s)
+}
+
+// Hub keeps the recent events and fans them out to the subscriptions
+type Hub struct {
+	bus       Bus
+	log       Log
+	heartbeat time.Duration
+
+	mx          sync.Mutex
+	buffer      []Entry
+	start       int
+	lastID      int64
+	subs        map[*Subscription]struct{}
+	unsubscribe func()
+	stopped     bool
+}
+
+// NewHub creates a hub from the options. Invalid options are logged and replaced with the defaults.
+func NewHub(bus Bus, log Log, option Options) *Hub {
+	size, err := strconv.Atoi(option.StreamBufferSize())
+	if err != nil || size < 1 {
+		log.Info("invalid STREAM_BUFFER_SIZE, using the default: ", zap.Int("default", defaultBufferSize))
+		size = defaultBufferSize
+	}
+
+	heartbeat, err := time.ParseDuration(option.StreamHeartbeatInterval())
+	if err != nil || heartbeat <= 0 {
+		log.Info("invalid STREAM_HEARTBEAT_INTERVAL, using the default: ", zap.Duration("default", defaultHeartbeat))
+		heartbeat = defaultHeartbeat
+	}
+
+	return &Hub{
+		bus:       bus,
+		log:       log,
+		heartbeat: heartbeat,
+		buffer:    make([]Entry, 0, size),
+		subs:      make(map[*Subscription]struct{}),
+	}
+}
+
+// Heartbeat returns the interval between the heartbeats of an idle stream
+func (h *Hub) Heartbeat() time.Duration {
+	return h.heartbeat
+}
+
+// Start subscribes to the events of the bus
+func (h *Hub) Start() {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	h.unsubscribe = h.bus.Subscribe(h.publish, EventTypes...)
+}
+
+// Stop unsubscribes from the bus and ends the subscriptions
+func (h *Hub) Stop() {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	if h.unsubscribe != nil {
+		h.unsubscribe()
+		h.unsubscribe = nil
+	}
+	h.stopped = true
+
+	for sub := range h.subs {
+		delete(h.subs, sub)
+		close(sub.queue)
+	}
+}
+
+// Subscribe starts a subscription resuming after the event with lastID, or with
+// the events published from now on if lastID is 0. The subscriptions of a stopped
+// hub are closed.
+func (h *Hub) Subscribe(lastID int64) *Subscription {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	queue := make(chan Entry, subscriberQueueSize)
+	sub := &Subscription{Events: queue, hub: h, queue: queue}
+	if h.stopped {
+		close(queue)
+		return sub
+	}
+	h.subs[sub] = struct{}{}
+
+	if lastID <= 0 || lastID == h.lastID {
+		return sub
+	}
+
+	// The ID is gone from the buffer, or comes from before a restart
+	oldest := h.lastID - int64(len(h.buffer)) + 1
+	if lastID > h.lastID || lastID < oldest-1 {
+		sub.Missed = true
+		return sub
+	}
+
+	for i := lastID - oldest + 1; i < int64(len(h.buffer)); i++ {
+		sub.Backlog = append(sub.Backlog, h.at(int(i)))
+	}
+
+	return sub
+}
+
+// publish numbers the event, buffers it and passes it to the subscriptions. A
+// subscription that can't keep up is dropped rather than holding up the others.
+func (h *Hub) publish(event events.Event) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	h.lastID++
+	entry := Entry{ID: h.lastID, Event: event}
+
+	if len(h.buffer) < cap(h.buffer) {
+		h.buffer = append(h.buffer, entry)
+	} else {
+		h.buffer[h.start] = entry
+		h.start = (h.start + 1) % len(h.buffer)
+	}
+
+	for sub := range h.subs {
+		select {
+		case sub.queue <- entry:
+		default:
+			h.log.Info("stream subscriber is too slow, dropping it")
+			delete(h.subs, sub)
+			close(sub.queue)
+		}
+	}
+}
+
+// at returns the i-th buffered event, the oldest first
+func (h *Hub) at(i int) Entry {
+	return h.buffer[(h.start+i)%len(h.buffer)]
+}
+
+func (h *Hub) remove(sub *Subscription) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.queue)
+	}
+}
@@ -0,0 +1,119 @@
+package stream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wurt83ow/timetracker/internal/events"
+	"go.uber.org/zap"
+)
+
+type options struct {
+	bufferSize string
+}
+
+func (o options) StreamBufferSize() string      { return o.bufferSize }
+func (options) StreamHeartbeatInterval() string { return "1s" }
+
+func newHub(t *testing.T, bufferSize string) (*Hub, *events.Bus) {
+	bus := events.NewBus(zap.NewNop())
+	t.Cleanup(bus.Close)
+
+	hub := NewHub(bus, zap.NewNop(), options{bufferSize: bufferSize})
+	hub.Start()
+	t.Cleanup(hub.Stop)
+
+	return hub, bus
+}
+
+func receive(t *testing.T, sub *Subscription) Entry {
+	t.Helper()
+
+	select {
+	case entry, ok := <-sub.Events:
+		require.True(t, ok, "subscription closed")
+		return entry
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+		return Entry{}
+	}
+}
+
+func TestHub_ResumesFromBuffer(t *testing.T) {
+	hub, bus := newHub(t, "3")
+
+	live := hub.Subscribe(0)
+	defer live.Close()
+
+	// Only the streamed types are numbered
+	bus.Publish(events.Event{Type: events.UserRegistered, ID: 9})
+	for taskID := 1; taskID <= 5; taskID++ {
+		bus.Publish(events.Event{Type: events.TaskCreated, ID: taskID})
+	}
+	for id := int64(1); id <= 5; id++ {
+		entry := receive(t, live)
+		assert.Equal(t, id, entry.ID)
+		assert.Equal(t, int(id), entry.Event.ID)
+	}
+
+	// Events 3 to 5 are buffered
+	sub := hub.Subscribe(3)
+	assert.False(t, sub.Missed)
+	require.Len(t, sub.Backlog, 2)
+	assert.Equal(t, int64(4), sub.Backlog[0].ID)
+	assert.Equal(t, int64(5), sub.Backlog[1].ID)
+	sub.Close()
+
+	sub = hub.Subscribe(2)
+	assert.False(t, sub.Missed)
+	assert.Len(t, sub.Backlog, 3)
+	sub.Close()
+
+	sub = hub.Subscribe(5)
+	assert.False(t, sub.Missed)
+	assert.Empty(t, sub.Backlog)
+	sub.Close()
+
+	// Events 2 and later are gone, and IDs from before a restart are unknown
+	for _, lastID := range []int64{1, 6} {
+		sub = hub.Subscribe(lastID)
+		assert.True(t, sub.Missed, lastID)
+		assert.Empty(t, sub.Backlog)
+		sub.Close()
+	}
+}
+
+func TestHub_DropsLaggingSubscribers(t *testing.T) {
+	hub, bus := newHub(t, "")
+
+	slow := hub.Subscribe(0)
+	for i := 0; i <= subscriberQueueSize; i++ {
+		bus.Publish(events.Event{Type: events.TimerStarted, ID: 1})
+	}
+
+	require.Eventually(t, func() bool {
+		hub.mx.Lock()
+		defer hub.mx.Unlock()
+		return hub.lastID == subscriberQueueSize+1
+	}, time.Second, 10*time.Millisecond)
+
+	for i := 0; i < subscriberQueueSize; i++ {
+		receive(t, slow)
+	}
+	_, ok := <-slow.Events
+	assert.False(t, ok)
+
+	// Closing a dropped subscription is harmless
+	slow.Close()
+	assert.Equal(t, defaultBufferSize, cap(hub.buffer))
+
+	// Stopping ends the subscriptions, and the new ones are closed
+	sub := hub.Subscribe(0)
+	hub.Stop()
+	_, ok = <-sub.Events
+	assert.False(t, ok)
+	_, ok = <-hub.Subscribe(0).Events
+	assert.False(t, ok)
+}
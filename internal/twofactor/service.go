@@ -0,0 +1,257 @@
+package twofactor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"github.com/wurt83ow/timetracker/internal/totp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	recoveryCodesCount = 10
+	allowedSkew        = 1
+
+	// maxFailedAttempts wrong codes in a row lock the verification out for
+	// lockoutDuration, and every further wrong code locks it out again until a code
+	// is accepted
+	maxFailedAttempts = 5
+	lockoutDuration   = 15 * time.Minute
+)
+
+var (
+	ErrNotEnrolled    = errors.New("two-factor authentication is not enrolled")
+	ErrAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrInvalidCode    = errors.New("invalid two-factor code")
+	ErrLocked         = errors.New("too many invalid two-factor codes, try again later")
+)
+
+type Log interface {
+	Info(string, ...zapcore.Field)
+}
+
+type Storage interface {
+	GetTwoFactor(context.Context, int) (models.TwoFactor, error)
+	SaveTwoFactor(context.Context, models.TwoFactor) error
+	DeleteTwoFactor(context.Context, int) error
+	SaveRecoveryCodes(context.Context, int, []string) error
+	UseRecoveryCode(context.Context, int, string) error
+	UseTOTPStep(context.Context, int, int64) error
+	FailTwoFactor(context.Context, int, int, time.Time) (int, error)
+}
+
+type Cipher interface {
+	Encrypt([]byte) (string, error)
+	Decrypt(string) ([]byte, error)
+}
+
+type Service struct {
+	storage Storage
+	cipher  Cipher
+	issuer  func() string
+	log     Log
+	now     func() time.Time
+}
+
+// NewService creates a new Service instance
+func NewService(storage Storage, cipher Cipher, issuer func() string, log Log) *Service {
+	return &Service{
+		storage: storage,
+		cipher:  cipher,
+		issuer:  issuer,
+		log:     log,
+		now:     time.Now,
+	}
+}
+
+// Enabled reports whether the user has confirmed two-factor authentication
+func (s *Service) Enabled(ctx context.Context, userID int) (bool, error) {
+	tf, err := s.storage.GetTwoFactor(ctx, userID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return tf.Enabled, nil
+}
+
+// Enroll generates a new secret for the user. The secret stays inactive until it is confirmed.
+func (s *Service) Enroll(ctx context.Context, userID int, account string) (models.TwoFactorEnrollment, error) {
+	tf, err := s.storage.GetTwoFactor(ctx, userID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return models.TwoFactorEnrollment{}, err
+	}
+	if err == nil && tf.Enabled {
+		return models.TwoFactorEnrollment{}, ErrAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return models.TwoFactorEnrollment{}, err
+	}
+
+	encrypted, err := s.cipher.Encrypt([]byte(secret))
+	if err != nil {
+		return models.TwoFactorEnrollment{}, fmt.Errorf("failed to encrypt totp secret: %w", err)
+	}
+
+	err = s.storage.SaveTwoFactor(ctx, models.TwoFactor{
+		UserID:    userID,
+		Secret:    encrypted,
+		CreatedAt: s.now(),
+	})
+	if err != nil {
+		return models.TwoFactorEnrollment{}, err
+	}
+
+	return models.TwoFactorEnrollment{
+		Secret: secret,
+		URI:    totp.URI(s.issuer(), account, secret),
+	}, nil
+}
+
+// Confirm activates a pending enrollment and returns a fresh set of recovery codes
+func (s *Service) Confirm(ctx context.Context, userID int, code string) ([]string, error) {
+	tf, err := s.getTwoFactor(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if tf.Enabled {
+		return nil, ErrAlreadyEnabled
+	}
+	if s.now().Before(tf.LockedUntil) {
+		return nil, ErrLocked
+	}
+
+	step, ok, err := s.validate(tf, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, s.fail(ctx, userID)
+	}
+
+	codes, err := totp.GenerateRecoveryCodes(recoveryCodesCount)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, 0, len(codes))
+	for _, c := range codes {
+		hashes = append(hashes, totp.HashRecoveryCode(c))
+	}
+
+	if err := s.storage.SaveRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+
+	tf.Enabled = true
+	tf.LastUsedStep = step
+	tf.ConfirmedAt = s.now()
+	if err := s.storage.SaveTwoFactor(ctx, tf); err != nil {
+		return nil, err
+	}
+
+	s.log.Info("two-factor authentication enabled", zap.Int("userID", userID))
+	return codes, nil
+}
+
+// Disable turns two-factor authentication off after checking a TOTP or recovery code
+func (s *Service) Disable(ctx context.Context, userID int, code string) error {
+	if err := s.Verify(ctx, userID, code); err != nil {
+		return err
+	}
+
+	if err := s.storage.DeleteTwoFactor(ctx, userID); err != nil {
+		return err
+	}
+
+	s.log.Info("two-factor authentication disabled", zap.Int("userID", userID))
+	return nil
+}
+
+// Verify checks a TOTP code or consumes a recovery code of a user with enabled 2FA.
+// Too many wrong codes in a row lock the verification out with ErrLocked.
+func (s *Service) Verify(ctx context.Context, userID int, code string) error {
+	tf, err := s.getTwoFactor(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !tf.Enabled {
+		return ErrNotEnrolled
+	}
+	if s.now().Before(tf.LockedUntil) {
+		return ErrLocked
+	}
+
+	step, ok, err := s.validate(tf, code)
+	if err != nil {
+		return err
+	}
+
+	if ok {
+		// A code may only be used once: the step is only taken if it is after the last
+		// used one, so concurrent requests with the same code can't both pass
+		err := s.storage.UseTOTPStep(ctx, userID, step)
+		if errors.Is(err, storage.ErrNotFound) {
+			return s.fail(ctx, userID)
+		}
+		return err
+	}
+
+	err = s.storage.UseRecoveryCode(ctx, userID, totp.HashRecoveryCode(code))
+	if errors.Is(err, storage.ErrNotFound) {
+		return s.fail(ctx, userID)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.log.Info("recovery code used", zap.Int("userID", userID))
+	return nil
+}
+
+func (s *Service) getTwoFactor(ctx context.Context, userID int) (models.TwoFactor, error) {
+	tf, err := s.storage.GetTwoFactor(ctx, userID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return models.TwoFactor{}, ErrNotEnrolled
+	}
+
+	return tf, err
+}
+
+// fail counts a wrong code and returns ErrLocked once there are too many of them
+func (s *Service) fail(ctx context.Context, userID int) error {
+	failures, err := s.storage.FailTwoFactor(ctx, userID, maxFailedAttempts, s.now().Add(lockoutDuration))
+	if errors.Is(err, storage.ErrNotFound) {
+		return ErrNotEnrolled
+	}
+	if err != nil {
+		return err
+	}
+
+	if failures >= maxFailedAttempts {
+		s.log.Info("two-factor verification locked out", zap.Int("userID", userID), zap.Int("failures", failures))
+		return ErrLocked
+	}
+
+	return ErrInvalidCode
+}
+
+func (s *Service) validate(tf models.TwoFactor, code string) (int64, bool, error) {
+	secret, err := s.cipher.Decrypt(tf.Secret)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to decrypt totp secret: %w", err)
+	}
+
+	step, ok := totp.Validate(string(secret), code, s.now(), allowedSkew)
+
+	return step, ok, nil
+}
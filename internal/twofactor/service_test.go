@@ -0,0 +1,297 @@
+package twofactor
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wurt83ow/timetracker/internal/encryption"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"github.com/wurt83ow/timetracker/internal/totp"
+	"go.uber.org/zap"
+)
+
+const userID = 1
+
+type testService struct {
+	*Service
+	now time.Time
+}
+
+// fakeStorage keeps the settings of the users in memory with the conditional
+// updates of the keepers
+type fakeStorage struct {
+	mx       sync.Mutex
+	settings map[int]models.TwoFactor
+	codes    map[int]map[string]bool
+}
+
+func (f *fakeStorage) GetTwoFactor(_ context.Context, userID int) (models.TwoFactor, error) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	tf, ok := f.settings[userID]
+	if !ok {
+		return models.TwoFactor{}, storage.ErrNotFound
+	}
+	return tf, nil
+}
+
+func (f *fakeStorage) SaveTwoFactor(_ context.Context, tf models.TwoFactor) error {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	// As in the keepers the failed attempts are kept across saves
+	current := f.settings[tf.UserID]
+	tf.FailedAttempts, tf.LockedUntil = current.FailedAttempts, current.LockedUntil
+	f.settings[tf.UserID] = tf
+	return nil
+}
+
+func (f *fakeStorage) DeleteTwoFactor(_ context.Context, userID int) error {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	if _, ok := f.settings[userID]; !ok {
+		return storage.ErrNotFound
+	}
+	delete(f.settings, userID)
+	delete(f.codes, userID)
+	return nil
+}
+
+func (f *fakeStorage) SaveRecoveryCodes(_ context.Context, userID int, hashes []string) error {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	f.codes[userID] = make(map[string]bool)
+	for _, hash := range hashes {
+		f.codes[userID][hash] = true
+	}
+	return nil
+}
+
+func (f *fakeStorage) UseRecoveryCode(_ context.Context, userID int, hash string) error {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	if !f.codes[userID][hash] {
+		return storage.ErrNotFound
+	}
+	delete(f.codes[userID], hash)
+
+	tf := f.settings[userID]
+	tf.FailedAttempts = 0
+	f.settings[userID] = tf
+	return nil
+}
+
+func (f *fakeStorage) UseTOTPStep(_ context.Context, userID int, step int64) error {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	tf, ok := f.settings[userID]
+	if !ok || tf.LastUsedStep >= step {
+		return storage.ErrNotFound
+	}
+	tf.LastUsedStep, tf.FailedAttempts = step, 0
+	f.settings[userID] = tf
+	return nil
+}
+
+func (f *fakeStorage) FailTwoFactor(_ context.Context, userID int, limit int, lockUntil time.Time) (int, error) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	tf, ok := f.settings[userID]
+	if !ok {
+		return 0, storage.ErrNotFound
+	}
+	tf.FailedAttempts++
+	if tf.FailedAttempts >= limit {
+		tf.LockedUntil = lockUntil
+	}
+	f.settings[userID] = tf
+	return tf.FailedAttempts, nil
+}
+
+func newService(t *testing.T) *testService {
+	cipher, err := encryption.NewCipher("test_encryption_key")
+	require.NoError(t, err)
+
+	storage := &fakeStorage{
+		settings: make(map[int]models.TwoFactor),
+		codes:    make(map[int]map[string]bool),
+	}
+
+	s := &testService{now: time.Unix(1700000000, 0)}
+	s.Service = NewService(storage, cipher, func() string { return "TimeTracker" }, zap.NewNop())
+	s.Service.now = func() time.Time { return s.now }
+	return s
+}
+
+// code returns the code of the secret at the current time of the service
+func (s *testService) code(t *testing.T, secret string) string {
+	t.Helper()
+
+	code, err := totp.CodeAt(secret, totp.Step(s.now))
+	require.NoError(t, err)
+	return code
+}
+
+// wrong returns a code that is not valid for the secret at the current time
+func (s *testService) wrong(t *testing.T, secret string) string {
+	t.Helper()
+
+	for _, code := range []string{"000000", "000001", "000002", "000003"} {
+		if _, ok := totp.Validate(secret, code, s.now, allowedSkew); !ok {
+			return code
+		}
+	}
+	t.Fatal("no invalid code found")
+	return ""
+}
+
+// enable enrolls the user and confirms it, it returns the secret and the recovery codes
+func (s *testService) enable(t *testing.T) (string, []string) {
+	t.Helper()
+
+	enrollment, err := s.Enroll(context.Background(), userID, "user-1")
+	require.NoError(t, err)
+
+	codes, err := s.Confirm(context.Background(), userID, s.code(t, enrollment.Secret))
+	require.NoError(t, err)
+
+	// The codes of the confirmation step are used, the next ones are fresh
+	s.now = s.now.Add(30 * time.Second)
+	return enrollment.Secret, codes
+}
+
+func TestService_EnrollConfirmVerify(t *testing.T) {
+	ctx := context.Background()
+	s := newService(t)
+
+	_, err := s.Confirm(ctx, userID, "123456")
+	assert.ErrorIs(t, err, ErrNotEnrolled)
+
+	enrollment, err := s.Enroll(ctx, userID, "user-1")
+	require.NoError(t, err)
+	assert.Contains(t, enrollment.URI, "otpauth://totp/TimeTracker:user-1?")
+	assert.Contains(t, enrollment.URI, "secret="+enrollment.Secret)
+
+	// A pending enrollment is not enabled and can't be used to log in
+	enabled, err := s.Enabled(ctx, userID)
+	require.NoError(t, err)
+	assert.False(t, enabled)
+	assert.ErrorIs(t, s.Verify(ctx, userID, s.code(t, enrollment.Secret)), ErrNotEnrolled)
+
+	_, err = s.Confirm(ctx, userID, s.wrong(t, enrollment.Secret))
+	assert.ErrorIs(t, err, ErrInvalidCode)
+
+	codes, err := s.Confirm(ctx, userID, s.code(t, enrollment.Secret))
+	require.NoError(t, err)
+	assert.Len(t, codes, recoveryCodesCount)
+
+	enabled, err = s.Enabled(ctx, userID)
+	require.NoError(t, err)
+	assert.True(t, enabled)
+
+	_, err = s.Enroll(ctx, userID, "user-1")
+	assert.ErrorIs(t, err, ErrAlreadyEnabled)
+
+	s.now = s.now.Add(30 * time.Second)
+	assert.NoError(t, s.Verify(ctx, userID, s.code(t, enrollment.Secret)))
+	assert.ErrorIs(t, s.Verify(ctx, userID, s.wrong(t, enrollment.Secret)), ErrInvalidCode)
+
+	s.now = s.now.Add(30 * time.Second)
+	require.NoError(t, s.Disable(ctx, userID, s.code(t, enrollment.Secret)))
+	enabled, err = s.Enabled(ctx, userID)
+	require.NoError(t, err)
+	assert.False(t, enabled)
+}
+
+func TestService_RejectsReplayedCodes(t *testing.T) {
+	ctx := context.Background()
+	s := newService(t)
+	secret, _ := s.enable(t)
+
+	code := s.code(t, secret)
+	require.NoError(t, s.Verify(ctx, userID, code))
+	assert.ErrorIs(t, s.Verify(ctx, userID, code), ErrInvalidCode)
+
+	// The code of the previous step is within the skew, but older than the used one
+	previous, err := totp.CodeAt(secret, totp.Step(s.now)-1)
+	require.NoError(t, err)
+	assert.ErrorIs(t, s.Verify(ctx, userID, previous), ErrInvalidCode)
+
+	// Of concurrent requests with the same code only one passes
+	s.now = s.now.Add(30 * time.Second)
+	code = s.code(t, secret)
+
+	var wg sync.WaitGroup
+	results := make(chan error, 10)
+	for i := 0; i < cap(results); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- s.Verify(ctx, userID, code)
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	passed := 0
+	for err := range results {
+		if err == nil {
+			passed++
+		} else if !errors.Is(err, ErrInvalidCode) && !errors.Is(err, ErrLocked) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	assert.Equal(t, 1, passed)
+}
+
+func TestService_RecoveryCodesAreSingleUse(t *testing.T) {
+	ctx := context.Background()
+	s := newService(t)
+	_, codes := s.enable(t)
+
+	require.NoError(t, s.Verify(ctx, userID, codes[0]))
+	assert.ErrorIs(t, s.Verify(ctx, userID, codes[0]), ErrInvalidCode)
+	require.NoError(t, s.Verify(ctx, userID, codes[1]))
+}
+
+func TestService_LocksOutAfterFailedAttempts(t *testing.T) {
+	ctx := context.Background()
+	s := newService(t)
+	secret, codes := s.enable(t)
+
+	for i := 1; i < maxFailedAttempts; i++ {
+		assert.ErrorIs(t, s.Verify(ctx, userID, s.wrong(t, secret)), ErrInvalidCode)
+	}
+	assert.ErrorIs(t, s.Verify(ctx, userID, s.wrong(t, secret)), ErrLocked)
+
+	// No code is checked while locked out, not even a valid one
+	assert.ErrorIs(t, s.Verify(ctx, userID, s.code(t, secret)), ErrLocked)
+	assert.ErrorIs(t, s.Verify(ctx, userID, codes[0]), ErrLocked)
+	assert.ErrorIs(t, s.Disable(ctx, userID, s.code(t, secret)), ErrLocked)
+
+	// Another wrong code after the lockout locks it out again
+	s.now = s.now.Add(lockoutDuration)
+	assert.ErrorIs(t, s.Verify(ctx, userID, s.wrong(t, secret)), ErrLocked)
+
+	s.now = s.now.Add(lockoutDuration)
+	require.NoError(t, s.Verify(ctx, userID, s.code(t, secret)))
+
+	// An accepted code starts the count over
+	for i := 1; i < maxFailedAttempts; i++ {
+		assert.ErrorIs(t, s.Verify(ctx, userID, s.wrong(t, secret)), ErrInvalidCode)
+	}
+	require.NoError(t, s.Verify(ctx, userID, codes[0]))
+	assert.ErrorIs(t, s.Verify(ctx, userID, s.wrong(t, secret)), ErrInvalidCode)
+}
@@ -0,0 +1,436 @@
+// Package webhooks posts the events of the bus to the URLs subscribed to them. Every
+// delivery is kept in the delivery log and sent by a job of the database queue, so a
+// failing endpoint is retried with the backoff of the queue and can be redelivered.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wurt83ow/timetracker/internal/events"
+	"github.com/wurt83ow/timetracker/internal/jobs"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// JobKind is the kind of the jobs and worker pool tasks that send the deliveries
+const JobKind = "webhook"
+
+// Headers of the delivery requests
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+const (
+	defaultTimeout = 10 * time.Second
+
+	// secretLength is the number of random bytes of a generated secret
+	secretLength = 32
+
+	// maxResponseLength bounds the response body kept as the error of a failed attempt
+	maxResponseLength = 200
+)
+
+// ErrInvalidWebhook is returned by Create for a webhook with a bad URL or event type
+var ErrInvalidWebhook = errors.New("invalid webhook")
+
+// EventTypes are the types of the events a webhook may subscribe to
+var EventTypes = []string{
+	events.UserRegistered,
+	events.UserPassportChanged,
+	events.UserEnriched,
+	events.TaskCreated,
+	events.TimerStarted,
+	events.TimerStopped,
+}
+
+type Log interface {
+	Info(string, ...zapcore.Field)
+}
+
+type Storage interface {
+	SaveWebhook(context.Context, models.Webhook) (int, error)
+	GetWebhook(context.Context, int) (models.Webhook, error)
+	GetWebhooks(context.Context) ([]models.Webhook, error)
+	DeleteWebhook(context.Context, int) error
+	SaveWebhookDelivery(context.Context, models.WebhookDelivery) (int64, error)
+	GetWebhookDelivery(context.Context, int64) (models.WebhookDelivery, error)
+	GetWebhookDeliveries(context.Context, int, int) ([]models.WebhookDelivery, error)
+	UpdateWebhookDelivery(context.Context, models.WebhookDelivery) error
+}
+
+type Jobs interface {
+	Handle(kind string, handler jobs.Handler)
+	Enqueue(ctx context.Context, kind, key string, payload any, runAt time.Time) (int64, error)
+}
+
+type Bus interface {
+	SubscribeBlocking(events.Handler, ...string) func()
+}
+
+// Cipher keeps the secrets of the webhooks encrypted at rest
+type Cipher interface {
+	Encrypt([]byte) (string, error)
+	Decrypt(string) ([]byte, error)
+}
+
+// Options configure the requests of the deliveries
+type Options interface {
+	WebhookTimeout() string
+}
+
+// deliveryJob is the payload of the webhook jobs
+type deliveryJob struct {
+	DeliveryID int64 `json:"delivery_id"`
+}
+
+// Service keeps the webhooks and delivers the events to them
+type Service struct {
+	storage Storage
+	jobs    Jobs
+	bus     Bus
+	cipher  Cipher
+	log     Log
+	client  *http.Client
+	now     func() time.Time
+
+	mx          sync.Mutex
+	ctx         context.Context
+	unsubscribe func()
+}
+
+// NewService creates a webhook service. Invalid options are logged and replaced with the defaults.
+func NewService(storage Storage, jobs Jobs, bus Bus, cipher Cipher, log Log, option Options) *Service {
+	timeout, err := time.ParseDuration(option.WebhookTimeout())
+	if err != nil || timeout <= 0 {
+		log.Info("invalid WEBHOOK_TIMEOUT, using the default: ", zap.Duration("default", defaultTimeout))
+		timeout = defaultTimeout
+	}
+
+	return &Service{
+		storage: storage,
+		jobs:    jobs,
+		bus:     bus,
+		cipher:  cipher,
+		log:     log,
+		client:  &http.Client{Timeout: timeout},
+		now:     time.Now,
+	}
+}
+
+// Start registers the delivery jobs and subscribes to the events. ctx bounds the
+// recording of the deliveries.
+func (s *Service) Start(ctx context.Context) {
+	s.jobs.Handle(JobKind, s.deliver)
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.ctx = ctx
+	// A dropped event would be a delivery that is neither logged nor retried
+	s.unsubscribe = s.bus.SubscribeBlocking(s.dispatch, EventTypes...)
+}
+
+// Stop stops recording new deliveries, the queued ones are sent by the jobs
+func (s *Service) Stop() {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.unsubscribe != nil {
+		s.unsubscribe()
+		s.unsubscribe = nil
+	}
+}
+
+// Create validates and saves a webhook of the user. A secret is generated unless
+// one is given; the returned webhook holds it in plain text, the only time it is shown.
+func (s *Service) Create(ctx context.Context, userID int, req models.RequestWebhook) (models.Webhook, error) {
+	hook, err := newWebhook(req, userID)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+
+	secret := hook.Secret
+	if secret == "" {
+		if secret, err = newSecret(); err != nil {
+			return models.Webhook{}, err
+		}
+	}
+
+	hook.Secret, err = s.cipher.Encrypt([]byte(secret))
+	if err != nil {
+		return models.Webhook{}, fmt.Errorf("failed to encrypt webhook secret: %w", err)
+	}
+
+	hook.ID, err = s.storage.SaveWebhook(ctx, hook)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+
+	hook.Secret = secret
+	return hook, nil
+}
+
+// List returns the webhooks without their secrets
+func (s *Service) List(ctx context.Context) ([]models.Webhook, error) {
+	hooks, err := s.storage.GetWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+
+	return hooks, nil
+}
+
+// Delete deletes a webhook with its delivery log
+func (s *Service) Delete(ctx context.Context, id int) error {
+	return s.storage.DeleteWebhook(ctx, id)
+}
+
+// Deliveries returns up to limit deliveries of a webhook, the most recent first
+func (s *Service) Deliveries(ctx context.Context, webhookID int, limit int) ([]models.WebhookDelivery, error) {
+	if _, err := s.storage.GetWebhook(ctx, webhookID); err != nil {
+		return nil, err
+	}
+
+	return s.storage.GetWebhookDeliveries(ctx, webhookID, limit)
+}
+
+// Redeliver queues a delivery of the webhook again. A delivery queued already
+// returns storage.ErrConflict.
+func (s *Service) Redeliver(ctx context.Context, webhookID int, deliveryID int64) error {
+	d, err := s.storage.GetWebhookDelivery(ctx, deliveryID)
+	if err != nil {
+		return err
+	}
+	if d.WebhookID != webhookID {
+		return storage.ErrNotFound
+	}
+
+	return s.enqueue(ctx, d.ID)
+}
+
+// dispatch records a delivery of the event for every webhook subscribed to it
+func (s *Service) dispatch(event events.Event) {
+	s.mx.Lock()
+	ctx := s.ctx
+	s.mx.Unlock()
+
+	hooks, err := s.storage.GetWebhooks(ctx)
+	if err != nil {
+		s.log.Info("failed to load webhooks: ", zap.String("event", event.Type), zap.Error(err))
+		return
+	}
+
+	var payload []byte
+	for _, hook := range hooks {
+		if !subscribed(hook, event.Type) {
+			continue
+		}
+
+		if payload == nil {
+			if payload, err = json.Marshal(event); err != nil {
+				s.log.Info("failed to encode webhook payload: ", zap.String("event", event.Type), zap.Error(err))
+				return
+			}
+		}
+
+		id, err := s.storage.SaveWebhookDelivery(ctx, models.WebhookDelivery{
+			WebhookID: hook.ID,
+			EventType: event.Type,
+			Payload:   payload,
+		})
+		if err != nil {
+			// The webhook may be deleted in the meantime
+			if !errors.Is(err, storage.ErrNotFound) {
+				s.log.Info("failed to save webhook delivery: ", zap.Int("webhook", hook.ID), zap.Error(err))
+			}
+			continue
+		}
+
+		if err := s.enqueue(ctx, id); err != nil {
+			s.log.Info("failed to queue webhook delivery: ", zap.Int64("delivery", id), zap.Error(err))
+		}
+	}
+}
+
+// enqueue queues the job sending the delivery. The key of the job is the delivery,
+// so a delivery is queued once.
+func (s *Service) enqueue(ctx context.Context, deliveryID int64) error {
+	key := fmt.Sprintf("%s:%d", JobKind, deliveryID)
+	_, err := s.jobs.Enqueue(ctx, JobKind, key, deliveryJob{DeliveryID: deliveryID}, time.Time{})
+	return err
+}
+
+// deliver runs a webhook job. A failed attempt is recorded and returned, so the
+// queue retries it.
+func (s *Service) deliver(ctx context.Context, job models.Job) error {
+	var payload deliveryJob
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return fmt.Errorf("invalid webhook job payload: %w", err)
+	}
+
+	d, err := s.storage.GetWebhookDelivery(ctx, payload.DeliveryID)
+	if errors.Is(err, storage.ErrNotFound) {
+		// The webhook is deleted with its deliveries
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	hook, err := s.storage.GetWebhook(ctx, d.WebhookID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	secret, err := s.cipher.Decrypt(hook.Secret)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt webhook secret: %w", err)
+	}
+
+	status, sendErr := s.send(ctx, hook, d, secret)
+
+	d.Attempts++
+	d.ResponseStatus = status
+	if sendErr != nil {
+		d.Status = models.WebhookFailed
+		d.LastError = sendErr.Error()
+	} else {
+		now := s.now()
+		d.Status = models.WebhookSucceeded
+		d.LastError = ""
+		d.DeliveredAt = &now
+	}
+
+	if err := s.storage.UpdateWebhookDelivery(ctx, d); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		s.log.Info("failed to record webhook delivery: ", zap.Int64("delivery", d.ID), zap.Error(err))
+	}
+
+	if sendErr != nil {
+		return sendErr
+	}
+
+	s.log.Info("webhook delivered", zap.Int("webhook", hook.ID), zap.Int64("delivery", d.ID))
+	return nil
+}
+
+// send posts the payload of the delivery signed with the secret and returns the
+// status of the response. Any status but 2xx fails.
+func (s *Service) send(ctx context.Context, hook models.Webhook, d models.WebhookDelivery, secret []byte) (int, error) {
+	timestamp := strconv.FormatInt(s.now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, d.EventType)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(d.ID, 10))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(secret, timestamp, d.Payload))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseLength))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return resp.StatusCode, nil
+}
+
+// Sign returns the signature of a delivery, "sha256=" and the hex HMAC-SHA256 of
+// the timestamp and the body joined by a dot, keyed with the secret of the webhook
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// newWebhook validates the request
+func newWebhook(req models.RequestWebhook, userID int) (models.Webhook, error) {
+	hook := models.Webhook{
+		UserID:    userID,
+		URL:       strings.TrimSpace(req.URL),
+		Secret:    req.Secret,
+		CreatedAt: time.Now(),
+	}
+
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return hook, fmt.Errorf("%w: the URL must be an absolute http or https URL", ErrInvalidWebhook)
+	}
+
+	if len(req.Events) == 0 {
+		return hook, fmt.Errorf("%w: no event types", ErrInvalidWebhook)
+	}
+	seen := make(map[string]bool, len(req.Events))
+	for _, eventType := range req.Events {
+		if !known(eventType) {
+			return hook, fmt.Errorf("%w: unknown event type %q", ErrInvalidWebhook, eventType)
+		}
+		if !seen[eventType] {
+			seen[eventType] = true
+			hook.Events = append(hook.Events, eventType)
+		}
+	}
+
+	return hook, nil
+}
+
+func known(eventType string) bool {
+	for _, t := range EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func subscribed(hook models.Webhook, eventType string) bool {
+	for _, t := range hook.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, secretLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,225 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wurt83ow/timetracker/internal/encryption"
+	"github.com/wurt83ow/timetracker/internal/events"
+	"github.com/wurt83ow/timetracker/internal/jobs"
+	"github.com/wurt83ow/timetracker/internal/memkeeper"
+	"github.com/wurt83ow/timetracker/internal/models"
+	"github.com/wurt83ow/timetracker/internal/storage"
+	"go.uber.org/zap"
+)
+
+type options struct{}
+
+func (options) WebhookTimeout() string { return "5s" }
+
+// fakeJobs keeps the registered handlers and the queued jobs, which are run by the test
+type fakeJobs struct {
+	mx       sync.Mutex
+	handlers map[string]jobs.Handler
+	queued   []models.Job
+	keys     map[string]bool
+}
+
+func newFakeJobs() *fakeJobs {
+	return &fakeJobs{handlers: make(map[string]jobs.Handler), keys: make(map[string]bool)}
+}
+
+func (f *fakeJobs) Handle(kind string, handler jobs.Handler) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.handlers[kind] = handler
+}
+
+func (f *fakeJobs) Enqueue(ctx context.Context, kind, key string, payload any, runAt time.Time) (int64, error) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	if f.keys[key] {
+		return 0, storage.ErrConflict
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	f.keys[key] = true
+	f.queued = append(f.queued, models.Job{ID: int64(len(f.queued) + 1), Kind: kind, Key: key, Payload: raw})
+	return int64(len(f.queued)), nil
+}
+
+// run runs the queued jobs once, releasing their keys as the queue does
+func (f *fakeJobs) run(ctx context.Context) []error {
+	f.mx.Lock()
+	queued := f.queued
+	f.queued = nil
+	f.keys = make(map[string]bool)
+	f.mx.Unlock()
+
+	errs := make([]error, 0, len(queued))
+	for _, job := range queued {
+		errs = append(errs, f.handlers[job.Kind](ctx, job))
+	}
+	return errs
+}
+
+func (f *fakeJobs) pending() int {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return len(f.queued)
+}
+
+func newService(t *testing.T) (*Service, *memkeeper.MemKeeper, *fakeJobs, *events.Bus) {
+	envelope, err := encryption.NewEnvelope("test_encryption_key")
+	require.NoError(t, err)
+	cipher, err := encryption.NewCipher("test_encryption_key")
+	require.NoError(t, err)
+
+	kp := memkeeper.NewMemKeeper(func() string { return "" }, zap.NewNop(), func() string { return "5m" }, envelope)
+	bus := events.NewBus(zap.NewNop())
+	t.Cleanup(bus.Close)
+
+	queue := newFakeJobs()
+	return NewService(kp, queue, bus, cipher, zap.NewNop(), options{}), kp, queue, bus
+}
+
+func TestService_DeliversSignedEvents(t *testing.T) {
+	s, kp, queue, bus := newService(t)
+	ctx := context.Background()
+
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	requests := make(chan received, 10)
+	var fail atomic.Bool
+	fail.Store(true)
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- received{header: r.Header.Clone(), body: body}
+		if fail.Load() {
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+		}
+	}))
+	defer endpoint.Close()
+
+	hook, err := s.Create(ctx, 1, models.RequestWebhook{URL: endpoint.URL, Events: []string{events.TimerStarted}})
+	require.NoError(t, err)
+	require.Len(t, hook.Secret, 2*secretLength)
+
+	// The secret is kept encrypted and isn't listed
+	stored, err := kp.GetWebhook(ctx, hook.ID)
+	require.NoError(t, err)
+	assert.NotEqual(t, hook.Secret, stored.Secret)
+	hooks, err := s.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, hooks, 1)
+	assert.Empty(t, hooks[0].Secret)
+
+	s.Start(ctx)
+	defer s.Stop()
+
+	// Only the subscribed events are delivered
+	bus.Publish(events.Event{Type: events.TaskCreated, ID: 3})
+	bus.Publish(events.Event{Type: events.TimerStarted, ID: 1, Data: events.Timer{UserID: 1, TaskID: 3}})
+	require.Eventually(t, func() bool { return queue.pending() == 1 }, time.Second, 10*time.Millisecond)
+
+	// A failed attempt is recorded and returned for the retry
+	errs := queue.run(ctx)
+	require.Len(t, errs, 1)
+	assert.Error(t, errs[0])
+
+	deliveries, err := s.Deliveries(ctx, hook.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	d := deliveries[0]
+	assert.Equal(t, models.WebhookFailed, d.Status)
+	assert.Equal(t, 1, d.Attempts)
+	assert.Equal(t, http.StatusServiceUnavailable, d.ResponseStatus)
+	assert.Contains(t, d.LastError, "try later")
+
+	req := <-requests
+	assert.Equal(t, events.TimerStarted, req.header.Get(HeaderEvent))
+	assert.Equal(t, strconv.FormatInt(d.ID, 10), req.header.Get(HeaderDelivery))
+	assert.Equal(t, Sign([]byte(hook.Secret), req.header.Get(HeaderTimestamp), req.body), req.header.Get(HeaderSignature))
+
+	var event events.Event
+	require.NoError(t, json.Unmarshal(req.body, &event))
+	assert.Equal(t, events.TimerStarted, event.Type)
+	assert.Equal(t, 1, event.ID)
+
+	// A redelivery succeeds once the endpoint recovers
+	fail.Store(false)
+	require.NoError(t, s.Redeliver(ctx, hook.ID, d.ID))
+	assert.ErrorIs(t, s.Redeliver(ctx, hook.ID, d.ID), storage.ErrConflict)
+	assert.ErrorIs(t, s.Redeliver(ctx, hook.ID+1, d.ID), storage.ErrNotFound)
+
+	errs = queue.run(ctx)
+	require.Len(t, errs, 1)
+	require.NoError(t, errs[0])
+
+	d, err = kp.GetWebhookDelivery(ctx, d.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.WebhookSucceeded, d.Status)
+	assert.Equal(t, 2, d.Attempts)
+	assert.Equal(t, http.StatusOK, d.ResponseStatus)
+	assert.Empty(t, d.LastError)
+	assert.NotNil(t, d.DeliveredAt)
+	<-requests
+
+	// The jobs of a deleted webhook are dropped
+	require.NoError(t, s.Redeliver(ctx, hook.ID, d.ID))
+	require.NoError(t, s.Delete(ctx, hook.ID))
+	errs = queue.run(ctx)
+	require.Len(t, errs, 1)
+	assert.NoError(t, errs[0])
+	assert.Empty(t, requests)
+}
+
+func TestService_Create(t *testing.T) {
+	s, _, _, _ := newService(t)
+	ctx := context.Background()
+
+	hook, err := s.Create(ctx, 1, models.RequestWebhook{
+		URL:    " https://example.com/hooks ",
+		Events: []string{events.TaskCreated, events.TaskCreated, events.TimerStopped},
+		Secret: "my-secret",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/hooks", hook.URL)
+	assert.Equal(t, []string{events.TaskCreated, events.TimerStopped}, hook.Events)
+	assert.Equal(t, "my-secret", hook.Secret)
+
+	invalid := []models.RequestWebhook{
+		{URL: "ftp://example.com", Events: []string{events.TaskCreated}},
+		{URL: "/hooks", Events: []string{events.TaskCreated}},
+		{URL: "https://example.com", Events: nil},
+		{URL: "https://example.com", Events: []string{"task.deleted"}},
+	}
+	for _, req := range invalid {
+		_, err := s.Create(ctx, 1, req)
+		assert.True(t, errors.Is(err, ErrInvalidWebhook), req)
+	}
+}
+
+func TestSign(t *testing.T) {
+	// Computed with: printf '1700000000.{}' | openssl dgst -sha256 -hmac secret
+	assert.Equal(t,
+		"sha256=b8569b78799ff9e3cbff0fc2d63a33a2b57f3282abd07c37ae5e8e7d79a5f163",
+		Sign([]byte("secret"), "1700000000", []byte("{}")),
+	)
+}
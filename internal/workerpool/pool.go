@@ -1,112 +1,326 @@
 package workerpool
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"strconv"
 	"sync"
 	"time"
 
-	"github.com/wurt83ow/timetracker/internal/models"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
-type External interface {
-	GetUserInfo(int, int) (models.ExtUserData, error)
-}
+var (
+	// ErrQueueFull is returned by TrySubmit when the queue of the kind has no room for the task
+	ErrQueueFull = errors.New("worker pool queue is full")
+	// ErrClosed is returned when a task is submitted after Shutdown
+	ErrClosed = errors.New("worker pool is shut down")
+)
+
+const (
+	defaultConcurrency = 5
+	defaultQueueSize   = 1000
+)
 
 type Log interface {
 	Info(string, ...zapcore.Field)
 }
 
-// Pool.
+// Options configure the workers and the queues of the task kinds. The kind lists
+// look like "export=1,enrichment=4"; a kind missing from a list gets priority 0,
+// no concurrency limit below the number of workers, and no rate limit.
+type Options interface {
+	Concurrency() string
+	WorkerQueueSize() string
+	WorkerPriorities() string
+	WorkerKindConcurrency() string
+	WorkerRateLimits() string
+}
+
+// Pool runs the submitted tasks on a fixed number of workers. Every kind of task
+// has a queue of its own, so that a flood of one kind doesn't fill the queue of
+// the others; the workers take the oldest task of the highest priority kind that
+// is below its concurrency and rate limits.
 type Pool struct {
-	Tasks   []*Task
-	Workers []*Worker
-
-	concurrency   int
-	collector     chan *Task
-	runBackground chan bool
-	wg            sync.WaitGroup
-	log           Log
-	taskInterval  int
+	concurrency int
+	queueSize   int
+	priorities  map[string]int
+	limits      map[string]int
+	rates       map[string]time.Duration
+
+	// ctx is the context of the tasks, cancelled when Shutdown gives up waiting
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mx     sync.Mutex
+	cond   *sync.Cond
+	queues map[string]*queue
+	closed bool
+	seq    uint64
+	timer  *time.Timer
+	wakeAt time.Time
+	wg     sync.WaitGroup
+	now    func() time.Time
 }
 
-// NewPool initializes a new pool with the given tasks.
-func NewPool(tasks []*Task, concurrency func() string, log Log, TaskExecutionInterval func() string) *Pool {
-	taskInterval, err := strconv.Atoi(TaskExecutionInterval())
+// NewPool creates a pool from the options. Invalid options are logged and replaced with the defaults.
+func NewPool(option Options, log Log) *Pool {
+	conc, err := strconv.Atoi(option.Concurrency())
+	if err != nil || conc < 1 {
+		log.Info("invalid CONCURRENCY, using the default: ", zap.Int("default", defaultConcurrency))
+		conc = defaultConcurrency
+	}
+
+	size, err := strconv.Atoi(option.WorkerQueueSize())
+	if err != nil || size < 0 {
+		log.Info("invalid WORKER_QUEUE_SIZE, using the default: ", zap.Int("default", defaultQueueSize))
+		size = defaultQueueSize
+	}
 
+	priorities, err := parseKinds(option.WorkerPriorities(), strconv.Atoi)
 	if err != nil {
-		log.Info("cannot convert concurrency option 'TaskExecutionInterval': ", zap.Error(err))
-		taskInterval = 3000
+		log.Info("invalid WORKER_PRIORITIES, ignoring them: ", zap.Error(err))
+		priorities = nil
 	}
 
-	conc, err := strconv.Atoi(concurrency())
+	limits, err := parseKinds(option.WorkerKindConcurrency(), func(s string) (int, error) {
+		n, err := strconv.Atoi(s)
+		if err == nil && n < 1 {
+			err = errors.New("the limit must be positive")
+		}
+		return n, err
+	})
 	if err != nil {
-		log.Info("cannot convert concurrency option: ", zap.Error(err))
-		conc = 5
+		log.Info("invalid WORKER_KIND_CONCURRENCY, ignoring it: ", zap.Error(err))
+		limits = nil
 	}
 
-	return &Pool{
-		Tasks:        tasks,
-		concurrency:  conc,
-		collector:    make(chan *Task, 1000),
-		log:          log,
-		taskInterval: taskInterval,
+	rates, err := parseKinds(option.WorkerRateLimits(), parseRate)
+	if err != nil {
+		log.Info("invalid WORKER_RATE_LIMITS, ignoring them: ", zap.Error(err))
+		rates = nil
 	}
-}
 
-// Starts all the work in the Pool and blocks until it is finished.
-func (p *Pool) Run() {
-	for i := 1; i <= p.concurrency; i++ {
-		worker := NewWorker(p.collector, i)
-		worker.Start(&p.wg)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	p := &Pool{
+		concurrency: conc,
+		queueSize:   size,
+		priorities:  priorities,
+		limits:      limits,
+		rates:       rates,
+		ctx:         ctx,
+		cancel:      cancel,
+		queues:      make(map[string]*queue),
+		now:         time.Now,
 	}
+	p.cond = sync.NewCond(&p.mx)
 
-	for i := range p.Tasks {
-		p.collector <- p.Tasks[i]
+	return p
+}
+
+// Start starts the workers
+func (p *Pool) Start() {
+	for i := 0; i < p.concurrency; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for {
+				task, q := p.next()
+				if task == nil {
+					return
+				}
+
+				task.run(p.ctx)
+				p.finish(q)
+			}
+		}()
 	}
-	close(p.collector)
+}
 
-	p.wg.Wait()
+// Submit queues the task, waiting for room in the queue of its kind until ctx is done
+func (p *Pool) Submit(ctx context.Context, task *Task) error {
+	stop := context.AfterFunc(ctx, func() {
+		p.mx.Lock()
+		defer p.mx.Unlock()
+		p.cond.Broadcast()
+	})
+	defer stop()
+
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	for {
+		if p.closed {
+			return ErrClosed
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if q := p.queue(task.kind); len(q.tasks) < p.queueSize {
+			p.push(q, task)
+			return nil
+		}
+		p.cond.Wait()
+	}
 }
 
-// AddTask adds tasks to the pool.
-func (p *Pool) AddTask(task *Task) {
-	p.collector <- task
+// TrySubmit queues the task if there is room in the queue of its kind, or returns ErrQueueFull
+func (p *Pool) TrySubmit(task *Task) error {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	if p.closed {
+		return ErrClosed
+	}
+
+	q := p.queue(task.kind)
+	if len(q.tasks) >= p.queueSize {
+		return ErrQueueFull
+	}
+
+	p.push(q, task)
+	return nil
 }
 
-// RunBackground runs the pool in the background.
-func (p *Pool) RunBackground() {
+// Shutdown stops accepting tasks and waits for the queued ones to finish. If ctx
+// is done first, the running tasks are cancelled, the queued ones finish with
+// context.Canceled without running, and the error of ctx is returned.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.mx.Lock()
+	p.closed = true
+	p.cond.Broadcast()
+	p.mx.Unlock()
+
+	drained := make(chan struct{})
 	go func() {
-		for {
-			fmt.Print("⌛ Waiting for tasks to come in ...\n")
-			time.Sleep(time.Duration(p.taskInterval) * time.Millisecond)
-		}
+		p.wg.Wait()
+		close(drained)
 	}()
 
-	for i := 1; i <= p.concurrency; i++ {
-		worker := NewWorker(p.collector, i)
-		p.Workers = append(p.Workers, worker)
-		go worker.StartBackground()
+	select {
+	case <-drained:
+		p.cancel()
+		return nil
+	case <-ctx.Done():
+		p.cancel()
+
+		// The rate limits no longer hold back the cancelled tasks
+		p.mx.Lock()
+		p.cond.Broadcast()
+		p.mx.Unlock()
+
+		<-drained
+		return ctx.Err()
+	}
+}
+
+// queue returns the queue of the kind, creating it on first use. Called with mx held.
+func (p *Pool) queue(kind string) *queue {
+	q, ok := p.queues[kind]
+	if !ok {
+		q = &queue{kind: kind, priority: p.priorities[kind], limit: p.limits[kind]}
+		if interval, ok := p.rates[kind]; ok {
+			q.limiter = &limiter{interval: interval}
+		}
+		p.queues[kind] = q
+	}
+	return q
+}
+
+func (p *Pool) push(q *queue, task *Task) {
+	p.seq++
+	task.seq = p.seq
+	q.tasks = append(q.tasks, task)
+	p.cond.Broadcast()
+}
+
+// next waits for a task that may run. It returns nil once the pool is shut down
+// and every queue is empty.
+func (p *Pool) next() (*Task, *queue) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	for {
+		q, wait, pending := p.pick()
+		if q != nil {
+			task := q.tasks[0]
+			q.tasks[0] = nil
+			q.tasks = q.tasks[1:]
+			q.running++
+			if p.ctx.Err() == nil {
+				q.limiter.take(p.now())
+			}
+			return task, q
+		}
+
+		if !pending && p.closed {
+			return nil, nil
+		}
+
+		if wait > 0 {
+			p.wakeAfter(wait)
+		}
+		p.cond.Wait()
 	}
+}
+
+// pick returns the queue to take a task from. Otherwise it tells how long the
+// first task held back by a rate limit has to wait, and whether any task is queued.
+func (p *Pool) pick() (best *queue, wait time.Duration, pending bool) {
+	now := p.now()
+	cancelled := p.ctx.Err() != nil
+
+	for _, q := range p.queues {
+		if len(q.tasks) == 0 {
+			continue
+		}
+		pending = true
 
-	for i := range p.Tasks {
-		p.collector <- p.Tasks[i]
+		if q.limit > 0 && q.running >= q.limit {
+			continue
+		}
+		if w := q.limiter.wait(now); w > 0 && !cancelled {
+			if wait == 0 || w < wait {
+				wait = w
+			}
+			continue
+		}
+
+		// Among the kinds of the same priority the oldest task goes first
+		if best == nil || q.priority > best.priority ||
+			q.priority == best.priority && q.tasks[0].seq < best.tasks[0].seq {
+			best = q
+		}
 	}
 
-	p.runBackground = make(chan bool)
-	<-p.runBackground
+	return best, wait, pending
 }
 
-// Stop stops workers running in the background.
-func (p *Pool) Stop() {
-	for i := range p.Workers {
-		p.Workers[i].Stop()
+// wakeAfter wakes the workers once a rate limit lets a task through. Called with mx held.
+func (p *Pool) wakeAfter(wait time.Duration) {
+	at := p.now().Add(wait)
+	if p.timer != nil {
+		if p.wakeAt.After(p.now()) && !p.wakeAt.After(at) {
+			// An earlier wake-up is scheduled already
+			return
+		}
+		p.timer.Stop()
 	}
 
-	// p.cancelFunc()
-	// p.wg.Wait()
+	p.wakeAt = at
+	p.timer = time.AfterFunc(wait, func() {
+		p.mx.Lock()
+		defer p.mx.Unlock()
+		p.cond.Broadcast()
+	})
+}
+
+func (p *Pool) finish(q *queue) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
 
-	p.runBackground <- true
+	q.running--
+	p.cond.Broadcast()
 }
@@ -0,0 +1,217 @@
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type options struct {
+	concurrency, queueSize, priorities, kindConcurrency, rateLimits string
+}
+
+func (o options) Concurrency() string           { return o.concurrency }
+func (o options) WorkerQueueSize() string       { return o.queueSize }
+func (o options) WorkerPriorities() string      { return o.priorities }
+func (o options) WorkerKindConcurrency() string { return o.kindConcurrency }
+func (o options) WorkerRateLimits() string      { return o.rateLimits }
+
+func newPool(concurrency, queueSize string) *Pool {
+	return NewPool(options{concurrency: concurrency, queueSize: queueSize}, zap.NewNop())
+}
+
+func noop(context.Context) (any, error) {
+	return nil, nil
+}
+
+func TestPool_ReturnsResults(t *testing.T) {
+	p := newPool("2", "10")
+	p.Start()
+
+	var called error
+	ok := NewTask("", func(context.Context) (any, error) { return 42, nil })
+	failed := NewTask("", func(context.Context) (any, error) { return nil, errors.New("unavailable") }).
+		OnDone(func(_ any, err error) { called = err })
+	panicked := NewTask("", func(context.Context) (any, error) { panic("boom") })
+
+	for _, task := range []*Task{ok, failed, panicked} {
+		require.NoError(t, p.Submit(context.Background(), task))
+	}
+
+	result, err := ok.Wait(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 42, result)
+
+	_, err = failed.Wait(context.Background())
+	assert.EqualError(t, err, "unavailable")
+	assert.EqualError(t, called, "unavailable", "the callback runs before Wait returns")
+
+	_, err = panicked.Wait(context.Background())
+	assert.ErrorContains(t, err, "boom")
+
+	require.NoError(t, p.Shutdown(context.Background()))
+}
+
+func TestPool_TrySubmitBackpressure(t *testing.T) {
+	p := newPool("1", "1")
+
+	// No workers are running, so the queue fills up
+	require.NoError(t, p.TrySubmit(NewTask("", noop)))
+	assert.ErrorIs(t, p.TrySubmit(NewTask("", noop)), ErrQueueFull)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, p.Submit(ctx, NewTask("", noop)), context.DeadlineExceeded)
+
+	p.Start()
+	require.NoError(t, p.Shutdown(context.Background()))
+	assert.ErrorIs(t, p.TrySubmit(NewTask("", noop)), ErrClosed)
+}
+
+func TestPool_ShutdownDrains(t *testing.T) {
+	p := newPool("1", "10")
+	p.Start()
+
+	tasks := make([]*Task, 3)
+	for i := range tasks {
+		tasks[i] = NewTask("", func(context.Context) (any, error) {
+			time.Sleep(5 * time.Millisecond)
+			return nil, nil
+		})
+		require.NoError(t, p.Submit(context.Background(), tasks[i]))
+	}
+
+	require.NoError(t, p.Shutdown(context.Background()))
+	for _, task := range tasks {
+		select {
+		case <-task.Done():
+		default:
+			t.Fatal("a queued task was abandoned")
+		}
+	}
+}
+
+func TestPool_ShutdownCancels(t *testing.T) {
+	p := newPool("1", "10")
+	p.Start()
+
+	started := make(chan struct{})
+	running := NewTask("", func(ctx context.Context) (any, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	queued := NewTask("", func(context.Context) (any, error) { return "ran", nil })
+
+	require.NoError(t, p.Submit(context.Background(), running))
+	require.NoError(t, p.Submit(context.Background(), queued))
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, p.Shutdown(ctx), context.DeadlineExceeded)
+
+	_, err := running.Wait(context.Background())
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = queued.Wait(context.Background())
+	assert.ErrorIs(t, err, context.Canceled, "queued tasks don't run after the cancellation")
+}
+
+func TestPool_PrioritiesAndKindLimits(t *testing.T) {
+	p := NewPool(options{
+		concurrency:     "1",
+		queueSize:       "10",
+		priorities:      "interactive=10,export=-1",
+		kindConcurrency: "export=1",
+	}, zap.NewNop())
+
+	var mx sync.Mutex
+	var order []string
+	record := func(kind string) *Task {
+		var task *Task
+		task = NewTask(kind, func(context.Context) (any, error) {
+			mx.Lock()
+			defer mx.Unlock()
+			order = append(order, task.Kind())
+			return nil, nil
+		})
+		return task
+	}
+
+	// Queued before the workers start, so that the priorities decide the order
+	for _, kind := range []string{"export", "export", "", "interactive"} {
+		require.NoError(t, p.TrySubmit(record(kind)))
+	}
+
+	// Every kind has a queue of its own
+	for i := 0; i < 8; i++ {
+		require.NoError(t, p.TrySubmit(NewTask("export", noop)))
+	}
+	assert.ErrorIs(t, p.TrySubmit(NewTask("export", noop)), ErrQueueFull)
+	assert.NoError(t, p.TrySubmit(NewTask("interactive", noop)))
+
+	p.Start()
+	require.NoError(t, p.Shutdown(context.Background()))
+	assert.Equal(t, []string{"interactive", KindDefault, "export", "export"}, order)
+}
+
+func TestPool_KindConcurrency(t *testing.T) {
+	p := NewPool(options{concurrency: "4", queueSize: "10", kindConcurrency: "export=1"}, zap.NewNop())
+	p.Start()
+
+	var mx sync.Mutex
+	running, peak := 0, 0
+	for i := 0; i < 4; i++ {
+		require.NoError(t, p.TrySubmit(NewTask("export", func(context.Context) (any, error) {
+			mx.Lock()
+			running++
+			peak = max(peak, running)
+			mx.Unlock()
+
+			time.Sleep(5 * time.Millisecond)
+
+			mx.Lock()
+			running--
+			mx.Unlock()
+			return nil, nil
+		})))
+	}
+
+	require.NoError(t, p.Shutdown(context.Background()))
+	assert.Equal(t, 1, peak)
+}
+
+func TestPool_RateLimits(t *testing.T) {
+	p := NewPool(options{concurrency: "2", queueSize: "10", rateLimits: "api=50/s"}, zap.NewNop())
+	p.Start()
+
+	start := time.Now()
+	tasks := make([]*Task, 3)
+	for i := range tasks {
+		tasks[i] = NewTask("api", noop)
+		require.NoError(t, p.TrySubmit(tasks[i]))
+	}
+	require.NoError(t, p.Shutdown(context.Background()))
+
+	// The first task runs right away, the next ones 20ms apart
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+}
+
+func TestParseKinds(t *testing.T) {
+	rates, err := parseKinds("api=10/s, export=30/m,bulk=0.5", parseRate)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]time.Duration{"api": 100 * time.Millisecond, "export": 2 * time.Second, "bulk": 2 * time.Second}, rates)
+
+	_, err = parseKinds("api", parseRate)
+	assert.ErrorIs(t, err, errInvalidKinds)
+	_, err = parseKinds("api=10/d", parseRate)
+	assert.ErrorIs(t, err, errInvalidKinds)
+	_, err = parseKinds("api=0", parseRate)
+	assert.ErrorIs(t, err, errInvalidKinds)
+}
@@ -0,0 +1,102 @@
+package workerpool
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KindDefault is the kind of the tasks created without one
+const KindDefault = "default"
+
+var errInvalidKinds = errors.New("invalid worker kinds")
+
+// queue holds the tasks of a kind. The kinds with a higher priority are run
+// first; limit bounds the tasks of the kind running at once.
+type queue struct {
+	kind     string
+	priority int
+	limit    int
+	limiter  *limiter
+	tasks    []*Task
+	running  int
+}
+
+// limiter lets through one task every interval
+type limiter struct {
+	interval time.Duration
+	next     time.Time
+}
+
+// wait returns how long the next task has to wait, 0 if it may run now
+func (l *limiter) wait(now time.Time) time.Duration {
+	if l == nil || !now.Before(l.next) {
+		return 0
+	}
+	return l.next.Sub(now)
+}
+
+func (l *limiter) take(now time.Time) {
+	if l == nil {
+		return
+	}
+	l.next = maxTime(l.next, now).Add(l.interval)
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// parseKinds reads a list like "export=1,enrichment=4" into the values of the kinds
+func parseKinds[T any](s string, parse func(string) (T, error)) (map[string]T, error) {
+	kinds := make(map[string]T)
+
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		kind, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q is not kind=value", errInvalidKinds, entry)
+		}
+
+		v, err := parse(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %v", errInvalidKinds, entry, err)
+		}
+		kinds[strings.TrimSpace(kind)] = v
+	}
+
+	return kinds, nil
+}
+
+// parseRate reads a rate like "10/s", "30/m" or "10" (per second) into the interval between tasks
+func parseRate(s string) (time.Duration, error) {
+	count, unit, _ := strings.Cut(s, "/")
+
+	n, err := strconv.ParseFloat(count, 64)
+	if err != nil || n <= 0 || math.IsInf(n, 0) {
+		return 0, fmt.Errorf("rate %q is not a positive number", s)
+	}
+
+	per := time.Second
+	switch unit {
+	case "", "s":
+	case "m":
+		per = time.Minute
+	case "h":
+		per = time.Hour
+	default:
+		return 0, fmt.Errorf("rate %q has an unknown unit", s)
+	}
+
+	return time.Duration(float64(per) / n), nil
+}
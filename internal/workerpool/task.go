@@ -1,20 +1,86 @@
 package workerpool
 
 import (
+	"context"
 	"fmt"
 )
 
+// Func is the work of a task. ctx is cancelled when the pool is shut down
+// without waiting for the running tasks.
+type Func func(ctx context.Context) (any, error)
+
+// Task is a unit of work of a kind and the future of its result
 type Task struct {
-	Err  error
-	Data interface{}
-	f    func(interface{}) error
+	kind     string
+	seq      uint64
+	f        Func
+	callback func(any, error)
+	done     chan struct{}
+	result   any
+	err      error
+}
+
+// NewTask creates a task of the kind, KindDefault if it is empty
+func NewTask(kind string, f Func) *Task {
+	if kind == "" {
+		kind = KindDefault
+	}
+	return &Task{kind: kind, f: f, done: make(chan struct{})}
+}
+
+// Kind returns the kind of the task
+func (t *Task) Kind() string {
+	return t.kind
+}
+
+// OnDone sets a callback that gets the result when the task is finished. It
+// runs on the worker, before Wait returns.
+func (t *Task) OnDone(callback func(result any, err error)) *Task {
+	t.callback = callback
+	return t
+}
+
+// Done is closed when the task is finished
+func (t *Task) Done() <-chan struct{} {
+	return t.done
+}
+
+// Wait returns the result of the task once it is finished, or the error of ctx
+func (t *Task) Wait(ctx context.Context) (any, error) {
+	select {
+	case <-t.done:
+		return t.result, t.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (t *Task) run(ctx context.Context) {
+	var result any
+	var err error
+
+	if err = ctx.Err(); err == nil {
+		result, err = t.call(ctx)
+	}
+
+	t.finish(result, err)
 }
 
-func NewTask(f func(interface{}) error, data interface{}) *Task {
-	return &Task{f: f, Data: data}
+// call runs the work, turning a panic into the error of the task
+func (t *Task) call(ctx context.Context) (result any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("task panicked: %v", r)
+		}
+	}()
+
+	return t.f(ctx)
 }
 
-func process(workerID int, task *Task) {
-	fmt.Printf("Worker %d processes task %v\n", workerID, task.Data)
-	task.Err = task.f(task.Data)
+func (t *Task) finish(result any, err error) {
+	t.result, t.err = result, err
+	if t.callback != nil {
+		t.callback(result, err)
+	}
+	close(t.done)
 }
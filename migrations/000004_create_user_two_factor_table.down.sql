@@ -0,0 +1,5 @@
+-- Drop the user_recovery_codes table
+DROP TABLE IF EXISTS user_recovery_codes;
+
+-- Drop the user_two_factor table
+DROP TABLE IF EXISTS user_two_factor;
\ No newline at end of file
@@ -0,0 +1,5 @@
+-- Drop indexes for the user_identities table
+DROP INDEX IF EXISTS idx_user_identities_user;
+
+-- Drop the user_identities table
+DROP TABLE IF EXISTS user_identities;
\ No newline at end of file
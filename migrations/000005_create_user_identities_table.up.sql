@@ -0,0 +1,15 @@
+-- User_identities table
+CREATE TABLE user_identities (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES Users(id) ON DELETE CASCADE,
+    UNIQUE (issuer, subject)
+);
+
+-- Indexes for the user_identities table
+-- Used by: DeleteUser (cascade)
+CREATE INDEX idx_user_identities_user ON user_identities (user_id);
@@ -0,0 +1,27 @@
+-- The encrypted values cannot be decrypted in SQL, and the up migration cleared
+-- the plaintext columns. Refuse to downgrade while any encrypted value is left
+-- instead of discarding the personal data of users.
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM Users
+        WHERE passport_enc IS NOT NULL
+            OR surname_enc IS NOT NULL
+            OR name_enc IS NOT NULL
+            OR patronymic_enc IS NOT NULL
+            OR address_enc IS NOT NULL
+    ) THEN
+        RAISE EXCEPTION 'Users holds encrypted personal data: decrypt it into the plaintext columns before downgrading past migration 6';
+    END IF;
+END
+$$;
+
+DROP INDEX IF EXISTS idx_users_passport_bidx;
+
+ALTER TABLE Users
+    DROP COLUMN IF EXISTS passport_bidx,
+    DROP COLUMN IF EXISTS passport_enc,
+    DROP COLUMN IF EXISTS surname_enc,
+    DROP COLUMN IF EXISTS name_enc,
+    DROP COLUMN IF EXISTS patronymic_enc,
+    DROP COLUMN IF EXISTS address_enc;
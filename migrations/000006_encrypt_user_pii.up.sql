@@ -0,0 +1,14 @@
+-- Encrypted copies of the personal data of users. The keeper moves existing
+-- plaintext values into these columns on startup and clears the originals.
+ALTER TABLE Users
+    ADD COLUMN passport_bidx VARCHAR(64),
+    ADD COLUMN passport_enc TEXT,
+    ADD COLUMN surname_enc TEXT,
+    ADD COLUMN name_enc TEXT,
+    ADD COLUMN patronymic_enc TEXT,
+    ADD COLUMN address_enc TEXT,
+    ALTER COLUMN passportSerie DROP NOT NULL,
+    ALTER COLUMN passportNumber DROP NOT NULL;
+
+-- Blind index of the passport, used by: SaveUser, GetUser, UpdateUsersInfo, UpdateUser
+CREATE UNIQUE INDEX idx_users_passport_bidx ON Users (passport_bidx);
@@ -0,0 +1,6 @@
+ALTER TABLE user_tasks DROP CONSTRAINT user_tasks_user_id_fkey;
+ALTER TABLE user_tasks
+    ADD CONSTRAINT user_tasks_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES Users(id);
+
+ALTER TABLE Users DROP COLUMN IF EXISTS erased_at;
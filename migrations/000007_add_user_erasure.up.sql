@@ -0,0 +1,8 @@
+-- Erased users keep their row, so that time entries stay attached for reporting
+ALTER TABLE Users ADD COLUMN erased_at TIMESTAMP WITH TIME ZONE;
+
+-- Deleting a user removes the time entries instead of failing on the foreign key
+ALTER TABLE user_tasks DROP CONSTRAINT user_tasks_user_id_fkey;
+ALTER TABLE user_tasks
+    ADD CONSTRAINT user_tasks_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES Users(id) ON DELETE CASCADE;
@@ -0,0 +1,13 @@
+-- Drop the append-only trigger
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+DROP FUNCTION IF EXISTS audit_events_append_only();
+
+-- Drop indexes for the audit_events table
+DROP INDEX IF EXISTS idx_audit_events_actor;
+DROP INDEX IF EXISTS idx_audit_events_entity;
+DROP INDEX IF EXISTS idx_audit_events_created_at;
+
+-- Drop the audit_events table
+DROP TABLE IF EXISTS audit_events;
+
+ALTER TABLE Users DROP COLUMN IF EXISTS role;
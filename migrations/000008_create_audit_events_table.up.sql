@@ -0,0 +1,35 @@
+-- Role of a user, admins can read the audit log
+ALTER TABLE Users ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'user';
+
+-- Audit_events table
+CREATE TABLE audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    actor_id INTEGER,
+    action VARCHAR(50) NOT NULL,
+    entity_type VARCHAR(50) NOT NULL,
+    entity_id INTEGER NOT NULL,
+    before JSONB,
+    after JSONB,
+    request_id VARCHAR(100),
+    ip VARCHAR(64),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Indexes for the audit_events table
+-- Used by: GetAuditEvents, ExportUser
+CREATE INDEX idx_audit_events_actor ON audit_events (actor_id, id);
+-- Used by: GetAuditEvents, ExportUser
+CREATE INDEX idx_audit_events_entity ON audit_events (entity_type, entity_id, id);
+-- Used by: GetAuditEvents
+CREATE INDEX idx_audit_events_created_at ON audit_events (created_at);
+
+-- The audit log is append-only
+CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
@@ -0,0 +1,10 @@
+-- Full-text search over task names (weight A) and descriptions (weight B).
+-- The simple configuration does not stem, so that Russian and English words
+-- are matched alike by prefix queries.
+ALTER TABLE tasks ADD COLUMN search tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', coalesce(name, '')), 'A') ||
+    setweight(to_tsvector('simple', coalesce(description, '')), 'B')
+) STORED;
+
+-- Used by: Search
+CREATE INDEX idx_tasks_search ON tasks USING GIN (search);
@@ -0,0 +1,2 @@
+-- Drop the enrichment_attempts table
+DROP TABLE IF EXISTS enrichment_attempts;
@@ -0,0 +1,14 @@
+-- Enrichment_attempts table, the state of the external enrichment of a user
+CREATE TABLE enrichment_attempts (
+    user_id INTEGER PRIMARY KEY,
+    status VARCHAR(20) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_retry_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES Users(id) ON DELETE CASCADE
+);
+
+-- Indexes for the enrichment_attempts table
+-- Used by: GetEnrichmentAttempts, CountEnrichmentAttempts
+CREATE INDEX idx_enrichment_attempts_status ON enrichment_attempts (status, updated_at);
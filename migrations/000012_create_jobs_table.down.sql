@@ -0,0 +1,2 @@
+-- Drop the jobs table
+DROP TABLE IF EXISTS jobs;
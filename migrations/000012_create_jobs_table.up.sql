@@ -0,0 +1,19 @@
+-- Jobs table, the durable queue of background work
+CREATE TABLE jobs (
+    id BIGSERIAL PRIMARY KEY,
+    kind VARCHAR(50) NOT NULL,
+    job_key TEXT,
+    payload JSONB NOT NULL DEFAULT '{}',
+    status VARCHAR(20) NOT NULL DEFAULT 'queued',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    run_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_until TIMESTAMP WITH TIME ZONE,
+    last_error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Indexes for the jobs table
+-- Used by: ClaimJobs
+CREATE INDEX idx_jobs_status_run_at ON jobs (status, run_at);
+-- A job with a key is queued once until it is done
+CREATE UNIQUE INDEX idx_jobs_pending_key ON jobs (job_key) WHERE status IN ('queued', 'running');
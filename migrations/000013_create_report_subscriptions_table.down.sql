@@ -0,0 +1,2 @@
+-- Drop the report_subscriptions table
+DROP TABLE IF EXISTS report_subscriptions;
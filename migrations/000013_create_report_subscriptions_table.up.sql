@@ -0,0 +1,13 @@
+-- Report_subscriptions table, the reports emailed on a schedule
+CREATE TABLE report_subscriptions (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    schedule VARCHAR(100) NOT NULL,
+    report_type VARCHAR(50) NOT NULL,
+    recipients TEXT[] NOT NULL,
+    format VARCHAR(20) NOT NULL,
+    timezone VARCHAR(50) NOT NULL DEFAULT 'UTC',
+    last_sent_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES Users(id) ON DELETE CASCADE
+);
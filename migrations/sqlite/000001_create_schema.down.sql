@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS audit_events;
+DROP TABLE IF EXISTS user_identities;
+DROP TABLE IF EXISTS user_recovery_codes;
+DROP TABLE IF EXISTS user_two_factor;
+DROP TABLE IF EXISTS user_tasks;
+DROP TABLE IF EXISTS tasks;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,126 @@
+-- The SQLite schema matches the PostgreSQL one after all of its migrations.
+-- Times are stored as text, timestamps in UTC with a fixed width so that they compare as strings.
+
+-- Users table
+CREATE TABLE users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    passport_bidx TEXT,
+    passport_enc TEXT,
+    surname_enc TEXT,
+    name_enc TEXT,
+    patronymic_enc TEXT,
+    address_enc TEXT,
+    default_end_time TEXT,
+    timezone TEXT,
+    password_hash TEXT,
+    last_checked_at TEXT,
+    role TEXT NOT NULL DEFAULT 'user',
+    erased_at TEXT
+);
+
+-- Indexes for the users table
+-- Used by: SaveUser, GetUser, UpdateUsersInfo, UpdateUser
+CREATE UNIQUE INDEX idx_users_passport_bidx ON users (passport_bidx);
+-- Used by: GetNonUpdateUsers, UpdateUsersInfo
+CREATE INDEX idx_users_last_checked ON users (last_checked_at);
+
+-- Tasks table
+CREATE TABLE tasks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    description TEXT,
+    created_at TEXT
+);
+
+-- User_tasks table
+CREATE TABLE user_tasks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    task_id INTEGER NOT NULL,
+    event_date TEXT,
+    start_time TEXT,
+    end_time TEXT,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (task_id) REFERENCES tasks(id)
+);
+
+-- Indexes for the user_tasks table
+-- Used by: StartTaskTracking, StopTaskTracking
+CREATE INDEX idx_user_tasks_user_task_date ON user_tasks (user_id, task_id, event_date);
+-- Used by: GetUserTaskSummary
+CREATE INDEX idx_user_tasks_user_date ON user_tasks (user_id, event_date);
+-- Used by: DeleteTask
+CREATE INDEX idx_user_tasks_task ON user_tasks (task_id);
+
+-- User_two_factor table
+CREATE TABLE user_two_factor (
+    user_id INTEGER PRIMARY KEY,
+    secret TEXT NOT NULL,
+    enabled INTEGER NOT NULL DEFAULT 0,
+    last_used_step INTEGER NOT NULL DEFAULT 0,
+    -- Wrong codes in a row, the verification is locked out until locked_until after too many of them
+    failed_attempts INTEGER NOT NULL DEFAULT 0,
+    locked_until TEXT,
+    created_at TEXT,
+    confirmed_at TEXT,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- User_recovery_codes table
+CREATE TABLE user_recovery_codes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    code_hash TEXT NOT NULL,
+    used_at TEXT,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE (user_id, code_hash)
+);
+
+-- User_identities table
+CREATE TABLE user_identities (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    email TEXT,
+    created_at TEXT,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE (issuer, subject)
+);
+
+-- Indexes for the user_identities table
+-- Used by: DeleteUser (cascade)
+CREATE INDEX idx_user_identities_user ON user_identities (user_id);
+
+-- Audit_events table
+CREATE TABLE audit_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    actor_id INTEGER,
+    action TEXT NOT NULL,
+    entity_type TEXT NOT NULL,
+    entity_id INTEGER NOT NULL,
+    before TEXT,
+    after TEXT,
+    request_id TEXT,
+    ip TEXT,
+    created_at TEXT NOT NULL
+);
+
+-- Indexes for the audit_events table
+-- Used by: GetAuditEvents, ExportUser
+CREATE INDEX idx_audit_events_actor ON audit_events (actor_id, id);
+-- Used by: GetAuditEvents, ExportUser
+CREATE INDEX idx_audit_events_entity ON audit_events (entity_type, entity_id, id);
+-- Used by: GetAuditEvents
+CREATE INDEX idx_audit_events_created_at ON audit_events (created_at);
+
+-- The audit log is append-only
+CREATE TRIGGER audit_events_no_update BEFORE UPDATE ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+
+CREATE TRIGGER audit_events_no_delete BEFORE DELETE ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
@@ -0,0 +1,12 @@
+-- Enrichment_attempts table, the state of the external enrichment of a user
+CREATE TABLE enrichment_attempts (
+    user_id INTEGER PRIMARY KEY,
+    status TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_retry_at TEXT,
+    updated_at TEXT NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_enrichment_attempts_status ON enrichment_attempts (status, updated_at);
@@ -0,0 +1 @@
+ALTER TABLE users DROP COLUMN enrichment_leased_until;
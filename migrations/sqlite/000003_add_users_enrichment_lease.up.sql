@@ -0,0 +1,2 @@
+-- Lease of a user claimed for enrichment, other instances skip the user until it expires
+ALTER TABLE users ADD COLUMN enrichment_leased_until TEXT;
@@ -0,0 +1,16 @@
+-- Jobs table, the durable queue of background work
+CREATE TABLE jobs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind TEXT NOT NULL,
+    job_key TEXT,
+    payload TEXT NOT NULL DEFAULT '{}',
+    status TEXT NOT NULL DEFAULT 'queued',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    run_at TEXT NOT NULL,
+    locked_until TEXT,
+    last_error TEXT,
+    created_at TEXT NOT NULL
+);
+
+CREATE INDEX idx_jobs_status_run_at ON jobs (status, run_at);
+CREATE UNIQUE INDEX idx_jobs_pending_key ON jobs (job_key) WHERE status IN ('queued', 'running');
@@ -0,0 +1,13 @@
+-- Report_subscriptions table, the reports emailed on a schedule
+CREATE TABLE report_subscriptions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    schedule TEXT NOT NULL,
+    report_type TEXT NOT NULL,
+    recipients TEXT NOT NULL,
+    format TEXT NOT NULL,
+    timezone TEXT NOT NULL DEFAULT 'UTC',
+    last_sent_at TEXT,
+    created_at TEXT NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);